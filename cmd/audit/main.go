@@ -0,0 +1,117 @@
+// Command audit reports which co-scheduled virt-launcher pods do not run on
+// the nodes of their Longhorn share-managers right now, as a table or as
+// JSON. It exits with status 1 if any pod is divergent (or, with
+// --fail-on-unknown, of unknown status) and 2 on error, so it can run from
+// CI or a CronJob. It reads the kubeconfig like kubectl does, or the
+// in-cluster config.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/audit"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/schedulerconfig"
+)
+
+// Exit statuses.
+const (
+	exitDivergent = 1
+	exitError     = 2
+)
+
+func main() {
+	var (
+		kubeconfig    = flag.String("kubeconfig", "", "Path to the kubeconfig. Defaults to $KUBECONFIG, ~/.kube/config or the in-cluster config.")
+		kubeContext   = flag.String("context", "", "Kubeconfig context to use.")
+		namespace     = flag.String("namespace", "", "Namespace of the pods to audit, or empty for all namespaces.")
+		podSelector   = flag.String("pod-selector", audit.DefaultPodSelector, "Label selector of the pods to audit.")
+		all           = flag.Bool("all", false, "Audit every selected pod, not only the ones opted in to co-scheduling.")
+		output        = flag.String("output", "table", "Output format: \"table\" or \"json\".")
+		failOnUnknown = flag.Bool("fail-on-unknown", false, "Also exit with status 1 if a pod's status is unknown, e.g. because its share-manager was not found.")
+		timeout       = flag.Duration("timeout", 5*time.Minute, "How long the audit may take.")
+		lhNamespace   = flag.String("longhorn-namespace", longhorn_cosched.LonghornNamespace, "Namespace Longhorn runs in.")
+		schedConfig   = flag.String("scheduler-config", "", "KubeSchedulerConfiguration file whose "+longhorn_cosched.Name+" args select the pods' co-scheduling mode. Defaults to the default args.")
+		schedProfile  = flag.String("scheduler-profile", "kubevirt-scheduler", "Scheduler name of the --scheduler-config profile whose args to use.")
+	)
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	write := audit.WriteTable
+	switch *output {
+	case "table":
+	case "json":
+		write = audit.WriteJSON
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --output %q, want \"table\" or \"json\"\n", *output)
+		os.Exit(exitError)
+	}
+	selector, err := labels.Parse(*podSelector)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --pod-selector: %v\n", err)
+		os.Exit(exitError)
+	}
+	config := audit.Config{
+		Namespace:   *namespace,
+		PodSelector: selector,
+		All:         *all,
+		Lookup:      longhorn_cosched.LookupConfig{LonghornNamespace: *lhNamespace},
+	}
+	if *schedConfig != "" {
+		args, err := schedulerconfig.PluginArgs(*schedConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reading --scheduler-config: %v\n", err)
+			os.Exit(exitError)
+		}
+		config.PluginArgs = args[*schedProfile]
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	report, err := run(ctx, *kubeconfig, *kubeContext, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit failed: %v\n", err)
+		os.Exit(exitError)
+	}
+	if err := write(os.Stdout, report); err != nil {
+		fmt.Fprintf(os.Stderr, "writing report: %v\n", err)
+		os.Exit(exitError)
+	}
+	if report.Divergent > 0 || (*failOnUnknown && report.Unknown > 0) {
+		os.Exit(exitDivergent)
+	}
+}
+
+// run audits the cluster of the kubeconfig.
+func run(ctx context.Context, kubeconfig, kubeContext string, config audit.Config) (*audit.Report, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	dynClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return audit.Audit(ctx, clientset, dynClient, config)
+}
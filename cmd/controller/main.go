@@ -0,0 +1,237 @@
+// Command controller copies the co-schedule annotation set on KubeVirt
+// VirtualMachineInstances, or on the VirtualMachines owning them, onto their
+// virt-launcher pods, which KubeVirt does not do. With --storage-gate it also
+// removes the storage-ready scheduling gate the webhook adds, once the pod's
+// Longhorn storage is ready or the gate timed out. With --deschedule it
+// evicts running VMs that ended up off their share-manager node, e.g. after
+// a share-manager failover, or live-migrates them with
+// --deschedule-action=migrate. With --cleanup it removes co-scheduling
+// decision annotations that went stale. Replicas elect a leader through a
+// Lease; only the leader patches, evicts and migrates.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/controller"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/schedulerconfig"
+)
+
+// options are the controller's command-line options.
+type options struct {
+	workers        int
+	resync         time.Duration
+	leaderElect    bool
+	leaseNamespace string
+	leaseName      string
+	metricsAddr    string
+
+	// gate, deschedule and cleanup are nil unless enabled.
+	gate       *controller.GateConfig
+	deschedule *controller.DescheduleConfig
+	cleanup    *controller.CleanupConfig
+}
+
+func main() {
+	var (
+		o              options
+		storageGate    = flag.Bool("storage-gate", false, "Remove the "+longhorn_cosched.StorageReadyGate+" scheduling gate the webhook's --storage-gate adds, once the pod's storage is ready.")
+		gateTimeout    = flag.Duration("storage-gate-timeout", controller.DefaultGateTimeout, "How long after its creation a pod stays gated at most, e.g. when Longhorn is not installed.")
+		gatePoll       = flag.Duration("storage-gate-poll-interval", controller.DefaultGatePollInterval, "How often the storage of a gated pod is checked.")
+		deschedule     = flag.Bool("deschedule", false, "Evict running hard-mode VMs whose node is no longer their share-manager node.")
+		interval       = flag.Duration("deschedule-interval", controller.DefaultDescheduleInterval, "How often running VMs are checked against their share-manager node.")
+		gracePeriod    = flag.Duration("deschedule-grace-period", controller.DefaultDescheduleGracePeriod, "How long a VM has to run off its share-manager node before it is evicted.")
+		maxUnavailable = flag.Int("deschedule-max-unavailable", 1, "How many evicted VMs may still be running, e.g. migrating, before no more are evicted.")
+		dryRun         = flag.Bool("deschedule-dry-run", false, "Only emit events on the VMs that would be evicted.")
+		action         = flag.String("deschedule-action", string(controller.DescheduleEvict), "How VMs are moved: \"evict\" their virt-launcher pods, or \"migrate\" them with VirtualMachineInstanceMigrations pinned to the share-manager node.")
+		maxMigrations  = flag.Int("deschedule-max-migrations-per-namespace", 1, "How many migrations created with --deschedule-action=migrate may be in flight in a namespace at once.")
+		cooldown       = flag.Duration("deschedule-migration-cooldown", controller.DefaultMigrationCooldown, "How long after a VM was migrated with --deschedule-action=migrate it is not migrated again.")
+		cleanup        = flag.Bool("cleanup", false, "Remove co-scheduling decision annotations from terminated and opted-out virt-launcher pods.")
+		cleanupEvery   = flag.Duration("cleanup-interval", controller.DefaultCleanupInterval, "How often virt-launcher pods are checked for stale decision annotations.")
+		cleanupQPS     = flag.Float64("cleanup-qps", controller.DefaultCleanupQPS, "How many pods per second the cleanup patches at most.")
+		cleanupBurst   = flag.Int("cleanup-burst", controller.DefaultCleanupBurst, "How many pods the cleanup patches at most in a burst.")
+		cleanupDryRun  = flag.Bool("cleanup-dry-run", false, "Only log the stale decision annotations that would be removed.")
+		schedConfig    = flag.String("scheduler-config", "", "KubeSchedulerConfiguration file whose "+longhorn_cosched.Name+" args select the VMs' co-scheduling mode for --deschedule. Defaults to the default args.")
+		schedProfile   = flag.String("scheduler-profile", "kubevirt-scheduler", "Scheduler name of the --scheduler-config profile whose args to use.")
+		namespace      = flag.String("longhorn-namespace", longhorn_cosched.LonghornNamespace, "Namespace Longhorn runs in.")
+	)
+	flag.IntVar(&o.workers, "workers", 2, "Number of VMIs reconciled concurrently.")
+	flag.DurationVar(&o.resync, "resync", 10*time.Minute, "Interval at which every VMI is reconciled again.")
+	flag.BoolVar(&o.leaderElect, "leader-elect", true, "Elect a leader among the replicas through a Lease before patching pods.")
+	flag.StringVar(&o.leaseNamespace, "leader-elect-namespace", "kube-system", "Namespace of the leader election Lease.")
+	flag.StringVar(&o.leaseName, "leader-elect-name", "kubevirt-scheduler-controller", "Name of the leader election Lease.")
+	flag.StringVar(&o.metricsAddr, "metrics-bind-address", ":8080", "Address /metrics is served on over HTTP, or empty to disable it.")
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	lookup := longhorn_cosched.LookupConfig{LonghornNamespace: *namespace}
+	if *storageGate {
+		o.gate = &controller.GateConfig{Timeout: *gateTimeout, PollInterval: *gatePoll, Lookup: lookup}
+	}
+	if *deschedule {
+		o.deschedule = &controller.DescheduleConfig{
+			Interval:       *interval,
+			GracePeriod:    *gracePeriod,
+			MaxUnavailable: *maxUnavailable,
+			DryRun:         *dryRun,
+			Lookup:         lookup,
+
+			Action:                    controller.DescheduleAction(*action),
+			MaxMigrationsPerNamespace: *maxMigrations,
+			MigrationCooldown:         *cooldown,
+		}
+		if *schedConfig != "" {
+			args, err := schedulerconfig.PluginArgs(*schedConfig)
+			if err != nil {
+				klog.ErrorS(err, "Controller: reading --scheduler-config")
+				os.Exit(1)
+			}
+			o.deschedule.PluginArgs = args[*schedProfile]
+		}
+	}
+	if *cleanup {
+		o.cleanup = &controller.CleanupConfig{
+			Interval: *cleanupEvery,
+			QPS:      float32(*cleanupQPS),
+			Burst:    *cleanupBurst,
+			DryRun:   *cleanupDryRun,
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, o); err != nil {
+		klog.ErrorS(err, "Controller: exiting")
+		os.Exit(1)
+	}
+}
+
+// run runs the annotation propagation controller, and the storage-gate
+// controller, the descheduler and the cleaner if enabled in o.
+func run(ctx context.Context, o options) error {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	dynClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, o.resync,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) { options.LabelSelector = controller.LauncherSelector }))
+	dynFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynClient, o.resync)
+	c, err := controller.NewController(clientset, factory.Core().V1().Pods(),
+		dynFactory.ForResource(controller.VMIResource), dynFactory.ForResource(controller.VMResource))
+	if err != nil {
+		return err
+	}
+	runners := []func(context.Context) error{
+		func(ctx context.Context) error { return c.Run(ctx, o.workers) },
+	}
+	broadcaster := events.NewBroadcaster(&events.EventSinkImpl{Interface: clientset.EventsV1()})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, "kubevirt-scheduler-controller")
+	if o.gate != nil {
+		gc, err := controller.NewGateController(clientset, dynClient, factory.Core().V1().Pods(), recorder, *o.gate)
+		if err != nil {
+			return err
+		}
+		runners = append(runners, func(ctx context.Context) error { return gc.Run(ctx, o.workers) })
+	}
+	if o.deschedule != nil {
+		d, err := controller.NewDescheduler(clientset, dynClient, factory.Core().V1().Pods(), recorder, *o.deschedule)
+		if err != nil {
+			return err
+		}
+		runners = append(runners, d.Run)
+	}
+	if o.cleanup != nil {
+		runners = append(runners, controller.NewCleaner(clientset, factory.Core().V1().Pods(), *o.cleanup).Run)
+	}
+	start := func(ctx context.Context) error {
+		factory.Start(ctx.Done())
+		dynFactory.Start(ctx.Done())
+		broadcaster.StartRecordingToSink(ctx.Done())
+		defer broadcaster.Shutdown()
+		errs := make(chan error, len(runners))
+		for _, run := range runners {
+			go func() { errs <- run(ctx) }()
+		}
+		var err error
+		for range runners {
+			err = errors.Join(err, <-errs)
+		}
+		return err
+	}
+
+	if o.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", legacyregistry.Handler())
+		server := &http.Server{Addr: o.metricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				klog.ErrorS(err, "Controller: error serving metrics", "address", o.metricsAddr)
+			}
+		}()
+		defer server.Close()
+	}
+	if !o.leaderElect {
+		return start(ctx)
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, o.leaseNamespace, o.leaseName,
+		clientset.CoreV1(), clientset.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return err
+	}
+	var runErr error
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Name:            o.leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				runErr = start(ctx)
+			},
+			OnStoppedLeading: func() {
+				klog.InfoS("Controller: stopped leading", "identity", identity)
+			},
+		},
+	})
+	if runErr == nil && ctx.Err() == nil {
+		// Restart to rejoin the election with fresh informers.
+		return errors.New("lost the leader election")
+	}
+	return runErr
+}
@@ -0,0 +1,139 @@
+// Command extender serves the LonghornCoSchedule plugin as a scheduler
+// extender, for clusters that cannot run the kubevirt-scheduler but can add
+// extenders to their default scheduler's KubeSchedulerConfiguration. It
+// answers the extender filter and prioritize verbs on /filter and /prioritize
+// exactly like the plugin's Filter and Score, over informer caches of the
+// cluster's nodes and pods. The plugin args are read from --config.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/extender"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/webhook"
+)
+
+// options are the extender's command-line options.
+type options struct {
+	addr        string
+	certFile    string
+	keyFile     string
+	configFile  string
+	resync      time.Duration
+	metricsAddr string
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.addr, "bind-address", ":8888", "Address the extender serves on.")
+	flag.StringVar(&o.certFile, "tls-cert-file", "", "Serving certificate, e.g. mounted from a kubernetes.io/tls Secret, for the scheduler's enableHTTPS. Reloaded when it changes. If empty, plain HTTP is served.")
+	flag.StringVar(&o.keyFile, "tls-private-key-file", "", "Private key of the serving certificate.")
+	flag.StringVar(&o.configFile, "config", "", "YAML file with the LonghornCoSchedule plugin args, as under pluginConfig in the scheduler's configuration. If empty, the defaults are used.")
+	flag.DurationVar(&o.resync, "resync", 10*time.Minute, "Resync interval of the node and pod caches.")
+	flag.StringVar(&o.metricsAddr, "metrics-bind-address", ":8080", "Address /metrics is served on over HTTP, or empty to disable it.")
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, o); err != nil {
+		klog.ErrorS(err, "Extender: exiting")
+		os.Exit(1)
+	}
+}
+
+// run serves the extender until ctx is done.
+func run(ctx context.Context, o options) error {
+	var args runtime.Object
+	if o.configFile != "" {
+		data, err := os.ReadFile(o.configFile)
+		if err != nil {
+			return err
+		}
+		args = &runtime.Unknown{Raw: data, ContentType: runtime.ContentTypeYAML}
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	dynClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(clientset, o.resync)
+	broadcaster := events.NewBroadcaster(&events.EventSinkImpl{Interface: clientset.EventsV1()})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, extender.SchedulerName)
+	e, err := extender.New(ctx, clientset, dynClient, factory, recorder, args)
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	broadcaster.StartRecordingToSink(ctx.Done())
+	defer broadcaster.Shutdown()
+
+	var ready func() error
+	server := &http.Server{Addr: o.addr, ReadHeaderTimeout: 10 * time.Second}
+	if o.certFile != "" {
+		certs, err := webhook.NewCertReloader(o.certFile, o.keyFile)
+		if err != nil {
+			return err
+		}
+		ready = certs.Ready
+		server.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+	}
+	server.Handler = e.Handler(ready)
+
+	if o.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", legacyregistry.Handler())
+		metricsServer := &http.Server{Addr: o.metricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				klog.ErrorS(err, "Extender: error serving metrics", "address", o.metricsAddr)
+			}
+		}()
+		defer metricsServer.Close()
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			klog.ErrorS(err, "Extender: error shutting down")
+		}
+	}()
+
+	klog.InfoS("Extender: serving", "address", o.addr, "tls", o.certFile != "", "config", o.configFile)
+	if o.certFile != "" {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/simulate"
+)
+
+// newExplainCommand returns the explain subcommand, which simulates the
+// plugin for a pod.
+func newExplainCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain (NAME | TYPE/NAME)",
+		Short: "Show what the co-scheduling plugin makes of a pod on each node",
+		Long: `Run the LonghornCoSchedule plugin's PreFilter, Filter and Score, with the
+plugin args of --scheduler-config or the defaults, for a pod (NAME or pod/NAME) or for the virt-launcher
+pods of a VirtualMachine (vm/NAME or vmi/NAME), like the scheduler's simulate
+subcommand: each node's verdict and score, and the plugin's decision. The pod
+is simulated as if it were pending; nothing is written to the cluster.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, namespace, err := o.complete()
+			if err != nil {
+				return err
+			}
+			pods, err := targetPods(cmd.Context(), c, namespace, args[0], podResource)
+			if err != nil {
+				return err
+			}
+			pluginArgs, err := o.pluginArgs()
+			if err != nil {
+				return err
+			}
+			results := make([]*simulate.Result, 0, len(pods))
+			for _, pod := range pods {
+				result, err := simulate.Run(cmd.Context(), c.clientset, c.dynClient, pod, pluginArgs)
+				if err != nil {
+					return fmt.Errorf("simulating %s/%s: %w", pod.Namespace, pod.Name, err)
+				}
+				results = append(results, result)
+			}
+			if o.output == "json" {
+				return simulate.WriteJSON(o.streams.Out, results)
+			}
+			for i, result := range results {
+				if i > 0 {
+					fmt.Fprintln(o.streams.Out)
+				}
+				if err := simulate.WriteTable(o.streams.Out, result); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
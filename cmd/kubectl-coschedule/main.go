@@ -0,0 +1,23 @@
+// Command kubectl-coschedule is a kubectl plugin for looking into the
+// co-scheduling of KubeVirt VMs with their Longhorn share-managers:
+//
+//	kubectl coschedule status <vm|pod>   opt-in, share-manager node, current node
+//	kubectl coschedule explain <pod>     per-node Filter verdicts and scores
+//
+// It takes kubectl's kubeconfig, context and namespace flags, and only reads
+// from the cluster.
+package main
+
+import (
+	"os"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func main() {
+	streams := genericiooptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
+	if err := newRootCommand(streams, genericclioptions.NewConfigFlags(true), restClients).Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/restmapper"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/audit"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	testNamespace = "vms"
+	testPVName    = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+)
+
+// makeLauncher returns the running virt-launcher pod of the VM on the node,
+// opted in to hard mode and mounting the RWX PVC "shared".
+func makeLauncher(vm, node string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "virt-launcher-" + vm,
+			Namespace:   testNamespace,
+			Labels:      map[string]string{"kubevirt.io": "virt-launcher", longhorn_cosched.VMNameLabel: vm, createdByLabel: vm + "-uid"},
+			Annotations: map[string]string{longhorn_cosched.AnnotationKey: "true"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Volumes: []corev1.Volume{{
+				Name:         "disk",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "shared"}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+// newTestClients returns fake clients holding three nodes, the PVC "shared"
+// with its share-manager running on node-2, the VMs vm-a on node-1 and vm-b
+// on node-2, and the VMI of vm-a, with a REST mapper that knows the pod, VM
+// and VMI resources and their short names.
+func newTestClients() *clients {
+	objects := []runtime.Object{
+		makeLauncher("vm-a", "node-1"),
+		makeLauncher("vm-b", "node-2"),
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: testNamespace},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+				VolumeName:  testPVName,
+			},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		},
+	}
+	for _, name := range []string{"node-1", "node-2", "node-3"} {
+		objects = append(objects, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{{Name: "pods", Namespaced: true, Kind: "Pod", ShortNames: []string{"po"}}},
+		},
+		{
+			GroupVersion: "kubevirt.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "virtualmachines", Namespaced: true, Kind: "VirtualMachine", ShortNames: []string{"vm", "vms"}},
+				{Name: "virtualmachineinstances", Namespaced: true, Kind: "VirtualMachineInstance", ShortNames: []string{"vmi", "vmis"}},
+			},
+		},
+		{
+			GroupVersion: "longhorn.io/v1beta2",
+			APIResources: []metav1.APIResource{{Name: "sharemanagers", Namespaced: true, Kind: "ShareManager"}},
+		},
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, kind := range []schema.GroupVersionKind{
+		corev1.SchemeGroupVersion.WithKind("Pod"),
+		{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachine"},
+		{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstance"},
+	} {
+		mapper.Add(kind, meta.RESTScopeNamespace)
+	}
+
+	shareManager := &unstructured.Unstructured{}
+	shareManager.SetAPIVersion("longhorn.io/v1beta2")
+	shareManager.SetKind("ShareManager")
+	shareManager.SetNamespace(longhorn_cosched.LonghornNamespace)
+	shareManager.SetName(testPVName)
+	shareManager.Object["status"] = map[string]interface{}{"ownerID": "node-2", "state": "running"}
+	vmi := &unstructured.Unstructured{}
+	vmi.SetAPIVersion("kubevirt.io/v1")
+	vmi.SetKind("VirtualMachineInstance")
+	vmi.SetNamespace(testNamespace)
+	vmi.SetName("vm-a")
+	vmi.SetUID("vm-a-uid")
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "sharemanagers"}:      "ShareManagerList",
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "replicas"}:           "ReplicaList",
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "volumes"}:            "VolumeList",
+		{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"}: "VirtualMachineInstanceList",
+	}, shareManager, vmi)
+
+	return &clients{
+		clientset: clientset,
+		dynClient: dynClient,
+		mapper:    restmapper.NewShortcutExpander(mapper, clientset.Discovery(), nil),
+	}
+}
+
+// run runs the plugin with the arguments against the test clients and
+// returns its output, with runs of spaces collapsed to one.
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	// Keep the kubeconfig of whoever runs the tests out of them.
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "config"))
+	streams, _, out, _ := genericiooptions.NewTestIOStreams()
+	cmd := newRootCommand(streams, genericclioptions.NewConfigFlags(false), func(*genericclioptions.ConfigFlags) (*clients, error) {
+		return newTestClients(), nil
+	})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	lines := strings.Split(out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n"), err
+}
+
+// TestStatus checks the status of VMs and pods given in each form.
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// want are lines of the output, with runs of spaces collapsed.
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "VM by name",
+			args: []string{"status", "-n", testNamespace, "vm-a"},
+			want: []string{
+				"NAMESPACE POD MODE NODE PVC SHARE-MANAGER NODE STATUS",
+				"vms virt-launcher-vm-a hard node-1 shared node-2 divergent",
+				"0 colocated, 1 divergent, 0 unknown",
+			},
+		},
+		{
+			name: "VM by short name",
+			args: []string{"status", "--namespace", testNamespace, "vm/vm-b"},
+			want: []string{"vms virt-launcher-vm-b hard node-2 shared node-2 colocated"},
+		},
+		{
+			name: "VMI",
+			args: []string{"status", "-n", testNamespace, "virtualmachineinstance/vm-a"},
+			want: []string{"vms virt-launcher-vm-a hard node-1 shared node-2 divergent"},
+		},
+		{
+			name: "pod",
+			args: []string{"status", "-n", testNamespace, "po/virt-launcher-vm-b"},
+			want: []string{"vms virt-launcher-vm-b hard node-2 shared node-2 colocated"},
+		},
+		{
+			name:    "namespace defaults to the kubeconfig's",
+			args:    []string{"status", "vm-a"},
+			wantErr: "virtualmachines.kubevirt.io default/vm-a has no virt-launcher pod",
+		},
+		{
+			name:    "VMI not found",
+			args:    []string{"status", "-n", testNamespace, "vmi/vm-c"},
+			wantErr: `"vm-c" not found`,
+		},
+		{
+			name:    "other resource",
+			args:    []string{"status", "-n", testNamespace, "sharemanagers/" + testPVName},
+			wantErr: "resolving",
+		},
+		{
+			name:    "unknown output",
+			args:    []string{"status", "-n", testNamespace, "-o", "yaml", "vm-a"},
+			wantErr: `unknown --output "yaml"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := run(t, tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want+"\n") {
+					t.Errorf("output does not contain %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+// TestStatusJSON checks that -o json prints the audit report.
+func TestStatusJSON(t *testing.T) {
+	out, err := run(t, "status", "-n", testNamespace, "-o", "json", "vm-a")
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	var report audit.Report
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("decoding %s: %v", out, err)
+	}
+	want := audit.Result{Namespace: testNamespace, Pod: "virt-launcher-vm-a", Mode: "hard", Node: "node-1", PVC: "shared", PV: testPVName, ShareManagerNode: "node-2", Status: audit.StatusDivergent}
+	if len(report.Results) != 1 || report.Results[0] != want || report.Divergent != 1 {
+		t.Errorf("report = %+v, want the single result %+v", report, want)
+	}
+}
+
+// TestExplain checks the per-node verdicts of a pod and of a VM.
+func TestExplain(t *testing.T) {
+	for _, target := range []string{"virt-launcher-vm-a", "vm/vm-a"} {
+		t.Run(target, func(t *testing.T) {
+			out, err := run(t, "explain", "-n", testNamespace, target)
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			for _, want := range []string{
+				"Pod: vms/virt-launcher-vm-a",
+				"Outcome: pinned",
+				"Share-manager node: node-2",
+				"NODE FILTER SCORE REASON",
+				`node-1 fail - node "node-1" rejected: VM must run on "node-2"`,
+				"node-2 pass 100 -",
+			} {
+				if !strings.Contains(out, want) {
+					t.Errorf("output does not contain %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+// TestExplainJSON checks that -o json prints the verdicts and the decision.
+func TestExplainJSON(t *testing.T) {
+	out, err := run(t, "explain", "-n", testNamespace, "-o", "json", "virt-launcher-vm-b")
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	var results []struct {
+		Namespace string `json:"namespace"`
+		Pod       string `json:"pod"`
+		Decision  longhorn_cosched.Decision
+		Nodes     []struct {
+			Node    string `json:"node"`
+			Verdict string `json:"verdict"`
+			Score   int64  `json:"score"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("decoding %s: %v", out, err)
+	}
+	if len(results) != 1 || results[0].Pod != "virt-launcher-vm-b" || results[0].Decision.ShareManagerNode != "node-2" {
+		t.Fatalf("results = %+v, want the pinned virt-launcher-vm-b", results)
+	}
+	if n := results[0].Nodes; len(n) != 3 || n[1].Node != "node-2" || n[1].Verdict != "pass" || n[1].Score != 100 || n[0].Verdict != "fail" {
+		t.Errorf("nodes = %+v, want only node-2 to pass, with 100", n)
+	}
+}
+
+// TestSchedulerConfig checks that status and explain apply the plugin args
+// of the --scheduler-config profile.
+func TestSchedulerConfig(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "scheduler-config.yaml")
+	if err := os.WriteFile(config, []byte(`apiVersion: kubescheduler.config.k8s.io/v1
+kind: KubeSchedulerConfiguration
+profiles:
+  - schedulerName: kubevirt-scheduler
+    pluginConfig:
+      - name: LonghornCoSchedule
+        args:
+          observeOnly: true
+`), 0o600); err != nil {
+		t.Fatalf("writing scheduler config: %v", err)
+	}
+
+	out, err := run(t, "status", "-n", testNamespace, "--scheduler-config", config, "vm-a")
+	if err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	if want := "vms virt-launcher-vm-a observe node-1 shared node-2 divergent"; !strings.Contains(out, want+"\n") {
+		t.Errorf("status output does not contain %q:\n%s", want, out)
+	}
+
+	out, err = run(t, "explain", "-n", testNamespace, "--scheduler-config", config, "virt-launcher-vm-a")
+	if err != nil {
+		t.Fatalf("explain error = %v", err)
+	}
+	if !strings.Contains(out, "node-1 pass") {
+		t.Errorf("explain output should let node-1 pass in observe mode:\n%s", out)
+	}
+
+	if _, err := run(t, "status", "-n", testNamespace, "--scheduler-config", filepath.Join(t.TempDir(), "missing.yaml"), "vm-a"); err == nil || !strings.Contains(err.Error(), "--scheduler-config") {
+		t.Errorf("error = %v, want it to name --scheduler-config", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/schedulerconfig"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/simulate"
+)
+
+// clients are what the subcommands read the cluster with.
+type clients struct {
+	clientset kubernetes.Interface
+	dynClient dynamic.Interface
+	mapper    meta.RESTMapper
+}
+
+// newClientsFunc returns the clients for the kubeconfig flags.
+type newClientsFunc func(*genericclioptions.ConfigFlags) (*clients, error)
+
+// options are shared by the subcommands.
+type options struct {
+	configFlags *genericclioptions.ConfigFlags
+	streams     genericiooptions.IOStreams
+	newClients  newClientsFunc
+	output      string
+
+	// schedulerConfig and schedulerProfile select the plugin args.
+	schedulerConfig  string
+	schedulerProfile string
+}
+
+// complete returns the clients and the namespace of the command's target.
+func (o *options) complete() (*clients, string, error) {
+	switch o.output {
+	case "table", "json":
+	default:
+		return nil, "", fmt.Errorf("unknown --output %q, want \"table\" or \"json\"", o.output)
+	}
+	namespace, _, err := o.configFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := o.newClients(o.configFlags)
+	if err != nil {
+		return nil, "", err
+	}
+	return c, namespace, nil
+}
+
+// pluginArgs returns the plugin args of --scheduler-profile in
+// --scheduler-config, or nil for the defaults.
+func (o *options) pluginArgs() (runtime.Object, error) {
+	if o.schedulerConfig == "" {
+		return nil, nil
+	}
+	args, err := schedulerconfig.PluginArgs(o.schedulerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("reading --scheduler-config: %w", err)
+	}
+	return args[o.schedulerProfile], nil
+}
+
+// newRootCommand returns the coschedule command with its subcommands.
+func newRootCommand(streams genericiooptions.IOStreams, configFlags *genericclioptions.ConfigFlags, newClients newClientsFunc) *cobra.Command {
+	o := &options{configFlags: configFlags, streams: streams, newClients: newClients}
+	cmd := &cobra.Command{
+		Use:   "kubectl coschedule",
+		Short: "Look into the co-scheduling of KubeVirt VMs with their Longhorn share-managers",
+		// Errors are printed by cobra, and usage only for bad invocations.
+		SilenceUsage: true,
+	}
+	cmd.SetOut(streams.Out)
+	cmd.SetErr(streams.ErrOut)
+	configFlags.AddFlags(cmd.PersistentFlags())
+	cmd.PersistentFlags().StringVarP(&o.output, "output", "o", "table", "Output format: \"table\" or \"json\".")
+	cmd.PersistentFlags().StringVar(&o.schedulerConfig, "scheduler-config", "", "KubeSchedulerConfiguration file whose "+longhorn_cosched.Name+" args to apply. Defaults to the default args.")
+	cmd.PersistentFlags().StringVar(&o.schedulerProfile, "scheduler-profile", "kubevirt-scheduler", "Scheduler name of the --scheduler-config profile whose args to use.")
+	cmd.AddCommand(newStatusCommand(o), newExplainCommand(o))
+	return cmd
+}
+
+// restClients returns clients for the kubeconfig flags that refuse to write.
+func restClients(configFlags *genericclioptions.ConfigFlags) (*clients, error) {
+	restConfig, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Wrap(simulate.ReadOnly)
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	dynClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	mapper, err := configFlags.ToRESTMapper()
+	if err != nil {
+		return nil, err
+	}
+	return &clients{clientset: clientset, dynClient: dynClient, mapper: mapper}, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/audit"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// newStatusCommand returns the status subcommand, which audits the pods of a
+// VM or a pod.
+func newStatusCommand(o *options) *cobra.Command {
+	var longhornNamespace string
+	cmd := &cobra.Command{
+		Use:   "status (NAME | TYPE/NAME)",
+		Short: "Show whether a VM runs on the node of its share-managers",
+		Long: `Show, for each virt-launcher pod of a VirtualMachine (NAME, vm/NAME or
+vmi/NAME) or for a pod (pod/NAME), its co-scheduling mode, the node it runs on
+and, per Longhorn RWX PVC, the node of the share-manager, and whether the two
+are the same. The mode is selected as the scheduler does, through the pod's
+annotations, its VMI, its volumes and its namespace, under the plugin args of
+--scheduler-config. Share-manager nodes the pod is only preferred on are left
+out.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, namespace, err := o.complete()
+			if err != nil {
+				return err
+			}
+			pods, err := targetPods(cmd.Context(), c, namespace, args[0], vmResource)
+			if err != nil {
+				return err
+			}
+			pluginArgs, err := o.pluginArgs()
+			if err != nil {
+				return err
+			}
+			modes, err := longhorn_cosched.NewModeResolver(c.clientset, c.dynClient, pluginArgs)
+			if err != nil {
+				return err
+			}
+			report := audit.AuditPods(cmd.Context(), c.clientset, c.dynClient, pods, modes, longhorn_cosched.LookupConfig{LonghornNamespace: longhornNamespace})
+			if o.output == "json" {
+				return audit.WriteJSON(o.streams.Out, report)
+			}
+			return audit.WriteTable(o.streams.Out, report)
+		},
+	}
+	cmd.Flags().StringVar(&longhornNamespace, "longhorn-namespace", longhorn_cosched.LonghornNamespace, "Namespace Longhorn runs in.")
+	return cmd
+}
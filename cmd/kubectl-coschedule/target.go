@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/audit"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// createdByLabel is the KubeVirt label holding the UID of the
+// VirtualMachineInstance on its virt-launcher pods.
+const createdByLabel = "kubevirt.io/created-by"
+
+var (
+	podResource = schema.GroupResource{Resource: "pods"}
+	vmResource  = schema.GroupResource{Group: "kubevirt.io", Resource: "virtualmachines"}
+	vmiResource = schema.GroupResource{Group: "kubevirt.io", Resource: "virtualmachineinstances"}
+)
+
+// targetPods returns the pods of the target, TYPE/NAME or the NAME of a
+// defaultResource, in the namespace. TYPE is anything kubectl accepts for
+// pods, VirtualMachines or VirtualMachineInstances, e.g. "pod", "vm" or
+// "virtualmachineinstances.kubevirt.io". A VM or VMI yields its virt-launcher
+// pods that have not terminated: one, or two while it migrates.
+func targetPods(ctx context.Context, c *clients, namespace, target string, defaultResource schema.GroupResource) ([]*corev1.Pod, error) {
+	resource, name := defaultResource, target
+	if typ, n, ok := strings.Cut(target, "/"); ok {
+		gvr, err := c.mapper.ResourceFor(schema.ParseGroupResource(typ).WithVersion(""))
+		if err != nil {
+			return nil, fmt.Errorf("resolving %q: %w", typ, err)
+		}
+		resource, name = gvr.GroupResource(), n
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no name given in %q", target)
+	}
+
+	var selector labels.Set
+	switch resource {
+	case podResource:
+		pod, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []*corev1.Pod{pod}, nil
+	case vmResource:
+		selector = labels.Set{longhorn_cosched.VMNameLabel: name}
+	case vmiResource:
+		gvr, err := c.mapper.ResourceFor(resource.WithVersion(""))
+		if err != nil {
+			return nil, err
+		}
+		vmi, err := c.dynClient.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = labels.Set{createdByLabel: string(vmi.GetUID())}
+	default:
+		return nil, fmt.Errorf("%s is not a pod, VirtualMachine or VirtualMachineInstance", resource)
+	}
+
+	launchers, err := labels.Parse(audit.DefaultPodSelector)
+	if err != nil {
+		return nil, err
+	}
+	requirements, _ := selector.AsSelector().Requirements()
+	list, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: launchers.Add(requirements...).String()})
+	if err != nil {
+		return nil, err
+	}
+	var pods []*corev1.Pod
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			pods = append(pods, pod)
+		}
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("%s %s/%s has no virt-launcher pod; is it running?", resource, namespace, name)
+	}
+	return pods, nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// restConfig returns the client config of the subcommands: the kubeconfig
+// loaded like kubectl does, or the in-cluster config, optionally
+// impersonating a user.
+func restConfig(kubeconfig, kubeContext, impersonate string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	overrides.AuthInfo.Impersonate = impersonate
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
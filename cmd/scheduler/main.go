@@ -2,7 +2,13 @@
 // VM pods with their Longhorn RWX share-manager pods on the same node.
 //
 // It embeds the default kube-scheduler and registers the LonghornCoSchedule
-// plugin as an additional Filter and Score plugin.
+// plugin as an additional Filter and Score plugin. Profiles may also enable
+// the LonghornReplicaLocality plugin, to place pods next to the replicas of
+// their RWO Longhorn volumes, and the VMSpread plugin, to keep the VMs of a
+// spread group on different nodes. The preflight subcommand checks that a
+// cluster is ready for it, the simulate subcommand shows what the plugin
+// makes of a pod without scheduling it, and the print-config subcommand
+// writes a configuration for it.
 package main
 
 import (
@@ -19,7 +25,10 @@ import (
 func main() {
 	command := app.NewSchedulerCommand(
 		app.WithPlugin(longhorn_cosched.Name, longhorn_cosched.New),
+		app.WithPlugin(longhorn_cosched.ReplicaLocalityName, longhorn_cosched.NewReplicaLocality),
+		app.WithPlugin(longhorn_cosched.VMSpreadName, longhorn_cosched.NewVMSpread),
 	)
+	command.AddCommand(newPreflightCommand(), newSimulateCommand(), newPrintConfigCommand())
 
 	code := cli.Run(command)
 	os.Exit(code)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/preflight"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/schedulerconfig"
+)
+
+// newPreflightCommand returns the preflight subcommand, which checks that the
+// cluster is ready for the scheduler and exits with status 1 if it is not.
+func newPreflightCommand() *cobra.Command {
+	var (
+		kubeconfig, kubeContext, impersonate string
+		configFile, namespace                string
+	)
+	cmd := &cobra.Command{
+		Use:   "preflight",
+		Short: "Check the ShareManager CRD, the scheduler's RBAC, Longhorn and the plugin args",
+		Long: `Check that the ShareManager CRD is served, that the scheduler may read
+PVCs, PVs, StorageClasses, the pods in the Longhorn namespace and
+ShareManagers, that longhorn-manager runs in the Longhorn namespace, and that
+the LonghornCoSchedule args in --config are valid. Access is checked for the
+user running the command: run it as the scheduler's ServiceAccount, or
+impersonate it with --as.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			config := preflight.Config{LonghornNamespace: namespace}
+			if configFile != "" {
+				args, err := schedulerconfig.PluginArgs(configFile)
+				if err != nil {
+					return err
+				}
+				config.Args = args
+			}
+			restConfig, err := restConfig(kubeconfig, kubeContext, impersonate)
+			if err != nil {
+				return err
+			}
+			clientset, err := kubernetes.NewForConfig(restConfig)
+			if err != nil {
+				return err
+			}
+
+			results := preflight.Run(cmd.Context(), clientset, config)
+			if err := preflight.WriteReport(cmd.OutOrStdout(), results); err != nil {
+				return err
+			}
+			if preflight.Failed(results) {
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+	// The scheduler command's usage and help list its own flag sets, which
+	// the subcommand would inherit; use cobra's defaults instead.
+	defaults := &cobra.Command{}
+	cmd.SetUsageFunc(defaults.UsageFunc())
+	cmd.SetHelpFunc(defaults.HelpFunc())
+
+	flags := cmd.Flags()
+	flags.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig. Defaults to $KUBECONFIG, ~/.kube/config or the in-cluster config.")
+	flags.StringVar(&kubeContext, "context", "", "Kubeconfig context to use.")
+	flags.StringVar(&impersonate, "as", "", "User to impersonate, e.g. system:serviceaccount:kube-system:kubevirt-scheduler.")
+	flags.StringVar(&configFile, "config", "", "KubeSchedulerConfiguration file whose "+longhorn_cosched.Name+" args to validate.")
+	flags.StringVar(&namespace, "longhorn-namespace", longhorn_cosched.LonghornNamespace, "Namespace Longhorn runs in.")
+	return cmd
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/sampleconfig"
+)
+
+// newPrintConfigCommand returns the print-config subcommand, which writes a
+// KubeSchedulerConfiguration for the scheduler to stdout.
+func newPrintConfigCommand() *cobra.Command {
+	var (
+		mode, schedulerName string
+		argsFile            string
+	)
+	cmd := &cobra.Command{
+		Use:   "print-config",
+		Short: "Print a KubeSchedulerConfiguration enabling the " + longhorn_cosched.Name + " plugin",
+		Long: `Print a complete KubeSchedulerConfiguration for the scheduler, with the
+LonghornCoSchedule plugin enabled at all its extension points, for a mode:
+
+  hard           one profile; pods are co-scheduled in the mode their
+                 annotations ask for
+  soft           one profile co-scheduling every opted-in pod in soft mode
+  multi-profile  both, the soft profile named after --scheduler-name with
+                 "-soft" appended
+
+Plugin args in --args are merged over the mode's. The configuration is
+decoded and validated as the scheduler would before it is printed, and the
+args strictly, so a misspelled field fails.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			config := sampleconfig.Config{Mode: sampleconfig.Mode(mode), SchedulerName: schedulerName}
+			if argsFile != "" {
+				args, err := os.ReadFile(argsFile)
+				if err != nil {
+					return err
+				}
+				config.Args = args
+			}
+			data, err := sampleconfig.Generate(config)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+	// The scheduler command's usage and help list its own flag sets, which
+	// the subcommand would inherit; use cobra's defaults instead.
+	defaults := &cobra.Command{}
+	cmd.SetUsageFunc(defaults.UsageFunc())
+	cmd.SetHelpFunc(defaults.HelpFunc())
+
+	flags := cmd.Flags()
+	flags.StringVar(&mode, "mode", string(sampleconfig.ModeHard), fmt.Sprintf("Profiles to write, one of %v.", sampleconfig.Modes))
+	flags.StringVar(&schedulerName, "scheduler-name", sampleconfig.DefaultSchedulerName, "Scheduler name of the profile, and name of the leader election lease.")
+	flags.StringVar(&argsFile, "args", "", "YAML or JSON file of "+longhorn_cosched.Name+" args to merge over the mode's.")
+	return cmd
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/schedulerconfig"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/simulate"
+)
+
+// newSimulateCommand returns the simulate subcommand, which runs the
+// LonghornCoSchedule plugin for a pod against the cluster without binding
+// it.
+func newSimulateCommand() *cobra.Command {
+	var (
+		kubeconfig, kubeContext string
+		filename, podRef        string
+		namespace               string
+		configFile, profile     string
+	)
+	cmd := &cobra.Command{
+		Use:   "simulate (-f FILE | --pod NAMESPACE/NAME)",
+		Short: "Show what the " + longhorn_cosched.Name + " plugin makes of a pod on each node",
+		Long: `Run the LonghornCoSchedule plugin's PreFilter, Filter and Score for a pod
+against the cluster's current nodes, pods, PVCs and share-managers, and print
+each node's Filter verdict and score along with the plugin's decision. The
+pod is read from a manifest (-f) or from the cluster (--pod); an existing pod
+is simulated as if it were pending. Nothing is written to the cluster and
+nothing is bound.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if (filename == "") == (podRef == "") {
+				return fmt.Errorf("exactly one of --filename and --pod is required")
+			}
+			var args runtime.Object
+			if configFile != "" {
+				profileArgs, err := schedulerconfig.PluginArgs(configFile)
+				if err != nil {
+					return err
+				}
+				args = profileArgs[profile]
+			}
+			restConfig, err := restConfig(kubeconfig, kubeContext, "")
+			if err != nil {
+				return err
+			}
+			// The plugin only reads, but nothing else may be sent either.
+			restConfig.Wrap(simulate.ReadOnly)
+			clientset, err := kubernetes.NewForConfig(restConfig)
+			if err != nil {
+				return err
+			}
+			dynClient, err := dynamic.NewForConfig(restConfig)
+			if err != nil {
+				return err
+			}
+
+			pod := &corev1.Pod{}
+			if filename != "" {
+				data, err := os.ReadFile(filename)
+				if err != nil {
+					return err
+				}
+				if err := yaml.Unmarshal(data, pod); err != nil {
+					return fmt.Errorf("decoding %s: %w", filename, err)
+				}
+				if pod.Namespace == "" {
+					pod.Namespace = namespace
+				}
+			} else {
+				podNamespace, name, ok := strings.Cut(podRef, "/")
+				if !ok {
+					podNamespace, name = namespace, podRef
+				}
+				if pod, err = clientset.CoreV1().Pods(podNamespace).Get(cmd.Context(), name, metav1.GetOptions{}); err != nil {
+					return err
+				}
+			}
+
+			result, err := simulate.Run(cmd.Context(), clientset, dynClient, pod, args)
+			if err != nil {
+				return err
+			}
+			return simulate.WriteTable(cmd.OutOrStdout(), result)
+		},
+	}
+	// The scheduler command's usage and help list its own flag sets, which
+	// the subcommand would inherit; use cobra's defaults instead.
+	defaults := &cobra.Command{}
+	cmd.SetUsageFunc(defaults.UsageFunc())
+	cmd.SetHelpFunc(defaults.HelpFunc())
+
+	flags := cmd.Flags()
+	flags.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig. Defaults to $KUBECONFIG, ~/.kube/config or the in-cluster config.")
+	flags.StringVar(&kubeContext, "context", "", "Kubeconfig context to use.")
+	flags.StringVarP(&filename, "filename", "f", "", "Manifest of the pod to simulate.")
+	flags.StringVar(&podRef, "pod", "", "Existing pod to simulate, as NAMESPACE/NAME or NAME.")
+	flags.StringVarP(&namespace, "namespace", "n", metav1.NamespaceDefault, "Namespace of the pod if neither the manifest nor --pod sets one.")
+	flags.StringVar(&configFile, "config", "", "KubeSchedulerConfiguration file to take the "+longhorn_cosched.Name+" args from. Defaults to the default args.")
+	flags.StringVar(&profile, "profile", "kubevirt-scheduler", "Scheduler name of the --config profile whose args to use.")
+	return cmd
+}
@@ -0,0 +1,125 @@
+// Command webhook serves a mutating admission webhook that opts virt-launcher
+// pods mounting Longhorn RWX volumes in to co-scheduling with their
+// share-managers: it sets the co-schedule annotation and the scheduler name,
+// so VM authors do not have to annotate every VM template. With
+// --mode=required-affinity or preferred-affinity it injects node affinity for
+// the share-manager node instead, for clusters that cannot run the scheduler.
+// With --storage-gate it also adds a scheduling gate, for the controller to
+// remove once the pod's storage is ready. It also serves a validating
+// webhook, on /validate, that rejects or warns about invalid co-scheduling
+// annotation values.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/schedulerconfig"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/webhook"
+)
+
+func main() {
+	var (
+		addr          = flag.String("bind-address", ":8443", "Address the webhook serves HTTPS on.")
+		certFile      = flag.String("tls-cert-file", "/etc/webhook/certs/tls.crt", "Serving certificate, e.g. mounted from a kubernetes.io/tls Secret. Reloaded when it changes.")
+		keyFile       = flag.String("tls-private-key-file", "/etc/webhook/certs/tls.key", "Private key of the serving certificate.")
+		podSelector   = flag.String("pod-selector", webhook.DefaultPodSelector, "Label selector of the pods to opt in.")
+		schedulerName = flag.String("scheduler-name", webhook.DefaultSchedulerName, "Scheduler name set on pods that do not name one.")
+		value         = flag.String("co-schedule-value", "", "Value of the co-schedule annotation injected, e.g. \"soft\" or \"observe\". Defaults to \"true\" (hard mode).")
+		mode          = flag.String("mode", string(webhook.ModeAnnotate), "How pods are opted in: \"annotate\" for the scheduler, or \"required-affinity\" or \"preferred-affinity\" to inject node affinity for the share-manager node.")
+		invalid       = flag.String("invalid-annotations", string(webhook.InvalidAnnotationsReject), "What /validate does with pods and VirtualMachines whose plugin annotations have invalid values: \"reject\" or \"warn\".")
+		namespace     = flag.String("longhorn-namespace", longhorn_cosched.LonghornNamespace, "Namespace Longhorn runs in, for the affinity modes.")
+		storageGate   = flag.Bool("storage-gate", false, "Add the "+longhorn_cosched.StorageReadyGate+" scheduling gate to the pods opted in, for the controller's --storage-gate to remove once their storage is ready. Annotate mode only.")
+		schedConfig   = flag.String("scheduler-config", "", "KubeSchedulerConfiguration file whose "+longhorn_cosched.Name+" args decide whether pods inherit the co-schedule annotation of their VMI. Defaults to the default args.")
+		schedProfile  = flag.String("scheduler-profile", webhook.DefaultSchedulerName, "Scheduler name of the --scheduler-config profile whose args to use.")
+	)
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	config := webhook.Config{
+		Mode:          webhook.Mode(*mode),
+		SchedulerName: *schedulerName,
+		Value:         *value,
+		Lookup:        longhorn_cosched.LookupConfig{LonghornNamespace: *namespace},
+		StorageGate:   *storageGate,
+
+		InvalidAnnotations: webhook.InvalidAnnotationPolicy(*invalid),
+	}
+	if *schedConfig != "" {
+		args, err := schedulerconfig.PluginArgs(*schedConfig)
+		if err != nil {
+			klog.ErrorS(err, "Webhook: reading --scheduler-config")
+			os.Exit(1)
+		}
+		config.PluginArgs = args[*schedProfile]
+	}
+	if err := run(*addr, *certFile, *keyFile, *podSelector, config); err != nil {
+		klog.ErrorS(err, "Webhook: exiting")
+		os.Exit(1)
+	}
+}
+
+func run(addr, certFile, keyFile, podSelector string, config webhook.Config) error {
+	selector, err := labels.Parse(podSelector)
+	if err != nil {
+		return err
+	}
+	config.PodSelector = selector
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	dynClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	injector, err := webhook.NewInjector(clientset, dynClient, config)
+	if err != nil {
+		return err
+	}
+	certs, err := webhook.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           injector.Handler(certs.Ready),
+		TLSConfig:         &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			klog.ErrorS(err, "Webhook: error shutting down")
+		}
+	}()
+
+	klog.InfoS("Webhook: serving", "address", addr, "mode", config.Mode, "podSelector", selector.String(), "schedulerName", config.SchedulerName, "storageGate", config.StorageGate)
+	if err := server.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
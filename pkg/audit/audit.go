@@ -0,0 +1,212 @@
+// Package audit reports, once, whether the co-scheduled virt-launcher pods in
+// a cluster run on the nodes of their Longhorn share-managers, with the same
+// share-manager lookup the scheduler runs in PreFilter.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// DefaultPodSelector selects KubeVirt's virt-launcher pods.
+const DefaultPodSelector = "kubevirt.io=virt-launcher"
+
+// Status is whether a pod runs on the node of the share-manager of one of its
+// PVCs.
+type Status string
+
+const (
+	// StatusColocated is a pod on the share-manager's node.
+	StatusColocated Status = "colocated"
+	// StatusDivergent is a pod on another node than the share-manager.
+	StatusDivergent Status = "divergent"
+	// StatusUnknown is a pod that is not scheduled yet, has no share-manager
+	// or whose share-managers could not be looked up.
+	StatusUnknown Status = "unknown"
+)
+
+// Config selects the pods audited.
+type Config struct {
+	// Namespace is the namespace of the pods, or empty for all namespaces.
+	Namespace string
+	// PodSelector defaults to DefaultPodSelector.
+	PodSelector labels.Selector
+	// All audits every selected pod, not only the ones opted in to
+	// co-scheduling.
+	All bool
+	// PluginArgs are the scheduler's LonghornCoSchedule args, as found under
+	// pluginConfig in its KubeSchedulerConfiguration, or nil for the
+	// defaults. Pods are opted in as the scheduler does under them.
+	PluginArgs runtime.Object
+	// Lookup tunes the share-manager lookup like the plugin args of the same
+	// names.
+	Lookup longhorn_cosched.LookupConfig
+}
+
+// Result is the status of a pod against the share-manager of one of its
+// PVCs. A pod without a share-manager, or whose lookup failed, has a single
+// result without PVC.
+type Result struct {
+	Namespace        string `json:"namespace"`
+	Pod              string `json:"pod"`
+	Mode             string `json:"mode,omitempty"`
+	Node             string `json:"node,omitempty"`
+	PVC              string `json:"pvc,omitempty"`
+	PV               string `json:"pv,omitempty"`
+	ShareManagerNode string `json:"shareManagerNode,omitempty"`
+	Status           Status `json:"status"`
+	// Reason says why the status is StatusUnknown.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Report is the result of an audit.
+type Report struct {
+	Results []Result `json:"results"`
+	// Colocated, Divergent and Unknown count the results of each status.
+	Colocated int `json:"colocated"`
+	Divergent int `json:"divergent"`
+	Unknown   int `json:"unknown"`
+}
+
+// Audit lists the selected pods that have not terminated and looks up the
+// share-manager nodes of their Longhorn RWX PVCs. Pods exempt from
+// co-scheduling, and unless config.All pods not opted in, are left out; the
+// mode of a pod is selected as the scheduler does, through its own
+// annotations, its VMI, its volumes and its namespace. The results are
+// sorted by namespace, pod and PVC. An error is only returned if the pods
+// cannot be listed or the plugin args are invalid; a failed lookup is a
+// StatusUnknown result.
+func Audit(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, config Config) (*Report, error) {
+	modes, err := longhorn_cosched.NewModeResolver(clientset, dynClient, config.PluginArgs)
+	if err != nil {
+		return nil, fmt.Errorf("plugin args: %w", err)
+	}
+	selector := config.PodSelector
+	if selector == nil {
+		var err error
+		if selector, err = labels.Parse(DefaultPodSelector); err != nil {
+			return nil, err
+		}
+	}
+	pods, err := clientset.CoreV1().Pods(config.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+
+	var selected []auditedPod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if exempt, _ := strconv.ParseBool(pod.Annotations[longhorn_cosched.ExemptAnnotationKey]); exempt && !config.All {
+			continue
+		}
+		mode := modes.Mode(ctx, pod)
+		if mode == "" && !config.All {
+			continue
+		}
+		selected = append(selected, auditedPod{pod: pod, mode: mode})
+	}
+	return auditPods(ctx, clientset, dynClient, selected, config.Lookup), nil
+}
+
+// auditedPod is a pod to audit and its co-scheduling mode.
+type auditedPod struct {
+	pod  *corev1.Pod
+	mode longhorn_cosched.Mode
+}
+
+// AuditPods looks up the share-manager nodes of the Longhorn RWX PVCs of the
+// pods, whether they are opted in or not, with the results sorted like
+// Audit's. Their modes are selected by modes.
+func AuditPods(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pods []*corev1.Pod, modes *longhorn_cosched.ModeResolver, lookup longhorn_cosched.LookupConfig) *Report {
+	audited := make([]auditedPod, 0, len(pods))
+	for _, pod := range pods {
+		audited = append(audited, auditedPod{pod: pod, mode: modes.Mode(ctx, pod)})
+	}
+	return auditPods(ctx, clientset, dynClient, audited, lookup)
+}
+
+// auditPods audits the pods, with the results sorted by namespace, pod and
+// PVC.
+func auditPods(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pods []auditedPod, lookup longhorn_cosched.LookupConfig) *Report {
+	report := &Report{Results: []Result{}}
+	for _, a := range pods {
+		report.Results = append(report.Results, auditPod(ctx, clientset, dynClient, a.pod, string(a.mode), lookup)...)
+	}
+
+	sort.Slice(report.Results, func(i, j int) bool {
+		a, b := report.Results[i], report.Results[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+		return a.PVC < b.PVC
+	})
+	for _, result := range report.Results {
+		switch result.Status {
+		case StatusColocated:
+			report.Colocated++
+		case StatusDivergent:
+			report.Divergent++
+		default:
+			report.Unknown++
+		}
+	}
+	return report
+}
+
+// auditPod returns the results of the pod, one per share-manager found that
+// pins it. A share-manager node the pod is only preferred on, e.g. that of a
+// migratable block volume, is left out: running elsewhere is no divergence.
+func auditPod(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pod *corev1.Pod, mode string, lookup longhorn_cosched.LookupConfig) []Result {
+	base := Result{Namespace: pod.Namespace, Pod: pod.Name, Mode: mode, Node: pod.Spec.NodeName, Status: StatusUnknown}
+	placements, err := longhorn_cosched.FindShareManagerPlacements(ctx, clientset, dynClient, pod, lookup)
+	if err != nil {
+		base.Reason = fmt.Sprintf("share-manager lookup failed: %v", err)
+		return []Result{base}
+	}
+	if len(placements) == 0 {
+		base.Reason = "no share-manager found"
+		return []Result{base}
+	}
+
+	results := make([]Result, 0, len(placements))
+	for _, placement := range placements {
+		if !placement.Pins {
+			continue
+		}
+		result := base
+		result.PVC = placement.PVC
+		result.PV = placement.PV
+		result.ShareManagerNode = placement.Node
+		switch {
+		case pod.Spec.NodeName == "":
+			result.Reason = "pod not scheduled"
+		case pod.Spec.NodeName == placement.Node:
+			result.Status = StatusColocated
+		default:
+			result.Status = StatusDivergent
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		base.Reason = "no share-manager pins the pod"
+		return []Result{base}
+	}
+	return results
+}
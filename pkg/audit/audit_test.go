@@ -0,0 +1,279 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const testNamespace = "vms"
+
+var shareManagerResource = schema.GroupVersionResource{Group: "longhorn.io", Version: "v1beta2", Resource: "sharemanagers"}
+
+// makeVM returns a virt-launcher pod on node, with the co-schedule annotation
+// set to mode unless empty, mounting the RWX PVC of the same name, and the
+// PVC, bound to the PV pv.
+func makeVM(name, node, mode, pv string) (*corev1.Pod, *corev1.PersistentVolumeClaim) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "virt-launcher-" + name,
+			Namespace:   testNamespace,
+			Labels:      map[string]string{"kubevirt.io": "virt-launcher"},
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Volumes: []corev1.Volume{{
+				Name:         "disk",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if mode != "" {
+		pod.Annotations[longhorn_cosched.AnnotationKey] = mode
+	}
+	if node == "" {
+		pod.Status.Phase = corev1.PodPending
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			VolumeName:  pv,
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	return pod, pvc
+}
+
+// makeShareManager returns the running Longhorn ShareManager of the PV,
+// owned by node.
+func makeShareManager(pv, node string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("longhorn.io/v1beta2")
+	obj.SetKind("ShareManager")
+	obj.SetNamespace(longhorn_cosched.LonghornNamespace)
+	obj.SetName(pv)
+	obj.Object["status"] = map[string]interface{}{"ownerID": node, "state": "running"}
+	return obj
+}
+
+// newTestClients returns fake clients holding a cluster of VMs placed in
+// every way the audit tells apart.
+func newTestClients() (*fake.Clientset, *dynamicfake.FakeDynamicClient) {
+	var objects []runtime.Object
+	add := func(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim) *corev1.Pod {
+		objects = append(objects, pod, pvc)
+		return pod
+	}
+	add(makeVM("colocated", "node-1", "true", "pv-1"))
+	add(makeVM("divergent", "node-2", "soft", "pv-2"))
+	add(makeVM("pending", "", "hard", "pv-3"))
+	add(makeVM("no-share-manager", "node-1", "true", "pv-4"))
+	add(makeVM("not-opted-in", "node-2", "", "pv-5"))
+	exempt := add(makeVM("exempt", "node-2", "true", "pv-6"))
+	exempt.Annotations[longhorn_cosched.ExemptAnnotationKey] = "true"
+	finished := add(makeVM("finished", "node-2", "true", "pv-7"))
+	finished.Status.Phase = corev1.PodSucceeded
+	// Not a virt-launcher pod.
+	other := add(makeVM("other", "node-2", "true", "pv-8"))
+	other.Labels = nil
+
+	clientset := fake.NewSimpleClientset(objects...)
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		shareManagerResource: "ShareManagerList",
+	},
+		makeShareManager("pv-1", "node-1"),
+		makeShareManager("pv-2", "node-1"),
+		makeShareManager("pv-3", "node-1"),
+		makeShareManager("pv-5", "node-1"),
+		makeShareManager("pv-6", "node-1"),
+		makeShareManager("pv-7", "node-1"),
+		makeShareManager("pv-8", "node-1"),
+	)
+	return clientset, dynClient
+}
+
+// TestAudit audits VMs on, off and without their share-manager node and
+// checks the status reported for each.
+func TestAudit(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   map[string]Status
+	}{
+		{
+			name: "opted-in pods",
+			want: map[string]Status{
+				"virt-launcher-colocated":        StatusColocated,
+				"virt-launcher-divergent":        StatusDivergent,
+				"virt-launcher-no-share-manager": StatusUnknown,
+				"virt-launcher-pending":          StatusUnknown,
+			},
+		},
+		{
+			name:   "all pods",
+			config: Config{All: true},
+			want: map[string]Status{
+				"virt-launcher-colocated":        StatusColocated,
+				"virt-launcher-divergent":        StatusDivergent,
+				"virt-launcher-exempt":           StatusDivergent,
+				"virt-launcher-no-share-manager": StatusUnknown,
+				"virt-launcher-not-opted-in":     StatusDivergent,
+				"virt-launcher-pending":          StatusUnknown,
+			},
+		},
+		{
+			name:   "other namespace",
+			config: Config{Namespace: "other"},
+			want:   map[string]Status{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, dynClient := newTestClients()
+			report, err := Audit(context.Background(), clientset, dynClient, tt.config)
+			if err != nil {
+				t.Fatalf("Audit() error = %v", err)
+			}
+			got := map[string]Status{}
+			for _, r := range report.Results {
+				got[r.Pod] = r.Status
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("audited pods = %v, want %v", got, tt.want)
+			}
+			for pod, want := range tt.want {
+				if got[pod] != want {
+					t.Errorf("status of %s = %q, want %q", pod, got[pod], want)
+				}
+			}
+			divergent, unknown := 0, 0
+			for _, status := range tt.want {
+				switch status {
+				case StatusDivergent:
+					divergent++
+				case StatusUnknown:
+					unknown++
+				}
+			}
+			if report.Divergent != divergent || report.Unknown != unknown {
+				t.Errorf("divergent, unknown = %d, %d, want %d, %d", report.Divergent, report.Unknown, divergent, unknown)
+			}
+		})
+	}
+}
+
+// TestAuditResult checks the fields of a divergent result and the reasons
+// of unknown ones.
+func TestAuditResult(t *testing.T) {
+	clientset, dynClient := newTestClients()
+	report, err := Audit(context.Background(), clientset, dynClient, Config{})
+	if err != nil {
+		t.Fatalf("Audit() error = %v", err)
+	}
+	want := []Result{
+		{Namespace: testNamespace, Pod: "virt-launcher-colocated", Mode: "hard", Node: "node-1", PVC: "colocated", PV: "pv-1", ShareManagerNode: "node-1", Status: StatusColocated},
+		{Namespace: testNamespace, Pod: "virt-launcher-divergent", Mode: "soft", Node: "node-2", PVC: "divergent", PV: "pv-2", ShareManagerNode: "node-1", Status: StatusDivergent},
+		{Namespace: testNamespace, Pod: "virt-launcher-no-share-manager", Mode: "hard", Node: "node-1", Status: StatusUnknown, Reason: "no share-manager found"},
+		{Namespace: testNamespace, Pod: "virt-launcher-pending", Mode: "hard", PVC: "pending", PV: "pv-3", ShareManagerNode: "node-1", Status: StatusUnknown, Reason: "pod not scheduled"},
+	}
+	if len(report.Results) != len(want) {
+		t.Fatalf("results = %+v, want %+v", report.Results, want)
+	}
+	for i := range want {
+		if report.Results[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, report.Results[i], want[i])
+		}
+	}
+}
+
+// TestAuditNamespaceAndPreference checks that a pod opted in by its
+// namespace is audited in the namespace's mode, and that a share-manager
+// node the pod is only preferred on does not make it divergent.
+func TestAuditNamespaceAndPreference(t *testing.T) {
+	pod, pvc := makeVM("starting", "node-2", "", "pv-1")
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        testNamespace,
+		Annotations: map[string]string{longhorn_cosched.AnnotationKey: "soft"},
+	}}
+	shareManager := makeShareManager("pv-1", "node-1")
+	shareManager.Object["status"].(map[string]interface{})["state"] = "starting"
+
+	for _, tt := range []struct {
+		name string
+		// requireReady makes the starting share-manager only preferred.
+		requireReady bool
+		want         Result
+	}{
+		{
+			name: "pinned",
+			want: Result{Namespace: testNamespace, Pod: pod.Name, Mode: "soft", Node: "node-2", PVC: "starting", PV: "pv-1", ShareManagerNode: "node-1", Status: StatusDivergent},
+		},
+		{
+			name:         "preferred",
+			requireReady: true,
+			want:         Result{Namespace: testNamespace, Pod: pod.Name, Mode: "soft", Node: "node-2", Status: StatusUnknown, Reason: "no share-manager pins the pod"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(pod, pvc, ns)
+			dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+				shareManagerResource: "ShareManagerList",
+			}, shareManager.DeepCopy())
+			config := Config{Lookup: longhorn_cosched.LookupConfig{RequireShareManagerReady: tt.requireReady}}
+			report, err := Audit(context.Background(), clientset, dynClient, config)
+			if err != nil {
+				t.Fatalf("Audit() error = %v", err)
+			}
+			if len(report.Results) != 1 || report.Results[0] != tt.want {
+				t.Errorf("results = %+v, want %+v", report.Results, tt.want)
+			}
+		})
+	}
+}
+
+// TestWrite checks that both output formats carry the results and the
+// counts.
+func TestWrite(t *testing.T) {
+	clientset, dynClient := newTestClients()
+	report, err := Audit(context.Background(), clientset, dynClient, Config{})
+	if err != nil {
+		t.Fatalf("Audit() error = %v", err)
+	}
+
+	var table bytes.Buffer
+	if err := WriteTable(&table, report); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	for _, want := range []string{"SHARE-MANAGER NODE", "virt-launcher-divergent", "divergent", "unknown (pod not scheduled)", "1 colocated, 1 divergent, 2 unknown"} {
+		if !strings.Contains(table.String(), want) {
+			t.Errorf("table does not contain %q:\n%s", want, table.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := WriteJSON(&out, report); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("decoding JSON output: %v", err)
+	}
+	if len(decoded.Results) != len(report.Results) || decoded.Divergent != 1 {
+		t.Errorf("JSON output = %s, want %d results and 1 divergent", out.String(), len(report.Results))
+	}
+}
@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// WriteTable writes the report as a table with a summary line.
+func WriteTable(w io.Writer, report *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tMODE\tNODE\tPVC\tSHARE-MANAGER NODE\tSTATUS")
+	for _, r := range report.Results {
+		status := string(r.Status)
+		if r.Reason != "" {
+			status += " (" + r.Reason + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Namespace, r.Pod, orNone(r.Mode), orNone(r.Node), orNone(r.PVC), orNone(r.ShareManagerNode), status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d colocated, %d divergent, %d unknown\n", report.Colocated, report.Divergent, report.Unknown)
+	return err
+}
+
+// WriteJSON writes the report as indented JSON.
+func WriteJSON(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
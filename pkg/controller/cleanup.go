@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	// DefaultCleanupInterval is how often the cleaner looks for stale
+	// annotations by default.
+	DefaultCleanupInterval = 10 * time.Minute
+
+	// DefaultCleanupQPS and DefaultCleanupBurst limit the pods the cleaner
+	// patches by default.
+	DefaultCleanupQPS   = 5
+	DefaultCleanupBurst = 10
+)
+
+// Reasons a decision annotation is stale, the reason label of
+// cleanupRemoved.
+const (
+	staleTerminated = "terminated"
+	staleOptedOut   = "opted-out"
+)
+
+// CleanupConfig configures a Cleaner.
+type CleanupConfig struct {
+	// Interval is how often every pod is checked. Defaults to
+	// DefaultCleanupInterval.
+	Interval time.Duration
+
+	// QPS and Burst limit the pods patched; the others are left for the
+	// next sweep. They default to DefaultCleanupQPS and DefaultCleanupBurst.
+	QPS   float32
+	Burst int
+
+	// DryRun only logs the annotations that would be removed.
+	DryRun bool
+}
+
+// Cleaner periodically removes the co-scheduling decision annotation
+// (longhorn_cosched.DecisionAnnotationKey) the scheduler set on observe-mode
+// pods once it went stale: the pod has terminated, or has since opted out of
+// co-scheduling through its own annotations. The audit tooling would
+// otherwise count decisions that no longer apply. Pods without a
+// co-scheduling annotation of their own are left alone: they may be opted in
+// through their namespace, VMI or PriorityClass, which only the scheduler
+// knows.
+type Cleaner struct {
+	clientset kubernetes.Interface
+	pods      corelisters.PodLister
+	synced    cache.InformerSynced
+	config    CleanupConfig
+	limiter   flowcontrol.PassiveRateLimiter
+}
+
+// NewCleaner returns a Cleaner for the pods of the informer, which should
+// only watch virt-launcher pods (LauncherSelector).
+func NewCleaner(clientset kubernetes.Interface, pods coreinformers.PodInformer, config CleanupConfig) *Cleaner {
+	return newCleaner(clientset, pods, config, clock.RealClock{})
+}
+
+func newCleaner(clientset kubernetes.Interface, pods coreinformers.PodInformer, config CleanupConfig, clock clock.PassiveClock) *Cleaner {
+	if config.Interval <= 0 {
+		config.Interval = DefaultCleanupInterval
+	}
+	if config.QPS <= 0 {
+		config.QPS = DefaultCleanupQPS
+	}
+	if config.Burst <= 0 {
+		config.Burst = DefaultCleanupBurst
+	}
+	registerMetrics()
+	return &Cleaner{
+		clientset: clientset,
+		pods:      pods.Lister(),
+		synced:    pods.Informer().HasSynced,
+		config:    config,
+		limiter:   flowcontrol.NewTokenBucketPassiveRateLimiterWithClock(config.QPS, config.Burst, clock),
+	}
+}
+
+// Run checks every pod once per interval until ctx is done.
+func (c *Cleaner) Run(ctx context.Context) error {
+	defer utilruntime.HandleCrash()
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced) {
+		return fmt.Errorf("waiting for the informer caches to sync: %w", ctx.Err())
+	}
+	klog.InfoS("Controller: cleaning up stale decision annotations", "interval", c.config.Interval, "qps", c.config.QPS, "burst", c.config.Burst, "dryRun", c.config.DryRun)
+	wait.UntilWithContext(ctx, c.sweep, c.config.Interval)
+	return nil
+}
+
+// sweep removes the stale decision annotations of the pods, as far as the
+// rate limit allows.
+func (c *Cleaner) sweep(ctx context.Context) {
+	pods, err := c.pods.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Controller: error listing virt-launcher pods")
+		return
+	}
+	for _, pod := range pods {
+		reason := staleDecision(pod)
+		if reason == "" {
+			continue
+		}
+		podKey := klog.KObj(pod)
+		if c.config.DryRun {
+			klog.V(2).InfoS("Controller: would remove stale decision annotation (dry run)", "pod", podKey, "reason", reason)
+			cleanupSkipped.WithLabelValues(skipDryRun).Inc()
+			continue
+		}
+		if !c.limiter.TryAccept() {
+			klog.V(4).InfoS("Controller: stale decision annotation left for the next sweep, rate limited", "pod", podKey, "reason", reason)
+			cleanupSkipped.WithLabelValues(skipThrottled).Inc()
+			continue
+		}
+		_, err := c.clientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, decisionRemovalPatch(pod), metav1.PatchOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			// A conflict means the pod changed since it was read; the next
+			// sweep checks it again.
+			klog.ErrorS(err, "Controller: error removing stale decision annotation", "pod", podKey)
+			cleanupSkipped.WithLabelValues(skipError).Inc()
+			continue
+		}
+		klog.V(2).InfoS("Controller: removed stale decision annotation", "pod", podKey, "reason", reason)
+		cleanupRemoved.WithLabelValues(reason).Inc()
+	}
+}
+
+// staleDecision returns why the pod's decision annotation is stale, or "" if
+// the pod has none or it still applies.
+func staleDecision(pod *corev1.Pod) string {
+	if _, ok := pod.Annotations[longhorn_cosched.DecisionAnnotationKey]; !ok || pod.DeletionTimestamp != nil {
+		return ""
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return staleTerminated
+	}
+	if exempt, _ := strconv.ParseBool(pod.Annotations[longhorn_cosched.ExemptAnnotationKey]); exempt {
+		return staleOptedOut
+	}
+	if value, ok := pod.Annotations[longhorn_cosched.AnnotationKey]; ok {
+		if mode, valid := longhorn_cosched.ParseMode(value); valid && mode == "" {
+			return staleOptedOut
+		}
+	}
+	return ""
+}
+
+// decisionRemovalPatch returns the merge patch removing the pod's decision
+// annotation. It carries the pod's resourceVersion, so it fails with a
+// conflict if the pod changed, e.g. opted in again, since it was read.
+func decisionRemovalPatch(pod *corev1.Pod) []byte {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": pod.ResourceVersion,
+			"annotations":     map[string]interface{}{longhorn_cosched.DecisionAnnotationKey: nil},
+		},
+	})
+	return patch
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// observedDecision is a decision annotation value as PostBind writes it.
+const observedDecision = `{"outcome":"observed","shareManagerNode":"node-1","node":"node-2","colocated":false}`
+
+// newTestCleaner returns a Cleaner over a fake clientset holding the pods,
+// with its informer started and synced.
+func newTestCleaner(t *testing.T, config CleanupConfig, clock *clocktesting.FakePassiveClock, pods ...runtime.Object) (*Cleaner, *fake.Clientset) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	clientset := fake.NewSimpleClientset(pods...)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	c := newCleaner(clientset, factory.Core().V1().Pods(), config, clock)
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	return c, clientset
+}
+
+// decisionAnnotated returns true if the pod still has the decision
+// annotation.
+func decisionAnnotated(t *testing.T, clientset *fake.Clientset, name string) bool {
+	t.Helper()
+	pod, err := clientset.CoreV1().Pods(testNamespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok := pod.Annotations[longhorn_cosched.DecisionAnnotationKey]
+	return ok
+}
+
+func TestCleanerStaleDecisions(t *testing.T) {
+	annotated := func(name string, annotations map[string]string) *corev1.Pod {
+		pod := makeLauncher(name, name, name+"-uid", map[string]string{longhorn_cosched.DecisionAnnotationKey: observedDecision})
+		for key, value := range annotations {
+			pod.Annotations[key] = value
+		}
+		pod.Status.Phase = corev1.PodRunning
+		return pod
+	}
+	terminated := annotated("terminated", map[string]string{longhorn_cosched.AnnotationKey: "observe"})
+	terminated.Status.Phase = corev1.PodSucceeded
+	failed := annotated("failed", nil)
+	failed.Status.Phase = corev1.PodFailed
+
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		wantReason string
+	}{
+		{name: "terminated pod", pod: terminated, wantReason: staleTerminated},
+		{name: "failed pod", pod: failed, wantReason: staleTerminated},
+		{name: "exempt pod", pod: annotated("exempt", map[string]string{longhorn_cosched.AnnotationKey: "observe", longhorn_cosched.ExemptAnnotationKey: "true"}), wantReason: staleOptedOut},
+		{name: "opted out with false", pod: annotated("false", map[string]string{longhorn_cosched.AnnotationKey: "false"}), wantReason: staleOptedOut},
+		{name: "opted out with the opt-out value", pod: annotated("optout", map[string]string{longhorn_cosched.AnnotationKey: longhorn_cosched.OptOutValue}), wantReason: staleOptedOut},
+		{name: "still in observe mode", pod: annotated("observe", map[string]string{longhorn_cosched.AnnotationKey: "observe"})},
+		{name: "hard mode, e.g. observed through observeOnly", pod: annotated("hard", map[string]string{longhorn_cosched.AnnotationKey: "true"})},
+		{name: "no annotation of its own, e.g. opted in through the namespace", pod: annotated("namespace", nil)},
+		{name: "invalid annotation value", pod: annotated("invalid", map[string]string{longhorn_cosched.AnnotationKey: "yes"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clientset := newTestCleaner(t, CleanupConfig{}, clocktesting.NewFakePassiveClock(time.Now()), tt.pod)
+			var before float64
+			if tt.wantReason != "" {
+				before, _ = testutil.GetCounterMetricValue(cleanupRemoved.WithLabelValues(tt.wantReason))
+			}
+
+			c.sweep(context.Background())
+
+			if got := decisionAnnotated(t, clientset, tt.pod.Name); got != (tt.wantReason == "") {
+				t.Errorf("decision annotation kept = %v, want %v", got, tt.wantReason == "")
+			}
+			if tt.wantReason == "" {
+				return
+			}
+			if after, _ := testutil.GetCounterMetricValue(cleanupRemoved.WithLabelValues(tt.wantReason)); after != before+1 {
+				t.Errorf("removed_total{reason=%q} = %v, want %v", tt.wantReason, after, before+1)
+			}
+		})
+	}
+}
+
+func TestCleanerDryRun(t *testing.T) {
+	pod := makeLauncher("optout", "optout", "optout-uid", map[string]string{
+		longhorn_cosched.DecisionAnnotationKey: observedDecision,
+		longhorn_cosched.AnnotationKey:         "false",
+	})
+	c, clientset := newTestCleaner(t, CleanupConfig{DryRun: true}, clocktesting.NewFakePassiveClock(time.Now()), pod)
+	before, _ := testutil.GetCounterMetricValue(cleanupSkipped.WithLabelValues(skipDryRun))
+
+	c.sweep(context.Background())
+
+	if !decisionAnnotated(t, clientset, pod.Name) {
+		t.Error("decision annotation removed in dry-run mode")
+	}
+	if after, _ := testutil.GetCounterMetricValue(cleanupSkipped.WithLabelValues(skipDryRun)); after != before+1 {
+		t.Errorf("skipped_total{reason=dry-run} = %v, want %v", after, before+1)
+	}
+}
+
+// TestCleanerRateLimit checks that patches beyond the burst are left for a
+// later sweep, once the rate limit allows them.
+func TestCleanerRateLimit(t *testing.T) {
+	var pods []runtime.Object
+	for _, name := range []string{"vm-1", "vm-2", "vm-3"} {
+		pod := makeLauncher(name, name, name+"-uid", map[string]string{longhorn_cosched.DecisionAnnotationKey: observedDecision})
+		pod.Status.Phase = corev1.PodSucceeded
+		pods = append(pods, pod)
+	}
+	clock := clocktesting.NewFakePassiveClock(time.Now())
+	c, clientset := newTestCleaner(t, CleanupConfig{QPS: 1, Burst: 2}, clock, pods...)
+	remaining := func() int {
+		n := 0
+		for _, pod := range pods {
+			if decisionAnnotated(t, clientset, pod.(*corev1.Pod).Name) {
+				n++
+			}
+		}
+		return n
+	}
+	before, _ := testutil.GetCounterMetricValue(cleanupSkipped.WithLabelValues(skipThrottled))
+
+	c.sweep(context.Background())
+	if n := remaining(); n != 1 {
+		t.Fatalf("%d pods annotated after the first sweep, want 1 left by the burst of 2", n)
+	}
+	if after, _ := testutil.GetCounterMetricValue(cleanupSkipped.WithLabelValues(skipThrottled)); after != before+1 {
+		t.Errorf("skipped_total{reason=throttled} = %v, want %v", after, before+1)
+	}
+
+	// Let the informer see the first patches, then refill the bucket.
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		listed, err := c.pods.List(labels.Everything())
+		stale := 0
+		for _, pod := range listed {
+			if staleDecision(pod) != "" {
+				stale++
+			}
+		}
+		return stale == 1, err
+	})
+	if err != nil {
+		t.Fatalf("waiting for the informer: %v", err)
+	}
+	clock.SetTime(clock.Now().Add(10 * time.Second))
+	c.sweep(context.Background())
+	if n := remaining(); n != 0 {
+		t.Errorf("%d pods annotated after the second sweep, want 0", n)
+	}
+}
@@ -0,0 +1,408 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	// DefaultDescheduleInterval is how often the descheduler looks for
+	// divergent VMs by default.
+	DefaultDescheduleInterval = time.Minute
+
+	// DefaultDescheduleGracePeriod is how long a VM has to stay off its
+	// share-manager node before it is evicted, by default.
+	DefaultDescheduleGracePeriod = 10 * time.Minute
+
+	// descheduleReason is the reason of the event emitted on a pod evicted,
+	// or in dry-run mode that would be evicted, because it runs off its
+	// share-manager node.
+	descheduleReason = "DivergentFromShareManager"
+
+	// kubevirtEvacuationMessage is part of the message KubeVirt denies the
+	// eviction of a live-migratable virt-launcher pod with, once it started
+	// migrating the VM instead.
+	kubevirtEvacuationMessage = "evacuation"
+)
+
+// Reasons for which a divergent pod is not evicted, the reason label of
+// descheduleSkipped.
+const (
+	skipGracePeriod   = "grace-period"
+	skipMigrating     = "migrating"
+	skipThrottled     = "throttled"
+	skipDryRun        = "dry-run"
+	skipBudget        = "disruption-budget"
+	skipCooldown      = "cooldown"
+	skipNotMigratable = "not-migratable"
+	skipError         = "error"
+)
+
+// DescheduleAction selects how a Descheduler moves a VM off its share-manager
+// node.
+type DescheduleAction string
+
+const (
+	// DescheduleEvict evicts the virt-launcher pod. KubeVirt live-migrates
+	// VMs whose evictionStrategy is LiveMigrate instead; others restart.
+	DescheduleEvict DescheduleAction = "evict"
+
+	// DescheduleMigrate creates a VirtualMachineInstanceMigration whose
+	// target the scheduler pins to the share-manager node, and never stops
+	// the VM.
+	DescheduleMigrate DescheduleAction = "migrate"
+)
+
+// DescheduleConfig configures a Descheduler.
+type DescheduleConfig struct {
+	// Interval is how often every running VM is checked. Defaults to
+	// DefaultDescheduleInterval.
+	Interval time.Duration
+
+	// GracePeriod is how long a VM has to be seen off its share-manager node
+	// before it is evicted, so a share-manager failing over briefly does
+	// not move VMs. Defaults to DefaultDescheduleGracePeriod.
+	GracePeriod time.Duration
+
+	// MaxUnavailable is how many evicted VMs may have no new virt-launcher
+	// pod Running yet, e.g. while KubeVirt live-migrates or restarts them,
+	// before no more are evicted. Defaults to 1.
+	MaxUnavailable int
+
+	// DryRun only emits the events, nothing is evicted.
+	DryRun bool
+
+	// Action defaults to DescheduleEvict.
+	Action DescheduleAction
+
+	// MaxMigrationsPerNamespace is how many migrations the Descheduler
+	// created may be in flight in a namespace at once, with
+	// DescheduleMigrate. Defaults to 1.
+	MaxMigrationsPerNamespace int
+
+	// MigrationCooldown is how long after a migration of a VMI is created
+	// no other one is, with DescheduleMigrate, e.g. because the
+	// share-manager keeps moving. Defaults to DefaultMigrationCooldown.
+	MigrationCooldown time.Duration
+
+	// Lookup tunes how the pods' share-managers are looked up.
+	Lookup longhorn_cosched.LookupConfig
+
+	// PluginArgs are the scheduler's LonghornCoSchedule args, which select
+	// the pods' co-scheduling mode, e.g. through inheritVMIAnnotation or
+	// priorityClassModes. nil selects the defaults.
+	PluginArgs runtime.Object
+}
+
+// Descheduler periodically evicts the running hard-mode virt-launcher pods
+// whose node no longer is the node of their share-managers, e.g. after
+// Longhorn failed the share-manager over to another node, so the VM is
+// scheduled, or live-migrated, next to its share-manager again. Evictions go
+// through the Eviction API, so PodDisruptionBudgets and KubeVirt's eviction
+// strategies apply. With DescheduleMigrate it creates live migrations
+// instead.
+type Descheduler struct {
+	clientset kubernetes.Interface
+	dynClient dynamic.Interface
+	pods      corelisters.PodLister
+	synced    cache.InformerSynced
+	recorder  events.EventRecorder
+	modes     *longhorn_cosched.ModeResolver
+	config    DescheduleConfig
+	clock     clock.PassiveClock
+
+	mu sync.Mutex
+	// divergentSince is when each divergent pod was first seen off its
+	// share-manager node, by UID.
+	divergentSince map[types.UID]time.Time
+	// evicted are the VMs evicted, or migrated, whose VMI had no new
+	// virt-launcher pod Running yet at the last sweep, by the UID of the
+	// VMI, or of the pod for a pod without one.
+	evicted map[types.UID]evictedVM
+	// migrated is when the last migration of each VMI was created, by UID.
+	migrated map[types.UID]time.Time
+}
+
+// evictedVM is a VM the Descheduler evicted, or migrated.
+type evictedVM struct {
+	// pod is the UID of the virt-launcher pod moved.
+	pod types.UID
+	// at is when it was moved.
+	at time.Time
+}
+
+// evictionKey returns the key of the pod's VM in Descheduler.evicted.
+func evictionKey(pod *corev1.Pod) types.UID {
+	if owner := longhorn_cosched.VMIOwner(pod); owner != nil {
+		return owner.UID
+	}
+	return pod.UID
+}
+
+// NewDescheduler returns a Descheduler for the pods of the informer, which
+// should only watch virt-launcher pods (LauncherSelector).
+func NewDescheduler(clientset kubernetes.Interface, dynClient dynamic.Interface, pods coreinformers.PodInformer, recorder events.EventRecorder, config DescheduleConfig) (*Descheduler, error) {
+	switch config.Action {
+	case "":
+		config.Action = DescheduleEvict
+	case DescheduleEvict, DescheduleMigrate:
+	default:
+		return nil, fmt.Errorf("invalid deschedule action %q, must be %q or %q", config.Action, DescheduleEvict, DescheduleMigrate)
+	}
+	if config.MaxMigrationsPerNamespace <= 0 {
+		config.MaxMigrationsPerNamespace = 1
+	}
+	if config.MigrationCooldown <= 0 {
+		config.MigrationCooldown = DefaultMigrationCooldown
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultDescheduleInterval
+	}
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = DefaultDescheduleGracePeriod
+	}
+	if config.MaxUnavailable <= 0 {
+		config.MaxUnavailable = 1
+	}
+	modes, err := longhorn_cosched.NewModeResolver(clientset, dynClient, config.PluginArgs)
+	if err != nil {
+		return nil, err
+	}
+	registerMetrics()
+	return &Descheduler{
+		clientset:      clientset,
+		dynClient:      dynClient,
+		pods:           pods.Lister(),
+		synced:         pods.Informer().HasSynced,
+		recorder:       recorder,
+		modes:          modes,
+		config:         config,
+		clock:          clock.RealClock{},
+		divergentSince: map[types.UID]time.Time{},
+		evicted:        map[types.UID]evictedVM{},
+		migrated:       map[types.UID]time.Time{},
+	}, nil
+}
+
+// Run checks every running VM once per interval until ctx is done.
+func (d *Descheduler) Run(ctx context.Context) error {
+	defer utilruntime.HandleCrash()
+
+	if !cache.WaitForCacheSync(ctx.Done(), d.synced) {
+		return fmt.Errorf("waiting for the informer caches to sync: %w", ctx.Err())
+	}
+	klog.InfoS("Controller: descheduling VMs off their share-manager node",
+		"action", d.config.Action, "interval", d.config.Interval, "gracePeriod", d.config.GracePeriod, "maxUnavailable", d.config.MaxUnavailable, "dryRun", d.config.DryRun)
+	wait.UntilWithContext(ctx, d.sweep, d.config.Interval)
+	return nil
+}
+
+// sweep checks every running virt-launcher pod once and evicts the divergent
+// ones past the grace period, as long as fewer than MaxUnavailable VMs it
+// evicted earlier are still moving.
+func (d *Descheduler) sweep(ctx context.Context) {
+	pods, err := d.pods.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Controller: error listing virt-launcher pods")
+		return
+	}
+	// Oldest first, so the VMs that diverged first move first.
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// alive are the keys of the VMs with a running or pending pod.
+	alive := map[types.UID]bool{}
+	// launchers counts the running and pending pods of each VMI: more than
+	// one means it is being live-migrated.
+	launchers := map[types.UID]int{}
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+		key := evictionKey(pod)
+		alive[key] = true
+		if owner := longhorn_cosched.VMIOwner(pod); owner != nil {
+			launchers[owner.UID]++
+		}
+		// A VM stays moving until a new virt-launcher pod of it runs: the
+		// evicted pod is gone long before the VM restarted, or finished
+		// migrating.
+		if e, ok := d.evicted[key]; ok && pod.Status.Phase == corev1.PodRunning && pod.UID != e.pod {
+			delete(d.evicted, key)
+		}
+	}
+	for key, e := range d.evicted {
+		// A VM without pods past the grace period was stopped, or its pod
+		// had no VMI.
+		if !alive[key] && d.clock.Since(e.at) >= d.config.GracePeriod {
+			delete(d.evicted, key)
+		}
+	}
+	seen := map[types.UID]bool{}
+
+	for _, pod := range pods {
+		if _, moving := d.evicted[evictionKey(pod)]; moving || !d.candidate(ctx, pod) {
+			continue
+		}
+		descheduleConsidered.Inc()
+		node, err := d.shareManagerNode(ctx, pod)
+		if err != nil {
+			klog.ErrorS(err, "Controller: error looking up share-managers", "pod", klog.KObj(pod))
+			descheduleSkipped.WithLabelValues(skipError).Inc()
+			continue
+		}
+		if node == "" || node == pod.Spec.NodeName {
+			continue
+		}
+		seen[pod.UID] = true
+		since, ok := d.divergentSince[pod.UID]
+		if !ok {
+			since = d.clock.Now()
+			d.divergentSince[pod.UID] = since
+		}
+		podKey := klog.KObj(pod)
+		if waited := d.clock.Since(since); waited < d.config.GracePeriod {
+			klog.V(4).InfoS("Controller: VM off its share-manager node, within the grace period", "pod", podKey, "node", pod.Spec.NodeName, "shareManagerNode", node, "waited", waited)
+			descheduleSkipped.WithLabelValues(skipGracePeriod).Inc()
+			continue
+		}
+		owner := longhorn_cosched.VMIOwner(pod)
+		if owner != nil && launchers[owner.UID] > 1 {
+			klog.V(4).InfoS("Controller: VM off its share-manager node is migrating, not moving it", "pod", podKey)
+			descheduleSkipped.WithLabelValues(skipMigrating).Inc()
+			continue
+		}
+		migrate := d.config.Action == DescheduleMigrate
+		if migrate {
+			if skip := d.migratable(ctx, pod, owner); skip != "" {
+				descheduleSkipped.WithLabelValues(skip).Inc()
+				continue
+			}
+		}
+		action, verb := "Evict", "evict"
+		if migrate {
+			action, verb = "Migrate", "migrate"
+		}
+		if d.config.DryRun {
+			d.recorder.Eventf(pod, nil, corev1.EventTypeNormal, descheduleReason, action,
+				"Would %s the pod: it runs on node %s, its share-manager on node %s (dry run)", verb, pod.Spec.NodeName, node)
+			klog.V(2).InfoS("Controller: would move VM off its share-manager node (dry run)", "pod", podKey, "action", d.config.Action, "node", pod.Spec.NodeName, "shareManagerNode", node)
+			descheduleSkipped.WithLabelValues(skipDryRun).Inc()
+			continue
+		}
+		if len(d.evicted) >= d.config.MaxUnavailable {
+			klog.V(4).InfoS("Controller: VM off its share-manager node, too many VMs moving", "pod", podKey, "inProgress", len(d.evicted))
+			descheduleSkipped.WithLabelValues(skipThrottled).Inc()
+			continue
+		}
+		if !migrate {
+			if skip := d.evict(ctx, pod); skip != "" {
+				descheduleSkipped.WithLabelValues(skip).Inc()
+				continue
+			}
+			d.recorder.Eventf(pod, nil, corev1.EventTypeNormal, descheduleReason, action,
+				"Evicted the pod: it runs on node %s, its share-manager on node %s", pod.Spec.NodeName, node)
+			klog.InfoS("Controller: evicted VM off its share-manager node", "pod", podKey, "node", pod.Spec.NodeName, "shareManagerNode", node)
+			descheduleEvicted.Inc()
+		} else {
+			name, skip := d.migrate(ctx, pod, owner)
+			if skip != "" {
+				descheduleSkipped.WithLabelValues(skip).Inc()
+				continue
+			}
+			d.migrated[owner.UID] = d.clock.Now()
+			d.recorder.Eventf(pod, nil, corev1.EventTypeNormal, descheduleReason, action,
+				"Created VirtualMachineInstanceMigration %s: the pod runs on node %s, its share-manager on node %s", name, pod.Spec.NodeName, node)
+			klog.InfoS("Controller: migrating VM off its share-manager node", "pod", podKey, "migration", name, "node", pod.Spec.NodeName, "shareManagerNode", node)
+			descheduleMigrations.Inc()
+		}
+		d.evicted[evictionKey(pod)] = evictedVM{pod: pod.UID, at: d.clock.Now()}
+		delete(d.divergentSince, pod.UID)
+	}
+
+	for uid := range d.divergentSince {
+		if !seen[uid] {
+			delete(d.divergentSince, uid)
+		}
+	}
+}
+
+// shareManagerNode returns the node of the pod's share-managers, or "" if
+// the pod has none, or they are not all on the same node. Only
+// share-managers that would pin the pod count.
+func (d *Descheduler) shareManagerNode(ctx context.Context, pod *corev1.Pod) (string, error) {
+	placements, err := longhorn_cosched.FindShareManagerPlacements(ctx, d.clientset, d.dynClient, pod, d.config.Lookup)
+	if err != nil {
+		return "", err
+	}
+	node := ""
+	for _, placement := range placements {
+		if !placement.Pins {
+			continue
+		}
+		if node != "" && placement.Node != node {
+			return "", nil
+		}
+		node = placement.Node
+	}
+	return node, nil
+}
+
+// evict evicts the pod through the Eviction API. It returns the reason the
+// pod was not evicted, or "" if it was, or KubeVirt started live-migrating
+// the VM instead.
+func (d *Descheduler) evict(ctx context.Context, pod *corev1.Pod) string {
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	err := d.clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+	switch {
+	case err == nil:
+		return ""
+	case apierrors.IsTooManyRequests(err) && strings.Contains(err.Error(), kubevirtEvacuationMessage):
+		return ""
+	case apierrors.IsTooManyRequests(err):
+		klog.V(2).InfoS("Controller: eviction of VM off its share-manager node blocked by a disruption budget", "pod", klog.KObj(pod), "err", err)
+		return skipBudget
+	default:
+		klog.ErrorS(err, "Controller: error evicting VM off its share-manager node", "pod", klog.KObj(pod))
+		return skipError
+	}
+}
+
+// candidate returns true if the pod is a running hard-mode VM, opted in by
+// any of the mechanisms the scheduler honours: soft-mode VMs only prefer
+// their share-manager node, so evicting them may just move them elsewhere
+// again.
+func (d *Descheduler) candidate(ctx context.Context, pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+		return false
+	}
+	return d.modes.Mode(ctx, pod) == longhorn_cosched.ModeHard
+}
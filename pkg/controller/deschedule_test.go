@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// makeRunningVM returns a running hard-mode virt-launcher pod on node,
+// mounting the RWX PVC of the same name, and the PVC, bound to the PV pv.
+func makeRunningVM(name, node, pv string, created time.Time) (*corev1.Pod, *corev1.PersistentVolumeClaim) {
+	pod := makeLauncher("virt-launcher-"+name, name, name+"-uid", map[string]string{longhorn_cosched.AnnotationKey: "true"})
+	pod.UID = "pod-" + pod.OwnerReferences[0].UID
+	pod.CreationTimestamp = metav1.NewTime(created)
+	pod.Spec.NodeName = node
+	pod.Spec.Volumes = []corev1.Volume{{
+		Name:         "disk",
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name}},
+	}}
+	pod.Status.Phase = corev1.PodRunning
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			VolumeName:  pv,
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	return pod, pvc
+}
+
+// makeVolumeShareManager returns the running ShareManager of the PV, owned by
+// node.
+func makeVolumeShareManager(pv, node string) *unstructured.Unstructured {
+	obj := makeShareManager(node)
+	obj.SetName(pv)
+	return obj
+}
+
+// TestDeschedulerFailover fails the share-managers of two VMs over to another
+// node and checks that, past the grace period, exactly one VM is evicted while
+// the other waits for the first VM to run again, on a new virt-launcher pod.
+func TestDeschedulerFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	clock := clocktesting.NewFakeClock(now)
+
+	vm1, pvc1 := makeRunningVM("vm-1", "node-1", "pvc-1", now.Add(-2*time.Hour))
+	vm2, pvc2 := makeRunningVM("vm-2", "node-1", "pvc-2", now.Add(-time.Hour))
+	exempt, pvc3 := makeRunningVM("vm-3", "node-1", "pvc-3", now)
+	exempt.Annotations[longhorn_cosched.ExemptAnnotationKey] = "true"
+	clientset := fake.NewSimpleClientset(vm1, vm2, exempt, pvc1, pvc2, pvc3)
+	var evicted []string
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		name := action.(k8stesting.CreateAction).GetObject().(metav1.Object).GetName()
+		evicted = append(evicted, name)
+		// The eviction deletes the pod; KubeVirt creates a new one.
+		return true, nil, clientset.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"), action.GetNamespace(), name)
+	})
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		shareManagerResource: "ShareManagerList",
+	}, makeVolumeShareManager("pvc-1", "node-1"), makeVolumeShareManager("pvc-2", "node-1"), makeVolumeShareManager("pvc-3", "node-1"))
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	recorder := events.NewFakeRecorder(10)
+	d, err := NewDescheduler(clientset, dynClient, factory.Core().V1().Pods(), recorder,
+		DescheduleConfig{GracePeriod: 5 * time.Minute, MaxUnavailable: 1})
+	if err != nil {
+		t.Fatalf("NewDescheduler() error = %v", err)
+	}
+	d.clock = clock
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	considered, _ := testutil.GetCounterMetricValue(descheduleConsidered)
+	evictedMetric, _ := testutil.GetCounterMetricValue(descheduleEvicted)
+	throttled, _ := testutil.GetCounterMetricValue(descheduleSkipped.WithLabelValues(skipThrottled))
+
+	d.sweep(ctx)
+	if len(evicted) != 0 {
+		t.Fatalf("evicted %v while co-located, want none", evicted)
+	}
+
+	// Longhorn fails the share-managers over to node-2.
+	for _, pv := range []string{"pvc-1", "pvc-2", "pvc-3"} {
+		if _, err := dynClient.Resource(shareManagerResource).Namespace(longhorn_cosched.LonghornNamespace).
+			Update(ctx, makeVolumeShareManager(pv, "node-2"), metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("updating ShareManager: %v", err)
+		}
+	}
+	d.sweep(ctx)
+	if len(evicted) != 0 {
+		t.Fatalf("evicted %v within the grace period, want none", evicted)
+	}
+
+	clock.Step(5 * time.Minute)
+	d.sweep(ctx)
+	d.sweep(ctx)
+	if len(evicted) != 1 || evicted[0] != vm1.Name {
+		t.Fatalf("evicted %v, want only %s, the oldest", evicted, vm1.Name)
+	}
+
+	// The evicted pod is gone, but its VM has not restarted yet.
+	waitForPods(ctx, t, d, func(pods map[string]*corev1.Pod) bool { return pods[vm1.Name] == nil })
+	d.sweep(ctx)
+	restarted, _ := makeRunningVM("vm-1", "node-2", "pvc-1", now)
+	restarted.Name, restarted.UID = "virt-launcher-vm-1-restarted", "pod-vm-1-restarted"
+	restarted.Status.Phase = corev1.PodPending
+	if _, err := clientset.CoreV1().Pods(testNamespace).Create(ctx, restarted, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating pod: %v", err)
+	}
+	waitForPods(ctx, t, d, func(pods map[string]*corev1.Pod) bool { return pods[restarted.Name] != nil })
+	d.sweep(ctx)
+	if len(evicted) != 1 {
+		t.Fatalf("evicted %v before %s ran again, want only %s", evicted, vm1.Name, vm1.Name)
+	}
+
+	restarted.Status.Phase = corev1.PodRunning
+	if _, err := clientset.CoreV1().Pods(testNamespace).UpdateStatus(ctx, restarted, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating pod: %v", err)
+	}
+	waitForPods(ctx, t, d, func(pods map[string]*corev1.Pod) bool {
+		return pods[restarted.Name] != nil && pods[restarted.Name].Status.Phase == corev1.PodRunning
+	})
+	d.sweep(ctx)
+	if len(evicted) != 2 || evicted[1] != vm2.Name {
+		t.Fatalf("evicted %v once %s ran again, want %s next", evicted, vm1.Name, vm2.Name)
+	}
+
+	if got, _ := testutil.GetCounterMetricValue(descheduleConsidered); got-considered != 11 {
+		t.Errorf("considered_total grew by %v, want 11 (2 VMs in 7 sweeps, less the moving one in 3)", got-considered)
+	}
+	if got, _ := testutil.GetCounterMetricValue(descheduleEvicted); got-evictedMetric != 2 {
+		t.Errorf("evicted_total grew by %v, want 2", got-evictedMetric)
+	}
+	if got, _ := testutil.GetCounterMetricValue(descheduleSkipped.WithLabelValues(skipThrottled)); got-throttled != 4 {
+		t.Errorf("skipped_total{reason=throttled} grew by %v, want 4", got-throttled)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Normal "+descheduleReason) {
+			t.Errorf("event = %q, want a %s event", event, descheduleReason)
+		}
+	default:
+		t.Error("no event emitted")
+	}
+}
+
+// waitForPods waits until the Descheduler's pod cache, by pod name, satisfies
+// cond.
+func waitForPods(ctx context.Context, t *testing.T, d *Descheduler, cond func(map[string]*corev1.Pod) bool) {
+	t.Helper()
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		pods, err := d.pods.List(labels.Everything())
+		if err != nil {
+			return false, err
+		}
+		byName := map[string]*corev1.Pod{}
+		for _, pod := range pods {
+			byName[pod.Name] = pod
+		}
+		return cond(byName), nil
+	}); err != nil {
+		t.Fatalf("waiting for the pod cache: %v", err)
+	}
+}
+
+func TestDeschedulerDryRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	clock := clocktesting.NewFakeClock(now)
+
+	vm, pvc := makeRunningVM("vm-1", "node-1", "pvc-1", now)
+	clientset := fake.NewSimpleClientset(vm, pvc)
+	evictions := 0
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "eviction" {
+			evictions++
+			return true, nil, nil
+		}
+		return false, nil, nil
+	})
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		shareManagerResource: "ShareManagerList",
+	}, makeVolumeShareManager("pvc-1", "node-2"))
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	recorder := events.NewFakeRecorder(10)
+	d, err := NewDescheduler(clientset, dynClient, factory.Core().V1().Pods(), recorder, DescheduleConfig{GracePeriod: time.Minute, DryRun: true})
+	if err != nil {
+		t.Fatalf("NewDescheduler() error = %v", err)
+	}
+	d.clock = clock
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	d.sweep(ctx)
+	clock.Step(time.Minute)
+	d.sweep(ctx)
+	if evictions != 0 {
+		t.Errorf("%d evictions in dry-run mode, want none", evictions)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("%d events emitted, want 1", len(recorder.Events))
+	}
+}
+
+// TestEvict checks how Eviction API responses are told apart: a
+// PodDisruptionBudget blocking the eviction, and KubeVirt live-migrating the
+// VM instead, both answer 429.
+func TestEvict(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantSkip string
+	}{
+		{name: "evicted"},
+		{name: "KubeVirt migrates the VM", err: apierrors.NewTooManyRequests("Eviction triggered evacuation of VMI \"vms/my-vm\"", 0)},
+		{name: "disruption budget", err: apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10), wantSkip: skipBudget},
+		{name: "error", err: apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "vm", nil), wantSkip: skipError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm, _ := makeRunningVM("vm", "node-1", "pvc-1", time.Now())
+			clientset := fake.NewSimpleClientset(vm)
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return action.GetSubresource() == "eviction", nil, tt.err
+			})
+			d := &Descheduler{clientset: clientset}
+			if got := d.evict(context.Background(), vm); got != tt.wantSkip {
+				t.Errorf("evict() = %q, want %q", got, tt.wantSkip)
+			}
+		})
+	}
+}
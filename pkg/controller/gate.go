@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	// DefaultGateTimeout is how long a pod stays gated at most by default.
+	DefaultGateTimeout = 10 * time.Minute
+
+	// DefaultGatePollInterval is how often the storage of a gated pod is
+	// checked again by default.
+	DefaultGatePollInterval = 5 * time.Second
+
+	// storageReadyReason is the reason of the Normal event emitted on a pod
+	// whose gate was removed because its storage is ready.
+	storageReadyReason = "StorageReady"
+
+	// storageGateTimeoutReason is the reason of the warning event emitted on
+	// a pod whose gate was removed because its storage was not ready in
+	// time, e.g. because Longhorn is not installed.
+	storageGateTimeoutReason = "StorageGateTimeout"
+)
+
+// GateConfig configures a GateController.
+type GateConfig struct {
+	// Timeout is how long after its creation a pod stays gated at most.
+	// Defaults to DefaultGateTimeout.
+	Timeout time.Duration
+
+	// PollInterval is how often the storage of a gated pod is checked.
+	// Defaults to DefaultGatePollInterval.
+	PollInterval time.Duration
+
+	// Lookup tunes how the pod's ShareManagers are read.
+	Lookup longhorn_cosched.LookupConfig
+}
+
+// GateController removes the longhorn_cosched.StorageReadyGate scheduling
+// gate the webhook puts on opted-in pods, once longhorn_cosched.StorageReady
+// reports their storage ready, or once they were gated for the timeout. Its
+// queue is keyed by pod namespace/name.
+type GateController struct {
+	clientset kubernetes.Interface
+	dynClient dynamic.Interface
+	pods      corelisters.PodLister
+	synced    cache.InformerSynced
+	recorder  events.EventRecorder
+	config    GateConfig
+	clock     clock.PassiveClock
+	queue     workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewGateController returns a GateController for the pods of the informer.
+func NewGateController(clientset kubernetes.Interface, dynClient dynamic.Interface, pods coreinformers.PodInformer, recorder events.EventRecorder, config GateConfig) (*GateController, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultGateTimeout
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultGatePollInterval
+	}
+	c := &GateController{
+		clientset: clientset,
+		dynClient: dynClient,
+		pods:      pods.Lister(),
+		synced:    pods.Informer().HasSynced,
+		recorder:  recorder,
+		config:    config,
+		clock:     clock.RealClock{},
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "storage-gate"}),
+	}
+
+	enqueue := func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || !gated(pod) {
+			return
+		}
+		if key, err := cache.MetaNamespaceKeyFunc(pod); err == nil {
+			c.queue.Add(key)
+		}
+	}
+	if _, err := pods.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run processes the queue with the given number of workers until ctx is
+// done.
+func (c *GateController) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced) {
+		return fmt.Errorf("waiting for the informer caches to sync: %w", ctx.Err())
+	}
+	klog.InfoS("Controller: removing storage gates", "workers", workers, "timeout", c.config.Timeout)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, func(ctx context.Context) {
+			for c.processNextItem(ctx) {
+			}
+		}, time.Second)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (c *GateController) processNextItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	requeue, err := c.reconcile(ctx, key)
+	switch {
+	case err != nil:
+		klog.ErrorS(err, "Controller: error reconciling storage gate, retrying", "pod", key, "retries", c.queue.NumRequeues(key))
+		c.queue.AddRateLimited(key)
+	case requeue > 0:
+		c.queue.Forget(key)
+		c.queue.AddAfter(key, requeue)
+	default:
+		c.queue.Forget(key)
+	}
+	return true
+}
+
+// reconcile removes the storage gate of the pod with the given key if its
+// storage is ready or it has been gated for the timeout. Otherwise it returns
+// when to check again.
+func (c *GateController) reconcile(ctx context.Context, key string) (time.Duration, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return 0, nil
+	}
+	pod, err := c.pods.Pods(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !gated(pod) || pod.DeletionTimestamp != nil {
+		return 0, nil
+	}
+
+	reason, err := longhorn_cosched.StorageReady(ctx, c.clientset, c.dynClient, pod, c.config.Lookup)
+	if err != nil {
+		// Keep waiting: a lookup that keeps failing must not keep the pod
+		// gated past the timeout either.
+		klog.V(4).InfoS("Controller: error checking storage of gated pod", "pod", klog.KObj(pod), "err", err)
+		reason = err.Error()
+	}
+	if reason == "" {
+		if err := c.removeGate(ctx, pod); err != nil {
+			return 0, err
+		}
+		c.recorder.Eventf(pod, nil, corev1.EventTypeNormal, storageReadyReason, "Ungate",
+			"Storage is ready, removed scheduling gate %s", longhorn_cosched.StorageReadyGate)
+		klog.V(2).InfoS("Controller: storage ready, removed scheduling gate", "pod", klog.KObj(pod))
+		return 0, nil
+	}
+
+	waited := c.clock.Since(pod.CreationTimestamp.Time)
+	if waited < c.config.Timeout {
+		klog.V(4).InfoS("Controller: pod stays gated", "pod", klog.KObj(pod), "reason", reason, "waited", waited)
+		return min(c.config.PollInterval, c.config.Timeout-waited), nil
+	}
+	if err := c.removeGate(ctx, pod); err != nil {
+		return 0, err
+	}
+	c.recorder.Eventf(pod, nil, corev1.EventTypeWarning, storageGateTimeoutReason, "Ungate",
+		"Storage not ready after %s (%s), removed scheduling gate %s anyway", c.config.Timeout, reason, longhorn_cosched.StorageReadyGate)
+	klog.InfoS("Controller: storage not ready in time, removed scheduling gate", "pod", klog.KObj(pod), "reason", reason, "timeout", c.config.Timeout)
+	return 0, nil
+}
+
+// removeGate removes the storage gate from the pod. The JSON patch tests
+// the gate's index first, so it fails rather than remove another gate if the
+// gates changed since the pod was read.
+func (c *GateController) removeGate(ctx context.Context, pod *corev1.Pod) error {
+	for i, gate := range pod.Spec.SchedulingGates {
+		if gate.Name != longhorn_cosched.StorageReadyGate {
+			continue
+		}
+		path := fmt.Sprintf("/spec/schedulingGates/%d", i)
+		patch, _ := json.Marshal([]map[string]interface{}{
+			{"op": "test", "path": path + "/name", "value": gate.Name},
+			{"op": "remove", "path": path},
+		})
+		_, err := c.clientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("removing scheduling gate of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// gated returns true if the pod carries the storage gate.
+func gated(pod *corev1.Pod) bool {
+	for _, gate := range pod.Spec.SchedulingGates {
+		if gate.Name == longhorn_cosched.StorageReadyGate {
+			return true
+		}
+	}
+	return false
+}
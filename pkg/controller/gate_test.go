@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/events"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	gatePVCName = "my-rwx-pvc"
+	gatePVName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+)
+
+var shareManagerResource = schema.GroupVersionResource{Group: "longhorn.io", Version: "v1beta2", Resource: "sharemanagers"}
+
+// makeGatedLauncher returns a gated virt-launcher pod created at created,
+// mounting the Longhorn RWX PVC.
+func makeGatedLauncher(created time.Time) *corev1.Pod {
+	pod := makeLauncher("virt-launcher-my-vm-abcde", "my-vm", "vmi-uid", map[string]string{longhorn_cosched.AnnotationKey: "true"})
+	pod.CreationTimestamp = metav1.NewTime(created)
+	pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{{Name: "other.io/gate"}, {Name: longhorn_cosched.StorageReadyGate}}
+	pod.Spec.Volumes = []corev1.Volume{{
+		Name:         "disk",
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: gatePVCName}},
+	}}
+	return pod
+}
+
+// makeShareManager returns a Longhorn ShareManager of the PV owned by node.
+func makeShareManager(node string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("longhorn.io/v1beta2")
+	obj.SetKind("ShareManager")
+	obj.SetNamespace(longhorn_cosched.LonghornNamespace)
+	obj.SetName(gatePVName)
+	obj.Object["status"] = map[string]interface{}{"ownerID": node, "state": "running"}
+	return obj
+}
+
+// newTestGateController returns a GateController over fake clients holding
+// the pod, its bound PVC and the ShareManagers, at the fake clock's time, with
+// its informer started and synced.
+func newTestGateController(t *testing.T, pod *corev1.Pod, clock *clocktesting.FakeClock, shareManagers ...runtime.Object) (*GateController, *fake.Clientset, *dynamicfake.FakeDynamicClient, *events.FakeRecorder) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: gatePVCName, Namespace: testNamespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			VolumeName:  gatePVName,
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	clientset := fake.NewSimpleClientset(pod, pvc)
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		shareManagerResource: "ShareManagerList",
+	}, shareManagers...)
+	recorder := events.NewFakeRecorder(10)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	c, err := NewGateController(clientset, dynClient, factory.Core().V1().Pods(), recorder, GateConfig{Timeout: time.Minute, PollInterval: time.Second})
+	if err != nil {
+		t.Fatalf("NewGateController() error = %v", err)
+	}
+	c.clock = clock
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	return c, clientset, dynClient, recorder
+}
+
+// gates returns the names of the pod's scheduling gates.
+func gates(t *testing.T, clientset *fake.Clientset, name string) []string {
+	t.Helper()
+	pod, err := clientset.CoreV1().Pods(testNamespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting pod: %v", err)
+	}
+	var names []string
+	for _, gate := range pod.Spec.SchedulingGates {
+		names = append(names, gate.Name)
+	}
+	return names
+}
+
+// TestGateRemovedOnceStorageReady walks a gated pod from waiting for its
+// ShareManager to ungated once Longhorn assigns the share-manager a node.
+func TestGateRemovedOnceStorageReady(t *testing.T) {
+	now := time.Now()
+	clock := clocktesting.NewFakeClock(now)
+	pod := makeGatedLauncher(now)
+	c, clientset, dynClient, recorder := newTestGateController(t, pod, clock)
+	key := testNamespace + "/" + pod.Name
+
+	requeue, err := c.reconcile(context.Background(), key)
+	if err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if requeue != time.Second {
+		t.Errorf("requeue = %v, want the poll interval", requeue)
+	}
+	if got := gates(t, clientset, pod.Name); len(got) != 2 {
+		t.Fatalf("gates = %v, want the pod to stay gated", got)
+	}
+
+	if _, err := dynClient.Resource(shareManagerResource).Namespace(longhorn_cosched.LonghornNamespace).
+		Create(context.Background(), makeShareManager("node-2"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating ShareManager: %v", err)
+	}
+	if requeue, err = c.reconcile(context.Background(), key); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if requeue != 0 {
+		t.Errorf("requeue = %v, want none", requeue)
+	}
+	if got := gates(t, clientset, pod.Name); len(got) != 1 || got[0] != "other.io/gate" {
+		t.Errorf("gates = %v, want only the other gate left", got)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Normal StorageReady") {
+			t.Errorf("event = %q, want a StorageReady event", event)
+		}
+	default:
+		t.Error("no event emitted")
+	}
+}
+
+func TestGateRemovedOnTimeout(t *testing.T) {
+	now := time.Now()
+	clock := clocktesting.NewFakeClock(now.Add(50 * time.Second))
+	pod := makeGatedLauncher(now)
+	c, clientset, _, recorder := newTestGateController(t, pod, clock, makeShareManager(""))
+	key := testNamespace + "/" + pod.Name
+
+	requeue, err := c.reconcile(context.Background(), key)
+	if err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if requeue != time.Second {
+		t.Errorf("requeue = %v, want the poll interval", requeue)
+	}
+
+	clock.Step(10 * time.Second)
+	if requeue, err = c.reconcile(context.Background(), key); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if requeue != 0 {
+		t.Errorf("requeue = %v, want none", requeue)
+	}
+	if got := gates(t, clientset, pod.Name); len(got) != 1 || got[0] != "other.io/gate" {
+		t.Errorf("gates = %v, want only the other gate left", got)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning StorageGateTimeout") {
+			t.Errorf("event = %q, want a StorageGateTimeout warning", event)
+		}
+	default:
+		t.Error("no event emitted")
+	}
+}
+
+func TestGateReconcileIgnoresUngatedPods(t *testing.T) {
+	now := time.Now()
+	pod := makeGatedLauncher(now)
+	pod.Spec.SchedulingGates = nil
+	c, clientset, _, _ := newTestGateController(t, pod, clocktesting.NewFakeClock(now.Add(time.Hour)))
+	clientset.ClearActions()
+
+	for _, key := range []string{testNamespace + "/" + pod.Name, testNamespace + "/missing"} {
+		if requeue, err := c.reconcile(context.Background(), key); err != nil || requeue != 0 {
+			t.Errorf("reconcile(%s) = %v, %v, want nothing to do", key, requeue, err)
+		}
+	}
+	if actions := clientset.Actions(); len(actions) != 0 {
+		t.Errorf("actions = %v, want none", actions)
+	}
+}
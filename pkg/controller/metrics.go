@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// metricsSubsystem prefixes the descheduler's metrics, and
+// cleanupMetricsSubsystem those of the Cleaner.
+const (
+	metricsSubsystem        = "kubevirt_scheduler_descheduler"
+	cleanupMetricsSubsystem = "kubevirt_scheduler_cleanup"
+)
+
+// descheduleConsidered counts the running hard-mode VMs whose placement the
+// descheduler checked, once per sweep.
+var descheduleConsidered = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "considered_total",
+		Help:           "Number of running hard-mode virt-launcher pods checked against their share-manager node.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+// descheduleEvicted counts the pods evicted because they ran off their
+// share-manager node.
+var descheduleEvicted = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "evicted_total",
+		Help:           "Number of virt-launcher pods evicted because they ran off their share-manager node.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+// descheduleMigrations counts the VirtualMachineInstanceMigrations created
+// because a VM ran off its share-manager node.
+var descheduleMigrations = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "migrations_total",
+		Help:           "Number of VirtualMachineInstanceMigrations created because a VM ran off its share-manager node.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+// descheduleSkipped counts the checks of pods off their share-manager node
+// that did not move them. The reason label is e.g. "grace-period",
+// "throttled", "dry-run", "disruption-budget" or "cooldown".
+var descheduleSkipped = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "skipped_total",
+		Help:           "Number of checks of virt-launcher pods off their share-manager node that did not move them, by reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"reason"},
+)
+
+// cleanupRemoved counts the stale decision annotations the Cleaner removed.
+// The reason label is "terminated" or "opted-out".
+var cleanupRemoved = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      cleanupMetricsSubsystem,
+		Name:           "removed_total",
+		Help:           "Number of stale co-scheduling decision annotations removed from pods, by reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"reason"},
+)
+
+// cleanupSkipped counts the stale decision annotations the Cleaner left in
+// place. The reason label is "dry-run", "throttled" or "error".
+var cleanupSkipped = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      cleanupMetricsSubsystem,
+		Name:           "skipped_total",
+		Help:           "Number of stale co-scheduling decision annotations left in place, by reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"reason"},
+)
+
+var registerMetricsOnce sync.Once
+
+// registerMetrics registers the controller's metrics with the legacy
+// registry, which the controller serves on /metrics.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(descheduleConsidered, descheduleEvicted, descheduleMigrations, descheduleSkipped, cleanupRemoved, cleanupSkipped)
+	})
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	// DefaultMigrationCooldown is how long after a migration of a VMI is
+	// created no other one is, by default.
+	DefaultMigrationCooldown = 30 * time.Minute
+
+	// FollowStorageLabelKey labels the VirtualMachineInstanceMigrations the
+	// Descheduler created.
+	FollowStorageLabelKey = "scheduler.kubevirt-scheduler.io/follow-storage"
+
+	vmimKind = "VirtualMachineInstanceMigration"
+)
+
+// VMIMResource is KubeVirt's VirtualMachineInstanceMigrations.
+var VMIMResource = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstancemigrations"}
+
+// migratable returns why the VM of the pod, owned by the VMI owner, is not
+// migrated now, or "" if it can be: it was migrated within the cooldown, its
+// evictionStrategy or LiveMigratable condition rule migrations out, or the
+// pod's namespace already has MaxMigrationsPerNamespace migrations in
+// flight.
+func (d *Descheduler) migratable(ctx context.Context, pod *corev1.Pod, owner *metav1.OwnerReference) string {
+	podKey := klog.KObj(pod)
+	if owner == nil {
+		klog.V(4).InfoS("Controller: virt-launcher pod has no VMI owner, not migrating it", "pod", podKey)
+		return skipNotMigratable
+	}
+	if last, ok := d.migrated[owner.UID]; ok && d.clock.Since(last) < d.config.MigrationCooldown {
+		klog.V(4).InfoS("Controller: VM migrated recently, not migrating it again", "pod", podKey, "lastMigration", last)
+		return skipCooldown
+	}
+
+	vmi, err := d.dynClient.Resource(VMIResource).Namespace(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	if err != nil {
+		klog.ErrorS(err, "Controller: error reading VMI", "pod", podKey, "vmi", klog.KRef(pod.Namespace, owner.Name))
+		return skipError
+	}
+	if vmi.GetUID() != owner.UID {
+		return skipNotMigratable
+	}
+	if reason := migrationForbidden(vmi); reason != "" {
+		klog.V(4).InfoS("Controller: VM cannot be live-migrated", "pod", podKey, "reason", reason)
+		return skipNotMigratable
+	}
+
+	list, err := d.dynClient.Resource(VMIMResource).Namespace(pod.Namespace).List(ctx, metav1.ListOptions{LabelSelector: FollowStorageLabelKey + "=true"})
+	if err != nil {
+		klog.ErrorS(err, "Controller: error listing VirtualMachineInstanceMigrations", "namespace", pod.Namespace)
+		return skipError
+	}
+	inFlight := 0
+	for i := range list.Items {
+		if phase, _, _ := unstructured.NestedString(list.Items[i].Object, "status", "phase"); phase != "Succeeded" && phase != "Failed" {
+			inFlight++
+		}
+	}
+	if inFlight >= d.config.MaxMigrationsPerNamespace {
+		klog.V(4).InfoS("Controller: too many migrations in flight in the namespace", "pod", podKey, "inFlight", inFlight)
+		return skipThrottled
+	}
+	return ""
+}
+
+// migrationForbidden returns why the VMI must not be live-migrated, or "" if
+// nothing says so: an evictionStrategy of None or External, or a
+// LiveMigratable condition that is False, e.g. for a VM with a local disk.
+func migrationForbidden(vmi *unstructured.Unstructured) string {
+	switch strategy, _, _ := unstructured.NestedString(vmi.Object, "spec", "evictionStrategy"); strategy {
+	case "None", "External":
+		return "evictionStrategy is " + strategy
+	}
+	conditions, _, _ := unstructured.NestedSlice(vmi.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, ok := condition.(map[string]interface{})
+		if !ok || condition["type"] != "LiveMigratable" || condition["status"] != string(corev1.ConditionFalse) {
+			continue
+		}
+		message, _ := condition["message"].(string)
+		return "not live-migratable: " + message
+	}
+	return ""
+}
+
+// migrate creates a VirtualMachineInstanceMigration of the pod's VMI and
+// returns its name, or why none was created. The migration asks the
+// scheduler to pin its target pod to the share-manager node through the
+// co-schedule-migration-target annotation. It is named after the pod, so the
+// pod is only ever migrated once, even across restarts.
+func (d *Descheduler) migrate(ctx context.Context, pod *corev1.Pod, owner *metav1.OwnerReference) (string, string) {
+	vmim := &unstructured.Unstructured{}
+	vmim.SetAPIVersion(VMIMResource.GroupVersion().String())
+	vmim.SetKind(vmimKind)
+	vmim.SetNamespace(pod.Namespace)
+	vmim.SetName(fmt.Sprintf("%s-follow-storage-%s", owner.Name, pod.UID))
+	vmim.SetLabels(map[string]string{FollowStorageLabelKey: "true"})
+	vmim.SetAnnotations(map[string]string{longhorn_cosched.MigrationTargetAnnotationKey: string(longhorn_cosched.MigrationTargetPolicyHard)})
+	vmim.Object["spec"] = map[string]interface{}{"vmiName": owner.Name}
+
+	_, err := d.dynClient.Resource(VMIMResource).Namespace(pod.Namespace).Create(ctx, vmim, metav1.CreateOptions{})
+	switch {
+	case err == nil:
+		return vmim.GetName(), ""
+	case apierrors.IsAlreadyExists(err):
+		return "", skipMigrating
+	default:
+		klog.ErrorS(err, "Controller: error creating VirtualMachineInstanceMigration", "pod", klog.KObj(pod))
+		return "", skipError
+	}
+}
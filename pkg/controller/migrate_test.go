@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// newMigrateDynamicClient returns a fake dynamic client serving ShareManagers,
+// VMIs and VirtualMachineInstanceMigrations.
+func newMigrateDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		shareManagerResource: "ShareManagerList",
+		VMIResource:          "VirtualMachineInstanceList",
+		VMIMResource:         "VirtualMachineInstanceMigrationList",
+	}, objects...)
+}
+
+// makeFollowStorageVMIM returns a migration the Descheduler created, in the
+// given phase.
+func makeFollowStorageVMIM(name, phase string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("kubevirt.io/v1")
+	obj.SetKind(vmimKind)
+	obj.SetNamespace(testNamespace)
+	obj.SetName(name)
+	obj.SetLabels(map[string]string{FollowStorageLabelKey: "true"})
+	obj.Object["status"] = map[string]interface{}{"phase": phase}
+	return obj
+}
+
+// TestDeschedulerMigrate fails the share-manager of a VM over to another node
+// and checks that, past the grace period, a single migration asking for a
+// hard-pinned target is created.
+func TestDeschedulerMigrate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	clock := clocktesting.NewFakeClock(now)
+
+	vm, pvc := makeRunningVM("vm-1", "node-1", "pvc-1", now)
+	clientset := fake.NewSimpleClientset(vm, pvc)
+	dynClient := newMigrateDynamicClient(makeVolumeShareManager("pvc-1", "node-2"),
+		makeKubeVirtObject(vmiKind, "vm-1", "vm-1-uid", "", nil))
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	recorder := events.NewFakeRecorder(10)
+	d, err := NewDescheduler(clientset, dynClient, factory.Core().V1().Pods(), recorder,
+		DescheduleConfig{GracePeriod: time.Minute, Action: DescheduleMigrate})
+	if err != nil {
+		t.Fatalf("NewDescheduler() error = %v", err)
+	}
+	d.clock = clock
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	migrations, _ := testutil.GetCounterMetricValue(descheduleMigrations)
+
+	d.sweep(ctx)
+	clock.Step(time.Minute)
+	d.sweep(ctx)
+	d.sweep(ctx)
+
+	list, err := dynClient.Resource(VMIMResource).Namespace(testNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing migrations: %v", err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("%d migrations created, want 1", len(list.Items))
+	}
+	vmim := list.Items[0]
+	if got := vmim.GetLabels()[FollowStorageLabelKey]; got != "true" {
+		t.Errorf("label %s = %q, want true", FollowStorageLabelKey, got)
+	}
+	if got := vmim.GetAnnotations()[longhorn_cosched.MigrationTargetAnnotationKey]; got != string(longhorn_cosched.MigrationTargetPolicyHard) {
+		t.Errorf("annotation %s = %q, want hard", longhorn_cosched.MigrationTargetAnnotationKey, got)
+	}
+	if got, _, _ := unstructured.NestedString(vmim.Object, "spec", "vmiName"); got != "vm-1" {
+		t.Errorf("spec.vmiName = %q, want vm-1", got)
+	}
+	if got, _ := testutil.GetCounterMetricValue(descheduleMigrations); got-migrations != 1 {
+		t.Errorf("migrations_total grew by %v, want 1", got-migrations)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("%d events emitted, want 1", len(recorder.Events))
+	}
+}
+
+func TestMigratable(t *testing.T) {
+	now := time.Now()
+	vm, _ := makeRunningVM("vm-1", "node-1", "pvc-1", now)
+	owner := longhorn_cosched.VMIOwner(vm)
+
+	tests := []struct {
+		name     string
+		vmi      func(*unstructured.Unstructured)
+		vmims    []runtime.Object
+		migrated time.Duration
+		noOwner  bool
+		want     string
+	}{
+		{name: "migratable"},
+		{name: "no VMI owner", noOwner: true, want: skipNotMigratable},
+		{name: "migrated within the cooldown", migrated: time.Minute, want: skipCooldown},
+		{name: "migrated before the cooldown", migrated: time.Hour},
+		{
+			name: "evictionStrategy None",
+			vmi: func(vmi *unstructured.Unstructured) {
+				_ = unstructured.SetNestedField(vmi.Object, "None", "spec", "evictionStrategy")
+			},
+			want: skipNotMigratable,
+		},
+		{
+			name: "not live-migratable",
+			vmi: func(vmi *unstructured.Unstructured) {
+				_ = unstructured.SetNestedSlice(vmi.Object, []interface{}{map[string]interface{}{
+					"type": "LiveMigratable", "status": "False", "message": "cannot migrate VMI with a local disk",
+				}}, "status", "conditions")
+			},
+			want: skipNotMigratable,
+		},
+		{
+			name: "VMI recreated",
+			vmi:  func(vmi *unstructured.Unstructured) { vmi.SetUID(types.UID("other-uid")) },
+			want: skipNotMigratable,
+		},
+		{name: "migration in flight", vmims: []runtime.Object{makeFollowStorageVMIM("other", "Running")}, want: skipThrottled},
+		{name: "migrations completed", vmims: []runtime.Object{makeFollowStorageVMIM("a", "Succeeded"), makeFollowStorageVMIM("b", "Failed")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmi := makeKubeVirtObject(vmiKind, "vm-1", "vm-1-uid", "", nil)
+			if tt.vmi != nil {
+				tt.vmi(vmi)
+			}
+			d := &Descheduler{
+				dynClient: newMigrateDynamicClient(append(tt.vmims, vmi)...),
+				config:    DescheduleConfig{MaxMigrationsPerNamespace: 1, MigrationCooldown: 30 * time.Minute},
+				clock:     clocktesting.NewFakeClock(now),
+				migrated:  map[types.UID]time.Time{},
+			}
+			if tt.migrated != 0 {
+				d.migrated[owner.UID] = now.Add(-tt.migrated)
+			}
+			o := owner
+			if tt.noOwner {
+				o = nil
+			}
+			if got := d.migratable(context.Background(), vm, o); got != tt.want {
+				t.Errorf("migratable() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+// Package controller implements controllers for virt-launcher pods outside
+// the scheduler. Controller copies the co-scheduling annotation from
+// VirtualMachineInstances, or the VirtualMachines owning them, onto their
+// virt-launcher pods. KubeVirt only copies the annotations of a
+// VirtualMachine's template, so an annotation set on the VM or VMI itself
+// otherwise has no effect. GateController removes the storage-ready
+// scheduling gate, Descheduler evicts VMs running off their share-manager
+// node, and Cleaner removes stale co-scheduling decision annotations.
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	// PropagatedFromAnnotationKey marks the pods whose co-scheduling
+	// annotation the controller set, with the object it was copied from
+	// ("VirtualMachineInstance/<name>" or "VirtualMachine/<name>"). A
+	// co-scheduling annotation without it was set on the pod explicitly and
+	// is never changed.
+	PropagatedFromAnnotationKey = "scheduler.kubevirt-scheduler.io/co-schedule-propagated-from"
+
+	// LauncherSelector selects KubeVirt's virt-launcher pods.
+	LauncherSelector = "kubevirt.io=virt-launcher"
+
+	vmiKind = "VirtualMachineInstance"
+	vmKind  = "VirtualMachine"
+)
+
+var (
+	// VMIResource and VMResource are KubeVirt's VirtualMachineInstances and
+	// VirtualMachines.
+	VMIResource = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"}
+	VMResource  = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachines"}
+)
+
+// Controller reconciles the co-scheduling annotation of the virt-launcher
+// pods of each VirtualMachineInstance. Its queue is keyed by VMI
+// namespace/name; pod and VM events enqueue the VMI they belong to.
+//
+// The patches race the scheduler: a pod it schedules before its patch lands
+// is placed without the annotation, so propagation only affects the pods
+// scheduled after it, such as later migration targets.
+type Controller struct {
+	clientset kubernetes.Interface
+	pods      corelisters.PodLister
+	vmis      cache.GenericLister
+	vms       cache.GenericLister
+	synced    []cache.InformerSynced
+	queue     workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewController returns a Controller patching pods through clientset. The
+// pod informer should only watch virt-launcher pods (LauncherSelector); vmis
+// and vms are informers of VMIResource and VMResource.
+func NewController(clientset kubernetes.Interface, pods coreinformers.PodInformer, vmis, vms informers.GenericInformer) (*Controller, error) {
+	c := &Controller{
+		clientset: clientset,
+		pods:      pods.Lister(),
+		vmis:      vmis.Lister(),
+		vms:       vms.Lister(),
+		synced:    []cache.InformerSynced{pods.Informer().HasSynced, vmis.Informer().HasSynced, vms.Informer().HasSynced},
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "co-schedule-propagation"}),
+	}
+
+	enqueue := func(obj interface{}) {
+		if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+			c.queue.Add(key)
+		}
+	}
+	if _, err := vmis.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	}); err != nil {
+		return nil, err
+	}
+	// A VMI is named after its VM, so the VM's key is that of its VMI.
+	if _, err := vms.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	}); err != nil {
+		return nil, err
+	}
+	enqueueOwner := func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+		if owner := longhorn_cosched.VMIOwner(pod); owner != nil {
+			c.queue.Add(pod.Namespace + "/" + owner.Name)
+		}
+	}
+	if _, err := pods.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueOwner,
+		UpdateFunc: func(_, obj interface{}) { enqueueOwner(obj) },
+	}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run processes the queue with the given number of workers until ctx is
+// done.
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return fmt.Errorf("waiting for the informer caches to sync: %w", ctx.Err())
+	}
+	klog.InfoS("Controller: propagating co-scheduling annotations", "workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, func(ctx context.Context) {
+			for c.processNextItem(ctx) {
+			}
+		}, time.Second)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.reconcile(ctx, key); err != nil {
+		klog.ErrorS(err, "Controller: error reconciling, retrying", "vmi", key, "retries", c.queue.NumRequeues(key))
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// reconcile brings the co-scheduling annotation of the virt-launcher pods of
+// the VMI with the given key in line with the VMI's, or its VM's if the VMI
+// has none. Pods whose annotation was set explicitly are left alone.
+func (c *Controller) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
+	}
+	obj, err := c.vmis.ByNamespace(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	vmi, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+	value, source, found := c.sourceValue(vmi)
+
+	pods, err := c.pods.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if owner := longhorn_cosched.VMIOwner(pod); owner == nil || owner.UID != vmi.GetUID() {
+			continue
+		}
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		patch := annotationPatch(pod, value, source, found)
+		if patch == nil {
+			continue
+		}
+		_, err := c.clientset.CoreV1().Pods(namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			// A conflict means the pod changed since it was read; the retry
+			// sees the change, e.g. an explicit annotation.
+			return fmt.Errorf("patching pod %s/%s: %w", namespace, pod.Name, err)
+		}
+		klog.V(2).InfoS("Controller: propagated co-scheduling annotation", "pod", klog.KObj(pod), "value", value, "from", source, "removed", !found)
+	}
+	return nil
+}
+
+// sourceValue returns the co-scheduling annotation of the VMI or, if it has
+// none, of the VirtualMachine that owns it, and where it was found.
+func (c *Controller) sourceValue(vmi *unstructured.Unstructured) (value, source string, found bool) {
+	if value, ok := vmi.GetAnnotations()[longhorn_cosched.AnnotationKey]; ok {
+		return value, vmiKind + "/" + vmi.GetName(), true
+	}
+	for _, ref := range vmi.GetOwnerReferences() {
+		if ref.Kind != vmKind || !strings.HasPrefix(ref.APIVersion, VMResource.Group+"/") {
+			continue
+		}
+		obj, err := c.vms.ByNamespace(vmi.GetNamespace()).Get(ref.Name)
+		if err != nil {
+			return "", "", false
+		}
+		vm, ok := obj.(*unstructured.Unstructured)
+		if !ok || vm.GetUID() != ref.UID {
+			return "", "", false
+		}
+		value, ok := vm.GetAnnotations()[longhorn_cosched.AnnotationKey]
+		return value, vmKind + "/" + vm.GetName(), ok
+	}
+	return "", "", false
+}
+
+// annotationPatch returns the merge patch setting the pod's co-scheduling
+// annotation to value, copied from source, or removing the one the
+// controller set if found is false. It returns nil if the pod is up to date
+// or its annotation was set explicitly. The patch carries the pod's
+// resourceVersion, so it fails with a conflict rather than overwrite an
+// annotation set in the meantime.
+func annotationPatch(pod *corev1.Pod, value, source string, found bool) []byte {
+	current, set := pod.Annotations[longhorn_cosched.AnnotationKey]
+	from, propagated := pod.Annotations[PropagatedFromAnnotationKey]
+	if set && !propagated {
+		return nil
+	}
+
+	var annotations map[string]interface{}
+	switch {
+	case found && (!set || current != value || from != source):
+		annotations = map[string]interface{}{longhorn_cosched.AnnotationKey: value, PropagatedFromAnnotationKey: source}
+	case !found && propagated:
+		annotations = map[string]interface{}{longhorn_cosched.AnnotationKey: nil, PropagatedFromAnnotationKey: nil}
+	default:
+		return nil
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": pod.ResourceVersion,
+			"annotations":     annotations,
+		},
+	})
+	return patch
+}
@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const testNamespace = "vms"
+
+// makeKubeVirtObject returns a VirtualMachine or VirtualMachineInstance with
+// the co-scheduling annotation set to value, unless it is empty.
+func makeKubeVirtObject(kind, name, uid, value string, owner *metav1.OwnerReference) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("kubevirt.io/v1")
+	obj.SetKind(kind)
+	obj.SetNamespace(testNamespace)
+	obj.SetName(name)
+	obj.SetUID(types.UID(uid))
+	if value != "" {
+		obj.SetAnnotations(map[string]string{longhorn_cosched.AnnotationKey: value})
+	}
+	if owner != nil {
+		obj.SetOwnerReferences([]metav1.OwnerReference{*owner})
+	}
+	return obj
+}
+
+// makeLauncher returns a virt-launcher pod of the VMI with the given
+// annotations.
+func makeLauncher(name, vmiName, vmiUID string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   testNamespace,
+			Labels:      map[string]string{"kubevirt.io": "virt-launcher"},
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "kubevirt.io/v1",
+				Kind:       vmiKind,
+				Name:       vmiName,
+				UID:        types.UID(vmiUID),
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+}
+
+// newTestController returns a Controller over fake clients holding the
+// objects, with its informers started and synced.
+func newTestController(t *testing.T, pods []runtime.Object, kubevirt ...runtime.Object) (*Controller, *fake.Clientset) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	clientset := fake.NewSimpleClientset(pods...)
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		VMIResource: "VirtualMachineInstanceList",
+		VMResource:  "VirtualMachineList",
+	}, kubevirt...)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	dynFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynClient, 0)
+	c, err := NewController(clientset, factory.Core().V1().Pods(), dynFactory.ForResource(VMIResource), dynFactory.ForResource(VMResource))
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	factory.Start(ctx.Done())
+	dynFactory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	dynFactory.WaitForCacheSync(ctx.Done())
+	return c, clientset
+}
+
+func TestReconcile(t *testing.T) {
+	const (
+		vmiUID = "vmi-uid"
+		vmUID  = "vm-uid"
+	)
+	vmOwner := &metav1.OwnerReference{APIVersion: "kubevirt.io/v1", Kind: vmKind, Name: "my-vm", UID: vmUID}
+	propagated := func(value, from string) map[string]string {
+		return map[string]string{longhorn_cosched.AnnotationKey: value, PropagatedFromAnnotationKey: from}
+	}
+
+	tests := []struct {
+		name    string
+		vmi     *unstructured.Unstructured
+		vm      *unstructured.Unstructured
+		pod     *corev1.Pod
+		wantPod map[string]string
+		patched bool
+	}{
+		{
+			name:    "VMI annotation copied",
+			vmi:     makeKubeVirtObject(vmiKind, "my-vm", vmiUID, "soft", nil),
+			pod:     makeLauncher("virt-launcher-my-vm-abcde", "my-vm", vmiUID, nil),
+			wantPod: propagated("soft", "VirtualMachineInstance/my-vm"),
+			patched: true,
+		},
+		{
+			name:    "VM annotation copied",
+			vmi:     makeKubeVirtObject(vmiKind, "my-vm", vmiUID, "", vmOwner),
+			vm:      makeKubeVirtObject(vmKind, "my-vm", vmUID, "true", nil),
+			pod:     makeLauncher("virt-launcher-my-vm-abcde", "my-vm", vmiUID, map[string]string{"kubevirt.io/domain": "my-vm"}),
+			wantPod: map[string]string{"kubevirt.io/domain": "my-vm", longhorn_cosched.AnnotationKey: "true", PropagatedFromAnnotationKey: "VirtualMachine/my-vm"},
+			patched: true,
+		},
+		{
+			name:    "VMI annotation wins over the VM's",
+			vmi:     makeKubeVirtObject(vmiKind, "my-vm", vmiUID, "soft", vmOwner),
+			vm:      makeKubeVirtObject(vmKind, "my-vm", vmUID, "true", nil),
+			pod:     makeLauncher("virt-launcher-my-vm-abcde", "my-vm", vmiUID, nil),
+			wantPod: propagated("soft", "VirtualMachineInstance/my-vm"),
+			patched: true,
+		},
+		{
+			name:    "explicit pod value kept",
+			vmi:     makeKubeVirtObject(vmiKind, "my-vm", vmiUID, "soft", nil),
+			pod:     makeLauncher("virt-launcher-my-vm-abcde", "my-vm", vmiUID, map[string]string{longhorn_cosched.AnnotationKey: "off"}),
+			wantPod: map[string]string{longhorn_cosched.AnnotationKey: "off"},
+		},
+		{
+			name:    "changed VMI value updated",
+			vmi:     makeKubeVirtObject(vmiKind, "my-vm", vmiUID, "hard", nil),
+			pod:     makeLauncher("virt-launcher-my-vm-abcde", "my-vm", vmiUID, propagated("soft", "VirtualMachineInstance/my-vm")),
+			wantPod: propagated("hard", "VirtualMachineInstance/my-vm"),
+			patched: true,
+		},
+		{
+			name:    "removed VMI annotation removed",
+			vmi:     makeKubeVirtObject(vmiKind, "my-vm", vmiUID, "", nil),
+			pod:     makeLauncher("virt-launcher-my-vm-abcde", "my-vm", vmiUID, propagated("soft", "VirtualMachineInstance/my-vm")),
+			wantPod: map[string]string{},
+			patched: true,
+		},
+		{
+			name:    "up to date",
+			vmi:     makeKubeVirtObject(vmiKind, "my-vm", vmiUID, "soft", nil),
+			pod:     makeLauncher("virt-launcher-my-vm-abcde", "my-vm", vmiUID, propagated("soft", "VirtualMachineInstance/my-vm")),
+			wantPod: propagated("soft", "VirtualMachineInstance/my-vm"),
+		},
+		{
+			name: "pod of an earlier VMI of the same name",
+			vmi:  makeKubeVirtObject(vmiKind, "my-vm", vmiUID, "soft", nil),
+			pod:  makeLauncher("virt-launcher-my-vm-abcde", "my-vm", "old-vmi-uid", nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubevirt := []runtime.Object{tt.vmi}
+			if tt.vm != nil {
+				kubevirt = append(kubevirt, tt.vm)
+			}
+			c, clientset := newTestController(t, []runtime.Object{tt.pod}, kubevirt...)
+			clientset.ClearActions()
+
+			if err := c.reconcile(context.Background(), testNamespace+"/my-vm"); err != nil {
+				t.Fatalf("reconcile() error = %v", err)
+			}
+			patches := 0
+			for _, action := range clientset.Actions() {
+				if action.GetVerb() == "patch" {
+					patches++
+				}
+			}
+			if patched := patches > 0; patched != tt.patched {
+				t.Errorf("pod patched = %v, want %v", patched, tt.patched)
+			}
+			pod, err := clientset.CoreV1().Pods(testNamespace).Get(context.Background(), tt.pod.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting pod: %v", err)
+			}
+			if len(pod.Annotations) != len(tt.wantPod) {
+				t.Fatalf("annotations = %v, want %v", pod.Annotations, tt.wantPod)
+			}
+			for key, want := range tt.wantPod {
+				if got := pod.Annotations[key]; got != want {
+					t.Errorf("annotation %s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestRunPropagatesToNewPods checks that a virt-launcher pod created while the
+// controller runs, e.g. the target of a live migration, gets the annotation.
+func TestRunPropagatesToNewPods(t *testing.T) {
+	c, clientset := newTestController(t, nil, makeKubeVirtObject(vmiKind, "my-vm", "vmi-uid", "soft", nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := c.Run(ctx, 1); err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	}()
+
+	pod := makeLauncher("virt-launcher-my-vm-fghij", "my-vm", "vmi-uid", nil)
+	if _, err := clientset.CoreV1().Pods(testNamespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating pod: %v", err)
+	}
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		pod, err := clientset.CoreV1().Pods(testNamespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return pod.Annotations[longhorn_cosched.AnnotationKey] == "soft", nil
+	})
+	if err != nil {
+		t.Errorf("pod not annotated: %v", err)
+	}
+}
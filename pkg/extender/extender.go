@@ -0,0 +1,296 @@
+// Package extender serves the LonghornCoSchedule plugin as an HTTP scheduler
+// extender, for clusters whose default scheduler can be configured with
+// extenders but not replaced. It runs the plugin in a scheduling framework of
+// its own, over a scheduler cache its informers keep up to date like the
+// scheduler's, so filter and prioritize requests decide exactly like the
+// plugin does in-tree.
+package extender
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/klog/v2"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+	"k8s.io/kubernetes/pkg/scheduler/backend/cache"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// SchedulerName is the name of the profile the extender's framework runs,
+// as reported in events.
+const SchedulerName = "kubevirt-scheduler-extender"
+
+// Extender answers scheduler extender filter and prioritize requests with the
+// LonghornCoSchedule plugin.
+type Extender struct {
+	fwk framework.Framework
+	// cache holds the nodes and the assigned pods, fed by the informers;
+	// snapshot is brought up to date from it for every request.
+	cache    cache.Cache
+	snapshot *cache.Snapshot
+	synced   []func() bool
+
+	// mu serializes requests, as the scheduler does its scheduling cycles:
+	// the snapshot and the cycle state are shared by a whole cycle.
+	mu sync.Mutex
+	// cycle is the state left by the last filter request, for the
+	// prioritize request of the same pod that follows it.
+	cycle *cycle
+}
+
+// cycle is the state of a scheduling cycle of a pod after PreFilter.
+type cycle struct {
+	pod    types.UID
+	state  *framework.CycleState
+	status *framework.Status
+}
+
+// New returns an Extender running the plugin, with the given args, over the
+// clients and the informers of factory. factory must be started, and
+// HasSynced must return true, before requests are answered.
+func New(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, factory informers.SharedInformerFactory, recorder events.EventRecorder, args runtime.Object) (*Extender, error) {
+	e := &Extender{
+		cache:    cache.New(ctx, 0),
+		snapshot: cache.NewEmptySnapshot(),
+	}
+	fwk, err := longhorn_cosched.NewFramework(ctx, SchedulerName, clientset, dynClient, factory, e.snapshot, recorder, args)
+	if err != nil {
+		return nil, err
+	}
+	e.fwk = fwk
+	if err := e.watch(klog.FromContext(ctx), factory); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// watch keeps the cache up to date with the nodes and the pods assigned to
+// them that have not finished, as the scheduler does its own.
+func (e *Extender) watch(logger klog.Logger, factory informers.SharedInformerFactory) error {
+	nodes, err := factory.Core().V1().Nodes().Informer().AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*corev1.Node); ok {
+				e.cache.AddNode(logger, node)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*corev1.Node)
+			newNode, ok2 := newObj.(*corev1.Node)
+			if ok && ok2 {
+				e.cache.UpdateNode(logger, oldNode, newNode)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*corev1.Node); ok {
+				if err := e.cache.RemoveNode(logger, node); err != nil {
+					logger.Error(err, "Extender: removing node from the cache", "node", klog.KObj(node))
+				}
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	pods, err := factory.Core().V1().Pods().Informer().AddEventHandler(toolscache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			pod, ok := obj.(*corev1.Pod)
+			return ok && pod.Spec.NodeName != "" && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+		},
+		Handler: toolscache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				pod := obj.(*corev1.Pod)
+				if err := e.cache.AddPod(logger, pod); err != nil {
+					logger.Error(err, "Extender: adding pod to the cache", "pod", klog.KObj(pod))
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldPod, newPod := oldObj.(*corev1.Pod), newObj.(*corev1.Pod)
+				if err := e.cache.UpdatePod(logger, oldPod, newPod); err != nil {
+					logger.Error(err, "Extender: updating pod in the cache", "pod", klog.KObj(newPod))
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				pod := obj.(*corev1.Pod)
+				if err := e.cache.RemovePod(logger, pod); err != nil {
+					logger.Error(err, "Extender: removing pod from the cache", "pod", klog.KObj(pod))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	e.synced = []func() bool{nodes.HasSynced, pods.HasSynced}
+	return nil
+}
+
+// HasSynced returns true once the node and pod caches have synced.
+func (e *Extender) HasSynced() bool {
+	for _, synced := range e.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter answers a filter request: the nodes of args that pass PreFilter and
+// Filter, and why the others do not. A node the extender's cache does not
+// know yet fails, so the scheduler tries it again in a later cycle.
+func (e *Extender) Filter(ctx context.Context, args *extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	result := &extenderv1.ExtenderFilterResult{
+		FailedNodes:                extenderv1.FailedNodesMap{},
+		FailedAndUnresolvableNodes: extenderv1.FailedNodesMap{},
+	}
+	names, err := e.updateSnapshot(ctx, args)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	c := e.preFilter(ctx, args.Pod)
+	e.cycle = c
+
+	var passed []string
+	for _, name := range names {
+		status := c.status
+		if status.IsSuccess() {
+			nodeInfo, err := e.snapshot.NodeInfos().Get(name)
+			if err != nil {
+				result.FailedNodes[name] = "node not found in the extender's node cache"
+				continue
+			}
+			status = e.fwk.RunFilterPlugins(ctx, c.state, args.Pod, nodeInfo)
+		}
+		switch status.Code() {
+		case framework.Success:
+			passed = append(passed, name)
+		case framework.UnschedulableAndUnresolvable:
+			result.FailedAndUnresolvableNodes[name] = status.Message()
+		case framework.Unschedulable:
+			result.FailedNodes[name] = status.Message()
+		default:
+			result.Error = status.AsError().Error()
+			return result
+		}
+	}
+
+	if args.NodeNames != nil {
+		result.NodeNames = &passed
+		return result
+	}
+	result.Nodes = &corev1.NodeList{}
+	for _, node := range args.Nodes.Items {
+		if _, failed := result.FailedNodes[node.Name]; failed {
+			continue
+		}
+		if _, failed := result.FailedAndUnresolvableNodes[node.Name]; failed {
+			continue
+		}
+		result.Nodes.Items = append(result.Nodes.Items, node)
+	}
+	return result
+}
+
+// Prioritize answers a prioritize request with the plugin's normalized
+// scores, scaled from 0-framework.MaxNodeScore down to
+// 0-extenderv1.MaxExtenderPriority. The scheduler scales them back up, so
+// they are rounded to the nearest multiple of 10. The cycle state of the filter
+// request for the same pod is reused; without one, PreFilter runs again.
+// Nodes score 0 if PreFilter did not succeed, as Score would not run in-tree.
+func (e *Extender) Prioritize(ctx context.Context, args *extenderv1.ExtenderArgs) (extenderv1.HostPriorityList, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	names, err := e.updateSnapshot(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	c := e.cycle
+	e.cycle = nil
+	if c == nil || c.pod != args.Pod.UID {
+		c = e.preFilter(ctx, args.Pod)
+	}
+
+	priorities := make(extenderv1.HostPriorityList, 0, len(names))
+	if !c.status.IsSuccess() {
+		for _, name := range names {
+			priorities = append(priorities, extenderv1.HostPriority{Host: name})
+		}
+		return priorities, nil
+	}
+	var nodeInfos []*framework.NodeInfo
+	for _, name := range names {
+		nodeInfo, err := e.snapshot.NodeInfos().Get(name)
+		if err != nil {
+			priorities = append(priorities, extenderv1.HostPriority{Host: name})
+			continue
+		}
+		nodeInfos = append(nodeInfos, nodeInfo)
+	}
+	scores, status := e.fwk.RunScorePlugins(ctx, c.state, args.Pod, nodeInfos)
+	if !status.IsSuccess() {
+		return nil, status.AsError()
+	}
+	for _, score := range scores {
+		priorities = append(priorities, extenderv1.HostPriority{
+			Host:  score.Name,
+			Score: (score.TotalScore*extenderv1.MaxExtenderPriority + framework.MaxNodeScore/2) / framework.MaxNodeScore,
+		})
+	}
+	return priorities, nil
+}
+
+// preFilter runs PreFilter for the pod in a new cycle state. Skip is turned
+// into success by the framework, which then skips Filter.
+func (e *Extender) preFilter(ctx context.Context, pod *corev1.Pod) *cycle {
+	state := framework.NewCycleState()
+	_, status, _ := e.fwk.RunPreFilterPlugins(ctx, state, pod)
+	return &cycle{pod: pod.UID, state: state, status: status}
+}
+
+// updateSnapshot brings the snapshot up to date with the cache. It returns
+// the names of the nodes of args; nodes the cache does not know are not
+// added to it.
+func (e *Extender) updateSnapshot(ctx context.Context, args *extenderv1.ExtenderArgs) ([]string, error) {
+	if args.Pod == nil {
+		return nil, fmt.Errorf("request has no pod")
+	}
+	if err := e.cache.UpdateSnapshot(klog.FromContext(ctx), e.snapshot); err != nil {
+		return nil, fmt.Errorf("updating snapshot: %w", err)
+	}
+
+	var names []string
+	switch {
+	case args.NodeNames != nil:
+		names = *args.NodeNames
+	case args.Nodes != nil:
+		for _, node := range args.Nodes.Items {
+			names = append(names, node.Name)
+		}
+	}
+	klog.V(5).InfoS("Extender: snapshot updated", "nodes", e.snapshot.NumNodes())
+	return names, nil
+}
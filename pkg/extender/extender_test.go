@@ -0,0 +1,399 @@
+package extender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/events"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+	"k8s.io/kubernetes/pkg/scheduler/backend/cache"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	testNamespace = "vms"
+	testPVCName   = "disk"
+	testPVName    = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+)
+
+var testNodes = []string{"node-1", "node-2", "node-3"}
+
+// testCase is a cluster and a pod to schedule in it, with the decision both
+// the in-tree plugin and the extender must reach.
+type testCase struct {
+	name string
+	// mode is the pod's co-schedule annotation, or empty for none.
+	mode string
+	// shareManagerNode is the node of the PVC's share-manager pod, or empty
+	// for none.
+	shareManagerNode string
+	// coScheduledNode is the node of another hard-mode VM, or empty for
+	// none.
+	coScheduledNode string
+	cordoned        string
+	args            longhorn_cosched.Args
+
+	// wantPassed are the nodes passing Filter and wantScores their scores,
+	// in plugin units (0-100).
+	wantPassed []string
+	wantScores map[string]int64
+}
+
+var testCases = []testCase{
+	{
+		name:             "hard mode pins to the share-manager node",
+		mode:             "true",
+		shareManagerNode: "node-2",
+		wantPassed:       []string{"node-2"},
+		wantScores:       map[string]int64{"node-2": 100},
+	},
+	{
+		name:             "soft mode prefers the share-manager node",
+		mode:             "soft",
+		shareManagerNode: "node-2",
+		wantPassed:       testNodes,
+		wantScores:       map[string]int64{"node-1": 0, "node-2": 100, "node-3": 0},
+	},
+	{
+		name:             "not opted in",
+		shareManagerNode: "node-2",
+		wantPassed:       testNodes,
+		wantScores:       map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+	},
+	{
+		name:             "observe mode",
+		mode:             "observe",
+		shareManagerNode: "node-2",
+		wantPassed:       testNodes,
+		wantScores:       map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+	},
+	{
+		name:       "hard mode without a share-manager",
+		mode:       "hard",
+		wantPassed: testNodes,
+		wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+	},
+	{
+		name:             "share-manager node cordoned, pinned",
+		mode:             "hard",
+		shareManagerNode: "node-2",
+		cordoned:         "node-2",
+		wantPassed:       []string{"node-2"},
+		wantScores:       map[string]int64{"node-2": 100},
+	},
+	{
+		name:             "share-manager node cordoned, soft fallback",
+		mode:             "hard",
+		shareManagerNode: "node-2",
+		cordoned:         "node-2",
+		args:             longhorn_cosched.Args{CordonedNodePolicy: longhorn_cosched.CordonedNodePolicySoft},
+		wantPassed:       testNodes,
+		wantScores:       map[string]int64{"node-1": 0, "node-2": 100, "node-3": 0},
+	},
+	{
+		name:             "soft mode, penalised for a co-scheduled VM",
+		mode:             "soft",
+		shareManagerNode: "node-2",
+		coScheduledNode:  "node-2",
+		args:             longhorn_cosched.Args{PinnedVMPenalty: 13},
+		wantPassed:       testNodes,
+		wantScores:       map[string]int64{"node-1": 0, "node-2": 87, "node-3": 0},
+	},
+}
+
+// objects returns the nodes, the pod, its PVC and the share-manager pod of
+// the test case.
+func (tc testCase) objects() (*corev1.Pod, []*corev1.Node, []runtime.Object) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "virt-launcher-vm", Namespace: testNamespace, UID: "vm-uid"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         testPVCName,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: testPVCName}},
+		}}},
+	}
+	if tc.mode != "" {
+		pod.Annotations = map[string]string{longhorn_cosched.AnnotationKey: tc.mode}
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: testPVCName, Namespace: testNamespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			VolumeName:  testPVName,
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	objects := []runtime.Object{pod, pvc}
+	var nodes []*corev1.Node
+	for _, name := range testNodes {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.NodeSpec{Unschedulable: name == tc.cordoned},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4"), corev1.ResourcePods: resource.MustParse("110")},
+				Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		nodes = append(nodes, node)
+		objects = append(objects, node)
+	}
+	if tc.shareManagerNode != "" {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      longhorn_cosched.ShareManagerPrefix + testPVName,
+				Namespace: longhorn_cosched.LonghornNamespace,
+				Labels:    map[string]string{longhorn_cosched.ShareManagerLabel: testPVName},
+			},
+			Spec:   corev1.PodSpec{NodeName: tc.shareManagerNode},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+	if tc.coScheduledNode != "" {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "virt-launcher-other",
+				Namespace:   testNamespace,
+				UID:         "other-uid",
+				Annotations: map[string]string{longhorn_cosched.AnnotationKey: "true"},
+			},
+			Spec:   corev1.PodSpec{NodeName: tc.coScheduledNode},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+	return pod, nodes, objects
+}
+
+// newClients returns fake clients holding the objects, and a started and
+// synced informer factory over them.
+func newClients(t *testing.T, objects []runtime.Object) (*fake.Clientset, *dynamicfake.FakeDynamicClient, informers.SharedInformerFactory) {
+	t.Helper()
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "longhorn.io/v1beta2",
+		APIResources: []metav1.APIResource{{Name: "sharemanagers", Namespaced: true, Kind: "ShareManager"}},
+	}}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "sharemanagers"}:               "ShareManagerList",
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "nodes"}:                       "NodeList",
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "replicas"}:                    "ReplicaList",
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "volumes"}:                     "VolumeList",
+		{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstancemigrations"}: "VirtualMachineInstanceMigrationList",
+	})
+	return clientset, dynClient, informers.NewSharedInformerFactory(clientset, 0)
+}
+
+// pluginArgs encodes the args the way the scheduler passes them to plugins.
+func pluginArgs(t *testing.T, args longhorn_cosched.Args) runtime.Object {
+	t.Helper()
+	raw, err := json.Marshal(args)
+	if err != nil {
+		t.Fatalf("encoding args: %v", err)
+	}
+	return &runtime.Unknown{Raw: raw, ContentType: runtime.ContentTypeJSON}
+}
+
+// inTree runs a scheduling cycle of the test case's pod through the plugin
+// in a framework of its own over a snapshot of the test case's cluster,
+// returning the nodes passing Filter and their scores.
+func inTree(t *testing.T, tc testCase) ([]string, map[string]int64) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	pod, nodes, objects := tc.objects()
+	clientset, dynClient, factory := newClients(t, objects)
+	var assigned []*corev1.Pod
+	for _, obj := range objects {
+		if p, ok := obj.(*corev1.Pod); ok && p.Spec.NodeName != "" {
+			assigned = append(assigned, p)
+		}
+	}
+	snapshot := cache.NewSnapshot(assigned, nodes)
+	fwk, err := longhorn_cosched.NewFramework(ctx, "kubevirt-scheduler", clientset, dynClient, factory, snapshot, &events.FakeRecorder{}, pluginArgs(t, tc.args))
+	if err != nil {
+		t.Fatalf("NewFramework() error = %v", err)
+	}
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	state := framework.NewCycleState()
+	if _, status, _ := fwk.RunPreFilterPlugins(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status)
+	}
+	var passed []string
+	var feasible []*framework.NodeInfo
+	for _, node := range nodes {
+		nodeInfo, err := snapshot.NodeInfos().Get(node.Name)
+		if err != nil {
+			t.Fatalf("getting node %s: %v", node.Name, err)
+		}
+		if status := fwk.RunFilterPlugins(ctx, state, pod, nodeInfo); status.IsSuccess() {
+			passed = append(passed, node.Name)
+			feasible = append(feasible, nodeInfo)
+		}
+	}
+	scores, status := fwk.RunScorePlugins(ctx, state, pod, feasible)
+	if !status.IsSuccess() {
+		t.Fatalf("Score: %v", status)
+	}
+	byNode := map[string]int64{}
+	for _, score := range scores {
+		byNode[score.Name] = score.TotalScore
+	}
+	return passed, byNode
+}
+
+// viaExtender sends the scheduler's filter and prioritize requests for the
+// test case's pod to the extender over HTTP, with node names only if
+// nodeCacheCapable, and returns the nodes passing and their scores.
+func viaExtender(t *testing.T, tc testCase, nodeCacheCapable bool) ([]string, map[string]int64) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	pod, nodes, objects := tc.objects()
+	clientset, dynClient, factory := newClients(t, objects)
+	e, err := New(ctx, clientset, dynClient, factory, &events.FakeRecorder{}, pluginArgs(t, tc.args))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	server := httptest.NewServer(e.Handler(nil))
+	t.Cleanup(server.Close)
+
+	post := func(path string, args *extenderv1.ExtenderArgs, into interface{}) {
+		t.Helper()
+		body, err := json.Marshal(args)
+		if err != nil {
+			t.Fatalf("encoding ExtenderArgs: %v", err)
+		}
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s: status %s", path, resp.Status)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+			t.Fatalf("decoding %s response: %v", path, err)
+		}
+	}
+	args := func(names []string) *extenderv1.ExtenderArgs {
+		if nodeCacheCapable {
+			return &extenderv1.ExtenderArgs{Pod: pod, NodeNames: &names}
+		}
+		list := &corev1.NodeList{}
+		for _, node := range nodes {
+			if slices.Contains(names, node.Name) {
+				list.Items = append(list.Items, *node)
+			}
+		}
+		return &extenderv1.ExtenderArgs{Pod: pod, Nodes: list}
+	}
+
+	var filtered extenderv1.ExtenderFilterResult
+	post("/filter", args(testNodes), &filtered)
+	if filtered.Error != "" {
+		t.Fatalf("filter error: %s", filtered.Error)
+	}
+	var passed []string
+	if nodeCacheCapable {
+		passed = *filtered.NodeNames
+	} else {
+		for _, node := range filtered.Nodes.Items {
+			passed = append(passed, node.Name)
+		}
+	}
+	for _, name := range testNodes {
+		_, failed := filtered.FailedNodes[name]
+		_, unresolvable := filtered.FailedAndUnresolvableNodes[name]
+		if slices.Contains(passed, name) == (failed || unresolvable) {
+			t.Errorf("node %s passed = %v, failed = %v, unresolvable = %v", name, slices.Contains(passed, name), failed, unresolvable)
+		}
+	}
+
+	var priorities extenderv1.HostPriorityList
+	post("/prioritize", args(passed), &priorities)
+	scores := map[string]int64{}
+	for _, priority := range priorities {
+		scores[priority.Host] = priority.Score
+	}
+	return passed, scores
+}
+
+// TestExtenderMatchesPlugin runs each test case through the plugin directly
+// and through the extender, with and without nodeCacheCapable, and checks
+// that all reach the expected decision. The extender's scores must be the
+// nearest extender priority to the plugin's.
+func TestExtenderMatchesPlugin(t *testing.T) {
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			paths := []struct {
+				name string
+				// unit is the plugin score one point of the path's scores
+				// stands for.
+				unit int64
+				run  func() ([]string, map[string]int64)
+			}{
+				{"in-tree", 1, func() ([]string, map[string]int64) { return inTree(t, tc) }},
+				{"extender", 10, func() ([]string, map[string]int64) { return viaExtender(t, tc, false) }},
+				{"extender with node cache", 10, func() ([]string, map[string]int64) { return viaExtender(t, tc, true) }},
+			}
+			for _, path := range paths {
+				passed, scores := path.run()
+				if !slices.Equal(passed, tc.wantPassed) {
+					t.Errorf("%s: nodes passing = %v, want %v", path.name, passed, tc.wantPassed)
+				}
+				for node, want := range tc.wantScores {
+					if diff := scores[node]*path.unit - want; 2*diff > path.unit || -2*diff >= path.unit {
+						t.Errorf("%s: score of %s = %d, want the nearest to %d (scores %v)", path.name, node, scores[node], want, scores)
+					}
+				}
+				if len(scores) != len(tc.wantScores) {
+					t.Errorf("%s: scores = %v, want %v", path.name, scores, tc.wantScores)
+				}
+			}
+		})
+	}
+}
+
+// TestPrioritizeWithoutFilter checks that a prioritize request not preceded
+// by a filter request for the pod runs PreFilter itself.
+func TestPrioritizeWithoutFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tc := testCases[1]
+	pod, _, objects := tc.objects()
+	clientset, dynClient, factory := newClients(t, objects)
+	e, err := New(ctx, clientset, dynClient, factory, &events.FakeRecorder{}, pluginArgs(t, tc.args))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	names := testNodes
+	priorities, err := e.Prioritize(ctx, &extenderv1.ExtenderArgs{Pod: pod, NodeNames: &names})
+	if err != nil {
+		t.Fatalf("Prioritize() error = %v", err)
+	}
+	for _, priority := range priorities {
+		if want := tc.wantScores[priority.Host] / 10; priority.Score != want {
+			t.Errorf("score of %s = %d, want %d", priority.Host, priority.Score, want)
+		}
+	}
+}
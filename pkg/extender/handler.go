@@ -0,0 +1,112 @@
+package extender
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// maxRequestBytes bounds the ExtenderArgs bodies read. Without
+// nodeCacheCapable the scheduler sends every node object, so this is more
+// than the API server's object limit.
+const maxRequestBytes = 32 << 20
+
+// Handler returns the extender's HTTP handler: ExtenderArgs are answered on
+// /filter and /prioritize, and /healthz and /readyz report whether the server
+// is up. /readyz fails until the caches have synced and while ready returns
+// an error, e.g. until a serving certificate has been loaded; ready may be
+// nil.
+func (e *Extender) Handler(ready func() error) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/filter", e.serve(func(r *http.Request, args *extenderv1.ExtenderArgs) (interface{}, int, error) {
+		result := e.Filter(r.Context(), args)
+		passed := 0
+		switch {
+		case result.NodeNames != nil:
+			passed = len(*result.NodeNames)
+		case result.Nodes != nil:
+			passed = len(result.Nodes.Items)
+		}
+		return result, passed, nil
+	}))
+	mux.HandleFunc("/prioritize", e.serve(func(r *http.Request, args *extenderv1.ExtenderArgs) (interface{}, int, error) {
+		priorities, err := e.Prioritize(r.Context(), args)
+		return priorities, len(priorities), err
+	}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !e.HasSynced() {
+			http.Error(w, "caches not synced", http.StatusServiceUnavailable)
+			return
+		}
+		if ready != nil {
+			if err := ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprint(w, "ok")
+	})
+	return mux
+}
+
+// serve returns a handler decoding ExtenderArgs, answering them with answer
+// and logging each request with the number of nodes answer returns. An error
+// is answered with status 500, which fails the scheduling cycle unless the
+// extender is configured as ignorable, as a plugin error does in-tree.
+func (e *Extender) serve(answer func(*http.Request, *extenderv1.ExtenderArgs) (interface{}, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !e.HasSynced() {
+			http.Error(w, "caches not synced", http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("reading request: %v", err), http.StatusBadRequest)
+			return
+		}
+		args := &extenderv1.ExtenderArgs{}
+		if err := json.Unmarshal(body, args); err != nil || args.Pod == nil {
+			http.Error(w, "request body is not ExtenderArgs", http.StatusBadRequest)
+			return
+		}
+
+		response, nodes, err := answer(r, args)
+		if err != nil {
+			klog.ErrorS(err, "Extender: error answering request", "path", r.URL.Path, "pod", klog.KObj(args.Pod))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			klog.ErrorS(err, "Extender: error writing response", "path", r.URL.Path, "pod", klog.KObj(args.Pod))
+			return
+		}
+		requested := 0
+		switch {
+		case args.NodeNames != nil:
+			requested = len(*args.NodeNames)
+		case args.Nodes != nil:
+			requested = len(args.Nodes.Items)
+		}
+		klog.V(4).InfoS("Extender: answered request",
+			"path", r.URL.Path,
+			"pod", klog.KObj(args.Pod),
+			"nodes", requested,
+			"answered", nodes,
+			"duration", time.Since(start),
+		)
+	}
+}
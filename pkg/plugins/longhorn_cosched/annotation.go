@@ -0,0 +1,106 @@
+package longhorn_cosched
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// invalidAnnotationReason is the reason of the event emitted on a pod
+	// whose annotation has a value the plugin cannot parse.
+	invalidAnnotationReason = "InvalidAnnotationValue"
+
+	// invalidAnnotationWarningInterval is how long the plugin waits before
+	// warning about the same pod again. A pod is retried every few seconds
+	// while it is unschedulable; it should not collect an event every time.
+	invalidAnnotationWarningInterval = time.Hour
+
+	// acceptedModeValues lists the values ParseMode accepts, for events.
+	acceptedModeValues = `"true", "hard", "soft", "observe", "false" or "off"`
+)
+
+// InvalidAnnotation returns the key, value and accepted values of the first
+// plugin annotation whose value cannot be parsed, or an empty key if there is
+// none. The validating webhook checks pods and VirtualMachines with it.
+func InvalidAnnotation(annotations map[string]string) (key, value, accepted string) {
+	if value, ok := annotations[AnnotationKey]; ok {
+		if _, valid := ParseMode(value); !valid {
+			return AnnotationKey, value, acceptedModeValues
+		}
+	}
+	if value, ok := annotations[CoScheduleWithAnnotationKey]; ok {
+		if _, _, err := parseCoScheduleWith(value); err != nil {
+			return CoScheduleWithAnnotationKey, value, `"<namespace>/<label selector>"`
+		}
+	}
+	if value, ok := annotations[MigrationTargetAnnotationKey]; ok && !validMigrationTargetPolicy(MigrationTargetPolicy(value)) {
+		return MigrationTargetAnnotationKey, value, `"skip", "soft" or "hard"`
+	}
+	for _, key := range []string{ExemptAnnotationKey, WaitForStorageAnnotationKey, AllowRelocationAnnotationKey} {
+		value, ok := annotations[key]
+		if !ok {
+			continue
+		}
+		if _, err := strconv.ParseBool(value); err != nil {
+			return key, value, `"true" or "false"`
+		}
+	}
+	return "", "", ""
+}
+
+// warningLimiter remembers which pods were warned about recently so each pod
+// gets one event per interval. Keys are pod UIDs, or a pod UID combined with
+// what the event is about.
+type warningLimiter struct {
+	clock    clock.PassiveClock
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newWarningLimiter(c clock.PassiveClock, interval time.Duration) *warningLimiter {
+	return &warningLimiter{clock: c, interval: interval, last: map[string]time.Time{}}
+}
+
+// allow records a warning under the given key and returns true, unless there
+// was one less than the interval ago. Keys warned about longer ago are
+// forgotten, so deleted pods do not accumulate.
+func (l *warningLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+	for id, last := range l.last {
+		if now.Sub(last) >= l.interval {
+			delete(l.last, id)
+		}
+	}
+	l.last[key] = now
+	return true
+}
+
+// reportInvalidAnnotation emits a warning event on the pod if one of its
+// plugin annotations has a value that cannot be parsed, e.g. "yes", which
+// otherwise silently leaves co-scheduling off.
+func (p *Plugin) reportInvalidAnnotation(pod *corev1.Pod) {
+	key, value, accepted := InvalidAnnotation(pod.Annotations)
+	if key == "" || p.handle == nil || p.warnings == nil || !p.warnings.allow(string(pod.UID)) {
+		return
+	}
+	klog.V(2).InfoS("LonghornCoSchedule: ignoring invalid annotation value",
+		"pod", klog.KObj(pod),
+		"annotation", key,
+		"value", value,
+	)
+	p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, invalidAnnotationReason, "Schedule",
+		"Annotation %s has invalid value %q and is ignored; use %s", key, value, accepted)
+}
@@ -0,0 +1,89 @@
+package longhorn_cosched
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestInvalidAnnotationValue checks that a pod with an unparseable annotation
+// value gets one warning event naming the value, however often it is retried,
+// and that accepted values get none.
+func TestInvalidAnnotationValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		value     string
+		wantEvent bool
+	}{
+		{name: "co-schedule True", key: AnnotationKey, value: "True"},
+		{name: "co-schedule 0", key: AnnotationKey, value: "0"},
+		{name: "co-schedule yes", key: AnnotationKey, value: "yes", wantEvent: true},
+		{name: "co-schedule empty", key: AnnotationKey, value: "", wantEvent: true},
+		{name: "co-schedule off", key: AnnotationKey, value: OptOutValue},
+		{name: "exempt yes", key: ExemptAnnotationKey, value: "yes", wantEvent: true},
+		{name: "wait-for-storage 1", key: WaitForStorageAnnotationKey, value: "1"},
+		{name: "wait-for-storage hard", key: WaitForStorageAnnotationKey, value: "hard", wantEvent: true},
+		{name: "allow-relocation yes", key: AllowRelocationAnnotationKey, value: "yes", wantEvent: true},
+		{name: "migration target soft", key: MigrationTargetAnnotationKey, value: "soft"},
+		{name: "migration target always", key: MigrationTargetAnnotationKey, value: "always", wantEvent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", true)
+			pod.UID = types.UID("vm-uid")
+			pod.Annotations[tt.key] = tt.value
+
+			recorder := events.NewFakeRecorder(10)
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{makeNode("node-1", "4")},
+				objects:  []runtime.Object{pod},
+				recorder: recorder,
+			})
+			runFilters(t, fwk, pod)
+			runFilters(t, fwk, pod)
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				got = append(got, <-recorder.Events)
+			}
+			wantEvents := 0
+			if tt.wantEvent {
+				wantEvents = 1
+			}
+			if len(got) != wantEvents {
+				t.Fatalf("%d events emitted, want %d: %v", len(got), wantEvents, got)
+			}
+			if tt.wantEvent && (!hasEvent(got, invalidAnnotationReason) || !strings.Contains(got[0], tt.key)) {
+				t.Errorf("event %q should have reason %s and name %s", got[0], invalidAnnotationReason, tt.key)
+			}
+		})
+	}
+}
+
+func TestWarningLimiter(t *testing.T) {
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+	l := newWarningLimiter(fakeClock, time.Hour)
+
+	if !l.allow("a") || !l.allow("b") {
+		t.Fatal("allow() = false for the first warning about a pod")
+	}
+	if l.allow("a") {
+		t.Error("allow() = true for a second warning within the interval")
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(time.Hour))
+	if !l.allow("a") {
+		t.Error("allow() = false after the interval")
+	}
+	if _, ok := l.last["b"]; ok {
+		t.Error("pod b not forgotten after the interval")
+	}
+}
@@ -0,0 +1,885 @@
+package longhorn_cosched
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// ConflictPolicy selects how the plugin resolves a pod whose RWX PVCs have
+// share-managers on different nodes.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyFirst uses the node of the first PVC, in the order the
+	// pod's volumes are listed. This is the default.
+	ConflictPolicyFirst ConflictPolicy = "first"
+
+	// ConflictPolicyMostVolumes uses the node hosting the most of the pod's
+	// share-managers. Ties go to the node that appears first.
+	ConflictPolicyMostVolumes ConflictPolicy = "mostVolumes"
+
+	// ConflictPolicyFail makes the pod unschedulable until its share-managers
+	// are on the same node.
+	ConflictPolicyFail ConflictPolicy = "fail"
+
+	// ConflictPolicyScoreOnly disables the hard filter and only scores nodes
+	// by the number of the pod's share-managers they host.
+	ConflictPolicyScoreOnly ConflictPolicy = "scoreOnly"
+)
+
+// DataVolumePolicy selects how the plugin treats pods whose PVCs are still
+// being created or populated by CDI for a DataVolume.
+type DataVolumePolicy string
+
+const (
+	// DataVolumePolicyAllowAll ignores PVCs that are still being populated;
+	// they have no share-manager yet, so they do not restrict the pod. This
+	// is the default.
+	DataVolumePolicyAllowAll DataVolumePolicy = "allowAll"
+
+	// DataVolumePolicyWait makes the pod unschedulable until every PVC it
+	// references exists and CDI has finished populating it. The pod is
+	// retried when one of its PVCs changes.
+	DataVolumePolicyWait DataVolumePolicy = "wait"
+)
+
+// UnboundPVCPolicy selects how the plugin treats a hard-mode pod with an RWX
+// Longhorn PVC that is not bound yet, e.g. because its StorageClass uses
+// WaitForFirstConsumer binding.
+type UnboundPVCPolicy string
+
+const (
+	// UnboundPVCPolicyAllow ignores unbound PVCs; they have no share-manager
+	// yet, so they do not restrict the pod. This is the default.
+	UnboundPVCPolicyAllow UnboundPVCPolicy = "allow"
+
+	// UnboundPVCPolicyWait makes the pod unschedulable until every RWX
+	// Longhorn PVC it references is bound. The pod is retried when one of
+	// its PVCs changes.
+	UnboundPVCPolicyWait UnboundPVCPolicy = "wait"
+)
+
+// CordonedNodePolicy selects how the plugin treats a hard-mode pod whose
+// share-manager node is cordoned.
+type CordonedNodePolicy string
+
+const (
+	// CordonedNodePolicyPin keeps the pod pinned to the cordoned node and
+	// emits a warning event on it. The pod stays Pending until the node is
+	// uncordoned or the share-manager moves. This is the default.
+	CordonedNodePolicyPin CordonedNodePolicy = "pin"
+
+	// CordonedNodePolicySoft lets every node pass the filter while the
+	// share-manager node is cordoned; the share-manager node is still
+	// preferred in Score.
+	CordonedNodePolicySoft CordonedNodePolicy = "soft"
+)
+
+// InsufficientResourcesPolicy selects how the plugin treats a hard-mode pod
+// whose share-manager node does not have enough free resources for it.
+type InsufficientResourcesPolicy string
+
+const (
+	// InsufficientResourcesPolicyPin keeps the pod pinned to the node, so
+	// PostFilter can preempt lower-priority pods there or relocate the
+	// share-manager. This is the default.
+	InsufficientResourcesPolicyPin InsufficientResourcesPolicy = "pin"
+
+	// InsufficientResourcesPolicySoft lets every node pass the filter for
+	// the cycle and emits a warning event; the share-manager node is still
+	// preferred in Score.
+	InsufficientResourcesPolicySoft InsufficientResourcesPolicy = "soft"
+)
+
+// TaintConflictPolicy selects how the plugin treats a hard-mode pod that does
+// not tolerate a NoSchedule or NoExecute taint of its share-manager node.
+type TaintConflictPolicy string
+
+const (
+	// TaintConflictPolicyReject keeps the pod pinned and rejects every node
+	// with a status message naming the taint. This is the default.
+	TaintConflictPolicyReject TaintConflictPolicy = "reject"
+
+	// TaintConflictPolicySoft lets every node pass the filter for the cycle
+	// and emits a warning event; the share-manager node is still preferred
+	// in Score.
+	TaintConflictPolicySoft TaintConflictPolicy = "soft"
+)
+
+// NodeAffinityConflictPolicy selects how the plugin treats a hard-mode pod
+// whose nodeSelector or required node affinity does not match its
+// share-manager node.
+type NodeAffinityConflictPolicy string
+
+const (
+	// NodeAffinityConflictPolicyReject keeps the pod pinned and rejects
+	// every node with a status message naming the share-manager node and the
+	// selector it does not match. This is the default.
+	NodeAffinityConflictPolicyReject NodeAffinityConflictPolicy = "reject"
+
+	// NodeAffinityConflictPolicySoft lets every node pass the filter for the
+	// cycle and emits a warning event; the share-manager node is still
+	// preferred in Score.
+	NodeAffinityConflictPolicySoft NodeAffinityConflictPolicy = "soft"
+)
+
+// PVCLookupErrorPolicy selects how the plugin treats a hard-mode pod when
+// one of its PVCs cannot be read, e.g. because of RBAC or an API timeout.
+type PVCLookupErrorPolicy string
+
+const (
+	// PVCLookupErrorPolicyError fails the scheduling cycle with an error;
+	// the pod is retried with backoff. This is the default.
+	PVCLookupErrorPolicyError PVCLookupErrorPolicy = "error"
+
+	// PVCLookupErrorPolicyAllowAll treats the pod as if no share-manager
+	// was found, so it schedules freely.
+	PVCLookupErrorPolicyAllowAll PVCLookupErrorPolicy = "allowAll"
+)
+
+// MigrationTargetPolicy selects how the plugin treats an opted-in pod that
+// is the target of a KubeVirt live migration.
+type MigrationTargetPolicy string
+
+const (
+	// MigrationTargetPolicySkip leaves migration targets to the KubeVirt
+	// migration controller; the plugin is a no-op for them. This is the
+	// default.
+	MigrationTargetPolicySkip MigrationTargetPolicy = "skip"
+
+	// MigrationTargetPolicySoft only prefers the share-manager node in
+	// Score, even for hard-mode pods.
+	MigrationTargetPolicySoft MigrationTargetPolicy = "soft"
+
+	// MigrationTargetPolicyHard co-schedules migration targets in the pod's
+	// own mode, except that the migration source node is always rejected;
+	// if the share-manager runs there, the target is not pinned. PostFilter
+	// never preempts for migration targets.
+	MigrationTargetPolicyHard MigrationTargetPolicy = "hard"
+)
+
+// LookupSource is a source the plugin asks for the node of a PVC's
+// share-manager.
+type LookupSource string
+
+const (
+	// LookupSourceShareManager reads status.ownerID of the ShareManager.
+	LookupSourceShareManager LookupSource = "shareManager"
+
+	// LookupSourcePod reads the node of the share-manager pod.
+	LookupSourcePod LookupSource = "pod"
+
+	// LookupSourceVolume reads status.currentNodeID of the Longhorn Volume,
+	// the node the volume is attached to. It is empty while the volume is
+	// detached.
+	LookupSourceVolume LookupSource = "volume"
+
+	// LookupSourceEndpoints reads the node of the endpoint in the
+	// EndpointSlices of the share-manager's Service, for clusters where
+	// pods in the Longhorn namespace cannot be read. It is not part of
+	// DefaultLookupOrder.
+	LookupSourceEndpoints LookupSource = "endpoints"
+)
+
+// SourceDisagreementPolicy selects which node wins when the ShareManager CRD
+// and the share-manager pod name different nodes, e.g. after a failover
+// Longhorn has not recorded yet.
+type SourceDisagreementPolicy string
+
+const (
+	// SourceDisagreementPolicyCRD prefers the ShareManager's
+	// status.ownerID.
+	SourceDisagreementPolicyCRD SourceDisagreementPolicy = "crd"
+
+	// SourceDisagreementPolicyPod prefers the node of the share-manager
+	// pod.
+	SourceDisagreementPolicyPod SourceDisagreementPolicy = "pod"
+
+	// SourceDisagreementPolicyNewest prefers whichever of the ShareManager
+	// and the share-manager pod was written last.
+	SourceDisagreementPolicyNewest SourceDisagreementPolicy = "newest"
+)
+
+// DefaultLookupOrder is the order in which the lookup sources are asked when
+// Args.LookupOrder is unset.
+var DefaultLookupOrder = []LookupSource{LookupSourceShareManager, LookupSourcePod, LookupSourceVolume}
+
+// LonghornNodePolicy selects how the plugin treats nodes that Longhorn
+// considers unschedulable for storage: spec.allowScheduling is false, or the
+// Ready or Schedulable condition of the Longhorn Node is not True.
+type LonghornNodePolicy string
+
+const (
+	// LonghornNodePolicyIgnore does not read Longhorn Nodes. This is the
+	// default.
+	LonghornNodePolicyIgnore LonghornNodePolicy = "ignore"
+
+	// LonghornNodePolicyScore gives such nodes a score of 0 for opted-in
+	// pods.
+	LonghornNodePolicyScore LonghornNodePolicy = "score"
+
+	// LonghornNodePolicyFilter also rejects such nodes in Filter, for
+	// opted-in pods in either mode. A hard-mode pod is not pinned to such a
+	// share-manager node; the hard filter is relaxed instead.
+	LonghornNodePolicyFilter LonghornNodePolicy = "filter"
+)
+
+// VolumeNodeTagPolicy selects how the plugin treats nodes whose Longhorn
+// Node lacks one of the node tags the pod's Longhorn Volumes select
+// (spec.nodeSelector).
+type VolumeNodeTagPolicy string
+
+const (
+	// VolumeNodeTagPolicyIgnore does not read volume node tags. This is the
+	// default.
+	VolumeNodeTagPolicyIgnore VolumeNodeTagPolicy = "ignore"
+
+	// VolumeNodeTagPolicyScore gives such nodes a score of 0 for opted-in
+	// pods.
+	VolumeNodeTagPolicyScore VolumeNodeTagPolicy = "score"
+
+	// VolumeNodeTagPolicyFilter also rejects such nodes in Filter for
+	// hard-mode pods. A hard-mode pod is not pinned to such a share-manager
+	// node; the hard filter is relaxed instead.
+	VolumeNodeTagPolicyFilter VolumeNodeTagPolicy = "filter"
+)
+
+// DataEngine is a Longhorn data engine, as set in a Longhorn Volume's
+// spec.dataEngine.
+type DataEngine string
+
+const (
+	// DataEngineV1 is the iSCSI-based data engine. Volumes that do not name
+	// an engine use it.
+	DataEngineV1 DataEngine = "v1"
+
+	// DataEngineV2 is the SPDK-based data engine.
+	DataEngineV2 DataEngine = "v2"
+)
+
+// DecisionLogFormat selects the format of the decision audit log.
+type DecisionLogFormat string
+
+const (
+	// DecisionLogFormatJSON writes each decision as a single-line JSON
+	// object.
+	DecisionLogFormatJSON DecisionLogFormat = "json"
+)
+
+// Args holds the configuration of the LonghornCoSchedule plugin, decoded from
+// the plugin's entry in the pluginConfig section of the
+// KubeSchedulerConfiguration.
+//
+// The zero value is valid and reproduces the plugin's default behaviour, so
+// every field must be optional.
+type Args struct {
+	// WaitForShareManager makes PreEnqueue keep pods that carry the
+	// wait-for-storage annotation out of the active queue until Longhorn has
+	// assigned a share-manager (ShareManager status.ownerID) to each of their
+	// RWX volumes, in addition to waiting for the PVCs to be bound.
+	WaitForShareManager bool `json:"waitForShareManager,omitempty"`
+
+	// RelocateShareManager lets PostFilter delete the share-manager pod of a
+	// hard-mode pod when the share-manager node cannot fit the pod even with
+	// preemption but other nodes can, so Longhorn reschedules it. Pods must
+	// also carry the allow-share-manager-relocation annotation.
+	RelocateShareManager bool `json:"relocateShareManager,omitempty"`
+
+	// ShareManagerRelocationCooldown is the minimum time between two
+	// relocations of the same share-manager. Defaults to
+	// DefaultRelocationCooldown.
+	ShareManagerRelocationCooldown metav1.Duration `json:"shareManagerRelocationCooldown,omitempty"`
+
+	// ShareManagerFollowsVM lets PostBind delete the share-manager pod of a
+	// PVC when the pod was bound to another node and is the PVC's only
+	// consumer, so Longhorn reschedules the share-manager and it can follow
+	// the VM. PVCs must also carry the share-manager-follows-vm annotation.
+	// It shares the ShareManagerRelocationCooldown with RelocateShareManager.
+	ShareManagerFollowsVM bool `json:"shareManagerFollowsVM,omitempty"`
+
+	// ConflictPolicy selects how a pod whose RWX PVCs have share-managers on
+	// different nodes is handled. Defaults to ConflictPolicyFirst.
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+
+	// DataVolumePolicy selects how pods with PVCs that CDI is still
+	// populating are handled. Defaults to DataVolumePolicyAllowAll.
+	DataVolumePolicy DataVolumePolicy `json:"dataVolumePolicy,omitempty"`
+
+	// UnboundPVCPolicy selects how hard-mode pods with RWX Longhorn PVCs
+	// that are not bound yet are handled. Defaults to UnboundPVCPolicyAllow.
+	UnboundPVCPolicy UnboundPVCPolicy `json:"unboundPVCPolicy,omitempty"`
+
+	// AcceptPendingShareManager makes the pod-based lookup accept a
+	// share-manager pod that is already scheduled to a node but still
+	// Pending, e.g. the replacement pod during failover. Such a node scores
+	// slightly lower than one with a Running share-manager.
+	AcceptPendingShareManager bool `json:"acceptPendingShareManager,omitempty"`
+
+	// RequireShareManagerReady only pins a hard-mode pod to a share-manager
+	// whose pod is Ready, or whose ShareManager state is running. A
+	// share-manager that is not, e.g. because its NFS server is crash-looping,
+	// is only scored, as in soft mode, and an event is emitted on the pod.
+	RequireShareManagerReady bool `json:"requireShareManagerReady,omitempty"`
+
+	// CordonedNodePolicy selects how a hard-mode pod whose share-manager
+	// node is cordoned is handled. Defaults to CordonedNodePolicyPin.
+	CordonedNodePolicy CordonedNodePolicy `json:"cordonedNodePolicy,omitempty"`
+
+	// ShareManagerNodeNotReadyTimeout lets every node pass the filter for a
+	// hard-mode pod once its share-manager node has been NotReady for at
+	// least this long; Longhorn fails the share-manager over on its own, but
+	// the ShareManager ownerID can lag behind. Zero disables the fallback.
+	ShareManagerNodeNotReadyTimeout metav1.Duration `json:"shareManagerNodeNotReadyTimeout,omitempty"`
+
+	// FailureTaintKeys are the keys of the NoExecute taints that mark a node
+	// as being evacuated. A hard-mode pod whose share-manager node carries
+	// one of them is treated as if no share-manager was found. Defaults to
+	// DefaultFailureTaintKeys; an empty list disables the check.
+	FailureTaintKeys []string `json:"failureTaintKeys,omitempty"`
+
+	// InsufficientResourcesPolicy selects how a hard-mode pod whose
+	// share-manager node lacks the free resources it requests is handled.
+	// Defaults to InsufficientResourcesPolicyPin.
+	InsufficientResourcesPolicy InsufficientResourcesPolicy `json:"insufficientResourcesPolicy,omitempty"`
+
+	// TaintConflictPolicy selects how a hard-mode pod that does not
+	// tolerate a taint of its share-manager node is handled. Defaults to
+	// TaintConflictPolicyReject.
+	TaintConflictPolicy TaintConflictPolicy `json:"taintConflictPolicy,omitempty"`
+
+	// NodeAffinityConflictPolicy selects how a hard-mode pod whose
+	// nodeSelector or required node affinity does not match its
+	// share-manager node is handled. Defaults to
+	// NodeAffinityConflictPolicyReject.
+	NodeAffinityConflictPolicy NodeAffinityConflictPolicy `json:"nodeAffinityConflictPolicy,omitempty"`
+
+	// PVCLookupErrorPolicy selects how a hard-mode pod is handled when one
+	// of its PVCs cannot be read. Soft-mode pods always schedule freely then.
+	// Defaults to PVCLookupErrorPolicyError.
+	PVCLookupErrorPolicy PVCLookupErrorPolicy `json:"pvcLookupErrorPolicy,omitempty"`
+
+	// CRDFailureThreshold is the number of consecutive failed ShareManager
+	// CRD lookups after which the CRD is skipped for CRDFailureCooldown, and
+	// only the share-manager pod is used. Zero never skips the CRD.
+	CRDFailureThreshold int32 `json:"crdFailureThreshold,omitempty"`
+
+	// CRDFailureCooldown is how long the CRD is skipped once
+	// CRDFailureThreshold is reached. Defaults to DefaultCRDFailureCooldown.
+	CRDFailureCooldown metav1.Duration `json:"crdFailureCooldown,omitempty"`
+
+	// ShareManagerStates lists, per data engine, the ShareManager
+	// status.state values in which its status.ownerID is used. Engines that
+	// are not listed use DefaultShareManagerStates. When set, the data engine
+	// of each volume is read from its Longhorn Volume.
+	ShareManagerStates map[DataEngine][]string `json:"shareManagerStates,omitempty"`
+
+	// AllowedLonghornNamespaces are the namespaces, besides
+	// LonghornNamespace, a pod may name in the longhorn-namespace annotation
+	// to have its share-managers looked up there. Other values are ignored
+	// with a warning event, so tenants cannot point the scheduler at
+	// arbitrary namespaces. Empty disables the annotation.
+	AllowedLonghornNamespaces []string `json:"allowedLonghornNamespaces,omitempty"`
+
+	// InheritVMIAnnotation makes a pod without co-scheduling annotations of
+	// its own use the co-scheduling annotation of the VirtualMachineInstance
+	// that owns it, which KubeVirt does not copy to the virt-launcher pod.
+	// It costs a read of the VMI per virt-launcher pod, cached for
+	// vmiAnnotationCacheTTL.
+	InheritVMIAnnotation bool `json:"inheritVMIAnnotation,omitempty"`
+
+	// VerifyMigrationTargets only treats a pod labelled as a live-migration
+	// target as one if an in-flight VirtualMachineInstanceMigration with
+	// the label's UID targets it. A stray label is then ignored. If the
+	// migrations cannot be listed, the label alone decides.
+	VerifyMigrationTargets bool `json:"verifyMigrationTargets,omitempty"`
+
+	// CoScheduleMigrationTargets selects how opted-in live-migration target
+	// pods are handled. Pods can override it with the
+	// co-schedule-migration-target annotation. Defaults to
+	// MigrationTargetPolicySkip.
+	CoScheduleMigrationTargets MigrationTargetPolicy `json:"coScheduleMigrationTargets,omitempty"`
+
+	// PreferPreviousNode makes Score prefer the node the VM's previous
+	// virt-launcher pod ran on when no share-manager is found, e.g. because
+	// the RWX volume was detached while the VM was stopped. Restarting in
+	// place avoids moving the volume's replicas. It costs a pod list per
+	// scheduling cycle of such a pod.
+	PreferPreviousNode bool `json:"preferPreviousNode,omitempty"`
+
+	// RequireCSIPlugin makes Filter reject, for opted-in pods, nodes that do
+	// not run a Ready Longhorn CSI plugin pod, where the pod's volumes could
+	// not be mounted. The pods are looked up in the scheduler's snapshot.
+	RequireCSIPlugin bool `json:"requireCSIPlugin,omitempty"`
+
+	// CSIPluginSelector is the label selector of the Longhorn CSI plugin
+	// DaemonSet's pods, in the Longhorn namespace. Defaults to
+	// DefaultCSIPluginSelector.
+	CSIPluginSelector string `json:"csiPluginSelector,omitempty"`
+
+	// RequireInstanceManager makes Filter reject, for opted-in pods, nodes
+	// that do not run a Running Longhorn instance-manager pod, where the
+	// pod's volumes could not be attached. The pods are looked up in the
+	// scheduler's snapshot.
+	RequireInstanceManager bool `json:"requireInstanceManager,omitempty"`
+
+	// InstanceManagerSelector is the label selector of the Longhorn
+	// instance-manager pods, in the Longhorn namespace. Defaults to
+	// DefaultInstanceManagerSelector.
+	InstanceManagerSelector string `json:"instanceManagerSelector,omitempty"`
+
+	// LonghornNodePolicy selects how nodes that Longhorn considers
+	// unschedulable for storage are treated. Longhorn Nodes are watched
+	// through an informer unless it is LonghornNodePolicyIgnore, the
+	// default.
+	LonghornNodePolicy LonghornNodePolicy `json:"longhornNodePolicy,omitempty"`
+
+	// LookupOrder is the order in which the sources of a share-manager's
+	// node are asked; the first one naming a node wins. Sources left out are
+	// not asked. Defaults to DefaultLookupOrder.
+	LookupOrder []LookupSource `json:"lookupOrder,omitempty"`
+
+	// StrictErrors fails the scheduling cycle of a hard-mode pod with an
+	// error when one of its PVCs, the ShareManager CRD or the share-manager
+	// pods cannot be read, instead of scheduling it as if no share-manager
+	// was found. It overrides PVCLookupErrorPolicyAllowAll. Soft-mode pods
+	// are not affected.
+	StrictErrors bool `json:"strictErrors,omitempty"`
+
+	// SourceDisagreementPolicy, when set, makes the plugin ask both the
+	// ShareManager CRD and the share-manager pod whenever one of them names
+	// a node, and selects which one wins if they disagree. When unset, the
+	// first source in LookupOrder to name a node wins without a second
+	// lookup.
+	SourceDisagreementPolicy SourceDisagreementPolicy `json:"sourceDisagreementPolicy,omitempty"`
+
+	// PreferStoppedShareManagerNode scores the node a stopped ShareManager
+	// last ran on (its status.ownerID), so a restarted VM returns there and
+	// Longhorn avoids rebuilding replicas elsewhere. The node is never used
+	// to filter, unlike a ShareManager in one of ShareManagerStates.
+	PreferStoppedShareManagerNode bool `json:"preferStoppedShareManagerNode,omitempty"`
+
+	// VolumeNodeTagPolicy selects how nodes outside the node tags of the
+	// pod's Longhorn Volumes are treated. Longhorn Nodes are watched through
+	// an informer unless both it and LonghornNodePolicy are ignore, the
+	// default.
+	VolumeNodeTagPolicy VolumeNodeTagPolicy `json:"volumeNodeTagPolicy,omitempty"`
+
+	// PriorityClassModes maps PriorityClass names to the mode used for
+	// opted-in pods of that class, overriding every opt-in mechanism,
+	// including the pod's own annotations. Pods that are not opted in are
+	// not affected.
+	PriorityClassModes map[string]Mode `json:"priorityClassModes,omitempty"`
+
+	// SoftModePriorityThreshold, when set, co-schedules opted-in pods whose
+	// priority is at least this value in soft mode, so storage placement
+	// never keeps them pending. A PriorityClassModes entry for the pod's
+	// class takes precedence.
+	SoftModePriorityThreshold *int32 `json:"softModePriorityThreshold,omitempty"`
+
+	// ForbiddenThreshold is the number of consecutive Forbidden errors after
+	// which a lookup path — the PVCs of one namespace, or one lookup source
+	// in the Longhorn namespace — is skipped for ForbiddenCooldown, as if
+	// it found nothing. Defaults to DefaultForbiddenThreshold.
+	ForbiddenThreshold int32 `json:"forbiddenThreshold,omitempty"`
+
+	// ForbiddenCooldown is how long a lookup path is skipped once
+	// ForbiddenThreshold is reached. Defaults to DefaultForbiddenCooldown.
+	ForbiddenCooldown metav1.Duration `json:"forbiddenCooldown,omitempty"`
+
+	// ReadableNamespaces, when set, are the only namespaces the plugin reads
+	// PVCs in. Pods in other namespaces are scheduled as if no share-manager
+	// was found, without an API request.
+	ReadableNamespaces []string `json:"readableNamespaces,omitempty"`
+
+	// IncludeHotplugVolumes keeps the PVCs of volumes hotplugged into a VM
+	// among the PVCs a virt-launcher pod is co-scheduled with. By default
+	// they are left out, so a hotplugged disk that shows up in the pod after
+	// a restart cannot pull the VM away from its boot disk's share-manager.
+	IncludeHotplugVolumes bool `json:"includeHotplugVolumes,omitempty"`
+
+	// PreferCDIReplicaNodes makes Score prefer, for CDI importer and upload
+	// server pods, the nodes holding a Longhorn replica of the PVC they
+	// populate, so the image is written to a local replica. These pods are
+	// only ever scored, never filtered.
+	PreferCDIReplicaNodes bool `json:"preferCDIReplicaNodes,omitempty"`
+
+	// BestEffortReplicaBonus, when set, is added to the score of nodes
+	// holding a healthy replica of one of an opted-in pod's Longhorn volumes,
+	// RWO or RWX, with best-effort data locality, so the VM starts next to
+	// a replica and Longhorn does not rebuild one. It must be below
+	// MaxNodeScore, so the node hosting all the pod's share-managers still
+	// ranks first. Longhorn Replicas are watched through an informer when
+	// it is set.
+	BestEffortReplicaBonus int64 `json:"bestEffortReplicaBonus,omitempty"`
+
+	// PinnedVMPenalty, when set, is subtracted from the normalized score of
+	// a node for each opted-in pod, in hard or soft mode, the scheduler's
+	// snapshot has on it, down to 0, so co-scheduled VMs do not all pile
+	// onto the nodes hosting share-managers. It only affects Score: a
+	// hard-mode pod is still pinned to its share-manager node.
+	PinnedVMPenalty int64 `json:"pinnedVMPenalty,omitempty"`
+
+	// ZoneScore, when set, is the score of nodes in the zone
+	// (topology.kubernetes.io/zone) of an opted-in pod's share-manager node,
+	// which scores MaxNodeScore. It matters in soft mode and when the hard
+	// filter is relaxed, where the pod may land elsewhere: staying in the
+	// share-manager's zone keeps its traffic off inter-zone links. It must be
+	// below MaxNodeScore. Unset, such nodes score 0 like the others.
+	ZoneScore int64 `json:"zoneScore,omitempty"`
+
+	// RWXDrivers are the CSI drivers whose RWX volumes are served by a
+	// per-volume server pod the pod is co-scheduled with. Longhorn is the
+	// built-in entry, looked up through its share-manager; an entry for
+	// LonghornDriver replaces it and only sets scoreOnly. RWX volumes of
+	// drivers not listed do not restrict the pod.
+	RWXDrivers []RWXDriver `json:"rwxDrivers,omitempty"`
+
+	// RookCephFS, when set, co-schedules pods with Rook CephFS volumes with
+	// the Rook ceph-nfs gateway, like an rwxDrivers entry for
+	// RookCephFSDriver.
+	RookCephFS *RookCephFSArgs `json:"rookCephFS,omitempty"`
+
+	// DriftReconcileInterval, when set, makes one scheduler instance check
+	// every running opted-in virt-launcher pod at this interval and publish
+	// how many run next to their share-managers, as the
+	// longhorn_cosched_drift_pods gauges. Unset disables the check.
+	DriftReconcileInterval metav1.Duration `json:"driftReconcileInterval,omitempty"`
+
+	// DriftLeaseNamespace is the namespace of the Lease that picks the
+	// scheduler instance running the drift check. Defaults to
+	// DefaultDriftLeaseNamespace.
+	DriftLeaseNamespace string `json:"driftLeaseNamespace,omitempty"`
+
+	// SuppressDecisionEvents stops the events explaining co-scheduling
+	// decisions: pinning a pod to its share-manager node, and no node
+	// fitting a pinned pod. The warnings about ignored share-managers and
+	// PVCs, a relaxed hard filter, a cordoned share-manager node, invalid
+	// configuration and failed lookups are still emitted.
+	SuppressDecisionEvents bool `json:"suppressDecisionEvents,omitempty"`
+
+	// ObserveOnly co-schedules every opted-in pod in ModeObserve, whatever
+	// mode its opt-in selects: the plugin only reports what it would have
+	// done, and never changes a placement.
+	ObserveOnly bool `json:"observeOnly,omitempty"`
+
+	// DecisionHistorySize is the number of recent co-scheduling decisions
+	// kept in memory and written to stderr when the scheduler receives
+	// SIGUSR1. Zero, the default, keeps none.
+	DecisionHistorySize int32 `json:"decisionHistorySize,omitempty"`
+
+	// DecisionLog, when set, writes an audit record of the decision for each
+	// opted-in pod that is bound, and for each that stays unschedulable (at
+	// most once per decisionEventInterval per pod and decision), to stdout
+	// or to DecisionLogPath. Unset writes none.
+	DecisionLog DecisionLogFormat `json:"decisionLog,omitempty"`
+
+	// DecisionLogPath is the file the decision log is appended to instead of
+	// stdout. It is created if missing.
+	DecisionLogPath string `json:"decisionLogPath,omitempty"`
+
+	// DecisionRecords makes PostBind write the decision for each opted-in
+	// pod it binds to a CoScheduleDecision in the pod's namespace, named
+	// after and owned by the pod's VirtualMachineInstance (or the pod, if it
+	// has none), so records outlive the pod and are garbage-collected with
+	// the VM. Records are written in the background and never delay
+	// binding. The CRD must be installed: once a write finds it missing,
+	// recording stops until the scheduler restarts. A record that cannot be
+	// written is logged and does not affect scheduling.
+	DecisionRecords bool `json:"decisionRecords,omitempty"`
+
+	// DecisionRecordsPerNamespace is the number of CoScheduleDecisions kept
+	// per namespace; the oldest beyond it are deleted, once a minute.
+	// Defaults to DefaultDecisionRecordsPerNamespace.
+	DecisionRecordsPerNamespace int32 `json:"decisionRecordsPerNamespace,omitempty"`
+}
+
+// DefaultCSIPluginSelector selects the pods of Longhorn's longhorn-csi-plugin
+// DaemonSet when Args.CSIPluginSelector is unset.
+const DefaultCSIPluginSelector = "app=longhorn-csi-plugin"
+
+// DefaultInstanceManagerSelector selects the pods of Longhorn's instance
+// managers when Args.InstanceManagerSelector is unset.
+const DefaultInstanceManagerSelector = "longhorn.io/component=instance-manager"
+
+// DefaultFailureTaintKeys are the failure taint keys used when
+// Args.FailureTaintKeys is unset: the taints the node lifecycle controller
+// sets on unreachable and NotReady nodes.
+var DefaultFailureTaintKeys = []string{corev1.TaintNodeUnreachable, corev1.TaintNodeNotReady}
+
+// DefaultShareManagerStates are the ShareManager states in which its
+// status.ownerID is used, for data engines Args.ShareManagerStates does not
+// list.
+var DefaultShareManagerStates = []string{"starting", "running"}
+
+// shareManagerStateStopped is the state of a ShareManager whose volume is
+// detached, e.g. because every VM using it is stopped.
+const shareManagerStateStopped = "stopped"
+
+// csiPluginSelector returns the configured CSI plugin pod selector, applying
+// the default.
+func (a Args) csiPluginSelector() string {
+	if a.CSIPluginSelector == "" {
+		return DefaultCSIPluginSelector
+	}
+	return a.CSIPluginSelector
+}
+
+// instanceManagerSelector returns the configured instance-manager pod
+// selector, applying the default.
+func (a Args) instanceManagerSelector() string {
+	if a.InstanceManagerSelector == "" {
+		return DefaultInstanceManagerSelector
+	}
+	return a.InstanceManagerSelector
+}
+
+// longhornNodePolicy returns the configured Longhorn Node policy, applying
+// the default.
+func (a Args) longhornNodePolicy() LonghornNodePolicy {
+	if a.LonghornNodePolicy == "" {
+		return LonghornNodePolicyIgnore
+	}
+	return a.LonghornNodePolicy
+}
+
+// volumeNodeTagPolicy returns the configured volume node tag policy,
+// applying the default.
+func (a Args) volumeNodeTagPolicy() VolumeNodeTagPolicy {
+	if a.VolumeNodeTagPolicy == "" {
+		return VolumeNodeTagPolicyIgnore
+	}
+	return a.VolumeNodeTagPolicy
+}
+
+// conflictPolicy returns the configured conflict policy, applying the default.
+func (a Args) conflictPolicy() ConflictPolicy {
+	if a.ConflictPolicy == "" {
+		return ConflictPolicyFirst
+	}
+	return a.ConflictPolicy
+}
+
+// cordonedNodePolicy returns the configured cordoned node policy, applying the
+// default.
+func (a Args) cordonedNodePolicy() CordonedNodePolicy {
+	if a.CordonedNodePolicy == "" {
+		return CordonedNodePolicyPin
+	}
+	return a.CordonedNodePolicy
+}
+
+// migrationTargetPolicy returns the configured migration target policy,
+// applying the default.
+func (a Args) migrationTargetPolicy() MigrationTargetPolicy {
+	if a.CoScheduleMigrationTargets == "" {
+		return MigrationTargetPolicySkip
+	}
+	return a.CoScheduleMigrationTargets
+}
+
+// failureTaintKeys returns the configured failure taint keys, applying the
+// default.
+func (a Args) failureTaintKeys() []string {
+	if a.FailureTaintKeys == nil {
+		return DefaultFailureTaintKeys
+	}
+	return a.FailureTaintKeys
+}
+
+// driftLeaseNamespace returns the configured drift Lease namespace, applying
+// the default.
+func (a Args) driftLeaseNamespace() string {
+	if a.DriftLeaseNamespace == "" {
+		return DefaultDriftLeaseNamespace
+	}
+	return a.DriftLeaseNamespace
+}
+
+// ValidateArgs returns an error if the plugin args, as found under
+// pluginConfig in a KubeSchedulerConfiguration, would make the scheduler fail
+// to start, or set fields the plugin does not know, which the scheduler
+// silently ignores, e.g. a misspelled arg. A nil object is valid.
+func ValidateArgs(obj runtime.Object) error {
+	if _, err := decodeArgs(obj); err != nil {
+		return err
+	}
+	unknown, ok := obj.(*runtime.Unknown)
+	if !ok || unknown.Raw == nil {
+		return nil
+	}
+	// JSON is YAML, so the strict YAML decoder checks both content types.
+	var args Args
+	if err := yaml.UnmarshalStrict(unknown.Raw, &args); err != nil {
+		return fmt.Errorf("invalid %s args: %w", Name, err)
+	}
+	return nil
+}
+
+// decodeArgs decodes the plugin args passed in by the scheduler framework.
+// A nil object yields the zero value.
+func decodeArgs(obj runtime.Object) (Args, error) {
+	var args Args
+	if err := frameworkruntime.DecodeInto(obj, &args); err != nil {
+		return Args{}, fmt.Errorf("failed to decode %s args: %w", Name, err)
+	}
+	switch args.ConflictPolicy {
+	case "", ConflictPolicyFirst, ConflictPolicyMostVolumes, ConflictPolicyFail, ConflictPolicyScoreOnly:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown conflictPolicy %q", Name, args.ConflictPolicy)
+	}
+	switch args.DataVolumePolicy {
+	case "", DataVolumePolicyAllowAll, DataVolumePolicyWait:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown dataVolumePolicy %q", Name, args.DataVolumePolicy)
+	}
+	switch args.UnboundPVCPolicy {
+	case "", UnboundPVCPolicyAllow, UnboundPVCPolicyWait:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown unboundPVCPolicy %q", Name, args.UnboundPVCPolicy)
+	}
+	switch args.CordonedNodePolicy {
+	case "", CordonedNodePolicyPin, CordonedNodePolicySoft:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown cordonedNodePolicy %q", Name, args.CordonedNodePolicy)
+	}
+	switch args.InsufficientResourcesPolicy {
+	case "", InsufficientResourcesPolicyPin, InsufficientResourcesPolicySoft:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown insufficientResourcesPolicy %q", Name, args.InsufficientResourcesPolicy)
+	}
+	switch args.TaintConflictPolicy {
+	case "", TaintConflictPolicyReject, TaintConflictPolicySoft:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown taintConflictPolicy %q", Name, args.TaintConflictPolicy)
+	}
+	switch args.NodeAffinityConflictPolicy {
+	case "", NodeAffinityConflictPolicyReject, NodeAffinityConflictPolicySoft:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown nodeAffinityConflictPolicy %q", Name, args.NodeAffinityConflictPolicy)
+	}
+	switch args.PVCLookupErrorPolicy {
+	case "", PVCLookupErrorPolicyError, PVCLookupErrorPolicyAllowAll:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown pvcLookupErrorPolicy %q", Name, args.PVCLookupErrorPolicy)
+	}
+	switch args.CoScheduleMigrationTargets {
+	case "", MigrationTargetPolicySkip, MigrationTargetPolicySoft, MigrationTargetPolicyHard:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown coScheduleMigrationTargets %q", Name, args.CoScheduleMigrationTargets)
+	}
+	switch args.LonghornNodePolicy {
+	case "", LonghornNodePolicyIgnore, LonghornNodePolicyScore, LonghornNodePolicyFilter:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown longhornNodePolicy %q", Name, args.LonghornNodePolicy)
+	}
+	switch args.VolumeNodeTagPolicy {
+	case "", VolumeNodeTagPolicyIgnore, VolumeNodeTagPolicyScore, VolumeNodeTagPolicyFilter:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown volumeNodeTagPolicy %q", Name, args.VolumeNodeTagPolicy)
+	}
+	seen := map[LookupSource]bool{}
+	for _, source := range args.LookupOrder {
+		switch source {
+		case LookupSourceShareManager, LookupSourcePod, LookupSourceVolume, LookupSourceEndpoints:
+		default:
+			return Args{}, fmt.Errorf("invalid %s args: unknown lookupOrder source %q", Name, source)
+		}
+		if seen[source] {
+			return Args{}, fmt.Errorf("invalid %s args: lookupOrder source %q listed twice", Name, source)
+		}
+		seen[source] = true
+	}
+	switch args.SourceDisagreementPolicy {
+	case "", SourceDisagreementPolicyCRD, SourceDisagreementPolicyPod, SourceDisagreementPolicyNewest:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown sourceDisagreementPolicy %q", Name, args.SourceDisagreementPolicy)
+	}
+	for class, mode := range args.PriorityClassModes {
+		switch mode {
+		case ModeHard, ModeSoft, ModeObserve:
+		default:
+			return Args{}, fmt.Errorf("invalid %s args: unknown priorityClassModes mode %q for priority class %q", Name, mode, class)
+		}
+	}
+	for engine, states := range args.ShareManagerStates {
+		switch engine {
+		case DataEngineV1, DataEngineV2:
+		default:
+			return Args{}, fmt.Errorf("invalid %s args: unknown shareManagerStates data engine %q", Name, engine)
+		}
+		for _, state := range states {
+			switch state {
+			case "starting", "running", "stopping", "stopped", "error":
+			default:
+				return Args{}, fmt.Errorf("invalid %s args: unknown shareManagerStates state %q for data engine %q", Name, state, engine)
+			}
+		}
+	}
+	for _, namespace := range args.AllowedLonghornNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return Args{}, fmt.Errorf("invalid %s args: allowedLonghornNamespaces entry %q: %s", Name, namespace, strings.Join(errs, "; "))
+		}
+	}
+	if _, err := labels.Parse(args.CSIPluginSelector); err != nil {
+		return Args{}, fmt.Errorf("invalid %s args: csiPluginSelector %q: %w", Name, args.CSIPluginSelector, err)
+	}
+	if _, err := labels.Parse(args.InstanceManagerSelector); err != nil {
+		return Args{}, fmt.Errorf("invalid %s args: instanceManagerSelector %q: %w", Name, args.InstanceManagerSelector, err)
+	}
+	for _, namespace := range args.ReadableNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return Args{}, fmt.Errorf("invalid %s args: readableNamespaces entry %q: %s", Name, namespace, strings.Join(errs, "; "))
+		}
+	}
+	if args.BestEffortReplicaBonus < 0 || args.BestEffortReplicaBonus >= framework.MaxNodeScore {
+		return Args{}, fmt.Errorf("invalid %s args: bestEffortReplicaBonus must be between 0 and %d", Name, framework.MaxNodeScore-1)
+	}
+	if args.PinnedVMPenalty < 0 || args.PinnedVMPenalty > framework.MaxNodeScore {
+		return Args{}, fmt.Errorf("invalid %s args: pinnedVMPenalty must be between 0 and %d", Name, framework.MaxNodeScore)
+	}
+	if args.ZoneScore < 0 || args.ZoneScore >= framework.MaxNodeScore {
+		return Args{}, fmt.Errorf("invalid %s args: zoneScore must be between 0 and %d", Name, framework.MaxNodeScore-1)
+	}
+	if err := validateRWXDrivers(args.rwxDrivers()); err != nil {
+		return Args{}, fmt.Errorf("invalid %s args: %w", Name, err)
+	}
+	if args.ForbiddenThreshold < 0 {
+		return Args{}, fmt.Errorf("invalid %s args: forbiddenThreshold must not be negative", Name)
+	}
+	if args.CRDFailureThreshold < 0 {
+		return Args{}, fmt.Errorf("invalid %s args: crdFailureThreshold must not be negative", Name)
+	}
+	switch args.DecisionLog {
+	case "", DecisionLogFormatJSON:
+	default:
+		return Args{}, fmt.Errorf("invalid %s args: unknown decisionLog format %q", Name, args.DecisionLog)
+	}
+	if args.DecisionLogPath != "" && args.DecisionLog == "" {
+		return Args{}, fmt.Errorf("invalid %s args: decisionLogPath requires decisionLog", Name)
+	}
+	if args.DecisionRecordsPerNamespace < 0 {
+		return Args{}, fmt.Errorf("invalid %s args: decisionRecordsPerNamespace must not be negative", Name)
+	}
+	if args.DecisionHistorySize < 0 {
+		return Args{}, fmt.Errorf("invalid %s args: decisionHistorySize must not be negative", Name)
+	}
+	if args.DriftReconcileInterval.Duration < 0 {
+		return Args{}, fmt.Errorf("invalid %s args: driftReconcileInterval must not be negative", Name)
+	}
+	if errs := validation.IsDNS1123Label(args.DriftLeaseNamespace); args.DriftLeaseNamespace != "" && len(errs) > 0 {
+		return Args{}, fmt.Errorf("invalid %s args: driftLeaseNamespace %q: %s", Name, args.DriftLeaseNamespace, strings.Join(errs, "; "))
+	}
+	if args.ShareManagerNodeNotReadyTimeout.Duration < 0 {
+		return Args{}, fmt.Errorf("invalid %s args: shareManagerNodeNotReadyTimeout must not be negative", Name)
+	}
+	return args, nil
+}
@@ -0,0 +1,106 @@
+package longhorn_cosched
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TestDecodeArgs checks that decodeArgs accepts valid args and rejects
+// invalid ones, and that it decodes the conflict policy.
+func TestDecodeArgs(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    ConflictPolicy
+		wantErr bool
+	}{
+		{raw: `{}`, want: ""},
+		{raw: `{"conflictPolicy":"mostVolumes"}`, want: ConflictPolicyMostVolumes},
+		{raw: `{"conflictPolicy":"scoreOnly"}`, want: ConflictPolicyScoreOnly},
+		{raw: `{"conflictPolicy":"random"}`, wantErr: true},
+
+		{raw: `{"shareManagerStates":{"v1":["starting","running"],"v2":["running"]}}`},
+		{raw: `{"shareManagerStates":{"v3":["running"]}}`, wantErr: true},
+		{raw: `{"shareManagerStates":{"v2":["Running"]}}`, wantErr: true},
+
+		{raw: `{"allowedLonghornNamespaces":["tenant-longhorn"]}`},
+		{raw: `{"allowedLonghornNamespaces":["Tenant_Longhorn"]}`, wantErr: true},
+
+		{raw: `{"csiPluginSelector":"app=longhorn-csi-plugin"}`},
+		{raw: `{"csiPluginSelector":"app in (a, b"}`, wantErr: true},
+
+		{raw: `{"lookupOrder":["volume","shareManager"]}`},
+		{raw: `{"lookupOrder":["endpoints","pod"]}`},
+		{raw: `{"lookupOrder":["volume","attachment"]}`, wantErr: true},
+		{raw: `{"lookupOrder":["pod","pod"]}`, wantErr: true},
+
+		{raw: `{"sourceDisagreementPolicy":"newest"}`},
+		{raw: `{"sourceDisagreementPolicy":"oldest"}`, wantErr: true},
+
+		{raw: `{"volumeNodeTagPolicy":"filter"}`},
+		{raw: `{"volumeNodeTagPolicy":"require"}`, wantErr: true},
+
+		{raw: `{"priorityClassModes":{"vm-critical":"soft"},"softModePriorityThreshold":1000}`},
+		{raw: `{"priorityClassModes":{"vm-critical":"true"}}`, wantErr: true},
+
+		{raw: `{"forbiddenThreshold":5,"forbiddenCooldown":"30m","readableNamespaces":["vms"]}`},
+		{raw: `{"forbiddenThreshold":-1}`, wantErr: true},
+		{raw: `{"readableNamespaces":["Not_A_Namespace"]}`, wantErr: true},
+
+		{raw: `{"driftReconcileInterval":"5m","driftLeaseNamespace":"kubevirt-scheduler"}`},
+		{raw: `{"driftReconcileInterval":"-1m"}`, wantErr: true},
+		{raw: `{"driftLeaseNamespace":"Not_A_Namespace"}`, wantErr: true},
+
+		{raw: `{"decisionLog":"json"}`},
+		{raw: `{"decisionLog":"json","decisionLogPath":"/var/log/decisions.log"}`},
+		{raw: `{"decisionLog":"text"}`, wantErr: true},
+		{raw: `{"decisionLogPath":"/var/log/decisions.log"}`, wantErr: true},
+
+		{raw: `{"decisionRecords":true}`},
+		{raw: `{"decisionRecords":true,"decisionRecordsPerNamespace":50}`},
+		{raw: `{"decisionRecordsPerNamespace":-1}`, wantErr: true},
+
+		{raw: `{"bestEffortReplicaBonus":50}`},
+		{raw: `{"bestEffortReplicaBonus":100}`, wantErr: true},
+		{raw: `{"bestEffortReplicaBonus":-1}`, wantErr: true},
+
+		{raw: `{"instanceManagerSelector":"longhorn.io/component=instance-manager"}`},
+		{raw: `{"instanceManagerSelector":"app in (a, b"}`, wantErr: true},
+
+		{raw: `{"pinnedVMPenalty":100}`},
+		{raw: `{"pinnedVMPenalty":101}`, wantErr: true},
+		{raw: `{"pinnedVMPenalty":-1}`, wantErr: true},
+
+		{raw: `{"zoneScore":50}`},
+		{raw: `{"zoneScore":100}`, wantErr: true},
+		{raw: `{"zoneScore":-1}`, wantErr: true},
+
+		{raw: `{"rwxDrivers":[{"driver":"nfs.csi.example.com","namespace":"nfs-system","podName":"nfs-server-{pv}"}]}`},
+		{raw: `{"rwxDrivers":[{"driver":"nfs.csi.example.com","namespace":"nfs-system","podSelector":"app=nfs-server,pv={pv}"}]}`},
+		{raw: `{"rwxDrivers":[{"namespace":"nfs-system","podName":"nfs-server-{pv}"}]}`, wantErr: true},
+		{raw: `{"rwxDrivers":[{"driver":"driver.longhorn.io"}]}`},
+		{raw: `{"rwxDrivers":[{"driver":"driver.longhorn.io","scoreOnly":true}]}`},
+		{raw: `{"rwxDrivers":[{"driver":"driver.longhorn.io","namespace":"longhorn-system","podName":"share-manager-{pv}"}]}`, wantErr: true},
+		{raw: `{"rwxDrivers":[{"driver":"nfs.csi.example.com","namespace":"nfs-system"}]}`, wantErr: true},
+		{raw: `{"rwxDrivers":[{"driver":"nfs.csi.example.com","namespace":"NFS","podName":"nfs-server-{pv}"}]}`, wantErr: true},
+		{raw: `{"rwxDrivers":[{"driver":"nfs.csi.example.com","namespace":"nfs-system","podName":"NFS_{pv}"}]}`, wantErr: true},
+		{raw: `{"rwxDrivers":[{"driver":"nfs.csi.example.com","namespace":"nfs-system","podSelector":"app in (a"}]}`, wantErr: true},
+		{raw: `{"rwxDrivers":[{"driver":"nfs.csi.example.com","namespace":"a","podName":"x-{pv}"},{"driver":"nfs.csi.example.com","namespace":"b","podName":"y-{pv}"}]}`, wantErr: true},
+
+		{raw: `{"rookCephFS":{}}`},
+		{raw: `{"rookCephFS":{"namespace":"storage","gatewaySelector":"app=rook-ceph-mds","pin":true}}`},
+		{raw: `{"rookCephFS":{"gatewaySelector":"app in (a"}}`, wantErr: true},
+		{raw: `{"rookCephFS":{},"rwxDrivers":[{"driver":"rook-ceph.cephfs.csi.ceph.com","namespace":"rook-ceph","podSelector":"app=rook-ceph-nfs"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			args, err := decodeArgs(&runtime.Unknown{Raw: []byte(tt.raw), ContentType: runtime.ContentTypeJSON})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if args.ConflictPolicy != tt.want {
+				t.Errorf("decodeArgs() ConflictPolicy = %q, want %q", args.ConflictPolicy, tt.want)
+			}
+		})
+	}
+}
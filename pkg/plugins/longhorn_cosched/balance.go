@@ -0,0 +1,134 @@
+package longhorn_cosched
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// boundModes remembers the mode each co-scheduled pod was bound in, by UID,
+// so the VMs co-scheduled on a node are counted without resolving the mode
+// of every pod on it. A pod is added by PostBind and removed when it is
+// deleted.
+//
+// A nil *boundModes remembers nothing.
+type boundModes struct {
+	mu    sync.RWMutex
+	modes map[types.UID]Mode
+}
+
+func newBoundModes() *boundModes {
+	return &boundModes{modes: map[types.UID]Mode{}}
+}
+
+// set records the mode the pod was bound in.
+func (b *boundModes) set(uid types.UID, mode Mode) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.modes[uid] = mode
+}
+
+// get returns the mode the pod was bound in, if PostBind saw it.
+func (b *boundModes) get(uid types.UID) (Mode, bool) {
+	if b == nil {
+		return "", false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	mode, ok := b.modes[uid]
+	return mode, ok
+}
+
+// watch forgets pods when they are deleted.
+func (b *boundModes) watch(factory informers.SharedInformerFactory) error {
+	_, err := factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				b.mu.Lock()
+				delete(b.modes, pod.UID)
+				b.mu.Unlock()
+			}
+		},
+	})
+	return err
+}
+
+// pinnedVMs counts, per node of the scheduler's snapshot, the pods besides
+// the pod itself co-scheduled in hard or soft mode. Finished pods do not
+// count. The mode of a pod is the one PostBind saw it bound in; for a pod
+// bound before the scheduler started, or by another scheduler, it is read
+// from the pod's own annotations and priority, as no lookup is worth a
+// penalty. It is nil unless PinnedVMPenalty is set.
+func (p *Plugin) pinnedVMs(pod *corev1.Pod) map[string]int64 {
+	if p.args.PinnedVMPenalty == 0 || p.handle == nil {
+		return nil
+	}
+	nodeInfos, err := p.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return nil
+	}
+	counts := map[string]int64{}
+	for _, nodeInfo := range nodeInfos {
+		for _, pi := range nodeInfo.Pods {
+			other := pi.Pod
+			if other.UID == pod.UID || other.Status.Phase == corev1.PodSucceeded || other.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			mode, ok := p.bound.get(other.UID)
+			if !ok {
+				mode = p.priorityMode(other, podMode(other))
+			}
+			if mode == ModeHard || mode == ModeSoft {
+				counts[nodeInfo.Node().Name]++
+			}
+		}
+	}
+	return counts
+}
+
+// balanceScores subtracts PinnedVMPenalty for each co-scheduled pod already
+// on a node from its normalized score, down to 0, so that nodes the pod
+// prefers equally, or a share-manager node the pod only prefers, lose to
+// those hosting fewer co-scheduled VMs. The penalty applies after
+// NormalizeScore so it weighs the same whatever the best node's raw score.
+// Nodes scoring 0 are left alone: Score only ranks the nodes that passed
+// Filter, so the hard filter is never overridden.
+func (p *Plugin) balanceScores(state *framework.CycleState, pod *corev1.Pod, scores framework.NodeScoreList) {
+	if p.args.PinnedVMPenalty == 0 {
+		return
+	}
+	c, err := state.Read(stateKey)
+	if err != nil {
+		return
+	}
+	data, ok := c.(*stateData)
+	if !ok {
+		return
+	}
+	for i := range scores {
+		count := data.pinnedVMs[scores[i].Name]
+		if count == 0 || scores[i].Score <= 0 {
+			continue
+		}
+		balanced := max(scores[i].Score-p.args.PinnedVMPenalty*count, 0)
+		klog.V(5).InfoS("LonghornCoSchedule/NormalizeScore: penalising node for its co-scheduled VMs",
+			"pod", klog.KObj(pod),
+			"node", scores[i].Name,
+			"pinnedVMs", count,
+			"score", scores[i].Score,
+			"balancedScore", balanced,
+		)
+		scores[i].Score = balanced
+	}
+}
@@ -0,0 +1,139 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makePinnedVM creates a running virt-launcher pod on the node, co-scheduled
+// with the annotation value, or not opted in if it is empty.
+func makePinnedVM(name, nodeName, value string) *corev1.Pod {
+	pod := makeRunningPod(name, "default", nodeName)
+	if value != "" {
+		pod.Annotations = map[string]string{AnnotationKey: value}
+	}
+	return pod
+}
+
+// TestPinnedVMPenalty checks that, with PinnedVMPenalty, two nodes the pod
+// prefers equally are ranked by the co-scheduled VMs they already host, that
+// the penalty applies to the normalized scores, and that it never lets a
+// hard-mode pod leave its share-manager node.
+func TestPinnedVMPenalty(t *testing.T) {
+	const (
+		pvA = "pvc-0d6a3c4e-7b1f-4c55-a2a1-1e9b1f0c2d33"
+		pvB = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	nodes := []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")}
+	pinned := []*corev1.Pod{
+		makePinnedVM("vm-a", "node-1", "true"),
+		makePinnedVM("vm-b", "node-1", "soft"),
+		makePinnedVM("vm-c", "node-2", "true"),
+		makePinnedVM("vm-d", "node-2", ""),
+		makePinnedVM("vm-e", "node-2", string(ModeObserve)),
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		pvcs  []string
+		args  Args
+		// bound are the modes PostBind saw pods bound in, by name.
+		bound        map[string]Mode
+		wantRejected []string
+		wantScores   map[string]int64
+	}{
+		{
+			name:       "no penalty",
+			value:      "soft",
+			pvcs:       []string{"shared-a", "shared-b"},
+			args:       Args{ConflictPolicy: ConflictPolicyScoreOnly},
+			wantScores: map[string]int64{"node-1": 100, "node-2": 100, "node-3": 0},
+		},
+		{
+			name:       "equally preferred nodes ranked by pinned VMs, after normalization",
+			value:      "soft",
+			pvcs:       []string{"shared-a", "shared-b"},
+			args:       Args{ConflictPolicy: ConflictPolicyScoreOnly, PinnedVMPenalty: 10},
+			wantScores: map[string]int64{"node-1": 80, "node-2": 90, "node-3": 0},
+		},
+		{
+			name:       "mode seen at bind counts",
+			value:      "soft",
+			pvcs:       []string{"shared-a", "shared-b"},
+			args:       Args{ConflictPolicy: ConflictPolicyScoreOnly, PinnedVMPenalty: 10},
+			bound:      map[string]Mode{"vm-b": "", "vm-d": ModeHard},
+			wantScores: map[string]int64{"node-1": 90, "node-2": 80, "node-3": 0},
+		},
+		{
+			name:       "soft mode, share-manager node penalised down to 0",
+			value:      "soft",
+			pvcs:       []string{"shared-a"},
+			args:       Args{PinnedVMPenalty: 60},
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:         "hard mode, still pinned",
+			value:        "true",
+			pvcs:         []string{"shared-a"},
+			args:         Args{PinnedVMPenalty: 100},
+			wantRejected: []string{"node-2", "node-3"},
+			wantScores:   map[string]int64{"node-1": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", false, tt.pvcs...)
+			pod.Annotations = map[string]string{AnnotationKey: tt.value}
+
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes: nodes,
+				pods:  pinned,
+				objects: []runtime.Object{
+					pod,
+					makePVC("shared-a", "default", pvA), makeShareManagerPod(pvA, "node-1"),
+					makePVC("shared-b", "default", pvB), makeShareManagerPod(pvB, "node-2"),
+				},
+				args: tt.args,
+			})
+			for name, mode := range tt.bound {
+				plugin.bound.set(types.UID("uid-"+name), mode)
+			}
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				if m.Get(node).IsSuccess() {
+					t.Errorf("node %s not rejected", node)
+				}
+			}
+			var feasible []*framework.NodeInfo
+			for _, node := range nodes {
+				if _, ok := tt.wantScores[node.Name]; !ok {
+					continue
+				}
+				nodeInfo, err := fwk.SnapshotSharedLister().NodeInfos().Get(node.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				feasible = append(feasible, nodeInfo)
+			}
+			scores, status := fwk.RunScorePlugins(context.Background(), state, pod, feasible)
+			if !status.IsSuccess() {
+				t.Fatalf("RunScorePlugins() = %v", status)
+			}
+			for _, s := range scores {
+				if s.TotalScore != tt.wantScores[s.Name] {
+					t.Errorf("score of %s = %d, want %d", s.Name, s.TotalScore, tt.wantScores[s.Name])
+				}
+			}
+		})
+	}
+}
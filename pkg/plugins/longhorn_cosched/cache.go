@@ -0,0 +1,55 @@
+package longhorn_cosched
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// expiringCache remembers a value per key for a fixed time, so that objects
+// read outside the informer cache are not read again on every scheduling
+// attempt.
+type expiringCache[K comparable, V any] struct {
+	clock clock.PassiveClock
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[K]expiringEntry[V]
+}
+
+type expiringEntry[V any] struct {
+	value  V
+	stored time.Time
+}
+
+func newExpiringCache[K comparable, V any](c clock.PassiveClock, ttl time.Duration) *expiringCache[K, V] {
+	return &expiringCache[K, V]{clock: c, ttl: ttl, entries: map[K]expiringEntry[V]{}}
+}
+
+// get returns the value stored for key, if it was stored less than the TTL
+// ago.
+func (c *expiringCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || c.clock.Since(e.stored) >= c.ttl {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// put stores the value for key and forgets expired entries, so keys of
+// objects that are gone do not accumulate.
+func (c *expiringCache[K, V]) put(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	for other, e := range c.entries {
+		if now.Sub(e.stored) >= c.ttl {
+			delete(c.entries, other)
+		}
+	}
+	c.entries[key] = expiringEntry[V]{value: value, stored: now}
+}
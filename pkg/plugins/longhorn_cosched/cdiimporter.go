@@ -0,0 +1,192 @@
+package longhorn_cosched
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+const (
+	// cdiAppLabel and cdiAppLabelValue are set by CDI on every pod it runs.
+	cdiAppLabel      = "app"
+	cdiAppLabelValue = "containerized-data-importer"
+
+	// cdiComponentLabel tells the role of a CDI pod. Importer pods write an
+	// image into their PVC; upload server pods write an upload, or the data
+	// streamed by the source pod of a host-assisted clone, into theirs.
+	cdiComponentLabel        = "cdi.kubevirt.io"
+	cdiImporterComponent     = "importer"
+	cdiUploadServerComponent = "cdi-upload-server"
+
+	// cdiDataVolumeName is the name of the pod volume of the PVC a CDI pod
+	// populates.
+	cdiDataVolumeName = "cdi-data-vol"
+
+	// cdiImportPodAnnotation and cdiUploadPodAnnotation are set by CDI on the
+	// PVC being populated to the name of its importer or upload server pod.
+	cdiImportPodAnnotation = "cdi.kubevirt.io/storage.import.importPodName"
+	cdiUploadPodAnnotation = "cdi.kubevirt.io/storage.uploadPodName"
+
+	// longhornReplicaResource is the resource of Longhorn's Replica CRD,
+	// served in the same group and version as ShareManagers. Replicas carry
+	// the name of their volume in longhornReplicaVolumeLabel.
+	longhornReplicaResource    = "replicas"
+	longhornReplicaVolumeLabel = "longhornvolume"
+
+	// pendingReplicaScore is the score of a node holding a replica of the
+	// volume that is not running yet, slightly below MaxNodeScore.
+	pendingReplicaScore = framework.MaxNodeScore * 9 / 10
+)
+
+// isCDIPopulatorPod returns true if the pod is a CDI importer or upload
+// server pod.
+func isCDIPopulatorPod(pod *corev1.Pod) bool {
+	if pod.Labels[cdiAppLabel] != cdiAppLabelValue {
+		return false
+	}
+	switch pod.Labels[cdiComponentLabel] {
+	case cdiImporterComponent, cdiUploadServerComponent:
+		return true
+	}
+	return false
+}
+
+// coSchedulesCDIPod returns true if the pod is a CDI pod the
+// PreferCDIReplicaNodes arg scores by replica placement.
+func (p *Plugin) coSchedulesCDIPod(pod *corev1.Pod) bool {
+	return p.args.PreferCDIReplicaNodes && isCDIPopulatorPod(pod)
+}
+
+// cdiTargetPVC returns the name of the PVC the CDI pod populates: the claim
+// of its cdi-data-vol volume or, failing that, the PVC in its namespace
+// whose CDI import or upload pod annotation names it. It returns "" if
+// neither is found.
+func (p *Plugin) cdiTargetPVC(ctx context.Context, pod *corev1.Pod) (string, error) {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name == cdiDataVolumeName && vol.PersistentVolumeClaim != nil {
+			return vol.PersistentVolumeClaim.ClaimName, nil
+		}
+	}
+
+	var pvcs []*corev1.PersistentVolumeClaim
+	if p.pvcLister != nil {
+		list, err := p.pvcLister.PersistentVolumeClaims(pod.Namespace).List(labels.Everything())
+		if err != nil {
+			return "", err
+		}
+		pvcs = list
+	} else {
+		list, err := p.clientset.CoreV1().PersistentVolumeClaims(pod.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return "", err
+		}
+		for i := range list.Items {
+			pvcs = append(pvcs, &list.Items[i])
+		}
+	}
+	for _, pvc := range pvcs {
+		if pvc.Annotations[cdiImportPodAnnotation] == pod.Name || pvc.Annotations[cdiUploadPodAnnotation] == pod.Name {
+			return pvc.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// resolveCDIPod resolves the nodes holding a replica of the Longhorn volume
+// a CDI pod populates. A target PVC that is not found, cannot be read or is
+// not bound, or a volume without replicas, leaves every node neutral: lookup
+// failures are logged, never returned, so they cannot hold the pod back.
+func (p *Plugin) resolveCDIPod(ctx context.Context, pod *corev1.Pod) *stateData {
+	pvcName, err := p.cdiTargetPVC(ctx, pod)
+	if err != nil {
+		klog.V(4).InfoS("LonghornCoSchedule: listing PVCs for CDI pod failed", "pod", klog.KObj(pod), "err", err)
+		return &stateData{}
+	}
+	if pvcName == "" {
+		return &stateData{}
+	}
+	pvc, err := p.getPVC(ctx, pod.Namespace, pvcName)
+	if err != nil || pvc.Spec.VolumeName == "" {
+		return &stateData{cdiTarget: pvcName}
+	}
+
+	opts := p.lookupOptions(pod)
+	volumeName := pvc.Spec.VolumeName
+	if pv, err := getPV(ctx, p.clientset, volumeName, opts); err == nil && pv.Spec.CSI != nil && pv.Spec.CSI.VolumeHandle != "" {
+		volumeName = pv.Spec.CSI.VolumeHandle
+	}
+	return &stateData{cdiTarget: pvcName, replicaScores: p.replicaScores(opts, volumeName)}
+}
+
+// replicaScores returns the score of each node holding a replica of the
+// Longhorn volume: MaxNodeScore for a running replica and pendingReplicaScore
+// for one that is scheduled there but not running yet. Failed replicas are
+// ignored. The Replicas are read from the informer of the pod's Longhorn
+// namespace; it returns nil if the CRD is not served.
+func (p *Plugin) replicaScores(opts lookupOptions, volumeName string) map[string]int64 {
+	replicas := p.replicas[opts.longhornNamespace()]
+	if replicas == nil {
+		return nil
+	}
+	objs, err := replicas.List(labels.SelectorFromSet(labels.Set{longhornReplicaVolumeLabel: volumeName}))
+	if err != nil {
+		klog.V(4).InfoS("LonghornCoSchedule: listing Longhorn Replicas failed", "volume", volumeName, "err", err)
+		return nil
+	}
+	scores := map[string]int64{}
+	for _, obj := range objs {
+		replica, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		node, _, _ := unstructured.NestedString(replica.Object, "spec", "nodeID")
+		failedAt, _, _ := unstructured.NestedString(replica.Object, "spec", "failedAt")
+		if node == "" || failedAt != "" {
+			continue
+		}
+		score := pendingReplicaScore
+		if state, _ := statusString(replica.Object, "currentState"); state == replicaStateRunning {
+			score = framework.MaxNodeScore
+		}
+		scores[node] = max(scores[node], score)
+	}
+	return scores
+}
+
+// preFilterCDIPod is PreFilter for the CDI pods PreferCDIReplicaNodes
+// applies to. It stores the replica nodes of the pod's volume for Score and
+// always returns Skip: CDI pods are never filtered.
+func (p *Plugin) preFilterCDIPod(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	data := p.resolveCDIPod(ctx, pod)
+	klog.V(4).InfoS("LonghornCoSchedule/PreFilter: resolved CDI pod's replica nodes",
+		"pod", klog.KObj(pod),
+		"pvc", data.cdiTarget,
+		"replicaNodes", data.replicaScores,
+	)
+	data.decide(pod)
+	state.Write(stateKey, data)
+	return nil, framework.NewStatus(framework.Skip)
+}
+
+// scoreCDIPod is Score for the CDI pods PreferCDIReplicaNodes applies to:
+// nodes holding a replica of the pod's volume score by replicaScores, all
+// others 0.
+func (p *Plugin) scoreCDIPod(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) (int64, *framework.Status) {
+	data, err := p.cycleData(ctx, state, pod)
+	if err != nil {
+		return 0, framework.AsStatus(err)
+	}
+	score := data.replicaScores[nodeName]
+	klog.V(5).InfoS("LonghornCoSchedule/Score: CDI pod scored by replica placement",
+		"pod", klog.KObj(pod),
+		"node", nodeName,
+		"pvc", data.cdiTarget,
+		"score", score,
+	)
+	return score, nil
+}
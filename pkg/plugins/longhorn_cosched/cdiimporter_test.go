@@ -0,0 +1,163 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// makeCDIPod creates a CDI pod of the given component. A non-empty pvcName
+// is mounted as the cdi-data-vol volume, as CDI does for the PVC the pod
+// populates.
+func makeCDIPod(name, namespace, component, pvcName string) *corev1.Pod {
+	pod := makeVM(name, namespace, false)
+	pod.Labels = map[string]string{cdiAppLabel: cdiAppLabelValue, cdiComponentLabel: component}
+	if pvcName != "" {
+		pod.Spec.Volumes = []corev1.Volume{{
+			Name: cdiDataVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+			},
+		}}
+	}
+	return pod
+}
+
+// makeReplicaCR creates an unstructured Longhorn Replica of the volume on the
+// given node in currentState; failed sets spec.failedAt.
+func makeReplicaCR(name, volumeName, nodeID, currentState string, failed bool) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(shareManagerGVR.Group + "/" + shareManagerGVR.Version)
+	obj.SetKind("Replica")
+	obj.SetNamespace(LonghornNamespace)
+	obj.SetName(name)
+	obj.SetLabels(map[string]string{longhornReplicaVolumeLabel: volumeName})
+	spec := map[string]interface{}{"nodeID": nodeID, "volumeName": volumeName}
+	if failed {
+		spec["failedAt"] = "2026-10-16T12:00:00Z"
+	}
+	obj.Object["spec"] = spec
+	obj.Object["status"] = map[string]interface{}{"currentState": currentState}
+	return obj
+}
+
+// TestCDIReplicaNodes checks that, with PreferCDIReplicaNodes, CDI importer
+// and upload server pods score the nodes holding a replica of the volume they
+// populate — a running replica higher than one that is not running yet,
+// and a failed replica not at all — and that no node is ever filtered.
+func TestCDIReplicaNodes(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "rootdisk"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	nodes := []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")}
+	replicas := []runtime.Object{
+		makeReplicaCR(pvName+"-r-1", pvName, "node-1", "error", true),
+		makeReplicaCR(pvName+"-r-2", pvName, "node-2", "running", false),
+		makeReplicaCR(pvName+"-r-3", pvName, "node-3", "stopped", false),
+		makeReplicaCR("pvc-other-r-1", "pvc-other", "node-1", "running", false),
+	}
+	annotatedPVC := makePVC(pvcName, vmNamespace, pvName)
+	annotatedPVC.Annotations = map[string]string{cdiUploadPodAnnotation: "cdi-upload-" + pvcName}
+	unboundPVC := makePVC(pvcName, vmNamespace, "")
+	unboundPVC.Status.Phase = corev1.ClaimPending
+	notCDI := makeCDIPod("importer-"+pvcName, vmNamespace, cdiImporterComponent, pvcName)
+	delete(notCDI.Labels, cdiAppLabel)
+
+	replicaScores := map[string]int64{"node-1": 0, "node-2": 100, "node-3": 90}
+	neutral := map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0}
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		pvc        *corev1.PersistentVolumeClaim
+		preferCDI  bool
+		wantScores map[string]int64
+	}{
+		{
+			name:       "importer pod — PVC from its cdi-data-vol volume",
+			pod:        makeCDIPod("importer-"+pvcName, vmNamespace, cdiImporterComponent, pvcName),
+			pvc:        makePVC(pvcName, vmNamespace, pvName),
+			preferCDI:  true,
+			wantScores: replicaScores,
+		},
+		{
+			name:       "upload server pod — PVC from CDI's upload pod annotation",
+			pod:        makeCDIPod("cdi-upload-"+pvcName, vmNamespace, cdiUploadServerComponent, ""),
+			pvc:        annotatedPVC,
+			preferCDI:  true,
+			wantScores: replicaScores,
+		},
+		{
+			name:       "arg unset — neutral",
+			pod:        makeCDIPod("importer-"+pvcName, vmNamespace, cdiImporterComponent, pvcName),
+			pvc:        makePVC(pvcName, vmNamespace, pvName),
+			wantScores: neutral,
+		},
+		{
+			name:       "PVC not bound yet — neutral",
+			pod:        makeCDIPod("importer-"+pvcName, vmNamespace, cdiImporterComponent, pvcName),
+			pvc:        unboundPVC,
+			preferCDI:  true,
+			wantScores: neutral,
+		},
+		{
+			name:       "not a CDI pod — neutral",
+			pod:        notCDI,
+			pvc:        makePVC(pvcName, vmNamespace, pvName),
+			preferCDI:  true,
+			wantScores: neutral,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:      nodes,
+				objects:    []runtime.Object{tt.pod, tt.pvc},
+				dynObjects: replicas,
+				args:       Args{PreferCDIReplicaNodes: tt.preferCDI},
+			})
+
+			state, m := runFilters(t, fwk, tt.pod)
+			if m.Len() != 0 {
+				t.Errorf("Filter rejected %d nodes, want none: CDI pods are never filtered", m.Len())
+			}
+
+			nodeInfos, err := fwk.SnapshotSharedLister().NodeInfos().List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			scores, status := fwk.RunScorePlugins(context.Background(), state, tt.pod, nodeInfos)
+			if !status.IsSuccess() {
+				t.Fatalf("RunScorePlugins() = %v", status)
+			}
+			for _, s := range scores {
+				if s.TotalScore != tt.wantScores[s.Name] {
+					t.Errorf("score of %s = %d, want %d", s.Name, s.TotalScore, tt.wantScores[s.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestIsCDIPopulatorPod(t *testing.T) {
+	tests := []struct {
+		component string
+		want      bool
+	}{
+		{component: cdiImporterComponent, want: true},
+		{component: cdiUploadServerComponent, want: true},
+		{component: "cdi-clone-source"},
+		{component: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.component, func(t *testing.T) {
+			if got := isCDIPopulatorPod(makeCDIPod("pod", "default", tt.component, "")); got != tt.want {
+				t.Errorf("isCDIPopulatorPod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
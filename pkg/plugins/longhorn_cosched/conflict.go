@@ -0,0 +1,90 @@
+package longhorn_cosched
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// pendingShareManagerScore is the score a share-manager that is scheduled but
+// not Running yet, or not Ready, contributes to its node, slightly below
+// MaxNodeScore.
+const pendingShareManagerScore = framework.MaxNodeScore * 9 / 10
+
+// placementScore returns the score a share-manager placement contributes to
+// its node.
+func placementScore(pl shareManagerPlacement) int64 {
+	if pl.nominated {
+		return nominatedConsumerScore
+	}
+	if pl.pending || pl.notReady {
+		return pendingShareManagerScore
+	}
+	return framework.MaxNodeScore
+}
+
+// resolvePlacements picks the node a pod should be co-located with from the
+// share-managers of its RWX PVCs. While they all share one node that node is
+// used; otherwise policy decides. Placements that do not pin the pod
+// (notReady share-managers, migratable volumes, other consumers) are scored
+// but take no part in picking the node.
+func resolvePlacements(placements []shareManagerPlacement, policy ConflictPolicy) *stateData {
+	counts := map[string]int{}
+	scores := map[string]int64{}
+	data := &stateData{placements: placements, policy: policy, nodeCounts: counts, nodeScores: scores}
+
+	var order []string
+	ready := map[string]int{}
+	for _, pl := range placements {
+		counts[pl.node]++
+		scores[pl.node] += placementScore(pl)
+		if !pl.pins() {
+			continue
+		}
+		if ready[pl.node] == 0 {
+			order = append(order, pl.node)
+		}
+		ready[pl.node]++
+	}
+	if len(order) == 0 {
+		return data
+	}
+	if len(order) == 1 {
+		data.shareManagerNode = order[0]
+		return data
+	}
+
+	data.conflictNodes = append([]string(nil), order...)
+	sort.Strings(data.conflictNodes)
+
+	switch policy {
+	case ConflictPolicyMostVolumes:
+		best := order[0]
+		for _, node := range order[1:] {
+			if ready[node] > ready[best] {
+				best = node
+			}
+		}
+		data.shareManagerNode = best
+	case ConflictPolicyFail:
+		data.unresolvable = true
+	case ConflictPolicyScoreOnly:
+		// No hard filter; Score ranks nodes by share-manager count.
+	default:
+		data.shareManagerNode = order[0]
+	}
+	return data
+}
+
+// conflictMessage describes where the pod's share-managers are, for status
+// messages, e.g.
+// `conflictPolicy "fail": PVC "a" on node "n1", PVC "b" on node "n2"`.
+func (s *stateData) conflictMessage() string {
+	parts := make([]string, 0, len(s.placements))
+	for _, pl := range s.placements {
+		parts = append(parts, fmt.Sprintf("%s on node %q", pl.source(), pl.node))
+	}
+	return fmt.Sprintf("conflictPolicy %q: %s", s.policy, strings.Join(parts, ", "))
+}
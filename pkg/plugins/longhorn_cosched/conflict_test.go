@@ -0,0 +1,187 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// conflictObjects returns RWX PVCs named after each entry of nodes, bound to
+// PVs whose share-managers run on the given node.
+func conflictObjects(namespace string, nodes map[string]string) []runtime.Object {
+	var objects []runtime.Object
+	for pvc, node := range nodes {
+		pvName := "pv-" + pvc
+		objects = append(objects, makePVC(pvc, namespace, pvName), makeShareManagerPod(pvName, node))
+	}
+	return objects
+}
+
+func TestConflictPolicy(t *testing.T) {
+	const vmNamespace = "default"
+
+	// pvc-a's share-manager is on node-1, pvc-b's on node-2.
+	twoNodes := map[string]string{"pvc-a": "node-1", "pvc-b": "node-2"}
+	// pvc-c's share-manager joins pvc-b's on node-2.
+	threeVolumes := map[string]string{"pvc-a": "node-1", "pvc-b": "node-2", "pvc-c": "node-2"}
+
+	tests := []struct {
+		name       string
+		policy     ConflictPolicy
+		pvcs       []string
+		placements map[string]string
+		wantPass   map[string]bool
+		wantScore  map[string]int64
+		wantCode   framework.Code
+	}{
+		{
+			name:       "default policy is first — first PVC's node wins",
+			pvcs:       []string{"pvc-a", "pvc-b"},
+			placements: twoNodes,
+			wantPass:   map[string]bool{"node-1": true, "node-2": false},
+			wantScore:  map[string]int64{"node-1": 50, "node-2": 50},
+			wantCode:   framework.Unschedulable,
+		},
+		{
+			name:       "first — volume order decides",
+			policy:     ConflictPolicyFirst,
+			pvcs:       []string{"pvc-b", "pvc-a"},
+			placements: twoNodes,
+			wantPass:   map[string]bool{"node-1": false, "node-2": true},
+			wantScore:  map[string]int64{"node-1": 50, "node-2": 50},
+			wantCode:   framework.Unschedulable,
+		},
+		{
+			name:       "mostVolumes — tie goes to the first node",
+			policy:     ConflictPolicyMostVolumes,
+			pvcs:       []string{"pvc-a", "pvc-b"},
+			placements: twoNodes,
+			wantPass:   map[string]bool{"node-1": true, "node-2": false},
+			wantScore:  map[string]int64{"node-1": 50, "node-2": 50},
+			wantCode:   framework.Unschedulable,
+		},
+		{
+			name:       "mostVolumes — node with two of three share-managers wins",
+			policy:     ConflictPolicyMostVolumes,
+			pvcs:       []string{"pvc-a", "pvc-b", "pvc-c"},
+			placements: threeVolumes,
+			wantPass:   map[string]bool{"node-1": false, "node-2": true},
+			wantScore:  map[string]int64{"node-1": 33, "node-2": 66},
+			wantCode:   framework.Unschedulable,
+		},
+		{
+			name:       "fail — every node rejected",
+			policy:     ConflictPolicyFail,
+			pvcs:       []string{"pvc-a", "pvc-b"},
+			placements: twoNodes,
+			wantPass:   map[string]bool{"node-1": false, "node-2": false},
+			wantScore:  map[string]int64{"node-1": 0, "node-2": 0},
+			wantCode:   framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name:       "scoreOnly — no hard filter, scored by count",
+			policy:     ConflictPolicyScoreOnly,
+			pvcs:       []string{"pvc-a", "pvc-b"},
+			placements: twoNodes,
+			wantPass:   map[string]bool{"node-1": true, "node-2": true},
+			wantScore:  map[string]int64{"node-1": 50, "node-2": 50},
+		},
+		{
+			name:       "no conflict — policy does not matter",
+			policy:     ConflictPolicyFail,
+			pvcs:       []string{"pvc-a", "pvc-b"},
+			placements: map[string]string{"pvc-a": "node-2", "pvc-b": "node-2"},
+			wantPass:   map[string]bool{"node-1": false, "node-2": true},
+			wantScore:  map[string]int64{"node-1": 0, "node-2": framework.MaxNodeScore},
+			wantCode:   framework.Unschedulable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(conflictObjects(vmNamespace, tt.placements)...)
+			plugin := &Plugin{clientset: clientset, args: Args{ConflictPolicy: tt.policy}}
+			pod := makeVM("vm", vmNamespace, true, tt.pvcs...)
+
+			state := framework.NewCycleState()
+			if _, status := plugin.PreFilter(context.Background(), state, pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter() status = %v", status)
+			}
+
+			for node, wantPass := range tt.wantPass {
+				status := plugin.Filter(context.Background(), state, pod, makeNodeInfo(node))
+				if status.IsSuccess() != wantPass {
+					t.Errorf("Filter(%s) pass = %v, want %v (status: %v)", node, status.IsSuccess(), wantPass, status)
+				}
+				if status.IsSuccess() {
+					continue
+				}
+				if status.Code() != tt.wantCode {
+					t.Errorf("Filter(%s) code = %v, want %v", node, status.Code(), tt.wantCode)
+				}
+				if len(tt.placements) > 1 && tt.placements["pvc-a"] != tt.placements["pvc-b"] {
+					msg := status.Message()
+					if !strings.Contains(msg, string(plugin.args.conflictPolicy())) || !strings.Contains(msg, `"node-1"`) || !strings.Contains(msg, `"node-2"`) {
+						t.Errorf("Filter(%s) message %q should name the policy and the conflicting nodes", node, msg)
+					}
+				}
+			}
+
+			for node, want := range tt.wantScore {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) status = %v", node, status)
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestScoreProportional checks that with three RWX PVCs spread 2/1 across two
+// nodes, each node scores by the share of the pod's share-managers it hosts,
+// and that NormalizeScore keeps the ordering while scaling the best to 100.
+func TestScoreProportional(t *testing.T) {
+	const vmNamespace = "default"
+	placements := map[string]string{"pvc-a": "node-1", "pvc-b": "node-2", "pvc-c": "node-2"}
+
+	clientset := fake.NewSimpleClientset(conflictObjects(vmNamespace, placements)...)
+	plugin := &Plugin{clientset: clientset}
+	pod := makeVM("vm", vmNamespace, true, "pvc-a", "pvc-b", "pvc-c")
+
+	state := framework.NewCycleState()
+	if _, status := plugin.PreFilter(context.Background(), state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter() status = %v", status)
+	}
+
+	var scores framework.NodeScoreList
+	for _, node := range []string{"node-1", "node-2", "node-3"} {
+		score, status := plugin.Score(context.Background(), state, pod, node)
+		if !status.IsSuccess() {
+			t.Fatalf("Score(%s) status = %v", node, status)
+		}
+		scores = append(scores, framework.NodeScore{Name: node, Score: score})
+	}
+	want := []int64{33, 66, 0}
+	for i, s := range scores {
+		if s.Score != want[i] {
+			t.Errorf("Score(%s) = %d, want %d", s.Name, s.Score, want[i])
+		}
+	}
+
+	if status := plugin.NormalizeScore(context.Background(), state, pod, scores); !status.IsSuccess() {
+		t.Fatalf("NormalizeScore() status = %v", status)
+	}
+	wantNormalized := []int64{50, framework.MaxNodeScore, 0}
+	for i, s := range scores {
+		if s.Score != wantNormalized[i] {
+			t.Errorf("normalized score of %s = %d, want %d", s.Name, s.Score, wantNormalized[i])
+		}
+	}
+}
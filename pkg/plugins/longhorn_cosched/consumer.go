@@ -0,0 +1,62 @@
+package longhorn_cosched
+
+import (
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// nominatedConsumerScore is the score a placement taken from a pod that is
+// only nominated to its node, not bound to it, contributes to the node.
+const nominatedConsumerScore = framework.MaxNodeScore / 2
+
+// consumerFinder looks up the node of another pod mounting a PVC, for PVCs
+// whose share-manager cannot be found yet.
+type consumerFinder func(namespace, pvcName string) (node string, nominated bool)
+
+// consumerNode returns the node of another pod in the scheduler's snapshot
+// that mounts the PVC, so the pod can follow it while the ShareManager has
+// not caught up. Running pods bound to a node are preferred; otherwise a pod
+// nominated to a node after preemption is used, and nominated is true. Pods
+// of the same VMI as the pod, such as a migration source, are ignored.
+func (p *Plugin) consumerNode(pod *corev1.Pod, namespace, pvcName string) (node string, nominated bool) {
+	if p.handle == nil {
+		return "", false
+	}
+	nodeInfos, err := p.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: listing nodes for other consumers of PVC", "pod", klog.KObj(pod), "pvc", pvcName)
+		return "", false
+	}
+
+	consumes := func(other *corev1.Pod) bool {
+		if other.UID == pod.UID || other.Namespace != namespace || sameVMI(pod, other) {
+			return false
+		}
+		return slices.Contains(collectPVCNames(other), pvcName)
+	}
+	for _, ni := range nodeInfos {
+		for _, pi := range ni.Pods {
+			if pi.Pod.Status.Phase == corev1.PodRunning && consumes(pi.Pod) {
+				return ni.Node().Name, false
+			}
+		}
+	}
+	for _, ni := range nodeInfos {
+		for _, pi := range p.handle.NominatedPodsForNode(ni.Node().Name) {
+			if consumes(pi.Pod) {
+				return ni.Node().Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// sameVMI returns true if both pods are owned by the same
+// VirtualMachineInstance.
+func sameVMI(a, b *corev1.Pod) bool {
+	ra, rb := VMIOwner(a), VMIOwner(b)
+	return ra != nil && rb != nil && ra.UID == rb.UID
+}
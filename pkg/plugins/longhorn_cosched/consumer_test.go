@@ -0,0 +1,100 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// TestConsumerPlacement checks that a VM whose share-manager is not found yet
+// prefers the node of another pod mounting the same PVC, without being
+// restricted to it, and that a nominated consumer counts less.
+func TestConsumerPlacement(t *testing.T) {
+	const (
+		pvcName = "shared"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	sibling := func(name string, phase corev1.PodPhase, nodeName string, pvcs ...string) *corev1.Pod {
+		pod := makeVM(name, "default", true, pvcs...)
+		pod.UID = types.UID(name)
+		pod.Spec.NodeName = nodeName
+		pod.Status.Phase = phase
+		return pod
+	}
+
+	tests := []struct {
+		name       string
+		bound      []*corev1.Pod
+		nominated  *corev1.Pod
+		smNode     string
+		wantScores map[string]int64
+	}{
+		{
+			name:       "sibling consumer on node-1",
+			bound:      []*corev1.Pod{sibling("vm-a", corev1.PodRunning, "node-1", pvcName)},
+			wantScores: map[string]int64{"node-1": framework.MaxNodeScore, "node-2": 0},
+		},
+		{
+			name:       "nominated sibling counts less",
+			nominated:  sibling("vm-a", corev1.PodPending, "", pvcName),
+			wantScores: map[string]int64{"node-1": 0, "node-2": nominatedConsumerScore},
+		},
+		{
+			name:       "sibling not Running",
+			bound:      []*corev1.Pod{sibling("vm-a", corev1.PodSucceeded, "node-1", pvcName)},
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0},
+		},
+		{
+			name:       "pod using another PVC",
+			bound:      []*corev1.Pod{sibling("vm-a", corev1.PodRunning, "node-1", "other")},
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0},
+		},
+		{
+			name:       "share-manager wins",
+			bound:      []*corev1.Pod{sibling("vm-a", corev1.PodRunning, "node-1", pvcName)},
+			smNode:     "node-2",
+			wantScores: map[string]int64{"node-1": 0, "node-2": framework.MaxNodeScore},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm-b", "default", true, pvcName)
+			objects := []runtime.Object{pod, makePVC(pvcName, "default", pvName)}
+			if tt.smNode != "" {
+				objects = append(objects, makeShareManagerPod(pvName, tt.smNode))
+			}
+
+			var queued []*corev1.Pod
+			if tt.nominated != nil {
+				tt.nominated.Status.NominatedNodeName = "node-2"
+				queued = append(queued, tt.nominated)
+			}
+
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				pods:    tt.bound,
+				queued:  queued,
+				objects: objects,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != 0 && tt.smNode == "" {
+				t.Errorf("%d nodes rejected, want 0", m.Len())
+			}
+			for node, want := range tt.wantScores {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package longhorn_cosched
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// coScheduleWithNoMatchReason is the reason of the event emitted on a pod
+	// when no Running pod matches its co-schedule-with annotation.
+	coScheduleWithNoMatchReason = "CoScheduleWithNoMatch"
+
+	// coScheduleWithMultipleMatchesReason is the reason of the event emitted
+	// on a pod when Running pods on several nodes match its co-schedule-with
+	// annotation.
+	coScheduleWithMultipleMatchesReason = "CoScheduleWithMultipleMatches"
+)
+
+// parseCoScheduleWith splits a co-schedule-with annotation value into the
+// namespace and the label selector. The selector must not be empty, so that
+// a typo cannot match every pod of the namespace.
+func parseCoScheduleWith(value string) (namespace string, selector labels.Selector, err error) {
+	namespace, rawSelector, found := strings.Cut(value, "/")
+	if !found || namespace == "" || strings.TrimSpace(rawSelector) == "" {
+		return "", nil, fmt.Errorf("want <namespace>/<label selector>, got %q", value)
+	}
+	selector, err = labels.Parse(rawSelector)
+	if err != nil {
+		return "", nil, err
+	}
+	return namespace, selector, nil
+}
+
+// coScheduleWithPlacement returns the placement of the pod named by the
+// pod's co-schedule-with annotation. ok is false if the annotation is not set
+// or invalid, or no pod matches.
+//
+// Candidates are the Running pods in the scheduler snapshot, other than the
+// pod itself, that are not being deleted. If several match, the oldest is
+// used, so the answer does not change while newer replicas come and go; if
+// they are on different nodes, a warning event says so. If none matches, the
+// pod is not restricted and a warning event is emitted.
+func (p *Plugin) coScheduleWithPlacement(pod *corev1.Pod) (pl shareManagerPlacement, ok bool) {
+	value, set := pod.Annotations[CoScheduleWithAnnotationKey]
+	if !set || p.handle == nil {
+		return pl, false
+	}
+	namespace, selector, err := parseCoScheduleWith(value)
+	if err != nil {
+		// Reported by reportInvalidAnnotation.
+		return pl, false
+	}
+
+	nodeInfos, err := p.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: listing nodes for co-schedule-with", "pod", klog.KObj(pod))
+		return pl, false
+	}
+	var matches []*corev1.Pod
+	for _, ni := range nodeInfos {
+		for _, pi := range ni.Pods {
+			other := pi.Pod
+			if other.UID == pod.UID || other.Namespace != namespace || other.DeletionTimestamp != nil ||
+				other.Status.Phase != corev1.PodRunning || !selector.Matches(labels.Set(other.Labels)) {
+				continue
+			}
+			matches = append(matches, other)
+		}
+	}
+
+	if len(matches) == 0 {
+		klog.V(4).InfoS("LonghornCoSchedule: no Running pod matches co-schedule-with",
+			"pod", klog.KObj(pod),
+			"namespace", namespace,
+			"selector", selector.String(),
+		)
+		p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, coScheduleWithNoMatchReason, "Schedule",
+			"No Running pod in namespace %q matches %q; the pod is not co-scheduled with one", namespace, selector.String())
+		return pl, false
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].CreationTimestamp.Equal(&matches[j].CreationTimestamp) {
+			return matches[i].CreationTimestamp.Before(&matches[j].CreationTimestamp)
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	target := matches[0]
+	nodes := map[string]bool{}
+	for _, m := range matches {
+		nodes[m.Spec.NodeName] = true
+	}
+	if len(nodes) > 1 {
+		klog.V(4).InfoS("LonghornCoSchedule: several pods on different nodes match co-schedule-with, using the oldest",
+			"pod", klog.KObj(pod),
+			"matches", len(matches),
+			"target", klog.KObj(target),
+			"node", target.Spec.NodeName,
+		)
+		p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, coScheduleWithMultipleMatchesReason, "Schedule",
+			"%d Running pods on %d nodes match %q; co-scheduling with the oldest, %s on node %q",
+			len(matches), len(nodes), value, klog.KObj(target), target.Spec.NodeName)
+	}
+	return shareManagerPlacement{pod: klog.KObj(target).String(), node: target.Spec.NodeName}, true
+}
@@ -0,0 +1,153 @@
+package longhorn_cosched
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+)
+
+// gatewayNamespace is the namespace of the pods VMs are co-scheduled with.
+const gatewayNamespace = "storage"
+
+// makeGatewayPod creates a Running pod labelled app=gateway, created age ago.
+func makeGatewayPod(name, nodeName string, age time.Duration) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         gatewayNamespace,
+			UID:               types.UID(name),
+			Labels:            map[string]string{"app": "gateway"},
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestParseCoScheduleWith(t *testing.T) {
+	tests := []struct {
+		value         string
+		wantNamespace string
+		wantSelector  string
+		wantErr       bool
+	}{
+		{value: "storage/app=gateway", wantNamespace: "storage", wantSelector: "app=gateway"},
+		{value: "storage/app.kubernetes.io/name=gateway,tier!=test", wantNamespace: "storage", wantSelector: "app.kubernetes.io/name=gateway,tier!=test"},
+		{value: "app=gateway", wantErr: true},
+		{value: "/app=gateway", wantErr: true},
+		{value: "storage/", wantErr: true},
+		{value: "storage/app in (", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			namespace, selector, err := parseCoScheduleWith(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCoScheduleWith() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if namespace != tt.wantNamespace || selector.String() != tt.wantSelector {
+				t.Errorf("parseCoScheduleWith() = %q, %q, want %q, %q", namespace, selector, tt.wantNamespace, tt.wantSelector)
+			}
+		})
+	}
+}
+
+// TestCoScheduleWith checks that a pod with the co-schedule-with annotation is
+// pinned to the node of the matching pod, is not restricted when none
+// matches, and follows the oldest match when several do.
+func TestCoScheduleWith(t *testing.T) {
+	notRunning := makeGatewayPod("gateway-pending", "node-1", time.Hour)
+	notRunning.Status.Phase = corev1.PodPending
+	otherNamespace := makeGatewayPod("gateway-elsewhere", "node-1", time.Hour)
+	otherNamespace.Namespace = "default"
+
+	tests := []struct {
+		name      string
+		pods      []*corev1.Pod
+		wantNode  string
+		wantEvent string
+	}{
+		{
+			name:     "hit",
+			pods:     []*corev1.Pod{makeGatewayPod("gateway-0", "node-2", time.Hour)},
+			wantNode: "node-2",
+		},
+		{
+			name:      "miss — not Running or other namespace, not restricted",
+			pods:      []*corev1.Pod{notRunning, otherNamespace},
+			wantEvent: coScheduleWithNoMatchReason,
+		},
+		{
+			name: "multi-hit on one node",
+			pods: []*corev1.Pod{
+				makeGatewayPod("gateway-0", "node-2", time.Hour),
+				makeGatewayPod("gateway-1", "node-2", time.Minute),
+			},
+			wantNode: "node-2",
+		},
+		{
+			name: "multi-hit on several nodes — oldest wins",
+			pods: []*corev1.Pod{
+				makeGatewayPod("gateway-new", "node-1", time.Minute),
+				makeGatewayPod("gateway-old", "node-3", time.Hour),
+			},
+			wantNode:  "node-3",
+			wantEvent: coScheduleWithMultipleMatchesReason,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", false)
+			pod.UID = types.UID("vm")
+			pod.Annotations = map[string]string{CoScheduleWithAnnotationKey: gatewayNamespace + "/app=gateway"}
+
+			recorder := events.NewFakeRecorder(10)
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+				pods:     tt.pods,
+				objects:  []runtime.Object{pod},
+				recorder: recorder,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).shareManagerNode; got != tt.wantNode {
+				t.Errorf("shareManagerNode = %q, want %q", got, tt.wantNode)
+			}
+			wantRejected := 0
+			if tt.wantNode != "" {
+				wantRejected = 2
+			}
+			if m.Len() != wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), wantRejected)
+			}
+
+			// A pinned pod also gets a Normal event; only warnings are
+			// checked here.
+			var got []string
+			for len(recorder.Events) > 0 {
+				if e := <-recorder.Events; strings.HasPrefix(e, corev1.EventTypeWarning) {
+					got = append(got, e)
+				}
+			}
+			if tt.wantEvent == "" && len(got) > 0 {
+				t.Errorf("warning events emitted: %v, want none", got)
+			}
+			if tt.wantEvent != "" && !hasEvent(got, tt.wantEvent) {
+				t.Errorf("%s event not emitted (events: %v)", tt.wantEvent, got)
+			}
+		})
+	}
+}
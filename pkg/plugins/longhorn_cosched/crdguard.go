@@ -0,0 +1,107 @@
+package longhorn_cosched
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// DefaultCRDFailureCooldown is how long the ShareManager CRD lookup is
+	// skipped after CRDFailureThreshold consecutive failures when
+	// CRDFailureCooldown is unset.
+	DefaultCRDFailureCooldown = 5 * time.Minute
+
+	// crdErrorLogInterval is the minimum time between two logged CRD lookup
+	// failures; the ones in between are only counted.
+	crdErrorLogInterval = time.Minute
+)
+
+// crdGuard keeps track of failing ShareManager CRD lookups. It counts them,
+// rate-limits their logging and, when a failure threshold is set, skips the
+// CRD for a cooldown after that many consecutive failures so every lookup
+// goes straight to the share-manager pod.
+//
+// A nil *crdGuard counts and logs failures but never skips the CRD.
+type crdGuard struct {
+	clock     clock.PassiveClock
+	threshold int
+	cooldown  time.Duration
+
+	mu            sync.Mutex
+	failures      int
+	disabledUntil time.Time
+	lastLogged    time.Time
+	suppressed    int
+}
+
+func newCRDGuard(c clock.PassiveClock, threshold int, cooldown time.Duration) *crdGuard {
+	if cooldown <= 0 {
+		cooldown = DefaultCRDFailureCooldown
+	}
+	return &crdGuard{clock: c, threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns false while the CRD lookup is disabled after repeated
+// failures.
+func (g *crdGuard) allow() bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.clock.Now().Before(g.disabledUntil)
+}
+
+// success resets the consecutive failure count.
+func (g *crdGuard) success() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failures = 0
+}
+
+// failure records a failed lookup of the ShareManager for pvName. It returns
+// true if the failure was logged, rather than only counted.
+func (g *crdGuard) failure(pvName string, err error) bool {
+	crdLookupErrors.WithLabelValues(errorReason(err)).Inc()
+	if g == nil {
+		klog.V(4).InfoS("LonghornCoSchedule: ShareManager CRD lookup failed, falling back to the share-manager pod",
+			"pv", pvName, "err", err)
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.clock.Now()
+
+	g.failures++
+	if g.threshold > 0 && g.failures >= g.threshold {
+		g.failures = 0
+		g.disabledUntil = now.Add(g.cooldown)
+		klog.ErrorS(err, "LonghornCoSchedule: ShareManager CRD lookups keep failing, using only the share-manager pod for a while",
+			"pv", pvName,
+			"crdFailureThreshold", g.threshold,
+			"cooldown", g.cooldown,
+		)
+		g.lastLogged = now
+		g.suppressed = 0
+		return true
+	}
+
+	if !g.lastLogged.IsZero() && now.Sub(g.lastLogged) < crdErrorLogInterval {
+		g.suppressed++
+		return false
+	}
+	klog.ErrorS(err, "LonghornCoSchedule: ShareManager CRD lookup failed, falling back to the share-manager pod",
+		"pv", pvName,
+		"suppressed", g.suppressed,
+	)
+	g.lastLogged = now
+	g.suppressed = 0
+	return true
+}
@@ -0,0 +1,201 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// crdTestPVC is bound to crdTestPV in the CRD guard tests.
+const (
+	crdTestPVC = "my-rwx-pvc"
+	crdTestPV  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+)
+
+// failingCRDClient returns a dynamic client whose ShareManager reads fail with
+// err, and a function counting the ShareManager reads made so far.
+func failingCRDClient(err error) (*dynamicfake.FakeDynamicClient, func() int) {
+	dynClient := newDynamicClient(makeShareManagerCR(crdTestPV, "node-1", "running"))
+	dynClient.PrependReactor("get", "sharemanagers", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, err
+	})
+	return dynClient, func() int {
+		reads := 0
+		for _, action := range dynClient.Actions() {
+			if action.GetResource().Resource == shareManagerGVR.Resource {
+				reads++
+			}
+		}
+		return reads
+	}
+}
+
+func TestCRDLookupFailureFallsBackToPod(t *testing.T) {
+	registerMetrics()
+	forbidden := apierrors.NewForbidden(shareManagerGVR.GroupResource(), crdTestPV, errors.New("RBAC: access denied"))
+	dynClient, _ := failingCRDClient(forbidden)
+	clientset := fake.NewSimpleClientset(makePVC(crdTestPVC, "default", crdTestPV), makeShareManagerPod(crdTestPV, "node-2"))
+
+	counter := crdLookupErrors.WithLabelValues("Forbidden")
+	before, _ := testutil.GetCounterMetricValue(counter)
+
+	pl, err := getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", crdTestPVC, lookupOptions{})
+	if err != nil {
+		t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+	}
+	if pl.node != "node-2" {
+		t.Errorf("getShareManagerNodeForPVC() node = %q, want the share-manager pod's node-2", pl.node)
+	}
+	if after, _ := testutil.GetCounterMetricValue(counter); after-before != 1 {
+		t.Errorf("CRD lookup errors counted = %v, want 1", after-before)
+	}
+}
+
+func TestCRDGuardDisablesCRD(t *testing.T) {
+	dynClient, reads := failingCRDClient(apierrors.NewTimeoutError("request timed out", 1))
+	clientset := fake.NewSimpleClientset(makePVC(crdTestPVC, "default", crdTestPV), makeShareManagerPod(crdTestPV, "node-2"))
+	clock := clocktesting.NewFakePassiveClock(time.Now())
+	opts := lookupOptions{crd: newCRDGuard(clock, 2, 5*time.Minute)}
+
+	lookup := func() {
+		t.Helper()
+		pl, err := getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", crdTestPVC, opts)
+		if err != nil || pl.node != "node-2" {
+			t.Fatalf("getShareManagerNodeForPVC() = (%q, %v), want node-2 from the pod", pl.node, err)
+		}
+	}
+
+	lookup()
+	lookup()
+	if got := reads(); got != 2 {
+		t.Fatalf("CRD reads after two failures = %d, want 2", got)
+	}
+
+	lookup()
+	if got := reads(); got != 2 {
+		t.Errorf("CRD reads while disabled = %d, want 2", got)
+	}
+
+	clock.SetTime(clock.Now().Add(5 * time.Minute))
+	lookup()
+	if got := reads(); got != 3 {
+		t.Errorf("CRD reads after the cooldown = %d, want 3", got)
+	}
+}
+
+func TestCRDGuardThresholdZeroNeverDisables(t *testing.T) {
+	dynClient, reads := failingCRDClient(apierrors.NewTimeoutError("request timed out", 1))
+	clientset := fake.NewSimpleClientset(makePVC(crdTestPVC, "default", crdTestPV))
+	opts := lookupOptions{crd: newCRDGuard(clocktesting.NewFakePassiveClock(time.Now()), 0, 0)}
+
+	for i := 0; i < 5; i++ {
+		if _, err := getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", crdTestPVC, opts); err != nil {
+			t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+		}
+	}
+	if got := reads(); got != 5 {
+		t.Errorf("CRD reads = %d, want 5", got)
+	}
+}
+
+func TestCRDGuardLogRateLimit(t *testing.T) {
+	clock := clocktesting.NewFakePassiveClock(time.Now())
+	g := newCRDGuard(clock, 0, 0)
+	err := apierrors.NewTimeoutError("request timed out", 1)
+
+	if !g.failure(crdTestPV, err) {
+		t.Errorf("first failure not logged")
+	}
+	if g.failure(crdTestPV, err) {
+		t.Errorf("failure within %s logged, want only counted", crdErrorLogInterval)
+	}
+	clock.SetTime(clock.Now().Add(crdErrorLogInterval))
+	if !g.failure(crdTestPV, err) {
+		t.Errorf("failure after %s not logged", crdErrorLogInterval)
+	}
+}
+
+// TestCRDPodFallbackSignals checks that a share-manager found from its pod
+// after a failed ShareManager CRD read is counted once per scheduling cycle
+// by error class, and reported with a warning event once per pod, while a
+// ShareManager that does not exist is neither.
+func TestCRDPodFallbackSignals(t *testing.T) {
+	registerMetrics()
+
+	malformed := makeShareManagerCR(crdTestPV, "node-1", "running")
+	malformed.Object["status"] = []interface{}{"node-1"}
+
+	tests := []struct {
+		name       string
+		dynObjects []runtime.Object
+		err        error
+		// wantClass is the class counted, or "" for no fallback.
+		wantClass string
+	}{
+		{name: "timeout", err: apierrors.NewTimeoutError("request timed out", 1), wantClass: lookupErrorTimeout},
+		{name: "forbidden", err: apierrors.NewForbidden(shareManagerGVR.GroupResource(), crdTestPV, errors.New("RBAC: access denied")), wantClass: lookupErrorForbidden},
+		{name: "malformed", dynObjects: []runtime.Object{malformed}, wantClass: lookupErrorParse},
+		{name: "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", true, crdTestPVC)
+			recorder := events.NewFakeRecorder(10)
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:      []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects:    []runtime.Object{pod, makePVC(crdTestPVC, "default", crdTestPV), makeShareManagerPod(crdTestPV, "node-2")},
+				dynObjects: tt.dynObjects,
+				recorder:   recorder,
+			})
+			if tt.err != nil {
+				plugin.dynClient.(*dynamicfake.FakeDynamicClient).PrependReactor("get", "sharemanagers", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.err
+				})
+			}
+			before := gatherCounter("scheduler_plugin_longhorn_cosched_crd_pod_fallbacks_total", nil, "class")
+
+			for i := 0; i < 2; i++ {
+				state, _ := runFilters(t, fwk, pod)
+				data, _ := state.Read(stateKey)
+				if got := data.(*stateData).shareManagerNode; got != "node-2" {
+					t.Fatalf("shareManagerNode = %q, want the share-manager pod's node-2", got)
+				}
+			}
+
+			var want map[string]float64
+			if tt.wantClass != "" {
+				want = map[string]float64{tt.wantClass: 2}
+			}
+			checkCounterDelta(t, "scheduler_plugin_longhorn_cosched_crd_pod_fallbacks_total", nil, "class", before, want)
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				if e := <-recorder.Events; strings.Contains(e, crdFallbackReason) {
+					got = append(got, e)
+				}
+			}
+			wantEvents := 0
+			if tt.wantClass != "" {
+				wantEvents = 1
+			}
+			if len(got) != wantEvents {
+				t.Fatalf("%s events = %v, want %d", crdFallbackReason, got, wantEvents)
+			}
+			if wantEvents == 1 && (!strings.HasPrefix(got[0], corev1.EventTypeWarning) || !strings.Contains(got[0], "PVC "+crdTestPVC)) {
+				t.Errorf("event = %q, want a warning naming PVC %s", got[0], crdTestPVC)
+			}
+		})
+	}
+}
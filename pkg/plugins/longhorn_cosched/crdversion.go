@@ -0,0 +1,139 @@
+package longhorn_cosched
+
+import (
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// shareManagerVersions are the versions of the ShareManager CRD the plugin can
+// read, most preferred first. Longhorn 1.3 only serves v1beta1.
+var shareManagerVersions = []string{"v1beta2", "v1beta1"}
+
+const (
+	// crdRediscoverAfter is the number of consecutive NotFound ShareManager
+	// lookups after which the served version is discovered again, in case
+	// Longhorn was upgraded or rolled back.
+	crdRediscoverAfter = 20
+
+	// crdRediscoverInterval is the minimum time between two discoveries.
+	crdRediscoverInterval = time.Minute
+)
+
+// shareManagerAPI finds out which version of the ShareManager CRD the cluster
+// serves and caches it. Until the first discovery succeeds, and while
+// discovery fails for reasons other than NotFound, shareManagerGVR is used.
+//
+// A nil *shareManagerAPI always uses shareManagerGVR.
+type shareManagerAPI struct {
+	discovery discovery.DiscoveryInterface
+	clock     clock.PassiveClock
+
+	mu            sync.Mutex
+	gvr           schema.GroupVersionResource
+	served        bool
+	lastDiscovery time.Time
+	notFound      int
+}
+
+// newShareManagerAPI returns a shareManagerAPI after running discovery once.
+func newShareManagerAPI(d discovery.DiscoveryInterface, c clock.PassiveClock) *shareManagerAPI {
+	a := &shareManagerAPI{discovery: d, clock: c, gvr: shareManagerGVR, served: true}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.discoverLocked()
+	return a
+}
+
+// resource returns the ShareManager GroupVersionResource to query, and false
+// if no known version is served. While none is, discovery is retried at most
+// once per crdRediscoverInterval.
+func (a *shareManagerAPI) resource() (schema.GroupVersionResource, bool) {
+	if a == nil {
+		return shareManagerGVR, true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.served && a.clock.Since(a.lastDiscovery) >= crdRediscoverInterval {
+		a.discoverLocked()
+	}
+	return a.gvr, a.served
+}
+
+// observe records the result of a ShareManager lookup. A NotFound error may
+// mean the ShareManager does not exist yet, or that the version is no longer
+// served; after crdRediscoverAfter of them in a row, discovery runs again.
+func (a *shareManagerAPI) observe(err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !apierrors.IsNotFound(err) {
+		a.notFound = 0
+		return
+	}
+	a.notFound++
+	if a.notFound >= crdRediscoverAfter && a.clock.Since(a.lastDiscovery) >= crdRediscoverInterval {
+		a.discoverLocked()
+	}
+}
+
+// discoverLocked asks the API server which ShareManager version it serves.
+// a.mu must be held.
+func (a *shareManagerAPI) discoverLocked() {
+	a.lastDiscovery = a.clock.Now()
+	a.notFound = 0
+
+	version, err := ServedShareManagerVersion(a.discovery)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: discovering the ShareManager CRD version failed, keeping the current one",
+			"groupVersion", a.gvr.GroupVersion().String(),
+			"served", a.served,
+		)
+		return
+	}
+	if version != "" {
+		gvr := schema.GroupVersionResource{Group: shareManagerGVR.Group, Version: version, Resource: shareManagerGVR.Resource}
+		if gvr != a.gvr || !a.served {
+			klog.V(2).InfoS("LonghornCoSchedule: using ShareManager CRD version", "groupVersion", gvr.GroupVersion().String())
+		}
+		a.gvr, a.served = gvr, true
+		return
+	}
+
+	if a.served {
+		klog.InfoS("LonghornCoSchedule: the ShareManager CRD is not served in any known version, finding share-managers by their pods only",
+			"group", shareManagerGVR.Group,
+			"versions", shareManagerVersions,
+		)
+	}
+	a.served = false
+}
+
+// ServedShareManagerVersion returns the most preferred version of the
+// ShareManager CRD the plugin can read that the API server serves, or "" if
+// it serves none of them, e.g. because Longhorn is not installed.
+func ServedShareManagerVersion(d discovery.DiscoveryInterface) (string, error) {
+	for _, version := range shareManagerVersions {
+		gv := schema.GroupVersion{Group: shareManagerGVR.Group, Version: version}
+		resources, err := d.ServerResourcesForGroupVersion(gv.String())
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, r := range resources.APIResources {
+			if r.Name == shareManagerGVR.Resource {
+				return version, nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,118 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// serveShareManagers makes the fake clientset's discovery report the
+// ShareManager CRD in the given versions, and nothing else.
+func serveShareManagers(clientset *fake.Clientset, versions ...string) {
+	clientset.Resources = nil
+	for _, version := range versions {
+		clientset.Resources = append(clientset.Resources, &metav1.APIResourceList{
+			GroupVersion: schema.GroupVersion{Group: shareManagerGVR.Group, Version: version}.String(),
+			APIResources: []metav1.APIResource{{Name: shareManagerGVR.Resource, Namespaced: true, Kind: "ShareManager"}},
+		})
+	}
+}
+
+func TestShareManagerAPIDiscovery(t *testing.T) {
+	const (
+		crdNode = "node-1"
+		podNode = "node-2"
+	)
+
+	tests := []struct {
+		name       string
+		served     []string
+		wantServed bool
+		wantGVR    string
+		wantNode   string
+	}{
+		{name: "v1beta2 only", served: []string{"v1beta2"}, wantServed: true, wantGVR: "v1beta2", wantNode: crdNode},
+		{name: "v1beta1 only (Longhorn 1.3)", served: []string{"v1beta1"}, wantServed: true, wantGVR: "v1beta1", wantNode: crdNode},
+		{name: "both — v1beta2 preferred", served: []string{"v1beta1", "v1beta2"}, wantServed: true, wantGVR: "v1beta2", wantNode: crdNode},
+		{name: "neither — CRD path disabled", wantNode: podNode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makePVC(crdTestPVC, "default", crdTestPV), makeShareManagerPod(crdTestPV, podNode))
+			serveShareManagers(clientset, tt.served...)
+
+			var crs []runtime.Object
+			for _, version := range tt.served {
+				cr := makeShareManagerCR(crdTestPV, crdNode, "running")
+				cr.SetAPIVersion(shareManagerGVR.Group + "/" + version)
+				crs = append(crs, cr)
+			}
+			dynClient := newDynamicClient(crs...)
+
+			api := newShareManagerAPI(clientset.Discovery(), clocktesting.NewFakePassiveClock(time.Now()))
+			gvr, served := api.resource()
+			if served != tt.wantServed {
+				t.Fatalf("resource() served = %v, want %v", served, tt.wantServed)
+			}
+			if served && gvr.Version != tt.wantGVR {
+				t.Errorf("resource() version = %q, want %q", gvr.Version, tt.wantGVR)
+			}
+
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", crdTestPVC, lookupOptions{api: api})
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode {
+				t.Errorf("getShareManagerNodeForPVC() node = %q, want %q", pl.node, tt.wantNode)
+			}
+			if !tt.wantServed && len(dynClient.Actions()) != 0 {
+				t.Errorf("CRD read %d times, want none when no version is served", len(dynClient.Actions()))
+			}
+		})
+	}
+}
+
+func TestShareManagerAPIRediscovery(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+	api := newShareManagerAPI(clientset.Discovery(), fakeClock)
+	if _, served := api.resource(); served {
+		t.Fatal("resource() served = true with no ShareManager CRD")
+	}
+
+	// Longhorn is installed; the next discovery is rate-limited.
+	serveShareManagers(clientset, "v1beta1")
+	if _, served := api.resource(); served {
+		t.Error("resource() rediscovered before the retry interval")
+	}
+	fakeClock.SetTime(fakeClock.Now().Add(crdRediscoverInterval))
+	if gvr, served := api.resource(); !served || gvr.Version != "v1beta1" {
+		t.Fatalf("resource() = %v, %v, want v1beta1 after the retry interval", gvr, served)
+	}
+
+	// Longhorn is upgraded and v1beta1 goes away: every lookup is NotFound.
+	serveShareManagers(clientset, "v1beta2")
+	fakeClock.SetTime(fakeClock.Now().Add(crdRediscoverInterval))
+	notFound := apierrors.NewNotFound(shareManagerGVR.GroupResource(), crdTestPV)
+	for i := 0; i < crdRediscoverAfter-1; i++ {
+		api.observe(notFound)
+	}
+	if gvr, _ := api.resource(); gvr.Version != "v1beta1" {
+		t.Errorf("resource() version = %q before %d NotFound lookups, want v1beta1", gvr.Version, crdRediscoverAfter)
+	}
+	api.observe(nil)
+	for i := 0; i < crdRediscoverAfter; i++ {
+		api.observe(notFound)
+	}
+	if gvr, served := api.resource(); !served || gvr.Version != "v1beta2" {
+		t.Errorf("resource() = %v, %v, want v1beta2 after %d NotFound lookups", gvr, served, crdRediscoverAfter)
+	}
+}
@@ -0,0 +1,59 @@
+package longhorn_cosched
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/util"
+)
+
+// filterCSIPlugin rejects the node if the RequireCSIPlugin arg is set and no
+// Ready, non-terminating pod in the pod's Longhorn namespace matches the CSI
+// plugin selector on it. The node's pods are taken from the snapshot.
+func (p *Plugin) filterCSIPlugin(pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if p.csiPlugins == nil || node == nil {
+		return nil
+	}
+	namespace := p.lookupOptions(pod).longhornNamespace()
+	for _, pi := range nodeInfo.Pods {
+		if isLonghornComponentPod(pi.Pod, namespace, p.csiPlugins) && isPodReady(pi.Pod) {
+			return nil
+		}
+	}
+
+	klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (no Ready Longhorn CSI plugin pod)",
+		"pod", klog.KObj(pod),
+		"node", node.Name,
+		"selector", p.csiPlugins.String(),
+	)
+	return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+		fmt.Sprintf("node %q has no Ready Longhorn CSI plugin pod (%s in namespace %q), volumes cannot be mounted", node.Name, p.csiPlugins, namespace))
+}
+
+// isLonghornComponentPod returns true if the pod is a Longhorn component pod
+// matching selector, e.g. a CSI plugin or instance-manager pod, in the given
+// namespace that is not being deleted.
+func isLonghornComponentPod(pod *corev1.Pod, namespace string, selector labels.Selector) bool {
+	return pod.Namespace == namespace && pod.DeletionTimestamp == nil && selector.Matches(labels.Set(pod.Labels))
+}
+
+// isSchedulableAfterCSIPluginChange requeues the pod when a Longhorn CSI
+// plugin pod becomes Ready, which may make a rejected node usable.
+func (p *Plugin) isSchedulableAfterCSIPluginChange(logger klog.Logger, pod *corev1.Pod, oldObj, newObj interface{}) (framework.QueueingHint, error) {
+	oldPod, newPod, err := util.As[*corev1.Pod](oldObj, newObj)
+	if err != nil {
+		return framework.Queue, err
+	}
+	if !p.csiPlugins.Matches(labels.Set(newPod.Labels)) || !isPodReady(newPod) {
+		return framework.QueueSkip, nil
+	}
+	if oldPod != nil && isPodReady(oldPod) {
+		return framework.QueueSkip, nil
+	}
+	logger.V(5).Info("Longhorn CSI plugin pod became Ready, requeueing", "pod", klog.KObj(pod), "csiPluginPod", klog.KObj(newPod), "node", newPod.Spec.NodeName)
+	return framework.Queue, nil
+}
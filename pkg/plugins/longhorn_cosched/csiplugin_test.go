@@ -0,0 +1,140 @@
+package longhorn_cosched
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeCSIPluginPod creates a longhorn-csi-plugin pod on the given node, with
+// its Ready condition set to ready.
+func makeCSIPluginPod(nodeName, app string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "longhorn-csi-plugin-" + nodeName,
+			Namespace: LonghornNamespace,
+			UID:       types.UID("csi-" + nodeName),
+			Labels:    map[string]string{"app": app},
+		},
+		Spec: corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+// TestRequireCSIPlugin checks that, with RequireCSIPlugin, opted-in pods are
+// kept off nodes whose CSI plugin pod is missing or not Ready, and that the
+// selector is configurable.
+func TestRequireCSIPlugin(t *testing.T) {
+	const pvcName = "shared"
+
+	tests := []struct {
+		name         string
+		args         Args
+		value        string
+		wantRejected []string
+	}{
+		{
+			name:         "hard",
+			args:         Args{RequireCSIPlugin: true},
+			value:        "true",
+			wantRejected: []string{"node-2", "node-3", "node-4"},
+		},
+		{
+			name:         "soft",
+			args:         Args{RequireCSIPlugin: true},
+			value:        "soft",
+			wantRejected: []string{"node-2", "node-3", "node-4"},
+		},
+		{
+			name:         "custom selector",
+			args:         Args{RequireCSIPlugin: true, CSIPluginSelector: "app in (longhorn-csi-plugin, csi-plugin)"},
+			value:        "true",
+			wantRejected: []string{"node-2", "node-3"},
+		},
+		{name: "not opted in", args: Args{RequireCSIPlugin: true}, value: OptOutValue},
+		{name: "flag off", value: "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", false, pvcName)
+			pod.Annotations = map[string]string{AnnotationKey: tt.value}
+
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4"), makeNode("node-4", "4")},
+				pods: []*corev1.Pod{
+					makeCSIPluginPod("node-1", "longhorn-csi-plugin", true),
+					makeCSIPluginPod("node-3", "longhorn-csi-plugin", false),
+					makeCSIPluginPod("node-4", "csi-plugin", true),
+				},
+				objects: []runtime.Object{pod},
+				args:    tt.args,
+			})
+
+			_, m := runFilters(t, fwk, pod)
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				if m.Get(node).IsSuccess() {
+					t.Errorf("node %s not rejected", node)
+				}
+			}
+		})
+	}
+}
+
+func TestIsSchedulableAfterCSIPluginChange(t *testing.T) {
+	plugin := &Plugin{csiPlugins: labels.SelectorFromSet(labels.Set{"app": "longhorn-csi-plugin"})}
+	pod := makeVM("vm", "default", true)
+
+	tests := []struct {
+		name     string
+		old, new *corev1.Pod
+		want     framework.QueueingHint
+	}{
+		{name: "Ready CSI plugin pod added", new: makeCSIPluginPod("node-1", "longhorn-csi-plugin", true), want: framework.Queue},
+		{name: "CSI plugin pod added not Ready", new: makeCSIPluginPod("node-1", "longhorn-csi-plugin", false), want: framework.QueueSkip},
+		{
+			name: "CSI plugin pod became Ready",
+			old:  makeCSIPluginPod("node-1", "longhorn-csi-plugin", false),
+			new:  makeCSIPluginPod("node-1", "longhorn-csi-plugin", true),
+			want: framework.Queue,
+		},
+		{
+			name: "CSI plugin pod stayed Ready",
+			old:  makeCSIPluginPod("node-1", "longhorn-csi-plugin", true),
+			new:  makeCSIPluginPod("node-1", "longhorn-csi-plugin", true),
+			want: framework.QueueSkip,
+		},
+		{name: "other pod", new: makeCSIPluginPod("node-1", "longhorn-manager", true), want: framework.QueueSkip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var oldObj interface{}
+			if tt.old != nil {
+				oldObj = tt.old
+			}
+			got, err := plugin.isSchedulableAfterCSIPluginChange(klog.Background(), pod, oldObj, tt.new)
+			if err != nil {
+				t.Fatalf("isSchedulableAfterCSIPluginChange() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isSchedulableAfterCSIPluginChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+// parseDataEngine returns the data engine of an unstructured Longhorn Volume.
+// Longhorn 1.6 and later set spec.dataEngine; Longhorn 1.5 called the same
+// field spec.backendStoreDriver. A Volume that sets neither predates the v2
+// engine and uses v1.
+func parseDataEngine(obj map[string]interface{}) (DataEngine, error) {
+	for _, field := range []string{"dataEngine", "backendStoreDriver"} {
+		engine, err := nestedString(obj, "spec", field)
+		if err != nil {
+			return "", fmt.Errorf("parsing spec.%s of Longhorn Volume: %w", field, err)
+		}
+		if engine != "" {
+			return DataEngine(engine), nil
+		}
+	}
+	return DataEngineV1, nil
+}
+
+// shareManagerStates returns the ShareManager states in which status.ownerID
+// is used for the named Longhorn volume. The ShareManager does not record the
+// data engine, so it is read from the Volume of the same name, but only when
+// the states are configured per engine. If the Volume cannot be read, the
+// defaults apply.
+func (o lookupOptions) shareManagerStates(ctx context.Context, dynClient dynamic.Interface, volumeName string) []string {
+	if len(o.engineStates) == 0 {
+		return DefaultShareManagerStates
+	}
+	return o.volumeShareManagerStates(getLonghornVolume(ctx, dynClient, o, volumeName), volumeName)
+}
+
+// volumeShareManagerStates returns the accepted ShareManager states for the
+// data engine of the Longhorn Volume obj, which may be nil.
+func (o lookupOptions) volumeShareManagerStates(obj *unstructured.Unstructured, volumeName string) []string {
+	if len(o.engineStates) == 0 || obj == nil {
+		return DefaultShareManagerStates
+	}
+	engine, err := parseDataEngine(obj.Object)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: ignoring malformed Longhorn Volume", "volume", volumeName)
+		return DefaultShareManagerStates
+	}
+	states, ok := o.engineStates[engine]
+	if !ok {
+		states = DefaultShareManagerStates
+	}
+	klog.V(5).InfoS("LonghornCoSchedule: data engine of Longhorn volume",
+		"volume", volumeName,
+		"dataEngine", engine,
+		"states", states,
+	)
+	return states
+}
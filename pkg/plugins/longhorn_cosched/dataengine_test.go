@@ -0,0 +1,187 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// fixtureVolume is the name of the Longhorn Volume and ShareManager in
+// testdata.
+const fixtureVolume = "pvc-3f6b8a1e-5c2d-4e7f-9a0b-1c2d3e4f5a6b"
+
+// loadFixture reads an unstructured Longhorn object from testdata. The
+// fixtures follow the shape of the longhorn.io/v1beta2 CRDs, trimmed to the
+// fields that matter here.
+func loadFixture(t *testing.T, name string) *unstructured.Unstructured {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		t.Fatalf("decoding fixture %s: %v", name, err)
+	}
+	return obj
+}
+
+func TestParseDataEngine(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    DataEngine
+	}{
+		{fixture: "volume-v1.json", want: DataEngineV1},
+		{fixture: "volume-v2.json", want: DataEngineV2},
+		{fixture: "volume-v2-backendstoredriver.json", want: DataEngineV2},
+		{fixture: "volume-legacy.json", want: DataEngineV1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			got, err := parseDataEngine(loadFixture(t, tt.fixture).Object)
+			if err != nil {
+				t.Fatalf("parseDataEngine() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseDataEngine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	malformed := map[string]interface{}{"spec": map[string]interface{}{"dataEngine": int64(2)}}
+	if _, err := parseDataEngine(malformed); err == nil {
+		t.Error("parseDataEngine() error = nil for a numeric spec.dataEngine")
+	}
+}
+
+// TestShareManagerStatesPerEngine checks that a starting share-manager is
+// used or ignored according to the states configured for its volume's data
+// engine, by the ShareManager CRD and the Longhorn Volume sources alike, and
+// that the Volume is only read for its data engine when states are
+// configured per engine.
+func TestShareManagerStatesPerEngine(t *testing.T) {
+	const pvcName = "shared"
+	runningOnly := map[DataEngine][]string{DataEngineV2: {"running"}}
+	volumeFirst := []LookupSource{LookupSourceVolume, LookupSourceShareManager, LookupSourcePod}
+
+	tests := []struct {
+		name            string
+		volume          string
+		engineStates    map[DataEngine][]string
+		order           []LookupSource
+		wantNode        string
+		wantVolumeReads int
+	}{
+		{name: "defaults — volume not read", volume: "volume-v2.json", wantNode: "node-2"},
+		{name: "v2 volume, v2 running only", volume: "volume-v2.json", engineStates: runningOnly, wantVolumeReads: 2},
+		{name: "Longhorn 1.5 v2 volume, v2 running only", volume: "volume-v2-backendstoredriver.json", engineStates: runningOnly, wantVolumeReads: 2},
+		{name: "v1 volume, v2 running only", volume: "volume-v1.json", engineStates: runningOnly, wantNode: "node-2", wantVolumeReads: 1},
+		{name: "legacy volume, v2 running only", volume: "volume-legacy.json", engineStates: runningOnly, wantNode: "node-2", wantVolumeReads: 1},
+		{name: "Volume missing — defaults", engineStates: runningOnly, wantNode: "node-2", wantVolumeReads: 1},
+		{name: "volume first, defaults", volume: "volume-v2.json", order: volumeFirst, wantNode: "node-2", wantVolumeReads: 1},
+		{name: "volume first, v2 running only", volume: "volume-v2.json", engineStates: runningOnly, order: volumeFirst, wantVolumeReads: 2},
+		{name: "volume first, v1 volume, v2 running only", volume: "volume-v1.json", engineStates: runningOnly, order: volumeFirst, wantNode: "node-2", wantVolumeReads: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makePVC(pvcName, "default", fixtureVolume))
+			serveShareManagers(clientset, "v1beta2")
+			objects := []runtime.Object{loadFixture(t, "sharemanager-starting.json")}
+			if tt.volume != "" {
+				// The Volume reports the state of the starting ShareManager.
+				volume := loadFixture(t, tt.volume)
+				if err := unstructured.SetNestedField(volume.Object, "starting", "status", "shareState"); err != nil {
+					t.Fatal(err)
+				}
+				objects = append(objects, volume)
+			}
+			dynClient := newDynamicClient(objects...)
+			opts := lookupOptions{
+				api:          newShareManagerAPI(clientset.Discovery(), clocktesting.NewFakePassiveClock(time.Now())),
+				engineStates: tt.engineStates,
+				order:        tt.order,
+			}
+
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", pvcName, opts)
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode {
+				t.Errorf("getShareManagerNodeForPVC() node = %q, want %q", pl.node, tt.wantNode)
+			}
+
+			volumeReads := 0
+			for _, action := range dynClient.Actions() {
+				if action.GetResource().Resource == longhornVolumeResource {
+					volumeReads++
+				}
+			}
+			if volumeReads != tt.wantVolumeReads {
+				t.Errorf("Longhorn Volume read %d times, want %d", volumeReads, tt.wantVolumeReads)
+			}
+		})
+	}
+}
+
+// TestVolumeLookup checks that the Longhorn Volume's status.currentNodeID is
+// used when the ShareManager and its pod name no node, that a detached
+// volume yields none, and that the lookup order is honoured.
+func TestVolumeLookup(t *testing.T) {
+	const pvcName = "shared"
+
+	tests := []struct {
+		name         string
+		volume       string
+		smNode       string
+		order        []LookupSource
+		requireReady bool
+		wantNode     string
+		wantNotReady bool
+	}{
+		{name: "attached", volume: "volume-v1.json", wantNode: "node-2"},
+		{name: "detached", volume: "volume-detached.json"},
+		{name: "attaching", volume: "volume-attaching.json", wantNode: "node-3"},
+		{name: "attaching, ready required", volume: "volume-attaching.json", requireReady: true, wantNode: "node-3", wantNotReady: true},
+		{name: "share-manager first", volume: "volume-v1.json", smNode: "node-1", wantNode: "node-1"},
+		{
+			name:     "volume first",
+			volume:   "volume-v1.json",
+			smNode:   "node-1",
+			order:    []LookupSource{LookupSourceVolume, LookupSourceShareManager, LookupSourcePod},
+			wantNode: "node-2",
+		},
+		{name: "volume left out", volume: "volume-v1.json", order: []LookupSource{LookupSourceShareManager, LookupSourcePod}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makePVC(pvcName, "default", fixtureVolume))
+			serveShareManagers(clientset, "v1beta2")
+			objects := []runtime.Object{loadFixture(t, tt.volume)}
+			if tt.smNode != "" {
+				objects = append(objects, makeShareManagerCR(fixtureVolume, tt.smNode, "running"))
+			}
+			opts := lookupOptions{
+				api:          newShareManagerAPI(clientset.Discovery(), clocktesting.NewFakePassiveClock(time.Now())),
+				requireReady: tt.requireReady,
+				order:        tt.order,
+			}
+
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, newDynamicClient(objects...), "default", pvcName, opts)
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode || pl.notReady != tt.wantNotReady {
+				t.Errorf("getShareManagerNodeForPVC() = node %q, notReady %v, want %q, %v", pl.node, pl.notReady, tt.wantNode, tt.wantNotReady)
+			}
+		})
+	}
+}
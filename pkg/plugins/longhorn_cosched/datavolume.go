@@ -0,0 +1,65 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// cdiAPIGroup is the API group of CDI's DataVolume.
+	cdiAPIGroup = "cdi.kubevirt.io"
+
+	// cdiPodPhaseAnnotation is set by CDI on a DataVolume's PVC to the phase
+	// of the importer/cloner/uploader pod populating it.
+	cdiPodPhaseAnnotation = "cdi.kubevirt.io/storage.pod.phase"
+)
+
+// isDataVolumePVC returns true if the PVC belongs to a CDI DataVolume, either
+// through an owner reference or CDI's storage annotations.
+func isDataVolumePVC(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, ref := range pvc.OwnerReferences {
+		if ref.Kind == "DataVolume" && strings.HasPrefix(ref.APIVersion, cdiAPIGroup+"/") {
+			return true
+		}
+	}
+	for key := range pvc.Annotations {
+		if strings.HasPrefix(key, cdiAPIGroup+"/storage.") {
+			return true
+		}
+	}
+	return false
+}
+
+// isPopulating returns true if CDI has not finished populating the
+// DataVolume PVC: it is not bound yet (e.g. while a prime PVC is being
+// populated), or its importer pod has not succeeded.
+func isPopulating(pvc *corev1.PersistentVolumeClaim) bool {
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return true
+	}
+	phase, ok := pvc.Annotations[cdiPodPhaseAnnotation]
+	return ok && phase != string(corev1.PodSucceeded)
+}
+
+// pendingDataVolume returns a reason if one of the pod's PVCs does not exist
+// yet or is a DataVolume PVC that CDI is still populating, or "" if the pod
+// does not have to wait.
+func (p *Plugin) pendingDataVolume(ctx context.Context, pod *corev1.Pod) (string, error) {
+	for _, pvcName := range collectPVCNames(pod) {
+		pvc, err := p.getPVC(ctx, pod.Namespace, pvcName)
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("PVC %q does not exist yet", pvcName), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("getting PVC %s/%s: %w", pod.Namespace, pvcName, err)
+		}
+		if isDataVolumePVC(pvc) && isPopulating(pvc) {
+			return fmt.Sprintf("PVC %q is still being populated by CDI", pvcName), nil
+		}
+	}
+	return "", nil
+}
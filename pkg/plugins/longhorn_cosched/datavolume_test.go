@@ -0,0 +1,147 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeDataVolumePVC creates a PVC owned by a CDI DataVolume whose importer pod
+// is in the given phase. An empty pvName leaves the PVC unbound.
+func makeDataVolumePVC(name, namespace, pvName string, importerPhase corev1.PodPhase) *corev1.PersistentVolumeClaim {
+	pvc := makePVC(name, namespace, pvName)
+	if pvName == "" {
+		pvc.Status.Phase = corev1.ClaimPending
+	}
+	pvc.Annotations = map[string]string{cdiPodPhaseAnnotation: string(importerPhase)}
+	pvc.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "cdi.kubevirt.io/v1beta1",
+		Kind:       "DataVolume",
+		Name:       name,
+		UID:        "uid-dv",
+	}}
+	return pvc
+}
+
+func TestDataVolumePolicy(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "rootdisk"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+		otherNode   = "node-1"
+	)
+
+	tests := []struct {
+		name     string
+		policy   DataVolumePolicy
+		objects  []runtime.Object
+		wantPass map[string]bool
+	}{
+		{
+			name:     "importing — allowAll lets every node pass",
+			policy:   DataVolumePolicyAllowAll,
+			objects:  []runtime.Object{makeDataVolumePVC(pvcName, vmNamespace, pvName, corev1.PodRunning)},
+			wantPass: map[string]bool{targetNode: true, otherNode: true},
+		},
+		{
+			name:     "importing — default policy is allowAll",
+			objects:  []runtime.Object{makeDataVolumePVC(pvcName, vmNamespace, "", corev1.PodPending)},
+			wantPass: map[string]bool{targetNode: true, otherNode: true},
+		},
+		{
+			name:     "importing — wait rejects every node",
+			policy:   DataVolumePolicyWait,
+			objects:  []runtime.Object{makeDataVolumePVC(pvcName, vmNamespace, pvName, corev1.PodRunning)},
+			wantPass: map[string]bool{targetNode: false, otherNode: false},
+		},
+		{
+			name:     "prime PVC still populating (target unbound) — wait rejects every node",
+			policy:   DataVolumePolicyWait,
+			objects:  []runtime.Object{makeDataVolumePVC(pvcName, vmNamespace, "", corev1.PodPending)},
+			wantPass: map[string]bool{targetNode: false, otherNode: false},
+		},
+		{
+			name:     "PVC not created yet — wait rejects every node",
+			policy:   DataVolumePolicyWait,
+			wantPass: map[string]bool{targetNode: false, otherNode: false},
+		},
+		{
+			name:   "import succeeded — wait co-schedules with the share-manager",
+			policy: DataVolumePolicyWait,
+			objects: []runtime.Object{
+				makeDataVolumePVC(pvcName, vmNamespace, pvName, corev1.PodSucceeded),
+				makeShareManagerPod(pvName, targetNode),
+			},
+			wantPass: map[string]bool{targetNode: true, otherNode: false},
+		},
+		{
+			name:   "plain PVC — wait does not apply",
+			policy: DataVolumePolicyWait,
+			objects: []runtime.Object{
+				makePVC(pvcName, vmNamespace, pvName),
+				makeShareManagerPod(pvName, targetNode),
+			},
+			wantPass: map[string]bool{targetNode: true, otherNode: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &Plugin{
+				clientset: fake.NewSimpleClientset(tt.objects...),
+				args:      Args{DataVolumePolicy: tt.policy},
+			}
+			pod := makeVM("vm", vmNamespace, true, pvcName)
+
+			state := framework.NewCycleState()
+			if _, status := plugin.PreFilter(context.Background(), state, pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter() status = %v", status)
+			}
+			for node, want := range tt.wantPass {
+				status := plugin.Filter(context.Background(), state, pod, makeNodeInfo(node))
+				if status.IsSuccess() != want {
+					t.Errorf("Filter(%s) pass = %v, want %v (status: %v)", node, status.IsSuccess(), want, status)
+				}
+			}
+		})
+	}
+}
+
+// TestDataVolumeRequeue checks that a pod waiting for a DataVolume is
+// requeued once the PVC is updated, and schedules after the import finished.
+func TestDataVolumeRequeue(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "rootdisk"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	importing := makeDataVolumePVC(pvcName, vmNamespace, pvName, corev1.PodRunning)
+	clientset := fake.NewSimpleClientset(importing, makeShareManagerPod(pvName, "node-2"))
+	plugin := &Plugin{clientset: clientset, args: Args{DataVolumePolicy: DataVolumePolicyWait}}
+	pod := makeVM("vm", vmNamespace, true, pvcName)
+
+	if status := plugin.Filter(context.Background(), nil, pod, makeNodeInfo("node-2")); status.IsSuccess() {
+		t.Fatal("Filter() should reject the pod while the PVC is importing")
+	}
+
+	done := makeDataVolumePVC(pvcName, vmNamespace, pvName, corev1.PodSucceeded)
+	hint, err := plugin.isSchedulableAfterPVCChange(klog.Background(), pod, importing, done)
+	if err != nil || hint != framework.Queue {
+		t.Errorf("isSchedulableAfterPVCChange() = %v, %v, want Queue", hint, err)
+	}
+	if _, err := clientset.CoreV1().PersistentVolumeClaims(vmNamespace).Update(context.Background(), done, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating PVC: %v", err)
+	}
+
+	if status := plugin.Filter(context.Background(), nil, pod, makeNodeInfo("node-2")); !status.IsSuccess() {
+		t.Errorf("Filter() after import = %v, want success", status)
+	}
+}
@@ -0,0 +1,182 @@
+package longhorn_cosched
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// Outcomes of a scheduling cycle's co-scheduling decision.
+const (
+	// decisionPinned: a hard-mode pod is restricted to node.
+	decisionPinned = "pinned"
+
+	// decisionPreferred: a soft-mode pod prefers node but may land elsewhere.
+	decisionPreferred = "preferred"
+
+	// decisionObserved: an observe-mode pod would have been pinned to node,
+	// but nothing restricts or scores it.
+	decisionObserved = "observed"
+
+	// decisionFallback: a fallback policy relaxed the hard filter; node is
+	// the share-manager node the pod is not pinned to.
+	decisionFallback = "fallback"
+
+	// decisionWaiting: the pod waits for one of its PVCs (DataVolume or
+	// unbound PVC policy).
+	decisionWaiting = "waiting"
+
+	// decisionUnresolvable: the share-managers are on different nodes and
+	// the conflict policy fails the pod.
+	decisionUnresolvable = "unresolvable"
+
+	// decisionNotPinned: share-managers were found, but none pins the pod,
+	// e.g. because they are not Ready or the conflict policy only scores.
+	decisionNotPinned = "not-pinned"
+
+	// decisionNoShareManager: no share-manager was found.
+	decisionNoShareManager = "no-share-manager"
+)
+
+// Decision is what a scheduling cycle decided for a pod, in the terms every
+// report on it uses: Filter status messages, events, log lines, metrics and
+// the records PostBind writes. PreFilter computes it once, after the lookup,
+// and stores it with the rest of the stateData in the CycleState, so that
+// they all describe one lookup and cannot disagree. Tools that run the
+// plugin in a framework of their own, such as the simulate subcommand, read
+// it with CycleDecision.
+type Decision struct {
+	Mode Mode `json:"mode,omitempty"`
+
+	// Outcome is "pinned", "preferred", "observed", "fallback", "waiting",
+	// "unresolvable", "not-pinned" or "no-share-manager".
+	Outcome string `json:"outcome"`
+
+	// ShareManagerNode is the node the pod is pinned to or prefers, or,
+	// after a fallback, the one it is no longer pinned to.
+	ShareManagerNode string `json:"shareManagerNode,omitempty"`
+
+	// PinnedBy describes what holds the pod on ShareManagerNode, as status
+	// messages and events do.
+	PinnedBy string `json:"pinnedBy,omitempty"`
+
+	// Fallback explains, for the "fallback" outcome, why the hard filter was
+	// relaxed.
+	Fallback string `json:"fallback,omitempty"`
+
+	// Placements are all the share-managers the lookup found.
+	Placements []ShareManagerPlacement `json:"placements,omitempty"`
+}
+
+// skippedDecision is the Decision for a pod PreFilter skips, e.g. one that
+// is not opted in: it has no mode and no share-manager.
+var skippedDecision = Decision{Outcome: decisionNoShareManager}
+
+// decide computes the cycle's decision for the pod and stores it in
+// s.decision, and the placements that pin the pod in s.pins. PreFilter calls
+// it once it is done changing s.
+func (s *stateData) decide(pod *corev1.Pod) {
+	d := Decision{Mode: s.mode, ShareManagerNode: s.shareManagerNode, Fallback: s.fallback}
+	var pins []shareManagerPlacement
+	switch {
+	case s.waitingFor != "":
+		d.Outcome = decisionWaiting
+	case len(s.placements) == 0:
+		d.Outcome = decisionNoShareManager
+	case s.fallback != "":
+		d.Outcome = decisionFallback
+		d.ShareManagerNode = s.fallbackNode
+	case s.unresolvable:
+		d.Outcome = decisionUnresolvable
+	case s.shareManagerNode == "":
+		d.Outcome = decisionNotPinned
+	case s.mode == ModeObserve:
+		d.Outcome = decisionObserved
+	case s.mode == ModeSoft:
+		d.Outcome = decisionPreferred
+	default:
+		d.Outcome = decisionPinned
+	}
+	for _, pl := range s.placements {
+		if d.ShareManagerNode != "" && pl.node == d.ShareManagerNode && pl.pins() {
+			pins = append(pins, pl)
+		}
+		d.Placements = append(d.Placements, ShareManagerPlacement{PVC: pl.pvc, PV: pl.pv, Node: pl.node, Source: pl.from, Pins: pl.pins()})
+	}
+	if len(pins) > 0 {
+		d.PinnedBy = describePins(pins, pod.Namespace)
+	}
+	s.decision, s.pins = d, pins
+}
+
+// pinnedPVCs returns the names of the PVCs whose share-managers pin the pod.
+func (s *stateData) pinnedPVCs() []string {
+	var names []string
+	for _, pl := range s.pins {
+		if pl.pvc != "" {
+			names = append(names, pl.pvc)
+		}
+	}
+	return names
+}
+
+// pinnedBy returns PinnedBy, or a generic description if nothing pins the
+// pod.
+func (d Decision) pinnedBy() string {
+	if d.PinnedBy == "" {
+		return "its Longhorn share-manager is running"
+	}
+	return d.PinnedBy
+}
+
+// describePins describes what holds the pod on the share-manager node, for
+// status messages and events: each share-manager with its PVC, PV and the
+// lookup source that found it, or the pod named by the co-schedule-with
+// annotation. PVCs are named in the given namespace, the pod's.
+func describePins(pins []shareManagerPlacement, namespace string) string {
+	var parts []string
+	for _, pl := range pins {
+		if pl.pod != "" {
+			parts = append(parts, fmt.Sprintf("pod %s is running", pl.pod))
+			continue
+		}
+		part := fmt.Sprintf("Longhorn share-manager for PVC %s/%s (pv %s) is running", namespace, pl.pvc, pl.pv)
+		if pl.driver != "" {
+			part = fmt.Sprintf("%s server pod for PVC %s/%s (pv %s) is running", pl.driver, namespace, pl.pvc, pl.pv)
+		}
+		if pl.from != "" {
+			part += fmt.Sprintf(" (source: %s)", pl.from)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " and ")
+}
+
+// CycleDecision returns the decision PreFilter stored in the CycleState for
+// the pod, and false if PreFilter has not run. A pod PreFilter skipped, e.g.
+// one not opted in, has an empty Mode and the "no-share-manager" outcome.
+func CycleDecision(state *framework.CycleState) (Decision, bool) {
+	data, err := state.Read(stateKey)
+	if err != nil {
+		return Decision{}, false
+	}
+	s, ok := data.(*stateData)
+	if !ok {
+		return Decision{}, false
+	}
+	return s.decision, true
+}
+
+// cycleDecision returns the decision PreFilter stored in the CycleState, or
+// skippedDecision if it stored none.
+func cycleDecision(state *framework.CycleState) Decision {
+	if state == nil {
+		return skippedDecision
+	}
+	if d, ok := CycleDecision(state); ok {
+		return d
+	}
+	return skippedDecision
+}
@@ -0,0 +1,151 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestStateDataDecision(t *testing.T) {
+	pinning := shareManagerPlacement{pvc: "a", pv: "pv-a", node: "node-1", from: LookupSourceShareManager}
+	notReady := shareManagerPlacement{pvc: "b", pv: "pv-b", node: "node-1", notReady: true}
+	elsewhere := shareManagerPlacement{pvc: "c", pv: "pv-c", node: "node-2", from: LookupSourcePod}
+
+	tests := []struct {
+		name        string
+		data        *stateData
+		wantOutcome string
+		wantNode    string
+		wantPVCs    []string
+	}{
+		{
+			name:        "pinned",
+			data:        &stateData{mode: ModeHard, shareManagerNode: "node-1", placements: []shareManagerPlacement{pinning, notReady, elsewhere}},
+			wantOutcome: decisionPinned,
+			wantNode:    "node-1",
+			wantPVCs:    []string{"a"},
+		},
+		{
+			name:        "preferred",
+			data:        &stateData{mode: ModeSoft, shareManagerNode: "node-1", placements: []shareManagerPlacement{pinning}},
+			wantOutcome: decisionPreferred,
+			wantNode:    "node-1",
+			wantPVCs:    []string{"a"},
+		},
+		{
+			name:        "observed",
+			data:        &stateData{mode: ModeObserve, shareManagerNode: "node-1", placements: []shareManagerPlacement{pinning}},
+			wantOutcome: decisionObserved,
+			wantNode:    "node-1",
+			wantPVCs:    []string{"a"},
+		},
+		{
+			name:        "fallback",
+			data:        &stateData{mode: ModeHard, fallback: "cordoned", fallbackNode: "node-1", placements: []shareManagerPlacement{pinning}},
+			wantOutcome: decisionFallback,
+			wantNode:    "node-1",
+			wantPVCs:    []string{"a"},
+		},
+		{
+			name:        "not ready only",
+			data:        &stateData{mode: ModeHard, placements: []shareManagerPlacement{notReady}},
+			wantOutcome: decisionNotPinned,
+		},
+		{
+			name:        "unresolvable",
+			data:        &stateData{mode: ModeHard, unresolvable: true, placements: []shareManagerPlacement{pinning, elsewhere}},
+			wantOutcome: decisionUnresolvable,
+		},
+		{
+			name:        "waiting",
+			data:        &stateData{mode: ModeHard, waitingFor: "PVC a is unbound"},
+			wantOutcome: decisionWaiting,
+		},
+		{
+			name:        "no share-manager",
+			data:        &stateData{mode: ModeHard},
+			wantOutcome: decisionNoShareManager,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.data.decide(makeVM("vm", "default", true))
+			d := tt.data.decision
+			if d.Outcome != tt.wantOutcome || d.ShareManagerNode != tt.wantNode {
+				t.Errorf("decision = {outcome %q, node %q}, want {%q, %q}", d.Outcome, d.ShareManagerNode, tt.wantOutcome, tt.wantNode)
+			}
+			if got := strings.Join(tt.data.pinnedPVCs(), ","); got != strings.Join(tt.wantPVCs, ",") {
+				t.Errorf("pinnedPVCs() = %q, want %q", got, strings.Join(tt.wantPVCs, ","))
+			}
+		})
+	}
+}
+
+// TestDecisionConsistency checks that the Filter status message, the pinned
+// event and the PostBind metric of a cycle all describe the one lookup
+// PreFilter made, and that Score and PostBind do not read the API again.
+func TestDecisionConsistency(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	registerMetrics()
+
+	pod := makeVM("vm", vmNamespace, true, pvcName)
+	recorder := events.NewFakeRecorder(10)
+	fwk, plugin, clientset := newTestFramework(t, testCluster{
+		nodes:    []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects:  []runtime.Object{pod, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+		recorder: recorder,
+	})
+
+	state, m := runFilters(t, fwk, pod)
+	data, err := state.Read(stateKey)
+	if err != nil {
+		t.Fatalf("reading CycleState: %v", err)
+	}
+	d := data.(*stateData).decision
+	if d.Outcome != decisionPinned || d.ShareManagerNode != "node-1" {
+		t.Fatalf("decision = {outcome %q, node %q}, want {%q, %q}", d.Outcome, d.ShareManagerNode, decisionPinned, "node-1")
+	}
+	pinnedBy := d.PinnedBy
+	if !strings.Contains(pinnedBy, "PVC default/shared (pv "+pvName+")") {
+		t.Errorf("pinnedBy = %q, want it to name the PVC and PV", pinnedBy)
+	}
+	actions := len(clientset.Actions())
+
+	if msg := m.Get("node-2").Message(); !strings.HasSuffix(msg, `VM must run on "node-1" where `+pinnedBy) {
+		t.Errorf("Filter status = %q, want it to end with %q", msg, pinnedBy)
+	}
+	var got []string
+	for len(recorder.Events) > 0 {
+		got = append(got, <-recorder.Events)
+	}
+	if len(got) != 1 || !strings.HasSuffix(got[0], `Co-scheduling on node "node-1", where `+pinnedBy) {
+		t.Errorf("events = %v, want one %s event naming %q", got, pinnedReason, pinnedBy)
+	}
+
+	reason := divergenceReason(d)
+	before, _ := testutil.GetCounterMetricValue(divergentBinds.WithLabelValues(string(d.Mode), reason))
+	nodeInfos, err := fwk.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		t.Fatalf("listing nodes: %v", err)
+	}
+	if _, status := fwk.RunScorePlugins(context.Background(), state, pod, nodeInfos); !status.IsSuccess() {
+		t.Fatalf("RunScorePlugins() = %v", status)
+	}
+	plugin.PostBind(context.Background(), state, pod, "node-2")
+	if after, _ := testutil.GetCounterMetricValue(divergentBinds.WithLabelValues(string(d.Mode), reason)); after-before != 1 {
+		t.Errorf("divergent{mode=%q,reason=%q} went up by %v, want 1", d.Mode, reason, after-before)
+	}
+
+	if n := len(clientset.Actions()) - actions; n != 0 {
+		t.Errorf("%d API calls after PreFilter, want none: %v", n, clientset.Actions()[actions:])
+	}
+}
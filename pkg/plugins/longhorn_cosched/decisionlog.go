@@ -0,0 +1,149 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/utils/clock"
+)
+
+// Values of the event field of a decision log record.
+const (
+	decisionLogBound         = "bound"
+	decisionLogUnschedulable = "unschedulable"
+)
+
+// decisionLogRecord is a line of the decision audit log: the cycle decision
+// for a pod and what became of the pod.
+type decisionLogRecord struct {
+	Time    time.Time `json:"time"`
+	Profile string    `json:"profile,omitempty"`
+	Event   string    `json:"event"`
+	Pod     string    `json:"pod"`
+
+	Mode             Mode             `json:"mode"`
+	Outcome          string           `json:"outcome"`
+	ShareManagerNode string           `json:"shareManagerNode,omitempty"`
+	Pins             []decisionLogPin `json:"pins,omitempty"`
+	Fallback         string           `json:"fallback,omitempty"`
+	FallbackReason   string           `json:"fallbackReason,omitempty"`
+
+	// Node is the node a bound pod landed on; Colocated is set if it hosts
+	// one of the pod's share-managers.
+	Node      string `json:"node,omitempty"`
+	Colocated bool   `json:"colocated,omitempty"`
+}
+
+// decisionLogPin is a placement that pins the pod to its share-manager node:
+// the share-manager of a PVC, or the pod named by co-schedule-with.
+type decisionLogPin struct {
+	PVC    string       `json:"pvc,omitempty"`
+	PV     string       `json:"pv,omitempty"`
+	Pod    string       `json:"pod,omitempty"`
+	Source LookupSource `json:"source,omitempty"`
+}
+
+// decisionLogger writes the decision audit log enabled by Args.DecisionLog.
+//
+// A nil *decisionLogger writes nothing.
+type decisionLogger struct {
+	clock   clock.PassiveClock
+	profile string
+
+	// mu guards w, the file the log is appended to or stdout. Lines are
+	// written whole, so they do not interleave.
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newDecisionLogger returns a decisionLogger for the profile of h, appending
+// to the file at path, which is closed when ctx is done, or writing to
+// stdout if path is empty. klog writes to stderr, so the lines on stdout are
+// the decision log alone.
+func newDecisionLogger(ctx context.Context, h framework.Handle, clock clock.PassiveClock, path string) (*decisionLogger, error) {
+	l := &decisionLogger{clock: clock, w: os.Stdout}
+	if f, ok := h.(interface{ ProfileName() string }); ok {
+		l.profile = f.ProfileName()
+	}
+	if path == "" {
+		return l, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l.w = file
+	go func() {
+		<-ctx.Done()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		file.Close()
+		l.w = io.Discard
+	}()
+	return l, nil
+}
+
+// log writes a record of the decision in s for the pod. node and colocated
+// describe where a bound pod landed.
+func (l *decisionLogger) log(pod *corev1.Pod, s *stateData, event, node string, colocated bool) {
+	if l == nil {
+		return
+	}
+	d := s.decision
+	record := decisionLogRecord{
+		Time:             l.clock.Now().UTC(),
+		Profile:          l.profile,
+		Event:            event,
+		Pod:              klog.KObj(pod).String(),
+		Mode:             d.Mode,
+		Outcome:          d.Outcome,
+		ShareManagerNode: d.ShareManagerNode,
+		Fallback:         d.Fallback,
+		FallbackReason:   s.fallbackReason,
+		Node:             node,
+		Colocated:        colocated,
+	}
+	for _, pl := range s.pins {
+		record.Pins = append(record.Pins, decisionLogPin{PVC: pl.pvc, PV: pl.pv, Pod: pl.pod, Source: pl.from})
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: error encoding decision log record", "pod", klog.KObj(pod))
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: error writing decision log", "pod", klog.KObj(pod))
+	}
+}
+
+// logUnschedulable writes the decision for an opted-in pod PostFilter left
+// unschedulable, once per decisionEventInterval per pod and Decision, since
+// the pod is retried every few seconds.
+func (p *Plugin) logUnschedulable(state *framework.CycleState, pod *corev1.Pod, status *framework.Status) {
+	if p.decisionLog == nil || status.Code() != framework.Unschedulable && status.Code() != framework.UnschedulableAndUnresolvable {
+		return
+	}
+	data, err := state.Read(stateKey)
+	if err != nil {
+		return
+	}
+	s, ok := data.(*stateData)
+	if !ok || s.mode == "" || hotplugOwner(pod) != "" {
+		return
+	}
+	d := s.decision
+	if p.decisions != nil && !p.decisions.allow(string(pod.UID)+"/decisionLog/"+d.Outcome+"/"+d.ShareManagerNode) {
+		return
+	}
+	p.decisionLog.log(pod, s, decisionLogUnschedulable, "", false)
+}
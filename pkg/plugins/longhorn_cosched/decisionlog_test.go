@@ -0,0 +1,85 @@
+package longhorn_cosched
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// readDecisionLog parses each line of the decision log at path.
+func readDecisionLog(t *testing.T, path string) []decisionLogRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening decision log: %v", err)
+	}
+	defer f.Close()
+	var records []decisionLogRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record decisionLogRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("decoding decision log line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading decision log: %v", err)
+	}
+	return records
+}
+
+// TestDecisionLog checks that the decision log gets one JSON line per bound
+// pod, and one per pod that stays unschedulable however often it is retried.
+func TestDecisionLog(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	path := filepath.Join(t.TempDir(), "decisions.log")
+
+	bound := makeVM("bound", vmNamespace, true, pvcName)
+	bound.UID = types.UID("bound")
+	// The share-manager node is too small for the 2-CPU VM.
+	pending := withCPU(makeVM("pending", vmNamespace, true, pvcName), "2")
+	pending.UID = types.UID("pending")
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "1"), makeNode("node-2", "4")},
+		objects: []runtime.Object{bound, pending, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+		args:    Args{DecisionLog: DecisionLogFormatJSON, DecisionLogPath: path},
+	})
+
+	state, _ := runFilters(t, fwk, bound)
+	plugin.PostBind(context.Background(), state, bound, "node-1")
+	for i := 0; i < 3; i++ {
+		state, m := runFilters(t, fwk, pending)
+		if _, status := plugin.PostFilter(context.Background(), state, pending, m); status.IsSuccess() {
+			t.Fatalf("PostFilter() = %v, want the pod unschedulable", status)
+		}
+	}
+
+	records := readDecisionLog(t, path)
+	if len(records) != 2 {
+		t.Fatalf("%d decision log records, want 2: %+v", len(records), records)
+	}
+	got := records[0]
+	if got.Profile != "kubevirt-scheduler" || got.Event != decisionLogBound || got.Pod != "default/bound" ||
+		got.Mode != ModeHard || got.Outcome != decisionPinned || got.ShareManagerNode != "node-1" || got.Node != "node-1" || !got.Colocated {
+		t.Errorf("bound record = %+v", got)
+	}
+	if len(got.Pins) != 1 || got.Pins[0] != (decisionLogPin{PVC: pvcName, PV: pvName, Source: LookupSourcePod}) {
+		t.Errorf("bound record pins = %+v, want the share-manager of PVC %s", got.Pins, pvcName)
+	}
+	got = records[1]
+	if got.Event != decisionLogUnschedulable || got.Pod != "default/pending" || got.Outcome != decisionPinned || got.ShareManagerNode != "node-1" || got.Node != "" {
+		t.Errorf("unschedulable record = %+v", got)
+	}
+}
@@ -0,0 +1,321 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// CoScheduleDecisionResource is the GroupVersionResource of the
+// CoScheduleDecision records PostBind writes when the DecisionRecords arg is
+// set. The CRD is in manifests/coscheduledecision-crd.yaml.
+var CoScheduleDecisionResource = schema.GroupVersionResource{
+	Group:    "scheduler.kubevirt-scheduler.io",
+	Version:  "v1alpha1",
+	Resource: "coscheduledecisions",
+}
+
+// DecisionRecordLabelSelector selects the CoScheduleDecisions the plugin
+// wrote, which it prunes to Args.DecisionRecordsPerNamespace.
+const DecisionRecordLabelSelector = "app.kubernetes.io/managed-by=kubevirt-scheduler"
+
+// DefaultDecisionRecordsPerNamespace is the number of CoScheduleDecisions
+// kept per namespace when Args.DecisionRecordsPerNamespace is unset.
+const DefaultDecisionRecordsPerNamespace = 500
+
+// Values of the operation label of decisionRecordErrors.
+const (
+	decisionRecordCreate  = "create"
+	decisionRecordUpdate  = "update"
+	decisionRecordPrune   = "prune"
+	decisionRecordDropped = "dropped"
+)
+
+// CoScheduleDecisionSpec is the spec of a CoScheduleDecision: the decision
+// of the scheduling cycle that bound a pod, and where the pod was bound.
+type CoScheduleDecisionSpec struct {
+	Pod PodReference `json:"pod"`
+
+	// VMI is the name of the VirtualMachineInstance that owns the pod, if
+	// any.
+	VMI string `json:"vmi,omitempty"`
+
+	// Node is the node the pod was bound to; Colocated is true if it hosts
+	// one of the pod's share-managers.
+	Node      string `json:"node"`
+	Colocated bool   `json:"colocated"`
+
+	// BoundAt is when PostBind wrote the record.
+	BoundAt metav1.Time `json:"boundAt"`
+
+	// Decision is the cycle's decision; its placements name the PVCs and
+	// PVs, the node of each share-manager and the lookup source that found
+	// it.
+	Decision `json:",inline"`
+}
+
+// PodReference names a pod and the incarnation of it that was bound.
+type PodReference struct {
+	Name string    `json:"name"`
+	UID  types.UID `json:"uid"`
+}
+
+const (
+	// decisionRecordQueueSize is the number of records waiting to be
+	// written; PostBind drops records beyond it.
+	decisionRecordQueueSize = 256
+
+	// decisionRecordPruneInterval is how often the namespaces records were
+	// written in are pruned to the per-namespace limit.
+	decisionRecordPruneInterval = time.Minute
+
+	// decisionRecordPageSize is the number of records listed per request
+	// when pruning.
+	decisionRecordPageSize = 500
+)
+
+// decisionRecorder writes the CoScheduleDecision records enabled by
+// Args.DecisionRecords. A record is named after the pod's
+// VirtualMachineInstance, so a VM rescheduled under the same VMI, e.g. by a
+// live migration, updates its record; a pod without a VMI gets a record of
+// its own. Records are owned by the VMI, or the pod, so the garbage
+// collector deletes them with it.
+//
+// PostBind only queues the records: a single worker writes them, and the
+// namespaces written in are pruned on a timer, so binding never waits for
+// the API server. Once a write finds the CRD missing, recording stops.
+//
+// A nil *decisionRecorder writes nothing.
+type decisionRecorder struct {
+	dynClient dynamic.Interface
+	clock     clock.PassiveClock
+	// limit is the number of records kept per namespace.
+	limit int
+
+	queue    chan decisionRecord
+	disabled atomic.Bool
+
+	// mu guards written, the namespaces records were written in since the
+	// last prune.
+	mu      sync.Mutex
+	written map[string]bool
+}
+
+// decisionRecord is a record waiting to be written.
+type decisionRecord struct {
+	pod *corev1.Pod
+	obj *unstructured.Unstructured
+}
+
+// newDecisionRecorder returns a decisionRecorder whose worker and pruning
+// stop when ctx is done.
+func newDecisionRecorder(ctx context.Context, dynClient dynamic.Interface, clock clock.PassiveClock, limit int) *decisionRecorder {
+	if limit == 0 {
+		limit = DefaultDecisionRecordsPerNamespace
+	}
+	r := &decisionRecorder{
+		dynClient: dynClient,
+		clock:     clock,
+		limit:     limit,
+		queue:     make(chan decisionRecord, decisionRecordQueueSize),
+		written:   map[string]bool{},
+	}
+	go r.run(ctx)
+	go wait.UntilWithContext(ctx, r.pruneWritten, decisionRecordPruneInterval)
+	return r
+}
+
+// record queues the record of the pod bound to nodeName with the decision.
+// A record that cannot be queued is dropped; failures are logged and counted
+// in decisionRecordErrors, and the pod is bound either way.
+func (r *decisionRecorder) record(pod *corev1.Pod, d Decision, nodeName string, colocated bool) {
+	if r == nil || r.disabled.Load() {
+		return
+	}
+	spec := CoScheduleDecisionSpec{
+		Pod:       PodReference{Name: pod.Name, UID: pod.UID},
+		Node:      nodeName,
+		Colocated: colocated,
+		BoundAt:   metav1.NewTime(r.clock.Now()),
+		Decision:  d,
+	}
+	name := pod.Name
+	owner := metav1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: pod.Name, UID: pod.UID}
+	if vmi := VMIOwner(pod); vmi != nil {
+		spec.VMI, name = vmi.Name, vmi.Name
+		owner = metav1.OwnerReference{APIVersion: vmi.APIVersion, Kind: vmi.Kind, Name: vmi.Name, UID: vmi.UID}
+	}
+	value, err := toUnstructuredSpec(spec)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/PostBind: error encoding CoScheduleDecision", "pod", klog.KObj(pod))
+		decisionRecordErrors.WithLabelValues(decisionRecordCreate).Inc()
+		return
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(CoScheduleDecisionResource.GroupVersion().String())
+	obj.SetKind("CoScheduleDecision")
+	obj.SetNamespace(pod.Namespace)
+	obj.SetName(name)
+	obj.SetLabels(map[string]string{"app.kubernetes.io/managed-by": "kubevirt-scheduler"})
+	obj.SetOwnerReferences([]metav1.OwnerReference{owner})
+	obj.Object["spec"] = value
+
+	select {
+	case r.queue <- decisionRecord{pod: pod, obj: obj}:
+	default:
+		klog.V(2).InfoS("LonghornCoSchedule/PostBind: CoScheduleDecision queue full, dropping record", "pod", klog.KObj(pod), "record", name)
+		decisionRecordErrors.WithLabelValues(decisionRecordDropped).Inc()
+	}
+}
+
+// run writes the queued records until ctx is done.
+func (r *decisionRecorder) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rec := <-r.queue:
+			if !r.disabled.Load() {
+				r.write(ctx, rec)
+			}
+		}
+	}
+}
+
+// write creates the record, or updates it if the VM already has one.
+func (r *decisionRecorder) write(ctx context.Context, rec decisionRecord) {
+	obj, name := rec.obj, rec.obj.GetName()
+	records := r.dynClient.Resource(CoScheduleDecisionResource).Namespace(obj.GetNamespace())
+	_, err := records.Create(ctx, obj, metav1.CreateOptions{})
+	switch {
+	case err == nil:
+		r.mu.Lock()
+		r.written[obj.GetNamespace()] = true
+		r.mu.Unlock()
+		return
+	case isResourceNotFound(err):
+		if !r.disabled.Swap(true) {
+			klog.InfoS("LonghornCoSchedule: the CoScheduleDecision CRD is not installed, no longer writing decision records",
+				"resource", CoScheduleDecisionResource.String(),
+				"err", err,
+			)
+		}
+		return
+	case !apierrors.IsAlreadyExists(err):
+		klog.ErrorS(err, "LonghornCoSchedule/PostBind: error creating CoScheduleDecision", "pod", klog.KObj(rec.pod), "record", name)
+		decisionRecordErrors.WithLabelValues(decisionRecordCreate).Inc()
+		return
+	}
+
+	// The VM was rescheduled: record where it is now.
+	existing, err := records.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		existing.SetOwnerReferences(obj.GetOwnerReferences())
+		existing.SetLabels(obj.GetLabels())
+		existing.Object["spec"] = obj.Object["spec"]
+		_, err = records.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/PostBind: error updating CoScheduleDecision", "pod", klog.KObj(rec.pod), "record", name)
+		decisionRecordErrors.WithLabelValues(decisionRecordUpdate).Inc()
+	}
+}
+
+// isResourceNotFound returns true if err says the CoScheduleDecision
+// resource is not served, rather than that the namespace is missing.
+func isResourceNotFound(err error) bool {
+	if meta.IsNoMatchError(err) {
+		return true
+	}
+	var status apierrors.APIStatus
+	if !apierrors.IsNotFound(err) || !errors.As(err, &status) {
+		return false
+	}
+	details := status.Status().Details
+	return details == nil || details.Kind != "namespaces"
+}
+
+// pruneWritten prunes the namespaces records were created in since the last
+// call.
+func (r *decisionRecorder) pruneWritten(ctx context.Context) {
+	r.mu.Lock()
+	namespaces := slices.Sorted(maps.Keys(r.written))
+	clear(r.written)
+	r.mu.Unlock()
+	for _, namespace := range namespaces {
+		r.prune(ctx, namespace)
+	}
+}
+
+// prune deletes the oldest records of the namespace, by spec.boundAt, beyond
+// the limit. The records are listed a page at a time.
+func (r *decisionRecorder) prune(ctx context.Context, namespace string) {
+	type entry struct{ name, boundAt string }
+	records := r.dynClient.Resource(CoScheduleDecisionResource).Namespace(namespace)
+	var entries []entry
+	opts := metav1.ListOptions{LabelSelector: DecisionRecordLabelSelector, Limit: decisionRecordPageSize}
+	for {
+		list, err := records.List(ctx, opts)
+		if err != nil {
+			klog.ErrorS(err, "LonghornCoSchedule: error listing CoScheduleDecisions", "namespace", namespace)
+			decisionRecordErrors.WithLabelValues(decisionRecordPrune).Inc()
+			return
+		}
+		for _, item := range list.Items {
+			boundAt, _, _ := unstructured.NestedString(item.Object, "spec", "boundAt")
+			entries = append(entries, entry{name: item.GetName(), boundAt: boundAt})
+		}
+		if opts.Continue = list.GetContinue(); opts.Continue == "" {
+			break
+		}
+	}
+	excess := len(entries) - r.limit
+	if excess <= 0 {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].boundAt != entries[j].boundAt {
+			return entries[i].boundAt < entries[j].boundAt
+		}
+		return entries[i].name < entries[j].name
+	})
+	for _, e := range entries[:excess] {
+		err := records.Delete(ctx, e.name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "LonghornCoSchedule: error deleting CoScheduleDecision", "namespace", namespace, "record", e.name)
+			decisionRecordErrors.WithLabelValues(decisionRecordPrune).Inc()
+		}
+	}
+}
+
+// toUnstructuredSpec returns the spec as the JSON object it is stored as.
+func toUnstructuredSpec(spec CoScheduleDecisionSpec) (map[string]interface{}, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,226 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// getDecisionRecord waits for the recorder to write a CoScheduleDecision in
+// the namespace that done accepts, and returns it and its spec.
+func getDecisionRecord(t *testing.T, plugin *Plugin, namespace, name string, done func(CoScheduleDecisionSpec) bool) (*unstructured.Unstructured, CoScheduleDecisionSpec) {
+	t.Helper()
+	var obj *unstructured.Unstructured
+	var spec CoScheduleDecisionSpec
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(ctx context.Context) (bool, error) {
+		var err error
+		obj, err = plugin.dynClient.Resource(CoScheduleDecisionResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		spec = CoScheduleDecisionSpec{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object["spec"].(map[string]interface{}), &spec); err != nil {
+			return false, err
+		}
+		return done == nil || done(spec), nil
+	})
+	if err != nil {
+		t.Fatalf("waiting for CoScheduleDecision %s/%s: %v", namespace, name, err)
+	}
+	return obj, spec
+}
+
+// TestDecisionRecords checks that PostBind records the decision for a
+// virt-launcher pod in a CoScheduleDecision owned by its VMI, updates the
+// record when the VMI's pod is bound again, and gives a pod without a VMI a
+// record owned by the pod.
+func TestDecisionRecords(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		vmiName     = "my-vm"
+		vmiUID      = types.UID("5d0b1c7e-2f41-4c1a-9a57-0c6f8d3e2b19")
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	launcher := makeLauncher(vmiName, vmNamespace, vmiUID, pvcName)
+	launcher.Annotations = map[string]string{AnnotationKey: AnnotationValue}
+	launcher.UID = "launcher-1"
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{launcher, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+		args:    Args{DecisionRecords: true},
+	})
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	fakeClock := clocktesting.NewFakePassiveClock(start)
+	plugin.records.clock = fakeClock
+
+	state, _ := runFilters(t, fwk, launcher)
+	plugin.PostBind(context.Background(), state, launcher, "node-1")
+
+	obj, spec := getDecisionRecord(t, plugin, vmNamespace, vmiName, nil)
+	if spec.Pod != (PodReference{Name: launcher.Name, UID: launcher.UID}) || spec.VMI != vmiName || spec.Node != "node-1" || !spec.Colocated ||
+		spec.Mode != ModeHard || spec.Outcome != decisionPinned || spec.ShareManagerNode != "node-1" || !spec.BoundAt.Time.Equal(start) {
+		t.Errorf("record spec = %+v", spec)
+	}
+	wantPlacement := ShareManagerPlacement{PVC: pvcName, PV: pvName, Node: "node-1", Source: LookupSourcePod, Pins: true}
+	if len(spec.Placements) != 1 || spec.Placements[0] != wantPlacement {
+		t.Errorf("record placements = %+v, want %+v", spec.Placements, wantPlacement)
+	}
+	wantOwner := metav1.OwnerReference{APIVersion: "kubevirt.io/v1", Kind: "VirtualMachineInstance", Name: vmiName, UID: vmiUID}
+	if owners := obj.GetOwnerReferences(); len(owners) != 1 || owners[0] != wantOwner {
+		t.Errorf("record owners = %+v, want the VMI %+v so the record is garbage-collected with it", owners, wantOwner)
+	}
+	if obj.GetLabels()["app.kubernetes.io/managed-by"] != "kubevirt-scheduler" {
+		t.Errorf("record labels = %v", obj.GetLabels())
+	}
+
+	// The VM is rescheduled, e.g. migrated, in a new pod of the same VMI.
+	rescheduled := launcher.DeepCopy()
+	rescheduled.Name, rescheduled.UID = "virt-launcher-"+vmiName+"-target", "launcher-2"
+	fakeClock.SetTime(start.Add(time.Hour))
+	state, _ = runFilters(t, fwk, rescheduled)
+	plugin.PostBind(context.Background(), state, rescheduled, "node-2")
+
+	_, spec = getDecisionRecord(t, plugin, vmNamespace, vmiName, func(spec CoScheduleDecisionSpec) bool { return spec.Pod.UID == "launcher-2" })
+	if spec.Pod.UID != "launcher-2" || spec.Node != "node-2" || spec.Colocated || !spec.BoundAt.Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("record spec after reschedule = %+v, want the new pod on node-2", spec)
+	}
+
+	// A pod without a VMI is recorded under its own name, owned by itself.
+	pod := makeVM("bare", vmNamespace, true, pvcName)
+	pod.UID = "bare"
+	state, _ = runFilters(t, fwk, pod)
+	plugin.PostBind(context.Background(), state, pod, "node-1")
+	obj, spec = getDecisionRecord(t, plugin, vmNamespace, "bare", nil)
+	wantOwner = metav1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: "bare", UID: "bare"}
+	if owners := obj.GetOwnerReferences(); len(owners) != 1 || owners[0] != wantOwner || spec.VMI != "" {
+		t.Errorf("record owners = %+v and VMI %q, want the pod %+v", owners, spec.VMI, wantOwner)
+	}
+}
+
+// TestDecisionRecordsRetention checks that pruning deletes the oldest records
+// of a namespace beyond DecisionRecordsPerNamespace.
+func TestDecisionRecordsRetention(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+		args:    Args{DecisionRecords: true, DecisionRecordsPerNamespace: 2},
+	})
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	fakeClock := clocktesting.NewFakePassiveClock(start)
+	plugin.records.clock = fakeClock
+
+	for i, name := range []string{"vm-c", "vm-b", "vm-a"} {
+		pod := makeVM(name, vmNamespace, true, pvcName)
+		pod.UID = types.UID(name)
+		fakeClock.SetTime(start.Add(time.Duration(i) * time.Minute))
+		state, _ := runFilters(t, fwk, pod)
+		plugin.PostBind(context.Background(), state, pod, "node-1")
+		getDecisionRecord(t, plugin, vmNamespace, name, nil)
+	}
+	plugin.records.pruneWritten(context.Background())
+
+	list, err := plugin.dynClient.Resource(CoScheduleDecisionResource).Namespace(vmNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	if len(names) != 2 || names[0] != "vm-a" || names[1] != "vm-b" {
+		t.Errorf("records = %v, want the two newest, vm-a and vm-b", names)
+	}
+}
+
+// TestDecisionRecordsFailure checks that a record that cannot be written is
+// counted and does not stop PostBind.
+func TestDecisionRecordsFailure(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	pod := makeVM("vm", vmNamespace, true, pvcName)
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{pod, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+		args:    Args{DecisionRecords: true},
+	})
+	plugin.dynClient.(*dynamicfake.FakeDynamicClient).PrependReactor("create", "coscheduledecisions", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("the server could not find the requested resource")
+	})
+	before, _ := testutil.GetCounterMetricValue(decisionRecordErrors.WithLabelValues(decisionRecordCreate))
+	divergentBefore, _ := testutil.GetCounterMetricValue(divergentBinds.WithLabelValues(string(ModeHard), divergentNotPinned))
+
+	state, _ := runFilters(t, fwk, pod)
+	plugin.PostBind(context.Background(), state, pod, "node-2")
+
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		after, err := testutil.GetCounterMetricValue(decisionRecordErrors.WithLabelValues(decisionRecordCreate))
+		return after == before+1, err
+	}); err != nil {
+		t.Errorf("decision_record_errors_total{operation=create} did not reach %v: %v", before+1, err)
+	}
+	if after, _ := testutil.GetCounterMetricValue(divergentBinds.WithLabelValues(string(ModeHard), divergentNotPinned)); after != divergentBefore+1 {
+		t.Errorf("divergent_total = %v, want %v: PostBind must go on after the record failed", after, divergentBefore+1)
+	}
+}
+
+// TestDecisionRecordsCRDMissing checks that recording stops once a write
+// finds the CRD missing, so later binds do not try again.
+func TestDecisionRecordsCRDMissing(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+		args:    Args{DecisionRecords: true},
+	})
+	var creates atomic.Int32
+	plugin.dynClient.(*dynamicfake.FakeDynamicClient).PrependReactor("create", "coscheduledecisions", func(k8stesting.Action) (bool, runtime.Object, error) {
+		creates.Add(1)
+		return true, nil, apierrors.NewNotFound(CoScheduleDecisionResource.GroupResource(), "")
+	})
+
+	pod := makeVM("vm-a", vmNamespace, true, pvcName)
+	state, _ := runFilters(t, fwk, pod)
+	plugin.PostBind(context.Background(), state, pod, "node-1")
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		return plugin.records.disabled.Load(), nil
+	}); err != nil {
+		t.Fatalf("recording not disabled after the CRD was found missing: %v", err)
+	}
+
+	pod = makeVM("vm-b", vmNamespace, true, pvcName)
+	state, _ = runFilters(t, fwk, pod)
+	plugin.PostBind(context.Background(), state, pod, "node-1")
+	if n := creates.Load(); n != 1 {
+		t.Errorf("%d creates, want 1: no record is written once the CRD is known to be missing", n)
+	}
+}
@@ -0,0 +1,77 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// crossCheckSources asks the other of the ShareManager CRD and the
+// share-manager pod sources once source has named the node of pl, if the
+// SourceDisagreementPolicy arg is set. If both name a node and the nodes
+// differ, e.g. because status.ownerID is stale after a failover, the
+// disagreement is logged and counted and the policy picks the placement.
+// Other sources are returned unchanged.
+func crossCheckSources(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pvName string, source LookupSource, pl shareManagerPlacement, opts lookupOptions) (shareManagerPlacement, error) {
+	if opts.disagreement == "" {
+		return pl, nil
+	}
+	var crd, pod shareManagerPlacement
+	var err error
+	switch source {
+	case LookupSourceShareManager:
+		crd = pl
+		pod, err = getShareManagerNodeFromPod(ctx, clientset, pvName, opts)
+	case LookupSourcePod:
+		pod = pl
+		crd, err = getShareManagerPlacementFromCRD(ctx, dynClient, pvName, opts)
+	default:
+		return pl, nil
+	}
+	if err != nil {
+		return pl, err
+	}
+	if crd.node == "" || pod.node == "" || crd.node == pod.node {
+		return pl, nil
+	}
+
+	chosen, winner := crd, LookupSourceShareManager
+	switch opts.disagreement {
+	case SourceDisagreementPolicyPod:
+		chosen, winner = pod, LookupSourcePod
+	case SourceDisagreementPolicyNewest:
+		if !pod.updated.Before(crd.updated) {
+			chosen, winner = pod, LookupSourcePod
+		}
+	}
+	chosen.from = winner
+	sourceDisagreements.WithLabelValues(string(opts.disagreement), string(winner)).Inc()
+	klog.V(2).InfoS("LonghornCoSchedule: ShareManager CRD and share-manager pod disagree on the node",
+		"pv", pvName,
+		"crdNode", crd.node,
+		"crdUpdated", crd.updated,
+		"podNode", pod.node,
+		"podCreated", pod.updated,
+		"sourceDisagreementPolicy", opts.disagreement,
+		"chosen", winner,
+	)
+	return chosen, nil
+}
+
+// lastUpdated returns when the object was last written according to its
+// managed fields, which record a time for every manager and subresource
+// (Longhorn writes status.ownerID through the status subresource), or its
+// creation time if it has none.
+func lastUpdated(obj *unstructured.Unstructured) time.Time {
+	updated := obj.GetCreationTimestamp().Time
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Time != nil && entry.Time.After(updated) {
+			updated = entry.Time.Time
+		}
+	}
+	return updated
+}
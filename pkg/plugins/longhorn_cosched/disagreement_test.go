@@ -0,0 +1,109 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestSourceDisagreement checks that with a SourceDisagreementPolicy the
+// ShareManager CRD and the share-manager pod are both asked, and that each
+// policy picks the expected node when they disagree.
+func TestSourceDisagreement(t *testing.T) {
+	const (
+		pvcName = "shared"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		crdNode = "node-1"
+		podNode = "node-2"
+	)
+	now := time.Now()
+
+	registerMetrics()
+
+	tests := []struct {
+		name        string
+		policy      SourceDisagreementPolicy
+		order       []LookupSource
+		podNode     string
+		crdUpdated  time.Time
+		podCreated  time.Time
+		wantNode    string
+		wantCounted LookupSource
+	}{
+		{name: "no policy, CRD first", podNode: podNode, wantNode: crdNode},
+		{name: "no policy, pod first", order: []LookupSource{LookupSourcePod, LookupSourceShareManager}, podNode: podNode, wantNode: podNode},
+		{name: "crd", policy: SourceDisagreementPolicyCRD, podNode: podNode, wantNode: crdNode, wantCounted: LookupSourceShareManager},
+		{
+			name:        "crd, pod first",
+			policy:      SourceDisagreementPolicyCRD,
+			order:       []LookupSource{LookupSourcePod, LookupSourceShareManager},
+			podNode:     podNode,
+			wantNode:    crdNode,
+			wantCounted: LookupSourceShareManager,
+		},
+		{name: "pod", policy: SourceDisagreementPolicyPod, podNode: podNode, wantNode: podNode, wantCounted: LookupSourcePod},
+		{
+			name:        "newest, pod newer (stale ownerID after failover)",
+			policy:      SourceDisagreementPolicyNewest,
+			podNode:     podNode,
+			crdUpdated:  now.Add(-time.Hour),
+			podCreated:  now.Add(-time.Minute),
+			wantNode:    podNode,
+			wantCounted: LookupSourcePod,
+		},
+		{
+			name:        "newest, CRD newer (pod not replaced yet)",
+			policy:      SourceDisagreementPolicyNewest,
+			podNode:     podNode,
+			crdUpdated:  now.Add(-time.Minute),
+			podCreated:  now.Add(-time.Hour),
+			wantNode:    crdNode,
+			wantCounted: LookupSourceShareManager,
+		},
+		{name: "agreement", policy: SourceDisagreementPolicyPod, podNode: crdNode, wantNode: crdNode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smPod := makeShareManagerPod(pvName, tt.podNode)
+			smPod.CreationTimestamp = metav1.NewTime(tt.podCreated)
+			clientset := fake.NewSimpleClientset(makePVC(pvcName, "default", pvName), smPod)
+			serveShareManagers(clientset, shareManagerGVR.Version)
+			cr := makeShareManagerCR(pvName, crdNode, "running")
+			if !tt.crdUpdated.IsZero() {
+				updated := metav1.NewTime(tt.crdUpdated)
+				cr.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: "longhorn-manager", Subresource: "status", Time: &updated}})
+			}
+			opts := lookupOptions{
+				api:          newShareManagerAPI(clientset.Discovery(), clocktesting.NewFakePassiveClock(now)),
+				order:        tt.order,
+				disagreement: tt.policy,
+			}
+
+			var before float64
+			if tt.wantCounted != "" {
+				before, _ = testutil.GetCounterMetricValue(sourceDisagreements.WithLabelValues(string(tt.policy), string(tt.wantCounted)))
+			}
+
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, newDynamicClient(cr), "default", pvcName, opts)
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode {
+				t.Errorf("getShareManagerNodeForPVC() node = %q, want %q", pl.node, tt.wantNode)
+			}
+
+			if tt.wantCounted != "" {
+				after, _ := testutil.GetCounterMetricValue(sourceDisagreements.WithLabelValues(string(tt.policy), string(tt.wantCounted)))
+				if after-before != 1 {
+					t.Errorf("disagreements counted = %v, want 1", after-before)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultDriftLeaseNamespace is the namespace of the drift check's Lease
+	// when Args.DriftLeaseNamespace is unset.
+	DefaultDriftLeaseNamespace = "kube-system"
+
+	// driftLeaseName is the name of the Lease held by the scheduler instance
+	// running the drift check.
+	driftLeaseName = "kubevirt-scheduler-drift"
+)
+
+// Values of the state label of the drift gauges.
+const (
+	driftColocated = "colocated"
+	driftDivergent = "divergent"
+)
+
+// runDriftReconciler runs reconcileDrift at the interval while this scheduler
+// instance holds the drift Lease, so only one instance publishes the drift
+// gauges. An instance that loses the Lease clears its gauges and campaigns
+// again until ctx is done.
+func (p *Plugin) runDriftReconciler(ctx context.Context, interval time.Duration, namespace string) {
+	hostname, _ := os.Hostname()
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: driftLeaseName},
+		Client:     p.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: hostname + "_" + string(uuid.NewUUID())},
+	}
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            driftLeaseName,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				if p.vmiInformer != nil && !cache.WaitForCacheSync(ctx.Done(), p.vmiInformer.Informer().HasSynced) {
+					return
+				}
+				klog.V(2).InfoS("LonghornCoSchedule: running the co-location drift check", "interval", interval)
+				wait.UntilWithContext(ctx, p.reconcileDrift, interval)
+			},
+			OnStoppedLeading: func() {
+				driftPods.Reset()
+				driftNamespacePods.Reset()
+			},
+		},
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, config)
+	}, config.RetryPeriod)
+}
+
+// reconcileDrift counts the running opted-in virt-launcher pods that run on
+// a node hosting one of their share-managers (colocated) or none of them
+// (divergent), and publishes the counts in the drift gauges, in total and by
+// namespace. Everything is read from the informer caches only: the pods'
+// modes, their VMIs from the VMI informer, and the Running share-manager
+// pods of their co-scheduled RWX PVCs. Pods with no share-manager are not
+// counted.
+func (p *Plugin) reconcileDrift(ctx context.Context) {
+	if p.podLister == nil || p.pvcLister == nil {
+		return
+	}
+	pods, err := p.podLister.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: listing pods for the drift check")
+		return
+	}
+
+	total := map[string]int{}
+	byNamespace := map[string]map[string]int{}
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning || pod.Spec.NodeName == "" || VMIOwner(pod) == nil {
+			continue
+		}
+		if p.mode(ctx, pod) == "" {
+			continue
+		}
+		nodes := p.cachedShareManagerNodes(ctx, pod)
+		if len(nodes) == 0 {
+			continue
+		}
+		state := driftDivergent
+		if nodes[pod.Spec.NodeName] {
+			state = driftColocated
+		}
+		total[state]++
+		if byNamespace[pod.Namespace] == nil {
+			byNamespace[pod.Namespace] = map[string]int{}
+		}
+		byNamespace[pod.Namespace][state]++
+	}
+
+	for _, state := range []string{driftColocated, driftDivergent} {
+		driftPods.WithLabelValues(state).Set(float64(total[state]))
+	}
+	driftNamespacePods.Reset()
+	for namespace, counts := range byNamespace {
+		for _, state := range []string{driftColocated, driftDivergent} {
+			driftNamespacePods.WithLabelValues(namespace, state).Set(float64(counts[state]))
+		}
+	}
+	klog.V(4).InfoS("LonghornCoSchedule: co-location drift checked",
+		"colocated", total[driftColocated],
+		"divergent", total[driftDivergent],
+	)
+}
+
+// cachedShareManagerNodes returns the nodes of the Running share-manager pods
+// of the pod's co-scheduled RWX PVCs, as found in the informer caches.
+func (p *Plugin) cachedShareManagerNodes(ctx context.Context, pod *corev1.Pod) map[string]bool {
+	smPods, err := p.podLister.Pods(p.lookupOptions(pod).longhornNamespace()).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	nodes := map[string]bool{}
+	for _, pvcName := range coSchedulePVCNames(pod, p.hotplugPVCNames(ctx, pod)) {
+		pvc, err := p.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(pvcName)
+		if err != nil || !IsRWX(pvc) || pvc.Spec.VolumeName == "" {
+			continue
+		}
+		for _, smPod := range smPods {
+			if isShareManagerPodFor(smPod, pvc.Spec.VolumeName) && smPod.DeletionTimestamp == nil &&
+				smPod.Status.Phase == corev1.PodRunning && smPod.Spec.NodeName != "" {
+				nodes[smPod.Spec.NodeName] = true
+			}
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,92 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// TestReconcileDrift checks that the drift check counts running opted-in
+// virt-launcher pods by whether they run next to one of their share-managers,
+// in total and by namespace, from the informer caches.
+func TestReconcileDrift(t *testing.T) {
+	registerMetrics()
+
+	// launcher returns a virt-launcher pod in namespace on node using a PVC
+	// named after the VMI, whose PV is "pv-" + vmiName.
+	launcher := func(vmiName, namespace, node string, optedIn bool, phase corev1.PodPhase) *corev1.Pod {
+		pod := makeLauncher(vmiName, namespace, types.UID(vmiName+"-uid"), vmiName)
+		if optedIn {
+			pod.Annotations = map[string]string{AnnotationKey: AnnotationValue}
+		}
+		pod.Spec.NodeName = node
+		pod.Status.Phase = phase
+		return pod
+	}
+	pods := []*corev1.Pod{
+		launcher("a1", "team-a", "node-1", true, corev1.PodRunning),
+		launcher("a2", "team-a", "node-2", true, corev1.PodRunning),
+		launcher("b1", "team-b", "node-2", true, corev1.PodRunning),
+		launcher("b2", "team-b", "node-1", false, corev1.PodRunning),
+		launcher("b3", "team-b", "node-1", true, corev1.PodPending),
+		launcher("b4", "team-b", "node-1", true, corev1.PodRunning),
+	}
+	objects := []runtime.Object{
+		makePVC("a1", "team-a", "pv-a1"), makeShareManagerPod("pv-a1", "node-1"),
+		makePVC("a2", "team-a", "pv-a2"), makeShareManagerPod("pv-a2", "node-1"),
+		makePVC("b1", "team-b", "pv-b1"), makeShareManagerPod("pv-b1", "node-2"),
+		makePVC("b2", "team-b", "pv-b2"), makeShareManagerPod("pv-b2", "node-2"),
+		makePVC("b3", "team-b", "pv-b3"), makeShareManagerPod("pv-b3", "node-2"),
+		makePVC("b4", "team-b", "pv-b4"),
+	}
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+	_, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: objects,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	plugin.vmiInformer = watchVMIs(ctx, plugin.dynClient)
+	if !cache.WaitForCacheSync(ctx.Done(), plugin.vmiInformer.Informer().HasSynced) {
+		t.Fatal("VMI informer did not sync")
+	}
+	dynClient := plugin.dynClient.(*dynamicfake.FakeDynamicClient)
+	dynClient.ClearActions()
+
+	plugin.reconcileDrift(ctx)
+
+	for _, action := range dynClient.Actions() {
+		if action.GetVerb() == "get" {
+			t.Errorf("drift check read %s from the API, want the informer caches only", action.GetResource().Resource)
+		}
+	}
+
+	for _, tt := range []struct {
+		namespace string
+		state     string
+		want      float64
+	}{
+		{state: driftColocated, want: 2},
+		{state: driftDivergent, want: 1},
+		{namespace: "team-a", state: driftColocated, want: 1},
+		{namespace: "team-a", state: driftDivergent, want: 1},
+		{namespace: "team-b", state: driftColocated, want: 1},
+		{namespace: "team-b", state: driftDivergent, want: 0},
+	} {
+		gauge := driftPods.WithLabelValues(tt.state)
+		if tt.namespace != "" {
+			gauge = driftNamespacePods.WithLabelValues(tt.namespace, tt.state)
+		}
+		if got, _ := testutil.GetGaugeMetricValue(gauge); got != tt.want {
+			t.Errorf("drift gauge {namespace=%q, state=%q} = %v, want %v", tt.namespace, tt.state, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package longhorn_cosched
+
+import (
+	"context"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// getEndpointsPlacement reads the EndpointSlices of the share-manager's
+// Service, which Longhorn names after the PV in the Longhorn namespace, and
+// returns the node of its endpoint. Ready endpoints are preferred. A
+// share-manager pod that is not Ready yet only has a not-ready endpoint; like
+// a Pending pod for the pod source, its node is returned only with
+// opts.acceptPending (reported as pending) or opts.requireReady (reported as
+// notReady). Terminating endpoints are ignored. Failures are logged and yield
+// an empty node, as do lookups skipped after repeated Forbidden errors.
+func getEndpointsPlacement(ctx context.Context, clientset kubernetes.Interface, pvName string, opts lookupOptions) shareManagerPlacement {
+	path, namespace := string(LookupSourceEndpoints), opts.longhornNamespace()
+	if !opts.forbidden.allow(path, namespace) {
+		return shareManagerPlacement{}
+	}
+	selector := labels.Set{discoveryv1.LabelServiceName: pvName}
+	slices, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	opts.observe(path, namespace, err)
+	if err != nil {
+		klog.V(4).InfoS("LonghornCoSchedule: listing share-manager EndpointSlices failed", "pv", pvName, "err", err)
+		return shareManagerPlacement{}
+	}
+
+	var notReadyNode string
+	for _, slice := range slices.Items {
+		for _, ep := range slice.Endpoints {
+			if ep.NodeName == nil || *ep.NodeName == "" {
+				continue
+			}
+			if ep.Conditions.Terminating != nil && *ep.Conditions.Terminating {
+				continue
+			}
+			// A nil Ready condition means ready.
+			if ep.Conditions.Ready == nil || *ep.Conditions.Ready {
+				klog.V(5).InfoS("LonghornCoSchedule: share-manager endpoint found", "pv", pvName, "endpointSlice", slice.Name, "node", *ep.NodeName)
+				return shareManagerPlacement{node: *ep.NodeName}
+			}
+			if notReadyNode == "" {
+				notReadyNode = *ep.NodeName
+			}
+		}
+	}
+
+	if notReadyNode == "" || (!opts.acceptPending && !opts.requireReady) {
+		return shareManagerPlacement{}
+	}
+	klog.V(5).InfoS("LonghornCoSchedule: share-manager endpoint not ready", "pv", pvName, "node", notReadyNode)
+	return shareManagerPlacement{node: notReadyNode, pending: opts.acceptPending, notReady: opts.requireReady}
+}
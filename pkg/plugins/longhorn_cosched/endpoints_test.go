@@ -0,0 +1,121 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// makeShareManagerEndpointSlice creates an EndpointSlice of the
+// share-manager Service of the PV with one endpoint on the node.
+func makeShareManagerEndpointSlice(pvName, nodeName string, ready, terminating bool) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pvName + "-" + nodeName,
+			Namespace: LonghornNamespace,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: pvName},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{{
+			Addresses:  []string{"10.42.0.10"},
+			NodeName:   &nodeName,
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready, Terminating: &terminating},
+		}},
+	}
+}
+
+// TestEndpointsLookup checks that the endpoints source names the node of a
+// ready share-manager endpoint, and the node of a not-ready one only with
+// acceptPending or requireReady.
+func TestEndpointsLookup(t *testing.T) {
+	const (
+		pvcName = "shared"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	endpointsOnly := []LookupSource{LookupSourceEndpoints}
+
+	tests := []struct {
+		name          string
+		slices        []runtime.Object
+		order         []LookupSource
+		acceptPending bool
+		requireReady  bool
+		wantNode      string
+		wantPending   bool
+		wantNotReady  bool
+	}{
+		{
+			name:     "ready",
+			slices:   []runtime.Object{makeShareManagerEndpointSlice(pvName, "node-1", true, false)},
+			order:    endpointsOnly,
+			wantNode: "node-1",
+		},
+		{
+			name:   "not ready",
+			slices: []runtime.Object{makeShareManagerEndpointSlice(pvName, "node-1", false, false)},
+			order:  endpointsOnly,
+		},
+		{
+			name:          "not ready, pending accepted",
+			slices:        []runtime.Object{makeShareManagerEndpointSlice(pvName, "node-1", false, false)},
+			order:         endpointsOnly,
+			acceptPending: true,
+			wantNode:      "node-1",
+			wantPending:   true,
+		},
+		{
+			name:         "not ready, ready required",
+			slices:       []runtime.Object{makeShareManagerEndpointSlice(pvName, "node-1", false, false)},
+			order:        endpointsOnly,
+			requireReady: true,
+			wantNode:     "node-1",
+			wantNotReady: true,
+		},
+		{
+			name: "ready preferred over not ready",
+			slices: []runtime.Object{
+				makeShareManagerEndpointSlice(pvName, "node-1", false, false),
+				makeShareManagerEndpointSlice(pvName, "node-2", true, false),
+			},
+			order:         endpointsOnly,
+			acceptPending: true,
+			wantNode:      "node-2",
+		},
+		{
+			name:          "terminating",
+			slices:        []runtime.Object{makeShareManagerEndpointSlice(pvName, "node-1", false, true)},
+			order:         endpointsOnly,
+			acceptPending: true,
+		},
+		{
+			name:   "another Service",
+			slices: []runtime.Object{makeShareManagerEndpointSlice("pvc-other", "node-1", true, false)},
+			order:  endpointsOnly,
+		},
+		{
+			name:   "not in the default order",
+			slices: []runtime.Object{makeShareManagerEndpointSlice(pvName, "node-1", true, false)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append([]runtime.Object{makePVC(pvcName, "default", pvName)}, tt.slices...)
+			clientset := fake.NewSimpleClientset(objects...)
+			opts := lookupOptions{order: tt.order, acceptPending: tt.acceptPending, requireReady: tt.requireReady}
+
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, newDynamicClient(), "default", pvcName, opts)
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode || pl.pending != tt.wantPending || pl.notReady != tt.wantNotReady {
+				t.Errorf("getShareManagerNodeForPVC() = node %q, pending %v, notReady %v, want %q, %v, %v",
+					pl.node, pl.pending, pl.notReady, tt.wantNode, tt.wantPending, tt.wantNotReady)
+			}
+		})
+	}
+}
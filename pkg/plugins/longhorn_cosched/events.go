@@ -0,0 +1,124 @@
+package longhorn_cosched
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/names"
+)
+
+const (
+	// pinnedReason is the reason of the Normal event emitted on a hard-mode
+	// pod when it is pinned to its share-manager node.
+	pinnedReason = "CoScheduledWithShareManager"
+
+	// shareManagerNodeUnavailableReason is the reason of the warning event
+	// emitted on a hard-mode pod that no node fits because its share-manager
+	// node cannot take it and PostFilter could not make room there.
+	shareManagerNodeUnavailableReason = "ShareManagerNodeUnavailable"
+
+	// decisionEventInterval is how long the plugin waits before emitting the
+	// same decision event on a pod again. An unschedulable pod is retried
+	// every few seconds and would otherwise collect an event every time.
+	decisionEventInterval = time.Hour
+)
+
+// emitDecision emits an event explaining a co-scheduling decision on the
+// pod, unless the SuppressDecisionEvents arg is set or the same event was
+// emitted on the pod less than decisionEventInterval ago.
+func (p *Plugin) emitDecision(pod *corev1.Pod, eventtype, reason, action, note string) {
+	p.emitDecisionOnce(pod, note, eventtype, reason, action, note)
+}
+
+// emitDecisionOnce is emitDecision for events that are de-duplicated by key
+// rather than by message: an event with the same reason and key is not
+// emitted on the pod again for decisionEventInterval, even if its message
+// changed.
+func (p *Plugin) emitDecisionOnce(pod *corev1.Pod, key, eventtype, reason, action, note string) {
+	if p.args.SuppressDecisionEvents {
+		return
+	}
+	p.emitOnce(pod, key, eventtype, reason, action, note)
+}
+
+// emitWarning emits a warning event on the pod unless the same one was
+// emitted on it less than decisionEventInterval ago. Unlike emitDecision, it
+// is not turned off by SuppressDecisionEvents: it is for the warnings about
+// share-managers and PVCs that are ignored and share-manager nodes that
+// cannot be used, which the plugin emitted long before decision events.
+func (p *Plugin) emitWarning(pod *corev1.Pod, reason, note string) {
+	p.emitOnce(pod, note, corev1.EventTypeWarning, reason, "Schedule", note)
+}
+
+// emitOnce emits an event on the pod unless one with the same reason and key
+// was emitted on it less than decisionEventInterval ago.
+func (p *Plugin) emitOnce(pod *corev1.Pod, key, eventtype, reason, action, note string) {
+	if p.handle == nil {
+		return
+	}
+	if p.decisions != nil && !p.decisions.allow(string(pod.UID)+"/"+reason+"/"+key) {
+		klog.V(5).InfoS("LonghornCoSchedule: event already emitted", "pod", klog.KObj(pod), "reason", reason)
+		return
+	}
+	p.handle.EventRecorder().Eventf(pod, nil, eventtype, reason, action, "%s", note)
+}
+
+// reportPinned emits a Normal event on a hard-mode pod pinned to its
+// share-manager node, naming what holds it there.
+func (p *Plugin) reportPinned(pod *corev1.Pod, data *stateData) {
+	d := data.decision
+	if d.Mode != ModeHard || d.Outcome != decisionPinned {
+		return
+	}
+	p.emitDecision(pod, corev1.EventTypeNormal, pinnedReason, "Schedule",
+		fmt.Sprintf("Co-scheduling on node %q, where %s", d.ShareManagerNode, d.pinnedBy()))
+}
+
+// reportShareManagerNodeUnavailable emits a warning event on a hard-mode pod
+// that fits no node, naming its share-manager node, the PVCs whose
+// share-managers run there and the constraint that keeps the pod off it. The
+// aggregate "0/N nodes are available" status does not say which node
+// mattered. The event is emitted at most once per decisionEventInterval per
+// pod.
+func (p *Plugin) reportShareManagerNodeUnavailable(pod *corev1.Pod, data *stateData, m framework.NodeToStatusReader) {
+	d := data.decision
+	pvcs := data.pinnedPVCs()
+	of := ""
+	if len(pvcs) > 0 {
+		of = " (PVC " + strings.Join(pvcs, ", ") + ")"
+	}
+	blocker := describeBlocker(m.Get(d.ShareManagerNode))
+	klog.V(2).InfoS("LonghornCoSchedule/PostFilter: share-manager node cannot take the pod",
+		"pod", klog.KObj(pod),
+		"shareManagerNode", d.ShareManagerNode,
+		"pvcs", pvcs,
+		"reason", blocker,
+	)
+	p.emitDecisionOnce(pod, "", corev1.EventTypeWarning, shareManagerNodeUnavailableReason, "Schedule",
+		fmt.Sprintf("Pod is pinned to share-manager node %q%s, which cannot take it: %s", d.ShareManagerNode, of, blocker))
+}
+
+// describeBlocker names the constraint behind the Filter status of the
+// share-manager node: resources, a taint, node affinity, or whatever the
+// rejecting plugin reported.
+func describeBlocker(status *framework.Status) string {
+	if status == nil || status.IsSuccess() {
+		return "it was not evaluated"
+	}
+	msg := status.Message()
+	switch status.Plugin() {
+	case names.NodeResourcesFit:
+		return "insufficient resources (" + msg + ")"
+	case names.TaintToleration:
+		return "untolerated taint (" + msg + ")"
+	case names.NodeAffinity:
+		return "node affinity (" + msg + ")"
+	case "", Name:
+		return msg
+	}
+	return fmt.Sprintf("%s (%s)", msg, status.Plugin())
+}
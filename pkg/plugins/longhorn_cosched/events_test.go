@@ -0,0 +1,209 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// TestDecisionEvents checks the events explaining co-scheduling decisions:
+// a Normal event when a hard-mode pod is pinned, a warning when the hard
+// filter is relaxed or the share-manager node cannot take the pod, none of
+// them twice for the same pod, and under SuppressDecisionEvents only the
+// warnings that do not explain a decision.
+func TestDecisionEvents(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	cordoned := makeNode("node-1", "4")
+	cordoned.Spec.Unschedulable = true
+
+	soft := makeVM("vm", vmNamespace, true, pvcName)
+	soft.Annotations[AnnotationKey] = string(ModeSoft)
+
+	tests := []struct {
+		name   string
+		pod    *corev1.Pod
+		smNode *corev1.Node
+		args   Args
+		// want are the expected events as "type reason", in order; each must
+		// contain the matching wantText.
+		want     []string
+		wantText []string
+	}{
+		{
+			name:     "hard, pinned",
+			pod:      makeVM("vm", vmNamespace, true, pvcName),
+			want:     []string{"Normal " + pinnedReason},
+			wantText: []string{`Co-scheduling on node "node-1", where Longhorn share-manager for PVC default/shared (pv ` + pvName + `) is running`},
+		},
+		{
+			name:     "hard, share-manager node cordoned",
+			pod:      makeVM("vm", vmNamespace, true, pvcName),
+			smNode:   cordoned,
+			args:     Args{CordonedNodePolicy: CordonedNodePolicySoft},
+			want:     []string{"Warning " + cordonedReason},
+			wantText: []string{`node "node-1" is cordoned`},
+		},
+		{
+			name:     "hard, share-manager node too small",
+			pod:      withCPU(makeVM("vm", vmNamespace, true, pvcName), "2"),
+			smNode:   makeNode("node-1", "1"),
+			want:     []string{"Normal " + pinnedReason, "Warning " + shareManagerNodeUnavailableReason},
+			wantText: []string{`on node "node-1"`, `node "node-1" (PVC shared), which cannot take it: insufficient resources (Insufficient cpu)`},
+		},
+		{
+			name: "hard, suppressed",
+			pod:  withCPU(makeVM("vm", vmNamespace, true, pvcName), "2"),
+			args: Args{SuppressDecisionEvents: true},
+		},
+		{
+			name:     "hard, share-manager node cordoned, suppressed",
+			pod:      makeVM("vm", vmNamespace, true, pvcName),
+			smNode:   cordoned,
+			args:     Args{CordonedNodePolicy: CordonedNodePolicySoft, SuppressDecisionEvents: true},
+			want:     []string{"Warning " + cordonedReason},
+			wantText: []string{`node "node-1" is cordoned`},
+		},
+		{
+			name: "soft",
+			pod:  soft,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smNode := tt.smNode
+			if smNode == nil {
+				smNode = makeNode("node-1", "4")
+			}
+			recorder := events.NewFakeRecorder(10)
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{smNode, makeNode("node-2", "1")},
+				objects:  []runtime.Object{tt.pod, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+				args:     tt.args,
+				recorder: recorder,
+			})
+
+			// The scheduler retries an unschedulable pod; the second cycle
+			// must not repeat the events.
+			for i := 0; i < 2; i++ {
+				state, m := runFilters(t, fwk, tt.pod)
+				if m.Len() == 2 {
+					plugin.PostFilter(context.Background(), state, tt.pod, m)
+				}
+			}
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				got = append(got, <-recorder.Events)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			for i, e := range got {
+				if !strings.HasPrefix(e, tt.want[i]+" ") || !strings.Contains(e, tt.wantText[i]) {
+					t.Errorf("event %d = %q, want %q containing %q", i, e, tt.want[i], tt.wantText[i])
+				}
+			}
+		})
+	}
+}
+
+// TestShareManagerNodeUnavailableEvent checks that a hard-mode pod that fits
+// no node gets one warning event naming its share-manager node, the PVC and
+// the constraint blocking the node, however often it is retried and even if
+// the constraint changes.
+func TestShareManagerNodeUnavailableEvent(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	tainted := makeNode("node-1", "4")
+	tainted.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "storage", Effect: corev1.TaintEffectNoSchedule}}
+
+	selective := makeVM("vm", vmNamespace, true, pvcName)
+	selective.Spec.NodeSelector = map[string]string{"gpu": "true"}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		smNode   *corev1.Node
+		wantText string
+	}{
+		{
+			name:     "resources",
+			pod:      withCPU(makeVM("vm", vmNamespace, true, pvcName), "2"),
+			smNode:   makeNode("node-1", "1"),
+			wantText: `Pod is pinned to share-manager node "node-1" (PVC shared), which cannot take it: insufficient resources (Insufficient cpu)`,
+		},
+		{
+			name:     "taint",
+			pod:      makeVM("vm", vmNamespace, true, pvcName),
+			smNode:   tainted,
+			wantText: `which cannot take it: share-manager node "node-1" has taint dedicated=storage:NoSchedule that the pod does not tolerate`,
+		},
+		{
+			name:     "node selector",
+			pod:      selective,
+			smNode:   makeNode("node-1", "4"),
+			wantText: `which cannot take it: share-manager is on node "node-1" which does not match the pod's node selector "gpu=true"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := events.NewFakeRecorder(10)
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{tt.smNode, makeNode("node-2", "1")},
+				objects:  []runtime.Object{tt.pod, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+				recorder: recorder,
+			})
+
+			for i := 0; i < 3; i++ {
+				state, m := runFilters(t, fwk, tt.pod)
+				if m.Len() != 2 {
+					t.Fatalf("%d nodes rejected, want 2", m.Len())
+				}
+				if i == 2 {
+					// A different constraint is not reported again either.
+					m.Set("node-1", framework.NewStatus(framework.Unschedulable, "something else"))
+				}
+				plugin.PostFilter(context.Background(), state, tt.pod, m)
+			}
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				if e := <-recorder.Events; strings.Contains(e, shareManagerNodeUnavailableReason) {
+					got = append(got, e)
+				}
+			}
+			if len(got) != 1 {
+				t.Fatalf("%s events = %v, want exactly one", shareManagerNodeUnavailableReason, got)
+			}
+			if !strings.HasPrefix(got[0], corev1.EventTypeWarning) || !strings.Contains(got[0], tt.wantText) {
+				t.Errorf("event = %q, want a warning containing %q", got[0], tt.wantText)
+			}
+		})
+	}
+}
+
+func TestDecodeArgsSuppressDecisionEvents(t *testing.T) {
+	args, err := decodeArgs(&runtime.Unknown{Raw: []byte(`{"suppressDecisionEvents":true}`), ContentType: runtime.ContentTypeJSON})
+	if err != nil {
+		t.Fatalf("decodeArgs() error = %v", err)
+	}
+	if !args.SuppressDecisionEvents {
+		t.Errorf("SuppressDecisionEvents = false, want true")
+	}
+}
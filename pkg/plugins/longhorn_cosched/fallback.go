@@ -0,0 +1,259 @@
+package longhorn_cosched
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/helper"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/noderesources"
+)
+
+const (
+	// cordonedReason is the reason of the events emitted when the
+	// share-manager node is cordoned.
+	cordonedReason = "ShareManagerNodeCordoned"
+
+	// staleOwnerReason is the reason of the event emitted when the
+	// share-manager node does not exist.
+	staleOwnerReason = "ShareManagerNodeNotFound"
+
+	// nodeNotReadyReason is the reason of the event emitted when the
+	// share-manager node has been NotReady for too long.
+	nodeNotReadyReason = "ShareManagerNodeNotReady"
+
+	// taintedReason is the reason of the event emitted when the
+	// share-manager node carries a failure NoExecute taint.
+	taintedReason = "ShareManagerNodeTainted"
+
+	// untoleratedTaintReason is the reason of the event emitted when the
+	// pod does not tolerate a taint of the share-manager node.
+	untoleratedTaintReason = "ShareManagerNodeTaintNotTolerated"
+
+	// nodeAffinityReason is the reason of the event emitted when the pod's
+	// node selector does not match the share-manager node.
+	nodeAffinityReason = "ShareManagerNodeAffinityMismatch"
+
+	// insufficientResourcesReason is the reason of the event emitted when
+	// the share-manager node cannot fit the pod.
+	insufficientResourcesReason = "ShareManagerNodeInsufficientResources"
+
+	// longhornUnschedulableReason is the reason of the event emitted when,
+	// under LonghornNodePolicyFilter, Longhorn considers the share-manager
+	// node unschedulable for storage.
+	longhornUnschedulableReason = "ShareManagerNodeLonghornUnschedulable"
+
+	// missingNodeTagsReason is the reason of the event emitted when, under
+	// VolumeNodeTagPolicyFilter, the share-manager node lacks a node tag the
+	// pod's Longhorn Volumes select.
+	missingNodeTagsReason = "ShareManagerNodeMissingVolumeTags"
+)
+
+// checkShareManagerNode looks up the share-manager node of a hard-mode pod in
+// the scheduler's snapshot and relaxes the hard filter for this cycle when the
+// node cannot take the pod and the configured policy allows it. A node that
+// does not exist (stale ownerID) or is being evacuated (failure NoExecute
+// taints) is ignored altogether.
+func (p *Plugin) checkShareManagerNode(pod *corev1.Pod, data *stateData) {
+	if data.mode != ModeHard || data.shareManagerNode == "" || p.handle == nil {
+		return
+	}
+	nodeInfo, err := p.handle.SnapshotSharedLister().NodeInfos().Get(data.shareManagerNode)
+	if err != nil || nodeInfo.Node() == nil {
+		p.ignoreShareManager(pod, data, staleOwnerReason, fmt.Sprintf("share-manager node %q does not exist; the ShareManager ownerID is stale", data.shareManagerNode))
+		return
+	}
+	node := nodeInfo.Node()
+
+	if taint := p.failureTaint(node); taint != nil {
+		p.ignoreShareManager(pod, data, taintedReason, fmt.Sprintf("share-manager node %q has the %s taint and is being evacuated", node.Name, taint.ToString()))
+		return
+	}
+
+	if since, ok := p.notReadyFor(node); ok {
+		p.fallBack(pod, data, nodeNotReadyReason, fmt.Sprintf("share-manager node %q has been NotReady for %s (shareManagerNodeNotReadyTimeout %s)",
+			node.Name, since.Round(time.Second), p.args.ShareManagerNodeNotReadyTimeout.Duration))
+		return
+	}
+
+	// Filter rejects the node under these filter policies; pinning the pod
+	// to it would reject every node.
+	if p.args.longhornNodePolicy() == LonghornNodePolicyFilter {
+		if reason := p.longhornNodeUnschedulable(node.Name); reason != "" {
+			p.fallBack(pod, data, longhornUnschedulableReason, fmt.Sprintf("Longhorn considers share-manager node %q unschedulable for storage: %s (longhornNodePolicy %q)",
+				node.Name, reason, LonghornNodePolicyFilter))
+			return
+		}
+	}
+
+	if p.args.volumeNodeTagPolicy() == VolumeNodeTagPolicyFilter {
+		if missing := p.missingNodeTags(node.Name, data.volumeTags); len(missing) > 0 {
+			p.fallBack(pod, data, missingNodeTagsReason, fmt.Sprintf("Longhorn Node %q of the share-manager lacks the node tags %s the pod's volumes select (volumeNodeTagPolicy %q)",
+				node.Name, strings.Join(missing, ", "), VolumeNodeTagPolicyFilter))
+			return
+		}
+	}
+
+	if node.Spec.Unschedulable {
+		policy := p.args.cordonedNodePolicy()
+		if policy == CordonedNodePolicySoft {
+			p.fallBack(pod, data, cordonedReason, fmt.Sprintf("share-manager node %q is cordoned (cordonedNodePolicy %q)", node.Name, policy))
+			return
+		}
+		klog.V(2).InfoS("LonghornCoSchedule/PreFilter: share-manager node is cordoned, keeping pod pinned to it",
+			"pod", klog.KObj(pod),
+			"shareManagerNode", node.Name,
+			"cordonedNodePolicy", policy,
+		)
+		p.emitWarning(pod, cordonedReason,
+			fmt.Sprintf("Share-manager node %q is cordoned; the pod stays pinned to it until it is uncordoned or the share-manager moves (cordonedNodePolicy %q)",
+				node.Name, policy))
+		return
+	}
+
+	if taint, ok := corev1helpers.FindMatchingUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations, helper.DoNotScheduleTaintsFilterFunc()); ok {
+		msg := fmt.Sprintf("share-manager node %q has taint %s that the pod does not tolerate", node.Name, taint.ToString())
+		if p.args.TaintConflictPolicy == TaintConflictPolicySoft {
+			p.fallBack(pod, data, untoleratedTaintReason, fmt.Sprintf("%s (taintConflictPolicy %q)", msg, TaintConflictPolicySoft))
+			return
+		}
+		klog.V(2).InfoS("LonghornCoSchedule/PreFilter: pod does not tolerate a taint of its share-manager node",
+			"pod", klog.KObj(pod),
+			"shareManagerNode", node.Name,
+			"taint", taint.ToString(),
+		)
+		data.nodeConflict = msg
+		return
+	}
+
+	if selector := unmatchedNodeSelector(pod, node); selector != "" {
+		msg := fmt.Sprintf("share-manager is on node %q which does not match the pod's %s", node.Name, selector)
+		if p.args.NodeAffinityConflictPolicy == NodeAffinityConflictPolicySoft {
+			p.fallBack(pod, data, nodeAffinityReason, fmt.Sprintf("%s (nodeAffinityConflictPolicy %q)", msg, NodeAffinityConflictPolicySoft))
+			return
+		}
+		klog.V(2).InfoS("LonghornCoSchedule/PreFilter: pod's node selector does not match its share-manager node",
+			"pod", klog.KObj(pod),
+			"shareManagerNode", node.Name,
+			"selector", selector,
+		)
+		data.nodeConflict = msg
+		return
+	}
+
+	if p.args.InsufficientResourcesPolicy == InsufficientResourcesPolicySoft {
+		if insufficient := noderesources.Fits(pod, nodeInfo, noderesources.ResourceRequestsOptions{}); len(insufficient) > 0 {
+			for _, r := range insufficient {
+				klog.V(2).InfoS("LonghornCoSchedule/PreFilter: share-manager node cannot fit the pod",
+					"pod", klog.KObj(pod),
+					"shareManagerNode", node.Name,
+					"resource", r.ResourceName,
+					"requested", r.Requested,
+					"used", r.Used,
+					"allocatable", r.Capacity,
+				)
+			}
+			p.fallBack(pod, data, insufficientResourcesReason, fmt.Sprintf("share-manager node %q cannot fit the pod: %s (insufficientResourcesPolicy %q)",
+				node.Name, describeInsufficient(insufficient), InsufficientResourcesPolicySoft))
+		}
+	}
+}
+
+// unmatchedNodeSelector describes the part of the pod's nodeSelector and
+// required node affinity the node does not match, e.g. `node selector
+// "gpu=true"`, or returns an empty string if the node matches.
+func unmatchedNodeSelector(pod *corev1.Pod, node *corev1.Node) string {
+	if len(pod.Spec.NodeSelector) > 0 {
+		selector := labels.SelectorFromSet(pod.Spec.NodeSelector)
+		if !selector.Matches(labels.Set(node.Labels)) {
+			return fmt.Sprintf("node selector %q", selector.String())
+		}
+	}
+	if match, _ := nodeaffinity.GetRequiredNodeAffinity(pod).Match(node); !match {
+		return "required node affinity"
+	}
+	return ""
+}
+
+// ignoreShareManager is fallBack for a share-manager node that must not be
+// preferred either: the pod is treated as if no share-manager was found, so
+// every node passes Filter with a neutral score.
+func (p *Plugin) ignoreShareManager(pod *corev1.Pod, data *stateData, reason, msg string) {
+	p.fallBack(pod, data, reason, msg)
+	data.placements, data.nodeCounts, data.nodeScores, data.conflictNodes = nil, nil, nil, nil
+}
+
+// describeInsufficient formats the resources a node lacks, e.g.
+// "memory: requested 68719476736, used 1073741824, allocatable 10737418240".
+func describeInsufficient(insufficient []noderesources.InsufficientResource) string {
+	parts := make([]string, 0, len(insufficient))
+	for _, r := range insufficient {
+		parts = append(parts, fmt.Sprintf("%s: requested %d, used %d, allocatable %d", r.ResourceName, r.Requested, r.Used, r.Capacity))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// failureTaint returns the first NoExecute taint of the node whose key is one
+// of the configured failure taint keys, or nil.
+func (p *Plugin) failureTaint(node *corev1.Node) *corev1.Taint {
+	keys := p.args.failureTaintKeys()
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		for _, key := range keys {
+			if taint.Key == key {
+				return taint
+			}
+		}
+	}
+	return nil
+}
+
+// notReadyFor returns how long the node's Ready condition has not been True,
+// and whether that is at least the ShareManagerNodeNotReadyTimeout arg. A node
+// without a Ready condition is not considered NotReady.
+func (p *Plugin) notReadyFor(node *corev1.Node) (time.Duration, bool) {
+	timeout := p.args.ShareManagerNodeNotReadyTimeout.Duration
+	if timeout <= 0 || p.clock == nil {
+		return 0, false
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type != corev1.NodeReady {
+			continue
+		}
+		if c.Status == corev1.ConditionTrue {
+			return 0, false
+		}
+		since := p.clock.Since(c.LastTransitionTime.Time)
+		return since, since >= timeout
+	}
+	return 0, false
+}
+
+// fallBack relaxes the hard filter for this cycle: every node passes Filter,
+// and Score still prefers the share-manager node. It emits a warning event
+// with the given reason and message (see emitWarning), and counts the
+// fallback by reason. It does nothing if the filter was already relaxed.
+func (p *Plugin) fallBack(pod *corev1.Pod, data *stateData, reason, msg string) {
+	if data.fallback != "" {
+		return
+	}
+	shareManagerNodeFallbacks.WithLabelValues(reason).Inc()
+	klog.V(2).InfoS("LonghornCoSchedule/PreFilter: not pinning pod to share-manager node",
+		"pod", klog.KObj(pod),
+		"shareManagerNode", data.shareManagerNode,
+		"reason", msg,
+	)
+	data.fallback = msg
+	data.fallbackReason = reason
+	data.fallbackNode = data.shareManagerNode
+	data.shareManagerNode = ""
+	p.emitWarning(pod, reason, "Not pinning the pod to its share-manager node: "+msg)
+}
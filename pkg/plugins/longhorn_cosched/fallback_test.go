@@ -0,0 +1,425 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// fallbackPVCName and fallbackPVName are the RWX volume used by the fallback
+// tests. Its share-manager runs on fallbackNode.
+const (
+	fallbackPVCName = "my-rwx-pvc"
+	fallbackPVName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	fallbackNode    = "node-2"
+	fallbackOther   = "node-1"
+
+	// fallbackGPU is an extended resource only the other node has.
+	fallbackGPU corev1.ResourceName = "example.com/gpu"
+)
+
+// fallbackResult is what runFallback observed for one scheduling cycle.
+type fallbackResult struct {
+	// pinned is set when the other node was rejected by this plugin.
+	pinned bool
+	// statuses are the Filter statuses of the rejected nodes.
+	statuses map[string]*framework.Status
+	// score is the plugin's score of the share-manager node.
+	score int64
+	// events are the events emitted during the cycle.
+	events []string
+}
+
+// fallbackVM returns a hard-mode VM using the fallback tests' volume.
+func fallbackVM() *corev1.Pod {
+	return makeVM("vm", "default", true, fallbackPVCName)
+}
+
+// runFallback runs PreFilter, Filter and Score for pod, whose share-manager
+// runs on smNode. The other node has 16 CPUs and one fallbackGPU. setup, if
+// not nil, adjusts the plugin first.
+func runFallback(t *testing.T, pod *corev1.Pod, smNode *corev1.Node, args Args, setup func(*Plugin)) fallbackResult {
+	t.Helper()
+	other := makeNode(fallbackOther, "16")
+	other.Status.Allocatable[fallbackGPU] = resource.MustParse("1")
+	recorder := events.NewFakeRecorder(10)
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:    []*corev1.Node{other, smNode},
+		objects:  []runtime.Object{pod, makePVC(fallbackPVCName, "default", fallbackPVName), makeShareManagerPod(fallbackPVName, fallbackNode)},
+		args:     args,
+		recorder: recorder,
+	})
+	if setup != nil {
+		setup(plugin)
+	}
+
+	res := fallbackResult{statuses: map[string]*framework.Status{}}
+	state, m := runFilters(t, fwk, pod)
+	m.ForEachExplicitNode(func(node string, status *framework.Status) {
+		res.pinned = res.pinned || (node == fallbackOther && status.Plugin() == Name)
+		res.statuses[node] = status
+	})
+
+	score, status := plugin.Score(context.Background(), state, pod, fallbackNode)
+	if !status.IsSuccess() {
+		t.Fatalf("Score() status = %v", status)
+	}
+	res.score = score
+
+	for len(recorder.Events) > 0 {
+		res.events = append(res.events, <-recorder.Events)
+	}
+	return res
+}
+
+// hasEvent returns true if one of the events has the given reason.
+func hasEvent(events []string, reason string) bool {
+	for _, e := range events {
+		if strings.Contains(e, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCordonedShareManagerNode(t *testing.T) {
+	cordoned := makeNode(fallbackNode, "4")
+	cordoned.Spec.Unschedulable = true
+
+	tests := []struct {
+		name       string
+		node       *corev1.Node
+		policy     CordonedNodePolicy
+		wantPinned bool
+		wantEvent  bool
+	}{
+		{name: "schedulable, default policy — pinned", node: makeNode(fallbackNode, "4"), wantPinned: true},
+		{name: "schedulable, soft — pinned", node: makeNode(fallbackNode, "4"), policy: CordonedNodePolicySoft, wantPinned: true},
+		{name: "cordoned, default policy — pinned with warning", node: cordoned, wantPinned: true, wantEvent: true},
+		{name: "cordoned, pin — pinned with warning", node: cordoned, policy: CordonedNodePolicyPin, wantPinned: true, wantEvent: true},
+		{name: "cordoned, soft — all nodes pass", node: cordoned, policy: CordonedNodePolicySoft, wantEvent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runFallback(t, fallbackVM(), tt.node.DeepCopy(), Args{CordonedNodePolicy: tt.policy}, nil)
+			if res.pinned != tt.wantPinned {
+				t.Errorf("pinned = %v, want %v", res.pinned, tt.wantPinned)
+			}
+			if res.score != framework.MaxNodeScore {
+				t.Errorf("Score(%s) = %d, want %d", fallbackNode, res.score, framework.MaxNodeScore)
+			}
+			if got := hasEvent(res.events, cordonedReason); got != tt.wantEvent {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", cordonedReason, got, tt.wantEvent, res.events)
+			}
+		})
+	}
+}
+
+func TestNotReadyShareManagerNode(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	withReadyCondition := func(status corev1.ConditionStatus, age time.Duration) *corev1.Node {
+		node := makeNode(fallbackNode, "4")
+		node.Status.Conditions = []corev1.NodeCondition{{
+			Type:               corev1.NodeReady,
+			Status:             status,
+			LastTransitionTime: metav1.NewTime(now.Add(-age)),
+		}}
+		return node
+	}
+	timeout := metav1.Duration{Duration: 5 * time.Minute}
+
+	tests := []struct {
+		name       string
+		node       *corev1.Node
+		timeout    metav1.Duration
+		wantPinned bool
+	}{
+		{name: "Ready — pinned", node: withReadyCondition(corev1.ConditionTrue, time.Hour), timeout: timeout, wantPinned: true},
+		{name: "NotReady below the timeout — pinned", node: withReadyCondition(corev1.ConditionFalse, time.Minute), timeout: timeout, wantPinned: true},
+		{name: "NotReady past the timeout — all nodes pass", node: withReadyCondition(corev1.ConditionFalse, 10*time.Minute), timeout: timeout},
+		{name: "Unknown past the timeout — all nodes pass", node: withReadyCondition(corev1.ConditionUnknown, 10*time.Minute), timeout: timeout},
+		{name: "NotReady, fallback disabled — pinned", node: withReadyCondition(corev1.ConditionFalse, 10*time.Minute), wantPinned: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := shareManagerNodeFallbacks.WithLabelValues(nodeNotReadyReason)
+			before, err := testutil.GetCounterMetricValue(counter)
+			if err != nil {
+				t.Fatalf("reading metric: %v", err)
+			}
+
+			res := runFallback(t, fallbackVM(), tt.node, Args{ShareManagerNodeNotReadyTimeout: tt.timeout}, func(p *Plugin) {
+				p.clock = clocktesting.NewFakePassiveClock(now)
+			})
+			if res.pinned != tt.wantPinned {
+				t.Errorf("pinned = %v, want %v", res.pinned, tt.wantPinned)
+			}
+			if res.score != framework.MaxNodeScore {
+				t.Errorf("Score(%s) = %d, want %d", fallbackNode, res.score, framework.MaxNodeScore)
+			}
+			if got := hasEvent(res.events, nodeNotReadyReason); got == tt.wantPinned {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", nodeNotReadyReason, got, !tt.wantPinned, res.events)
+			}
+
+			after, err := testutil.GetCounterMetricValue(counter)
+			if err != nil {
+				t.Fatalf("reading metric: %v", err)
+			}
+			wantDelta := 1.0
+			if tt.wantPinned {
+				wantDelta = 0
+			}
+			if after-before != wantDelta {
+				t.Errorf("%s fallbacks counted = %v, want %v", nodeNotReadyReason, after-before, wantDelta)
+			}
+		})
+	}
+}
+
+func TestTaintedShareManagerNode(t *testing.T) {
+	withTaint := func(key string, effect corev1.TaintEffect) *corev1.Node {
+		node := makeNode(fallbackNode, "4")
+		node.Spec.Taints = []corev1.Taint{{Key: key, Effect: effect}}
+		return node
+	}
+
+	tests := []struct {
+		name       string
+		node       *corev1.Node
+		keys       []string
+		wantPinned bool
+	}{
+		{name: "no taints — pinned", node: makeNode(fallbackNode, "4"), wantPinned: true},
+		{name: "unreachable NoExecute — ignored", node: withTaint(corev1.TaintNodeUnreachable, corev1.TaintEffectNoExecute)},
+		{name: "not-ready NoExecute — ignored", node: withTaint(corev1.TaintNodeNotReady, corev1.TaintEffectNoExecute)},
+		{name: "not-ready NoSchedule — pinned", node: withTaint(corev1.TaintNodeNotReady, corev1.TaintEffectNoSchedule), wantPinned: true},
+		{name: "unrelated NoExecute — pinned", node: withTaint("example.com/maintenance", corev1.TaintEffectNoExecute), wantPinned: true},
+		{
+			name: "configured NoExecute — ignored",
+			node: withTaint("example.com/maintenance", corev1.TaintEffectNoExecute),
+			keys: []string{"example.com/maintenance"},
+		},
+		{
+			name:       "empty key list — pinned",
+			node:       withTaint(corev1.TaintNodeUnreachable, corev1.TaintEffectNoExecute),
+			keys:       []string{},
+			wantPinned: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runFallback(t, fallbackVM(), tt.node, Args{FailureTaintKeys: tt.keys}, nil)
+			if res.pinned != tt.wantPinned {
+				t.Errorf("pinned = %v, want %v", res.pinned, tt.wantPinned)
+			}
+			wantScore := int64(0)
+			if tt.wantPinned {
+				wantScore = framework.MaxNodeScore
+			}
+			if res.score != wantScore {
+				t.Errorf("Score(%s) = %d, want %d", fallbackNode, res.score, wantScore)
+			}
+			if got := hasEvent(res.events, taintedReason); got == tt.wantPinned {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", taintedReason, got, !tt.wantPinned, res.events)
+			}
+		})
+	}
+}
+
+func TestInsufficientResourcesOnShareManagerNode(t *testing.T) {
+	withGPU := func(pod *corev1.Pod) *corev1.Pod {
+		pod.Spec.Containers[0].Resources.Requests[fallbackGPU] = resource.MustParse("1")
+		return pod
+	}
+
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		policy     InsufficientResourcesPolicy
+		wantPinned bool
+	}{
+		{name: "fits, soft — pinned", pod: withCPU(fallbackVM(), "2"), policy: InsufficientResourcesPolicySoft, wantPinned: true},
+		{name: "CPU does not fit, soft — all nodes pass", pod: withCPU(fallbackVM(), "8"), policy: InsufficientResourcesPolicySoft},
+		{name: "CPU does not fit, default policy — pinned", pod: withCPU(fallbackVM(), "8"), wantPinned: true},
+		{name: "CPU does not fit, pin — pinned", pod: withCPU(fallbackVM(), "8"), policy: InsufficientResourcesPolicyPin, wantPinned: true},
+		{name: "extended resource missing, soft — all nodes pass", pod: withGPU(withCPU(fallbackVM(), "2")), policy: InsufficientResourcesPolicySoft},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runFallback(t, tt.pod, makeNode(fallbackNode, "4"), Args{InsufficientResourcesPolicy: tt.policy}, nil)
+			if res.pinned != tt.wantPinned {
+				t.Errorf("pinned = %v, want %v", res.pinned, tt.wantPinned)
+			}
+			if got := hasEvent(res.events, insufficientResourcesReason); got == tt.wantPinned {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", insufficientResourcesReason, got, !tt.wantPinned, res.events)
+			}
+		})
+	}
+}
+
+func TestUntoleratedShareManagerNodeTaint(t *testing.T) {
+	taint := corev1.Taint{Key: "dedicated", Value: "storage", Effect: corev1.TaintEffectNoSchedule}
+	tainted := makeNode(fallbackNode, "4")
+	tainted.Spec.Taints = []corev1.Taint{taint}
+	tolerating := fallbackVM()
+	tolerating.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "storage", Effect: corev1.TaintEffectNoSchedule}}
+
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		node       *corev1.Node
+		policy     TaintConflictPolicy
+		wantPinned bool
+		wantNamed  bool
+		wantEvent  bool
+	}{
+		{name: "no taint — pinned", pod: fallbackVM(), node: makeNode(fallbackNode, "4"), wantPinned: true},
+		{name: "tolerated taint — pinned", pod: tolerating, node: tainted, wantPinned: true},
+		{name: "untolerated taint, default policy — rejected naming the taint", pod: fallbackVM(), node: tainted, wantPinned: true, wantNamed: true},
+		{name: "untolerated taint, soft — all nodes pass", pod: fallbackVM(), node: tainted, policy: TaintConflictPolicySoft, wantEvent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runFallback(t, tt.pod.DeepCopy(), tt.node.DeepCopy(), Args{TaintConflictPolicy: tt.policy}, nil)
+			if res.pinned != tt.wantPinned {
+				t.Errorf("pinned = %v, want %v", res.pinned, tt.wantPinned)
+			}
+			if got := hasEvent(res.events, untoleratedTaintReason); got != tt.wantEvent {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", untoleratedTaintReason, got, tt.wantEvent, res.events)
+			}
+
+			smStatus := res.statuses[fallbackNode]
+			if !tt.wantNamed {
+				if smStatus != nil {
+					t.Errorf("Filter(%s) = %v, want success", fallbackNode, smStatus)
+				}
+				return
+			}
+			if smStatus.Code() != framework.UnschedulableAndUnresolvable {
+				t.Errorf("Filter(%s) code = %v, want UnschedulableAndUnresolvable", fallbackNode, smStatus.Code())
+			}
+			for node, status := range res.statuses {
+				if !strings.Contains(status.Message(), taint.ToString()) {
+					t.Errorf("Filter(%s) message %q does not name the taint %s", node, status.Message(), taint.ToString())
+				}
+			}
+		})
+	}
+}
+
+func TestNodeSelectorConflictWithShareManagerNode(t *testing.T) {
+	smNode := makeNode(fallbackNode, "4")
+	smNode.Labels = map[string]string{"zone": "a"}
+
+	withSelector := func(selector map[string]string) *corev1.Pod {
+		pod := fallbackVM()
+		pod.Spec.NodeSelector = selector
+		return pod
+	}
+	withAffinity := func(op corev1.NodeSelectorOperator, values ...string) *corev1.Pod {
+		pod := fallbackVM()
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: op, Values: values}},
+				}},
+			},
+		}}
+		return pod
+	}
+
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		policy     NodeAffinityConflictPolicy
+		wantPinned bool
+		wantNamed  string
+		wantEvent  bool
+	}{
+		{name: "selector matches — pinned", pod: withSelector(map[string]string{"zone": "a"}), wantPinned: true},
+		{name: "selector mismatch — rejected naming the selector", pod: withSelector(map[string]string{"gpu": "true"}), wantPinned: true, wantNamed: `node selector "gpu=true"`},
+		{name: "affinity In matches — pinned", pod: withAffinity(corev1.NodeSelectorOpIn, "a", "b"), wantPinned: true},
+		{name: "affinity NotIn mismatch — rejected", pod: withAffinity(corev1.NodeSelectorOpNotIn, "a"), wantPinned: true, wantNamed: "required node affinity"},
+		{name: "selector mismatch, soft — all nodes pass", pod: withSelector(map[string]string{"gpu": "true"}), policy: NodeAffinityConflictPolicySoft, wantEvent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The other node matches nothing either; only this plugin
+			// filters in the test framework.
+			res := runFallback(t, tt.pod, smNode.DeepCopy(), Args{NodeAffinityConflictPolicy: tt.policy}, nil)
+			if res.pinned != tt.wantPinned {
+				t.Errorf("pinned = %v, want %v", res.pinned, tt.wantPinned)
+			}
+			if got := hasEvent(res.events, nodeAffinityReason); got != tt.wantEvent {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", nodeAffinityReason, got, tt.wantEvent, res.events)
+			}
+
+			smStatus := res.statuses[fallbackNode]
+			if tt.wantNamed == "" {
+				if smStatus != nil {
+					t.Errorf("Filter(%s) = %v, want success", fallbackNode, smStatus)
+				}
+				return
+			}
+			want := fmt.Sprintf("share-manager is on node %q which does not match the pod's %s", fallbackNode, tt.wantNamed)
+			for node, status := range res.statuses {
+				if !strings.Contains(status.Message(), want) {
+					t.Errorf("Filter(%s) message %q, want it to contain %q", node, status.Message(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestStaleShareManagerOwner(t *testing.T) {
+	const vmNamespace = "default"
+	pod := fallbackVM()
+	recorder := events.NewFakeRecorder(10)
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:      []*corev1.Node{makeNode(fallbackOther, "4"), makeNode(fallbackNode, "4")},
+		objects:    []runtime.Object{pod, makePVC(fallbackPVCName, vmNamespace, fallbackPVName)},
+		dynObjects: []runtime.Object{makeShareManagerCR(fallbackPVName, "node-deleted", "running")},
+		recorder:   recorder,
+	})
+
+	state, m := runFilters(t, fwk, pod)
+	if m.Len() != 0 {
+		m.ForEachExplicitNode(func(node string, status *framework.Status) {
+			t.Errorf("Filter(%s) = %v, want every node to pass", node, status)
+		})
+	}
+	for _, node := range []string{fallbackOther, fallbackNode, "node-deleted"} {
+		score, status := plugin.Score(context.Background(), state, pod, node)
+		if !status.IsSuccess() {
+			t.Fatalf("Score(%s) status = %v", node, status)
+		}
+		if score != 0 {
+			t.Errorf("Score(%s) = %d, want 0", node, score)
+		}
+	}
+
+	var got []string
+	for len(recorder.Events) > 0 {
+		got = append(got, <-recorder.Events)
+	}
+	if !hasEvent(got, staleOwnerReason) {
+		t.Errorf("%s event not emitted (events: %v)", staleOwnerReason, got)
+	}
+}
@@ -3,6 +3,7 @@ package longhorn_cosched
 import (
 	"context"
 	"fmt"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
@@ -14,19 +15,38 @@ import (
 // If the pod has the co-scheduling annotation and a Longhorn share-manager pod
 // is already running for one of its RWX PVCs, only the node where the
 // share-manager is running will pass the filter. All other nodes are rejected
-// with an Unschedulable status.
+// with an Unschedulable status. Hotplug attachment pods are restricted to the
+// node of their virt-launcher pod instead. The policies that relax or extend
+// the filter are documented on Args.
 //
-// If the pod does not have the annotation, is a migration target, or no
-// share-manager pod is found, all nodes pass (the plugin is a no-op).
-func (p *Plugin) Filter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+// If the pod does not have the annotation, is in soft or observe mode, is a
+// skipped migration target, or no share-manager pod is found, all nodes pass
+// (the plugin is a no-op), apart from the checks Args applies in every mode,
+// such as the replica node of a strict-local volume.
+func (p *Plugin) Filter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) (status *framework.Status) {
+	defer func(start time.Time) { observeExtensionPoint(extensionPointFilter, start, status) }(time.Now())
 	podKey := klog.KObj(pod)
 
-	if !isOptedIn(pod) {
-		klog.V(5).InfoS("LonghornCoSchedule/Filter: pod not opted in, skipping", "pod", podKey)
+	strictLocal := strictLocalData(state)
+	if node := nodeInfo.Node(); node != nil {
+		if status := filterStrictLocal(strictLocal, pod, node.Name); status != nil {
+			return status
+		}
+	}
+	if strictLocal != nil && strictLocal.skipped {
 		return nil
 	}
 
-	if isMigrationTarget(pod) {
+	if hotplugOwner(pod) != "" {
+		return p.filterHotplug(ctx, state, pod, nodeInfo)
+	}
+
+	// result is the outcome counted if the node passes.
+	result := filterResultSkipped
+	defer func() { recordFilterResult(status, result, cycleDecision(state)) }()
+
+	mode := p.cycleMode(ctx, state, pod)
+	if mode == "" && p.isMigrationTargetPod(ctx, pod) {
 		klog.V(4).InfoS("LonghornCoSchedule/Filter: migration target pod, skipping (KubeVirt migration controller handles placement)",
 			"pod", podKey,
 			"migrationJobUID", pod.Labels[MigrationTargetLabel],
@@ -34,16 +54,109 @@ func (p *Plugin) Filter(ctx context.Context, state *framework.CycleState, pod *c
 		return nil
 	}
 
+	if mode == "" {
+		klog.V(5).InfoS("LonghornCoSchedule/Filter: pod not opted in, skipping", "pod", podKey)
+		result = filterResultNotOptedIn
+		return nil
+	}
+
+	if mode == ModeObserve {
+		klog.V(5).InfoS("LonghornCoSchedule/Filter: observe mode, skipping", "pod", podKey)
+		result = filterResultObserved
+		return nil
+	}
+
+	if status := p.filterCSIPlugin(pod, nodeInfo); status != nil {
+		return status
+	}
+	if status := p.filterInstanceManager(pod, nodeInfo); status != nil {
+		return status
+	}
+	if status := p.filterLonghornNode(pod, nodeInfo); status != nil {
+		return status
+	}
+
+	if mode == ModeSoft {
+		klog.V(5).InfoS("LonghornCoSchedule/Filter: soft mode, skipping", "pod", podKey)
+		return nil
+	}
+
 	node := nodeInfo.Node()
 	if node == nil {
 		return framework.NewStatus(framework.Error, "node not found")
 	}
 
-	shareManagerNode, err := findShareManagerNode(ctx, p.clientset, p.dynClient, pod)
+	data, err := p.cycleData(ctx, state, pod)
 	if err != nil {
 		klog.ErrorS(err, "LonghornCoSchedule/Filter: error looking up share-manager", "pod", podKey)
 		return framework.NewStatus(framework.Error, fmt.Sprintf("error looking up share-manager pod: %v", err))
 	}
+	shareManagerNode := data.shareManagerNode
+
+	if status := p.filterVolumeNodeTags(pod, data, node.Name); status != nil {
+		return status
+	}
+
+	// The pod is a live-migration target co-scheduled in hard mode; landing
+	// on the source node would make the migration a no-op.
+	if data.migrationSource != "" && node.Name == data.migrationSource {
+		klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (live-migration source node)",
+			"pod", podKey,
+			"node", node.Name,
+		)
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("node %q is the live-migration source node", node.Name))
+	}
+
+	// A PVC is still being created, populated or bound and the DataVolume
+	// or unbound PVC policy says wait. The pod is retried when the PVC
+	// changes.
+	if data.waitingFor != "" {
+		klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (waiting for PVC)",
+			"pod", podKey,
+			"node", node.Name,
+			"reason", data.waitingFor,
+		)
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("waiting for storage (%s): %s", data.waitingPolicy, data.waitingFor))
+	}
+
+	// Share-managers on different nodes and the conflict policy says fail.
+	if data.unresolvable {
+		klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (share-managers on different nodes)",
+			"pod", podKey,
+			"node", node.Name,
+			"conflictPolicy", data.policy,
+			"conflictNodes", data.conflictNodes,
+		)
+		return framework.NewStatus(
+			framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("Longhorn share-managers of the pod's RWX PVCs are on different nodes (%s)", data.conflictMessage()),
+		)
+	}
+
+	// The share-manager node cannot take the pod right now and a fallback
+	// policy relaxed the hard filter for this cycle.
+	if data.fallback != "" {
+		klog.V(4).InfoS("LonghornCoSchedule/Filter: share-manager node unusable, all nodes pass",
+			"pod", podKey,
+			"node", node.Name,
+			"shareManagerNode", data.fallbackNode,
+			"reason", data.fallback,
+		)
+		return nil
+	}
+
+	// Share-managers on different nodes and the conflict policy only scores.
+	if shareManagerNode == "" && len(data.conflictNodes) > 0 {
+		klog.V(4).InfoS("LonghornCoSchedule/Filter: share-managers on different nodes, all nodes pass",
+			"pod", podKey,
+			"node", node.Name,
+			"conflictPolicy", data.policy,
+			"conflictNodes", data.conflictNodes,
+		)
+		return nil
+	}
 
 	// No share-manager found yet — allow all nodes (VM schedules freely).
 	if shareManagerNode == "" {
@@ -56,21 +169,54 @@ func (p *Plugin) Filter(ctx context.Context, state *framework.CycleState, pod *c
 
 	// Share-manager is running on a specific node — only allow that node.
 	if node.Name != shareManagerNode {
+		pinnedBy := data.decision.pinnedBy()
 		klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (share-manager on different node)",
 			"pod", podKey,
 			"node", node.Name,
 			"shareManagerNode", shareManagerNode,
+			"pinnedBy", pinnedBy,
+			"conflictPolicy", data.policy,
+			"conflictNodes", data.conflictNodes,
 		)
-		return framework.NewStatus(
-			framework.Unschedulable,
-			fmt.Sprintf("node %q rejected: Longhorn share-manager pod is running on node %q", node.Name, shareManagerNode),
+		msg := fmt.Sprintf("node %q rejected: VM must run on %q where %s", node.Name, shareManagerNode, pinnedBy)
+		if len(data.conflictNodes) > 0 {
+			msg += fmt.Sprintf(" (share-managers on different nodes, %s)", data.conflictMessage())
+		}
+		if data.nodeConflict != "" {
+			msg += ", but " + data.nodeConflict
+		}
+		return framework.NewStatus(framework.Unschedulable, msg)
+	}
+
+	// The share-manager node itself cannot take the pod, and preemption
+	// cannot change that.
+	if data.nodeConflict != "" {
+		klog.V(4).InfoS("LonghornCoSchedule/Filter: share-manager node rejected",
+			"pod", podKey,
+			"node", node.Name,
+			"reason", data.nodeConflict,
 		)
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, data.nodeConflict)
 	}
 
 	klog.V(4).InfoS("LonghornCoSchedule/Filter: node accepted (share-manager co-located)",
 		"pod", podKey,
 		"node", node.Name,
 		"shareManagerNode", shareManagerNode,
+		"pinnedBy", data.decision.pinnedBy(),
 	)
+	result = filterResultAccepted
 	return nil
 }
+
+// recordFilterResult counts a Filter decision of the cycle that decided d:
+// an error or rejection by the status, otherwise result.
+func recordFilterResult(status *framework.Status, result string, d Decision) {
+	switch {
+	case status.Code() == framework.Error:
+		result = filterResultError
+	case !status.IsSuccess():
+		result = filterResultRejected
+	}
+	filterResults.WithLabelValues(result, d.Outcome).Inc()
+}
@@ -0,0 +1,160 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"slices"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// FollowVMAnnotationKey is the PVC annotation that, together with the
+	// ShareManagerFollowsVM plugin arg, lets PostBind move the PVC's
+	// share-manager after the only pod mounting the PVC. Its value is parsed
+	// like strconv.ParseBool.
+	FollowVMAnnotationKey = "scheduler.kubevirt-scheduler.io/share-manager-follows-vm"
+
+	// followedVMReason and followVMFailedReason are the reasons of the events
+	// emitted when a share-manager is asked to follow a pod.
+	followedVMReason     = "ShareManagerFollowedVM"
+	followVMFailedReason = "ShareManagerFollowVMFailed"
+)
+
+// Values of the result label of shareManagerFollows.
+const (
+	followResultRelocated = "relocated"
+	followResultShared    = "shared"
+	followResultCooldown  = "cooldown"
+	followResultError     = "error"
+)
+
+// followVM asks Longhorn to move the share-managers the pod, bound to
+// nodeName, was placed away from, e.g. because soft mode lost on the other
+// plugins' scores. Like relocateShareManager, it deletes the share-manager
+// pod; Longhorn recreates it and picks the node, which this plugin does
+// not control.
+//
+// Only PVCs carrying FollowVMAnnotationKey are considered, and only their
+// share-managers on a node other than nodeName. A PVC mounted by any
+// other pod, other than pods of the same VMI such as a migration source and
+// the pod's hotplug attachment pods, is left alone, as is a share-manager
+// within the relocation cooldown.
+func (p *Plugin) followVM(ctx context.Context, pod *corev1.Pod, placements []shareManagerPlacement, nodeName string) {
+	if p.relocations == nil || p.handle == nil {
+		return
+	}
+	for _, pl := range placements {
+		if pl.pvc == "" || pl.pv == "" || pl.node == "" || pl.node == nodeName ||
+			pl.migratable || pl.consumer || pl.attachment || pl.stopped {
+			continue
+		}
+		pvc, err := p.getPVC(ctx, pod.Namespace, pl.pvc)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				klog.ErrorS(err, "LonghornCoSchedule/PostBind: error reading PVC", "pod", klog.KObj(pod), "pvc", pl.pvc)
+			}
+			continue
+		}
+		if follows, _ := strconv.ParseBool(pvc.Annotations[FollowVMAnnotationKey]); !follows {
+			continue
+		}
+		p.followVMOnce(ctx, pod, pl, nodeName)
+	}
+}
+
+// followVMOnce deletes the share-manager pod of the placement if the pod is
+// the only consumer of its PVC, recording the outcome in shareManagerFollows.
+func (p *Plugin) followVMOnce(ctx context.Context, pod *corev1.Pod, pl shareManagerPlacement, nodeName string) {
+	podKey := klog.KObj(pod)
+	others, err := p.otherConsumers(ctx, pod, pl.pvc)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/PostBind: error listing consumers of PVC", "pod", podKey, "pvc", pl.pvc)
+		shareManagerFollows.WithLabelValues(followResultError).Inc()
+		return
+	}
+	if len(others) > 0 {
+		klog.V(4).InfoS("LonghornCoSchedule/PostBind: PVC has other consumers, share-manager stays",
+			"pod", podKey,
+			"pvc", pl.pvc,
+			"consumers", others,
+		)
+		shareManagerFollows.WithLabelValues(followResultShared).Inc()
+		return
+	}
+	if !p.relocations.tryAcquire(pl.pv) {
+		klog.V(4).InfoS("LonghornCoSchedule/PostBind: share-manager relocated recently, not following pod",
+			"pod", podKey,
+			"pv", pl.pv,
+		)
+		shareManagerFollows.WithLabelValues(followResultCooldown).Inc()
+		return
+	}
+
+	opts := p.lookupOptions(pod)
+	namespace := opts.longhornNamespace()
+	smName := ShareManagerPrefix + pl.pv
+	if smPod, err := newestShareManagerPod(ctx, p.clientset, pl.pv, opts); err == nil && smPod != nil {
+		smName = smPod.Name
+	}
+	err = p.clientset.CoreV1().Pods(namespace).Delete(ctx, smName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		p.relocations.release(pl.pv)
+		klog.ErrorS(err, "LonghornCoSchedule/PostBind: error deleting share-manager pod", "pod", podKey, "shareManager", klog.KRef(namespace, smName))
+		shareManagerFollows.WithLabelValues(followResultError).Inc()
+		p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, followVMFailedReason, "Relocate",
+			"Could not delete share-manager pod %s/%s on node %q to move it to node %q: %v", namespace, smName, pl.node, nodeName, err)
+		return
+	}
+
+	klog.V(2).InfoS("LonghornCoSchedule/PostBind: share-manager following pod",
+		"pod", podKey,
+		"pv", pl.pv,
+		"shareManagerNode", pl.node,
+		"node", nodeName,
+	)
+	shareManagerFollows.WithLabelValues(followResultRelocated).Inc()
+	p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeNormal, followedVMReason, "Relocate",
+		"Deleted share-manager pod %s/%s on node %q so Longhorn reschedules it; the pod, the only consumer of PVC %s, is on node %q",
+		namespace, smName, pl.node, pl.pvc, nodeName)
+}
+
+// otherConsumers returns the names of the pods in the pod's namespace, other
+// than pods of the same VMI and the pod's hotplug attachment pods, that mount
+// the PVC and have not terminated.
+func (p *Plugin) otherConsumers(ctx context.Context, pod *corev1.Pod, pvcName string) ([]string, error) {
+	var pods []*corev1.Pod
+	if p.podLister != nil {
+		var err error
+		if pods, err = p.podLister.Pods(pod.Namespace).List(labels.Everything()); err != nil {
+			return nil, err
+		}
+	} else {
+		list, err := p.clientset.CoreV1().Pods(pod.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			pods = append(pods, &list.Items[i])
+		}
+	}
+
+	var names []string
+	for _, other := range pods {
+		if other.UID == pod.UID || sameVMI(pod, other) || hotplugOwner(other) == pod.Name {
+			continue
+		}
+		if other.Status.Phase == corev1.PodSucceeded || other.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if slices.Contains(collectPVCNames(other), pvcName) {
+			names = append(names, other.Name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
@@ -0,0 +1,137 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// TestPostBindFollowVM binds a soft-mode pod away from its share-manager and
+// checks that the share-manager pod is deleted only when the PVC asks for it
+// and the pod is its only consumer.
+func TestPostBindFollowVM(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	registerMetrics()
+
+	makeSoftVM := func(name string) *corev1.Pod {
+		pod := makeVM(name, vmNamespace, true, pvcName)
+		pod.UID = types.UID("uid-" + pod.Name)
+		pod.Annotations[AnnotationKey] = string(ModeSoft)
+		return pod
+	}
+	otherVM := makeSoftVM("other-vm")
+	otherVM.Spec.NodeName = "node-1"
+	otherVM.Status.Phase = corev1.PodRunning
+	finishedVM := otherVM.DeepCopy()
+	finishedVM.Status.Phase = corev1.PodSucceeded
+	observed := makeSoftVM("vm")
+	observed.Annotations[AnnotationKey] = string(ModeObserve)
+
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		others     []runtime.Object
+		notFollow  bool
+		disabled   bool
+		wantResult string
+	}{
+		{name: "only consumer", pod: makeSoftVM("vm"), wantResult: followResultRelocated},
+		{name: "consumer that has terminated", pod: makeSoftVM("vm"), others: []runtime.Object{finishedVM}, wantResult: followResultRelocated},
+		{name: "another VM mounts the PVC", pod: makeSoftVM("vm"), others: []runtime.Object{otherVM}, wantResult: followResultShared},
+		{name: "PVC not annotated", pod: makeSoftVM("vm"), notFollow: true},
+		{name: "arg disabled", pod: makeSoftVM("vm"), disabled: true},
+		{name: "observe mode", pod: observed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := makePVC(pvcName, vmNamespace, pvName)
+			if !tt.notFollow {
+				pvc.Annotations = map[string]string{FollowVMAnnotationKey: "true"}
+			}
+			recorder := events.NewFakeRecorder(10)
+			fwk, plugin, clientset := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects:  append([]runtime.Object{tt.pod, pvc, makeShareManagerPod(pvName, "node-1")}, tt.others...),
+				args:     Args{ShareManagerFollowsVM: !tt.disabled},
+				recorder: recorder,
+			})
+			count := func(result string) float64 {
+				v, _ := testutil.GetCounterMetricValue(shareManagerFollows.WithLabelValues(result))
+				return v
+			}
+			var before float64
+			if tt.wantResult != "" {
+				before = count(tt.wantResult)
+			}
+
+			state, _ := runFilters(t, fwk, tt.pod)
+			plugin.PostBind(context.Background(), state, tt.pod, "node-2")
+
+			deleted := !shareManagerPodExists(t, clientset, ShareManagerPrefix+pvName)
+			if want := tt.wantResult == followResultRelocated; deleted != want {
+				t.Errorf("share-manager pod deleted = %v, want %v", deleted, want)
+			}
+			if tt.wantResult != "" {
+				if got := count(tt.wantResult) - before; got != 1 {
+					t.Errorf("share_manager_follows_total{result=%q} went up by %v, want 1", tt.wantResult, got)
+				}
+			}
+			if deleted {
+				select {
+				case event := <-recorder.Events:
+					if !strings.HasPrefix(event, "Normal "+followedVMReason) {
+						t.Errorf("event = %q, want a %s event", event, followedVMReason)
+					}
+				default:
+					t.Errorf("no %s event emitted", followedVMReason)
+				}
+			}
+		})
+	}
+}
+
+// TestPostBindFollowVMCooldown checks that a share-manager that just followed
+// a pod is not deleted again within the relocation cooldown.
+func TestPostBindFollowVMCooldown(t *testing.T) {
+	pod := makeVM("vm", "default", true, relocPVCName)
+	pod.Annotations[AnnotationKey] = string(ModeSoft)
+	pvc := makePVC(relocPVCName, "default", relocPVName)
+	pvc.Annotations = map[string]string{FollowVMAnnotationKey: "true"}
+	fwk, plugin, clientset := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{pod, pvc, makeShareManagerPod(relocPVName, "node-1")},
+		args:    Args{ShareManagerFollowsVM: true},
+	})
+	registerMetrics()
+	cooldown, _ := testutil.GetCounterMetricValue(shareManagerFollows.WithLabelValues(followResultCooldown))
+
+	state, _ := runFilters(t, fwk, pod)
+	plugin.PostBind(context.Background(), state, pod, "node-2")
+	if shareManagerPodExists(t, clientset, ShareManagerPrefix+relocPVName) {
+		t.Fatal("first bind should delete the share-manager pod")
+	}
+
+	// Longhorn recreates the share-manager on node-1 again.
+	if _, err := clientset.CoreV1().Pods(LonghornNamespace).Create(context.Background(), makeShareManagerPod(relocPVName, "node-1"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("recreating share-manager pod: %v", err)
+	}
+	state, _ = runFilters(t, fwk, pod)
+	plugin.PostBind(context.Background(), state, pod, "node-2")
+	if !shareManagerPodExists(t, clientset, ShareManagerPrefix+relocPVName) {
+		t.Error("bind within the cooldown should not delete the share-manager pod")
+	}
+	if got, _ := testutil.GetCounterMetricValue(shareManagerFollows.WithLabelValues(followResultCooldown)); got-cooldown != 1 {
+		t.Errorf("share_manager_follows_total{result=cooldown} went up by %v, want 1", got-cooldown)
+	}
+}
@@ -0,0 +1,116 @@
+package longhorn_cosched
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// DefaultForbiddenThreshold is the number of consecutive Forbidden
+	// errors after which a lookup path is skipped, when ForbiddenThreshold is
+	// unset.
+	DefaultForbiddenThreshold = 3
+
+	// DefaultForbiddenCooldown is how long a lookup path is skipped once it
+	// reached the Forbidden threshold, when ForbiddenCooldown is unset.
+	DefaultForbiddenCooldown = 10 * time.Minute
+)
+
+// forbiddenPathPVC is the lookup path of the PVC reads in a pod's namespace.
+// The other paths are named after their LookupSource.
+const forbiddenPathPVC = "pvc"
+
+// errPathForbidden is the cause of a strict-mode lookup failure while a
+// lookup path is skipped after repeated Forbidden errors.
+var errPathForbidden = errors.New("lookups skipped after repeated Forbidden errors")
+
+// forbiddenGuard keeps track of lookup paths the scheduler is not allowed to
+// read. A path is a kind of read (the PVCs, or a lookup source) in one
+// namespace. After threshold consecutive Forbidden errors on a path, it is
+// skipped for the cooldown, so a scheduler with partial RBAC stops sending
+// requests that are bound to fail. Any other outcome resets the count.
+//
+// A nil *forbiddenGuard never skips a path.
+type forbiddenGuard struct {
+	clock     clock.PassiveClock
+	threshold int
+	cooldown  time.Duration
+
+	mu            sync.Mutex
+	failures      map[string]int
+	disabledUntil map[string]time.Time
+}
+
+func newForbiddenGuard(c clock.PassiveClock, threshold int, cooldown time.Duration) *forbiddenGuard {
+	if threshold <= 0 {
+		threshold = DefaultForbiddenThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultForbiddenCooldown
+	}
+	return &forbiddenGuard{
+		clock:         c,
+		threshold:     threshold,
+		cooldown:      cooldown,
+		failures:      map[string]int{},
+		disabledUntil: map[string]time.Time{},
+	}
+}
+
+// allow returns false while the path is skipped in namespace.
+func (g *forbiddenGuard) allow(path, namespace string) bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.clock.Now().Before(g.disabledUntil[path+"/"+namespace])
+}
+
+// observe records the outcome of a read on the path in namespace and returns
+// true if err is Forbidden.
+func (g *forbiddenGuard) observe(path, namespace string, err error) bool {
+	forbidden := apierrors.IsForbidden(err)
+	if forbidden {
+		forbiddenLookups.WithLabelValues(path).Inc()
+	}
+	if g == nil {
+		return forbidden
+	}
+
+	key := path + "/" + namespace
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !forbidden {
+		delete(g.failures, key)
+		return false
+	}
+
+	g.failures[key]++
+	if g.failures[key] < g.threshold {
+		klog.V(4).InfoS("LonghornCoSchedule: lookup forbidden", "path", path, "namespace", namespace, "err", err)
+		return true
+	}
+	delete(g.failures, key)
+	g.disabledUntil[key] = g.clock.Now().Add(g.cooldown)
+	forbiddenPathsDisabled.WithLabelValues(path).Inc()
+	klog.ErrorS(err, "LonghornCoSchedule: lookups keep being forbidden, skipping them for a while",
+		"path", path,
+		"namespace", namespace,
+		"forbiddenThreshold", g.threshold,
+		"cooldown", g.cooldown,
+	)
+	return true
+}
+
+// readableNamespace returns true if the plugin may read PVCs in namespace:
+// always, unless readableNamespaces is set and does not list it.
+func (o lookupOptions) readableNamespace(namespace string) bool {
+	return len(o.readableNamespaces) == 0 || slices.Contains(o.readableNamespaces, namespace)
+}
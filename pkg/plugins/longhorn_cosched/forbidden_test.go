@@ -0,0 +1,174 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// countActions returns the number of actions with the verb on the resource.
+func countActions(actions []k8stesting.Action, verb, resource string) int {
+	n := 0
+	for _, action := range actions {
+		if action.GetVerb() == verb && action.GetResource().Resource == resource {
+			n++
+		}
+	}
+	return n
+}
+
+// TestForbiddenPathDisabled checks that a lookup path answering 403 is
+// skipped after ForbiddenThreshold consecutive Forbidden errors, so the
+// lookup degrades to the remaining sources, and is asked again after the
+// cooldown.
+func TestForbiddenPathDisabled(t *testing.T) {
+	forbidden := func(resource string) error {
+		return apierrors.NewForbidden(schema.GroupResource{Resource: resource}, crdTestPV, errors.New("RBAC: access denied"))
+	}
+	registerMetrics()
+
+	tests := []struct {
+		name     string
+		path     string
+		verb     string
+		resource string
+		dynamic  bool
+		// wantNode is the node found while the path is forbidden or skipped.
+		wantNode string
+		// wantErrors is the number of lookups failing before the path is
+		// skipped.
+		wantErrors int
+	}{
+		{name: "PVC", path: forbiddenPathPVC, verb: "get", resource: "persistentvolumeclaims", wantErrors: 2},
+		{name: "ShareManager CRD", path: string(LookupSourceShareManager), verb: "get", resource: "sharemanagers", dynamic: true, wantNode: "node-2"},
+		{name: "share-manager pods", path: string(LookupSourcePod), verb: "list", resource: "pods", wantNode: "node-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makePVC(crdTestPVC, "default", crdTestPV), makeShareManagerPod(crdTestPV, "node-2"))
+			dynClient := newDynamicClient(makeShareManagerCR(crdTestPV, "node-1", "running"))
+			inject := func(k8stesting.Action) (bool, runtime.Object, error) { return true, nil, forbidden(tt.resource) }
+			actions := clientset.Actions
+			if tt.dynamic {
+				dynClient.PrependReactor(tt.verb, tt.resource, inject)
+				actions = dynClient.Actions
+			} else {
+				clientset.PrependReactor(tt.verb, tt.resource, inject)
+			}
+			clock := clocktesting.NewFakePassiveClock(time.Now())
+			opts := lookupOptions{
+				forbidden: newForbiddenGuard(clock, 2, time.Minute),
+				order:     []LookupSource{LookupSourcePod, LookupSourceShareManager},
+			}
+			if tt.path == string(LookupSourceShareManager) {
+				opts.order = []LookupSource{LookupSourceShareManager, LookupSourcePod}
+			}
+
+			disabled := forbiddenPathsDisabled.WithLabelValues(tt.path)
+			before, _ := testutil.GetCounterMetricValue(disabled)
+
+			errs := 0
+			for i := 0; i < 4; i++ {
+				pl, err := getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", crdTestPVC, opts)
+				if err != nil {
+					errs++
+					continue
+				}
+				if pl.node != tt.wantNode {
+					t.Errorf("lookup %d: node = %q, want %q", i, pl.node, tt.wantNode)
+				}
+			}
+			if errs != tt.wantErrors {
+				t.Errorf("%d lookups failed, want %d", errs, tt.wantErrors)
+			}
+			if got := countActions(actions(), tt.verb, tt.resource); got != 2 {
+				t.Errorf("%d %s %s requests, want 2 before the path is skipped", got, tt.verb, tt.resource)
+			}
+			if after, _ := testutil.GetCounterMetricValue(disabled); after-before != 1 {
+				t.Errorf("paths disabled counted = %v, want 1", after-before)
+			}
+
+			clock.SetTime(clock.Now().Add(time.Minute))
+			_, _ = getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", crdTestPVC, opts)
+			if got := countActions(actions(), tt.verb, tt.resource); got != 3 {
+				t.Errorf("%d %s %s requests after the cooldown, want 3", got, tt.verb, tt.resource)
+			}
+		})
+	}
+}
+
+// TestForbiddenPVCDegradesToNeutral checks that a hard-mode pod whose PVC
+// reads are Forbidden fails PreFilter only until the path is skipped, and
+// then schedules as if no share-manager was found.
+func TestForbiddenPVCDegradesToNeutral(t *testing.T) {
+	pod := makeVM("vm", "default", true, crdTestPVC)
+	fwk, plugin, clientset := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{makePVC(crdTestPVC, "default", crdTestPV), makeShareManagerPod(crdTestPV, "node-1")},
+		args:    Args{ForbiddenThreshold: 2},
+	})
+	// Read the PVCs through the clientset, as without an informer cache.
+	plugin.pvcLister = nil
+	clientset.PrependReactor("get", "persistentvolumeclaims", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "persistentvolumeclaims"}, crdTestPVC, errors.New("RBAC: access denied"))
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, status := plugin.PreFilter(context.Background(), framework.NewCycleState(), pod); status.Code() != framework.Error {
+			t.Fatalf("PreFilter() cycle %d = %v, want Error", i, status)
+		}
+	}
+	// Without the informer cache the plugin's other PVC reads go through
+	// the clientset too, so the skipped path is checked on the guard.
+	if plugin.forbidden.allow(forbiddenPathPVC, "default") {
+		t.Fatalf("PVC lookups not skipped after repeated Forbidden errors")
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, m := runFilters(t, fwk, pod); m.Len() != 0 {
+			t.Errorf("cycle %d: %d nodes rejected, want none", i, m.Len())
+		}
+	}
+}
+
+// TestReadableNamespaces checks that PVCs are never read in namespaces
+// outside ReadableNamespaces.
+func TestReadableNamespaces(t *testing.T) {
+	tests := []struct {
+		namespace string
+		wantNode  string
+		wantReads int
+	}{
+		{namespace: "default", wantNode: "node-1", wantReads: 1},
+		{namespace: "tenant-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makePVC(crdTestPVC, tt.namespace, crdTestPV), makeShareManagerPod(crdTestPV, "node-1"))
+			opts := lookupOptions{readableNamespaces: []string{"default"}}
+
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, newDynamicClient(), tt.namespace, crdTestPVC, opts)
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode {
+				t.Errorf("getShareManagerNodeForPVC() node = %q, want %q", pl.node, tt.wantNode)
+			}
+			if got := countActions(clientset.Actions(), "get", "persistentvolumeclaims"); got != tt.wantReads {
+				t.Errorf("%d PVC reads, want %d", got, tt.wantReads)
+			}
+		})
+	}
+}
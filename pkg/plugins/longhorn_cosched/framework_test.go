@@ -0,0 +1,274 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/backend/cache"
+	internalqueue "k8s.io/kubernetes/pkg/scheduler/backend/queue"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/defaultbinder"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/feature"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/noderesources"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/queuesort"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	schedmetrics "k8s.io/kubernetes/pkg/scheduler/metrics"
+)
+
+// testCluster describes the cluster a test framework is built from.
+type testCluster struct {
+	// nodes and pods make up the scheduler snapshot. pods must be assigned.
+	nodes []*corev1.Node
+	pods  []*corev1.Pod
+
+	// queued pods wait in the scheduling queue, nominated to their
+	// status.nominatedNodeName.
+	queued []*corev1.Pod
+
+	// objects are added to the fake clientset (and informers) in addition to
+	// nodes and pods, e.g. PVCs, share-manager pods, PDBs and the pod being
+	// scheduled.
+	objects []runtime.Object
+
+	// dynObjects are served by the fake dynamic client.
+	dynObjects []runtime.Object
+
+	// shareManagerVersions are the ShareManager CRD versions reported by
+	// the fake discovery client. shareManagerGVR's version is used when nil.
+	shareManagerVersions []string
+
+	args Args
+
+	// spreadArgs are the args of the VMSpread plugin, which is registered
+	// but only runs if the profile enables it.
+	spreadArgs VMSpreadArgs
+
+	// recorder receives the framework's events. A recorder that drops every
+	// event is used when nil.
+	recorder *events.FakeRecorder
+}
+
+// newTestFramework builds a scheduling framework with PrioritySort,
+// NodeResourcesFit, DefaultBinder and the LonghornCoSchedule plugin enabled at
+// PreFilter, Filter, PostFilter and Score, backed by fake clients.
+func newTestFramework(t *testing.T, c testCluster) (framework.Framework, *Plugin, *fake.Clientset) {
+	t.Helper()
+	return newTestFrameworkWithPlugins(t, c, &config.Plugins{
+		QueueSort:  config.PluginSet{Enabled: []config.Plugin{{Name: queuesort.Name}}},
+		PreFilter:  config.PluginSet{Enabled: []config.Plugin{{Name: noderesources.Name}, {Name: Name}}},
+		Filter:     config.PluginSet{Enabled: []config.Plugin{{Name: noderesources.Name}, {Name: Name}}},
+		PostFilter: config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		Score:      config.PluginSet{Enabled: []config.Plugin{{Name: Name, Weight: 1}}},
+		Bind:       config.PluginSet{Enabled: []config.Plugin{{Name: defaultbinder.Name}}},
+	})
+}
+
+// newTestFrameworkWithPlugins builds a scheduling framework from a profile
+// with the given plugins, backed by fake clients. PrioritySort,
+// NodeResourcesFit, DefaultBinder, LonghornCoSchedule and VMSpread are
+// registered.
+func newTestFrameworkWithPlugins(t *testing.T, c testCluster, plugins *config.Plugins) (framework.Framework, *Plugin, *fake.Clientset) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// The scheduling queue and preemption report metrics, which must be
+	// registered before use.
+	schedmetrics.Register()
+
+	objects := append([]runtime.Object{}, c.objects...)
+	for _, n := range c.nodes {
+		objects = append(objects, n)
+	}
+	for _, p := range c.pods {
+		objects = append(objects, p)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	versions := c.shareManagerVersions
+	if versions == nil {
+		versions = []string{shareManagerGVR.Version}
+	}
+	serveShareManagers(clientset, versions...)
+	informerFactory := informers.NewSharedInformerFactory(clientset, 0)
+	dynClient := newDynamicClient(c.dynObjects...)
+
+	recorder := c.recorder
+	if recorder == nil {
+		recorder = &events.FakeRecorder{}
+	}
+
+	var plugin *Plugin
+	registry := frameworkruntime.Registry{
+		queuesort.Name:     queuesort.New,
+		defaultbinder.Name: defaultbinder.New,
+		noderesources.Name: func(ctx context.Context, _ runtime.Object, h framework.Handle) (framework.Plugin, error) {
+			return noderesources.NewFit(ctx, &config.NodeResourcesFitArgs{
+				ScoringStrategy: &config.ScoringStrategy{
+					Type:      config.LeastAllocated,
+					Resources: []config.ResourceSpec{{Name: "cpu", Weight: 1}, {Name: "memory", Weight: 1}},
+				},
+			}, h, feature.Features{})
+		},
+		Name: func(ctx context.Context, _ runtime.Object, h framework.Handle) (framework.Plugin, error) {
+			var err error
+			plugin, err = newPlugin(ctx, h, c.args, clientset, dynClient)
+			return plugin, err
+		},
+		VMSpreadName: func(_ context.Context, _ runtime.Object, h framework.Handle) (framework.Plugin, error) {
+			return newVMSpread(h, c.spreadArgs), nil
+		},
+	}
+	profile := &config.KubeSchedulerProfile{
+		SchedulerName: "kubevirt-scheduler",
+		Plugins:       plugins,
+	}
+
+	// The nominator only keeps pods its own pod lister knows.
+	var queued []runtime.Object
+	for _, pod := range c.queued {
+		queued = append(queued, pod)
+	}
+	queue := internalqueue.NewTestQueueWithObjects(ctx, nil, queued)
+	for _, pod := range c.queued {
+		queue.Add(klog.Background(), pod)
+	}
+
+	fwk, err := frameworkruntime.NewFramework(ctx, registry, profile,
+		frameworkruntime.WithClientSet(clientset),
+		frameworkruntime.WithInformerFactory(informerFactory),
+		frameworkruntime.WithSnapshotSharedLister(cache.NewSnapshot(c.pods, c.nodes)),
+		frameworkruntime.WithPodNominator(queue),
+		frameworkruntime.WithEventRecorder(recorder),
+		frameworkruntime.WithWaitingPods(frameworkruntime.NewWaitingPodsMap()),
+	)
+	if err != nil {
+		t.Fatalf("creating framework: %v", err)
+	}
+
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+	waitForLonghornInformers(ctx, t, plugin, c.dynObjects)
+
+	return fwk, plugin, clientset
+}
+
+// waitForLonghornInformers waits until the plugin's Replica and Volume
+// informers, which it does not wait for itself, list every Replica and
+// Volume of objects in their namespace.
+func waitForLonghornInformers(ctx context.Context, t *testing.T, plugin *Plugin, objects []runtime.Object) {
+	t.Helper()
+	if plugin == nil {
+		return
+	}
+	want := map[string]int{}
+	for _, obj := range objects {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			want[u.GetKind()+"/"+u.GetNamespace()]++
+		}
+	}
+	listers := map[string]toolscache.GenericNamespaceLister{}
+	for namespace, lister := range plugin.replicas {
+		listers["Replica/"+namespace] = lister
+	}
+	for namespace, lister := range plugin.volumes {
+		listers["Volume/"+namespace] = lister
+	}
+	for key, lister := range listers {
+		if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+			listed, err := lister.List(labels.Everything())
+			return len(listed) == want[key], err
+		}); err != nil {
+			t.Fatalf("Longhorn %s not listed: %v", key, err)
+		}
+	}
+}
+
+// runFilters runs PreFilter and Filter for the pod on every node of the
+// cluster, returning the cycle state and the per-node statuses in the form
+// PostFilter receives them.
+func runFilters(t *testing.T, fwk framework.Framework, pod *corev1.Pod) (*framework.CycleState, *framework.NodeToStatus) {
+	t.Helper()
+	ctx := context.Background()
+	state := framework.NewCycleState()
+	m := framework.NewDefaultNodeToStatus()
+
+	if _, status, _ := fwk.RunPreFilterPlugins(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("RunPreFilterPlugins() = %v", status)
+	}
+
+	nodeInfos, err := fwk.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		t.Fatalf("listing nodes: %v", err)
+	}
+	for _, ni := range nodeInfos {
+		if status := fwk.RunFilterPluginsWithNominatedPods(ctx, state, pod, ni); !status.IsSuccess() {
+			m.Set(ni.Node().Name, status)
+		}
+	}
+	return state, m
+}
+
+// makeNode creates a node with the given CPU capacity.
+func makeNode(name, cpu string) *corev1.Node {
+	capacity := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse("16Gi"),
+		corev1.ResourcePods:   resource.MustParse("110"),
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Capacity:    capacity,
+			Allocatable: capacity,
+		},
+	}
+}
+
+// withCPU sets a single-container CPU request on the pod and returns it.
+func withCPU(pod *corev1.Pod, cpu string) *corev1.Pod {
+	pod.Spec.Containers = []corev1.Container{{
+		Name: "compute",
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+		},
+	}}
+	return pod
+}
+
+// withPriority sets the pod's priority and returns it.
+func withPriority(pod *corev1.Pod, priority int32) *corev1.Pod {
+	pod.Spec.Priority = &priority
+	return pod
+}
+
+// makeRunningPod creates a running pod on the given node.
+func makeRunningPod(name, namespace, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID("uid-" + name),
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
+	}
+}
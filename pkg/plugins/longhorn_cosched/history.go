@@ -0,0 +1,160 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// historyEntry is a co-scheduling decision as kept in the decision history.
+// It holds nothing but object names, so a dump can be shared in a bug
+// report.
+type historyEntry struct {
+	time    time.Time
+	pod     klog.ObjectRef
+	mode    Mode
+	outcome string
+	node    string
+	volumes []historyVolume
+}
+
+// historyVolume is a share-manager the lookup found for one of the pod's
+// PVCs: the node it runs on and the lookup source that found it.
+type historyVolume struct {
+	pvc    string
+	node   string
+	source string
+}
+
+// String formats the entry as one line of a dump, e.g.
+//
+//	2026-10-16T12:00:00Z pod=default/vm mode=hard outcome=pinned node=node-1 pvcs=shared@node-1(shareManager)
+func (e historyEntry) String() string {
+	volumes := make([]string, 0, len(e.volumes))
+	for _, v := range e.volumes {
+		volumes = append(volumes, fmt.Sprintf("%s@%s(%s)", v.pvc, v.node, v.source))
+	}
+	return fmt.Sprintf("%s pod=%s mode=%s outcome=%s node=%s pvcs=%s",
+		e.time.UTC().Format(time.RFC3339), e.pod, e.mode, e.outcome, orDash(e.node), orDash(strings.Join(volumes, ",")))
+}
+
+// orDash returns s, or "-" if it is empty, so that every field of a dump line
+// has a value.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// decisionHistory keeps the last decisions of the plugin in a ring buffer, so
+// that the cluster state a pod was scheduled on can still be seen when a
+// placement is looked into. See Args.DecisionHistorySize.
+//
+// A nil *decisionHistory keeps nothing.
+type decisionHistory struct {
+	clock clock.PassiveClock
+
+	mu      sync.Mutex
+	entries []historyEntry
+	// next is the index the next entry is written to; once the buffer is
+	// full, it is also the oldest entry.
+	next int
+	full bool
+}
+
+func newDecisionHistory(clock clock.PassiveClock, size int) *decisionHistory {
+	return &decisionHistory{clock: clock, entries: make([]historyEntry, size)}
+}
+
+// record adds the decision PreFilter made for the pod, overwriting the oldest
+// entry once the buffer is full.
+func (h *decisionHistory) record(pod *corev1.Pod, data *stateData) {
+	if h == nil || len(h.entries) == 0 {
+		return
+	}
+	d := data.decision
+	entry := historyEntry{time: h.clock.Now(), pod: klog.KObj(pod), mode: d.Mode, outcome: d.Outcome, node: d.ShareManagerNode}
+	for _, pl := range data.placements {
+		if pl.pvc == "" {
+			continue
+		}
+		source := string(pl.from)
+		switch {
+		case pl.migratable:
+			source = lookupResultMigratable
+		case pl.consumer:
+			source = lookupResultConsumer
+		}
+		entry.volumes = append(entry.volumes, historyVolume{pvc: pl.pvc, node: pl.node, source: orDash(source)})
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// snapshot returns the recorded entries, oldest first.
+func (h *decisionHistory) snapshot() []historyEntry {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]historyEntry(nil), h.entries[:h.next]...)
+	}
+	return append(append([]historyEntry(nil), h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+// dump writes the recorded entries to w, oldest first, one per line, after a
+// header line counting them.
+func (h *decisionHistory) dump(w io.Writer) error {
+	if h == nil {
+		return nil
+	}
+	entries := h.snapshot()
+	if _, err := fmt.Fprintf(w, "LonghornCoSchedule: last %d of at most %d decisions\n", len(entries), len(h.entries)); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, err := fmt.Fprintln(w, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpOnSignal dumps the history to stderr whenever the scheduler receives
+// SIGUSR1, until ctx is done.
+func (h *decisionHistory) dumpOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				if err := h.dump(os.Stderr); err != nil {
+					klog.ErrorS(err, "LonghornCoSchedule: error dumping the decision history")
+				}
+			}
+		}
+	}()
+}
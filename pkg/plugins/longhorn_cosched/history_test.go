@@ -0,0 +1,74 @@
+package longhorn_cosched
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestDecisionHistory checks that the decision history keeps only the last
+// decisions once more are recorded than it holds, and dumps them oldest
+// first in the documented format.
+func TestDecisionHistory(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	fakeClock := clocktesting.NewFakePassiveClock(start)
+	h := newDecisionHistory(fakeClock, 3)
+
+	for i, name := range []string{"vm-0", "vm-1", "vm-2", "vm-3", "vm-4"} {
+		fakeClock.SetTime(start.Add(time.Duration(i) * time.Minute))
+		data := resolvePlacements([]shareManagerPlacement{
+			{pvc: "shared", node: "node-1", from: LookupSourceShareManager},
+			{pvc: "scratch", node: "node-2", consumer: true},
+		}, ConflictPolicyFirst)
+		data.mode = ModeHard
+		pod := makeVM(name, "default", true)
+		data.decide(pod)
+		h.record(pod, data)
+	}
+	pod := makeVM("vm-5", "default", true)
+	data := &stateData{mode: ModeSoft}
+	data.decide(pod)
+	h.record(pod, data)
+
+	var buf bytes.Buffer
+	if err := h.dump(&buf); err != nil {
+		t.Fatalf("dump() error = %v", err)
+	}
+	want := strings.Join([]string{
+		"LonghornCoSchedule: last 3 of at most 3 decisions",
+		"2026-10-16T12:03:00Z pod=default/vm-3 mode=hard outcome=pinned node=node-1 pvcs=shared@node-1(shareManager),scratch@node-2(consumer)",
+		"2026-10-16T12:04:00Z pod=default/vm-4 mode=hard outcome=pinned node=node-1 pvcs=shared@node-1(shareManager),scratch@node-2(consumer)",
+		"2026-10-16T12:04:00Z pod=default/vm-5 mode=soft outcome=no-share-manager node=- pvcs=-",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("dump() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestDecisionHistoryPreFilter checks that PreFilter records its decisions
+// when the decisionHistorySize arg is set.
+func TestDecisionHistoryPreFilter(t *testing.T) {
+	const pvName = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+
+	pod := makeVM("vm", "default", true, "shared")
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{pod, makePVC("shared", "default", pvName), makeShareManagerPod(pvName, "node-1")},
+		args:    Args{DecisionHistorySize: 10},
+	})
+	runFilters(t, fwk, pod)
+
+	entries := plugin.history.snapshot()
+	if len(entries) != 1 {
+		t.Fatalf("%d decisions recorded, want 1", len(entries))
+	}
+	if got := entries[0].String(); !strings.HasSuffix(got, " pod=default/vm mode=hard outcome=pinned node=node-1 pvcs=shared@node-1(pod)") {
+		t.Errorf("recorded decision = %q", got)
+	}
+}
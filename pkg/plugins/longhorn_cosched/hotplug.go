@@ -0,0 +1,170 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// hotplugOwner returns the name of the virt-launcher pod that owns the given
+// hotplug attachment pod, or "" if the pod is not a hotplug attachment pod.
+func hotplugOwner(pod *corev1.Pod) string {
+	if pod.Labels[HotplugPodLabel] != HotplugPodLabelValue {
+		return ""
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "Pod" && ref.APIVersion == "v1" {
+			return ref.Name
+		}
+	}
+	return ""
+}
+
+// hotplugVMNode returns the node of the named virt-launcher pod, or "" if the
+// pod does not exist, is not scheduled yet, or has not opted in to
+// co-scheduling.
+func (p *Plugin) hotplugVMNode(ctx context.Context, namespace, launcherName string) (string, error) {
+	launcher, err := p.clientset.CoreV1().Pods(namespace).Get(ctx, launcherName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting virt-launcher pod %s/%s: %w", namespace, launcherName, err)
+	}
+	if !p.isOptedIn(ctx, launcher) {
+		return "", nil
+	}
+	return launcher.Spec.NodeName, nil
+}
+
+// preFilterHotplug is PreFilter for hotplug attachment pods. It stores the
+// virt-launcher pod's node in the CycleState, or skips Filter if there is
+// none.
+func (p *Plugin) preFilterHotplug(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	data, err := p.resolve(ctx, pod)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/PreFilter: error looking up virt-launcher pod", "pod", klog.KObj(pod))
+		return nil, framework.AsStatus(err)
+	}
+
+	klog.V(4).InfoS("LonghornCoSchedule/PreFilter: resolved hotplug attachment pod's VM node",
+		"pod", klog.KObj(pod),
+		"virtLauncher", hotplugOwner(pod),
+		"vmNode", data.vmNode,
+	)
+	data.decide(pod)
+	state.Write(stateKey, data)
+	if data.vmNode == "" {
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	return nil, nil
+}
+
+// filterHotplug is Filter for hotplug attachment pods: only the node of the
+// owning virt-launcher pod passes.
+func (p *Plugin) filterHotplug(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+
+	data, err := p.cycleData(ctx, state, pod)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/Filter: error looking up virt-launcher pod", "pod", klog.KObj(pod))
+		return framework.AsStatus(err)
+	}
+	if data.vmNode == "" || node.Name == data.vmNode {
+		return nil
+	}
+
+	klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (hotplug attachment pod's VM on different node)",
+		"pod", klog.KObj(pod),
+		"node", node.Name,
+		"vmNode", data.vmNode,
+	)
+	return framework.NewStatus(
+		framework.UnschedulableAndUnresolvable,
+		fmt.Sprintf("node %q rejected: hotplug attachment pod must run on the node of virt-launcher pod %q (%q)", node.Name, hotplugOwner(pod), data.vmNode),
+	)
+}
+
+// scoreHotplug is Score for hotplug attachment pods: the owning virt-launcher
+// pod's node receives the maximum score.
+func (p *Plugin) scoreHotplug(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) (int64, *framework.Status) {
+	data, err := p.cycleData(ctx, state, pod)
+	if err != nil {
+		return 0, framework.AsStatus(err)
+	}
+	if data.vmNode != "" && nodeName == data.vmNode {
+		return framework.MaxNodeScore, nil
+	}
+	return 0, nil
+}
+
+// hotplugPVCNames returns the claims of the pod's volumes that the owning
+// VirtualMachineInstance reports as hotplugged: a status.volumeStatus entry
+// with hotplugVolume set, named like the pod volume. KubeVirt names the
+// volumes of a virt-launcher pod after the VMI's volumes. It returns nil if
+// the IncludeHotplugVolumes arg is set, the pod has no VMI owner, or the VMI
+// cannot be read.
+func (p *Plugin) hotplugPVCNames(ctx context.Context, pod *corev1.Pod) []string {
+	if p.hotplugVolumes == nil || p.dynClient == nil {
+		return nil
+	}
+	owner := VMIOwner(pod)
+	if owner == nil {
+		return nil
+	}
+
+	volumes, ok := p.hotplugVolumes.get(owner.UID)
+	if !ok {
+		obj, err := p.getVMI(ctx, pod.Namespace, owner.Name)
+		switch {
+		case apierrors.IsNotFound(err):
+		case err != nil:
+			klog.V(4).InfoS("LonghornCoSchedule: reading VirtualMachineInstance failed", "pod", klog.KObj(pod), "vmi", owner.Name, "err", err)
+			return nil
+		case obj.GetUID() == owner.UID:
+			volumes = hotplugVolumeNames(obj)
+		}
+		p.hotplugVolumes.put(owner.UID, volumes)
+	}
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	var names []string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil && slices.Contains(volumes, vol.Name) {
+			names = append(names, vol.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	if len(names) > 0 {
+		klog.V(5).InfoS("LonghornCoSchedule: leaving out hotplugged volumes", "pod", klog.KObj(pod), "pvcs", names)
+	}
+	return names
+}
+
+// hotplugVolumeNames returns the names of the volumes an unstructured
+// VirtualMachineInstance reports as hotplugged in status.volumeStatus.
+func hotplugVolumeNames(vmi *unstructured.Unstructured) []string {
+	statuses, _, _ := unstructured.NestedSlice(vmi.Object, "status", "volumeStatus")
+	var names []string
+	for _, item := range statuses {
+		status, ok := item.(map[string]interface{})
+		if !ok || status["hotplugVolume"] == nil {
+			continue
+		}
+		if name, ok := status["name"].(string); ok && name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
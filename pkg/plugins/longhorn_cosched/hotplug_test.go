@@ -0,0 +1,219 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeHotplugPod creates a KubeVirt hotplug attachment pod owned by the named
+// virt-launcher pod.
+func makeHotplugPod(name, namespace, launcherName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{HotplugPodLabel: HotplugPodLabelValue},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       launcherName,
+				UID:        "uid-launcher",
+			}},
+		},
+	}
+}
+
+func TestHotplugOwner(t *testing.T) {
+	unlabeled := makeHotplugPod("hp-volume-abcde", "default", "virt-launcher-vm")
+	unlabeled.Labels = nil
+
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want string
+	}{
+		{name: "hotplug attachment pod", pod: makeHotplugPod("hp-volume-abcde", "default", "virt-launcher-vm"), want: "virt-launcher-vm"},
+		{name: "missing hotplug label", pod: unlabeled, want: ""},
+		{name: "virt-launcher pod", pod: makeVM("virt-launcher-vm", "default", true), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hotplugOwner(tt.pod); got != tt.want {
+				t.Errorf("hotplugOwner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHotplugFilterAndScore(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		vmNode      = "node-2"
+		otherNode   = "node-1"
+	)
+
+	launcher := makeVM("virt-launcher-vm", vmNamespace, true)
+	launcher.Spec.NodeName = vmNode
+	notOptedIn := makeVM("virt-launcher-vm", vmNamespace, false)
+	notOptedIn.Spec.NodeName = vmNode
+	unscheduled := makeVM("virt-launcher-vm", vmNamespace, true)
+
+	tests := []struct {
+		name      string
+		objects   []runtime.Object
+		wantSkip  bool
+		wantPass  map[string]bool
+		wantScore map[string]int64
+	}{
+		{
+			name:      "launcher on node-2 — attachment pod restricted to node-2",
+			objects:   []runtime.Object{launcher},
+			wantPass:  map[string]bool{vmNode: true, otherNode: false},
+			wantScore: map[string]int64{vmNode: framework.MaxNodeScore, otherNode: 0},
+		},
+		{
+			name:      "launcher not opted in — plugin is a no-op",
+			objects:   []runtime.Object{notOptedIn},
+			wantSkip:  true,
+			wantPass:  map[string]bool{vmNode: true, otherNode: true},
+			wantScore: map[string]int64{vmNode: 0, otherNode: 0},
+		},
+		{
+			name:      "launcher not scheduled yet — plugin is a no-op",
+			objects:   []runtime.Object{unscheduled},
+			wantSkip:  true,
+			wantPass:  map[string]bool{vmNode: true, otherNode: true},
+			wantScore: map[string]int64{vmNode: 0, otherNode: 0},
+		},
+		{
+			name:      "launcher gone — plugin is a no-op",
+			wantSkip:  true,
+			wantPass:  map[string]bool{vmNode: true, otherNode: true},
+			wantScore: map[string]int64{vmNode: 0, otherNode: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &Plugin{clientset: fake.NewSimpleClientset(tt.objects...)}
+			pod := makeHotplugPod("hp-volume-abcde", vmNamespace, "virt-launcher-vm")
+
+			state := framework.NewCycleState()
+			_, status := plugin.PreFilter(context.Background(), state, pod)
+			if status.IsSkip() != tt.wantSkip {
+				t.Errorf("PreFilter() skip = %v, want %v (status: %v)", status.IsSkip(), tt.wantSkip, status)
+			}
+
+			for node, want := range tt.wantPass {
+				status := plugin.Filter(context.Background(), state, pod, makeNodeInfo(node))
+				if status.IsSuccess() != want {
+					t.Errorf("Filter(%s) pass = %v, want %v (status: %v)", node, status.IsSuccess(), want, status)
+				}
+				if !status.IsSuccess() && status.Code() != framework.UnschedulableAndUnresolvable {
+					t.Errorf("Filter(%s) code = %v, want UnschedulableAndUnresolvable", node, status.Code())
+				}
+			}
+			for node, want := range tt.wantScore {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) status = %v", node, status)
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestHotplugVolumesExcluded checks that the PVCs of volumes the VMI reports
+// as hotplugged do not decide where a virt-launcher pod goes, unless the
+// IncludeHotplugVolumes arg is set or the co-schedule-pvc annotation names
+// them. The hotplugged disk comes first in the pod's volumes and its
+// share-manager runs on node-2; the boot disk's runs on node-1.
+func TestHotplugVolumesExcluded(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		vmiName     = "my-vm"
+		vmiUID      = types.UID("5d0b1c7e-2f41-4c1a-9a57-0c6f8d3e2b19")
+		hotPVC      = "hotdisk"
+		hotPV       = "pvc-7d1c0c4e-9b6f-4b8e-a3f4-2f1e8c0b9d11"
+		bootPVC     = "rootdisk"
+		bootPV      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	tests := []struct {
+		name        string
+		include     bool
+		annotation  *string
+		noStatus    bool
+		wantNode    string
+		wantVMIRead bool
+	}{
+		{name: "hotplugged disk left out", wantNode: "node-1", wantVMIRead: true},
+		{name: "includeHotplugVolumes", include: true, wantNode: "node-2"},
+		{name: "co-schedule-pvc names the hotplugged disk", annotation: ptr(hotPVC), wantNode: "node-2", wantVMIRead: true},
+		{name: "no hotplug volume status", noStatus: true, wantNode: "node-2", wantVMIRead: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeLauncher(vmiName, vmNamespace, vmiUID, hotPVC, bootPVC)
+			pod.Annotations = map[string]string{AnnotationKey: AnnotationValue}
+			if tt.annotation != nil {
+				pod.Annotations[CoSchedulePVCAnnotationKey] = *tt.annotation
+			}
+			vmi := makeVMI(vmiName, vmNamespace, vmiUID, nil)
+			if !tt.noStatus {
+				vmi.Object["status"] = map[string]interface{}{
+					"volumeStatus": []interface{}{
+						map[string]interface{}{"name": bootPVC, "target": "vda"},
+						map[string]interface{}{
+							"name":          hotPVC,
+							"target":        "sda",
+							"hotplugVolume": map[string]interface{}{"attachPodName": "hp-volume-abcde"},
+						},
+					},
+				}
+			}
+
+			_, plugin, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects: []runtime.Object{
+					pod,
+					makePVC(hotPVC, vmNamespace, hotPV),
+					makePVC(bootPVC, vmNamespace, bootPV),
+					makeShareManagerPod(hotPV, "node-2"),
+					makeShareManagerPod(bootPV, "node-1"),
+				},
+				dynObjects: []runtime.Object{vmi},
+				args:       Args{IncludeHotplugVolumes: tt.include},
+			})
+			dynClient := plugin.dynClient.(*dynamicfake.FakeDynamicClient)
+			dynClient.ClearActions()
+
+			state := framework.NewCycleState()
+			if _, status := plugin.PreFilter(context.Background(), state, pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter() = %v", status)
+			}
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).shareManagerNode; got != tt.wantNode {
+				t.Errorf("shareManagerNode = %q, want %q", got, tt.wantNode)
+			}
+			if got := countActions(dynClient.Actions(), "get", vmiGVR.Resource) > 0; got != tt.wantVMIRead {
+				t.Errorf("VMI read = %v, want %v", got, tt.wantVMIRead)
+			}
+		})
+	}
+}
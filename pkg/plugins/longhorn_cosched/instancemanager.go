@@ -0,0 +1,55 @@
+package longhorn_cosched
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/util"
+)
+
+// filterInstanceManager rejects the node if the RequireInstanceManager arg is
+// set and no Running, non-terminating pod in the pod's Longhorn namespace
+// matches the instance-manager selector on it: Longhorn cannot start the
+// engines of the pod's volumes there. The node's pods are taken from the
+// snapshot.
+func (p *Plugin) filterInstanceManager(pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if p.instanceManagers == nil || node == nil {
+		return nil
+	}
+	namespace := p.lookupOptions(pod).longhornNamespace()
+	for _, pi := range nodeInfo.Pods {
+		if isLonghornComponentPod(pi.Pod, namespace, p.instanceManagers) && pi.Pod.Status.Phase == corev1.PodRunning {
+			return nil
+		}
+	}
+
+	klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (no Running Longhorn instance-manager pod)",
+		"pod", klog.KObj(pod),
+		"node", node.Name,
+		"selector", p.instanceManagers.String(),
+	)
+	return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+		fmt.Sprintf("node %q has no Running Longhorn instance-manager pod (%s in namespace %q), volumes cannot be attached", node.Name, p.instanceManagers, namespace))
+}
+
+// isSchedulableAfterInstanceManagerChange requeues the pod when a Longhorn
+// instance-manager pod in the pod's Longhorn namespace starts Running, which
+// may make a rejected node usable.
+func (p *Plugin) isSchedulableAfterInstanceManagerChange(logger klog.Logger, pod *corev1.Pod, oldObj, newObj interface{}) (framework.QueueingHint, error) {
+	oldPod, newPod, err := util.As[*corev1.Pod](oldObj, newObj)
+	if err != nil {
+		return framework.Queue, err
+	}
+	namespace := p.lookupOptions(pod).longhornNamespace()
+	if !isLonghornComponentPod(newPod, namespace, p.instanceManagers) || newPod.Status.Phase != corev1.PodRunning {
+		return framework.QueueSkip, nil
+	}
+	if oldPod != nil && oldPod.Status.Phase == corev1.PodRunning {
+		return framework.QueueSkip, nil
+	}
+	logger.V(5).Info("Longhorn instance-manager pod started Running, requeueing", "pod", klog.KObj(pod), "instanceManagerPod", klog.KObj(newPod), "node", newPod.Spec.NodeName)
+	return framework.Queue, nil
+}
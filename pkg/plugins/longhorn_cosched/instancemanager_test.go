@@ -0,0 +1,141 @@
+package longhorn_cosched
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeInstanceManagerPod creates a Longhorn instance-manager pod on the given
+// node, with the given component label and phase.
+func makeInstanceManagerPod(nodeName, component string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "instance-manager-" + nodeName,
+			Namespace: LonghornNamespace,
+			UID:       types.UID("im-" + nodeName),
+			Labels:    map[string]string{"longhorn.io/component": component},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+// TestRequireInstanceManager checks that, with RequireInstanceManager,
+// opted-in pods are kept off nodes whose instance-manager pod is missing or
+// not Running, and that the selector is configurable.
+func TestRequireInstanceManager(t *testing.T) {
+	const pvcName = "shared"
+
+	tests := []struct {
+		name         string
+		args         Args
+		value        string
+		wantRejected []string
+	}{
+		{
+			name:         "hard",
+			args:         Args{RequireInstanceManager: true},
+			value:        "true",
+			wantRejected: []string{"node-2", "node-3", "node-4"},
+		},
+		{
+			name:         "soft",
+			args:         Args{RequireInstanceManager: true},
+			value:        "soft",
+			wantRejected: []string{"node-2", "node-3", "node-4"},
+		},
+		{
+			name:         "custom selector",
+			args:         Args{RequireInstanceManager: true, InstanceManagerSelector: "longhorn.io/component in (instance-manager, engine-manager)"},
+			value:        "true",
+			wantRejected: []string{"node-2", "node-3"},
+		},
+		{name: "not opted in", args: Args{RequireInstanceManager: true}, value: OptOutValue},
+		{name: "flag off", value: "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", false, pvcName)
+			pod.Annotations = map[string]string{AnnotationKey: tt.value}
+
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4"), makeNode("node-4", "4")},
+				pods: []*corev1.Pod{
+					makeInstanceManagerPod("node-1", "instance-manager", corev1.PodRunning),
+					makeInstanceManagerPod("node-3", "instance-manager", corev1.PodPending),
+					makeInstanceManagerPod("node-4", "engine-manager", corev1.PodRunning),
+				},
+				objects: []runtime.Object{pod},
+				args:    tt.args,
+			})
+
+			_, m := runFilters(t, fwk, pod)
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				status := m.Get(node)
+				if status.Code() != framework.UnschedulableAndUnresolvable {
+					t.Errorf("status of %s = %v, want UnschedulableAndUnresolvable", node, status)
+				}
+				if !strings.Contains(status.Message(), "no Running Longhorn instance-manager pod") {
+					t.Errorf("status of %s = %q, want it to name the missing instance-manager", node, status.Message())
+				}
+			}
+		})
+	}
+}
+
+func TestIsSchedulableAfterInstanceManagerChange(t *testing.T) {
+	plugin := &Plugin{instanceManagers: labels.SelectorFromSet(labels.Set{"longhorn.io/component": "instance-manager"})}
+	pod := makeVM("vm", "default", true)
+	otherNamespace := makeInstanceManagerPod("node-1", "instance-manager", corev1.PodRunning)
+	otherNamespace.Namespace = "storage"
+
+	tests := []struct {
+		name     string
+		old, new *corev1.Pod
+		want     framework.QueueingHint
+	}{
+		{name: "Running instance-manager pod added", new: makeInstanceManagerPod("node-1", "instance-manager", corev1.PodRunning), want: framework.Queue},
+		{name: "instance-manager pod added Pending", new: makeInstanceManagerPod("node-1", "instance-manager", corev1.PodPending), want: framework.QueueSkip},
+		{
+			name: "instance-manager pod started Running",
+			old:  makeInstanceManagerPod("node-1", "instance-manager", corev1.PodPending),
+			new:  makeInstanceManagerPod("node-1", "instance-manager", corev1.PodRunning),
+			want: framework.Queue,
+		},
+		{
+			name: "instance-manager pod stayed Running",
+			old:  makeInstanceManagerPod("node-1", "instance-manager", corev1.PodRunning),
+			new:  makeInstanceManagerPod("node-1", "instance-manager", corev1.PodRunning),
+			want: framework.QueueSkip,
+		},
+		{name: "other pod", new: makeInstanceManagerPod("node-1", "manager", corev1.PodRunning), want: framework.QueueSkip},
+		{name: "instance-manager pod in another namespace", new: otherNamespace, want: framework.QueueSkip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var oldObj interface{}
+			if tt.old != nil {
+				oldObj = tt.old
+			}
+			got, err := plugin.isSchedulableAfterInstanceManagerChange(klog.Background(), pod, oldObj, tt.new)
+			if err != nil {
+				t.Fatalf("isSchedulableAfterInstanceManagerChange() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isSchedulableAfterInstanceManagerChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// longhornNodeResource is the resource of Longhorn's Node CRD, served in the
+// same group and version as ShareManagers. Longhorn Nodes are named after
+// the Kubernetes node.
+const longhornNodeResource = "nodes"
+
+// watchLonghornNodes starts an informer on the Longhorn Nodes in
+// LonghornNamespace and returns its lister, or nil if the ShareManager CRD,
+// and so Longhorn's API group, is not served. The informer is not waited
+// for: until it has synced, every node counts as schedulable for storage.
+func watchLonghornNodes(ctx context.Context, dynClient dynamic.Interface, api *shareManagerAPI) cache.GenericNamespaceLister {
+	gvr, served := api.resource()
+	if dynClient == nil || !served {
+		klog.V(2).InfoS("LonghornCoSchedule: Longhorn CRDs not served, not watching Longhorn Nodes")
+		return nil
+	}
+	gvr.Resource = longhornNodeResource
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynClient, 0, LonghornNamespace, nil)
+	informer := factory.ForResource(gvr)
+	factory.Start(ctx.Done())
+	return informer.Lister().ByNamespace(LonghornNamespace)
+}
+
+// longhornNodeUnschedulable returns why Longhorn considers the node
+// unschedulable for storage, or "" if it does not, the node has no Longhorn
+// Node, or Longhorn Nodes are not watched.
+func (p *Plugin) longhornNodeUnschedulable(nodeName string) string {
+	if p.longhornNodes == nil {
+		return ""
+	}
+	obj, err := p.longhornNodes.Get(nodeName)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.V(4).InfoS("LonghornCoSchedule: reading Longhorn Node failed", "node", nodeName, "err", err)
+		}
+		return ""
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+	return longhornNodeUnschedulableReason(u)
+}
+
+// longhornNodeUnschedulableReason returns why the Longhorn Node is
+// unschedulable for storage: spec.allowScheduling is false, or its Ready or
+// Schedulable condition is not True. Conditions are read in both the list
+// form of v1beta2 and the map form of v1beta1; missing fields count as
+// schedulable.
+func longhornNodeUnschedulableReason(node *unstructured.Unstructured) string {
+	if allow, found, _ := unstructured.NestedBool(node.Object, "spec", "allowScheduling"); found && !allow {
+		return "allowScheduling is false"
+	}
+
+	conditions := map[string]map[string]interface{}{}
+	switch raw := node.Object["status"].(type) {
+	case map[string]interface{}:
+		switch c := raw["conditions"].(type) {
+		case []interface{}:
+			for _, item := range c {
+				if m, ok := item.(map[string]interface{}); ok {
+					if t, ok := m["type"].(string); ok {
+						conditions[t] = m
+					}
+				}
+			}
+		case map[string]interface{}:
+			for t, item := range c {
+				if m, ok := item.(map[string]interface{}); ok {
+					conditions[t] = m
+				}
+			}
+		}
+	}
+	for _, t := range []string{"Ready", "Schedulable"} {
+		c, ok := conditions[t]
+		if !ok {
+			continue
+		}
+		if status, _ := c["status"].(string); status != "True" {
+			reason, _ := c["reason"].(string)
+			return fmt.Sprintf("condition %s is %q (%s)", t, status, reason)
+		}
+	}
+	return ""
+}
+
+// filterLonghornNode rejects the node, under LonghornNodePolicyFilter, if
+// Longhorn considers it unschedulable for storage.
+func (p *Plugin) filterLonghornNode(pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if p.args.longhornNodePolicy() != LonghornNodePolicyFilter || node == nil {
+		return nil
+	}
+	nodeName := node.Name
+	reason := p.longhornNodeUnschedulable(nodeName)
+	if reason == "" {
+		return nil
+	}
+	klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (Longhorn Node unschedulable)",
+		"pod", klog.KObj(pod),
+		"node", nodeName,
+		"reason", reason,
+	)
+	return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+		fmt.Sprintf("Longhorn considers node %q unschedulable for storage: %s", nodeName, reason))
+}
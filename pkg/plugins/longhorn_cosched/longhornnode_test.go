@@ -0,0 +1,167 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeLonghornNode creates an unstructured Longhorn Node with the given
+// allowScheduling and, if set, Ready condition status.
+func makeLonghornNode(name string, allowScheduling bool, ready string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(shareManagerGVR.Group + "/" + shareManagerGVR.Version)
+	obj.SetKind("Node")
+	obj.SetNamespace(LonghornNamespace)
+	obj.SetName(name)
+	obj.Object["spec"] = map[string]interface{}{"allowScheduling": allowScheduling}
+	if ready != "" {
+		obj.Object["status"] = map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": ready, "reason": "ManagerPodDown"},
+				map[string]interface{}{"type": "Schedulable", "status": "True"},
+			},
+		}
+	}
+	return obj
+}
+
+// TestLonghornNodePolicy checks that nodes Longhorn considers unschedulable
+// for storage score 0 for opted-in pods, and are rejected in Filter under
+// the filter policy. The share-manager runs on node-1, whose Longhorn Node
+// has allowScheduling=false; node-3's Longhorn Node is not Ready. A hard-mode
+// pod is not pinned to node-1 then, or no node would pass.
+func TestLonghornNodePolicy(t *testing.T) {
+	const (
+		pvcName = "shared"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	tests := []struct {
+		name         string
+		policy       LonghornNodePolicy
+		value        string
+		wantScores   map[string]int64
+		wantRejected []string
+		wantEvent    string
+	}{
+		{
+			name:       "ignore",
+			value:      "soft",
+			wantScores: map[string]int64{"node-1": framework.MaxNodeScore, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:       "score",
+			policy:     LonghornNodePolicyScore,
+			value:      "soft",
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:         "filter, soft",
+			policy:       LonghornNodePolicyFilter,
+			value:        "soft",
+			wantScores:   map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+			wantRejected: []string{"node-1", "node-3"},
+		},
+		{
+			name:         "filter, hard",
+			policy:       LonghornNodePolicyFilter,
+			value:        "true",
+			wantRejected: []string{"node-1", "node-3"},
+			wantEvent:    longhornUnschedulableReason,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", false, pvcName)
+			pod.Annotations = map[string]string{AnnotationKey: tt.value}
+
+			recorder := events.NewFakeRecorder(10)
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+				objects:  []runtime.Object{pod, makePVC(pvcName, "default", pvName), makeShareManagerPod(pvName, "node-1")},
+				recorder: recorder,
+				dynObjects: []runtime.Object{
+					makeLonghornNode("node-1", false, "True"),
+					makeLonghornNode("node-2", true, "True"),
+					makeLonghornNode("node-3", true, "False"),
+				},
+				args: Args{LonghornNodePolicy: tt.policy},
+			})
+			if plugin.longhornNodes != nil {
+				ctx := context.Background()
+				if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+					nodes, err := plugin.longhornNodes.List(labels.Everything())
+					return len(nodes) == 3, err
+				}); err != nil {
+					t.Fatalf("Longhorn Nodes not listed: %v", err)
+				}
+			}
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				if m.Get(node).IsSuccess() {
+					t.Errorf("node %s not rejected", node)
+				}
+			}
+			for node, want := range tt.wantScores {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+			var emitted []string
+			for len(recorder.Events) > 0 {
+				emitted = append(emitted, <-recorder.Events)
+			}
+			if tt.wantEvent != "" && !hasEvent(emitted, tt.wantEvent) {
+				t.Errorf("events = %v, want one with reason %s", emitted, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestLonghornNodeUnschedulableReason(t *testing.T) {
+	v1beta1 := makeLonghornNode("node-1", true, "")
+	v1beta1.Object["status"] = map[string]interface{}{
+		"conditions": map[string]interface{}{
+			"Ready":       map[string]interface{}{"type": "Ready", "status": "True"},
+			"Schedulable": map[string]interface{}{"type": "Schedulable", "status": "False", "reason": "KubernetesNodeCordoned"},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		node              *unstructured.Unstructured
+		wantUnschedulable bool
+	}{
+		{name: "healthy", node: makeLonghornNode("node-1", true, "True")},
+		{name: "no status", node: makeLonghornNode("node-1", true, "")},
+		{name: "allowScheduling false", node: makeLonghornNode("node-1", false, "True"), wantUnschedulable: true},
+		{name: "not Ready", node: makeLonghornNode("node-1", true, "False"), wantUnschedulable: true},
+		{name: "v1beta1 conditions, not Schedulable", node: v1beta1, wantUnschedulable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := longhornNodeUnschedulableReason(tt.node)
+			if (reason != "") != tt.wantUnschedulable {
+				t.Errorf("longhornNodeUnschedulableReason() = %q, want unschedulable %v", reason, tt.wantUnschedulable)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package longhorn_cosched
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// metricsSubsystem prefixes the plugin's metrics.
+const metricsSubsystem = "longhorn_cosched"
+
+// shareManagerNodeFallbacks counts the scheduling cycles in which the hard
+// filter was relaxed because the share-manager node could not take the pod.
+// The reason label is the reason of the event emitted on the pod.
+var shareManagerNodeFallbacks = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "share_manager_node_fallbacks_total",
+		Help:           "Number of scheduling cycles in which a hard-mode pod was not pinned to its share-manager node, by reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"reason"},
+)
+
+// pvcLookupErrors counts the failed PVC reads, other than NotFound, during
+// share-manager lookups. The reason label is the API status reason.
+var pvcLookupErrors = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "pvc_lookup_errors_total",
+		Help:           "Number of PVC reads that failed, other than with NotFound, while looking up share-managers, by reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"reason"},
+)
+
+// crdLookupErrors counts the failed ShareManager CRD reads, other than
+// NotFound, during share-manager lookups. The reason label is the API status
+// reason.
+var crdLookupErrors = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "crd_lookup_errors_total",
+		Help:           "Number of ShareManager CRD reads that failed, other than with NotFound, while looking up share-managers, by reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"reason"},
+)
+
+// crdParseErrors counts the ShareManagers read whose status had an
+// unexpected structure. The field label is the status field that could not be
+// parsed.
+var crdParseErrors = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "crd_parse_errors_total",
+		Help:           "Number of ShareManagers read whose status could not be parsed, by field.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"field"},
+)
+
+// strictLookupErrors counts the scheduling cycles of hard-mode pods failed by
+// the strictErrors arg. The source label is "pvc" or the lookup source that
+// failed; the reason label is the API status reason.
+var strictLookupErrors = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "strict_lookup_errors_total",
+		Help:           "Number of scheduling cycles of hard-mode pods failed by a lookup error under strictErrors, by source and reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"source", "reason"},
+)
+
+// sourceDisagreements counts the lookups in which the ShareManager CRD and
+// the share-manager pod named different nodes. The policy label is the
+// sourceDisagreementPolicy arg; the chosen label is the source that won.
+var sourceDisagreements = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "source_disagreements_total",
+		Help:           "Number of share-manager lookups in which the ShareManager CRD and the share-manager pod named different nodes, by policy and chosen source.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"policy", "chosen"},
+)
+
+// forbiddenLookups counts the lookups that failed with Forbidden. The path
+// label is "pvc" or the lookup source.
+var forbiddenLookups = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "forbidden_lookups_total",
+		Help:           "Number of share-manager lookups that failed with Forbidden, by path.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"path"},
+)
+
+// forbiddenPathsDisabled counts the times a lookup path was skipped for
+// forbiddenCooldown after forbiddenThreshold consecutive Forbidden errors.
+var forbiddenPathsDisabled = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "forbidden_paths_disabled_total",
+		Help:           "Number of times a lookup path was disabled after repeated Forbidden errors, by path.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"path"},
+)
+
+// colocatedBinds counts the opted-in pods bound to a node hosting one of
+// their share-managers. The mode label is the pod's mode.
+var colocatedBinds = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "colocated_total",
+		Help:           "Number of opted-in pods bound to a node hosting one of their share-managers, by mode.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"mode"},
+)
+
+// divergentBinds counts the opted-in pods bound to a node hosting none of
+// their share-managers. The mode label is the pod's mode; the reason label
+// is no-sm-found, fallback-triggered, soft-outvoted, observe-only or
+// not-pinned.
+var divergentBinds = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "divergent_total",
+		Help:           "Number of opted-in pods bound to a node hosting none of their share-managers, by mode and reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"mode", "reason"},
+)
+
+// shareManagerFollows counts the share-managers of annotated PVCs whose
+// only consumer was bound to another node under the ShareManagerFollowsVM
+// arg. The result label is relocated (the share-manager pod was deleted),
+// shared (another pod mounts the PVC), cooldown or error.
+var shareManagerFollows = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "share_manager_follows_total",
+		Help:           "Number of share-managers asked, or not, to follow the only pod mounting their PVC to its node, by result.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"result"},
+)
+
+// decisionRecordErrors counts the CoScheduleDecision records that could not
+// be written under the DecisionRecords arg, by operation: create, update,
+// prune (listing or deleting records beyond the per-namespace limit), or
+// dropped (the write queue was full).
+var decisionRecordErrors = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "decision_record_errors_total",
+		Help:           "Number of CoScheduleDecision records that could not be written or pruned, by operation.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"operation"},
+)
+
+// driftPods is the number of running opted-in virt-launcher pods on a node
+// hosting one of their share-managers (state colocated) or none of them
+// (state divergent), as of the last drift check. It is only set by the
+// scheduler instance running the check (see Args.DriftReconcileInterval).
+var driftPods = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "drift_pods",
+		Help:           "Number of running opted-in virt-launcher pods on a node hosting one of their share-managers or none of them, by state.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"state"},
+)
+
+// driftNamespacePods is driftPods by namespace.
+var driftNamespacePods = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "drift_namespace_pods",
+		Help:           "Number of running opted-in virt-launcher pods on a node hosting one of their share-managers or none of them, by namespace and state.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"namespace", "state"},
+)
+
+// pinnedPendingPods is the number of opted-in pods whose latest scheduling
+// cycle left them unschedulable while pinned to their share-manager node,
+// and that are still unbound. It is not labelled by pod.
+var pinnedPendingPods = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Subsystem:      metricsSubsystem,
+		Name:           "pinned_pending_pods",
+		Help:           "Number of opted-in pods pending because no node fits while they are pinned to their share-manager node.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+// The decision and lookup metrics follow the scheduler_plugin_ naming of the
+// scheduler's own plugin metrics, so they are found next to them.
+const (
+	decisionMetricsNamespace = "scheduler"
+	decisionMetricsSubsystem = "plugin_longhorn_cosched"
+)
+
+// Values of the result label of filterResults.
+const (
+	filterResultAccepted   = "accepted"
+	filterResultRejected   = "rejected"
+	filterResultSkipped    = "skipped"
+	filterResultNotOptedIn = "not_opted_in"
+	filterResultObserved   = "observed"
+	filterResultError      = "error"
+)
+
+// Values of the result label of scoreResults.
+const (
+	scoreResultMax     = "max"
+	scoreResultPartial = "partial"
+	scoreResultZero    = "zero"
+	scoreResultError   = "error"
+)
+
+// Values of the source label of lookupResults other than a lookup source.
+const (
+	lookupResultMigratable       = "migratable"
+	lookupResultVolumeAttachment = "volumeAttachment"
+	lookupResultConsumer         = "consumer"
+	lookupResultNone             = "none"
+	lookupResultError            = "error"
+)
+
+// Values of the class label of lookupErrors.
+const (
+	lookupErrorNotFound  = "notFound"
+	lookupErrorForbidden = "forbidden"
+	lookupErrorTimeout   = "timeout"
+	lookupErrorParse     = "parse"
+	lookupErrorOther     = "other"
+)
+
+// filterResults counts the Filter calls for pods other than hotplug
+// attachment pods. Pods that are not opted in are counted once per cycle at
+// PreFilter, which makes the scheduler skip Filter for them; so are skipped
+// migration targets and pods on a single-node cluster. The result label
+// is accepted (the node is the share-manager node), rejected, skipped (every
+// node passes, e.g. soft mode or no share-manager found), not_opted_in,
+// observed (observe mode, every node passes) or error. The outcome label is
+// the Outcome of the cycle's Decision.
+var filterResults = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      decisionMetricsNamespace,
+		Subsystem:      decisionMetricsSubsystem,
+		Name:           "filter_results_total",
+		Help:           "Number of LonghornCoSchedule Filter decisions, by result and cycle outcome.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"result", "outcome"},
+)
+
+// scoreResults counts the Score calls for pods other than hotplug attachment
+// pods. The result label is max, partial or zero by the node's score, or
+// error; the outcome label is the Outcome of the cycle's Decision.
+var scoreResults = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      decisionMetricsNamespace,
+		Subsystem:      decisionMetricsSubsystem,
+		Name:           "score_results_total",
+		Help:           "Number of LonghornCoSchedule Score decisions, by result and cycle outcome.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"result", "outcome"},
+)
+
+// lookupResults counts the share-manager lookups of Longhorn RWX PVCs. The
+// source label is the lookup source that named the node, migratable,
+// volumeAttachment or consumer for the fallbacks, none if nothing did, or
+// error.
+var lookupResults = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      decisionMetricsNamespace,
+		Subsystem:      decisionMetricsSubsystem,
+		Name:           "lookup_results_total",
+		Help:           "Number of share-manager lookups of Longhorn RWX PVCs, by the source that found the node.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"source"},
+)
+
+// lookupErrors counts the failed reads of the share-manager lookup. The
+// source label is "pvc" or the lookup source that failed; the class label is
+// notFound, forbidden, timeout, parse or other. Together with lookupResults
+// it tells how often a source fails and the next one has to answer.
+var lookupErrors = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      decisionMetricsNamespace,
+		Subsystem:      decisionMetricsSubsystem,
+		Name:           "lookup_errors_total",
+		Help:           "Number of failed reads while looking up share-managers, by source and error class.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"source", "class"},
+)
+
+// lookupDuration observes how long the share-manager lookup for all of a
+// pod's PVCs took.
+var lookupDuration = metrics.NewHistogram(
+	&metrics.HistogramOpts{
+		Namespace:      decisionMetricsNamespace,
+		Subsystem:      decisionMetricsSubsystem,
+		Name:           "lookup_duration_seconds",
+		Help:           "Duration of the share-manager lookup of a pod's PVCs, in seconds.",
+		Buckets:        metrics.ExponentialBuckets(0.001, 2, 12),
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+// crdPodFallbacks counts the share-manager lookups that found the node from
+// the share-manager pod after reading the ShareManager CRD failed (rather
+// than finding no ShareManager), by the class of the CRD error: forbidden,
+// timeout, parse or other. Scheduling still works, but a steady rate means
+// the CRD or the scheduler's RBAC for it is broken.
+var crdPodFallbacks = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      decisionMetricsNamespace,
+		Subsystem:      decisionMetricsSubsystem,
+		Name:           "crd_pod_fallbacks_total",
+		Help:           "Number of share-manager lookups answered by the share-manager pod after the ShareManager CRD read failed, by error class.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"class"},
+)
+
+// Values of the extension_point label of extensionPointDuration, named like
+// the scheduler's own extension_point labels.
+const (
+	extensionPointPreEnqueue = "PreEnqueue"
+	extensionPointPreFilter  = "PreFilter"
+	extensionPointFilter     = "Filter"
+	extensionPointPostFilter = "PostFilter"
+	extensionPointScore      = "Score"
+	extensionPointPostBind   = "PostBind"
+)
+
+// extensionPointDuration observes how long each of the plugin's extension
+// points took, by extension point and the code of the status it returned
+// (Success for PostBind, which returns none). Unlike
+// scheduler_plugin_execution_duration_seconds, every call is observed. The
+// share-manager lookup PreFilter (or, without a PreFilter result, Filter
+// and Score) makes is included; lookupDuration observes it on its own. The
+// buckets run from 100µs to 1.6s.
+var extensionPointDuration = metrics.NewHistogramVec(
+	&metrics.HistogramOpts{
+		Namespace:      decisionMetricsNamespace,
+		Subsystem:      decisionMetricsSubsystem,
+		Name:           "extension_point_duration_seconds",
+		Help:           "Duration of LonghornCoSchedule extension points, in seconds, by extension point and status code.",
+		Buckets:        metrics.ExponentialBuckets(0.0001, 2, 15),
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"extension_point", "outcome"},
+)
+
+// observeExtensionPoint observes in extensionPointDuration the time since
+// start of the given extension point, which returned status.
+func observeExtensionPoint(point string, start time.Time, status *framework.Status) {
+	extensionPointDuration.WithLabelValues(point, status.Code().String()).Observe(time.Since(start).Seconds())
+}
+
+var registerMetricsOnce sync.Once
+
+// registerMetrics registers the plugin's metrics with the scheduler's legacy
+// registry, which the scheduler serves on /metrics.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(
+			shareManagerNodeFallbacks, pvcLookupErrors, crdLookupErrors, crdParseErrors, strictLookupErrors, sourceDisagreements, forbiddenLookups, forbiddenPathsDisabled, colocatedBinds, divergentBinds, shareManagerFollows, decisionRecordErrors, driftPods, driftNamespacePods, pinnedPendingPods,
+			filterResults, scoreResults, lookupResults, lookupErrors, lookupDuration, crdPodFallbacks, extensionPointDuration)
+	})
+}
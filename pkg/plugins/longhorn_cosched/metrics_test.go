@@ -0,0 +1,334 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// gatherCounter returns the values of a counter in the scheduler's legacy
+// registry with the labels in lvMap, by label. A counter vector is only
+// gathered once a value was counted; until then the map is empty.
+func gatherCounter(name string, lvMap map[string]string, label string) map[string]float64 {
+	values, err := testutil.GetCounterValuesFromGatherer(legacyregistry.DefaultGatherer, name, lvMap, label)
+	if err != nil {
+		return map[string]float64{}
+	}
+	return values
+}
+
+// checkCounterDelta checks that each value of a counter in the registry went
+// up from before by the count in want, or not at all if it is not in want.
+func checkCounterDelta(t *testing.T, name string, lvMap map[string]string, label string, before, want map[string]float64) {
+	t.Helper()
+	after := gatherCounter(name, lvMap, label)
+	for value, count := range after {
+		if got := count - before[value]; got != want[value] {
+			t.Errorf("%s %v{%s=%q} went up by %v, want %v", name, lvMap, label, value, got, want[value])
+		}
+	}
+	for value, count := range want {
+		if _, ok := after[value]; !ok {
+			t.Errorf("%s %v{%s=%q} not found, want %v", name, lvMap, label, value, count)
+		}
+	}
+}
+
+// gatherLookups returns the number of lookup durations observed.
+func gatherLookups(t *testing.T) uint64 {
+	t.Helper()
+	vec, err := testutil.GetHistogramVecFromGatherer(legacyregistry.DefaultGatherer, "scheduler_plugin_longhorn_cosched_lookup_duration_seconds", map[string]string{})
+	if err != nil {
+		t.Fatalf("gathering lookup durations: %v", err)
+	}
+	return vec.GetAggregatedSampleCount()
+}
+
+// TestDecisionMetrics checks that Filter, Score and the share-manager lookup
+// count their outcomes in the metrics served on /metrics, Filter and Score
+// under the outcome of the cycle's Decision.
+func TestDecisionMetrics(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	registerMetrics()
+
+	tests := []struct {
+		name         string
+		pod          *corev1.Pod
+		shareManager bool
+		wantOutcome  string
+		wantFilter   map[string]float64
+		wantScore    map[string]float64
+		wantLookup   map[string]float64
+		wantLookups  uint64
+	}{
+		{
+			name:         "share-manager found",
+			pod:          makeVM("vm", vmNamespace, true, pvcName),
+			shareManager: true,
+			wantOutcome:  decisionPinned,
+			wantFilter:   map[string]float64{filterResultAccepted: 1, filterResultRejected: 1},
+			wantScore:    map[string]float64{scoreResultMax: 1, scoreResultZero: 1},
+			wantLookup:   map[string]float64{string(LookupSourcePod): 1},
+			wantLookups:  1,
+		},
+		{
+			name:        "no share-manager",
+			pod:         makeVM("vm", vmNamespace, true, pvcName),
+			wantOutcome: decisionNoShareManager,
+			wantFilter:  map[string]float64{filterResultSkipped: 2},
+			wantScore:   map[string]float64{scoreResultZero: 2},
+			wantLookup:  map[string]float64{lookupResultNone: 1},
+			wantLookups: 1,
+		},
+		{
+			name:         "not opted in",
+			pod:          makeVM("vm", vmNamespace, false, pvcName),
+			shareManager: true,
+			wantOutcome:  decisionNoShareManager,
+			wantFilter:   map[string]float64{filterResultNotOptedIn: 1},
+			wantScore:    map[string]float64{scoreResultZero: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{tt.pod, makePVC(pvcName, vmNamespace, pvName)}
+			if tt.shareManager {
+				objects = append(objects, makeShareManagerPod(pvName, "node-1"))
+			}
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects: objects,
+			})
+
+			outcome := map[string]string{"outcome": tt.wantOutcome}
+			filterBefore := gatherCounter("scheduler_plugin_longhorn_cosched_filter_results_total", outcome, "result")
+			scoreBefore := gatherCounter("scheduler_plugin_longhorn_cosched_score_results_total", outcome, "result")
+			lookupBefore := gatherCounter("scheduler_plugin_longhorn_cosched_lookup_results_total", nil, "source")
+			lookupsBefore := gatherLookups(t)
+
+			state, _ := runFilters(t, fwk, tt.pod)
+			for _, node := range []string{"node-1", "node-2"} {
+				if _, status := plugin.Score(context.Background(), state, tt.pod, node); !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+			}
+
+			checkCounterDelta(t, "scheduler_plugin_longhorn_cosched_filter_results_total", outcome, "result", filterBefore, tt.wantFilter)
+			checkCounterDelta(t, "scheduler_plugin_longhorn_cosched_score_results_total", outcome, "result", scoreBefore, tt.wantScore)
+			checkCounterDelta(t, "scheduler_plugin_longhorn_cosched_lookup_results_total", nil, "source", lookupBefore, tt.wantLookup)
+			if got := gatherLookups(t) - lookupsBefore; got != tt.wantLookups {
+				t.Errorf("%d lookup durations observed, want %d", got, tt.wantLookups)
+			}
+		})
+	}
+}
+
+// TestLookupMetrics checks that each share-manager lookup is counted by the
+// source that answered it, and each failed read by source and error class.
+func TestLookupMetrics(t *testing.T) {
+	const (
+		pvcName = "shared"
+		pvName  = "pvc-3f6b8a1e-5c2d-4e7f-9a0b-1c2d3e4f5a6b"
+	)
+	registerMetrics()
+	sources := []string{forbiddenPathPVC, string(LookupSourceShareManager), string(LookupSourcePod), string(LookupSourceVolume), string(LookupSourceEndpoints)}
+
+	malformed := makeShareManagerCR(pvName, "node-1", "running")
+	malformed.Object["status"] = []interface{}{"node-1"}
+
+	tests := []struct {
+		name         string
+		noPVC        bool
+		shareManager *unstructured.Unstructured
+		volume       bool
+		smPod        bool
+		order        []LookupSource
+		// resource and err inject an error into the reads of the resource.
+		resource   string
+		err        error
+		wantResult map[string]float64
+		wantErrors map[string]map[string]float64
+	}{
+		{
+			name:         "CRD answers",
+			shareManager: makeShareManagerCR(pvName, "node-1", "running"),
+			smPod:        true,
+			wantResult:   map[string]float64{string(LookupSourceShareManager): 1},
+		},
+		{
+			name:       "CRD NotFound, pod answers",
+			smPod:      true,
+			wantResult: map[string]float64{string(LookupSourcePod): 1},
+			wantErrors: map[string]map[string]float64{string(LookupSourceShareManager): {lookupErrorNotFound: 1}},
+		},
+		{
+			name:       "CRD Forbidden, pod answers",
+			smPod:      true,
+			resource:   "sharemanagers",
+			err:        apierrors.NewForbidden(schema.GroupResource{Resource: "sharemanagers"}, pvName, errors.New("RBAC: access denied")),
+			wantResult: map[string]float64{string(LookupSourcePod): 1},
+			wantErrors: map[string]map[string]float64{string(LookupSourceShareManager): {lookupErrorForbidden: 1}},
+		},
+		{
+			name:       "CRD timeout, pod answers",
+			smPod:      true,
+			resource:   "sharemanagers",
+			err:        apierrors.NewTimeoutError("request timed out", 1),
+			wantResult: map[string]float64{string(LookupSourcePod): 1},
+			wantErrors: map[string]map[string]float64{string(LookupSourceShareManager): {lookupErrorTimeout: 1}},
+		},
+		{
+			name:         "CRD malformed, pod answers",
+			shareManager: malformed,
+			smPod:        true,
+			wantResult:   map[string]float64{string(LookupSourcePod): 1},
+			wantErrors:   map[string]map[string]float64{string(LookupSourceShareManager): {lookupErrorParse: 1}},
+		},
+		{
+			name:       "volume answers",
+			volume:     true,
+			order:      []LookupSource{LookupSourceVolume},
+			wantResult: map[string]float64{string(LookupSourceVolume): 1},
+		},
+		{
+			name:       "pod listing timeout",
+			order:      []LookupSource{LookupSourcePod},
+			resource:   "pods",
+			err:        apierrors.NewTimeoutError("request timed out", 1),
+			wantResult: map[string]float64{lookupResultNone: 1},
+			wantErrors: map[string]map[string]float64{string(LookupSourcePod): {lookupErrorTimeout: 1}},
+		},
+		{
+			name:       "nothing answers",
+			wantResult: map[string]float64{lookupResultNone: 1},
+			wantErrors: map[string]map[string]float64{
+				string(LookupSourceShareManager): {lookupErrorNotFound: 1},
+				string(LookupSourceVolume):       {lookupErrorNotFound: 1},
+			},
+		},
+		{
+			name:       "PVC NotFound",
+			noPVC:      true,
+			wantErrors: map[string]map[string]float64{forbiddenPathPVC: {lookupErrorNotFound: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if !tt.noPVC {
+				objects = append(objects, makePVC(pvcName, "default", pvName))
+			}
+			if tt.smPod {
+				objects = append(objects, makeShareManagerPod(pvName, "node-2"))
+			}
+			var dynObjects []runtime.Object
+			if tt.shareManager != nil {
+				dynObjects = append(dynObjects, tt.shareManager)
+			}
+			if tt.volume {
+				dynObjects = append(dynObjects, loadFixture(t, "volume-v1.json"))
+			}
+			clientset := fake.NewSimpleClientset(objects...)
+			dynClient := newDynamicClient(dynObjects...)
+			if tt.err != nil {
+				inject := func(k8stesting.Action) (bool, runtime.Object, error) { return true, nil, tt.err }
+				if tt.resource == "sharemanagers" {
+					dynClient.PrependReactor("get", tt.resource, inject)
+				} else {
+					clientset.PrependReactor("list", tt.resource, inject)
+				}
+			}
+
+			resultsBefore := gatherCounter("scheduler_plugin_longhorn_cosched_lookup_results_total", nil, "source")
+			errorsBefore := map[string]map[string]float64{}
+			for _, source := range sources {
+				errorsBefore[source] = gatherCounter("scheduler_plugin_longhorn_cosched_lookup_errors_total", map[string]string{"source": source}, "class")
+			}
+
+			_, _ = getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", pvcName, lookupOptions{order: tt.order})
+
+			checkCounterDelta(t, "scheduler_plugin_longhorn_cosched_lookup_results_total", nil, "source", resultsBefore, tt.wantResult)
+			for _, source := range sources {
+				checkCounterDelta(t, "scheduler_plugin_longhorn_cosched_lookup_errors_total", map[string]string{"source": source}, "class", errorsBefore[source], tt.wantErrors[source])
+			}
+		})
+	}
+}
+
+// gatherExtensionPoint returns the number of durations observed for the
+// extension point with the given outcome, or 0 if there are none yet.
+func gatherExtensionPoint(point, outcome string) uint64 {
+	vec, err := testutil.GetHistogramVecFromGatherer(legacyregistry.DefaultGatherer, "scheduler_plugin_longhorn_cosched_extension_point_duration_seconds",
+		map[string]string{"extension_point": point, "outcome": outcome})
+	if err != nil {
+		return 0
+	}
+	return vec.GetAggregatedSampleCount()
+}
+
+// TestExtensionPointMetrics checks that each extension point call is timed
+// under its own extension point and the code of the status it returned.
+func TestExtensionPointMetrics(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	registerMetrics()
+
+	pod := makeVM("vm", vmNamespace, true, pvcName)
+	other := makeVM("other", vmNamespace, false, pvcName)
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{pod, other, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+	})
+
+	type key struct{ point, outcome string }
+	want := map[key]uint64{
+		{extensionPointPreEnqueue, "Success"}:   1,
+		{extensionPointPreFilter, "Success"}:    1,
+		{extensionPointPreFilter, "Skip"}:       1,
+		{extensionPointFilter, "Success"}:       1,
+		{extensionPointFilter, "Unschedulable"}: 1,
+		{extensionPointScore, "Success"}:        2,
+		{extensionPointPostBind, "Success"}:     1,
+	}
+	before := map[key]uint64{}
+	for k := range want {
+		before[k] = gatherExtensionPoint(k.point, k.outcome)
+	}
+
+	if status := plugin.PreEnqueue(context.Background(), pod); !status.IsSuccess() {
+		t.Fatalf("PreEnqueue() = %v", status)
+	}
+	state, _ := runFilters(t, fwk, pod)
+	for _, node := range []string{"node-1", "node-2"} {
+		if _, status := plugin.Score(context.Background(), state, pod, node); !status.IsSuccess() {
+			t.Fatalf("Score(%s) = %v", node, status)
+		}
+	}
+	plugin.PostBind(context.Background(), state, pod, "node-1")
+	runFilters(t, fwk, other)
+
+	for k, count := range want {
+		if got := gatherExtensionPoint(k.point, k.outcome) - before[k]; got != count {
+			t.Errorf("%s{outcome=%q}: %d durations observed, want %d", k.point, k.outcome, got, count)
+		}
+	}
+}
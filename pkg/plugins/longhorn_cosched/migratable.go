@@ -0,0 +1,120 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"slices"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// longhornVolumeResource is the resource of Longhorn's Volume CRD, served
+	// in the same group and version as ShareManagers.
+	longhornVolumeResource = "volumes"
+
+	// migratableAttribute is the Longhorn StorageClass parameter, copied to
+	// the PV's CSI volume attributes, that makes a block volume migratable:
+	// it is attached to two nodes during a KubeVirt live migration.
+	migratableAttribute = "migratable"
+)
+
+// isMigratableBlockVolume returns true if pv is a Longhorn migratable block
+// volume. Such RWX volumes are attached directly to every node using them and
+// have no share-manager.
+func isMigratableBlockVolume(pv *corev1.PersistentVolume) bool {
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != LonghornDriver {
+		return false
+	}
+	if pv.Spec.VolumeMode == nil || *pv.Spec.VolumeMode != corev1.PersistentVolumeBlock {
+		return false
+	}
+	migratable, _ := strconv.ParseBool(pv.Spec.CSI.VolumeAttributes[migratableAttribute])
+	return migratable
+}
+
+// migratableVolume returns the PV bound to pvc if it is a Longhorn migratable
+// block volume, or nil.
+func migratableVolume(ctx context.Context, clientset kubernetes.Interface, pvc *corev1.PersistentVolumeClaim, opts lookupOptions) *corev1.PersistentVolume {
+	if pvc.Spec.VolumeName == "" {
+		return nil
+	}
+	pv, err := getPV(ctx, clientset, pvc.Spec.VolumeName, opts)
+	if err != nil || !isMigratableBlockVolume(pv) {
+		return nil
+	}
+	return pv
+}
+
+// getLonghornVolumeNode returns status.currentNodeID of the named Longhorn
+// Volume: the node it is attached to, or "" if it is detached or cannot be
+// read.
+func getLonghornVolumeNode(ctx context.Context, dynClient dynamic.Interface, opts lookupOptions, volumeName string) string {
+	obj := getLonghornVolume(ctx, dynClient, opts, volumeName)
+	if obj == nil {
+		return ""
+	}
+	node, err := statusString(obj.Object, "currentNodeID")
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: ignoring malformed Longhorn Volume", "volume", volumeName)
+		return ""
+	}
+	return node
+}
+
+// getLonghornVolume returns the named Longhorn Volume, or nil if the CRD is
+// not served, the Volume cannot be read, or its reads are skipped after
+// repeated Forbidden errors.
+func getLonghornVolume(ctx context.Context, dynClient dynamic.Interface, opts lookupOptions, volumeName string) *unstructured.Unstructured {
+	gvr, served := opts.api.resource()
+	if dynClient == nil || !served {
+		return nil
+	}
+	gvr.Resource = longhornVolumeResource
+	path, namespace := string(LookupSourceVolume), opts.longhornNamespace()
+	if !opts.forbidden.allow(path, namespace) {
+		return nil
+	}
+
+	obj, err := dynClient.Resource(gvr).Namespace(namespace).Get(ctx, volumeName, metav1.GetOptions{})
+	opts.observe(path, namespace, err)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.V(4).InfoS("LonghornCoSchedule: reading Longhorn Volume failed", "volume", volumeName, "err", err)
+		}
+		return nil
+	}
+	return obj
+}
+
+// getVolumePlacement returns the node the Longhorn Volume named after the PV
+// is attached to (status.currentNodeID), which for an RWX volume is the node
+// of its share-manager. A detached volume, or one that cannot be read, yields
+// an empty node, and so does one whose share-manager state
+// (status.shareState) is not accepted for its data engine, as with the
+// ShareManager CRD. With requireReady, a volume whose status.state is not
+// "attached" yet is marked notReady.
+func getVolumePlacement(ctx context.Context, dynClient dynamic.Interface, pvName string, opts lookupOptions) shareManagerPlacement {
+	obj := getLonghornVolume(ctx, dynClient, opts, pvName)
+	if obj == nil {
+		return shareManagerPlacement{}
+	}
+	node, err := statusString(obj.Object, "currentNodeID")
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: ignoring malformed Longhorn Volume", "volume", pvName)
+		return shareManagerPlacement{}
+	}
+	state, _ := statusString(obj.Object, "state")
+	klog.V(5).InfoS("LonghornCoSchedule: Longhorn Volume looked up", "volume", pvName, "currentNodeID", node, "state", state)
+	if shareState, _ := statusString(obj.Object, "shareState"); shareState != "" && !slices.Contains(opts.volumeShareManagerStates(obj, pvName), shareState) {
+		klog.V(5).InfoS("LonghornCoSchedule: share-manager state of Longhorn Volume not accepted", "volume", pvName, "shareState", shareState)
+		return shareManagerPlacement{}
+	}
+	return shareManagerPlacement{node: node, notReady: node != "" && opts.requireReady && state != "attached"}
+}
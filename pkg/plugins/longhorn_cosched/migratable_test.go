@@ -0,0 +1,159 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeMigratablePV creates a Longhorn migratable block-mode PV.
+func makeMigratablePV(name string) *corev1.PersistentVolume {
+	pv := makeCSIPV(name, LonghornDriver)
+	block := corev1.PersistentVolumeBlock
+	pv.Spec.VolumeMode = &block
+	pv.Spec.CSI.VolumeAttributes = map[string]string{migratableAttribute: "true"}
+	return pv
+}
+
+// makeLonghornVolumeCR creates an unstructured Longhorn Volume attached to
+// the given node.
+func makeLonghornVolumeCR(name, currentNodeID string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(shareManagerGVR.Group + "/" + shareManagerGVR.Version)
+	obj.SetKind("Volume")
+	obj.SetNamespace(LonghornNamespace)
+	obj.SetName(name)
+	obj.Object["status"] = map[string]interface{}{"currentNodeID": currentNodeID}
+	return obj
+}
+
+func TestIsMigratableBlockVolume(t *testing.T) {
+	filesystem := makeMigratablePV("pv")
+	fs := corev1.PersistentVolumeFilesystem
+	filesystem.Spec.VolumeMode = &fs
+	notMigratable := makeMigratablePV("pv")
+	notMigratable.Spec.CSI.VolumeAttributes = nil
+	otherDriver := makeMigratablePV("pv")
+	otherDriver.Spec.CSI.Driver = cephFSDriver
+
+	tests := []struct {
+		name string
+		pv   *corev1.PersistentVolume
+		want bool
+	}{
+		{name: "migratable block volume", pv: makeMigratablePV("pv"), want: true},
+		{name: "filesystem volume", pv: filesystem},
+		{name: "not migratable", pv: notMigratable},
+		{name: "other driver", pv: otherDriver},
+		{name: "in-tree volume", pv: &corev1.PersistentVolume{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isMigratableBlockVolume(tt.pv); got != tt.want {
+				t.Errorf("isMigratableBlockVolume() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMigratableBlockVolume checks that a hard-mode pod with a migratable
+// block-mode PVC is never pinned, but prefers the node the Longhorn Volume is
+// attached to, and that it does not stop a share-manager of another PVC from
+// pinning the pod.
+func TestMigratableBlockVolume(t *testing.T) {
+	const (
+		vmNamespace  = "default"
+		blockPVC     = "rootdisk"
+		blockPV      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		rwxPVC       = "shared"
+		rwxPV        = "pvc-7d1c0c4e-9b6f-4b8e-a3f4-2f1e8c0b9d11"
+		attachedNode = "node-2"
+	)
+
+	tests := []struct {
+		name      string
+		pvcs      []string
+		objects   []runtime.Object
+		dynClient dynamic.Interface
+		wantPass  map[string]bool
+		wantScore map[string]int64
+	}{
+		{
+			name:      "attached volume — every node passes, attachment node preferred",
+			pvcs:      []string{blockPVC},
+			dynClient: newDynamicClient(makeLonghornVolumeCR(blockPV, attachedNode)),
+			wantPass:  map[string]bool{"node-1": true, attachedNode: true},
+			wantScore: map[string]int64{"node-1": 0, attachedNode: framework.MaxNodeScore},
+		},
+		{
+			name:      "detached volume — plugin is a no-op",
+			pvcs:      []string{blockPVC},
+			dynClient: newDynamicClient(makeLonghornVolumeCR(blockPV, "")),
+			wantPass:  map[string]bool{"node-1": true, attachedNode: true},
+			wantScore: map[string]int64{"node-1": 0, attachedNode: 0},
+		},
+		{
+			name:      "share-manager of another PVC still pins the pod",
+			pvcs:      []string{blockPVC, rwxPVC},
+			objects:   []runtime.Object{makePVC(rwxPVC, vmNamespace, rwxPV), makeShareManagerPod(rwxPV, "node-1")},
+			dynClient: newDynamicClient(makeLonghornVolumeCR(blockPV, attachedNode)),
+			wantPass:  map[string]bool{"node-1": true, attachedNode: false},
+			wantScore: map[string]int64{"node-1": 50, attachedNode: 50},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append([]runtime.Object{
+				makePVC(blockPVC, vmNamespace, blockPV),
+				makeMigratablePV(blockPV),
+				// A stray pod named like a share-manager must not be used.
+				makeShareManagerPod(blockPV, "node-1"),
+			}, tt.objects...)
+			plugin := &Plugin{clientset: fake.NewSimpleClientset(objects...), dynClient: tt.dynClient}
+			pod := makeVM("vm", vmNamespace, true, tt.pvcs...)
+
+			state := framework.NewCycleState()
+			if _, status := plugin.PreFilter(context.Background(), state, pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter() status = %v", status)
+			}
+			for node, want := range tt.wantPass {
+				status := plugin.Filter(context.Background(), state, pod, makeNodeInfo(node))
+				if status.IsSuccess() != want {
+					t.Errorf("Filter(%s) pass = %v, want %v (status: %v)", node, status.IsSuccess(), want, status)
+				}
+			}
+			for node, want := range tt.wantScore {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) status = %v", node, status)
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestPreEnqueueMigratableBlockVolume checks that WaitForShareManager does not
+// gate a pod on a migratable block volume, which never gets a ShareManager.
+func TestPreEnqueueMigratableBlockVolume(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "rootdisk"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	clientset := fake.NewSimpleClientset(makePVC(pvcName, vmNamespace, pvName), makeMigratablePV(pvName))
+	plugin := &Plugin{clientset: clientset, dynClient: newDynamicClient(), args: Args{WaitForShareManager: true}}
+
+	if status := plugin.PreEnqueue(context.Background(), makeWaitingVM("vm", vmNamespace, pvcName)); !status.IsSuccess() {
+		t.Errorf("PreEnqueue() = %v, want success for a migratable block volume", status)
+	}
+}
@@ -0,0 +1,281 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// vmimGVR is the GroupVersionResource of KubeVirt's
+// VirtualMachineInstanceMigration.
+var vmimGVR = schema.GroupVersionResource{
+	Group:    vmiGVR.Group,
+	Version:  vmiGVR.Version,
+	Resource: "virtualmachineinstancemigrations",
+}
+
+// migrationVerificationTTL is how long the outcome of checking a pod's
+// migration label against its VirtualMachineInstanceMigration is reused.
+// Filter and Score ask once per node, so the check must not hit the API
+// each time.
+const migrationVerificationTTL = 30 * time.Second
+
+// isMigrationTargetPod returns true if the plugin must treat the pod as a
+// live-migration target (see isMigrationTarget). With the
+// VerifyMigrationTargets arg, the label must be backed by an in-flight
+// VirtualMachineInstanceMigration of the same UID whose target is this pod;
+// if the migrations cannot be listed, e.g. because KubeVirt's CRDs are not
+// served or readable, the label alone decides.
+func (p *Plugin) isMigrationTargetPod(ctx context.Context, pod *corev1.Pod) bool {
+	if !isMigrationTarget(pod) {
+		return false
+	}
+	if p.migrations == nil || p.dynClient == nil {
+		return true
+	}
+	if target, ok := p.migrations.get(pod.UID); ok {
+		return target
+	}
+	target, verified := p.verifyMigrationTarget(ctx, pod)
+	if !verified {
+		return true
+	}
+	p.migrations.put(pod.UID, target)
+	return target
+}
+
+// verifyMigrationTarget looks up the VirtualMachineInstanceMigration named by
+// the pod's migration label. target is true if it is still in flight, it
+// migrates the pod's VMI, and its target pod, once known, is this pod.
+// verified is false if the migrations cannot be listed.
+func (p *Plugin) verifyMigrationTarget(ctx context.Context, pod *corev1.Pod) (target, verified bool) {
+	uid := types.UID(pod.Labels[MigrationTargetLabel])
+	// The label carries the UID, not the name, so the namespace is listed.
+	list, err := p.dynClient.Resource(vmimGVR).Namespace(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.V(4).InfoS("LonghornCoSchedule: listing VirtualMachineInstanceMigrations failed, trusting the migration label",
+			"pod", klog.KObj(pod),
+			"migrationJobUID", uid,
+			"err", err,
+		)
+		return false, false
+	}
+
+	for i := range list.Items {
+		vmim := &list.Items[i]
+		if vmim.GetUID() != uid {
+			continue
+		}
+		phase, _ := nestedString(vmim.Object, "status", "phase")
+		vmiName, _ := nestedString(vmim.Object, "spec", "vmiName")
+		targetPod, _ := nestedString(vmim.Object, "status", "migrationState", "targetPod")
+		var reason string
+		switch owner := VMIOwner(pod); {
+		case phase == "Succeeded" || phase == "Failed":
+			reason = "migration finished"
+		case owner != nil && vmiName != owner.Name:
+			reason = "migration of another VMI"
+		case targetPod != "" && targetPod != pod.Name:
+			reason = "migration targets another pod"
+		}
+		if reason != "" {
+			klog.V(4).InfoS("LonghornCoSchedule: migration label not confirmed, treating pod as a regular pod",
+				"pod", klog.KObj(pod),
+				"migration", vmim.GetName(),
+				"phase", phase,
+				"reason", reason,
+			)
+			return false, true
+		}
+		return true, true
+	}
+
+	klog.V(4).InfoS("LonghornCoSchedule: no VirtualMachineInstanceMigration matches the migration label, treating pod as a regular pod",
+		"pod", klog.KObj(pod),
+		"migrationJobUID", uid,
+	)
+	return false, true
+}
+
+// validMigrationTargetPolicy returns true if policy is one of the migration
+// target policies.
+func validMigrationTargetPolicy(policy MigrationTargetPolicy) bool {
+	switch policy {
+	case MigrationTargetPolicySkip, MigrationTargetPolicySoft, MigrationTargetPolicyHard:
+		return true
+	}
+	return false
+}
+
+// migrationTargetPolicy returns the migration target policy for the pod: its
+// co-schedule-migration-target annotation if valid, else that of its
+// VirtualMachineInstanceMigration, else the configured one.
+func (p *Plugin) migrationTargetPolicy(ctx context.Context, pod *corev1.Pod) MigrationTargetPolicy {
+	if policy := MigrationTargetPolicy(pod.Annotations[MigrationTargetAnnotationKey]); validMigrationTargetPolicy(policy) {
+		return policy
+	}
+	if policy := p.migrationObjectPolicy(ctx, pod); validMigrationTargetPolicy(policy) {
+		return policy
+	}
+	return p.args.migrationTargetPolicy()
+}
+
+// migrationObjectPolicy returns the co-schedule-migration-target annotation
+// of the VirtualMachineInstanceMigration named by the pod's migration label,
+// e.g. set by the descheduler on the migrations it creates, or "" if there is
+// none or the migrations cannot be listed.
+func (p *Plugin) migrationObjectPolicy(ctx context.Context, pod *corev1.Pod) MigrationTargetPolicy {
+	if p.migrationPolicies == nil || p.dynClient == nil {
+		return ""
+	}
+	if policy, ok := p.migrationPolicies.get(pod.UID); ok {
+		return policy
+	}
+	uid := types.UID(pod.Labels[MigrationTargetLabel])
+	list, err := p.dynClient.Resource(vmimGVR).Namespace(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.V(4).InfoS("LonghornCoSchedule: listing VirtualMachineInstanceMigrations failed, using the configured migration target policy",
+			"pod", klog.KObj(pod),
+			"err", err,
+		)
+		return ""
+	}
+	var policy MigrationTargetPolicy
+	for i := range list.Items {
+		if list.Items[i].GetUID() == uid {
+			policy = MigrationTargetPolicy(list.Items[i].GetAnnotations()[MigrationTargetAnnotationKey])
+			break
+		}
+	}
+	p.migrationPolicies.put(pod.UID, policy)
+	return policy
+}
+
+// schedulingMode returns the mode the plugin applies to the pod: its
+// co-scheduling mode (see mode), limited for live-migration targets by the
+// migration target policy. Under MigrationTargetPolicySkip, the default,
+// migration targets are not co-scheduled.
+func (p *Plugin) schedulingMode(ctx context.Context, pod *corev1.Pod) Mode {
+	return p.migrationMode(ctx, pod, p.mode(ctx, pod))
+}
+
+// migrationMode limits the pod's co-scheduling mode by the migration target
+// policy if the pod is a live-migration target. ModeObserve is only limited
+// by MigrationTargetPolicySkip.
+func (p *Plugin) migrationMode(ctx context.Context, pod *corev1.Pod, mode Mode) Mode {
+	if mode == "" || !p.isMigrationTargetPod(ctx, pod) {
+		return mode
+	}
+	switch p.migrationTargetPolicy(ctx, pod) {
+	case MigrationTargetPolicyHard:
+		return mode
+	case MigrationTargetPolicySoft:
+		if mode == ModeObserve {
+			return mode
+		}
+		return ModeSoft
+	default:
+		return ""
+	}
+}
+
+// migrationSourceNode returns the node of the running virt-launcher pod of
+// the VMI the migration target pod belongs to, as found in the scheduler's
+// snapshot, or "" if there is none.
+func (p *Plugin) migrationSourceNode(pod *corev1.Pod) string {
+	owner := VMIOwner(pod)
+	if owner == nil || p.handle == nil {
+		return ""
+	}
+	nodeInfos, err := p.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule: listing nodes for the migration source", "pod", klog.KObj(pod))
+		return ""
+	}
+	for _, ni := range nodeInfos {
+		for _, pi := range ni.Pods {
+			other := pi.Pod
+			if other.UID == pod.UID || other.Namespace != pod.Namespace || other.Status.Phase != corev1.PodRunning {
+				continue
+			}
+			if ref := VMIOwner(other); ref != nil && ref.UID == owner.UID {
+				return other.Spec.NodeName
+			}
+		}
+	}
+	return ""
+}
+
+// excludeMigrationSource keeps a hard-mode migration target from being
+// pinned to its source node, which Filter rejects so the migration is not a
+// no-op. If the share-manager runs on the source node, as it does whenever
+// the VM was co-scheduled, the target is not pinned for this cycle.
+func (p *Plugin) excludeMigrationSource(pod *corev1.Pod, data *stateData) {
+	if data.mode != ModeHard || data.migrationSource == "" || data.shareManagerNode != data.migrationSource {
+		return
+	}
+	klog.V(4).InfoS("LonghornCoSchedule/PreFilter: share-manager node is the migration source, not pinning migration target",
+		"pod", klog.KObj(pod),
+		"shareManagerNode", data.shareManagerNode,
+	)
+	data.fallback = fmt.Sprintf("share-manager node %q is the live-migration source node", data.shareManagerNode)
+	data.fallbackNode = data.shareManagerNode
+	data.shareManagerNode = ""
+}
+
+// scoreMigrationTarget scores a node for a live-migration target the
+// migration target policy co-schedules. Each of the pod's share-managers
+// contributes its full score to its own node and half of it to the other
+// nodes in the same zone (topology.kubernetes.io/zone); the sum is divided
+// by the number of share-managers, as in Score. The migration source node
+// always scores 0, so a share-manager there only benefits its zone.
+func (p *Plugin) scoreMigrationTarget(pod *corev1.Pod, data *stateData, nodeName string) int64 {
+	podKey := klog.KObj(pod)
+	if nodeName == data.migrationSource {
+		klog.V(4).InfoS("LonghornCoSchedule/Score: migration source node, scoring 0", "pod", podKey, "node", nodeName)
+		return 0
+	}
+	if len(data.placements) == 0 || data.unresolvable {
+		klog.V(4).InfoS("LonghornCoSchedule/Score: no share-manager found, scoring 0", "pod", podKey, "node", nodeName)
+		return 0
+	}
+
+	zone := p.nodeZone(nodeName)
+	var sum int64
+	for _, pl := range data.placements {
+		switch {
+		case pl.node == nodeName:
+			sum += placementScore(pl)
+		case zone != "" && p.nodeZone(pl.node) == zone:
+			sum += placementScore(pl) / 2
+		}
+	}
+	score := sum / int64(len(data.placements))
+	klog.V(4).InfoS("LonghornCoSchedule/Score: scoring migration target by share-manager node and zone",
+		"pod", podKey,
+		"node", nodeName,
+		"zone", zone,
+		"migrationSource", data.migrationSource,
+		"score", score,
+	)
+	return score
+}
+
+// nodeZone returns the zone label of the node in the scheduler's snapshot,
+// or "" if the node is unknown or has none.
+func (p *Plugin) nodeZone(nodeName string) string {
+	if p.handle == nil || nodeName == "" {
+		return ""
+	}
+	ni, err := p.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil || ni.Node() == nil {
+		return ""
+	}
+	return ni.Node().Labels[corev1.LabelTopologyZone]
+}
@@ -0,0 +1,324 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+const (
+	migrationVMI = "my-vm"
+	migrationUID = "08b02237-4ab6-493b-a4e0-c90e5e940a47"
+)
+
+// makeVMIM creates an unstructured VirtualMachineInstanceMigration of
+// migrationVMI in the given phase, targeting targetPod once it is known.
+func makeVMIM(uid types.UID, phase, targetPod string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(vmimGVR.Group + "/" + vmimGVR.Version)
+	obj.SetKind("VirtualMachineInstanceMigration")
+	obj.SetNamespace("default")
+	obj.SetName("migration-" + string(uid))
+	obj.SetUID(uid)
+	obj.Object["spec"] = map[string]interface{}{"vmiName": migrationVMI}
+	status := map[string]interface{}{"phase": phase}
+	if targetPod != "" {
+		status["migrationState"] = map[string]interface{}{"targetPod": targetPod}
+	}
+	obj.Object["status"] = status
+	return obj
+}
+
+// makeMigrationTarget creates an opted-in virt-launcher pod of migrationVMI,
+// labelled as the target of the migration with the given UID if uid is set.
+func makeMigrationTarget(uid string, pvcNames ...string) *corev1.Pod {
+	pod := makeLauncher(migrationVMI, "default", "vmi-uid", pvcNames...)
+	pod.Name = "virt-launcher-my-vm-target"
+	pod.UID = "target-pod-uid"
+	pod.Annotations = map[string]string{AnnotationKey: AnnotationValue}
+	if uid != "" {
+		pod.Labels = map[string]string{MigrationTargetLabel: uid}
+	}
+	return pod
+}
+
+// TestVerifyMigrationTargets checks that, with VerifyMigrationTargets, the
+// plugin only steps aside for a labelled pod whose in-flight migration
+// targets it, and co-schedules any other pod.
+func TestVerifyMigrationTargets(t *testing.T) {
+	const (
+		pvcName = "shared"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	targetName := makeMigrationTarget("").Name
+
+	tests := []struct {
+		name        string
+		verify      bool
+		label       string
+		migrations  []runtime.Object
+		wantSkipped bool
+	}{
+		{
+			name:        "label with VMIM",
+			verify:      true,
+			label:       migrationUID,
+			migrations:  []runtime.Object{makeVMIM(migrationUID, "Scheduling", "")},
+			wantSkipped: true,
+		},
+		{
+			name:        "label with VMIM naming this target pod",
+			verify:      true,
+			label:       migrationUID,
+			migrations:  []runtime.Object{makeVMIM(migrationUID, "TargetReady", targetName)},
+			wantSkipped: true,
+		},
+		{name: "label without VMIM", verify: true, label: migrationUID},
+		{
+			name:       "label with another VMIM",
+			verify:     true,
+			label:      migrationUID,
+			migrations: []runtime.Object{makeVMIM("other-uid", "Scheduling", "")},
+		},
+		{
+			name:       "label with finished VMIM",
+			verify:     true,
+			label:      migrationUID,
+			migrations: []runtime.Object{makeVMIM(migrationUID, "Succeeded", "")},
+		},
+		{
+			name:       "label with VMIM targeting another pod",
+			verify:     true,
+			label:      migrationUID,
+			migrations: []runtime.Object{makeVMIM(migrationUID, "Running", "virt-launcher-my-vm-other")},
+		},
+		{name: "no label", verify: true, migrations: []runtime.Object{makeVMIM(migrationUID, "Scheduling", targetName)}},
+		{name: "label without VMIM, verification off", label: migrationUID, wantSkipped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeMigrationTarget(tt.label, pvcName)
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:      []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects:    []runtime.Object{pod, makePVC(pvcName, "default", pvName), makeShareManagerPod(pvName, "node-1")},
+				dynObjects: tt.migrations,
+				args:       Args{VerifyMigrationTargets: tt.verify},
+			})
+
+			_, m := runFilters(t, fwk, pod)
+			wantRejected := 1
+			if tt.wantSkipped {
+				wantRejected = 0
+			}
+			if m.Len() != wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), wantRejected)
+			}
+		})
+	}
+}
+
+// TestVerifyMigrationTargetsUnreachable checks that the label alone decides
+// when the migrations cannot be listed, and that a confirmed answer is
+// reused.
+func TestVerifyMigrationTargetsUnreachable(t *testing.T) {
+	dynClient := newDynamicClient()
+	dynClient.PrependReactor("list", vmimGVR.Resource, func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(vmimGVR.GroupResource(), "")
+	})
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+	p := &Plugin{dynClient: dynClient, migrations: newExpiringCache[types.UID, bool](fakeClock, migrationVerificationTTL)}
+	ctx := context.Background()
+
+	if !p.isMigrationTargetPod(ctx, makeMigrationTarget(migrationUID)) {
+		t.Error("isMigrationTargetPod() = false with the migrations unreachable, want the label to decide")
+	}
+
+	confirmed := newDynamicClient(makeVMIM(migrationUID, "Scheduling", ""))
+	p.dynClient = confirmed
+	for range 3 {
+		if !p.isMigrationTargetPod(ctx, makeMigrationTarget(migrationUID)) {
+			t.Fatal("isMigrationTargetPod() = false for a confirmed migration target")
+		}
+	}
+	if got := len(confirmed.Actions()); got != 1 {
+		t.Errorf("migrations listed %d times, want 1", got)
+	}
+}
+
+// makeMigrationSource creates the running virt-launcher pod of migrationVMI
+// that a migration target is migrated away from.
+func makeMigrationSource(nodeName string) *corev1.Pod {
+	pod := makeLauncher(migrationVMI, "default", "vmi-uid")
+	pod.UID = "source-pod-uid"
+	pod.Spec.NodeName = nodeName
+	pod.Status.Phase = corev1.PodRunning
+	return pod
+}
+
+// TestCoScheduleMigrationTargets checks each migration target policy, from
+// the args, the migration's annotation and the pod annotation, for a
+// hard-mode migration target whose source runs on node-1.
+func TestCoScheduleMigrationTargets(t *testing.T) {
+	const (
+		pvcName    = "shared"
+		pvName     = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		sourceNode = "node-1"
+	)
+
+	tests := []struct {
+		name         string
+		policy       MigrationTargetPolicy
+		annotation   string
+		vmimPolicy   string
+		smNode       string
+		wantMode     Mode
+		wantRejected []string
+	}{
+		{name: "default skips", smNode: "node-2"},
+		{name: "skip", policy: MigrationTargetPolicySkip, smNode: "node-2"},
+		{name: "soft", policy: MigrationTargetPolicySoft, smNode: "node-2", wantMode: ModeSoft},
+		{name: "hard", policy: MigrationTargetPolicyHard, smNode: "node-2", wantMode: ModeHard, wantRejected: []string{"node-1", "node-3"}},
+		{
+			name:         "hard, share-manager on the source node — only the source rejected",
+			policy:       MigrationTargetPolicyHard,
+			smNode:       sourceNode,
+			wantMode:     ModeHard,
+			wantRejected: []string{sourceNode},
+		},
+		{name: "annotation hard overrides args skip", annotation: "hard", smNode: "node-2", wantMode: ModeHard, wantRejected: []string{"node-1", "node-3"}},
+		{name: "annotation skip overrides args hard", policy: MigrationTargetPolicyHard, annotation: "skip", smNode: "node-2"},
+		{name: "invalid annotation uses args", policy: MigrationTargetPolicySoft, annotation: "always", smNode: "node-2", wantMode: ModeSoft},
+		{name: "migration hard overrides args skip", vmimPolicy: "hard", smNode: "node-2", wantMode: ModeHard, wantRejected: []string{"node-1", "node-3"}},
+		{name: "annotation skip overrides migration hard", annotation: "skip", vmimPolicy: "hard", smNode: "node-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeMigrationTarget(migrationUID, pvcName)
+			if tt.annotation != "" {
+				pod.Annotations[MigrationTargetAnnotationKey] = tt.annotation
+			}
+			vmim := makeVMIM(migrationUID, "Scheduling", "")
+			if tt.vmimPolicy != "" {
+				vmim.SetAnnotations(map[string]string{MigrationTargetAnnotationKey: tt.vmimPolicy})
+			}
+
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+				pods:  []*corev1.Pod{makeMigrationSource(sourceNode)},
+				objects: []runtime.Object{
+					pod,
+					makePVC(pvcName, "default", pvName),
+					makeShareManagerPod(pvName, tt.smNode),
+				},
+				dynObjects: []runtime.Object{vmim},
+				args:       Args{CoScheduleMigrationTargets: tt.policy},
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).mode; got != tt.wantMode {
+				t.Errorf("mode = %q, want %q", got, tt.wantMode)
+			}
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				if m.Get(node).IsSuccess() {
+					t.Errorf("node %s not rejected", node)
+				}
+			}
+		})
+	}
+}
+
+// TestScoreMigrationTargets checks that a co-scheduled migration target
+// prefers the share-manager node and, less, its zone, and that the source
+// node node-1 never gets a bonus.
+func TestScoreMigrationTargets(t *testing.T) {
+	const (
+		pvcName    = "shared"
+		pvName     = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		sourceNode = "node-1"
+	)
+	zones := map[string]string{"node-1": "a", "node-2": "b", "node-3": "b", "node-4": "a"}
+
+	tests := []struct {
+		name       string
+		policy     MigrationTargetPolicy
+		smNode     string
+		wantScores map[string]int64
+	}{
+		{
+			name:       "soft, share-manager on another node",
+			policy:     MigrationTargetPolicySoft,
+			smNode:     "node-2",
+			wantScores: map[string]int64{"node-1": 0, "node-2": framework.MaxNodeScore, "node-3": framework.MaxNodeScore / 2, "node-4": 0},
+		},
+		{
+			name:       "hard, share-manager on another node",
+			policy:     MigrationTargetPolicyHard,
+			smNode:     "node-2",
+			wantScores: map[string]int64{"node-1": 0, "node-2": framework.MaxNodeScore, "node-3": framework.MaxNodeScore / 2, "node-4": 0},
+		},
+		{
+			name:       "soft, share-manager on the source node",
+			policy:     MigrationTargetPolicySoft,
+			smNode:     sourceNode,
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0, "node-4": framework.MaxNodeScore / 2},
+		},
+		{
+			name:       "skip",
+			policy:     MigrationTargetPolicySkip,
+			smNode:     "node-2",
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0, "node-4": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var nodes []*corev1.Node
+			for _, name := range []string{"node-1", "node-2", "node-3", "node-4"} {
+				node := makeNode(name, "4")
+				node.Labels = map[string]string{corev1.LabelTopologyZone: zones[name]}
+				nodes = append(nodes, node)
+			}
+			pod := makeMigrationTarget(migrationUID, pvcName)
+
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes: nodes,
+				pods:  []*corev1.Pod{makeMigrationSource(sourceNode)},
+				objects: []runtime.Object{
+					pod,
+					makePVC(pvcName, "default", pvName),
+					makeShareManagerPod(pvName, tt.smNode),
+				},
+				args: Args{CoScheduleMigrationTargets: tt.policy},
+			})
+
+			state, _ := runFilters(t, fwk, pod)
+			for node, want := range tt.wantScores {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/defaultbinder"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/queuesort"
+)
+
+// TestMultiPoint builds a framework from a profile that enables the plugin
+// only through multiPoint, and checks that it is wired into, and runs at,
+// every extension point it implements.
+func TestMultiPoint(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+		otherNode   = "node-1"
+	)
+
+	pod := withCPU(makeWaitingVM("vm", vmNamespace, pvcName), "1")
+	fwk, _, _ := newTestFrameworkWithPlugins(t, testCluster{
+		nodes:   []*corev1.Node{makeNode(otherNode, "4"), makeNode(targetNode, "4")},
+		objects: []runtime.Object{pod, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, targetNode)},
+	}, &config.Plugins{
+		MultiPoint: config.PluginSet{Enabled: []config.Plugin{
+			{Name: queuesort.Name},
+			{Name: defaultbinder.Name},
+			{Name: Name},
+		}},
+	})
+
+	t.Run("registered at every extension point", func(t *testing.T) {
+		plugins := fwk.ListPlugins()
+		for point, set := range map[string]*config.PluginSet{
+			"PreEnqueue": &plugins.PreEnqueue,
+			"PreFilter":  &plugins.PreFilter,
+			"Filter":     &plugins.Filter,
+			"PostFilter": &plugins.PostFilter,
+			"Score":      &plugins.Score,
+			"PostBind":   &plugins.PostBind,
+		} {
+			var found *config.Plugin
+			for i := range set.Enabled {
+				if set.Enabled[i].Name == Name {
+					found = &set.Enabled[i]
+				}
+			}
+			if found == nil {
+				t.Errorf("%s plugins = %v, want %s", point, set.Enabled, Name)
+				continue
+			}
+			if point == "Score" && found.Weight != 1 {
+				t.Errorf("Score weight = %d, want defaulted to 1", found.Weight)
+			}
+		}
+
+		var hasEnqueueExtensions bool
+		for _, ext := range fwk.EnqueueExtensions() {
+			if pl, ok := ext.(framework.Plugin); ok && pl.Name() == Name {
+				hasEnqueueExtensions = true
+			}
+		}
+		if !hasEnqueueExtensions {
+			t.Errorf("EnqueueExtensions() does not include %s", Name)
+		}
+	})
+
+	t.Run("every extension point runs", func(t *testing.T) {
+		ctx := context.Background()
+
+		for _, pl := range fwk.PreEnqueuePlugins() {
+			if status := pl.PreEnqueue(ctx, pod); !status.IsSuccess() {
+				t.Fatalf("PreEnqueue(%s) = %v, want success for bound PVC", pl.Name(), status)
+			}
+		}
+
+		state := framework.NewCycleState()
+		if _, status, _ := fwk.RunPreFilterPlugins(ctx, state, pod); !status.IsSuccess() {
+			t.Fatalf("RunPreFilterPlugins() = %v", status)
+		}
+		if _, err := state.Read(stateKey); err != nil {
+			t.Errorf("PreFilter did not write its CycleState entry: %v", err)
+		}
+
+		nodeInfos, err := fwk.SnapshotSharedLister().NodeInfos().List()
+		if err != nil {
+			t.Fatalf("listing nodes: %v", err)
+		}
+		m := framework.NewDefaultNodeToStatus()
+		var feasible []*framework.NodeInfo
+		for _, ni := range nodeInfos {
+			status := fwk.RunFilterPlugins(ctx, state, pod, ni)
+			if status.IsSuccess() {
+				feasible = append(feasible, ni)
+				continue
+			}
+			m.Set(ni.Node().Name, status)
+			if status.Plugin() != Name {
+				t.Errorf("Filter on %s rejected by %q, want %q", ni.Node().Name, status.Plugin(), Name)
+			}
+		}
+		if len(feasible) != 1 || feasible[0].Node().Name != targetNode {
+			t.Fatalf("feasible nodes = %d, want only %s", len(feasible), targetNode)
+		}
+
+		scores, status := fwk.RunScorePlugins(ctx, state, pod, nodeInfos)
+		if !status.IsSuccess() {
+			t.Fatalf("RunScorePlugins() = %v", status)
+		}
+		for _, s := range scores {
+			want := int64(0)
+			if s.Name == targetNode {
+				want = framework.MaxNodeScore
+			}
+			if s.TotalScore != want {
+				t.Errorf("score of %s = %d, want %d", s.Name, s.TotalScore, want)
+			}
+		}
+
+		_, status = fwk.RunPostFilterPlugins(ctx, state, pod, m)
+		if status.Plugin() != Name {
+			t.Errorf("RunPostFilterPlugins() rejected by %q, want %q", status.Plugin(), Name)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package longhorn_cosched
+
+import (
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// longhornNamespaceNotAllowedReason is the reason of the event emitted on a
+// pod whose longhorn-namespace annotation names a namespace that is not
+// allowed.
+const longhornNamespaceNotAllowedReason = "LonghornNamespaceNotAllowed"
+
+// podLonghornNamespace returns the namespace the pod's longhorn-namespace
+// annotation names, or "" if it has none. allowed is false if the namespace
+// is neither LonghornNamespace nor listed in AllowedLonghornNamespaces, in
+// which case "" is returned and the default namespace is used.
+func (p *Plugin) podLonghornNamespace(pod *corev1.Pod) (namespace string, allowed bool) {
+	namespace, ok := pod.Annotations[LonghornNamespaceAnnotationKey]
+	if !ok {
+		return "", true
+	}
+	if !p.isLonghornNamespace(namespace) {
+		return "", false
+	}
+	return namespace, true
+}
+
+// isLonghornNamespace returns true if share-managers may be looked up in the
+// namespace.
+func (p *Plugin) isLonghornNamespace(namespace string) bool {
+	return namespace == LonghornNamespace || slices.Contains(p.args.AllowedLonghornNamespaces, namespace)
+}
+
+// reportLonghornNamespace emits a warning event on the pod if its
+// longhorn-namespace annotation names a namespace that is not allowed.
+func (p *Plugin) reportLonghornNamespace(pod *corev1.Pod) {
+	if _, allowed := p.podLonghornNamespace(pod); allowed {
+		return
+	}
+	namespace := pod.Annotations[LonghornNamespaceAnnotationKey]
+	klog.V(2).InfoS("LonghornCoSchedule: Longhorn namespace not allowed, using the default",
+		"pod", klog.KObj(pod),
+		"namespace", namespace,
+		"default", LonghornNamespace,
+	)
+	if p.handle == nil {
+		return
+	}
+	p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, longhornNamespaceNotAllowedReason, "Schedule",
+		"Annotation %s names namespace %q, which is not in the scheduler's allowedLonghornNamespaces; looking up share-managers in %q",
+		LonghornNamespaceAnnotationKey, namespace, LonghornNamespace)
+}
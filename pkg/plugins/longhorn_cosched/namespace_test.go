@@ -0,0 +1,110 @@
+package longhorn_cosched
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// tenantNamespace is a dedicated Longhorn namespace of a tenant.
+const tenantNamespace = "tenant-longhorn"
+
+// TestLonghornNamespaceOverride checks that the longhorn-namespace annotation
+// moves the share-manager lookup to an allowed namespace, and that a namespace
+// that is not allowed is ignored with an event.
+func TestLonghornNamespaceOverride(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		defaultNode = "node-1"
+		tenantNode  = "node-2"
+	)
+
+	tests := []struct {
+		name       string
+		annotation string
+		allowed    []string
+		wantNode   string
+		wantEvent  bool
+	}{
+		{name: "no annotation — default namespace", allowed: []string{tenantNamespace}, wantNode: defaultNode},
+		{name: "allowed namespace", annotation: tenantNamespace, allowed: []string{tenantNamespace}, wantNode: tenantNode},
+		{name: "default namespace named explicitly", annotation: LonghornNamespace, wantNode: defaultNode},
+		{name: "unlisted namespace — rejected", annotation: tenantNamespace, allowed: []string{"other-longhorn"}, wantNode: defaultNode, wantEvent: true},
+		{name: "no allow-list — rejected", annotation: tenantNamespace, wantNode: defaultNode, wantEvent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, true, pvcName)
+			if tt.annotation != "" {
+				pod.Annotations[LonghornNamespaceAnnotationKey] = tt.annotation
+			}
+			tenantSM := makeShareManagerPod(pvName, tenantNode)
+			tenantSM.Namespace = tenantNamespace
+
+			recorder := events.NewFakeRecorder(10)
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode(defaultNode, "4"), makeNode(tenantNode, "4")},
+				objects: []runtime.Object{
+					pod,
+					makePVC(pvcName, vmNamespace, pvName),
+					makeShareManagerPod(pvName, defaultNode),
+					tenantSM,
+				},
+				args:     Args{AllowedLonghornNamespaces: tt.allowed},
+				recorder: recorder,
+			})
+
+			state, _ := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).shareManagerNode; got != tt.wantNode {
+				t.Errorf("shareManagerNode = %q, want %q", got, tt.wantNode)
+			}
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				got = append(got, <-recorder.Events)
+			}
+			if hasEvent(got, longhornNamespaceNotAllowedReason) != tt.wantEvent {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", longhornNamespaceNotAllowedReason, !tt.wantEvent, tt.wantEvent, got)
+			}
+		})
+	}
+}
+
+// TestShareManagerPodAddInAllowedNamespace checks that a share-manager pod
+// created in an allowed Longhorn namespace requeues pods, and one in another
+// namespace does not.
+func TestShareManagerPodAddInAllowedNamespace(t *testing.T) {
+	plugin := &Plugin{
+		args:      Args{AllowedLonghornNamespaces: []string{tenantNamespace}},
+		clientset: fake.NewSimpleClientset(makePVC("data", "default", "pvc-1")),
+	}
+	pod := makeVM("vm", "default", true, "data")
+
+	for namespace, want := range map[string]framework.QueueingHint{
+		LonghornNamespace: framework.Queue,
+		tenantNamespace:   framework.Queue,
+		"default":         framework.QueueSkip,
+	} {
+		added := makeShareManagerPod("pvc-1", "node-1")
+		added.Namespace = namespace
+		got, err := plugin.isSchedulableAfterShareManagerPodChange(klog.Background(), pod, nil, added)
+		if err != nil {
+			t.Fatalf("isSchedulableAfterShareManagerPodChange() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("isSchedulableAfterShareManagerPodChange(%s) = %v, want %v", namespace, got, want)
+		}
+	}
+}
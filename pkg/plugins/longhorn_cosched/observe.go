@@ -0,0 +1,76 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// DecisionAnnotationKey is the annotation PostBind sets on an observe-mode
+// pod once it is bound. Its value is an ObservedDecision in JSON, so the
+// node the plugin would have pinned the pod to can be compared with the node
+// it was bound to.
+const DecisionAnnotationKey = "scheduler.kubevirt-scheduler.io/co-schedule-decision"
+
+// ObservedDecision is the value of DecisionAnnotationKey.
+type ObservedDecision struct {
+	// Outcome is what the plugin would have done: "observed" if it would
+	// have pinned the pod to ShareManagerNode, otherwise why it would not
+	// have, e.g. "no-share-manager", "not-pinned", "unresolvable" or
+	// "waiting".
+	Outcome string `json:"outcome"`
+
+	// ShareManagerNode is the node the pod would have been pinned to.
+	ShareManagerNode string `json:"shareManagerNode,omitempty"`
+
+	// PVCs are the PVCs whose share-managers run on ShareManagerNode.
+	PVCs []string `json:"pvcs,omitempty"`
+
+	// Node is the node the pod was bound to.
+	Node string `json:"node"`
+
+	// Colocated is true if Node hosts one of the pod's share-managers.
+	Colocated bool `json:"colocated"`
+}
+
+// recordObservedDecision sets DecisionAnnotationKey on an observe-mode pod
+// bound to nodeName, from the decision in s. A failed patch is logged; the
+// pod is bound either way.
+func (p *Plugin) recordObservedDecision(ctx context.Context, pod *corev1.Pod, s *stateData, nodeName string, colocated bool) {
+	d := s.decision
+	observed := ObservedDecision{
+		Outcome:          d.Outcome,
+		ShareManagerNode: d.ShareManagerNode,
+		PVCs:             s.pinnedPVCs(),
+		Node:             nodeName,
+		Colocated:        colocated,
+	}
+	klog.V(4).InfoS("LonghornCoSchedule/PostBind: observe mode, recording decision",
+		"pod", klog.KObj(pod),
+		"node", nodeName,
+		"decision", d.Outcome,
+		"shareManagerNode", d.ShareManagerNode,
+		"colocated", colocated,
+	)
+	value, err := json.Marshal(observed)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/PostBind: error encoding observed decision", "pod", klog.KObj(pod))
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{DecisionAnnotationKey: string(value)},
+		},
+	})
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/PostBind: error encoding observed decision", "pod", klog.KObj(pod))
+		return
+	}
+	if _, err := p.clientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/PostBind: error recording observed decision", "pod", klog.KObj(pod))
+	}
+}
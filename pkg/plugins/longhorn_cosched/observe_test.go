@@ -0,0 +1,91 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/names"
+)
+
+// TestObserveMode checks that an observe-mode pod, selected by its
+// annotation or by the observeOnly arg, is never filtered or scored, and
+// that its decision is still resolved, counted and recorded on the pod in
+// PostBind.
+func TestObserveMode(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	registerMetrics()
+
+	tests := []struct {
+		name  string
+		value string
+		args  Args
+	}{
+		{name: "annotation", value: string(ModeObserve)},
+		{name: "observeOnly", value: AnnotationValue, args: Args{ObserveOnly: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The share-manager node is too small for the VM.
+			pod := withCPU(makeVM("vm", vmNamespace, true, pvcName), "2")
+			pod.Annotations[AnnotationKey] = tt.value
+			recorder := events.NewFakeRecorder(10)
+			fwk, plugin, clientset := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{makeNode("node-1", "1"), makeNode("node-2", "4")},
+				objects:  []runtime.Object{pod, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+				args:     tt.args,
+				recorder: recorder,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != 1 || m.Get("node-1").Plugin() != names.NodeResourcesFit {
+				t.Errorf("%d nodes rejected, want only node-1, by %s", m.Len(), names.NodeResourcesFit)
+			}
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			d := data.(*stateData).decision
+			if d.Mode != ModeObserve || d.Outcome != decisionObserved || d.ShareManagerNode != "node-1" {
+				t.Errorf("decision = {mode %q, outcome %q, node %q}, want {%q, %q, %q}", d.Mode, d.Outcome, d.ShareManagerNode, ModeObserve, decisionObserved, "node-1")
+			}
+			for _, node := range []string{"node-1", "node-2"} {
+				if score, status := plugin.Score(context.Background(), state, pod, node); score != 0 || !status.IsSuccess() {
+					t.Errorf("Score(%s) = %d, %v, want 0", node, score, status)
+				}
+			}
+			if len(recorder.Events) != 0 {
+				t.Errorf("%d events emitted, want none", len(recorder.Events))
+			}
+
+			before, _ := testutil.GetCounterMetricValue(divergentBinds.WithLabelValues(string(ModeObserve), divergentObserveOnly))
+			plugin.PostBind(context.Background(), state, pod, "node-2")
+			if after, _ := testutil.GetCounterMetricValue(divergentBinds.WithLabelValues(string(ModeObserve), divergentObserveOnly)); after-before != 1 {
+				t.Errorf("divergent{mode=observe,reason=observe-only} went up by %v, want 1", after-before)
+			}
+
+			got, err := clientset.CoreV1().Pods(vmNamespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting pod: %v", err)
+			}
+			var observed ObservedDecision
+			if err := json.Unmarshal([]byte(got.Annotations[DecisionAnnotationKey]), &observed); err != nil {
+				t.Fatalf("decoding %s annotation %q: %v", DecisionAnnotationKey, got.Annotations[DecisionAnnotationKey], err)
+			}
+			want := ObservedDecision{Outcome: decisionObserved, ShareManagerNode: "node-1", PVCs: []string{pvcName}, Node: "node-2"}
+			if observed.Outcome != want.Outcome || observed.ShareManagerNode != want.ShareManagerNode || observed.Node != want.Node ||
+				observed.Colocated || len(observed.PVCs) != 1 || observed.PVCs[0] != pvcName {
+				t.Errorf("observed decision = %+v, want %+v", observed, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package longhorn_cosched
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// ModeResolver selects the co-scheduling mode of pods outside the scheduler,
+// e.g. in the controllers, the audit and kubectl-coschedule, exactly like the
+// plugin does in PreFilter: the pod's own annotations, its
+// VirtualMachineInstance, its volumes and StorageClasses and its Namespace
+// opt it in, and its priority can change the mode.
+type ModeResolver struct {
+	p *Plugin
+}
+
+// NewModeResolver returns a ModeResolver applying the plugin args obj, as
+// found under pluginConfig in a KubeSchedulerConfiguration. A nil object
+// selects the defaults, under which VirtualMachineInstances are not read.
+// PVCs, PVs, StorageClasses and Namespaces are read through the clientset,
+// VirtualMachineInstances through dynClient.
+func NewModeResolver(clientset kubernetes.Interface, dynClient dynamic.Interface, obj runtime.Object) (*ModeResolver, error) {
+	args, err := decodeArgs(obj)
+	if err != nil {
+		return nil, err
+	}
+	p := &Plugin{clientset: clientset, dynClient: dynClient, args: args, clock: clock.RealClock{}}
+	if args.InheritVMIAnnotation {
+		p.vmis = newExpiringCache[types.UID, vmiAnnotation](p.clock, vmiAnnotationCacheTTL)
+	}
+	return &ModeResolver{p: p}, nil
+}
+
+// Mode returns the co-scheduling mode of the pod, or an empty Mode if it is
+// not co-scheduled.
+func (r *ModeResolver) Mode(ctx context.Context, pod *corev1.Pod) Mode {
+	return r.p.mode(ctx, pod)
+}
+
+// Inherited returns the object whose co-scheduling annotation decides for
+// the pod while it has none of its own, e.g. "Namespace prod-vms": its
+// VirtualMachineInstance, if the InheritVMIAnnotation arg is set, or its
+// Namespace. It returns "" if neither carries a valid value. An annotation
+// set on the pod itself would override theirs, opt-outs included.
+func (r *ModeResolver) Inherited(ctx context.Context, pod *corev1.Pod) string {
+	if _, decided := r.p.vmiMode(ctx, pod); decided {
+		return "VirtualMachineInstance " + VMIOwner(pod).Name
+	}
+	ns, err := r.p.getNamespace(ctx, pod.Namespace)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.V(4).InfoS("LonghornCoSchedule: reading namespace for the co-scheduling default failed", "pod", klog.KObj(pod), "err", err)
+		}
+		return ""
+	}
+	value, set := ns.Annotations[AnnotationKey]
+	if _, valid := ParseMode(value); !set || (!valid && value != NamespaceRequireValue) {
+		return ""
+	}
+	return "Namespace " + ns.Name
+}
+
+// mode returns the co-scheduling mode of the pod, or an empty Mode if it is
+// not co-scheduled. The pod's own annotations decide first (see podMode);
+// only if none of them is set are the broader opt-in mechanisms consulted,
+// in this order:
+//
+//  4. The co-scheduling annotation on the VirtualMachineInstance that owns
+//     the pod, if the InheritVMIAnnotation arg is set. It decides like the
+//     pod's own annotation.
+//  5. The co-scheduling annotation on any of the pod's RWX Longhorn PVCs, or
+//     on the StorageClass of one without its own.
+//  6. The co-scheduling annotation on the pod's Namespace, the default for
+//     every pod in it.
+//
+// The pod's priority can then change the mode of a pod opted in by any of
+// them, its own annotations included (see priorityMode). It never opts a
+// pod in or out. Finally, the ObserveOnly arg turns every mode into
+// ModeObserve.
+func (p *Plugin) mode(ctx context.Context, pod *corev1.Pod) Mode {
+	mode := p.priorityMode(pod, p.optInMode(ctx, pod))
+	if mode != "" && p.args.ObserveOnly {
+		return ModeObserve
+	}
+	return mode
+}
+
+// optInMode returns the mode the opt-in mechanisms listed on mode select for
+// the pod, before its priority is considered.
+func (p *Plugin) optInMode(ctx context.Context, pod *corev1.Pod) Mode {
+	if podDecides(pod) {
+		return podMode(pod)
+	}
+	if mode, decided := p.vmiMode(ctx, pod); decided {
+		return mode
+	}
+	if mode := p.volumeMode(ctx, pod); mode != "" {
+		return mode
+	}
+	return p.namespaceMode(ctx, pod)
+}
+
+// isOptedIn returns true if the pod is co-scheduled in hard or soft mode.
+// Observe-mode pods are not: nothing but the reports may treat them
+// differently from pods that are not opted in.
+func (p *Plugin) isOptedIn(ctx context.Context, pod *corev1.Pod) bool {
+	mode := p.mode(ctx, pod)
+	return mode != "" && mode != ModeObserve
+}
+
+// volumeMode returns the mode selected for the pod's RWX Longhorn PVCs,
+// ModeHard if any of them selects it. A PVC's own co-scheduling annotation
+// decides for it; without one, the annotation on its StorageClass does. PVCs
+// and StorageClasses are read from the informer cache; one that is missing
+// or cannot be read, and an annotation that opts out or is invalid, selects
+// nothing. Volumes cannot opt a pod out.
+func (p *Plugin) volumeMode(ctx context.Context, pod *corev1.Pod) Mode {
+	opts := p.lookupOptions(pod)
+	var mode Mode
+	for _, pvcName := range collectPVCNames(pod) {
+		pvc, err := p.getPVC(ctx, pod.Namespace, pvcName)
+		if err != nil || !IsRWX(pvc) {
+			continue
+		}
+		value, source, set := p.volumeAnnotation(ctx, pvc, opts)
+		if !set {
+			continue
+		}
+		selected, valid := ParseMode(value)
+		if !valid {
+			klog.V(4).InfoS("LonghornCoSchedule: ignoring invalid co-scheduling annotation",
+				"pod", klog.KObj(pod),
+				"pvc", klog.KObj(pvc),
+				"source", source,
+				"value", value,
+			)
+			continue
+		}
+		if selected == "" {
+			continue
+		}
+		if ok, _ := isLonghornVolume(ctx, p.clientset, pvc, opts); !ok {
+			continue
+		}
+		klog.V(5).InfoS("LonghornCoSchedule: pod opted in by volume annotation",
+			"pod", klog.KObj(pod),
+			"pvc", klog.KObj(pvc),
+			"source", source,
+			"mode", selected,
+		)
+		if selected == ModeHard {
+			return ModeHard
+		}
+		mode = selected
+	}
+	return mode
+}
+
+// volumeAnnotation returns the co-scheduling annotation that applies to pvc:
+// its own, or else the one on the StorageClass named by the PVC. source names
+// the object it was read from.
+func (p *Plugin) volumeAnnotation(ctx context.Context, pvc *corev1.PersistentVolumeClaim, opts lookupOptions) (value, source string, set bool) {
+	if value, set = pvc.Annotations[AnnotationKey]; set {
+		return value, "PersistentVolumeClaim", true
+	}
+	scName := pvc.Spec.StorageClassName
+	if scName == nil || *scName == "" {
+		return "", "", false
+	}
+	sc, err := getStorageClass(ctx, p.clientset, *scName, opts)
+	if err != nil {
+		return "", "", false
+	}
+	value, set = sc.Annotations[AnnotationKey]
+	return value, "StorageClass " + sc.Name, set
+}
+
+// namespaceMode returns the mode selected by the co-scheduling annotation on
+// the pod's Namespace, or an empty Mode if it has none, it opts out, or its
+// value is invalid. Besides the values ParseMode accepts,
+// NamespaceRequireValue selects ModeHard. An invalid value is reported on the
+// pod like an invalid annotation of its own. The Namespace is read from the
+// informer cache, so changes to the annotation apply from the next
+// scheduling attempt on; pods already in the unschedulable queue are not
+// requeued for them.
+func (p *Plugin) namespaceMode(ctx context.Context, pod *corev1.Pod) Mode {
+	ns, err := p.getNamespace(ctx, pod.Namespace)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "LonghornCoSchedule: reading namespace for the co-scheduling default", "pod", klog.KObj(pod))
+		}
+		return ""
+	}
+	value, set := ns.Annotations[AnnotationKey]
+	if !set {
+		return ""
+	}
+	if value == NamespaceRequireValue {
+		return ModeHard
+	}
+	mode, valid := ParseMode(value)
+	if !valid {
+		p.reportInvalidNamespaceAnnotation(pod, ns.Name, value)
+		return ""
+	}
+	return mode
+}
+
+// reportInvalidNamespaceAnnotation emits a warning event on the pod, at most
+// once per invalidAnnotationWarningInterval, if the co-scheduling annotation
+// on its Namespace has a value that cannot be parsed.
+func (p *Plugin) reportInvalidNamespaceAnnotation(pod *corev1.Pod, namespace, value string) {
+	klog.V(4).InfoS("LonghornCoSchedule: ignoring invalid co-scheduling annotation on namespace",
+		"pod", klog.KObj(pod),
+		"namespace", namespace,
+		"value", value,
+	)
+	if p.handle == nil || p.warnings == nil || !p.warnings.allow(string(pod.UID)+"/namespace") {
+		return
+	}
+	p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, invalidAnnotationReason, "Schedule",
+		"Annotation %s on namespace %s has invalid value %q and is ignored; use %q, %s", AnnotationKey, namespace, value, NamespaceRequireValue, acceptedModeValues)
+}
+
+// getNamespace returns the named Namespace, reading from the informer cache
+// when a lister is available and falling back to a live GET otherwise.
+func (p *Plugin) getNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	if p.nsLister != nil {
+		return p.nsLister.Get(name)
+	}
+	return p.clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+}
@@ -0,0 +1,358 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/events"
+)
+
+// TestPVCOptIn checks that the co-scheduling annotation on an RWX PVC opts the
+// pod in, and that the pod's own annotation wins when both are set.
+func TestPVCOptIn(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	tests := []struct {
+		name         string
+		podValue     *string
+		pvcValue     *string
+		wantMode     Mode
+		wantRejected int
+	}{
+		{name: "neither"},
+		{name: "PVC only", pvcValue: ptr("true"), wantMode: ModeHard, wantRejected: 1},
+		{name: "PVC only, soft", pvcValue: ptr("soft"), wantMode: ModeSoft},
+		{name: "PVC only, observe", pvcValue: ptr("observe"), wantMode: ModeObserve},
+		{name: "PVC only, false", pvcValue: ptr("false")},
+		{name: "PVC only, invalid", pvcValue: ptr("yes")},
+		{name: "pod only", podValue: ptr("true"), wantMode: ModeHard, wantRejected: 1},
+		{name: "pod off, PVC true — pod wins", podValue: ptr(OptOutValue), pvcValue: ptr("true")},
+		{name: "pod soft, PVC hard — pod wins", podValue: ptr("soft"), pvcValue: ptr("hard"), wantMode: ModeSoft},
+		{name: "pod hard, PVC false — pod wins", podValue: ptr("hard"), pvcValue: ptr("false"), wantMode: ModeHard, wantRejected: 1},
+		{name: "pod invalid, PVC true — not co-scheduled", podValue: ptr("yes"), pvcValue: ptr("true")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, false, pvcName)
+			if tt.podValue != nil {
+				pod.Annotations = map[string]string{AnnotationKey: *tt.podValue}
+			}
+			pvc := makePVC(pvcName, vmNamespace, pvName)
+			if tt.pvcValue != nil {
+				pvc.Annotations = map[string]string{AnnotationKey: *tt.pvcValue}
+			}
+
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects: []runtime.Object{pod, pvc, makeShareManagerPod(pvName, "node-1")},
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).mode; got != tt.wantMode {
+				t.Errorf("mode = %q, want %q", got, tt.wantMode)
+			}
+			if m.Len() != tt.wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), tt.wantRejected)
+			}
+		})
+	}
+}
+
+// TestPVCOptInStrictestWins checks that ModeHard on one PVC wins over
+// ModeSoft on another.
+func TestPVCOptInStrictestWins(t *testing.T) {
+	soft := makePVC("soft", "default", "pv-soft")
+	soft.Annotations = map[string]string{AnnotationKey: "soft"}
+	hard := makePVC("hard", "default", "pv-hard")
+	hard.Annotations = map[string]string{AnnotationKey: "hard"}
+	pod := makeVM("vm", "default", false, "soft", "hard")
+
+	_, p, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4")},
+		objects: []runtime.Object{pod, soft, hard},
+	})
+	if got := p.mode(context.Background(), pod); got != ModeHard {
+		t.Errorf("mode() = %q, want %q", got, ModeHard)
+	}
+}
+
+// TestNamespaceOptIn checks that the co-scheduling annotation on the pod's
+// Namespace is the default for its pods, that pod annotations override it,
+// and that an invalid value is reported on the pod once.
+func TestNamespaceOptIn(t *testing.T) {
+	const (
+		vmNamespace = "prod-vms"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	tests := []struct {
+		name         string
+		nsValue      *string
+		noNamespace  bool
+		podValue     *string
+		wantMode     Mode
+		wantRejected int
+		wantEvent    bool
+	}{
+		{name: "namespace hard, pod silent", nsValue: ptr("hard"), wantMode: ModeHard, wantRejected: 1},
+		{name: "namespace require, pod silent", nsValue: ptr(NamespaceRequireValue), wantMode: ModeHard, wantRejected: 1},
+		{name: "namespace soft, pod silent", nsValue: ptr("soft"), wantMode: ModeSoft},
+		{name: "namespace hard, pod off", nsValue: ptr("hard"), podValue: ptr(OptOutValue)},
+		{name: "namespace hard, pod soft", nsValue: ptr("hard"), podValue: ptr("soft"), wantMode: ModeSoft},
+		{name: "namespace off, pod hard", nsValue: ptr(OptOutValue), podValue: ptr("hard"), wantMode: ModeHard, wantRejected: 1},
+		{name: "namespace invalid", nsValue: ptr("yes"), wantEvent: true},
+		{name: "namespace not annotated"},
+		{name: "namespace absent", noNamespace: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, false, pvcName)
+			if tt.podValue != nil {
+				pod.Annotations = map[string]string{AnnotationKey: *tt.podValue}
+			}
+			objects := []runtime.Object{pod, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")}
+			if !tt.noNamespace {
+				ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: vmNamespace}}
+				if tt.nsValue != nil {
+					ns.Annotations = map[string]string{AnnotationKey: *tt.nsValue}
+				}
+				objects = append(objects, ns)
+			}
+
+			recorder := events.NewFakeRecorder(10)
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects:  objects,
+				recorder: recorder,
+			})
+
+			runFilters(t, fwk, pod)
+			state, m := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).mode; got != tt.wantMode {
+				t.Errorf("mode = %q, want %q", got, tt.wantMode)
+			}
+			if m.Len() != tt.wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), tt.wantRejected)
+			}
+
+			var invalid []string
+			for len(recorder.Events) > 0 {
+				if e := <-recorder.Events; strings.Contains(e, invalidAnnotationReason) {
+					invalid = append(invalid, e)
+				}
+			}
+			wantEvents := 0
+			if tt.wantEvent {
+				wantEvents = 1
+			}
+			if len(invalid) != wantEvents {
+				t.Fatalf("%d %s events emitted, want %d: %v", len(invalid), invalidAnnotationReason, wantEvents, invalid)
+			}
+			if tt.wantEvent && !strings.Contains(invalid[0], "namespace "+vmNamespace) {
+				t.Errorf("event %q should name namespace %s", invalid[0], vmNamespace)
+			}
+		})
+	}
+}
+
+// TestNamespaceOptInFollowsUpdates checks that a change to the Namespace
+// annotation applies without restarting the scheduler.
+func TestNamespaceOptInFollowsUpdates(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod-vms"}}
+	pod := makeVM("vm", ns.Name, false)
+	_, p, clientset := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4")},
+		objects: []runtime.Object{pod, ns},
+	})
+	ctx := context.Background()
+	if got := p.mode(ctx, pod); got != "" {
+		t.Fatalf("mode() = %q before the namespace is annotated, want none", got)
+	}
+
+	ns = ns.DeepCopy()
+	ns.Annotations = map[string]string{AnnotationKey: "hard"}
+	if _, err := clientset.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating namespace: %v", err)
+	}
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(ctx context.Context) (bool, error) {
+		return p.mode(ctx, pod) == ModeHard, nil
+	}); err != nil {
+		t.Errorf("mode() did not become %q after the namespace was annotated: %v", ModeHard, err)
+	}
+}
+
+// TestStorageClassOptIn checks that the co-scheduling annotation on the
+// StorageClass of one of the pod's RWX PVCs opts the pod in, unless the PVC
+// or the pod says otherwise.
+func TestStorageClassOptIn(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		fastPVC     = "fast"
+		fastPV      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		plainPVC    = "plain"
+		plainPV     = "pvc-7d1c0c4e-9b6f-4b8e-a3f4-2f1e8c0b9d11"
+		fastClass   = "longhorn-rwx-fast"
+		plainClass  = "longhorn-rwx"
+	)
+
+	tests := []struct {
+		name         string
+		classValue   *string
+		provisioner  string
+		fastPVCValue *string
+		podValue     *string
+		wantMode     Mode
+		wantNode     string
+	}{
+		{name: "one of two classes annotated", classValue: ptr("true"), wantMode: ModeHard, wantNode: "node-1"},
+		{name: "no class annotated"},
+		{name: "class soft", classValue: ptr("soft"), wantMode: ModeSoft, wantNode: "node-1"},
+		{name: "PVC annotation overrides its class", classValue: ptr("true"), fastPVCValue: ptr("false")},
+		{name: "pod off overrides the class", classValue: ptr("true"), podValue: ptr(OptOutValue)},
+		{name: "class of another provisioner", classValue: ptr("true"), provisioner: cephFSDriver},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, false, fastPVC, plainPVC)
+			if tt.podValue != nil {
+				pod.Annotations = map[string]string{AnnotationKey: *tt.podValue}
+			}
+			provisioner := LonghornDriver
+			if tt.provisioner != "" {
+				provisioner = tt.provisioner
+			}
+			fast := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: fastClass}, Provisioner: provisioner}
+			if tt.classValue != nil {
+				fast.Annotations = map[string]string{AnnotationKey: *tt.classValue}
+			}
+			plain := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: plainClass}, Provisioner: LonghornDriver}
+			fastClaim := makePVC(fastPVC, vmNamespace, fastPV)
+			fastClaim.Spec.StorageClassName = ptr(fastClass)
+			if tt.fastPVCValue != nil {
+				fastClaim.Annotations = map[string]string{AnnotationKey: *tt.fastPVCValue}
+			}
+			plainClaim := makePVC(plainPVC, vmNamespace, plainPV)
+			plainClaim.Spec.StorageClassName = ptr(plainClass)
+
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects: []runtime.Object{
+					pod, fast, plain, fastClaim, plainClaim,
+					makeShareManagerPod(fastPV, "node-1"),
+					makeShareManagerPod(plainPV, "node-1"),
+				},
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).mode; got != tt.wantMode {
+				t.Errorf("mode = %q, want %q", got, tt.wantMode)
+			}
+			if got := data.(*stateData).shareManagerNode; got != tt.wantNode {
+				t.Errorf("shareManagerNode = %q, want %q", got, tt.wantNode)
+			}
+			wantRejected := 0
+			if tt.wantMode == ModeHard {
+				wantRejected = 1
+			}
+			if m.Len() != wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), wantRejected)
+			}
+		})
+	}
+}
+
+// TestModeResolver checks that a ModeResolver selects the mode like the
+// plugin does, from the pod's VirtualMachineInstance, StorageClasses and
+// Namespace as well as its own annotations.
+func TestModeResolver(t *testing.T) {
+	const (
+		vmNamespace = "prod-vms"
+		vmiName     = "my-vm"
+		vmiUID      = types.UID("5d0b1c7e-2f41-4c1a-9a57-0c6f8d3e2b19")
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		className   = "longhorn-rwx"
+	)
+
+	tests := []struct {
+		name       string
+		args       string
+		podValue   *string
+		vmiValue   *string
+		classValue *string
+		nsValue    *string
+		want       Mode
+	}{
+		{name: "not opted in"},
+		{name: "pod", podValue: ptr("soft"), want: ModeSoft},
+		{name: "pod opted out, namespace hard", podValue: ptr(OptOutValue), nsValue: ptr("hard")},
+		{name: "VMI, inherited", args: `{"inheritVMIAnnotation":true}`, vmiValue: ptr("hard"), want: ModeHard},
+		{name: "VMI, not inherited", vmiValue: ptr("hard")},
+		{name: "VMI opted out, namespace hard", args: `{"inheritVMIAnnotation":true}`, vmiValue: ptr(OptOutValue), nsValue: ptr("hard")},
+		{name: "StorageClass", classValue: ptr("hard"), want: ModeHard},
+		{name: "namespace", nsValue: ptr("soft"), want: ModeSoft},
+		{name: "observe only", args: `{"observeOnly":true}`, nsValue: ptr("hard"), want: ModeObserve},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeLauncher(vmiName, vmNamespace, vmiUID, pvcName)
+			if tt.podValue != nil {
+				pod.Annotations = map[string]string{AnnotationKey: *tt.podValue}
+			}
+			class := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: className}, Provisioner: LonghornDriver}
+			if tt.classValue != nil {
+				class.Annotations = map[string]string{AnnotationKey: *tt.classValue}
+			}
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: vmNamespace}}
+			if tt.nsValue != nil {
+				ns.Annotations = map[string]string{AnnotationKey: *tt.nsValue}
+			}
+			pvc := makePVC(pvcName, vmNamespace, pvName)
+			pvc.Spec.StorageClassName = ptr(className)
+			clientset := fake.NewSimpleClientset(pod, pvc, class, ns)
+			dynClient := newDynamicClient(makeVMI(vmiName, vmNamespace, vmiUID, tt.vmiValue))
+
+			var args runtime.Object
+			if tt.args != "" {
+				args = &runtime.Unknown{Raw: []byte(tt.args), ContentType: runtime.ContentTypeJSON}
+			}
+			r, err := NewModeResolver(clientset, dynClient, args)
+			if err != nil {
+				t.Fatalf("NewModeResolver() error = %v", err)
+			}
+			if got := r.Mode(context.Background(), pod); got != tt.want {
+				t.Errorf("Mode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package longhorn_cosched
+
+import (
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// pinnedPendingLogLimit is the number of pending pods the pinned-pending log
+// line names; the rest are only counted.
+const pinnedPendingLogLimit = 10
+
+// pinnedPending tracks the opted-in pods whose latest scheduling cycle left
+// them unschedulable while pinned to their share-manager node, and adds their
+// number to the pinnedPendingPods gauge, which every scheduler profile
+// shares. A pod is added by PostFilter and removed when a later cycle does
+// not pin it, when it is bound, or when it is deleted.
+//
+// A nil *pinnedPending tracks nothing.
+type pinnedPending struct {
+	mu   sync.Mutex
+	pods map[types.UID]klog.ObjectRef
+}
+
+func newPinnedPending() *pinnedPending {
+	return &pinnedPending{pods: map[types.UID]klog.ObjectRef{}}
+}
+
+// add records the pod as pending on its share-manager node.
+func (t *pinnedPending) add(pod *corev1.Pod, node string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pods[pod.UID]; ok {
+		return
+	}
+	t.pods[pod.UID] = klog.KObj(pod)
+	pinnedPendingPods.Inc()
+
+	logger := klog.V(4)
+	if !logger.Enabled() {
+		return
+	}
+	refs := make([]string, 0, len(t.pods))
+	for _, ref := range t.pods {
+		refs = append(refs, ref.String())
+	}
+	sort.Strings(refs)
+	if len(refs) > pinnedPendingLogLimit {
+		refs = refs[:pinnedPendingLogLimit]
+	}
+	logger.InfoS("LonghornCoSchedule: pod pending on its share-manager node",
+		"pod", klog.KObj(pod),
+		"shareManagerNode", node,
+		"pendingPods", len(t.pods),
+		"pending", refs,
+	)
+}
+
+// remove forgets the pod.
+func (t *pinnedPending) remove(uid types.UID) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pods[uid]; !ok {
+		return
+	}
+	delete(t.pods, uid)
+	pinnedPendingPods.Dec()
+}
+
+// watch removes pods from the tracker when they are deleted, or bound
+// without PostBind seeing it (e.g. by another scheduler instance).
+func (t *pinnedPending) watch(factory informers.SharedInformerFactory) error {
+	_, err := factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok && pod.Spec.NodeName != "" {
+				t.remove(pod.UID)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				t.remove(pod.UID)
+			}
+		},
+	})
+	return err
+}
@@ -0,0 +1,93 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// TestPinnedPendingPods checks that a hard-mode pod that fits no node while
+// pinned to its share-manager node is counted in the pinned-pending gauge
+// once, however often it is retried, and that binding or deleting it, or a
+// cycle that no longer pins it, takes it out again.
+func TestPinnedPendingPods(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	registerMetrics()
+
+	// The share-manager node is too small for the 2-CPU VMs.
+	vm := func(name string) *corev1.Pod {
+		pod := withCPU(makeVM(name, vmNamespace, true, pvcName), "2")
+		pod.UID = types.UID(name)
+		return pod
+	}
+	bound, deleted, unpinned := vm("bound"), vm("deleted"), vm("unpinned")
+	fwk, plugin, clientset := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "1"), makeNode("node-2", "4")},
+		objects: []runtime.Object{bound, deleted, unpinned, makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-1")},
+	})
+	base, _ := testutil.GetGaugeMetricValue(pinnedPendingPods)
+	check := func(want float64) {
+		t.Helper()
+		if got, _ := testutil.GetGaugeMetricValue(pinnedPendingPods); got-base != want {
+			t.Errorf("pinned pending pods = %v, want %v", got-base, want)
+		}
+	}
+	reject := func(pod *corev1.Pod) *framework.CycleState {
+		t.Helper()
+		state, m := runFilters(t, fwk, pod)
+		if m.Len() != 2 {
+			t.Fatalf("%d nodes rejected, want 2", m.Len())
+		}
+		plugin.PostFilter(context.Background(), state, pod, m)
+		return state
+	}
+
+	for i := 0; i < 2; i++ {
+		reject(bound)
+	}
+	reject(deleted)
+	reject(unpinned)
+	check(3)
+
+	// Bound, e.g. after preemption made room on the share-manager node.
+	plugin.PostBind(context.Background(), reject(bound), bound, "node-1")
+	check(2)
+
+	if err := clientset.CoreV1().Pods(vmNamespace).Delete(context.Background(), deleted.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("deleting pod: %v", err)
+	}
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		got, _ := testutil.GetGaugeMetricValue(pinnedPendingPods)
+		return got-base == 1, nil
+	})
+	if err != nil {
+		t.Errorf("deleted pod still counted as pending")
+	}
+
+	// The share-manager goes away; the next cycle does not pin the pod.
+	if err := clientset.CoreV1().Pods(LonghornNamespace).Delete(context.Background(), ShareManagerPrefix+pvName, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("deleting share-manager pod: %v", err)
+	}
+	err = wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		_, err := plugin.podLister.Pods(LonghornNamespace).Get(ShareManagerPrefix + pvName)
+		return apierrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Fatalf("deleted share-manager pod still cached")
+	}
+	runFilters(t, fwk, unpinned)
+	check(0)
+}
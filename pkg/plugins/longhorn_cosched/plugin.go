@@ -6,12 +6,22 @@ package longhorn_cosched
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/preemption"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	"k8s.io/utils/clock"
 )
 
 const (
@@ -22,33 +32,267 @@ const (
 	// co-scheduling with its Longhorn share-manager pod.
 	AnnotationKey = "scheduler.kubevirt-scheduler.io/co-schedule"
 
-	// AnnotationValue is the value the annotation must have to opt in.
+	// AnnotationValue is the canonical value of the annotation to opt in.
+	// It selects hard mode; see ParseMode for the other accepted values.
 	AnnotationValue = "true"
 
+	// OptOutValue is the value of the co-scheduling annotation that exempts
+	// the pod from co-scheduling, whatever opts it in otherwise.
+	OptOutValue = "off"
+
+	// NamespaceRequireValue is a value of the co-scheduling annotation on a
+	// Namespace that selects ModeHard for its pods, besides those ParseMode
+	// accepts.
+	NamespaceRequireValue = "require"
+
+	// ExemptAnnotationKey exempts the pod from co-scheduling when its value
+	// parses as true, like OptOutValue. It takes precedence over the
+	// co-scheduling annotation.
+	ExemptAnnotationKey = "scheduler.kubevirt-scheduler.io/co-schedule-exempt"
+
+	// WaitForStorageAnnotationKey is the opt-in annotation that keeps a
+	// co-scheduled pod out of the active scheduling queue (PreEnqueue) until
+	// its RWX PVCs are bound. Its value is parsed like strconv.ParseBool.
+	WaitForStorageAnnotationKey = "scheduler.kubevirt-scheduler.io/wait-for-storage"
+
+	// StorageReadyGate is the scheduling gate the webhook puts on opted-in
+	// pods at admission and the storage-gate controller removes once
+	// StorageReady reports their storage ready. Unlike
+	// WaitForStorageAnnotationKey, a gated pod never reaches any scheduler.
+	StorageReadyGate = "kubevirt-scheduler/storage-ready"
+
 	// LonghornNamespace is the namespace where Longhorn share-manager pods run.
 	LonghornNamespace = "longhorn-system"
 
+	// CoSchedulePVCAnnotationKey is the per-pod annotation naming the PVCs,
+	// comma-separated, whose share-managers the pod is co-scheduled with. The
+	// pod's other PVCs are ignored. When unset, every RWX PVC is considered.
+	CoSchedulePVCAnnotationKey = "scheduler.kubevirt-scheduler.io/co-schedule-pvc"
+
+	// CoScheduleWithAnnotationKey is the per-pod annotation naming another
+	// pod to co-schedule with, as <namespace>/<label selector>, e.g. a
+	// storage gateway or a licensing daemon. The oldest Running pod matching
+	// the selector is placed like a share-manager. On its own it selects
+	// ModeHard.
+	CoScheduleWithAnnotationKey = "scheduler.kubevirt-scheduler.io/co-schedule-with"
+
+	// LonghornNamespaceAnnotationKey is the per-pod annotation naming the
+	// namespace Longhorn runs in for that pod's volumes, e.g. for a tenant
+	// with its own Longhorn. The namespace must be listed in
+	// Args.AllowedLonghornNamespaces.
+	LonghornNamespaceAnnotationKey = "scheduler.kubevirt-scheduler.io/longhorn-namespace"
+
+	// LonghornDriver is the CSI driver of Longhorn volumes, and the
+	// provisioner of Longhorn StorageClasses. RWX PVCs of other drivers are
+	// not co-scheduled.
+	LonghornDriver = "driver.longhorn.io"
+
 	// ShareManagerPrefix is the prefix used by Longhorn for share-manager pod names.
 	// The full name is: share-manager-<pv-name>
 	ShareManagerPrefix = "share-manager-"
 
+	// ShareManagerLabel is the label Longhorn sets on share-manager pods. Its
+	// value is the name of the ShareManager, which is the PV name.
+	ShareManagerLabel = "longhorn.io/share-manager"
+
+	// ShareManagerComponentLabel and ShareManagerComponentValue identify
+	// every Longhorn share-manager pod.
+	ShareManagerComponentLabel = "longhorn.io/component"
+	ShareManagerComponentValue = "share-manager"
+
 	// MigrationTargetLabel is the KubeVirt label set on virt-launcher pods that
 	// are being created as the target of a live migration. Its value is the UID
 	// of the VirtualMachineInstanceMigration object. The plugin must not
 	// constrain these pods — the migration subsystem handles node selection.
 	MigrationTargetLabel = "kubevirt.io/migrationJobUID"
+
+	// MigrationTargetAnnotationKey is the per-pod annotation that overrides
+	// Args.CoScheduleMigrationTargets for the pod: "skip", "soft" or "hard".
+	// KubeVirt copies it from the VM template to the migration target pod.
+	// Set on a VirtualMachineInstanceMigration, e.g. by the descheduler, it
+	// applies to the migration's target pod unless the pod sets it too.
+	MigrationTargetAnnotationKey = "scheduler.kubevirt-scheduler.io/co-schedule-migration-target"
+
+	// VMNameLabel is the KubeVirt label naming the VM on its virt-launcher
+	// pods. It survives VM restarts, unlike the VMI's UID.
+	VMNameLabel = "vm.kubevirt.io/name"
+
+	// HotplugPodLabel and HotplugPodLabelValue identify the attachment pods
+	// (hp-volume-*) KubeVirt creates for hotplugged volumes. They are owned by
+	// the VM's virt-launcher pod and must run on the same node.
+	HotplugPodLabel      = "kubevirt.io"
+	HotplugPodLabelValue = "hotplug-disk"
 )
 
-// Plugin implements the Filter and Score extension points of the Kubernetes
-// Scheduling Framework to co-locate VM pods with their Longhorn share-manager pods.
+// Mode is the co-scheduling mode requested by a pod through the value of the
+// co-scheduling annotation.
+type Mode string
+
+const (
+	// ModeHard restricts the pod to the share-manager node in Filter, and
+	// PostFilter may preempt lower-priority pods there to make room. This is
+	// the mode selected by AnnotationValue.
+	ModeHard Mode = "hard"
+
+	// ModeSoft never filters nodes; the share-manager node is only preferred
+	// in Score.
+	ModeSoft Mode = "soft"
+
+	// ModeObserve is a dry run: the share-managers are looked up and the
+	// decision is logged, counted and recorded on the bound pod in
+	// DecisionAnnotationKey, but every node passes Filter and scores 0.
+	ModeObserve Mode = "observe"
+)
+
+// Plugin implements the PreEnqueue, PreFilter, Filter, PostFilter and Score
+// extension points of the Kubernetes Scheduling Framework to co-locate VM pods
+// with their Longhorn share-manager pods. All of them live on this single type
+// so the plugin can be enabled through the profile's multiPoint section.
 type Plugin struct {
 	handle    framework.Handle
 	clientset kubernetes.Interface
 	dynClient dynamic.Interface
+	args      Args
+
+	// pvcLister reads PVCs from the scheduler's shared informer cache. It is
+	// nil when the plugin is constructed directly (tests), in which case PVCs
+	// are read through the clientset.
+	pvcLister corelisters.PersistentVolumeClaimLister
+
+	// pvLister and scLister read PVs and StorageClasses from the informer
+	// cache to tell Longhorn volumes apart. They are nil when the plugin is
+	// constructed directly (tests), in which case the clientset is used.
+	pvLister corelisters.PersistentVolumeLister
+	scLister storagelisters.StorageClassLister
+
+	// vaLister reads VolumeAttachments from the informer cache for the
+	// VolumeAttachment fallback of the share-manager lookup. It is nil when
+	// the plugin is constructed directly (tests).
+	vaLister storagelisters.VolumeAttachmentLister
+
+	// nsLister reads Namespaces from the informer cache for the namespace
+	// opt-in. It is nil when the plugin is constructed directly (tests), in
+	// which case the clientset is used.
+	nsLister corelisters.NamespaceLister
+
+	// podLister reads pods from the informer cache for the drift check and
+	// the share-manager and server pod lookups. It is nil when the plugin is
+	// constructed directly (tests).
+	podLister corelisters.PodLister
+
+	// preemptor runs preemption restricted to the share-manager node. It is
+	// nil when the plugin is constructed without a framework handle.
+	preemptor *preemption.Evaluator
+
+	// relocations rate-limits share-manager relocations. It is nil unless
+	// the RelocateShareManager or ShareManagerFollowsVM arg is set.
+	relocations *relocationLimiter
+
+	// vmis caches the co-scheduling annotation of VirtualMachineInstances.
+	// It is nil unless the InheritVMIAnnotation arg is set.
+	vmis *expiringCache[types.UID, vmiAnnotation]
+
+	// hotplugVolumes caches the names of the hotplugged volumes of
+	// VirtualMachineInstances. It is nil if the IncludeHotplugVolumes arg is
+	// set, or when the plugin is constructed directly (tests).
+	hotplugVolumes *expiringCache[types.UID, []string]
+
+	// vmiInformer watches VirtualMachineInstances, so that once it has
+	// synced the VMIs above are read from its cache instead of the API. It
+	// is nil unless the DriftReconcileInterval arg is set: the drift check
+	// reads every running VM's VMI on each run.
+	vmiInformer informers.GenericInformer
+
+	// migrations caches, by pod UID, whether a pod's migration label is
+	// backed by a VirtualMachineInstanceMigration. It is nil unless the
+	// VerifyMigrationTargets arg is set.
+	migrations *expiringCache[types.UID, bool]
+
+	// migrationPolicies caches, by pod UID, the migration target policy set
+	// on the VirtualMachineInstanceMigration a migration target pod belongs
+	// to. It is nil when the plugin is constructed directly (tests).
+	migrationPolicies *expiringCache[types.UID, MigrationTargetPolicy]
+
+	// csiPlugins selects the Longhorn CSI plugin pods Filter requires on a
+	// node. It is nil unless the RequireCSIPlugin arg is set.
+	csiPlugins labels.Selector
+
+	// instanceManagers selects the Longhorn instance-manager pods Filter
+	// requires on a node. It is nil unless the RequireInstanceManager arg is
+	// set.
+	instanceManagers labels.Selector
+
+	// longhornNodes lists Longhorn Nodes from a dynamic informer. It is nil
+	// when the LonghornNodePolicy and VolumeNodeTagPolicy args are ignore or
+	// the CRD is not served.
+	longhornNodes cache.GenericNamespaceLister
+
+	// replicas and volumes list Longhorn Replicas and Volumes from dynamic
+	// informers, by Longhorn namespace: LonghornNamespace and each of
+	// AllowedLonghornNamespaces. The strict-local lookup, which runs for
+	// every pod, and the replica scores read them. They are empty if the
+	// CRDs are not served.
+	replicas map[string]cache.GenericNamespaceLister
+	volumes  map[string]cache.GenericNamespaceLister
+
+	// clock tells how long the share-manager node has been NotReady. It is
+	// nil when the plugin is constructed directly (tests), in which case the
+	// NotReady fallback is disabled.
+	clock clock.PassiveClock
+
+	// crd tracks ShareManager CRD lookup failures. It is nil when the plugin
+	// is constructed directly (tests).
+	crd *crdGuard
+
+	// forbidden tracks lookup paths that keep failing with Forbidden. It is
+	// nil when the plugin is constructed directly (tests).
+	forbidden *forbiddenGuard
+
+	// shareManagers resolves which ShareManager CRD version the cluster
+	// serves. It is nil when the plugin is constructed directly (tests), in
+	// which case shareManagerGVR is used.
+	shareManagers *shareManagerAPI
+
+	// warnings rate-limits events about invalid annotation values. It is nil
+	// when the plugin is constructed directly (tests).
+	warnings *warningLimiter
+
+	// pending tracks the pods left pending while pinned to their
+	// share-manager node. It is nil when the plugin is constructed directly
+	// (tests).
+	pending *pinnedPending
+
+	// bound remembers the mode co-scheduled pods were bound in. It is nil
+	// unless the PinnedVMPenalty arg is set.
+	bound *boundModes
+
+	// decisions de-duplicates the events explaining co-scheduling decisions.
+	// It is nil when the plugin is constructed directly (tests), in which
+	// case every decision event is emitted.
+	decisions *warningLimiter
+
+	// history keeps the last co-scheduling decisions. It is nil unless the
+	// DecisionHistorySize arg is set.
+	history *decisionHistory
+
+	// decisionLog writes the decision audit log. It is nil unless the
+	// DecisionLog arg is set.
+	decisionLog *decisionLogger
+
+	// records writes CoScheduleDecision records. It is nil unless the
+	// DecisionRecords arg is set.
+	records *decisionRecorder
 }
 
+var _ framework.PreEnqueuePlugin = &Plugin{}
+var _ framework.EnqueueExtensions = &Plugin{}
+var _ framework.PreFilterPlugin = &Plugin{}
+var _ framework.PreFilterExtensions = &Plugin{}
 var _ framework.FilterPlugin = &Plugin{}
+var _ framework.PostFilterPlugin = &Plugin{}
 var _ framework.ScorePlugin = &Plugin{}
+var _ framework.ScoreExtensions = &Plugin{}
+var _ framework.PostBindPlugin = &Plugin{}
 
 // Name returns the name of the plugin.
 func (p *Plugin) Name() string {
@@ -56,7 +300,12 @@ func (p *Plugin) Name() string {
 }
 
 // New creates a new instance of the LonghornCoSchedule plugin.
-func New(_ context.Context, _ runtime.Object, h framework.Handle) (framework.Plugin, error) {
+func New(ctx context.Context, obj runtime.Object, h framework.Handle) (framework.Plugin, error) {
+	args, err := decodeArgs(obj)
+	if err != nil {
+		return nil, err
+	}
+
 	clientset, err := kubernetes.NewForConfig(h.KubeConfig())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kubernetes clientset: %w", err)
@@ -67,19 +316,224 @@ func New(_ context.Context, _ runtime.Object, h framework.Handle) (framework.Plu
 		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
 
-	return &Plugin{
+	return newPlugin(ctx, h, args, clientset, dynClient)
+}
+
+// NewWithClients returns a factory of the plugin that uses the given clients
+// instead of building them from the framework handle's kubeconfig, for
+// callers that run the framework themselves, such as the scheduler extender.
+func NewWithClients(clientset kubernetes.Interface, dynClient dynamic.Interface) frameworkruntime.PluginFactory {
+	return func(ctx context.Context, obj runtime.Object, h framework.Handle) (framework.Plugin, error) {
+		args, err := decodeArgs(obj)
+		if err != nil {
+			return nil, err
+		}
+		return newPlugin(ctx, h, args, clientset, dynClient)
+	}
+}
+
+// newPlugin wires a Plugin around the given clients and the framework handle's
+// informers. It is separate from New so tests can inject fake clients.
+func newPlugin(ctx context.Context, h framework.Handle, args Args, clientset kubernetes.Interface, dynClient dynamic.Interface) (*Plugin, error) {
+	p := &Plugin{
 		handle:    h,
 		clientset: clientset,
 		dynClient: dynClient,
-	}, nil
+		args:      args,
+		pvcLister: h.SharedInformerFactory().Core().V1().PersistentVolumeClaims().Lister(),
+		pvLister:  h.SharedInformerFactory().Core().V1().PersistentVolumes().Lister(),
+		scLister:  h.SharedInformerFactory().Storage().V1().StorageClasses().Lister(),
+		vaLister:  h.SharedInformerFactory().Storage().V1().VolumeAttachments().Lister(),
+		nsLister:  h.SharedInformerFactory().Core().V1().Namespaces().Lister(),
+		podLister: h.SharedInformerFactory().Core().V1().Pods().Lister(),
+		clock:     clock.RealClock{},
+	}
+	p.crd = newCRDGuard(p.clock, int(args.CRDFailureThreshold), args.CRDFailureCooldown.Duration)
+	p.forbidden = newForbiddenGuard(p.clock, int(args.ForbiddenThreshold), args.ForbiddenCooldown.Duration)
+	p.shareManagers = newShareManagerAPI(clientset.Discovery(), p.clock)
+	p.warnings = newWarningLimiter(p.clock, invalidAnnotationWarningInterval)
+	p.decisions = newWarningLimiter(p.clock, decisionEventInterval)
+	p.pending = newPinnedPending()
+	registerMetrics()
+	if err := p.pending.watch(h.SharedInformerFactory()); err != nil {
+		return nil, fmt.Errorf("watching pods: %w", err)
+	}
+
+	preemptor, err := newShareManagerPreemptor(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	p.preemptor = preemptor
+
+	if args.PinnedVMPenalty > 0 {
+		p.bound = newBoundModes()
+		if err := p.bound.watch(h.SharedInformerFactory()); err != nil {
+			return nil, fmt.Errorf("watching pods: %w", err)
+		}
+	}
+
+	if args.InheritVMIAnnotation {
+		p.vmis = newExpiringCache[types.UID, vmiAnnotation](p.clock, vmiAnnotationCacheTTL)
+	}
+	if !args.IncludeHotplugVolumes {
+		p.hotplugVolumes = newExpiringCache[types.UID, []string](p.clock, vmiAnnotationCacheTTL)
+	}
+
+	if args.VerifyMigrationTargets {
+		p.migrations = newExpiringCache[types.UID, bool](p.clock, migrationVerificationTTL)
+	}
+	p.migrationPolicies = newExpiringCache[types.UID, MigrationTargetPolicy](p.clock, migrationVerificationTTL)
+
+	if args.RequireCSIPlugin {
+		p.csiPlugins, err = labels.Parse(args.csiPluginSelector())
+		if err != nil {
+			return nil, fmt.Errorf("parsing csiPluginSelector: %w", err)
+		}
+	}
+	if args.RequireInstanceManager {
+		p.instanceManagers, err = labels.Parse(args.instanceManagerSelector())
+		if err != nil {
+			return nil, fmt.Errorf("parsing instanceManagerSelector: %w", err)
+		}
+	}
+
+	if args.longhornNodePolicy() != LonghornNodePolicyIgnore || args.volumeNodeTagPolicy() != VolumeNodeTagPolicyIgnore {
+		p.longhornNodes = watchLonghornNodes(ctx, dynClient, p.shareManagers)
+	}
+
+	p.replicas = map[string]cache.GenericNamespaceLister{}
+	p.volumes = map[string]cache.GenericNamespaceLister{}
+	for _, namespace := range append([]string{LonghornNamespace}, args.AllowedLonghornNamespaces...) {
+		if _, ok := p.replicas[namespace]; ok {
+			continue
+		}
+		if lister := watchLonghornResource(ctx, dynClient, p.shareManagers, namespace, longhornReplicaResource); lister != nil {
+			p.replicas[namespace] = lister
+		}
+		if lister := watchLonghornResource(ctx, dynClient, p.shareManagers, namespace, longhornVolumeResource); lister != nil {
+			p.volumes[namespace] = lister
+		}
+	}
+
+	if args.RelocateShareManager || args.ShareManagerFollowsVM {
+		p.relocations = newRelocationLimiter(p.clock, args.ShareManagerRelocationCooldown.Duration)
+	}
+
+	if args.DecisionLog != "" {
+		p.decisionLog, err = newDecisionLogger(ctx, h, p.clock, args.DecisionLogPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening decisionLogPath: %w", err)
+		}
+	}
+
+	if args.DecisionRecords {
+		p.records = newDecisionRecorder(ctx, dynClient, p.clock, int(args.DecisionRecordsPerNamespace))
+	}
+
+	if args.DecisionHistorySize > 0 {
+		p.history = newDecisionHistory(p.clock, int(args.DecisionHistorySize))
+		p.history.dumpOnSignal(ctx)
+	}
+
+	if args.DriftReconcileInterval.Duration > 0 {
+		p.vmiInformer = watchVMIs(ctx, dynClient)
+		go p.runDriftReconciler(ctx, args.DriftReconcileInterval.Duration, args.driftLeaseNamespace())
+	}
+
+	return p, nil
 }
 
-// isOptedIn returns true if the pod has the co-scheduling annotation set to "true".
-func isOptedIn(pod *corev1.Pod) bool {
-	if pod.Annotations == nil {
+// podMode returns the co-scheduling mode selected by the pod's own
+// annotations, or an empty Mode if they select none. The sources are checked
+// in this order, and the first one that decides wins:
+//
+//  1. Pod opt-out (optedOut): the exempt annotation, or the co-scheduling
+//     annotation set to OptOutValue or a false value. It overrides every
+//     broader opt-in mechanism, so a single VM can always float freely.
+//  2. The pod's co-scheduling annotation; see ParseMode for the values.
+//  3. The co-schedule-with annotation, which selects ModeHard.
+//
+// Plugin.mode consults the broader opt-in mechanisms when none of these is
+// set.
+func podMode(pod *corev1.Pod) Mode {
+	if optedOut(pod) {
+		return ""
+	}
+	if value, ok := pod.Annotations[AnnotationKey]; ok {
+		mode, _ := ParseMode(value)
+		return mode
+	}
+	if _, ok := pod.Annotations[CoScheduleWithAnnotationKey]; ok {
+		return ModeHard
+	}
+	return ""
+}
+
+// optedOut returns true if the pod explicitly refuses co-scheduling.
+func optedOut(pod *corev1.Pod) bool {
+	if annotationBool(pod, ExemptAnnotationKey) {
+		return true
+	}
+	value, ok := pod.Annotations[AnnotationKey]
+	if !ok {
 		return false
 	}
-	return pod.Annotations[AnnotationKey] == AnnotationValue
+	if value == OptOutValue {
+		return true
+	}
+	optIn, err := strconv.ParseBool(value)
+	return err == nil && !optIn
+}
+
+// ParseMode returns the co-scheduling mode selected by a value of the
+// co-scheduling annotation. The keywords "hard", "soft", "observe" and
+// OptOutValue are matched first; any other value is parsed like
+// strconv.ParseBool, so "true", "True" and "1" select ModeHard and "false" or
+// "0" opt out. valid is false for a value that is neither, e.g. "yes".
+func ParseMode(value string) (mode Mode, valid bool) {
+	switch value {
+	case string(ModeHard):
+		return ModeHard, true
+	case string(ModeSoft):
+		return ModeSoft, true
+	case string(ModeObserve):
+		return ModeObserve, true
+	case OptOutValue:
+		return "", true
+	}
+	optIn, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", false
+	}
+	if optIn {
+		return ModeHard, true
+	}
+	return "", true
+}
+
+// annotationBool returns the value of a boolean annotation, parsed like
+// strconv.ParseBool. A missing or invalid value is false.
+func annotationBool(pod *corev1.Pod, key string) bool {
+	value, _ := strconv.ParseBool(pod.Annotations[key])
+	return value
+}
+
+// podDecides returns true if the pod's own annotations decide its mode, so
+// that broader opt-in mechanisms are not consulted. An invalid co-scheduling
+// annotation value decides too: the pod is not co-scheduled.
+func podDecides(pod *corev1.Pod) bool {
+	if optedOut(pod) {
+		return true
+	}
+	_, set := pod.Annotations[AnnotationKey]
+	_, with := pod.Annotations[CoScheduleWithAnnotationKey]
+	return set || with
+}
+
+// waitsForStorage returns true if the pod asks to be held back from scheduling
+// until its storage is ready.
+func waitsForStorage(pod *corev1.Pod) bool {
+	return annotationBool(pod, WaitForStorageAnnotationKey)
 }
 
 // isMigrationTarget returns true if the pod is a KubeVirt live-migration target
@@ -88,6 +542,9 @@ func isOptedIn(pod *corev1.Pod) bool {
 // The plugin must be a no-op for these pods: the KubeVirt migration controller
 // already selects the destination node via node affinity, and constraining it
 // to the share-manager node would break live migration.
+//
+// Only the label is checked; Plugin.isMigrationTargetPod can confirm it
+// against the migration object.
 func isMigrationTarget(pod *corev1.Pod) bool {
 	if pod.Labels == nil {
 		return false
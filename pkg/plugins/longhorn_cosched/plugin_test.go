@@ -2,10 +2,12 @@ package longhorn_cosched
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/kubernetes/pkg/scheduler/framework"
@@ -51,17 +53,22 @@ func makePVC(name, namespace, pvName string) *corev1.PersistentVolumeClaim {
 			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
 			VolumeName:  pvName,
 		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase: corev1.ClaimBound,
+		},
 	}
 }
 
 // makeShareManagerPod creates a minimal Longhorn share-manager pod.
-// pvName is the PV name (= PVC UID for dynamic provisioning), which Longhorn
-// uses as the suffix: share-manager-<pvName>.
+// pvName is the PV name (pvc-<uid> for dynamic provisioning, anything for a
+// static PV), never the PVC name: Longhorn names the pod share-manager-<pvName>
+// and sets the longhorn.io/share-manager label to pvName.
 func makeShareManagerPod(pvName, nodeName string) *corev1.Pod {
 	return &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      ShareManagerPrefix + pvName,
 			Namespace: LonghornNamespace,
+			Labels:    map[string]string{ShareManagerLabel: pvName, ShareManagerComponentLabel: ShareManagerComponentValue},
 		},
 		Spec: corev1.PodSpec{
 			NodeName: nodeName,
@@ -151,7 +158,7 @@ func TestIsOptedIn(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := isOptedIn(tt.pod)
+			got := (&Plugin{clientset: fake.NewSimpleClientset()}).isOptedIn(context.Background(), tt.pod)
 			if got != tt.wantOptIn {
 				t.Errorf("isOptedIn() = %v, want %v", got, tt.wantOptIn)
 			}
@@ -159,10 +166,88 @@ func TestIsOptedIn(t *testing.T) {
 	}
 }
 
-// --- findShareManagerNode tests ---
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantMode  Mode
+		wantValid bool
+	}{
+		{value: "hard", wantMode: ModeHard, wantValid: true},
+		{value: "soft", wantMode: ModeSoft, wantValid: true},
+		{value: "true", wantMode: ModeHard, wantValid: true},
+		{value: "True", wantMode: ModeHard, wantValid: true},
+		{value: "TRUE", wantMode: ModeHard, wantValid: true},
+		{value: "t", wantMode: ModeHard, wantValid: true},
+		{value: "1", wantMode: ModeHard, wantValid: true},
+		{value: "false", wantValid: true},
+		{value: "False", wantValid: true},
+		{value: "f", wantValid: true},
+		{value: "0", wantValid: true},
+		{value: "off", wantValid: true},
+		{value: "yes"},
+		{value: "on"},
+		{value: "Hard"},
+		{value: " true"},
+		{value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.value), func(t *testing.T) {
+			mode, valid := ParseMode(tt.value)
+			if mode != tt.wantMode || valid != tt.wantValid {
+				t.Errorf("ParseMode(%q) = %q, %v, want %q, %v", tt.value, mode, valid, tt.wantMode, tt.wantValid)
+			}
+		})
+	}
+}
+
+// TestPodModeOptOut checks that a pod-level opt-out wins over the pod's
+// co-scheduling annotation.
+func TestPodModeOptOut(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantMode    Mode
+		wantOptOut  bool
+	}{
+		{name: "no annotations"},
+		{name: "hard", annotations: map[string]string{AnnotationKey: "hard"}, wantMode: ModeHard},
+		{name: "off", annotations: map[string]string{AnnotationKey: OptOutValue}, wantOptOut: true},
+		{name: "false", annotations: map[string]string{AnnotationKey: "false"}, wantOptOut: true},
+		{name: "invalid value is not an opt-out", annotations: map[string]string{AnnotationKey: "yes"}},
+		{
+			name:        "exempt overrides hard",
+			annotations: map[string]string{AnnotationKey: "hard", ExemptAnnotationKey: "true"},
+			wantOptOut:  true,
+		},
+		{
+			name:        "exempt overrides soft",
+			annotations: map[string]string{AnnotationKey: "soft", ExemptAnnotationKey: "1"},
+			wantOptOut:  true,
+		},
+		{
+			name:        "exempt false keeps hard",
+			annotations: map[string]string{AnnotationKey: "hard", ExemptAnnotationKey: "false"},
+			wantMode:    ModeHard,
+		},
+		{name: "exempt alone", annotations: map[string]string{ExemptAnnotationKey: "true"}, wantOptOut: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			if got := podMode(pod); got != tt.wantMode {
+				t.Errorf("podMode() = %q, want %q", got, tt.wantMode)
+			}
+			if got := optedOut(pod); got != tt.wantOptOut {
+				t.Errorf("optedOut() = %v, want %v", got, tt.wantOptOut)
+			}
+		})
+	}
+}
+
+// --- findShareManagerPlacements tests ---
 // These tests pass nil for dynClient so only the pod-based fallback is exercised.
 
-func TestFindShareManagerNode(t *testing.T) {
+func TestFindShareManagerPlacements(t *testing.T) {
 	const (
 		vmNamespace = "default"
 		pvcName     = "my-rwx-pvc"
@@ -232,13 +317,17 @@ func TestFindShareManagerNode(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			clientset := fake.NewSimpleClientset(tt.objects...)
 			// nil dynClient: CRD lookup is skipped, pod-based fallback is used.
-			got, err := findShareManagerNode(context.Background(), clientset, nil, tt.pod)
+			placements, err := findShareManagerPlacements(context.Background(), clientset, nil, tt.pod, lookupOptions{})
 			if (err != nil) != tt.wantErr {
-				t.Errorf("findShareManagerNode() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("findShareManagerPlacements() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			var got string
+			if len(placements) > 0 {
+				got = placements[0].node
+			}
 			if got != tt.wantNode {
-				t.Errorf("findShareManagerNode() = %q, want %q", got, tt.wantNode)
+				t.Errorf("findShareManagerPlacements() node = %q, want %q", got, tt.wantNode)
 			}
 		})
 	}
@@ -256,11 +345,14 @@ func TestFilter(t *testing.T) {
 	)
 
 	tests := []struct {
-		name        string
-		pod         *corev1.Pod
-		objects     []runtime.Object
-		nodeName    string
-		wantSuccess bool
+		name         string
+		pod          *corev1.Pod
+		objects      []runtime.Object
+		shareManager *unstructured.Unstructured
+		nodeName     string
+		wantSuccess  bool
+		// wantMessage is the status message of a rejected node.
+		wantMessage string
 	}{
 		{
 			name:        "pod not opted in — all nodes pass",
@@ -289,6 +381,16 @@ func TestFilter(t *testing.T) {
 			objects:     []runtime.Object{makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, targetNode)},
 			nodeName:    otherNode,
 			wantSuccess: false,
+			wantMessage: `node "node-1" rejected: VM must run on "node-2" where Longhorn share-manager for PVC default/my-rwx-pvc (pv ` + pvName + `) is running (source: pod)`,
+		},
+		{
+			name:         "opted in, ShareManager on target — other node rejected",
+			pod:          makeVM("vm", vmNamespace, true, pvcName),
+			objects:      []runtime.Object{makePVC(pvcName, vmNamespace, pvName)},
+			shareManager: makeShareManagerCR(pvName, targetNode, "running"),
+			nodeName:     otherNode,
+			wantSuccess:  false,
+			wantMessage:  `node "node-1" rejected: VM must run on "node-2" where Longhorn share-manager for PVC default/my-rwx-pvc (pv ` + pvName + `) is running (source: shareManager)`,
 		},
 		{
 			name:        "migration target pod — all nodes pass (plugin is no-op)",
@@ -304,8 +406,14 @@ func TestFilter(t *testing.T) {
 			clientset := fake.NewSimpleClientset(tt.objects...)
 			// nil dynClient: CRD lookup is skipped, pod-based fallback is used.
 			plugin := &Plugin{clientset: clientset, dynClient: nil}
+			if tt.shareManager != nil {
+				plugin.dynClient = newDynamicClient(tt.shareManager)
+			}
 			nodeInfo := makeNodeInfo(tt.nodeName)
 			status := plugin.Filter(context.Background(), nil, tt.pod, nodeInfo)
+			if tt.wantMessage != "" && status.Message() != tt.wantMessage {
+				t.Errorf("Filter() message = %q, want %q", status.Message(), tt.wantMessage)
+			}
 			if tt.wantSuccess && status != nil && !status.IsSuccess() {
 				t.Errorf("Filter() returned non-success status: %v", status.Message())
 			}
@@ -386,3 +494,50 @@ func TestScore(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores framework.NodeScoreList
+		want   []int64
+	}{
+		{
+			name:   "all zero — unchanged, no division by zero",
+			scores: framework.NodeScoreList{{Name: "node-1", Score: 0}, {Name: "node-2", Score: 0}},
+			want:   []int64{0, 0},
+		},
+		{
+			name:   "already 0-or-100 — unchanged",
+			scores: framework.NodeScoreList{{Name: "node-1", Score: 0}, {Name: "node-2", Score: framework.MaxNodeScore}},
+			want:   []int64{0, framework.MaxNodeScore},
+		},
+		{
+			name: "mixed raw scores — rescaled to 0-100, order kept",
+			scores: framework.NodeScoreList{
+				{Name: "node-1", Score: 1},
+				{Name: "node-2", Score: 4},
+				{Name: "node-3", Score: 0},
+				{Name: "node-4", Score: 2},
+			},
+			want: []int64{25, framework.MaxNodeScore, 0, 50},
+		},
+	}
+
+	plugin := &Plugin{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plugin.ScoreExtensions() == nil {
+				t.Fatal("ScoreExtensions() = nil")
+			}
+			status := plugin.ScoreExtensions().NormalizeScore(context.Background(), nil, nil, tt.scores)
+			if !status.IsSuccess() {
+				t.Fatalf("NormalizeScore() status = %v", status)
+			}
+			for i, s := range tt.scores {
+				if s.Score != tt.want[i] {
+					t.Errorf("NormalizeScore() %s = %d, want %d", s.Name, s.Score, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// Reasons a co-scheduled pod was bound away from its share-managers, as
+// counted in divergentBinds.
+const (
+	// divergentNoShareManager: no share-manager was found for the pod.
+	divergentNoShareManager = "no-sm-found"
+
+	// divergentFallback: a fallback policy relaxed the hard filter, e.g.
+	// because the share-manager node was cordoned.
+	divergentFallback = "fallback-triggered"
+
+	// divergentSoftOutvoted: a soft-mode pod's share-manager node lost to
+	// another node on the other plugins' scores.
+	divergentSoftOutvoted = "soft-outvoted"
+
+	// divergentObserveOnly: an observe-mode pod would have been pinned to
+	// its share-manager node, but nothing held it there.
+	divergentObserveOnly = "observe-only"
+
+	// divergentNotPinned: the share-managers did not pin a hard-mode pod,
+	// e.g. because they were not Ready or on different nodes under the
+	// scoreOnly conflict policy.
+	divergentNotPinned = "not-pinned"
+)
+
+// PostBind implements the PostBindPlugin interface.
+//
+// It counts whether an opted-in pod was bound to a node hosting one of its
+// share-managers, from the decision PreFilter stored in the CycleState, and
+// records the decision where Args asks for it. With ShareManagerFollowsVM,
+// the share-managers the pod was bound away from may be asked to follow it.
+func (p *Plugin) PostBind(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) {
+	defer func(start time.Time) { observeExtensionPoint(extensionPointPostBind, start, nil) }(time.Now())
+	p.pending.remove(pod.UID)
+
+	data, err := state.Read(stateKey)
+	if err != nil {
+		return
+	}
+	s, ok := data.(*stateData)
+	if !ok || s.mode == "" || hotplugOwner(pod) != "" {
+		return
+	}
+
+	p.bound.set(pod.UID, s.mode)
+	d := s.decision
+	p.decisionLog.log(pod, s, decisionLogBound, nodeName, s.nodeCounts[nodeName] > 0)
+	p.records.record(pod, d, nodeName, s.nodeCounts[nodeName] > 0)
+	if d.Mode == ModeObserve {
+		p.recordObservedDecision(ctx, pod, s, nodeName, s.nodeCounts[nodeName] > 0)
+	}
+
+	if s.nodeCounts[nodeName] > 0 {
+		colocatedBinds.WithLabelValues(string(s.mode)).Inc()
+		return
+	}
+	reason := divergenceReason(d)
+	divergentBinds.WithLabelValues(string(d.Mode), reason).Inc()
+	klog.V(4).InfoS("LonghornCoSchedule/PostBind: pod bound away from its share-managers",
+		"pod", klog.KObj(pod),
+		"node", nodeName,
+		"mode", d.Mode,
+		"reason", reason,
+		"decision", d.Outcome,
+		"shareManagerNode", d.ShareManagerNode,
+	)
+	if p.args.ShareManagerFollowsVM && d.Mode != ModeObserve {
+		p.followVM(ctx, pod, s.placements, nodeName)
+	}
+}
+
+// divergenceReason returns why a pod with the given cycle decision was not
+// bound to a node hosting one of its share-managers.
+func divergenceReason(d Decision) string {
+	switch {
+	case d.Outcome == decisionNoShareManager:
+		return divergentNoShareManager
+	case d.Outcome == decisionFallback:
+		return divergentFallback
+	case d.Outcome == decisionObserved:
+		return divergentObserveOnly
+	case d.Mode == ModeSoft:
+		return divergentSoftOutvoted
+	default:
+		return divergentNotPinned
+	}
+}
@@ -0,0 +1,121 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// TestPostBindColocation checks that PostBind counts a bind on the
+// share-manager node as colocated and any other as divergent, with the
+// reason taken from the cycle's decision.
+func TestPostBindColocation(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	registerMetrics()
+
+	cordoned := makeNode("node-1", "4")
+	cordoned.Spec.Unschedulable = true
+
+	soft := makeVM("vm", vmNamespace, true, pvcName)
+	soft.Annotations[AnnotationKey] = string(ModeSoft)
+
+	tests := []struct {
+		name         string
+		pod          *corev1.Pod
+		smNode       *corev1.Node
+		shareManager bool
+		args         Args
+		boundTo      string
+		wantMode     Mode
+		// wantReason is the divergence reason, or "" for a colocated bind.
+		wantReason string
+		wantNone   bool
+	}{
+		{name: "hard, on share-manager node", pod: makeVM("vm", vmNamespace, true, pvcName), shareManager: true, boundTo: "node-1", wantMode: ModeHard},
+		{name: "soft, on share-manager node", pod: soft, shareManager: true, boundTo: "node-1", wantMode: ModeSoft},
+		{name: "soft, outvoted", pod: soft, shareManager: true, boundTo: "node-2", wantMode: ModeSoft, wantReason: divergentSoftOutvoted},
+		{name: "hard, no share-manager", pod: makeVM("vm", vmNamespace, true, pvcName), boundTo: "node-2", wantMode: ModeHard, wantReason: divergentNoShareManager},
+		{
+			name:         "hard, share-manager node cordoned",
+			pod:          makeVM("vm", vmNamespace, true, pvcName),
+			smNode:       cordoned,
+			shareManager: true,
+			args:         Args{CordonedNodePolicy: CordonedNodePolicySoft},
+			boundTo:      "node-2",
+			wantMode:     ModeHard,
+			wantReason:   divergentFallback,
+		},
+		{name: "not opted in", pod: makeVM("vm", vmNamespace, false, pvcName), shareManager: true, boundTo: "node-2", wantNone: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smNode := tt.smNode
+			if smNode == nil {
+				smNode = makeNode("node-1", "4")
+			}
+			objects := []runtime.Object{tt.pod, makePVC(pvcName, vmNamespace, pvName)}
+			if tt.shareManager {
+				objects = append(objects, makeShareManagerPod(pvName, "node-1"))
+			}
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:   []*corev1.Node{smNode, makeNode("node-2", "4")},
+				objects: objects,
+				args:    tt.args,
+			})
+
+			colocated := func(mode Mode) float64 {
+				v, _ := testutil.GetCounterMetricValue(colocatedBinds.WithLabelValues(string(mode)))
+				return v
+			}
+			divergent := func(mode Mode, reason string) float64 {
+				v, _ := testutil.GetCounterMetricValue(divergentBinds.WithLabelValues(string(mode), reason))
+				return v
+			}
+			var before float64
+			switch {
+			case tt.wantNone:
+			case tt.wantReason == "":
+				before = colocated(tt.wantMode)
+			default:
+				before = divergent(tt.wantMode, tt.wantReason)
+			}
+			total := func() float64 {
+				var sum float64
+				for _, v := range gatherCounter("longhorn_cosched_colocated_total", nil, "mode") {
+					sum += v
+				}
+				for _, v := range gatherCounter("longhorn_cosched_divergent_total", nil, "reason") {
+					sum += v
+				}
+				return sum
+			}
+			totalBefore := total()
+
+			state, _ := runFilters(t, fwk, tt.pod)
+			plugin.PostBind(context.Background(), state, tt.pod, tt.boundTo)
+
+			switch {
+			case tt.wantNone:
+				if total() != totalBefore {
+					t.Errorf("bind of a pod that is not opted in was counted")
+				}
+			case tt.wantReason == "":
+				if got := colocated(tt.wantMode) - before; got != 1 {
+					t.Errorf("colocated{mode=%q} went up by %v, want 1", tt.wantMode, got)
+				}
+			default:
+				if got := divergent(tt.wantMode, tt.wantReason) - before; got != 1 {
+					t.Errorf("divergent{mode=%q,reason=%q} went up by %v, want 1", tt.wantMode, tt.wantReason, got)
+				}
+			}
+		})
+	}
+}
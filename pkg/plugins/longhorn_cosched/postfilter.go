@@ -0,0 +1,160 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/defaultpreemption"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/feature"
+	"k8s.io/kubernetes/pkg/scheduler/framework/preemption"
+)
+
+// PostFilter implements the PostFilterPlugin interface.
+//
+// When a hard-mode pod is unschedulable and a share-manager node is known,
+// PostFilter tries to make room on that node by preempting lower-priority
+// pods, and nominates it on success. Other nodes are never considered, and
+// victims whose eviction would violate a PodDisruptionBudget are never chosen.
+//
+// If preemption is not possible and share-manager relocation is enabled (see
+// Args.RelocateShareManager), PostFilter may instead ask Longhorn to move the
+// share-manager; the pod stays Unschedulable and is retried once the
+// share-manager pod has been recreated. Otherwise it emits a warning event
+// naming the share-manager node, its PVCs and the constraint that keeps the
+// pod off it.
+//
+// For any other pod PostFilter returns Unschedulable, leaving the decision to
+// the remaining PostFilter plugins (e.g. DefaultPreemption).
+func (p *Plugin) PostFilter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, m framework.NodeToStatusReader) (_ *framework.PostFilterResult, status *framework.Status) {
+	defer func(start time.Time) { observeExtensionPoint(extensionPointPostFilter, start, status) }(time.Now())
+	defer func() { p.logUnschedulable(state, pod, status) }()
+	podKey := klog.KObj(pod)
+
+	if p.cycleMode(ctx, state, pod) != ModeHard || p.isMigrationTargetPod(ctx, pod) {
+		return nil, framework.NewStatus(framework.Unschedulable)
+	}
+
+	data, err := p.cycleData(ctx, state, pod)
+	if err != nil {
+		return nil, framework.AsStatus(fmt.Errorf("looking up share-manager: %w", err))
+	}
+	shareManagerNode := data.shareManagerNode
+	if shareManagerNode == "" {
+		return nil, framework.NewStatus(framework.Unschedulable)
+	}
+	p.pending.add(pod, shareManagerNode)
+
+	if p.preemptor == nil {
+		p.reportShareManagerNodeUnavailable(pod, data, m)
+		return nil, framework.NewStatus(framework.Unschedulable, "preemption is not available")
+	}
+
+	klog.V(4).InfoS("LonghornCoSchedule/PostFilter: attempting preemption on share-manager node",
+		"pod", podKey,
+		"shareManagerNode", shareManagerNode,
+	)
+
+	result, status := p.preemptor.Preempt(ctx, state, pod, &shareManagerNodeStatus{NodeToStatusReader: m, node: shareManagerNode})
+	if status.IsSuccess() {
+		klog.V(4).InfoS("LonghornCoSchedule/PostFilter: nominated share-manager node",
+			"pod", podKey,
+			"shareManagerNode", shareManagerNode,
+		)
+		return result, status
+	}
+
+	klog.V(4).InfoS("LonghornCoSchedule/PostFilter: preemption on share-manager node not possible",
+		"pod", podKey,
+		"shareManagerNode", shareManagerNode,
+		"reason", status.Message(),
+	)
+
+	relocated, err := p.relocateShareManager(ctx, pod, shareManagerNode, m)
+	if err != nil {
+		return nil, framework.AsStatus(fmt.Errorf("relocating share-manager: %w", err))
+	}
+	if relocated {
+		return nil, framework.NewStatus(framework.Unschedulable,
+			fmt.Sprintf("share-manager on node %q is being relocated", shareManagerNode))
+	}
+	p.reportShareManagerNodeUnavailable(pod, data, m)
+	return result, status
+}
+
+// shareManagerNodeStatus wraps the scheduler's NodeToStatusReader so that the
+// preemption evaluator only ever sees the share-manager node as a candidate.
+type shareManagerNodeStatus struct {
+	framework.NodeToStatusReader
+	node string
+}
+
+// NodesForStatusCode returns the share-manager node if it has the given code.
+func (s *shareManagerNodeStatus) NodesForStatusCode(nodeLister framework.NodeInfoLister, code framework.Code) ([]*framework.NodeInfo, error) {
+	nodes, err := s.NodeToStatusReader.NodesForStatusCode(nodeLister, code)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		if n.Node() != nil && n.Node().Name == s.node {
+			return []*framework.NodeInfo{n}, nil
+		}
+	}
+	return nil, nil
+}
+
+// shareManagerPreemption is the preemption.Interface used by PostFilter. It
+// reuses DefaultPreemption for eligibility checks and victim selection, but
+// evaluates every candidate (there is only the share-manager node) and
+// rejects any victim set that violates a PodDisruptionBudget.
+type shareManagerPreemption struct {
+	*defaultpreemption.DefaultPreemption
+}
+
+var _ preemption.Interface = &shareManagerPreemption{}
+
+// newShareManagerPreemptor builds the preemption evaluator used by PostFilter.
+func newShareManagerPreemptor(ctx context.Context, h framework.Handle) (*preemption.Evaluator, error) {
+	dpArgs := &config.DefaultPreemptionArgs{
+		MinCandidateNodesPercentage: 10,
+		MinCandidateNodesAbsolute:   100,
+	}
+	dp, err := defaultpreemption.New(ctx, dpArgs, h, feature.Features{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create preemption evaluator: %w", err)
+	}
+
+	ev := preemption.NewEvaluator(Name, h, &shareManagerPreemption{DefaultPreemption: dp.(*defaultpreemption.DefaultPreemption)}, false)
+	ev.PluginName = Name
+	return ev, nil
+}
+
+// GetOffsetAndNumCandidates evaluates all potential nodes, from the first.
+func (s *shareManagerPreemption) GetOffsetAndNumCandidates(nodes int32) (int32, int32) {
+	return 0, nodes
+}
+
+// SelectVictimsOnNode selects victims like DefaultPreemption, but fails if any
+// of them is protected by a PodDisruptionBudget.
+func (s *shareManagerPreemption) SelectVictimsOnNode(
+	ctx context.Context,
+	state *framework.CycleState,
+	pod *corev1.Pod,
+	nodeInfo *framework.NodeInfo,
+	pdbs []*policy.PodDisruptionBudget,
+) ([]*corev1.Pod, int, *framework.Status) {
+	victims, numViolating, status := s.DefaultPreemption.SelectVictimsOnNode(ctx, state, pod, nodeInfo, pdbs)
+	if !status.IsSuccess() {
+		return nil, 0, status
+	}
+	if numViolating > 0 {
+		return nil, 0, framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("preemption on share-manager node %q would violate %d PodDisruptionBudget(s)", nodeInfo.Node().Name, numViolating))
+	}
+	return victims, 0, nil
+}
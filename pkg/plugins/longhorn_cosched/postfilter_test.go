@@ -0,0 +1,146 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+func TestPostFilter(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+		otherNode   = "node-1"
+	)
+
+	// makeVictim creates a low-priority pod using 2 of the node's 4 CPUs.
+	makeVictim := func(name, nodeName string, priority int32) *corev1.Pod {
+		p := withPriority(withCPU(makeRunningPod(name, vmNamespace, nodeName), "2"), priority)
+		p.Labels = map[string]string{"app": name}
+		return p
+	}
+
+	// makePDB creates a PodDisruptionBudget that allows no disruptions for
+	// pods labeled app=<app>.
+	makePDB := func(app string) *policy.PodDisruptionBudget {
+		return &policy.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: app, Namespace: vmNamespace},
+			Spec: policy.PodDisruptionBudgetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+			},
+			Status: policy.PodDisruptionBudgetStatus{DisruptionsAllowed: 0},
+		}
+	}
+
+	makeVMWithMode := func(mode Mode) *corev1.Pod {
+		p := withPriority(withCPU(makeVM("vm", vmNamespace, true, pvcName), "2"), 100)
+		p.UID = "uid-vm"
+		p.Annotations[AnnotationKey] = string(mode)
+		return p
+	}
+
+	tests := []struct {
+		name          string
+		pod           *corev1.Pod
+		pdbs          []runtime.Object
+		wantNominated string
+		wantSuccess   bool
+		wantEvicted   []string
+	}{
+		{
+			name:          "hard mode — lowest-priority pod on share-manager node is preempted",
+			pod:           makeVMWithMode(ModeHard),
+			wantNominated: targetNode,
+			wantSuccess:   true,
+			wantEvicted:   []string{"sm-low"},
+		},
+		{
+			name:        "hard mode — PDB protects every victim, no nomination",
+			pod:         makeVMWithMode(ModeHard),
+			pdbs:        []runtime.Object{makePDB("sm-low"), makePDB("sm-mid")},
+			wantSuccess: false,
+		},
+		{
+			name:          "hard mode — PDB protects lowest-priority pod, next one is preempted",
+			pod:           makeVMWithMode(ModeHard),
+			pdbs:          []runtime.Object{makePDB("sm-low")},
+			wantNominated: targetNode,
+			wantSuccess:   true,
+			wantEvicted:   []string{"sm-mid"},
+		},
+		{
+			name:        "soft mode — PostFilter does nothing",
+			pod:         makeVMWithMode(ModeSoft),
+			wantSuccess: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodePods := []*corev1.Pod{
+				makeVictim("sm-low", targetNode, 10),
+				makeVictim("sm-mid", targetNode, 20),
+				// The other node is full of even lower-priority pods; it must
+				// never be considered for preemption.
+				withPriority(withCPU(makeRunningPod("other-low", vmNamespace, otherNode), "4"), 0),
+			}
+			objects := append([]runtime.Object{
+				tt.pod,
+				makePVC(pvcName, vmNamespace, pvName),
+				makeShareManagerPod(pvName, targetNode),
+			}, tt.pdbs...)
+
+			fwk, plugin, clientset := newTestFramework(t, testCluster{
+				nodes:   []*corev1.Node{makeNode(otherNode, "4"), makeNode(targetNode, "4")},
+				pods:    nodePods,
+				objects: objects,
+			})
+
+			state, m := runFilters(t, fwk, tt.pod)
+			result, status := plugin.PostFilter(context.Background(), state, tt.pod, m)
+
+			if status.IsSuccess() != tt.wantSuccess {
+				t.Fatalf("PostFilter() status = %v, want success %v", status, tt.wantSuccess)
+			}
+			if tt.wantSuccess {
+				if result == nil || result.NominatedNodeName != tt.wantNominated {
+					t.Errorf("PostFilter() nominated = %v, want %q", result, tt.wantNominated)
+				}
+			}
+
+			evicted := map[string]bool{}
+			for _, name := range tt.wantEvicted {
+				evicted[name] = true
+			}
+			for _, p := range nodePods {
+				_, err := clientset.CoreV1().Pods(p.Namespace).Get(context.Background(), p.Name, metav1.GetOptions{})
+				gone := apierrors.IsNotFound(err)
+				if gone != evicted[p.Name] {
+					t.Errorf("pod %s evicted = %v, want %v", p.Name, gone, evicted[p.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestPostFilterNoShareManager(t *testing.T) {
+	pod := withPriority(withCPU(makeVM("vm", "default", true, "my-rwx-pvc"), "2"), 100)
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "1")},
+		objects: []runtime.Object{pod},
+	})
+	state, m := runFilters(t, fwk, pod)
+
+	_, status := plugin.PostFilter(context.Background(), state, pod, m)
+	if status.Code() != framework.Unschedulable {
+		t.Errorf("PostFilter() code = %v, want Unschedulable", status.Code())
+	}
+}
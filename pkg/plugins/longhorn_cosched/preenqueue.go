@@ -0,0 +1,266 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/util"
+)
+
+// shareManagerEventResource returns the ShareManager CRD in the
+// <resource>.<version>.<group> form the scheduler uses to register event
+// handlers for custom resources.
+func shareManagerEventResource(gvr schema.GroupVersionResource) framework.EventResource {
+	return framework.EventResource(gvr.Resource + "." + gvr.Version + "." + gvr.Group)
+}
+
+// PreEnqueue implements the PreEnqueuePlugin interface.
+//
+// Pods that opted in to co-scheduling and also carry the wait-for-storage
+// annotation are kept out of the active queue until every RWX PVC they
+// reference is Bound. If the WaitForShareManager arg is set, each bound RWX
+// volume must additionally have a ShareManager with an assigned node.
+//
+// All other pods pass immediately. PreEnqueue runs on every pod add/update
+// event, so the checks only read from the informer cache, except for the
+// optional ShareManager lookup. If the cluster serves no known version of the
+// ShareManager CRD, WaitForShareManager has no effect.
+func (p *Plugin) PreEnqueue(ctx context.Context, pod *corev1.Pod) (status *framework.Status) {
+	defer func(start time.Time) { observeExtensionPoint(extensionPointPreEnqueue, start, status) }(time.Now())
+
+	if !waitsForStorage(pod) || p.isMigrationTargetPod(ctx, pod) || !p.isOptedIn(ctx, pod) {
+		return nil
+	}
+
+	reason, err := storageWaitReason(ctx, p.clientset, p.dynClient, pod, p.lookupOptions(pod), p.getPVC, p.args.WaitForShareManager)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	if reason != "" {
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, reason)
+	}
+	return nil
+}
+
+// StorageReady returns why the pod's storage is not ready yet, or "" if it
+// is: every RWX PVC it mounts exists and is Bound, and each Longhorn one has
+// a ShareManager with an assigned node. It is the check PreEnqueue runs with
+// WaitForShareManager, for use outside the scheduler, e.g. by the
+// storage-gate controller. If Longhorn is not installed, the share-managers
+// are waited for forever; the caller must time out.
+func StorageReady(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pod *corev1.Pod, config LookupConfig) (reason string, err error) {
+	getPVC := func(ctx context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+		return clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	return storageWaitReason(ctx, clientset, dynClient, pod, config.lookupOptions(), getPVC, true)
+}
+
+// storageWaitReason returns why the pod has to wait for its storage, or "" if
+// it does not. PVCs are read through getPVC. Without waitForShareManager, or
+// a dynamic client, only the RWX PVCs have to be Bound.
+func storageWaitReason(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pod *corev1.Pod,
+	opts lookupOptions, getPVC func(context.Context, string, string) (*corev1.PersistentVolumeClaim, error), waitForShareManager bool) (string, error) {
+	podKey := klog.KObj(pod)
+	for _, pvcName := range collectPVCNames(pod) {
+		pvc, err := getPVC(ctx, pod.Namespace, pvcName)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				klog.V(4).InfoS("LonghornCoSchedule: PVC not found, waiting for storage", "pod", podKey, "pvc", pvcName)
+				return fmt.Sprintf("waiting for PVC %q to be created", pvcName), nil
+			}
+			return "", fmt.Errorf("getting PVC %q: %w", pvcName, err)
+		}
+
+		if !IsRWX(pvc) {
+			continue
+		}
+
+		if pvc.Status.Phase != corev1.ClaimBound || pvc.Spec.VolumeName == "" {
+			klog.V(4).InfoS("LonghornCoSchedule: RWX PVC not bound, waiting for storage", "pod", podKey, "pvc", pvcName)
+			return fmt.Sprintf("waiting for RWX PVC %q to be bound", pvcName), nil
+		}
+
+		if !waitForShareManager || dynClient == nil {
+			continue
+		}
+		if ok, driver := isLonghornVolume(ctx, clientset, pvc, opts); !ok {
+			// No share-manager will ever be assigned.
+			klog.V(5).InfoS("LonghornCoSchedule: RWX PVC not provisioned by Longhorn, not waiting for a share-manager",
+				"pod", podKey, "pvc", pvcName, "driver", driver)
+			continue
+		}
+
+		if migratableVolume(ctx, clientset, pvc, opts) != nil {
+			// Migratable block volumes never get a share-manager.
+			continue
+		}
+
+		gvr, served := opts.api.resource()
+		if !served {
+			// Without the CRD there is nothing to wait for; the pod would
+			// stay gated forever.
+			continue
+		}
+		states := opts.shareManagerStates(ctx, dynClient, pvc.Spec.VolumeName)
+		node, _, _, err := getShareManagerNodeFromCRD(ctx, dynClient, gvr, opts.longhornNamespace(), pvc.Spec.VolumeName, states)
+		opts.api.observe(err)
+		var parseErr *shareManagerParseError
+		if errors.As(err, &parseErr) {
+			// Malformed ShareManager, already logged: keep waiting for
+			// Longhorn to write a usable status.
+			node, err = "", nil
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("getting ShareManager %q: %w", pvc.Spec.VolumeName, err)
+		}
+		if node == "" {
+			klog.V(4).InfoS("LonghornCoSchedule: share-manager not assigned, waiting for storage",
+				"pod", podKey,
+				"pvc", pvcName,
+				"pv", pvc.Spec.VolumeName,
+			)
+			return fmt.Sprintf("waiting for Longhorn to assign a share-manager for PVC %q", pvcName), nil
+		}
+	}
+	return "", nil
+}
+
+// EventsToRegister implements the EnqueueExtensions interface.
+//
+// Pods gated by PreEnqueue or rejected by Filter can become schedulable when
+// one of their PVCs is created or bound, when the share-manager of one of
+// their volumes is assigned, recreated or moves, or when a node changes,
+// e.g. the share-manager node becomes Ready again or is uncordoned.
+// ShareManager events are only registered when the CRD is served, because
+// registering them makes the scheduler start an informer that requires it to
+// exist; they use the version discovered at startup.
+//
+// With RequireCSIPlugin set, pods are also requeued when a CSI plugin pod
+// becomes Ready, and with RequireInstanceManager when an instance-manager pod
+// starts Running.
+func (p *Plugin) EventsToRegister(_ context.Context) ([]framework.ClusterEventWithHint, error) {
+	events := []framework.ClusterEventWithHint{
+		{
+			Event:          framework.ClusterEvent{Resource: framework.PersistentVolumeClaim, ActionType: framework.Add | framework.Update},
+			QueueingHintFn: p.isSchedulableAfterPVCChange,
+		},
+		{
+			Event:          framework.ClusterEvent{Resource: framework.Pod, ActionType: framework.Add | framework.Update},
+			QueueingHintFn: p.isSchedulableAfterShareManagerPodChange,
+		},
+		{
+			Event: framework.ClusterEvent{Resource: framework.Node, ActionType: framework.Update},
+		},
+	}
+	if gvr, served := p.shareManagers.resource(); served {
+		events = append(events, framework.ClusterEventWithHint{
+			Event:          framework.ClusterEvent{Resource: shareManagerEventResource(gvr), ActionType: framework.Add | framework.Update},
+			QueueingHintFn: p.isSchedulableAfterShareManagerChange,
+		})
+	}
+	if p.csiPlugins != nil {
+		events = append(events, framework.ClusterEventWithHint{
+			Event:          framework.ClusterEvent{Resource: framework.Pod, ActionType: framework.Add | framework.Update},
+			QueueingHintFn: p.isSchedulableAfterCSIPluginChange,
+		})
+	}
+	if p.instanceManagers != nil {
+		events = append(events, framework.ClusterEventWithHint{
+			Event:          framework.ClusterEvent{Resource: framework.Pod, ActionType: framework.Add | framework.Update},
+			QueueingHintFn: p.isSchedulableAfterInstanceManagerChange,
+		})
+	}
+	return events, nil
+}
+
+// isSchedulableAfterPVCChange requeues the pod only when the changed PVC is
+// one of the claims it references.
+func (p *Plugin) isSchedulableAfterPVCChange(logger klog.Logger, pod *corev1.Pod, oldObj, newObj interface{}) (framework.QueueingHint, error) {
+	_, pvc, err := util.As[*corev1.PersistentVolumeClaim](oldObj, newObj)
+	if err != nil {
+		return framework.Queue, err
+	}
+
+	if pvc.Namespace != pod.Namespace {
+		return framework.QueueSkip, nil
+	}
+	for _, name := range collectPVCNames(pod) {
+		if name == pvc.Name {
+			logger.V(5).Info("PVC referenced by pod changed, requeueing", "pod", klog.KObj(pod), "pvc", klog.KObj(pvc))
+			return framework.Queue, nil
+		}
+	}
+	return framework.QueueSkip, nil
+}
+
+// isSchedulableAfterShareManagerPodChange requeues the pod when the
+// share-manager pod of one of its volumes is created, e.g. after a failover
+// or a relocation, or changes node, phase or readiness.
+func (p *Plugin) isSchedulableAfterShareManagerPodChange(logger klog.Logger, pod *corev1.Pod, oldObj, newObj interface{}) (framework.QueueingHint, error) {
+	oldSM, sm, err := util.As[*corev1.Pod](oldObj, newObj)
+	if err != nil {
+		return framework.Queue, err
+	}
+	if !p.isLonghornNamespace(sm.Namespace) || !isShareManagerPod(sm) {
+		return framework.QueueSkip, nil
+	}
+	if oldSM != nil && oldSM.Spec.NodeName == sm.Spec.NodeName && oldSM.Status.Phase == sm.Status.Phase && isPodReady(oldSM) == isPodReady(sm) {
+		return framework.QueueSkip, nil
+	}
+	for _, pvName := range p.boundPVNames(pod) {
+		if isShareManagerPodFor(sm, pvName) || sm.Name == ShareManagerPrefix+pvName {
+			logger.V(5).Info("share-manager pod of the pod's volume changed, requeueing", "pod", klog.KObj(pod), "shareManager", klog.KObj(sm), "node", sm.Spec.NodeName)
+			return framework.Queue, nil
+		}
+	}
+	return framework.QueueSkip, nil
+}
+
+// isSchedulableAfterShareManagerChange requeues the pod when the
+// ShareManager of one of its volumes changes.
+func (p *Plugin) isSchedulableAfterShareManagerChange(logger klog.Logger, pod *corev1.Pod, oldObj, newObj interface{}) (framework.QueueingHint, error) {
+	_, sm, err := util.As[*unstructured.Unstructured](oldObj, newObj)
+	if err != nil {
+		return framework.Queue, err
+	}
+	if !p.isLonghornNamespace(sm.GetNamespace()) || !slices.Contains(p.boundPVNames(pod), sm.GetName()) {
+		return framework.QueueSkip, nil
+	}
+	logger.V(5).Info("ShareManager of the pod's volume changed, requeueing", "pod", klog.KObj(pod), "shareManager", klog.KObj(sm))
+	return framework.Queue, nil
+}
+
+// boundPVNames returns the PVs bound to the pod's PVCs. PVCs that cannot be
+// read or are not bound are left out.
+func (p *Plugin) boundPVNames(pod *corev1.Pod) []string {
+	var names []string
+	for _, pvcName := range collectPVCNames(pod) {
+		pvc, err := p.getPVC(context.Background(), pod.Namespace, pvcName)
+		if err != nil || pvc.Spec.VolumeName == "" {
+			continue
+		}
+		names = append(names, pvc.Spec.VolumeName)
+	}
+	return names
+}
+
+// getPVC returns the named PVC, reading from the informer cache when a lister
+// is available and falling back to a live GET otherwise.
+func (p *Plugin) getPVC(ctx context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+	if p.pvcLister != nil {
+		return p.pvcLister.PersistentVolumeClaims(namespace).Get(name)
+	}
+	return p.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+}
@@ -0,0 +1,439 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeShareManagerCR creates an unstructured Longhorn ShareManager named after
+// the PV, with the given status.ownerID and status.state.
+func makeShareManagerCR(pvName, ownerID, state string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(shareManagerGVR.Group + "/" + shareManagerGVR.Version)
+	obj.SetKind("ShareManager")
+	obj.SetNamespace(LonghornNamespace)
+	obj.SetName(pvName)
+	obj.Object["status"] = map[string]interface{}{
+		"ownerID": ownerID,
+		"state":   state,
+	}
+	return obj
+}
+
+// newDynamicClient returns a fake dynamic client that serves the Longhorn
+// resources in every known version, VirtualMachineInstances,
+// VirtualMachineInstanceMigrations and CoScheduleDecisions.
+func newDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, version := range shareManagerVersions {
+		listKinds[schema.GroupVersionResource{Group: shareManagerGVR.Group, Version: version, Resource: shareManagerGVR.Resource}] = "ShareManagerList"
+		listKinds[schema.GroupVersionResource{Group: shareManagerGVR.Group, Version: version, Resource: longhornNodeResource}] = "NodeList"
+		listKinds[schema.GroupVersionResource{Group: shareManagerGVR.Group, Version: version, Resource: longhornReplicaResource}] = "ReplicaList"
+		listKinds[schema.GroupVersionResource{Group: shareManagerGVR.Group, Version: version, Resource: longhornVolumeResource}] = "VolumeList"
+	}
+	listKinds[vmiGVR] = "VirtualMachineInstanceList"
+	listKinds[vmimGVR] = "VirtualMachineInstanceMigrationList"
+	listKinds[CoScheduleDecisionResource] = "CoScheduleDecisionList"
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+}
+
+// makeWaitingVM creates an opted-in VM pod that also asks to wait for storage.
+func makeWaitingVM(name, namespace string, pvcNames ...string) *corev1.Pod {
+	pod := makeVM(name, namespace, true, pvcNames...)
+	pod.Annotations[WaitForStorageAnnotationKey] = AnnotationValue
+	return pod
+}
+
+func TestPreEnqueue(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+	)
+
+	unboundPVC := makePVC(pvcName, vmNamespace, "")
+	unboundPVC.Status.Phase = corev1.ClaimPending
+
+	migrationTarget := makeMigrationTargetVM("vm", vmNamespace, pvcName)
+	migrationTarget.Annotations[WaitForStorageAnnotationKey] = AnnotationValue
+
+	tests := []struct {
+		name                string
+		pod                 *corev1.Pod
+		objects             []runtime.Object
+		dynObjects          []runtime.Object
+		waitForShareManager bool
+		wantGated           bool
+	}{
+		{
+			name:      "pod without wait-for-storage annotation is never gated",
+			pod:       makeVM("vm", vmNamespace, true, pvcName),
+			objects:   []runtime.Object{unboundPVC},
+			wantGated: false,
+		},
+		{
+			name:      "PVC does not exist yet — gated",
+			pod:       makeWaitingVM("vm", vmNamespace, pvcName),
+			wantGated: true,
+		},
+		{
+			name:      "RWX PVC unbound — gated",
+			pod:       makeWaitingVM("vm", vmNamespace, pvcName),
+			objects:   []runtime.Object{unboundPVC},
+			wantGated: true,
+		},
+		{
+			name:      "RWX PVC bound — ungated",
+			pod:       makeWaitingVM("vm", vmNamespace, pvcName),
+			objects:   []runtime.Object{makePVC(pvcName, vmNamespace, pvName)},
+			wantGated: false,
+		},
+		{
+			name:                "bound, waiting for share-manager, none assigned — gated",
+			pod:                 makeWaitingVM("vm", vmNamespace, pvcName),
+			objects:             []runtime.Object{makePVC(pvcName, vmNamespace, pvName)},
+			waitForShareManager: true,
+			wantGated:           true,
+		},
+		{
+			name:                "bound, waiting for share-manager, stopped share-manager — gated",
+			pod:                 makeWaitingVM("vm", vmNamespace, pvcName),
+			objects:             []runtime.Object{makePVC(pvcName, vmNamespace, pvName)},
+			dynObjects:          []runtime.Object{makeShareManagerCR(pvName, "", "stopped")},
+			waitForShareManager: true,
+			wantGated:           true,
+		},
+		{
+			name:                "bound, waiting for share-manager, share-manager assigned — ungated",
+			pod:                 makeWaitingVM("vm", vmNamespace, pvcName),
+			objects:             []runtime.Object{makePVC(pvcName, vmNamespace, pvName)},
+			dynObjects:          []runtime.Object{makeShareManagerCR(pvName, targetNode, "starting")},
+			waitForShareManager: true,
+			wantGated:           false,
+		},
+		{
+			name:      "migration target is never gated",
+			pod:       migrationTarget,
+			objects:   []runtime.Object{unboundPVC},
+			wantGated: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &Plugin{
+				clientset: fake.NewSimpleClientset(tt.objects...),
+				dynClient: newDynamicClient(tt.dynObjects...),
+				args:      Args{WaitForShareManager: tt.waitForShareManager},
+			}
+			status := plugin.PreEnqueue(context.Background(), tt.pod)
+			gated := !status.IsSuccess()
+			if gated != tt.wantGated {
+				t.Errorf("PreEnqueue() gated = %v, want %v (status: %v)", gated, tt.wantGated, status)
+			}
+			if gated && status.Code() != framework.UnschedulableAndUnresolvable {
+				t.Errorf("PreEnqueue() code = %v, want UnschedulableAndUnresolvable", status.Code())
+			}
+		})
+	}
+}
+
+// TestPreEnqueueTransition walks a pod from gated to ungated as its PVC binds.
+func TestPreEnqueueTransition(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	pvc := makePVC(pvcName, vmNamespace, "")
+	pvc.Status.Phase = corev1.ClaimPending
+	clientset := fake.NewSimpleClientset(pvc)
+	plugin := &Plugin{clientset: clientset}
+	pod := makeWaitingVM("vm", vmNamespace, pvcName)
+
+	if status := plugin.PreEnqueue(context.Background(), pod); status.IsSuccess() {
+		t.Fatalf("PreEnqueue() should gate the pod while the PVC is pending")
+	}
+
+	bound := makePVC(pvcName, vmNamespace, pvName)
+	hint, err := plugin.isSchedulableAfterPVCChange(klog.Background(), pod, pvc, bound)
+	if err != nil {
+		t.Fatalf("isSchedulableAfterPVCChange() error = %v", err)
+	}
+	if hint != framework.Queue {
+		t.Errorf("isSchedulableAfterPVCChange() = %v, want Queue", hint)
+	}
+
+	if _, err := clientset.CoreV1().PersistentVolumeClaims(vmNamespace).Update(context.Background(), bound, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating PVC: %v", err)
+	}
+
+	if status := plugin.PreEnqueue(context.Background(), pod); !status.IsSuccess() {
+		t.Errorf("PreEnqueue() should ungate the pod once the PVC is bound, got %v", status)
+	}
+}
+
+// TestStorageReadyGate walks a pod gated by StorageReadyGate through the
+// controller's readiness check until it is ungated, then schedules it next to
+// its share-manager.
+func TestStorageReadyGate(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+	)
+	ctx := context.Background()
+	pod := makeVM("vm", vmNamespace, true, pvcName)
+	pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{{Name: StorageReadyGate}}
+	pvc := makePVC(pvcName, vmNamespace, pvName)
+	clientset := fake.NewSimpleClientset(pvc)
+	dynClient := newDynamicClient()
+
+	reason, err := StorageReady(ctx, clientset, dynClient, pod, LookupConfig{})
+	if err != nil {
+		t.Fatalf("StorageReady() error = %v", err)
+	}
+	if reason == "" {
+		t.Fatal("StorageReady() = ready, want the pod to stay gated until Longhorn assigns a share-manager")
+	}
+
+	cr := makeShareManagerCR(pvName, targetNode, "running")
+	if _, err := dynClient.Resource(shareManagerGVR).Namespace(LonghornNamespace).Create(ctx, cr, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating ShareManager: %v", err)
+	}
+	if reason, err = StorageReady(ctx, clientset, dynClient, pod, LookupConfig{}); err != nil || reason != "" {
+		t.Fatalf("StorageReady() = %q, %v, want ready", reason, err)
+	}
+
+	pod.Spec.SchedulingGates = nil
+	fwk, _, _ := newTestFramework(t, testCluster{
+		nodes:      []*corev1.Node{makeNode("node-1", "4"), makeNode(targetNode, "4")},
+		objects:    []runtime.Object{pod, pvc, makeShareManagerPod(pvName, targetNode)},
+		dynObjects: []runtime.Object{cr},
+	})
+	_, m := runFilters(t, fwk, pod)
+	var filtered []string
+	m.ForEachExplicitNode(func(node string, _ *framework.Status) { filtered = append(filtered, node) })
+	if len(filtered) != 1 || filtered[0] != "node-1" {
+		t.Errorf("nodes filtered out = %v, want only node-1", filtered)
+	}
+}
+
+func TestIsSchedulableAfterPVCChange(t *testing.T) {
+	pod := makeWaitingVM("vm", "default", "my-rwx-pvc")
+	plugin := &Plugin{}
+
+	tests := []struct {
+		name string
+		pvc  *corev1.PersistentVolumeClaim
+		want framework.QueueingHint
+	}{
+		{
+			name: "referenced PVC",
+			pvc:  makePVC("my-rwx-pvc", "default", "pv"),
+			want: framework.Queue,
+		},
+		{
+			name: "unrelated PVC in same namespace",
+			pvc:  makePVC("other", "default", "pv"),
+			want: framework.QueueSkip,
+		},
+		{
+			name: "same name in another namespace",
+			pvc:  makePVC("my-rwx-pvc", "other", "pv"),
+			want: framework.QueueSkip,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := plugin.isSchedulableAfterPVCChange(klog.Background(), pod, nil, tt.pvc)
+			if err != nil {
+				t.Fatalf("isSchedulableAfterPVCChange() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isSchedulableAfterPVCChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSchedulableAfterShareManagerPodChange(t *testing.T) {
+	const (
+		pvcName = "my-rwx-pvc"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	pod := makeVM("vm", "default", true, pvcName)
+	plugin := &Plugin{clientset: fake.NewSimpleClientset(makePVC(pvcName, "default", pvName))}
+	running := makeShareManagerPod(pvName, "node-1")
+
+	tests := []struct {
+		name     string
+		old, new *corev1.Pod
+		want     framework.QueueingHint
+	}{
+		{
+			name: "share-manager pod of the pod's volume created",
+			new:  running,
+			want: framework.Queue,
+		},
+		{
+			name: "share-manager pod found only by its label",
+			new: func() *corev1.Pod {
+				p := makeShareManagerPod(pvName, "node-1")
+				p.Name = "sm-" + pvName
+				return p
+			}(),
+			want: framework.Queue,
+		},
+		{
+			name: "share-manager pod moved to another node",
+			old:  running,
+			new:  makeShareManagerPod(pvName, "node-2"),
+			want: framework.Queue,
+		},
+		{
+			name: "share-manager pod updated in place",
+			old:  running,
+			new: func() *corev1.Pod {
+				p := running.DeepCopy()
+				p.Labels["unrelated"] = "true"
+				return p
+			}(),
+			want: framework.QueueSkip,
+		},
+		{
+			name: "share-manager pod of another volume",
+			new:  makeShareManagerPod("pvc-other", "node-1"),
+			want: framework.QueueSkip,
+		},
+		{
+			name: "other pod in the Longhorn namespace",
+			new:  makeRunningPod("instance-manager", LonghornNamespace, "node-1"),
+			want: framework.QueueSkip,
+		},
+		{
+			name: "share-manager-named pod elsewhere",
+			new:  makeRunningPod(ShareManagerPrefix+pvName, "default", "node-1"),
+			want: framework.QueueSkip,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var old interface{}
+			if tt.old != nil {
+				old = tt.old
+			}
+			got, err := plugin.isSchedulableAfterShareManagerPodChange(klog.Background(), pod, old, tt.new)
+			if err != nil {
+				t.Fatalf("isSchedulableAfterShareManagerPodChange() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isSchedulableAfterShareManagerPodChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSchedulableAfterShareManagerChange(t *testing.T) {
+	const (
+		pvcName = "my-rwx-pvc"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	pod := makeVM("vm", "default", true, pvcName)
+	plugin := &Plugin{clientset: fake.NewSimpleClientset(makePVC(pvcName, "default", pvName))}
+	elsewhere := makeShareManagerCR(pvName, "node-1", "running")
+	elsewhere.SetNamespace("default")
+
+	for _, tt := range []struct {
+		name string
+		sm   *unstructured.Unstructured
+		want framework.QueueingHint
+	}{
+		{name: "ShareManager of the pod's volume", sm: makeShareManagerCR(pvName, "node-1", "running"), want: framework.Queue},
+		{name: "ShareManager of another volume", sm: makeShareManagerCR("pvc-other", "node-1", "running"), want: framework.QueueSkip},
+		{name: "ShareManager outside the Longhorn namespace", sm: elsewhere, want: framework.QueueSkip},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := plugin.isSchedulableAfterShareManagerChange(klog.Background(), pod, nil, tt.sm)
+			if err != nil {
+				t.Fatalf("isSchedulableAfterShareManagerChange() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isSchedulableAfterShareManagerChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRequeueAfterShareManagerRecreated checks that a hard-mode pod rejected
+// by Filter is requeued when its share-manager pod is recreated on another
+// node, whatever the args, and then passes Filter there.
+func TestRequeueAfterShareManagerRecreated(t *testing.T) {
+	const (
+		pvcName = "my-rwx-pvc"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	pod := makeVM("vm", "default", true, pvcName)
+	old := makeShareManagerPod(pvName, "node-1")
+	fwk, plugin, clientset := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+		objects: []runtime.Object{pod, makePVC(pvcName, "default", pvName), old},
+	})
+
+	if _, m := runFilters(t, fwk, pod); m.Len() != 1 {
+		t.Fatalf("Filter rejected %d nodes, want node-2 only", m.Len())
+	}
+
+	if err := clientset.CoreV1().Pods(LonghornNamespace).Delete(context.Background(), old.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("deleting share-manager pod: %v", err)
+	}
+	recreated := makeShareManagerPod(pvName, "node-2")
+	recreated.CreationTimestamp = metav1.NewTime(time.Now())
+	if _, err := clientset.CoreV1().Pods(LonghornNamespace).Create(context.Background(), recreated, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating share-manager pod: %v", err)
+	}
+
+	events, err := plugin.EventsToRegister(context.Background())
+	if err != nil {
+		t.Fatalf("EventsToRegister() error = %v", err)
+	}
+	queued := false
+	for _, event := range events {
+		if event.Event.Resource != framework.Pod || event.Event.ActionType&framework.Add == 0 || event.QueueingHintFn == nil {
+			continue
+		}
+		hint, err := event.QueueingHintFn(klog.Background(), pod, nil, recreated)
+		if err != nil {
+			t.Fatalf("queueing hint error = %v", err)
+		}
+		queued = queued || hint == framework.Queue
+	}
+	if !queued {
+		t.Fatalf("recreated share-manager pod does not requeue the pod")
+	}
+
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		smPod, err := newestShareManagerPod(context.Background(), clientset, pvName, plugin.lookupOptions(pod))
+		return smPod != nil && smPod.Spec.NodeName == "node-2", err
+	}); err != nil {
+		t.Fatalf("recreated share-manager pod not cached: %v", err)
+	}
+	if _, m := runFilters(t, fwk, pod); m.Len() != 1 || m.Get("node-1").Message() == "" {
+		t.Errorf("Filter rejected %d nodes after the share-manager moved to node-2, want node-1 only", m.Len())
+	}
+}
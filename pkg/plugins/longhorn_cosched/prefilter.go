@@ -0,0 +1,484 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// stateKey is the CycleState key under which PreFilter stores its result.
+const stateKey framework.StateKey = Name
+
+const (
+	// notReadyReason is the reason of the event emitted when a share-manager
+	// is ignored by the filter because it is not Ready.
+	notReadyReason = "ShareManagerNotReady"
+
+	// terminatingPVCReason is the reason of the event emitted when a PVC is
+	// ignored because it is being deleted.
+	terminatingPVCReason = "PVCTerminating"
+
+	// lookupFailedReason is the reason of the event emitted when the
+	// strictErrors arg fails a scheduling cycle on a lookup error.
+	lookupFailedReason = "ShareManagerLookupFailed"
+
+	// crdFallbackReason is the reason of the event emitted when a
+	// share-manager was found from its pod because reading the ShareManager
+	// CRD failed.
+	crdFallbackReason = "ShareManagerCRDUnreadable"
+)
+
+// stateData is the result of the share-manager lookup for one scheduling
+// cycle. PreFilter computes it once so Filter, PostFilter and Score do not
+// repeat the API lookups for every node.
+type stateData struct {
+	// mode is the co-scheduling mode requested by the pod, or empty if the
+	// plugin does not apply to it.
+	mode Mode
+
+	// shareManagerNode is the node the pod should be co-located with, or
+	// empty if no share-manager was found or the conflict policy disabled
+	// the hard filter.
+	shareManagerNode string
+
+	// placements are the share-managers found for the pod's RWX PVCs.
+	placements []shareManagerPlacement
+
+	// nodeCounts maps each node to the number of the pod's share-managers
+	// on it.
+	nodeCounts map[string]int
+
+	// nodeScores maps each node to the sum of placementScore over the pod's
+	// share-managers on it.
+	nodeScores map[string]int64
+
+	// policy is the conflict policy applied to placements.
+	policy ConflictPolicy
+
+	// conflictNodes lists the distinct share-manager nodes, sorted, when
+	// the pod's share-managers are on more than one node.
+	conflictNodes []string
+
+	// unresolvable is set when the conflict policy makes the pod
+	// unschedulable on every node.
+	unresolvable bool
+
+	// waitingFor explains why the pod has to wait for one of its PVCs, as
+	// decided by the DataVolume or unbound PVC policy named in
+	// waitingPolicy. The pod is unschedulable while it is set.
+	waitingFor    string
+	waitingPolicy string
+
+	// fallback explains why the hard filter was relaxed for this cycle even
+	// though a share-manager node was found, e.g. because that node is
+	// cordoned. The node is then moved from shareManagerNode to fallbackNode,
+	// so every node passes Filter while Score still prefers it.
+	fallback     string
+	fallbackNode string
+
+	// fallbackReason is the reason of the event emitted for fallback.
+	fallbackReason string
+
+	// nodeConflict explains why the share-manager node itself cannot take
+	// the pod, e.g. a taint the pod does not tolerate, when the pod stays
+	// pinned to it anyway. Filter names it in its status messages.
+	nodeConflict string
+
+	// terminatingPVCs are the pod's RWX PVCs that are being deleted, and so
+	// were ignored. They are only collected for hard-mode pods.
+	terminatingPVCs []string
+
+	// migrationSource is the node of the running virt-launcher pod of the
+	// same VMI, for a live-migration target the migration target policy
+	// co-schedules. Score never prefers it, and in hard mode Filter rejects
+	// it.
+	migrationSource string
+
+	// previousNode is the node of the VM's previous virt-launcher pod, found
+	// with PreferPreviousNode when the pod has no share-manager. Score
+	// prefers it.
+	previousNode string
+
+	// volumeTags are the node tags the pod's Longhorn Volumes select, read
+	// unless VolumeNodeTagPolicy is ignore. Nodes lacking one score 0 and,
+	// under VolumeNodeTagPolicyFilter, hard-mode pods reject them.
+	volumeTags []string
+
+	// vmNode is the node of the virt-launcher pod a hotplug attachment pod
+	// belongs to. It is only set for hotplug attachment pods.
+	vmNode string
+
+	// cdiTarget is the PVC a CDI importer or upload server pod populates,
+	// and replicaScores the score of each node holding a replica of its
+	// Longhorn volume. They are only set for the CDI pods
+	// PreferCDIReplicaNodes applies to.
+	cdiTarget     string
+	replicaScores map[string]int64
+
+	// replicaNodes are the nodes holding a healthy replica of one of the
+	// pod's best-effort Longhorn volumes, read when BestEffortReplicaBonus
+	// is set. Score adds the bonus to them.
+	replicaNodes map[string]bool
+
+	// pinnedVMs counts, per node, the co-scheduled pods already there, read
+	// from the snapshot when PinnedVMPenalty is set. NormalizeScore
+	// penalises the nodes by them.
+	pinnedVMs map[string]int64
+
+	// decision is the cycle's decision, and pins the placements on its
+	// ShareManagerNode that pin the pod there: share-managers of its PVCs,
+	// or the pod named by the co-schedule-with annotation. decide computes
+	// both from the fields above once PreFilter has set them.
+	decision Decision
+	pins     []shareManagerPlacement
+}
+
+// Clone implements framework.StateData. stateData is never modified after
+// PreFilter writes it, so it can be shared between clones.
+func (s *stateData) Clone() framework.StateData {
+	return s
+}
+
+// PreFilter implements the PreFilterPlugin interface.
+//
+// It resolves the share-manager node for opted-in pods once per scheduling
+// cycle and stores it in the CycleState, along with the replica nodes of the
+// pod's strict-local Longhorn volumes. Hotplug attachment pods and, with
+// PreferCDIReplicaNodes, CDI pods resolve their own nodes instead. Pods the
+// plugin does not apply to get an empty entry and a Skip status, so Filter is
+// bypassed for them.
+func (p *Plugin) PreFilter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (result *framework.PreFilterResult, status *framework.Status) {
+	defer func(start time.Time) { observeExtensionPoint(extensionPointPreFilter, start, status) }(time.Now())
+	result, status = p.preFilter(ctx, state, pod)
+	if !status.IsSuccess() && !status.IsSkip() {
+		return result, status
+	}
+	return result, p.preFilterStrictLocal(ctx, state, pod, status)
+}
+
+// preFilter is PreFilter without the strict-local lookup.
+func (p *Plugin) preFilter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	podKey := klog.KObj(pod)
+
+	// With a single node there is nothing to choose; spare the lookups.
+	if hotplugOwner(pod) != "" {
+		if p.singleNode(pod) {
+			state.Write(stateKey, &stateData{decision: skippedDecision})
+			return nil, framework.NewStatus(framework.Skip)
+		}
+		return p.preFilterHotplug(ctx, state, pod)
+	}
+	if p.coSchedulesCDIPod(pod) {
+		if p.singleNode(pod) {
+			state.Write(stateKey, &stateData{decision: skippedDecision})
+			return nil, framework.NewStatus(framework.Skip)
+		}
+		return p.preFilterCDIPod(ctx, state, pod)
+	}
+
+	p.reportInvalidAnnotation(pod)
+
+	// A pod stays counted as pending on its share-manager node only while
+	// its cycles keep pinning it; PostFilter counts it again if it still
+	// fits nowhere.
+	pinned := false
+	defer func() {
+		if !pinned {
+			p.pending.remove(pod.UID)
+		}
+	}()
+
+	if p.singleNode(pod) {
+		state.Write(stateKey, &stateData{decision: skippedDecision})
+		filterResults.WithLabelValues(filterResultSkipped, skippedDecision.Outcome).Inc()
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	// Filter is not called for skipped pods; count them once here.
+	if mode := p.mode(ctx, pod); mode == "" || p.migrationMode(ctx, pod, mode) == "" {
+		state.Write(stateKey, &stateData{decision: skippedDecision})
+		result := filterResultNotOptedIn
+		if mode != "" {
+			result = filterResultSkipped
+		}
+		filterResults.WithLabelValues(result, skippedDecision.Outcome).Inc()
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	p.reportLonghornNamespace(pod)
+	p.reportCoSchedulePVCs(pod)
+
+	data, err := p.resolve(ctx, pod)
+	if err != nil {
+		klog.ErrorS(err, "LonghornCoSchedule/PreFilter: error looking up share-manager", "pod", podKey)
+		return nil, framework.NewStatus(framework.Error, fmt.Sprintf("error looking up share-manager pod: %v", err))
+	}
+
+	p.excludeMigrationSource(pod, data)
+	p.checkShareManagerNode(pod, data)
+	data.decide(pod)
+
+	klog.V(4).InfoS("LonghornCoSchedule/PreFilter: resolved share-manager node",
+		"pod", podKey,
+		"mode", data.mode,
+		"shareManagerNode", data.shareManagerNode,
+		"decision", data.decision.Outcome,
+		"conflictPolicy", data.policy,
+		"conflictNodes", data.conflictNodes,
+		"waitingFor", data.waitingFor,
+		"fallback", data.fallback,
+		"nodeConflict", data.nodeConflict,
+	)
+	p.reportNotReady(pod, data)
+	p.reportTerminatingPVCs(pod, data)
+	p.reportCRDFallback(pod, data)
+	p.reportPinned(pod, data)
+	p.history.record(pod, data)
+	pinned = data.decision.Outcome == decisionPinned
+	state.Write(stateKey, data)
+	return nil, nil
+}
+
+// reportNotReady emits an event on a hard-mode pod for each of its
+// share-managers that is not Ready, and so does not restrict the pod.
+func (p *Plugin) reportNotReady(pod *corev1.Pod, data *stateData) {
+	if data.mode != ModeHard {
+		return
+	}
+	for _, pl := range data.placements {
+		if !pl.notReady {
+			continue
+		}
+		klog.V(2).InfoS("LonghornCoSchedule/PreFilter: share-manager not ready, not pinning pod to its node",
+			"pod", klog.KObj(pod),
+			"pvc", pl.pvc,
+			"node", pl.node,
+		)
+		p.emitWarning(pod, notReadyReason,
+			fmt.Sprintf("Share-manager of PVC %q on node %q is not Ready; not pinning the pod to that node", pl.pvc, pl.node))
+	}
+}
+
+// reportTerminatingPVCs emits an event on a hard-mode pod for each of its RWX
+// PVCs that is being deleted, so the user knows why the pod was not pinned to
+// its share-manager.
+func (p *Plugin) reportTerminatingPVCs(pod *corev1.Pod, data *stateData) {
+	for _, pvcName := range data.terminatingPVCs {
+		klog.V(2).InfoS("LonghornCoSchedule/PreFilter: PVC is being deleted, not pinning pod to its share-manager",
+			"pod", klog.KObj(pod),
+			"pvc", pvcName,
+		)
+		p.emitWarning(pod, terminatingPVCReason,
+			fmt.Sprintf("PVC %q is being deleted; not pinning the pod to its share-manager", pvcName))
+	}
+}
+
+// reportStrictError counts a lookup error that failed the scheduling cycle of
+// a hard-mode pod under the strictErrors arg, and emits an event on the pod
+// naming its cause.
+func (p *Plugin) reportStrictError(pod *corev1.Pod, err error) {
+	source := "pvc"
+	var sourceErr *sourceLookupError
+	if errors.As(err, &sourceErr) {
+		source = string(sourceErr.source)
+	}
+	strictLookupErrors.WithLabelValues(source, errorReason(err)).Inc()
+	klog.V(2).InfoS("LonghornCoSchedule/PreFilter: lookup failed, not scheduling hard-mode pod (strictErrors)",
+		"pod", klog.KObj(pod),
+		"source", source,
+		"err", err,
+	)
+	if p.handle != nil {
+		p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, lookupFailedReason, "Schedule",
+			"Not scheduling the pod until its share-manager can be looked up (strictErrors): %v", err)
+	}
+}
+
+// reportCRDFallback emits a warning event on a pod whose share-managers were
+// found from their pods because reading the ShareManager CRD failed, at most
+// once per invalidAnnotationWarningInterval per pod. Scheduling works, but
+// the CRD or the scheduler's RBAC for it needs fixing. The fallbacks are
+// counted in crdPodFallbacks by the lookup.
+func (p *Plugin) reportCRDFallback(pod *corev1.Pod, data *stateData) {
+	var pvcs []string
+	var crdErr error
+	for _, pl := range data.placements {
+		if pl.crdErr != nil {
+			pvcs = append(pvcs, pl.pvc)
+			crdErr = pl.crdErr
+		}
+	}
+	if crdErr == nil || p.handle == nil || p.warnings == nil || !p.warnings.allow(string(pod.UID)+"/"+crdFallbackReason) {
+		return
+	}
+	p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, crdFallbackReason, "Schedule",
+		"Found the share-manager of PVC %s from its pod because the ShareManager CRD could not be read (%v); check the CRD and the scheduler's RBAC",
+		strings.Join(pvcs, ", "), crdErr)
+}
+
+// terminatingPVCs returns the names of the pod's co-scheduled RWX PVCs that
+// are being deleted. PVCs that cannot be read are left out; the share-manager
+// lookup reports those.
+func (p *Plugin) terminatingPVCs(ctx context.Context, pod *corev1.Pod) []string {
+	var names []string
+	for _, pvcName := range coSchedulePVCNames(pod, p.hotplugPVCNames(ctx, pod)) {
+		pvc, err := p.getPVC(ctx, pod.Namespace, pvcName)
+		if err != nil {
+			continue
+		}
+		if pvc.DeletionTimestamp != nil && IsRWX(pvc) {
+			names = append(names, pvcName)
+		}
+	}
+	return names
+}
+
+// PreFilterExtensions returns the plugin itself. The plugin's decision does
+// not depend on the other pods on a node, so AddPod and RemovePod are no-ops
+// that keep the CycleState entry valid. This lets preemption simulate
+// evictions without repeating the share-manager lookup.
+func (p *Plugin) PreFilterExtensions() framework.PreFilterExtensions {
+	return p
+}
+
+// AddPod implements the PreFilterExtensions interface. It is a no-op.
+func (p *Plugin) AddPod(_ context.Context, _ *framework.CycleState, _ *corev1.Pod, _ *framework.PodInfo, _ *framework.NodeInfo) *framework.Status {
+	return nil
+}
+
+// RemovePod implements the PreFilterExtensions interface. It is a no-op.
+func (p *Plugin) RemovePod(_ context.Context, _ *framework.CycleState, _ *corev1.Pod, _ *framework.PodInfo, _ *framework.NodeInfo) *framework.Status {
+	return nil
+}
+
+// cycleData returns the share-manager lookup result for the pod, reusing the
+// result PreFilter stored in the CycleState when available. Without a
+// PreFilter result (e.g. when the plugin is only enabled at Score) it falls
+// back to a direct lookup.
+func (p *Plugin) cycleData(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (*stateData, error) {
+	if state != nil {
+		if data, err := state.Read(stateKey); err == nil {
+			if s, ok := data.(*stateData); ok {
+				return s, nil
+			}
+		}
+	}
+	data, err := p.resolve(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+	data.decide(pod)
+	return data, nil
+}
+
+// cycleMode returns the pod's mode as resolved by PreFilter, or resolves it
+// if the CycleState has none.
+func (p *Plugin) cycleMode(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) Mode {
+	if state != nil {
+		if data, err := state.Read(stateKey); err == nil {
+			if s, ok := data.(*stateData); ok {
+				return s.mode
+			}
+		}
+	}
+	return p.schedulingMode(ctx, pod)
+}
+
+// shareManagerNode returns the node the pod should be co-located with, as
+// resolved by cycleData.
+func (p *Plugin) shareManagerNode(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (string, error) {
+	data, err := p.cycleData(ctx, state, pod)
+	if err != nil {
+		return "", err
+	}
+	return data.shareManagerNode, nil
+}
+
+// resolve looks up the share-managers of the pod's RWX PVCs, and the pod
+// named by its co-schedule-with annotation, and applies the configured
+// conflict policy. For hotplug attachment pods it looks up the owning
+// virt-launcher pod's node instead, and for the CDI pods PreferCDIReplicaNodes
+// applies to the replica nodes of their volume. A PVC that cannot be read
+// fails the lookup for hard-mode pods unless PVCLookupErrorPolicy is allowAll.
+func (p *Plugin) resolve(ctx context.Context, pod *corev1.Pod) (*stateData, error) {
+	if owner := hotplugOwner(pod); owner != "" {
+		node, err := p.hotplugVMNode(ctx, pod.Namespace, owner)
+		if err != nil {
+			return nil, err
+		}
+		return &stateData{vmNode: node}, nil
+	}
+	if p.coSchedulesCDIPod(pod) {
+		return p.resolveCDIPod(ctx, pod), nil
+	}
+
+	mode := p.schedulingMode(ctx, pod)
+	if p.args.DataVolumePolicy == DataVolumePolicyWait {
+		reason, err := p.pendingDataVolume(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			return &stateData{mode: mode, waitingFor: reason, waitingPolicy: fmt.Sprintf("dataVolumePolicy %q", DataVolumePolicyWait)}, nil
+		}
+	}
+
+	if p.args.UnboundPVCPolicy == UnboundPVCPolicyWait && mode == ModeHard {
+		reason, err := p.unboundLonghornPVC(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			return &stateData{mode: mode, waitingFor: reason, waitingPolicy: fmt.Sprintf("unboundPVCPolicy %q", UnboundPVCPolicyWait)}, nil
+		}
+	}
+
+	opts := p.lookupOptions(pod)
+	opts.strict = p.args.StrictErrors && mode == ModeHard
+	opts.hotplugPVCs = p.hotplugPVCNames(ctx, pod)
+	placements, err := findShareManagerPlacements(ctx, p.clientset, p.dynClient, pod, opts)
+	if err != nil && opts.strict {
+		p.reportStrictError(pod, err)
+		return nil, err
+	}
+	var lookupErr *pvcLookupError
+	if errors.As(err, &lookupErr) && (mode != ModeHard || p.args.PVCLookupErrorPolicy == PVCLookupErrorPolicyAllowAll) {
+		klog.ErrorS(err, "LonghornCoSchedule: error reading PVC, scheduling pod as if no share-manager was found",
+			"pod", klog.KObj(pod),
+			"pvc", klog.KRef(lookupErr.namespace, lookupErr.name),
+			"mode", mode,
+		)
+		placements, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The pod named by co-schedule-with goes first, so that it wins under
+	// the default conflict policy.
+	if pl, ok := p.coScheduleWithPlacement(pod); ok {
+		placements = append([]shareManagerPlacement{pl}, placements...)
+	}
+	data := resolvePlacements(placements, p.args.conflictPolicy())
+	data.mode = mode
+	if data.mode == ModeHard {
+		data.terminatingPVCs = p.terminatingPVCs(ctx, pod)
+	}
+	if data.mode != "" && p.isMigrationTargetPod(ctx, pod) {
+		data.migrationSource = p.migrationSourceNode(pod)
+	}
+	if p.args.PreferPreviousNode && len(data.placements) == 0 {
+		data.previousNode = p.previousNode(ctx, pod)
+	}
+	if data.mode != "" && p.args.volumeNodeTagPolicy() != VolumeNodeTagPolicyIgnore {
+		data.volumeTags = p.podVolumeNodeTags(ctx, pod)
+	}
+	if data.mode == ModeHard || data.mode == ModeSoft {
+		data.replicaNodes = p.bestEffortReplicaNodes(ctx, pod)
+		data.pinnedVMs = p.pinnedVMs(pod)
+	}
+	return data, nil
+}
@@ -0,0 +1,176 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+func TestPreFilter(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		wantSkip bool
+		wantNode string
+	}{
+		{
+			name:     "pod not opted in — skipped",
+			pod:      makeVM("vm", vmNamespace, false, pvcName),
+			wantSkip: true,
+		},
+		{
+			name:     "migration target — skipped",
+			pod:      makeMigrationTargetVM("vm", vmNamespace, pvcName),
+			wantSkip: true,
+		},
+		{
+			name:     "opted in — share-manager node stored",
+			pod:      makeVM("vm", vmNamespace, true, pvcName),
+			wantNode: "node-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, "node-2"))
+			plugin := &Plugin{clientset: clientset}
+			state := framework.NewCycleState()
+
+			_, status := plugin.PreFilter(context.Background(), state, tt.pod)
+			if status.IsSkip() != tt.wantSkip {
+				t.Errorf("PreFilter() skip = %v, want %v (status: %v)", status.IsSkip(), tt.wantSkip, status)
+			}
+			node, err := plugin.shareManagerNode(context.Background(), state, tt.pod)
+			if err != nil {
+				t.Fatalf("shareManagerNode() error = %v", err)
+			}
+			if node != tt.wantNode {
+				t.Errorf("shareManagerNode() = %q, want %q", node, tt.wantNode)
+			}
+		})
+	}
+}
+
+// TestPreFilterExtensions checks that the cached share-manager decision
+// survives the AddPod/RemovePod calls made while simulating preemption, and
+// is used instead of a new lookup.
+func TestPreFilterExtensions(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+	)
+
+	clientset := fake.NewSimpleClientset(makePVC(pvcName, vmNamespace, pvName), makeShareManagerPod(pvName, targetNode))
+	plugin := &Plugin{clientset: clientset}
+	pod := makeVM("vm", vmNamespace, true, pvcName)
+	state := framework.NewCycleState()
+
+	if _, status := plugin.PreFilter(context.Background(), state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter() status = %v", status)
+	}
+
+	ext := plugin.PreFilterExtensions()
+	if ext == nil {
+		t.Fatal("PreFilterExtensions() = nil")
+	}
+
+	// Preemption works on a copy of the state.
+	simulated := state.Clone()
+	nodeInfo := makeNodeInfo(targetNode)
+	victim, err := framework.NewPodInfo(makeRunningPod("victim", vmNamespace, targetNode))
+	if err != nil {
+		t.Fatalf("NewPodInfo() error = %v", err)
+	}
+	if status := ext.RemovePod(context.Background(), simulated, pod, victim, nodeInfo); !status.IsSuccess() {
+		t.Errorf("RemovePod() status = %v", status)
+	}
+	if status := ext.AddPod(context.Background(), simulated, pod, victim, nodeInfo); !status.IsSuccess() {
+		t.Errorf("AddPod() status = %v", status)
+	}
+
+	// With the share-manager pod gone, a fresh lookup would find nothing; the
+	// cached decision must still be used.
+	if err := clientset.CoreV1().Pods(LonghornNamespace).Delete(context.Background(), ShareManagerPrefix+pvName, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("deleting share-manager pod: %v", err)
+	}
+	for _, s := range []*framework.CycleState{state, simulated} {
+		if status := plugin.Filter(context.Background(), s, pod, makeNodeInfo("node-1")); status.IsSuccess() {
+			t.Errorf("Filter() on other node should use the cached share-manager node and reject it")
+		}
+		if status := plugin.Filter(context.Background(), s, pod, nodeInfo); !status.IsSuccess() {
+			t.Errorf("Filter() on share-manager node = %v, want success", status)
+		}
+	}
+}
+
+// TestTerminatingPVC checks that an RWX PVC being deleted does not pin the
+// pod to its share-manager, and that hard-mode pods get an event saying so.
+func TestTerminatingPVC(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+	)
+
+	tests := []struct {
+		name      string
+		mode      Mode
+		wantEvent bool
+	}{
+		{name: "hard mode — not pinned, event emitted", mode: ModeHard, wantEvent: true},
+		{name: "soft mode — not scored, no event", mode: ModeSoft},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := makePVC(pvcName, vmNamespace, pvName)
+			now := metav1.Now()
+			pvc.DeletionTimestamp = &now
+			pvc.Finalizers = []string{"kubernetes.io/pvc-protection"}
+			pod := makeVM("vm", vmNamespace, true, pvcName)
+			pod.Annotations[AnnotationKey] = string(tt.mode)
+
+			recorder := events.NewFakeRecorder(10)
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{makeNode("node-1", "4"), makeNode(targetNode, "4")},
+				objects:  []runtime.Object{pod, pvc, makeShareManagerPod(pvName, targetNode)},
+				recorder: recorder,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != 0 {
+				t.Errorf("%d nodes rejected, want none for a terminating PVC", m.Len())
+			}
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if node := data.(*stateData).shareManagerNode; node != "" {
+				t.Errorf("shareManagerNode = %q, want none", node)
+			}
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				got = append(got, <-recorder.Events)
+			}
+			if hasEvent(got, terminatingPVCReason) != tt.wantEvent {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", terminatingPVCReason, !tt.wantEvent, tt.wantEvent, got)
+			}
+		})
+	}
+}
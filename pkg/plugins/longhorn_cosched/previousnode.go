@@ -0,0 +1,54 @@
+package longhorn_cosched
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// previousNode returns the node of the most recently created earlier
+// virt-launcher pod of the same VM, or "" if there is none. Pods are found by
+// the VMNameLabel, so those of earlier VMIs of the VM count too, whatever
+// their phase, including Succeeded and terminating ones.
+func (p *Plugin) previousNode(ctx context.Context, pod *corev1.Pod) string {
+	vmName := pod.Labels[VMNameLabel]
+	if vmName == "" {
+		if owner := VMIOwner(pod); owner != nil {
+			vmName = owner.Name
+		}
+	}
+	if vmName == "" {
+		return ""
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{VMNameLabel: vmName})
+	list, err := p.clientset.CoreV1().Pods(pod.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		klog.V(4).InfoS("LonghornCoSchedule: listing previous virt-launcher pods failed", "pod", klog.KObj(pod), "vm", vmName, "err", err)
+		return ""
+	}
+
+	var previous *corev1.Pod
+	for i := range list.Items {
+		other := &list.Items[i]
+		if other.UID == pod.UID || other.Spec.NodeName == "" {
+			continue
+		}
+		if previous == nil || previous.CreationTimestamp.Before(&other.CreationTimestamp) {
+			previous = other
+		}
+	}
+	if previous == nil {
+		return ""
+	}
+	klog.V(4).InfoS("LonghornCoSchedule: found previous virt-launcher pod",
+		"pod", klog.KObj(pod),
+		"previousPod", klog.KObj(previous),
+		"phase", previous.Status.Phase,
+		"previousNode", previous.Spec.NodeName,
+	)
+	return previous.Spec.NodeName
+}
@@ -0,0 +1,84 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makePreviousLauncher creates a finished virt-launcher pod of the VM "my-vm"
+// that ran on the given node, created age ago.
+func makePreviousLauncher(name, nodeName string, age time.Duration) *corev1.Pod {
+	pod := makeLauncher("my-vm", "default", types.UID("old-"+name))
+	pod.Name = name
+	pod.UID = types.UID(name)
+	pod.Labels = map[string]string{VMNameLabel: "my-vm"}
+	pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-age))
+	pod.Spec.NodeName = nodeName
+	pod.Status.Phase = corev1.PodSucceeded
+	return pod
+}
+
+// TestPreferPreviousNode checks that, with PreferPreviousNode, a VM without a
+// share-manager prefers the node its latest previous virt-launcher pod ran
+// on, and that a share-manager still wins.
+func TestPreferPreviousNode(t *testing.T) {
+	const (
+		pvcName = "shared"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	previous := []runtime.Object{
+		makePreviousLauncher("virt-launcher-my-vm-old", "node-1", time.Hour),
+		makePreviousLauncher("virt-launcher-my-vm-last", "node-3", time.Minute),
+	}
+
+	tests := []struct {
+		name     string
+		prefer   bool
+		smNode   string
+		wantBest string
+	}{
+		{name: "prior pod on node-3", prefer: true, wantBest: "node-3"},
+		{name: "share-manager wins", prefer: true, smNode: "node-2", wantBest: "node-2"},
+		{name: "flag off"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeLauncher("my-vm", "default", "vmi-uid", pvcName)
+			pod.Labels = map[string]string{VMNameLabel: "my-vm"}
+			pod.Annotations = map[string]string{AnnotationKey: AnnotationValue}
+			objects := append([]runtime.Object{pod, makePVC(pvcName, "default", pvName)}, previous...)
+			if tt.smNode != "" {
+				objects = append(objects, makeShareManagerPod(pvName, tt.smNode))
+			}
+
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+				objects: objects,
+				args:    Args{PreferPreviousNode: tt.prefer},
+			})
+
+			state, _ := runFilters(t, fwk, pod)
+			for _, node := range []string{"node-1", "node-2", "node-3"} {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+				var want int64
+				if node == tt.wantBest {
+					want = framework.MaxNodeScore
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+		})
+	}
+}
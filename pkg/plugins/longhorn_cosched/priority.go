@@ -0,0 +1,45 @@
+package longhorn_cosched
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// priorityMode returns the mode of a pod the opt-in mechanisms co-schedule
+// in mode, after its priority is considered:
+//
+//  1. A PriorityClassModes entry for the pod's priorityClassName selects the
+//     mode.
+//  2. Otherwise, if the pod's priority is at least SoftModePriorityThreshold,
+//     the pod is co-scheduled in soft mode.
+//  3. Otherwise mode is kept.
+//
+// A pod that is not opted in (mode is empty) stays so, and a pod that asked
+// for ModeObserve is never co-scheduled for real.
+func (p *Plugin) priorityMode(pod *corev1.Pod, mode Mode) Mode {
+	if mode == "" || mode == ModeObserve {
+		return mode
+	}
+	if selected, ok := p.args.PriorityClassModes[pod.Spec.PriorityClassName]; ok && pod.Spec.PriorityClassName != "" {
+		if selected != mode {
+			klog.V(5).InfoS("LonghornCoSchedule: mode selected by priority class",
+				"pod", klog.KObj(pod),
+				"priorityClass", pod.Spec.PriorityClassName,
+				"mode", selected,
+			)
+		}
+		return selected
+	}
+	threshold := p.args.SoftModePriorityThreshold
+	if threshold == nil || pod.Spec.Priority == nil || *pod.Spec.Priority < *threshold {
+		return mode
+	}
+	if mode != ModeSoft {
+		klog.V(5).InfoS("LonghornCoSchedule: priority above the soft-mode threshold, co-scheduling in soft mode",
+			"pod", klog.KObj(pod),
+			"priority", *pod.Spec.Priority,
+			"threshold", *threshold,
+		)
+	}
+	return ModeSoft
+}
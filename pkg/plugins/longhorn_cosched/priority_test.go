@@ -0,0 +1,93 @@
+package longhorn_cosched
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TestPriorityMode checks that under a priority threshold and priority class
+// modes, opted-in pods at or above the threshold are co-scheduled in soft
+// mode, that a priority class entry takes precedence over the threshold and
+// over the pod's own annotation, and that pods that are not opted in stay
+// so.
+func TestPriorityMode(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	args := Args{
+		SoftModePriorityThreshold: int32Ptr(1000),
+		PriorityClassModes: map[string]Mode{
+			"vm-critical": ModeSoft,
+			"vm-pinned":   ModeHard,
+		},
+	}
+
+	tests := []struct {
+		name          string
+		podValue      *string
+		pvcValue      *string
+		priority      *int32
+		priorityClass string
+		wantMode      Mode
+		wantRejected  int
+	}{
+		{name: "below threshold", pvcValue: ptr("true"), priority: int32Ptr(100), wantMode: ModeHard, wantRejected: 1},
+		{name: "no priority", pvcValue: ptr("true"), wantMode: ModeHard, wantRejected: 1},
+		{name: "at threshold", pvcValue: ptr("true"), priority: int32Ptr(1000), wantMode: ModeSoft},
+		{name: "above threshold", pvcValue: ptr("true"), priority: int32Ptr(2000000000), wantMode: ModeSoft},
+		{name: "above threshold, pod annotation hard", podValue: ptr("hard"), priority: int32Ptr(2000), wantMode: ModeSoft},
+		{name: "above threshold, not opted in", priority: int32Ptr(2000)},
+		{name: "above threshold, pod opted out", podValue: ptr(OptOutValue), pvcValue: ptr("true"), priority: int32Ptr(2000)},
+		{name: "class soft", pvcValue: ptr("true"), priority: int32Ptr(100), priorityClass: "vm-critical", wantMode: ModeSoft},
+		{
+			name:          "class hard wins over threshold",
+			pvcValue:      ptr("soft"),
+			priority:      int32Ptr(2000),
+			priorityClass: "vm-pinned",
+			wantMode:      ModeHard,
+			wantRejected:  1,
+		},
+		{name: "class not listed", pvcValue: ptr("true"), priority: int32Ptr(100), priorityClass: "vm-batch", wantMode: ModeHard, wantRejected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, false, pvcName)
+			if tt.podValue != nil {
+				pod.Annotations = map[string]string{AnnotationKey: *tt.podValue}
+			}
+			pod.Spec.Priority = tt.priority
+			pod.Spec.PriorityClassName = tt.priorityClass
+			pvc := makePVC(pvcName, vmNamespace, pvName)
+			if tt.pvcValue != nil {
+				pvc.Annotations = map[string]string{AnnotationKey: *tt.pvcValue}
+			}
+
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects: []runtime.Object{pod, pvc, makeShareManagerPod(pvName, "node-1")},
+				args:    args,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).mode; got != tt.wantMode {
+				t.Errorf("mode = %q, want %q", got, tt.wantMode)
+			}
+			if m.Len() != tt.wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), tt.wantRejected)
+			}
+		})
+	}
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
@@ -0,0 +1,104 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getPVC returns the named PVC, reading from the informer cache when opts
+// carries a lister and with a live GET otherwise.
+func getPVC(ctx context.Context, clientset kubernetes.Interface, namespace, name string, opts lookupOptions) (*corev1.PersistentVolumeClaim, error) {
+	if opts.pvcLister != nil {
+		return opts.pvcLister.PersistentVolumeClaims(namespace).Get(name)
+	}
+	return clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// getPV returns the named PV, reading from the informer cache when opts
+// carries a lister and with a live GET otherwise.
+func getPV(ctx context.Context, clientset kubernetes.Interface, name string, opts lookupOptions) (*corev1.PersistentVolume, error) {
+	if opts.pvLister != nil {
+		return opts.pvLister.Get(name)
+	}
+	return clientset.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+}
+
+// getStorageClass returns the named StorageClass, reading from the informer
+// cache when opts carries a lister and with a live GET otherwise.
+func getStorageClass(ctx context.Context, clientset kubernetes.Interface, name string, opts lookupOptions) (*storagev1.StorageClass, error) {
+	if opts.scLister != nil {
+		return opts.scLister.Get(name)
+	}
+	return clientset.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+}
+
+// volumeDriver returns the CSI driver of the PV bound to pvc or, if the PVC
+// is not bound or the PV cannot be read, the provisioner of the PVC's
+// StorageClass. known is false if neither can be read, in which case the
+// caller should assume a Longhorn volume so that co-scheduling still applies.
+//
+// PVs and StorageClasses are read from the informer cache when opts carries
+// listers, and with a live GET otherwise.
+func volumeDriver(ctx context.Context, clientset kubernetes.Interface, pvc *corev1.PersistentVolumeClaim, opts lookupOptions) (driver string, known bool) {
+	var pv *corev1.PersistentVolume
+	err := errors.New("PVC not bound")
+	if pvc.Spec.VolumeName != "" {
+		pv, err = getPV(ctx, clientset, pvc.Spec.VolumeName, opts)
+	}
+	if err == nil {
+		// Longhorn volumes are always CSI volumes; an in-tree volume has
+		// no driver.
+		if pv.Spec.CSI == nil {
+			return "", true
+		}
+		return pv.Spec.CSI.Driver, true
+	}
+
+	scName := pvc.Spec.StorageClassName
+	if scName == nil || *scName == "" {
+		return "", false
+	}
+	sc, err := getStorageClass(ctx, clientset, *scName, opts)
+	if err != nil {
+		return "", false
+	}
+	return sc.Provisioner, true
+}
+
+// isLonghornVolume returns false if the volume bound to pvc is known to be
+// provisioned by something other than Longhorn, e.g. CephFS or an NFS
+// provisioner. Such volumes have no share-manager. The driver is returned for
+// logging.
+func isLonghornVolume(ctx context.Context, clientset kubernetes.Interface, pvc *corev1.PersistentVolumeClaim, opts lookupOptions) (ok bool, driver string) {
+	driver, known := volumeDriver(ctx, clientset, pvc, opts)
+	return !known || driver == LonghornDriver, driver
+}
+
+// unboundLonghornPVC returns a reason if one of the pod's RWX Longhorn PVCs is
+// not bound yet, or "" if none is. PVCs that do not exist are left to the
+// DataVolume policy.
+func (p *Plugin) unboundLonghornPVC(ctx context.Context, pod *corev1.Pod) (string, error) {
+	for _, pvcName := range collectPVCNames(pod) {
+		pvc, err := p.getPVC(ctx, pod.Namespace, pvcName)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("getting PVC %s/%s: %w", pod.Namespace, pvcName, err)
+		}
+		if !IsRWX(pvc) || (pvc.Status.Phase == corev1.ClaimBound && pvc.Spec.VolumeName != "") {
+			continue
+		}
+		if ok, _ := isLonghornVolume(ctx, p.clientset, pvc, p.lookupOptions(pod)); ok {
+			return fmt.Sprintf("RWX PVC %q is not bound yet", pvcName), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,222 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// cephFSDriver is the CSI driver of CephFS volumes.
+const cephFSDriver = "cephfs.csi.ceph.com"
+
+// makeCSIPV creates a PV of the given CSI driver.
+func makeCSIPV(name, driver string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: name},
+			},
+		},
+	}
+}
+
+// TestNonLonghornRWXPVC checks that a CephFS-backed RWX PVC on the same pod as
+// a Longhorn one is skipped, even though a pod in longhorn-system happens to
+// match its PV name.
+func TestNonLonghornRWXPVC(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		longhornPVC = "longhorn-rwx"
+		longhornPV  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		cephPVC     = "ceph-rwx"
+		cephPV      = "pvc-7d1c0c4e-9b6f-4b8e-a3f4-2f1e8c0b9d11"
+	)
+	cephClass := "cephfs"
+	cephPVCUnreadablePV := makePVC(cephPVC, vmNamespace, cephPV)
+	cephPVCUnreadablePV.Spec.StorageClassName = &cephClass
+
+	tests := []struct {
+		name      string
+		objects   []runtime.Object
+		wantNodes []string
+	}{
+		{
+			name: "PV CSI drivers — CephFS PVC skipped",
+			objects: []runtime.Object{
+				makeCSIPV(longhornPV, LonghornDriver),
+				makeCSIPV(cephPV, cephFSDriver),
+				makePVC(cephPVC, vmNamespace, cephPV),
+			},
+			wantNodes: []string{"node-2"},
+		},
+		{
+			name: "PV missing — StorageClass provisioner used",
+			objects: []runtime.Object{
+				makeCSIPV(longhornPV, LonghornDriver),
+				&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: cephClass}, Provisioner: cephFSDriver},
+				cephPVCUnreadablePV,
+			},
+			wantNodes: []string{"node-2"},
+		},
+		{
+			name: "driver unknown — assumed Longhorn",
+			objects: []runtime.Object{
+				makePVC(cephPVC, vmNamespace, cephPV),
+			},
+			wantNodes: []string{"node-1", "node-2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append([]runtime.Object{
+				makePVC(longhornPVC, vmNamespace, longhornPV),
+				makeShareManagerPod(longhornPV, "node-2"),
+				// Coincidentally matches the CephFS PV.
+				makeShareManagerPod(cephPV, "node-1"),
+			}, tt.objects...)
+			clientset := fake.NewSimpleClientset(objects...)
+			pod := makeVM("vm", vmNamespace, true, cephPVC, longhornPVC)
+
+			placements, err := findShareManagerPlacements(context.Background(), clientset, nil, pod, lookupOptions{})
+			if err != nil {
+				t.Fatalf("findShareManagerPlacements() error = %v", err)
+			}
+			var got []string
+			for _, pl := range placements {
+				got = append(got, pl.node)
+			}
+			if len(got) != len(tt.wantNodes) {
+				t.Fatalf("findShareManagerPlacements() nodes = %v, want %v", got, tt.wantNodes)
+			}
+			for i := range got {
+				if got[i] != tt.wantNodes[i] {
+					t.Errorf("findShareManagerPlacements() nodes = %v, want %v", got, tt.wantNodes)
+					break
+				}
+			}
+		})
+	}
+}
+
+// TestPreEnqueueNonLonghornRWXPVC checks that WaitForShareManager does not gate
+// a pod on a CephFS-backed RWX PVC, which never gets a ShareManager.
+func TestPreEnqueueNonLonghornRWXPVC(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "ceph-rwx"
+		pvName      = "pvc-7d1c0c4e-9b6f-4b8e-a3f4-2f1e8c0b9d11"
+	)
+	clientset := fake.NewSimpleClientset(makePVC(pvcName, vmNamespace, pvName), makeCSIPV(pvName, cephFSDriver))
+	plugin := &Plugin{clientset: clientset, dynClient: newDynamicClient(), args: Args{WaitForShareManager: true}}
+
+	if status := plugin.PreEnqueue(context.Background(), makeWaitingVM("vm", vmNamespace, pvcName)); !status.IsSuccess() {
+		t.Errorf("PreEnqueue() = %v, want success for a non-Longhorn RWX PVC", status)
+	}
+}
+
+// TestUnboundPVCPolicy checks both unbound PVC policies with an RWX Longhorn
+// PVC of a WaitForFirstConsumer StorageClass that binds later, and that a
+// soft-mode pod never waits.
+func TestUnboundPVCPolicy(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+		otherNode   = "node-1"
+	)
+	className := "longhorn-wffc"
+	wffc := storagev1.VolumeBindingWaitForFirstConsumer
+	class := &storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: className},
+		Provisioner:       LonghornDriver,
+		VolumeBindingMode: &wffc,
+	}
+
+	tests := []struct {
+		name            string
+		policy          UnboundPVCPolicy
+		soft            bool
+		wantUnboundPass bool
+	}{
+		{name: "default policy is allow", wantUnboundPass: true},
+		{name: "allow", policy: UnboundPVCPolicyAllow, wantUnboundPass: true},
+		{name: "wait", policy: UnboundPVCPolicyWait},
+		{name: "wait, soft mode", policy: UnboundPVCPolicyWait, soft: true, wantUnboundPass: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unbound := makePVC(pvcName, vmNamespace, "")
+			unbound.Spec.StorageClassName = &className
+			unbound.Status.Phase = corev1.ClaimPending
+			clientset := fake.NewSimpleClientset(unbound, class)
+			plugin := &Plugin{clientset: clientset, args: Args{UnboundPVCPolicy: tt.policy}}
+			pod := makeVM("vm", vmNamespace, true, pvcName)
+			if tt.soft {
+				pod.Annotations[AnnotationKey] = string(ModeSoft)
+			}
+
+			// filter runs a scheduling cycle and returns whether each node
+			// passed. While the PVC is unbound, only a pod that does not
+			// pass may wait, and rejections must name the policy.
+			filter := func(unbound bool) map[string]bool {
+				t.Helper()
+				state := framework.NewCycleState()
+				if _, status := plugin.PreFilter(context.Background(), state, pod); !status.IsSuccess() {
+					t.Fatalf("PreFilter() status = %v", status)
+				}
+				if c, err := state.Read(stateKey); err == nil && unbound {
+					if waiting := c.(*stateData).decision.Outcome == decisionWaiting; waiting == tt.wantUnboundPass {
+						t.Errorf("unbound: waiting = %v, want %v", waiting, !tt.wantUnboundPass)
+					}
+				}
+				passed := map[string]bool{}
+				for _, node := range []string{targetNode, otherNode} {
+					status := plugin.Filter(context.Background(), state, pod, makeNodeInfo(node))
+					passed[node] = status.IsSuccess()
+					if !status.IsSuccess() && unbound && !strings.Contains(status.Message(), "unboundPVCPolicy") {
+						t.Errorf("Filter(%s) message %q should name unboundPVCPolicy", node, status.Message())
+					}
+				}
+				return passed
+			}
+
+			got := filter(true)
+			for node, pass := range got {
+				if pass != tt.wantUnboundPass {
+					t.Errorf("unbound: Filter(%s) pass = %v, want %v", node, pass, tt.wantUnboundPass)
+				}
+			}
+
+			// The volume binds and Longhorn starts the share-manager.
+			bound := makePVC(pvcName, vmNamespace, pvName)
+			bound.Spec.StorageClassName = &className
+			if _, err := clientset.CoreV1().PersistentVolumeClaims(vmNamespace).Update(context.Background(), bound, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("binding PVC: %v", err)
+			}
+			if _, err := clientset.CoreV1().Pods(LonghornNamespace).Create(context.Background(), makeShareManagerPod(pvName, targetNode), metav1.CreateOptions{}); err != nil {
+				t.Fatalf("creating share-manager pod: %v", err)
+			}
+			hint, err := plugin.isSchedulableAfterPVCChange(klog.Background(), pod, unbound, bound)
+			if err != nil || hint != framework.Queue {
+				t.Errorf("isSchedulableAfterPVCChange() = %v, %v, want Queue", hint, err)
+			}
+
+			got = filter(false)
+			if !got[targetNode] || got[otherNode] != tt.soft {
+				t.Errorf("bound: Filter passed = %v, want %s, and %s in soft mode only", got, targetNode, otherNode)
+			}
+		})
+	}
+}
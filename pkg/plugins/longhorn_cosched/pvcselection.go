@@ -0,0 +1,72 @@
+package longhorn_cosched
+
+import (
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// coSchedulePVCNotFoundReason is the reason of the event emitted on a pod
+// whose co-schedule-pvc annotation names a claim none of its volumes uses.
+const coSchedulePVCNotFoundReason = "CoSchedulePVCNotFound"
+
+// selectedPVCNames returns the claim names listed in the pod's
+// co-schedule-pvc annotation. ok is false if the annotation is not set.
+func selectedPVCNames(pod *corev1.Pod) (names []string, ok bool) {
+	value, ok := pod.Annotations[CoSchedulePVCAnnotationKey]
+	if !ok {
+		return nil, false
+	}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// coSchedulePVCNames returns the names of the PVCs whose share-managers the
+// pod is co-scheduled with, in the order of the pod's volumes: those named in
+// the co-schedule-pvc annotation or, if it is not set, all of them except the
+// hotplugged ones (see hotplugPVCNames).
+func coSchedulePVCNames(pod *corev1.Pod, hotplug []string) []string {
+	all := collectPVCNames(pod)
+	selected, ok := selectedPVCNames(pod)
+	var names []string
+	for _, name := range all {
+		if ok {
+			if slices.Contains(selected, name) {
+				names = append(names, name)
+			}
+		} else if !slices.Contains(hotplug, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// reportCoSchedulePVCs emits a warning event on the pod for each claim its
+// co-schedule-pvc annotation names that none of its volumes uses, e.g. a
+// typo, which would otherwise silently leave the pod unpinned.
+func (p *Plugin) reportCoSchedulePVCs(pod *corev1.Pod) {
+	selected, ok := selectedPVCNames(pod)
+	if !ok {
+		return
+	}
+	volumes := collectPVCNames(pod)
+	for _, name := range selected {
+		if slices.Contains(volumes, name) {
+			continue
+		}
+		klog.V(2).InfoS("LonghornCoSchedule: co-schedule-pvc names a claim the pod does not use",
+			"pod", klog.KObj(pod),
+			"pvc", name,
+		)
+		if p.handle != nil {
+			p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeWarning, coSchedulePVCNotFoundReason, "Schedule",
+				"Annotation %s names PVC %q, which is not a volume of the pod; it is ignored", CoSchedulePVCAnnotationKey, name)
+		}
+	}
+}
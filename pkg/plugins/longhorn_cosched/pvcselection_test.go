@@ -0,0 +1,81 @@
+package longhorn_cosched
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+)
+
+// TestCoSchedulePVCAnnotation checks that the co-schedule-pvc annotation
+// restricts the share-manager lookup to the named claims, and that a name
+// that matches none of the pod's volumes is reported with an event.
+func TestCoSchedulePVCAnnotation(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		firstPVC    = "rootdisk"
+		firstPV     = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		secondPVC   = "database"
+		secondPV    = "pvc-7d1c0c4e-9b6f-4b8e-a3f4-2f1e8c0b9d11"
+	)
+
+	tests := []struct {
+		name       string
+		annotation *string
+		wantNode   string
+		wantEvent  bool
+	}{
+		{name: "no annotation — first PVC wins", wantNode: "node-1"},
+		{name: "second PVC selected", annotation: ptr(secondPVC), wantNode: "node-2"},
+		{name: "both selected — volume order", annotation: ptr(" " + secondPVC + " , " + firstPVC), wantNode: "node-1"},
+		{name: "unknown name next to a valid one", annotation: ptr("databse," + secondPVC), wantNode: "node-2", wantEvent: true},
+		{name: "only an unknown name — not pinned", annotation: ptr("databse"), wantEvent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, true, firstPVC, secondPVC)
+			if tt.annotation != nil {
+				pod.Annotations[CoSchedulePVCAnnotationKey] = *tt.annotation
+			}
+
+			recorder := events.NewFakeRecorder(10)
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects: []runtime.Object{
+					pod,
+					makePVC(firstPVC, vmNamespace, firstPV),
+					makePVC(secondPVC, vmNamespace, secondPV),
+					makeShareManagerPod(firstPV, "node-1"),
+					makeShareManagerPod(secondPV, "node-2"),
+				},
+				recorder: recorder,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).shareManagerNode; got != tt.wantNode {
+				t.Errorf("shareManagerNode = %q, want %q", got, tt.wantNode)
+			}
+			if tt.wantNode != "" && m.Len() != 1 {
+				t.Errorf("%d nodes rejected, want 1", m.Len())
+			}
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				got = append(got, <-recorder.Events)
+			}
+			if hasEvent(got, coSchedulePVCNotFoundReason) != tt.wantEvent {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", coSchedulePVCNotFoundReason, !tt.wantEvent, tt.wantEvent, got)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,192 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// AllowRelocationAnnotationKey is the per-pod annotation that, together
+	// with the RelocateShareManager plugin arg, allows PostFilter to relocate
+	// the pod's share-manager. Its value is parsed like strconv.ParseBool.
+	AllowRelocationAnnotationKey = "scheduler.kubevirt-scheduler.io/allow-share-manager-relocation"
+
+	// DefaultRelocationCooldown is the minimum time between two relocations
+	// of the same share-manager when ShareManagerRelocationCooldown is unset.
+	DefaultRelocationCooldown = 10 * time.Minute
+
+	// relocatedReason is the reason of the event emitted on relocation.
+	relocatedReason = "ShareManagerRelocated"
+)
+
+// allowsRelocation returns true if the pod allows its share-manager to be
+// relocated.
+func allowsRelocation(pod *corev1.Pod) bool {
+	return annotationBool(pod, AllowRelocationAnnotationKey)
+}
+
+// relocationLimiter remembers when each share-manager was last relocated so
+// the same volume is not restarted over and over.
+type relocationLimiter struct {
+	clock    clock.PassiveClock
+	cooldown time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newRelocationLimiter(c clock.PassiveClock, cooldown time.Duration) *relocationLimiter {
+	if cooldown <= 0 {
+		cooldown = DefaultRelocationCooldown
+	}
+	return &relocationLimiter{clock: c, cooldown: cooldown, last: map[string]time.Time{}}
+}
+
+// tryAcquire records a relocation of the given PV and returns true, unless
+// the PV was relocated less than the cooldown ago.
+func (l *relocationLimiter) tryAcquire(pvName string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if last, ok := l.last[pvName]; ok && now.Sub(last) < l.cooldown {
+		return false
+	}
+	l.last[pvName] = now
+	return true
+}
+
+// release forgets a relocation that did not happen.
+func (l *relocationLimiter) release(pvName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.last, pvName)
+}
+
+// relocateShareManager asks Longhorn to move the share-manager on
+// shareManagerNode away from it, because the pod cannot be placed there even
+// with preemption. It does so by deleting the share-manager pod; Longhorn
+// recreates it and picks the node. The nodes that passed every other filter
+// are only reported in the event, Longhorn is not told about them.
+//
+// PostFilter only calls it for pods in hard mode. It is a no-op unless the
+// RelocateShareManager arg is set, the pod carries the allow-relocation
+// annotation, and at least one other node was rejected by this plugin alone.
+// It returns true if a relocation was requested.
+func (p *Plugin) relocateShareManager(ctx context.Context, pod *corev1.Pod, shareManagerNode string, m framework.NodeToStatusReader) (bool, error) {
+	if !p.args.RelocateShareManager || p.relocations == nil || p.handle == nil {
+		return false, nil
+	}
+	if !allowsRelocation(pod) {
+		return false, nil
+	}
+
+	candidates, err := p.coScheduleRejectedNodes(m, shareManagerNode)
+	if err != nil {
+		return false, err
+	}
+	if len(candidates) == 0 {
+		klog.V(4).InfoS("LonghornCoSchedule/PostFilter: no other node fits the pod, not relocating share-manager",
+			"pod", klog.KObj(pod),
+			"shareManagerNode", shareManagerNode,
+		)
+		return false, nil
+	}
+
+	pvName, err := p.shareManagerVolumeOnNode(ctx, pod, shareManagerNode)
+	if err != nil || pvName == "" {
+		return false, err
+	}
+
+	if !p.relocations.tryAcquire(pvName) {
+		klog.V(4).InfoS("LonghornCoSchedule/PostFilter: share-manager relocated recently, waiting",
+			"pod", klog.KObj(pod),
+			"pv", pvName,
+		)
+		return false, nil
+	}
+
+	// Delete the pod the lookup found, which is not necessarily named
+	// share-manager-<pv>; fall back to that name if it is gone already.
+	opts := p.lookupOptions(pod)
+	namespace := opts.longhornNamespace()
+	smName := ShareManagerPrefix + pvName
+	if smPod, err := newestShareManagerPod(ctx, p.clientset, pvName, opts); err == nil && smPod != nil {
+		smName = smPod.Name
+	}
+	err = p.clientset.CoreV1().Pods(namespace).Delete(ctx, smName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		p.relocations.release(pvName)
+		return false, fmt.Errorf("deleting share-manager pod %s/%s: %w", namespace, smName, err)
+	}
+
+	klog.V(2).InfoS("LonghornCoSchedule/PostFilter: relocating share-manager",
+		"pod", klog.KObj(pod),
+		"pv", pvName,
+		"shareManagerNode", shareManagerNode,
+		"candidates", candidates,
+	)
+	p.handle.EventRecorder().Eventf(pod, nil, corev1.EventTypeNormal, relocatedReason, "Relocate",
+		"Deleted share-manager pod %s/%s on node %q, which cannot fit the pod, so Longhorn reschedules it; nodes that fit the pod: %s",
+		namespace, smName, shareManagerNode, strings.Join(candidates, ", "))
+	return true, nil
+}
+
+// coScheduleRejectedNodes returns the names of the nodes, other than
+// shareManagerNode, that passed every filter except this plugin's.
+func (p *Plugin) coScheduleRejectedNodes(m framework.NodeToStatusReader, shareManagerNode string) ([]string, error) {
+	nodes, err := m.NodesForStatusCode(p.handle.SnapshotSharedLister().NodeInfos(), framework.Unschedulable)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range nodes {
+		name := n.Node().Name
+		if name == shareManagerNode {
+			continue
+		}
+		if m.Get(name).Plugin() == Name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// shareManagerVolumeOnNode returns the PV name of the pod's RWX volume whose
+// share-manager is on the given node.
+func (p *Plugin) shareManagerVolumeOnNode(ctx context.Context, pod *corev1.Pod, nodeName string) (string, error) {
+	for _, pvcName := range coSchedulePVCNames(pod, p.hotplugPVCNames(ctx, pod)) {
+		pl, err := getShareManagerNodeForPVC(ctx, p.clientset, p.dynClient, pod.Namespace, pvcName, p.lookupOptions(pod))
+		if err != nil {
+			return "", err
+		}
+		if pl.node != nodeName || pl.migratable || pl.consumer || pl.attachment || pl.stopped {
+			continue
+		}
+		pvc, err := p.getPVC(ctx, pod.Namespace, pvcName)
+		if err != nil {
+			return "", err
+		}
+		return pvc.Spec.VolumeName, nil
+	}
+	return "", nil
+}
+
+// isShareManagerPod returns true if the pod carries the share-manager
+// component label or, for setups that do not label it, the share-manager name
+// prefix.
+func isShareManagerPod(pod *corev1.Pod) bool {
+	return pod.Labels[ShareManagerComponentLabel] == ShareManagerComponentValue || strings.HasPrefix(pod.Name, ShareManagerPrefix)
+}
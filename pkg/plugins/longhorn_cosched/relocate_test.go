@@ -0,0 +1,182 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+const (
+	relocPVCName = "my-rwx-pvc"
+	relocPVName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+)
+
+// makeRelocatableVM creates a VM pod requesting 2 CPUs in the given mode that
+// allows share-manager relocation if allow is set.
+func makeRelocatableVM(mode Mode, allow bool) *corev1.Pod {
+	pod := withCPU(makeVM("vm", "default", true, relocPVCName), "2")
+	pod.UID = "uid-vm"
+	pod.Annotations[AnnotationKey] = string(mode)
+	if allow {
+		pod.Annotations[AllowRelocationAnnotationKey] = AnnotationValue
+	}
+	return pod
+}
+
+// shareManagerPodExists reports whether the named share-manager pod still
+// exists.
+func shareManagerPodExists(t *testing.T, clientset kubernetes.Interface, name string) bool {
+	t.Helper()
+	_, err := clientset.CoreV1().Pods(LonghornNamespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		t.Fatalf("getting share-manager pod: %v", err)
+	}
+	return true
+}
+
+func TestPostFilterRelocation(t *testing.T) {
+	tests := []struct {
+		name          string
+		pod           *corev1.Pod
+		args          Args
+		otherNodeCPU  string
+		smPodName     string
+		wantRelocated bool
+	}{
+		{
+			name:          "enabled by arg and annotation — share-manager relocated",
+			pod:           makeRelocatableVM(ModeHard, true),
+			args:          Args{RelocateShareManager: true},
+			otherNodeCPU:  "4",
+			wantRelocated: true,
+		},
+		{
+			name:          "share-manager pod found by label — that pod is deleted",
+			pod:           makeRelocatableVM(ModeHard, true),
+			args:          Args{RelocateShareManager: true},
+			otherNodeCPU:  "4",
+			smPodName:     "sm-7f3a9",
+			wantRelocated: true,
+		},
+		{
+			name:         "arg disabled — not relocated",
+			pod:          makeRelocatableVM(ModeHard, true),
+			otherNodeCPU: "4",
+		},
+		{
+			name:         "annotation missing — not relocated",
+			pod:          makeRelocatableVM(ModeHard, false),
+			args:         Args{RelocateShareManager: true},
+			otherNodeCPU: "4",
+		},
+		{
+			name:         "soft mode — never relocated",
+			pod:          makeRelocatableVM(ModeSoft, true),
+			args:         Args{RelocateShareManager: true},
+			otherNodeCPU: "4",
+		},
+		{
+			name:         "no other node fits the pod — not relocated",
+			pod:          makeRelocatableVM(ModeHard, true),
+			args:         Args{RelocateShareManager: true},
+			otherNodeCPU: "1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smPod := makeShareManagerPod(relocPVName, "node-2")
+			if tt.smPodName != "" {
+				smPod.Name = tt.smPodName
+			}
+			recorder := events.NewFakeRecorder(10)
+			fwk, plugin, clientset := newTestFramework(t, testCluster{
+				// The share-manager node is too small for the pod, so
+				// preemption cannot help there.
+				nodes: []*corev1.Node{makeNode("node-1", tt.otherNodeCPU), makeNode("node-2", "1")},
+				objects: []runtime.Object{
+					tt.pod,
+					makePVC(relocPVCName, "default", relocPVName),
+					smPod,
+				},
+				args:     tt.args,
+				recorder: recorder,
+			})
+
+			state, m := runFilters(t, fwk, tt.pod)
+			_, status := plugin.PostFilter(context.Background(), state, tt.pod, m)
+			if status.Code() != framework.Unschedulable {
+				t.Errorf("PostFilter() code = %v, want Unschedulable", status.Code())
+			}
+
+			if relocated := !shareManagerPodExists(t, clientset, smPod.Name); relocated != tt.wantRelocated {
+				t.Errorf("share-manager relocated = %v, want %v", relocated, tt.wantRelocated)
+			}
+
+			var gotEvent bool
+			for len(recorder.Events) > 0 {
+				if strings.Contains(<-recorder.Events, relocatedReason) {
+					gotEvent = true
+				}
+			}
+			if gotEvent != tt.wantRelocated {
+				t.Errorf("%s event emitted = %v, want %v", relocatedReason, gotEvent, tt.wantRelocated)
+			}
+		})
+	}
+}
+
+func TestPostFilterRelocationCooldown(t *testing.T) {
+	pod := makeRelocatableVM(ModeHard, true)
+	smPod := makeShareManagerPod(relocPVName, "node-2")
+	fwk, plugin, clientset := newTestFramework(t, testCluster{
+		nodes:   []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "1")},
+		objects: []runtime.Object{pod, makePVC(relocPVCName, "default", relocPVName), smPod},
+		args: Args{
+			RelocateShareManager:           true,
+			ShareManagerRelocationCooldown: metav1.Duration{Duration: time.Minute},
+		},
+	})
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+	plugin.relocations.clock = fakeClock
+
+	// relocate runs a scheduling cycle after Longhorn has recreated the
+	// share-manager pod on the same node, and reports whether it was
+	// deleted again.
+	relocate := func() bool {
+		t.Helper()
+		if !shareManagerPodExists(t, clientset, smPod.Name) {
+			if _, err := clientset.CoreV1().Pods(LonghornNamespace).Create(context.Background(), smPod, metav1.CreateOptions{}); err != nil {
+				t.Fatalf("recreating share-manager pod: %v", err)
+			}
+		}
+		state, m := runFilters(t, fwk, pod)
+		plugin.PostFilter(context.Background(), state, pod, m)
+		return !shareManagerPodExists(t, clientset, smPod.Name)
+	}
+
+	if !relocate() {
+		t.Fatal("first cycle should relocate the share-manager")
+	}
+	fakeClock.SetTime(fakeClock.Now().Add(30 * time.Second))
+	if relocate() {
+		t.Error("cycle within the cooldown should not relocate the share-manager")
+	}
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	if !relocate() {
+		t.Error("cycle after the cooldown should relocate the share-manager again")
+	}
+}
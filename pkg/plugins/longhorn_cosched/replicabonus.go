@@ -0,0 +1,44 @@
+package longhorn_cosched
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// bestEffortReplicaNodes returns the nodes holding a healthy replica of one
+// of the pod's best-effort Longhorn volumes, read from the Replica informer of
+// the pod's Longhorn namespace. It is nil unless BestEffortReplicaBonus is
+// set.
+func (p *Plugin) bestEffortReplicaNodes(ctx context.Context, pod *corev1.Pod) map[string]bool {
+	if p.args.BestEffortReplicaBonus == 0 {
+		return nil
+	}
+	opts := p.lookupOptions(pod)
+	replicas := p.replicas[opts.longhornNamespace()]
+	if replicas == nil {
+		return nil
+	}
+	nodes := map[string]bool{}
+	for _, volume := range p.longhornVolumesWithDataLocality(ctx, pod, opts, dataLocalityBestEffort) {
+		healthy, err := healthyReplicaNodes(replicas, volume)
+		if err != nil {
+			klog.V(4).InfoS("LonghornCoSchedule: listing Longhorn Replicas failed", "pod", klog.KObj(pod), "volume", volume, "err", err)
+			continue
+		}
+		for node := range healthy {
+			nodes[node] = true
+		}
+	}
+	return nodes
+}
+
+// replicaBonus returns BestEffortReplicaBonus if the node holds a healthy
+// replica of one of the pod's best-effort Longhorn volumes, and 0 otherwise.
+func (p *Plugin) replicaBonus(data *stateData, nodeName string) int64 {
+	if !data.replicaNodes[nodeName] {
+		return 0
+	}
+	return p.args.BestEffortReplicaBonus
+}
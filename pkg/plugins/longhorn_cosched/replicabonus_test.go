@@ -0,0 +1,111 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TestBestEffortReplicaBonus checks that nodes holding a healthy replica of
+// one of a soft-mode pod's best-effort Longhorn volumes get the bonus, so
+// that the share-manager node ranks first, the replica node second and the
+// other node last.
+func TestBestEffortReplicaBonus(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		sharedPV    = "pvc-0d6a3c4e-7b1f-4c55-a2a1-1e9b1f0c2d33"
+		rootPV      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	nodes := []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")}
+	bestEffortClass := makeDataLocalityClass("longhorn-best-effort", dataLocalityBestEffort)
+	disabledClass := makeDataLocalityClass("longhorn", "disabled")
+
+	tests := []struct {
+		name string
+		args Args
+		// rootClass is the StorageClass of the RWO root disk, whose only
+		// healthy replica, stopped with its VM, is on node-2.
+		rootClass string
+		// namespace, if set, is the Longhorn namespace the pod's
+		// longhorn-namespace annotation selects, which the replicas and the
+		// share-manager run in.
+		namespace  string
+		wantScores map[string]int64
+	}{
+		{
+			name:       "share-manager node, then replica node, then the other",
+			args:       Args{BestEffortReplicaBonus: 30},
+			rootClass:  bestEffortClass.Name,
+			wantScores: map[string]int64{"node-1": 100, "node-2": 30, "node-3": 0},
+		},
+		{
+			name:       "replicas in the pod's Longhorn namespace",
+			args:       Args{BestEffortReplicaBonus: 30, AllowedLonghornNamespaces: []string{"storage"}},
+			rootClass:  bestEffortClass.Name,
+			namespace:  "storage",
+			wantScores: map[string]int64{"node-1": 100, "node-2": 30, "node-3": 0},
+		},
+		{
+			name:       "bonus unset",
+			rootClass:  bestEffortClass.Name,
+			wantScores: map[string]int64{"node-1": 100, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:       "data locality disabled",
+			args:       Args{BestEffortReplicaBonus: 30},
+			rootClass:  disabledClass.Name,
+			wantScores: map[string]int64{"node-1": 100, "node-2": 0, "node-3": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, false, "shared", "rootdisk")
+			pod.Annotations = map[string]string{AnnotationKey: string(ModeSoft)}
+			longhornNamespace := LonghornNamespace
+			if tt.namespace != "" {
+				longhornNamespace = tt.namespace
+				pod.Annotations[LonghornNamespaceAnnotationKey] = tt.namespace
+			}
+			replicas := []runtime.Object{
+				makeReplicaCR(rootPV+"-r-1", rootPV, "node-2", "stopped", false),
+				makeReplicaCR(rootPV+"-r-2", rootPV, "node-3", "error", true),
+			}
+			for _, replica := range replicas {
+				replica.(*unstructured.Unstructured).SetNamespace(longhornNamespace)
+			}
+			shareManager := makeShareManagerPod(sharedPV, "node-1")
+			shareManager.Namespace = longhornNamespace
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: nodes,
+				objects: []runtime.Object{
+					pod, bestEffortClass, disabledClass,
+					makeClassPV(sharedPV, bestEffortClass.Name), makePVC("shared", vmNamespace, sharedPV),
+					makeClassPV(rootPV, tt.rootClass), makeRWOPVC("rootdisk", vmNamespace, rootPV),
+					shareManager,
+				},
+				dynObjects: replicas,
+				args:       tt.args,
+			})
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != 0 {
+				t.Fatalf("Filter rejected %d nodes, want none", m.Len())
+			}
+			nodeInfos, err := fwk.SnapshotSharedLister().NodeInfos().List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			scores, status := fwk.RunScorePlugins(context.Background(), state, pod, nodeInfos)
+			if !status.IsSuccess() {
+				t.Fatalf("RunScorePlugins() = %v", status)
+			}
+			for _, s := range scores {
+				if s.TotalScore != tt.wantScores[s.Name] {
+					t.Errorf("score of %s = %d, want %d", s.Name, s.TotalScore, tt.wantScores[s.Name])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// ReplicaLocalityName is the name of the LonghornReplicaLocality plugin
+	// used in the plugin registry and configurations.
+	ReplicaLocalityName = "LonghornReplicaLocality"
+
+	// replicaLocalityStateKey is the CycleState key of the
+	// LonghornReplicaLocality plugin.
+	replicaLocalityStateKey framework.StateKey = ReplicaLocalityName
+
+	// replicaStateRunning is the status.currentState of a running Longhorn
+	// Replica.
+	replicaStateRunning = "running"
+)
+
+// ReplicaLocalityArgs are the args of the LonghornReplicaLocality plugin.
+type ReplicaLocalityArgs struct {
+	// LonghornNamespace is the namespace Longhorn runs in. Defaults to
+	// LonghornNamespace.
+	LonghornNamespace string `json:"longhornNamespace,omitempty"`
+
+	// RequireReplica also rejects, in Filter, nodes that do not hold a
+	// healthy replica of each of the pod's RWO Longhorn volumes. Volumes
+	// without any healthy replica do not restrict the pod. By default nodes
+	// are only scored.
+	RequireReplica bool `json:"requireReplica,omitempty"`
+}
+
+// longhornNamespace returns the configured Longhorn namespace, applying the
+// default.
+func (a ReplicaLocalityArgs) longhornNamespace() string {
+	if a.LonghornNamespace == "" {
+		return LonghornNamespace
+	}
+	return a.LonghornNamespace
+}
+
+// decodeReplicaLocalityArgs decodes the LonghornReplicaLocality args passed
+// in by the scheduler framework. A nil object yields the zero value.
+func decodeReplicaLocalityArgs(obj runtime.Object) (ReplicaLocalityArgs, error) {
+	var args ReplicaLocalityArgs
+	if err := frameworkruntime.DecodeInto(obj, &args); err != nil {
+		return ReplicaLocalityArgs{}, fmt.Errorf("failed to decode %s args: %w", ReplicaLocalityName, err)
+	}
+	if errs := validation.IsDNS1123Label(args.LonghornNamespace); args.LonghornNamespace != "" && len(errs) > 0 {
+		return ReplicaLocalityArgs{}, fmt.Errorf("invalid %s args: longhornNamespace %q: %s", ReplicaLocalityName, args.LonghornNamespace, strings.Join(errs, "; "))
+	}
+	return args, nil
+}
+
+// ReplicaLocality implements the PreFilter, Filter and Score extension points
+// to place pods with RWO Longhorn volumes on the nodes holding healthy
+// replicas of them, so that reads stay local. It is independent of
+// LonghornCoSchedule: RWX volumes, served by their share-manager, and the
+// co-scheduling annotations are ignored.
+type ReplicaLocality struct {
+	args ReplicaLocalityArgs
+
+	pvcLister corelisters.PersistentVolumeClaimLister
+	pvLister  corelisters.PersistentVolumeLister
+
+	// replicas lists Longhorn Replicas from a dynamic informer. It is nil
+	// when the CRD is not served, in which case every node scores 0.
+	replicas cache.GenericNamespaceLister
+}
+
+var _ framework.PreFilterPlugin = &ReplicaLocality{}
+var _ framework.FilterPlugin = &ReplicaLocality{}
+var _ framework.ScorePlugin = &ReplicaLocality{}
+
+// Name returns the name of the plugin.
+func (r *ReplicaLocality) Name() string {
+	return ReplicaLocalityName
+}
+
+// NewReplicaLocality creates a new instance of the LonghornReplicaLocality
+// plugin.
+func NewReplicaLocality(ctx context.Context, obj runtime.Object, h framework.Handle) (framework.Plugin, error) {
+	dynClient, err := dynamic.NewForConfig(h.KubeConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return NewReplicaLocalityWithClients(dynClient)(ctx, obj, h)
+}
+
+// NewReplicaLocalityWithClients returns a factory of the
+// LonghornReplicaLocality plugin that reads Replicas through the given
+// dynamic client instead of one built from the framework handle's
+// kubeconfig.
+func NewReplicaLocalityWithClients(dynClient dynamic.Interface) frameworkruntime.PluginFactory {
+	return func(ctx context.Context, obj runtime.Object, h framework.Handle) (framework.Plugin, error) {
+		args, err := decodeReplicaLocalityArgs(obj)
+		if err != nil {
+			return nil, err
+		}
+		return newReplicaLocality(ctx, h, args, dynClient), nil
+	}
+}
+
+// newReplicaLocality wires a ReplicaLocality around the dynamic client and
+// the framework handle's informers. It is separate from
+// NewReplicaLocalityWithClients so tests can pass args directly.
+func newReplicaLocality(ctx context.Context, h framework.Handle, args ReplicaLocalityArgs, dynClient dynamic.Interface) *ReplicaLocality {
+	api := newShareManagerAPI(h.ClientSet().Discovery(), clock.RealClock{})
+	return &ReplicaLocality{
+		args:      args,
+		pvcLister: h.SharedInformerFactory().Core().V1().PersistentVolumeClaims().Lister(),
+		pvLister:  h.SharedInformerFactory().Core().V1().PersistentVolumes().Lister(),
+		replicas:  watchLonghornResource(ctx, dynClient, api, args.longhornNamespace(), longhornReplicaResource),
+	}
+}
+
+// watchLonghornResource starts an informer on the Longhorn resource, e.g.
+// the Replicas, in the namespace and returns its lister, or nil if Longhorn's
+// API group is not served. The informer is not waited for: until it has
+// synced, no node holds a replica and no Volume is found.
+func watchLonghornResource(ctx context.Context, dynClient dynamic.Interface, api *shareManagerAPI, namespace, resource string) cache.GenericNamespaceLister {
+	gvr, served := api.resource()
+	if dynClient == nil || !served {
+		klog.V(2).InfoS("LonghornCoSchedule: Longhorn CRDs not served, not watching them", "resource", resource)
+		return nil
+	}
+	gvr.Resource = resource
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynClient, 0, namespace, nil)
+	informer := factory.ForResource(gvr)
+	factory.Start(ctx.Done())
+	return informer.Lister().ByNamespace(namespace)
+}
+
+// healthyReplicaNodes returns the nodes holding a replica of the Longhorn
+// volume that is not failed, read from the lister. Like in replicaScores, a
+// replica that is not running yet, e.g. one being rebuilt, counts: its data
+// is on the node.
+func healthyReplicaNodes(replicas cache.GenericNamespaceLister, volumeName string) (map[string]bool, error) {
+	objs, err := replicas.List(labels.SelectorFromSet(labels.Set{longhornReplicaVolumeLabel: volumeName}))
+	if err != nil {
+		return nil, err
+	}
+	nodes := map[string]bool{}
+	for _, obj := range objs {
+		replica, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		node, _, _ := unstructured.NestedString(replica.Object, "spec", "nodeID")
+		failedAt, _, _ := unstructured.NestedString(replica.Object, "spec", "failedAt")
+		if node == "" || failedAt != "" {
+			continue
+		}
+		nodes[node] = true
+	}
+	return nodes, nil
+}
+
+// replicaLocalityState is what PreFilter found out about the pod's volumes.
+type replicaLocalityState struct {
+	// volumes are the pod's RWO Longhorn volumes with a healthy replica
+	// somewhere.
+	volumes []string
+
+	// nodes counts, per node, the volumes with a healthy replica there.
+	nodes map[string]int
+}
+
+// Clone implements framework.StateData. replicaLocalityState is never
+// modified after PreFilter writes it, so it can be shared between clones.
+func (s *replicaLocalityState) Clone() framework.StateData {
+	return s
+}
+
+// rwoLonghornVolumes returns the Longhorn volume names of the pod's bound RWO
+// Longhorn PVCs. PVCs and PVs that cannot be read are left out.
+func (r *ReplicaLocality) rwoLonghornVolumes(pod *corev1.Pod) []string {
+	var volumes []string
+	for _, pvcName := range collectPVCNames(pod) {
+		pvc, err := r.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(pvcName)
+		if err != nil || IsRWX(pvc) || pvc.Spec.VolumeName == "" {
+			continue
+		}
+		pv, err := r.pvLister.Get(pvc.Spec.VolumeName)
+		if err != nil || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != LonghornDriver {
+			continue
+		}
+		volumes = append(volumes, longhornVolumeName(pv))
+	}
+	return volumes
+}
+
+// resolve reads the healthy replicas of the pod's RWO Longhorn volumes.
+func (r *ReplicaLocality) resolve(pod *corev1.Pod) *replicaLocalityState {
+	data := &replicaLocalityState{nodes: map[string]int{}}
+	if r.replicas == nil {
+		return data
+	}
+	for _, volume := range r.rwoLonghornVolumes(pod) {
+		nodes, err := healthyReplicaNodes(r.replicas, volume)
+		if err != nil {
+			klog.V(4).InfoS("LonghornReplicaLocality: listing Longhorn Replicas failed", "pod", klog.KObj(pod), "volume", volume, "err", err)
+			continue
+		}
+		if len(nodes) == 0 {
+			continue
+		}
+		data.volumes = append(data.volumes, volume)
+		for node := range nodes {
+			data.nodes[node]++
+		}
+	}
+	return data
+}
+
+// cycleData returns what PreFilter stored in the CycleState, or resolves it
+// if the plugin was not run at PreFilter.
+func (r *ReplicaLocality) cycleData(state *framework.CycleState, pod *corev1.Pod) *replicaLocalityState {
+	if state != nil {
+		if data, err := state.Read(replicaLocalityStateKey); err == nil {
+			if s, ok := data.(*replicaLocalityState); ok {
+				return s
+			}
+		}
+	}
+	return r.resolve(pod)
+}
+
+// PreFilter implements the PreFilterPlugin interface. It reads the replicas
+// of the pod's RWO Longhorn volumes once per scheduling cycle and returns
+// Skip, bypassing Filter, unless RequireReplica is set and one of them has a
+// healthy replica.
+func (r *ReplicaLocality) PreFilter(_ context.Context, state *framework.CycleState, pod *corev1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	data := r.resolve(pod)
+	state.Write(replicaLocalityStateKey, data)
+	klog.V(4).InfoS("LonghornReplicaLocality/PreFilter: resolved replica nodes",
+		"pod", klog.KObj(pod),
+		"volumes", data.volumes,
+		"nodes", data.nodes,
+	)
+	if !r.args.RequireReplica || len(data.volumes) == 0 {
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	return nil, nil
+}
+
+// PreFilterExtensions returns nil: the replicas do not depend on the pods
+// on a node.
+func (r *ReplicaLocality) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter implements the FilterPlugin interface. With RequireReplica, it
+// rejects nodes missing a healthy replica of one of the pod's volumes.
+func (r *ReplicaLocality) Filter(_ context.Context, state *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	data := r.cycleData(state, pod)
+	if missing := len(data.volumes) - data.nodes[node.Name]; missing > 0 {
+		klog.V(4).InfoS("LonghornReplicaLocality/Filter: node rejected (no healthy replica)",
+			"pod", klog.KObj(pod),
+			"node", node.Name,
+			"missing", missing,
+		)
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("node %q holds no healthy replica of %d of the pod's Longhorn volumes", node.Name, missing))
+	}
+	return nil
+}
+
+// Score implements the ScorePlugin interface: MaxNodeScore for a node
+// holding a healthy replica of each of the pod's volumes, proportionally
+// less for a node holding replicas of some of them, and 0 for the others.
+func (r *ReplicaLocality) Score(_ context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) (int64, *framework.Status) {
+	data := r.cycleData(state, pod)
+	if len(data.volumes) == 0 {
+		return 0, nil
+	}
+	score := framework.MaxNodeScore * int64(data.nodes[nodeName]) / int64(len(data.volumes))
+	klog.V(5).InfoS("LonghornReplicaLocality/Score: node scored",
+		"pod", klog.KObj(pod),
+		"node", nodeName,
+		"score", score,
+	)
+	return score, nil
+}
+
+// ScoreExtensions returns nil: scores are already in the framework's range.
+func (r *ReplicaLocality) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
@@ -0,0 +1,205 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/backend/cache"
+	internalqueue "k8s.io/kubernetes/pkg/scheduler/backend/queue"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/defaultbinder"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/queuesort"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	schedmetrics "k8s.io/kubernetes/pkg/scheduler/metrics"
+)
+
+// makeRWOPVC creates an RWO PVC bound to pvName.
+func makeRWOPVC(name, namespace, pvName string) *corev1.PersistentVolumeClaim {
+	pvc := makePVC(name, namespace, pvName)
+	pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	return pvc
+}
+
+// newReplicaLocalityFramework builds a scheduling framework with the
+// LonghornReplicaLocality plugin enabled at PreFilter, Filter and Score,
+// backed by fake clients, and waits until the plugin's Replica informer
+// lists the replicas.
+func newReplicaLocalityFramework(t *testing.T, nodes []*corev1.Node, objects, replicas []runtime.Object, args ReplicaLocalityArgs) (framework.Framework, *ReplicaLocality) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// The scheduling queue reports metrics, which must be registered before
+	// use.
+	schedmetrics.Register()
+
+	objects = append([]runtime.Object{}, objects...)
+	for _, n := range nodes {
+		objects = append(objects, n)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	serveShareManagers(clientset, shareManagerGVR.Version)
+	informerFactory := informers.NewSharedInformerFactory(clientset, 0)
+
+	var plugin *ReplicaLocality
+	registry := frameworkruntime.Registry{
+		queuesort.Name:     queuesort.New,
+		defaultbinder.Name: defaultbinder.New,
+		ReplicaLocalityName: func(ctx context.Context, _ runtime.Object, h framework.Handle) (framework.Plugin, error) {
+			plugin = newReplicaLocality(ctx, h, args, newDynamicClient(replicas...))
+			return plugin, nil
+		},
+	}
+	profile := &config.KubeSchedulerProfile{
+		SchedulerName: "kubevirt-scheduler",
+		Plugins: &config.Plugins{
+			QueueSort: config.PluginSet{Enabled: []config.Plugin{{Name: queuesort.Name}}},
+			PreFilter: config.PluginSet{Enabled: []config.Plugin{{Name: ReplicaLocalityName}}},
+			Filter:    config.PluginSet{Enabled: []config.Plugin{{Name: ReplicaLocalityName}}},
+			Score:     config.PluginSet{Enabled: []config.Plugin{{Name: ReplicaLocalityName, Weight: 1}}},
+			Bind:      config.PluginSet{Enabled: []config.Plugin{{Name: defaultbinder.Name}}},
+		},
+	}
+	fwk, err := frameworkruntime.NewFramework(ctx, registry, profile,
+		frameworkruntime.WithClientSet(clientset),
+		frameworkruntime.WithInformerFactory(informerFactory),
+		frameworkruntime.WithSnapshotSharedLister(cache.NewSnapshot(nil, nodes)),
+		frameworkruntime.WithPodNominator(internalqueue.NewTestQueue(ctx, nil)),
+		frameworkruntime.WithEventRecorder(&events.FakeRecorder{}),
+		frameworkruntime.WithWaitingPods(frameworkruntime.NewWaitingPodsMap()),
+	)
+	if err != nil {
+		t.Fatalf("creating framework: %v", err)
+	}
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		listed, err := plugin.replicas.List(labels.Everything())
+		return len(listed) == len(replicas), err
+	}); err != nil {
+		t.Fatalf("waiting for the Replica informer: %v", err)
+	}
+	return fwk, plugin
+}
+
+// TestReplicaLocality checks that nodes are scored by the healthy replicas
+// they hold of the pod's RWO Longhorn volumes, and that nodes are only
+// filtered with requireReplica.
+func TestReplicaLocality(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		rootPV      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		dataPV      = "pvc-8c1f0b0e-2f5e-4a8b-9d0a-6f6f2a7c9b11"
+		sharedPV    = "pvc-0d6a3c4e-7b1f-4c55-a2a1-1e9b1f0c2d33"
+	)
+	nodes := []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")}
+	replicas := []runtime.Object{
+		makeReplicaCR(rootPV+"-r-1", rootPV, "node-1", "running", false),
+		makeReplicaCR(rootPV+"-r-2", rootPV, "node-2", "running", false),
+		makeReplicaCR(rootPV+"-r-3", rootPV, "node-3", "error", true),
+		makeReplicaCR(dataPV+"-r-1", dataPV, "node-2", "running", false),
+		makeReplicaCR(dataPV+"-r-2", dataPV, "node-3", "stopped", false),
+		makeReplicaCR(sharedPV+"-r-1", sharedPV, "node-3", "running", false),
+	}
+	objects := []runtime.Object{
+		makeCSIPV(rootPV, LonghornDriver), makeCSIPV(dataPV, LonghornDriver), makeCSIPV(sharedPV, LonghornDriver),
+		makeRWOPVC("rootdisk", vmNamespace, rootPV),
+		makeRWOPVC("datadisk", vmNamespace, dataPV),
+		makePVC("shared", vmNamespace, sharedPV),
+	}
+
+	tests := []struct {
+		name         string
+		pod          *corev1.Pod
+		args         ReplicaLocalityArgs
+		wantScores   map[string]int64
+		wantRejected []string
+	}{
+		{
+			name:       "one volume — running replicas score, failed ones do not",
+			pod:        makeVM("vm", vmNamespace, false, "rootdisk"),
+			wantScores: map[string]int64{"node-1": 100, "node-2": 100, "node-3": 0},
+		},
+		{
+			name:       "two volumes — nodes holding both score highest, stopped replicas count",
+			pod:        makeVM("vm", vmNamespace, false, "rootdisk", "datadisk"),
+			wantScores: map[string]int64{"node-1": 50, "node-2": 100, "node-3": 50},
+		},
+		{
+			name:       "RWX volume — left to its share-manager",
+			pod:        makeVM("vm", vmNamespace, true, "shared"),
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:         "requireReplica — nodes missing a replica rejected",
+			pod:          makeVM("vm", vmNamespace, false, "rootdisk", "datadisk"),
+			args:         ReplicaLocalityArgs{RequireReplica: true},
+			wantScores:   map[string]int64{"node-2": 100},
+			wantRejected: []string{"node-1", "node-3"},
+		},
+		{
+			name:       "requireReplica — pod without Longhorn RWO volumes not filtered",
+			pod:        makeVM("vm", vmNamespace, false, "shared"),
+			args:       ReplicaLocalityArgs{RequireReplica: true},
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwk, _ := newReplicaLocalityFramework(t, nodes, objects, replicas, tt.args)
+
+			state, m := runFilters(t, fwk, tt.pod)
+			for _, node := range tt.wantRejected {
+				if status := m.Get(node); status.Code() != framework.UnschedulableAndUnresolvable {
+					t.Errorf("status of %s = %v, want UnschedulableAndUnresolvable", node, status)
+				}
+			}
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("Filter rejected %d nodes, want %d", m.Len(), len(tt.wantRejected))
+			}
+
+			var feasible []*framework.NodeInfo
+			nodeInfos, err := fwk.SnapshotSharedLister().NodeInfos().List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ni := range nodeInfos {
+				if _, ok := tt.wantScores[ni.Node().Name]; ok {
+					feasible = append(feasible, ni)
+				}
+			}
+			scores, status := fwk.RunScorePlugins(context.Background(), state, tt.pod, feasible)
+			if !status.IsSuccess() {
+				t.Fatalf("RunScorePlugins() = %v", status)
+			}
+			for _, s := range scores {
+				if s.TotalScore != tt.wantScores[s.Name] {
+					t.Errorf("score of %s = %d, want %d", s.Name, s.TotalScore, tt.wantScores[s.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeReplicaLocalityArgs(t *testing.T) {
+	if _, err := decodeReplicaLocalityArgs(&runtime.Unknown{Raw: []byte(`{"longhornNamespace":"Not_A_Namespace"}`), ContentType: runtime.ContentTypeJSON}); err == nil {
+		t.Error("decodeReplicaLocalityArgs() accepted an invalid longhornNamespace")
+	}
+	args, err := decodeReplicaLocalityArgs(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := args.longhornNamespace(); got != LonghornNamespace {
+		t.Errorf("longhornNamespace() = %q, want %q", got, LonghornNamespace)
+	}
+}
@@ -0,0 +1,70 @@
+package longhorn_cosched
+
+import "slices"
+
+const (
+	// RookCephFSDriver is the CSI driver of the CephFS volumes of a Rook
+	// cluster in the rook-ceph namespace.
+	RookCephFSDriver = "rook-ceph.cephfs.csi.ceph.com"
+
+	// DefaultRookNamespace is the namespace the Rook gateway pods are looked
+	// up in when RookCephFSArgs.Namespace is unset.
+	DefaultRookNamespace = "rook-ceph"
+
+	// DefaultRookGatewaySelector selects the pods of Rook's ceph-nfs
+	// gateways when RookCephFSArgs.GatewaySelector is unset.
+	DefaultRookGatewaySelector = "app=rook-ceph-nfs"
+)
+
+// RookCephFSArgs co-schedule pods with Rook CephFS volumes with the gateway
+// serving them, e.g. the active ceph-nfs server or, with a selector matching
+// them, an MDS daemon.
+type RookCephFSArgs struct {
+	// Driver is the CSI driver of the CephFS volumes, which Rook prefixes
+	// with its operator namespace. Defaults to RookCephFSDriver.
+	Driver string `json:"driver,omitempty"`
+
+	// Namespace is the namespace of the Rook cluster. Defaults to
+	// DefaultRookNamespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// GatewaySelector is the label selector of the gateway pods, with
+	// PVPlaceholder standing for the PV name. Only the node of the newest
+	// Running one is used; the other gateways' nodes are not ranked.
+	// DefaultRookGatewaySelector, the default, has no PVPlaceholder, so
+	// every volume resolves to the same gateway.
+	GatewaySelector string `json:"gatewaySelector,omitempty"`
+
+	// Pin restricts hard-mode pods to the gateway's node. By default it is
+	// only scored, since a CephFS volume mounts on any node.
+	Pin bool `json:"pin,omitempty"`
+}
+
+// rwxDriver returns the rwxDrivers entry the args stand for.
+func (a RookCephFSArgs) rwxDriver() RWXDriver {
+	d := RWXDriver{
+		Driver:      a.Driver,
+		Namespace:   a.Namespace,
+		PodSelector: a.GatewaySelector,
+		ScoreOnly:   !a.Pin,
+	}
+	if d.Driver == "" {
+		d.Driver = RookCephFSDriver
+	}
+	if d.Namespace == "" {
+		d.Namespace = DefaultRookNamespace
+	}
+	if d.PodSelector == "" {
+		d.PodSelector = DefaultRookGatewaySelector
+	}
+	return d
+}
+
+// rwxDrivers returns the configured rwxDrivers entries, followed by the one
+// for RookCephFS if it is set.
+func (a Args) rwxDrivers() []RWXDriver {
+	if a.RookCephFS == nil {
+		return a.RWXDrivers
+	}
+	return append(slices.Clip(a.RWXDrivers), a.RookCephFS.rwxDriver())
+}
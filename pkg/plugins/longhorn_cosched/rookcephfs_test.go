@@ -0,0 +1,97 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeNFSGatewayPod creates a Rook ceph-nfs gateway pod on the given node.
+func makeNFSGatewayPod(name, nodeName string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: DefaultRookNamespace,
+			Labels:    map[string]string{"app": "rook-ceph-nfs", "ceph_nfs": "my-nfs"},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+// TestRookCephFS checks that pods with a Rook CephFS volume prefer the node of
+// the Running ceph-nfs gateway, are only restricted to it with pin, and are
+// left alone without the rookCephFS arg.
+func TestRookCephFS(t *testing.T) {
+	const pvName = "pvc-5e3f4b2a-8c1d-4e6f-9a7b-0c2d4e6f8a1b"
+
+	tests := []struct {
+		name         string
+		args         Args
+		wantRejected []string
+		wantScores   map[string]int64
+	}{
+		{
+			name:       "score only",
+			args:       Args{RookCephFS: &RookCephFSArgs{}},
+			wantScores: map[string]int64{"node-1": 0, "node-2": 100, "node-3": 0},
+		},
+		{
+			name:         "pin",
+			args:         Args{RookCephFS: &RookCephFSArgs{Pin: true}},
+			wantRejected: []string{"node-1", "node-3"},
+			wantScores:   map[string]int64{"node-1": 0, "node-2": 100, "node-3": 0},
+		},
+		{
+			name:       "custom gateway selector",
+			args:       Args{RookCephFS: &RookCephFSArgs{GatewaySelector: "app=rook-ceph-nfs,ceph_nfs=other-nfs"}},
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:       "not configured",
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", true, "shared")
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+				pods: []*corev1.Pod{
+					makeNFSGatewayPod("rook-ceph-nfs-my-nfs-a", "node-2", corev1.PodRunning),
+					makeNFSGatewayPod("rook-ceph-nfs-my-nfs-b", "node-3", corev1.PodFailed),
+				},
+				objects: []runtime.Object{
+					pod,
+					makePVC("shared", "default", pvName),
+					makeCSIPV(pvName, RookCephFSDriver),
+				},
+				args: tt.args,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				if status := m.Get(node); status.Code() != framework.Unschedulable {
+					t.Errorf("status of %s = %v, want Unschedulable", node, status)
+				}
+			}
+			for node, want := range tt.wantScores {
+				score, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+				if score != want {
+					t.Errorf("score of %s = %d, want %d", node, score, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// PVPlaceholder is replaced with the PV name in RWXDriver.PodName and
+// RWXDriver.PodSelector.
+const PVPlaceholder = "{pv}"
+
+// RWXDriver names the server pod of each RWX volume of a CSI driver that
+// runs one per volume, e.g. an NFS server exporting the volume, so that pods
+// mounting the volume are co-scheduled with it. Longhorn is the built-in
+// entry, whose server pods are its share-managers; an entry for
+// LonghornDriver replaces it and only sets ScoreOnly.
+type RWXDriver struct {
+	// Driver is the CSI driver of the volumes' PVs.
+	Driver string `json:"driver"`
+
+	// Namespace is the namespace the server pods run in.
+	Namespace string `json:"namespace"`
+
+	// PodName is the name of a volume's server pod, with PVPlaceholder
+	// standing for the PV name, e.g. "nfs-server-{pv}".
+	PodName string `json:"podName,omitempty"`
+
+	// PodSelector is a label selector matching a volume's server pods, with
+	// PVPlaceholder standing for the PV name, e.g. "app=nfs-server,pv={pv}".
+	// At least one of PodName and PodSelector must be set; with both, the
+	// pods of either are candidates.
+	PodSelector string `json:"podSelector,omitempty"`
+
+	// ScoreOnly only scores the server pod's node, and never restricts a
+	// hard-mode pod to it, for drivers whose volumes mount equally well on
+	// any node.
+	ScoreOnly bool `json:"scoreOnly,omitempty"`
+}
+
+// examplePVName is a PV name, as the external provisioner names them, that
+// the templates of an RWXDriver are validated with.
+const examplePVName = "pvc-00000000-0000-0000-0000-000000000000"
+
+// podName returns the name of the server pod of the PV, or "" if the entry
+// names none.
+func (d RWXDriver) podName(pvName string) string {
+	return strings.ReplaceAll(d.PodName, PVPlaceholder, pvName)
+}
+
+// podSelector returns the selector of the server pods of the PV, or nil if
+// the entry sets none.
+func (d RWXDriver) podSelector(pvName string) (labels.Selector, error) {
+	if d.PodSelector == "" {
+		return nil, nil
+	}
+	return labels.Parse(strings.ReplaceAll(d.PodSelector, PVPlaceholder, pvName))
+}
+
+// validateRWXDrivers checks the rwxDrivers arg.
+func validateRWXDrivers(drivers []RWXDriver) error {
+	seen := map[string]bool{}
+	for _, d := range drivers {
+		switch {
+		case d.Driver == "":
+			return errors.New("rwxDrivers entry without a driver")
+		case seen[d.Driver]:
+			return fmt.Errorf("rwxDrivers entry %q listed twice", d.Driver)
+		case d.Driver == LonghornDriver && (d.Namespace != "" || d.PodName != "" || d.PodSelector != ""):
+			return fmt.Errorf("rwxDrivers entry %q: only scoreOnly can be set, share-managers are found by their own names", d.Driver)
+		case d.Driver == LonghornDriver:
+			seen[d.Driver] = true
+			continue
+		case d.PodName == "" && d.PodSelector == "":
+			return fmt.Errorf("rwxDrivers entry %q: one of podName and podSelector is required", d.Driver)
+		}
+		seen[d.Driver] = true
+		if errs := validation.IsDNS1123Label(d.Namespace); len(errs) > 0 {
+			return fmt.Errorf("rwxDrivers entry %q: namespace %q: %s", d.Driver, d.Namespace, strings.Join(errs, "; "))
+		}
+		if name := d.podName(examplePVName); name != "" {
+			if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+				return fmt.Errorf("rwxDrivers entry %q: podName %q: %s", d.Driver, d.PodName, strings.Join(errs, "; "))
+			}
+		}
+		if _, err := d.podSelector(examplePVName); err != nil {
+			return fmt.Errorf("rwxDrivers entry %q: podSelector %q: %w", d.Driver, d.PodSelector, err)
+		}
+	}
+	return nil
+}
+
+// rwxLookup resolves the placement of a bound RWX PVC of an rwxDrivers
+// entry.
+type rwxLookup func(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pvc *corev1.PersistentVolumeClaim, server RWXDriver, opts lookupOptions) (shareManagerPlacement, error)
+
+// lookup returns how the entry's volumes are placed: Longhorn's through
+// their share-manager, the others' through the server pod the entry names.
+func (d RWXDriver) lookup() rwxLookup {
+	if d.Driver == LonghornDriver {
+		return getShareManagerPlacementForPVC
+	}
+	return getServerPodPlacementForPVC
+}
+
+// rwxDriver returns the rwxDrivers entry of the CSI driver, if there is one.
+// Longhorn has a built-in entry unless one is configured.
+func (o lookupOptions) rwxDriver(driver string) (RWXDriver, bool) {
+	for _, d := range o.drivers {
+		if d.Driver == driver {
+			return d, true
+		}
+	}
+	if driver == LonghornDriver {
+		return RWXDriver{Driver: LonghornDriver}, true
+	}
+	return RWXDriver{}, false
+}
+
+// getServerPodPlacementForPVC resolves the node of the server pod of a bound
+// RWX PVC of an rwxDrivers entry. The placement is named after the pod
+// source; the Longhorn fallbacks after the lookup order do not apply.
+func getServerPodPlacementForPVC(ctx context.Context, clientset kubernetes.Interface, _ dynamic.Interface, pvc *corev1.PersistentVolumeClaim, server RWXDriver, opts lookupOptions) (shareManagerPlacement, error) {
+	pvName := pvc.Spec.VolumeName
+	pl, err := getServerPodPlacement(ctx, clientset, server, pvName, opts)
+	if err != nil {
+		lookupResults.WithLabelValues(lookupResultError).Inc()
+		return shareManagerPlacement{pvc: pvc.Name}, err
+	}
+	if pl.node == "" {
+		klog.V(5).InfoS("LonghornCoSchedule: no server pod found for RWX PVC", "pvc", klog.KObj(pvc), "pv", pvName, "driver", server.Driver)
+		lookupResults.WithLabelValues(lookupResultNone).Inc()
+		return shareManagerPlacement{pvc: pvc.Name}, nil
+	}
+	klog.V(5).InfoS("LonghornCoSchedule: found the server pod of RWX PVC",
+		"pvc", klog.KObj(pvc),
+		"pv", pvName,
+		"driver", server.Driver,
+		"node", pl.node,
+	)
+	lookupResults.WithLabelValues(string(LookupSourcePod)).Inc()
+	pl.pvc, pl.pv, pl.from, pl.driver = pvc.Name, pvName, LookupSourcePod, server.Driver
+	return pl, nil
+}
+
+// getServerPodPlacement looks up the server pod of a PV of an rwxDrivers
+// entry and returns the node it runs on, like getShareManagerNodeFromPod does
+// for a share-manager: pods being deleted are ignored, the newest of the
+// others is used, and opts.acceptPending and opts.requireReady apply. With
+// opts.strict, a failure to read the server pods is returned as a
+// *sourceLookupError.
+func getServerPodPlacement(ctx context.Context, clientset kubernetes.Interface, server RWXDriver, pvName string, opts lookupOptions) (shareManagerPlacement, error) {
+	path := string(LookupSourcePod)
+	if !opts.forbidden.allow(path, server.Namespace) {
+		if opts.strict {
+			return shareManagerPlacement{}, &sourceLookupError{source: LookupSourcePod, pv: pvName, err: errPathForbidden}
+		}
+		return shareManagerPlacement{}, nil
+	}
+	serverPod, err := newestServerPod(ctx, clientset, server, pvName, opts)
+	opts.observe(path, server.Namespace, err)
+	if err != nil && opts.strict {
+		return shareManagerPlacement{}, &sourceLookupError{source: LookupSourcePod, pv: pvName, err: err}
+	}
+	if err != nil || serverPod == nil {
+		return shareManagerPlacement{}, nil
+	}
+	return podPlacement(serverPod, opts), nil
+}
+
+// newestServerPod returns the most recently created server pod of the PV
+// that is not being deleted, or nil if there is none. Of pods created at the
+// same time, the first by name wins, as in an API list. The pods are read
+// from opts.podLister when it is set.
+func newestServerPod(ctx context.Context, clientset kubernetes.Interface, server RWXDriver, pvName string, opts lookupOptions) (*corev1.Pod, error) {
+	var candidates []*corev1.Pod
+	selector, err := server.podSelector(pvName)
+	if err != nil {
+		return nil, err
+	}
+	if selector != nil {
+		pods, err := listServerPods(ctx, clientset, server.Namespace, selector, opts)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, pods...)
+	}
+	if name := server.podName(pvName); name != "" {
+		byName, err := getServerPod(ctx, clientset, server.Namespace, name, opts)
+		if err == nil {
+			candidates = append(candidates, byName)
+		} else if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	var newest *corev1.Pod
+	for _, pod := range candidates {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if newest == nil || newest.CreationTimestamp.Before(&pod.CreationTimestamp) ||
+			(newest.CreationTimestamp.Equal(&pod.CreationTimestamp) && pod.Name < newest.Name) {
+			newest = pod
+		}
+	}
+	return newest, nil
+}
+
+// listServerPods lists the pods in namespace matching selector.
+func listServerPods(ctx context.Context, clientset kubernetes.Interface, namespace string, selector labels.Selector, opts lookupOptions) ([]*corev1.Pod, error) {
+	if opts.podLister != nil {
+		return opts.podLister.Pods(namespace).List(selector)
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*corev1.Pod, 0, len(pods.Items))
+	for i := range pods.Items {
+		list = append(list, &pods.Items[i])
+	}
+	return list, nil
+}
+
+// getServerPod returns the named pod in namespace.
+func getServerPod(ctx context.Context, clientset kubernetes.Interface, namespace, name string, opts lookupOptions) (*corev1.Pod, error) {
+	if opts.podLister != nil {
+		return opts.podLister.Pods(namespace).Get(name)
+	}
+	return clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+}
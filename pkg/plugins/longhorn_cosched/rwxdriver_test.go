@@ -0,0 +1,125 @@
+package longhorn_cosched
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// exampleNFSDriver is a fictional CSI driver running an NFS server pod per
+// RWX volume.
+const exampleNFSDriver = "nfs.csi.example.com"
+
+// makeServerPod creates a Running NFS server pod for the PV on the given node.
+func makeServerPod(pvName, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nfs-server-" + pvName,
+			Namespace: "nfs-system",
+			Labels:    map[string]string{"app": "nfs-server", "example.com/pv": pvName},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+// TestRWXDrivers checks that a hard-mode pod with an RWX volume of an
+// rwxDrivers entry is pinned to the node of the volume's server pod, found by
+// name or by label, and that volumes of drivers not listed do not pin it.
+func TestRWXDrivers(t *testing.T) {
+	const pvName = "pvc-1c0a5a34-64c5-4a42-9a8e-3e8d6f0b7a21"
+
+	tests := []struct {
+		name         string
+		drivers      []RWXDriver
+		wantRejected []string
+	}{
+		{
+			name:         "pod name",
+			drivers:      []RWXDriver{{Driver: exampleNFSDriver, Namespace: "nfs-system", PodName: "nfs-server-{pv}"}},
+			wantRejected: []string{"node-1", "node-3"},
+		},
+		{
+			name:         "pod selector",
+			drivers:      []RWXDriver{{Driver: exampleNFSDriver, Namespace: "nfs-system", PodSelector: "app=nfs-server,example.com/pv={pv}"}},
+			wantRejected: []string{"node-1", "node-3"},
+		},
+		{
+			name:    "server pod in another namespace",
+			drivers: []RWXDriver{{Driver: exampleNFSDriver, Namespace: "storage", PodName: "nfs-server-{pv}"}},
+		},
+		{name: "driver not listed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", true, "shared")
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+				pods:  []*corev1.Pod{makeServerPod(pvName, "node-2")},
+				objects: []runtime.Object{
+					pod,
+					makePVC("shared", "default", pvName),
+					makeCSIPV(pvName, exampleNFSDriver),
+				},
+				args: Args{RWXDrivers: tt.drivers},
+			})
+
+			_, m := runFilters(t, fwk, pod)
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				status := m.Get(node)
+				if status.Code() != framework.Unschedulable {
+					t.Errorf("status of %s = %v, want Unschedulable", node, status)
+				}
+				if !strings.Contains(status.Message(), `"node-2" where nfs.csi.example.com server pod`) {
+					t.Errorf("status of %s = %q, want it to name the server pod and its node", node, status.Message())
+				}
+			}
+		})
+	}
+}
+
+// TestLonghornRWXDriver checks that Longhorn volumes are looked up through
+// the built-in entry, pinning a hard-mode pod to the share-manager node, and
+// that a configured entry for Longhorn with scoreOnly replaces it.
+func TestLonghornRWXDriver(t *testing.T) {
+	const pvName = "pvc-1c0a5a34-64c5-4a42-9a8e-3e8d6f0b7a21"
+
+	tests := []struct {
+		name         string
+		drivers      []RWXDriver
+		wantRejected int
+	}{
+		{name: "built-in entry", wantRejected: 2},
+		{name: "other entries only", drivers: []RWXDriver{{Driver: exampleNFSDriver, Namespace: "nfs-system", PodName: "nfs-server-{pv}"}}, wantRejected: 2},
+		{name: "scoreOnly entry", drivers: []RWXDriver{{Driver: LonghornDriver, ScoreOnly: true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", true, "shared")
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+				pods:  []*corev1.Pod{makeShareManagerPod(pvName, "node-2")},
+				objects: []runtime.Object{
+					pod,
+					makePVC("shared", "default", pvName),
+					makeCSIPV(pvName, LonghornDriver),
+				},
+				args: Args{RWXDrivers: tt.drivers},
+			})
+
+			_, m := runFilters(t, fwk, pod)
+			if m.Len() != tt.wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), tt.wantRejected)
+			}
+		})
+	}
+}
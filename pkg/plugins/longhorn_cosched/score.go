@@ -3,29 +3,41 @@ package longhorn_cosched
 import (
 	"context"
 	"fmt"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/helper"
 )
 
 // Score implements the ScorePlugin interface.
 //
-// If the pod has the co-scheduling annotation and a Longhorn share-manager pod
-// is already running for one of its RWX PVCs, the node where the share-manager
-// runs receives the maximum score (100). All other nodes receive 0.
+// If the pod has the co-scheduling annotation and Longhorn share-manager pods
+// are running for its RWX PVCs, each node receives MaxNodeScore * matched /
+// total, where matched is the number of the pod's share-managers on the node
+// and total the number found; NormalizeScore rescales the result. Hotplug
+// attachment pods, CDI pods and migration targets are scored by their own
+// nodes (see replicaScores and scoreMigrationTarget), and the bonuses and
+// penalties added on top are documented on Args.
 //
-// If the pod does not have the annotation, is a migration target, or no
-// share-manager pod is found, all nodes receive 0 (neutral — the plugin is a no-op).
-func (p *Plugin) Score(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) (int64, *framework.Status) {
+// If the pod does not have the annotation, is in observe mode, is a skipped
+// migration target, or no share-manager pod is found, all nodes receive 0
+// (neutral — the plugin is a no-op).
+func (p *Plugin) Score(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) (score int64, status *framework.Status) {
+	defer func(start time.Time) { observeExtensionPoint(extensionPointScore, start, status) }(time.Now())
 	podKey := klog.KObj(pod)
 
-	if !isOptedIn(pod) {
-		klog.V(5).InfoS("LonghornCoSchedule/Score: pod not opted in, skipping", "pod", podKey, "node", nodeName)
-		return 0, nil
+	if hotplugOwner(pod) != "" {
+		return p.scoreHotplug(ctx, state, pod, nodeName)
+	}
+	if p.coSchedulesCDIPod(pod) {
+		return p.scoreCDIPod(ctx, state, pod, nodeName)
 	}
+	defer func() { recordScoreResult(score, status, cycleDecision(state)) }()
 
-	if isMigrationTarget(pod) {
+	mode := p.cycleMode(ctx, state, pod)
+	if mode == "" && p.isMigrationTargetPod(ctx, pod) {
 		klog.V(4).InfoS("LonghornCoSchedule/Score: migration target pod, skipping (KubeVirt migration controller handles placement)",
 			"pod", podKey,
 			"node", nodeName,
@@ -34,41 +46,140 @@ func (p *Plugin) Score(ctx context.Context, state *framework.CycleState, pod *co
 		return 0, nil
 	}
 
-	shareManagerNode, err := findShareManagerNode(ctx, p.clientset, p.dynClient, pod)
+	if mode == "" {
+		klog.V(5).InfoS("LonghornCoSchedule/Score: pod not opted in, skipping", "pod", podKey, "node", nodeName)
+		return 0, nil
+	}
+
+	if mode == ModeObserve {
+		klog.V(5).InfoS("LonghornCoSchedule/Score: observe mode, scoring 0", "pod", podKey, "node", nodeName)
+		return 0, nil
+	}
+
+	if reason := p.longhornNodeUnschedulable(nodeName); reason != "" {
+		klog.V(4).InfoS("LonghornCoSchedule/Score: Longhorn Node unschedulable, scoring 0",
+			"pod", podKey,
+			"node", nodeName,
+			"reason", reason,
+		)
+		return 0, nil
+	}
+
+	data, err := p.cycleData(ctx, state, pod)
 	if err != nil {
 		klog.ErrorS(err, "LonghornCoSchedule/Score: error looking up share-manager", "pod", podKey, "node", nodeName)
 		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("error looking up share-manager pod: %v", err))
 	}
 
-	// No share-manager found yet — neutral score for all nodes.
-	if shareManagerNode == "" {
-		klog.V(4).InfoS("LonghornCoSchedule/Score: no share-manager found, scoring 0",
+	// The node's Longhorn Node lacks a node tag the pod's Longhorn Volumes
+	// select: Longhorn will not place replicas there.
+	if missing := p.missingNodeTags(nodeName, data.volumeTags); len(missing) > 0 {
+		klog.V(4).InfoS("LonghornCoSchedule/Score: Longhorn Node lacks volume node tags, scoring 0",
 			"pod", podKey,
 			"node", nodeName,
+			"missingTags", missing,
 		)
 		return 0, nil
 	}
 
-	// Give the share-manager's node the maximum score.
-	if nodeName == shareManagerNode {
-		klog.V(4).InfoS("LonghornCoSchedule/Score: node matches share-manager, scoring max",
+	if p.isMigrationTargetPod(ctx, pod) {
+		return p.scoreMigrationTarget(pod, data, nodeName), nil
+	}
+
+	// Holding a replica of a best-effort volume saves Longhorn a rebuild.
+	bonus := p.replicaBonus(data, nodeName)
+
+	// No share-manager found, e.g. because the volume is detached while the
+	// VM was stopped: prefer the node the VM ran on before, if known.
+	if len(data.placements) == 0 && data.previousNode != "" {
+		score = bonus
+		if nodeName == data.previousNode {
+			score += framework.MaxNodeScore
+		}
+		klog.V(4).InfoS("LonghornCoSchedule/Score: no share-manager found, scoring by previous virt-launcher node",
+			"pod", podKey,
+			"node", nodeName,
+			"previousNode", data.previousNode,
+			"replicaBonus", bonus,
+			"score", score,
+		)
+		return score, nil
+	}
+
+	// No share-manager found yet — neutral score for all nodes but those
+	// holding a replica. The same applies when the conflict policy rejects
+	// every node.
+	if len(data.placements) == 0 || data.unresolvable {
+		klog.V(4).InfoS("LonghornCoSchedule/Score: no share-manager found, scoring by best-effort replicas",
+			"pod", podKey,
+			"node", nodeName,
+			"score", bonus,
+		)
+		return bonus, nil
+	}
+
+	// Score by the share of the pod's share-managers the node hosts, and
+	// of those in its zone.
+	matched, total := data.nodeCounts[nodeName], len(data.placements)
+	zone := p.zoneScore(data, nodeName)
+	score = data.nodeScores[nodeName]/int64(total) + zone + bonus
+	if matched == 0 {
+		klog.V(4).InfoS("LonghornCoSchedule/Score: node does not match share-manager, scoring by zone and best-effort replicas",
 			"pod", podKey,
 			"node", nodeName,
-			"shareManagerNode", shareManagerNode,
-			"score", framework.MaxNodeScore,
+			"shareManagerNode", data.shareManagerNode,
+			"conflictNodes", data.conflictNodes,
+			"zoneScore", zone,
+			"replicaBonus", bonus,
+			"score", score,
 		)
-		return framework.MaxNodeScore, nil
+		return score, nil
 	}
 
-	klog.V(4).InfoS("LonghornCoSchedule/Score: node does not match share-manager, scoring 0",
+	klog.V(4).InfoS("LonghornCoSchedule/Score: node matches share-manager, scoring by share of co-located share-managers",
 		"pod", podKey,
 		"node", nodeName,
-		"shareManagerNode", shareManagerNode,
+		"matched", matched,
+		"total", total,
+		"shareManagerNode", data.shareManagerNode,
+		"conflictNodes", data.conflictNodes,
+		"zoneScore", zone,
+		"replicaBonus", bonus,
+		"score", score,
 	)
-	return 0, nil
+	return score, nil
 }
 
-// ScoreExtensions returns nil because this plugin does not implement score extensions.
+// ScoreExtensions returns the plugin itself, which implements NormalizeScore.
 func (p *Plugin) ScoreExtensions() framework.ScoreExtensions {
+	return p
+}
+
+// NormalizeScore implements the ScoreExtensions interface.
+//
+// It rescales the raw scores of a cycle so the highest one becomes
+// MaxNodeScore and the others keep their proportion to it. If every node
+// scored 0 the scores are left at 0. With PinnedVMPenalty, the rescaled
+// scores are then balanced (see balanceScores).
+func (p *Plugin) NormalizeScore(_ context.Context, state *framework.CycleState, pod *corev1.Pod, scores framework.NodeScoreList) *framework.Status {
+	if status := helper.DefaultNormalizeScore(framework.MaxNodeScore, false, scores); !status.IsSuccess() {
+		return status
+	}
+	p.balanceScores(state, pod, scores)
 	return nil
 }
+
+// recordScoreResult counts a score given to a node in the cycle that decided
+// d.
+func recordScoreResult(score int64, status *framework.Status, d Decision) {
+	result := scoreResultPartial
+	switch {
+	case !status.IsSuccess():
+		result = scoreResultError
+	case score >= framework.MaxNodeScore:
+		result = scoreResultMax
+	case score <= 0:
+		result = scoreResultZero
+	}
+	scoreResults.WithLabelValues(result, d.Outcome).Inc()
+}
@@ -2,49 +2,334 @@ package longhorn_cosched
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/klog/v2"
 )
 
-// shareManagerGVR is the GroupVersionResource for the Longhorn ShareManager CRD.
+// shareManagerGVR is the GroupVersionResource for the Longhorn ShareManager
+// CRD. The version actually queried is discovered at startup, see
+// shareManagerAPI; this one is used when discovery has not run.
 var shareManagerGVR = schema.GroupVersionResource{
 	Group:    "longhorn.io",
 	Version:  "v1beta2",
 	Resource: "sharemanagers",
 }
 
-// findShareManagerNode looks up the node where the Longhorn share-manager for
-// any of the RWX PVCs referenced by the given pod is running (or assigned).
+// shareManagerPlacement is the node of the share-manager serving one of a
+// pod's RWX PVCs.
+type shareManagerPlacement struct {
+	pvc  string
+	node string
+
+	// pod is set, instead of pvc, for the pod named by the co-schedule-with
+	// annotation, as namespace/name. It is placed like a share-manager.
+	pod string
+
+	// pending is set when the node comes from a share-manager pod that is
+	// scheduled but not Running yet, a lower-confidence answer.
+	pending bool
+
+	// notReady is set, when the requireShareManagerReady arg is on, for a
+	// share-manager that runs but is not Ready (or a ShareManager still
+	// starting). Such a placement is only scored; it does not restrict the
+	// pod to its node.
+	notReady bool
+
+	// migratable is set for a Longhorn migratable block volume, which has no
+	// share-manager; node is the node the volume is attached to. Such a
+	// placement is only scored, since the volume can attach anywhere.
+	migratable bool
+
+	// consumer is set when no share-manager was found and node is that of
+	// another pod mounting the PVC. Such a placement is only scored;
+	// nominated consumers score less than Running ones.
+	consumer, nominated bool
+
+	// updated is when the ShareManager or the share-manager pod the node
+	// comes from was last written, for SourceDisagreementPolicyNewest.
+	updated time.Time
+
+	// stopped is set, with the PreferStoppedShareManagerNode arg, for a
+	// ShareManager in the stopped state; node is its last status.ownerID.
+	// Such a placement is only scored.
+	stopped bool
+
+	// attachment is set when no share-manager was found and node is that of
+	// an attached VolumeAttachment of the PV, i.e. of a consumer. Such a
+	// placement is only scored.
+	attachment bool
+
+	// driver is set, for an RWX volume of an rwxDrivers entry other than
+	// Longhorn's, to its CSI driver; node is then that of the volume's
+	// server pod.
+	driver string
+
+	// scoreOnly is set for the placement of an rwxDrivers entry with
+	// scoreOnly. Such a placement is only scored.
+	scoreOnly bool
+
+	// pv is the PV bound to pvc, and from the lookup source that named the
+	// node, for status messages. from is empty for the fallbacks after the
+	// configured lookup order.
+	pv   string
+	from LookupSource
+
+	// crdErr is the error reading the ShareManager CRD that the lookup
+	// moved past. The CRD source returns it on an empty placement; on the
+	// placement the share-manager pod source then found, it marks a fallback
+	// from a failing CRD, counted in crdPodFallbacks.
+	crdErr error
+}
+
+// source describes what the placement is for, for status messages.
+func (pl shareManagerPlacement) source() string {
+	if pl.pod != "" {
+		return fmt.Sprintf("pod %q", pl.pod)
+	}
+	return fmt.Sprintf("PVC %q", pl.pvc)
+}
+
+// pins returns true if the placement restricts a hard-mode pod to its node.
+func (pl shareManagerPlacement) pins() bool {
+	return !pl.notReady && !pl.migratable && !pl.consumer && !pl.attachment && !pl.stopped && !pl.scoreOnly
+}
+
+// lookupOptions tune how the share-manager of a PVC is looked up.
+type lookupOptions struct {
+	// acceptPending accepts a share-manager pod that is scheduled to a node
+	// but still Pending.
+	acceptPending bool
+
+	// requireReady marks share-managers that are not Ready as notReady.
+	requireReady bool
+
+	// crd tracks ShareManager CRD lookup failures. It may be nil.
+	crd *crdGuard
+
+	// forbidden tracks Forbidden errors per lookup path and skips paths
+	// that keep failing with them. It may be nil.
+	forbidden *forbiddenGuard
+
+	// readableNamespaces are the namespaces PVCs may be read in. When empty,
+	// every namespace is readable.
+	readableNamespaces []string
+
+	// hotplugPVCs are the claims of the pod's hotplugged volumes, which
+	// findShareManagerPlacements leaves out.
+	hotplugPVCs []string
+
+	// api resolves the served ShareManager CRD version. It may be nil.
+	api *shareManagerAPI
+
+	// pvcLister, pvLister and scLister read PVCs, PVs and StorageClasses
+	// from the informer cache. When nil, they are read through the
+	// clientset.
+	pvcLister corelisters.PersistentVolumeClaimLister
+	pvLister  corelisters.PersistentVolumeLister
+	scLister  storagelisters.StorageClassLister
+
+	// vaLister reads VolumeAttachments from the informer cache. When nil,
+	// they are listed through the clientset.
+	vaLister storagelisters.VolumeAttachmentLister
+
+	// podLister reads share-manager pods and the server pods of rwxDrivers
+	// entries from the informer cache. When nil, they are read through the
+	// clientset.
+	podLister corelisters.PodLister
+
+	// engineStates are the accepted ShareManager states per data engine.
+	// When empty, DefaultShareManagerStates is used without reading the
+	// volume's engine.
+	engineStates map[DataEngine][]string
+
+	// namespace is the namespace Longhorn runs in. When empty,
+	// LonghornNamespace is used.
+	namespace string
+
+	// order is the order in which the lookup sources are asked. When
+	// empty, DefaultLookupOrder is used.
+	order []LookupSource
+
+	// consumers finds other pods mounting a PVC whose share-manager was not
+	// found. It may be nil.
+	consumers consumerFinder
+
+	// disagreement, when set, asks both the ShareManager CRD and the
+	// share-manager pod and resolves a disagreement between them.
+	disagreement SourceDisagreementPolicy
+
+	// preferStopped returns the last status.ownerID of a stopped
+	// ShareManager as a stopped placement.
+	preferStopped bool
+
+	// drivers are the rwxDrivers entries: CSI drivers whose RWX volumes are
+	// served by a server pod. Longhorn's built-in entry, looked up through
+	// its share-manager, applies unless one of them replaces it.
+	drivers []RWXDriver
+
+	// strict fails the lookup with a *sourceLookupError when the
+	// ShareManager CRD or the share-manager pods cannot be read, instead of
+	// asking the next source. It is set for hard-mode pods by the
+	// strictErrors arg.
+	strict bool
+}
+
+// longhornNamespace returns the namespace share-managers are looked up in.
+func (o lookupOptions) longhornNamespace() string {
+	if o.namespace == "" {
+		return LonghornNamespace
+	}
+	return o.namespace
+}
+
+// lookupOrder returns the order in which the lookup sources are asked.
+func (o lookupOptions) lookupOrder() []LookupSource {
+	if len(o.order) == 0 {
+		return DefaultLookupOrder
+	}
+	return o.order
+}
+
+// lookupOptions returns the share-manager lookup options for the pod, set by
+// the args and the pod's Longhorn namespace annotation.
+func (p *Plugin) lookupOptions(pod *corev1.Pod) lookupOptions {
+	namespace, _ := p.podLonghornNamespace(pod)
+	return lookupOptions{
+		acceptPending:      p.args.AcceptPendingShareManager,
+		requireReady:       p.args.RequireShareManagerReady,
+		crd:                p.crd,
+		forbidden:          p.forbidden,
+		api:                p.shareManagers,
+		pvcLister:          p.pvcLister,
+		pvLister:           p.pvLister,
+		scLister:           p.scLister,
+		vaLister:           p.vaLister,
+		podLister:          p.podLister,
+		engineStates:       p.args.ShareManagerStates,
+		namespace:          namespace,
+		order:              p.args.LookupOrder,
+		disagreement:       p.args.SourceDisagreementPolicy,
+		preferStopped:      p.args.PreferStoppedShareManagerNode,
+		readableNamespaces: p.args.ReadableNamespaces,
+		drivers:            p.args.rwxDrivers(),
+		consumers: func(namespace, pvcName string) (string, bool) {
+			return p.consumerNode(pod, namespace, pvcName)
+		},
+	}
+}
+
+// findShareManagerPlacements looks up the node where the Longhorn
+// share-manager for each of the RWX PVCs referenced by the given pod is
+// running (or assigned). Only the PVCs named in the co-schedule-pvc
+// annotation are considered when it is set. PVCs without a share-manager are
+// left out; the result follows the order of the pod's volumes.
 //
-// It first queries the ShareManager CRD (status.ownerID), which is set by
-// Longhorn before the share-manager pod reaches Running phase. This avoids the
-// chicken-and-egg problem where the pod hasn't started yet when the VM is
-// being scheduled.
+// By default it first queries the ShareManager CRD (status.ownerID), which is
+// set by Longhorn before the share-manager pod reaches Running phase. This
+// avoids the chicken-and-egg problem where the pod hasn't started yet when the
+// VM is being scheduled.
 //
 // If the CRD lookup yields nothing, it falls back to inspecting the
-// share-manager pod directly (for compatibility with non-standard setups).
-func findShareManagerNode(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pod *corev1.Pod) (string, error) {
-	pvcNames := collectPVCNames(pod)
-	if len(pvcNames) == 0 {
-		return "", nil
-	}
+// share-manager pod directly (for compatibility with non-standard setups),
+// then to the Longhorn Volume's status.currentNodeID, then to the node of an
+// attached VolumeAttachment of the PV, and then to the node of another pod
+// mounting the PVC.
+//
+// Its duration is observed in lookupDuration; the result of each PVC's lookup
+// is counted in lookupResults.
+func findShareManagerPlacements(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pod *corev1.Pod, opts lookupOptions) ([]shareManagerPlacement, error) {
+	defer func(start time.Time) { lookupDuration.Observe(time.Since(start).Seconds()) }(time.Now())
 
-	for _, pvcName := range pvcNames {
-		node, err := getShareManagerNodeForPVC(ctx, clientset, dynClient, pod.Namespace, pvcName)
+	var placements []shareManagerPlacement
+	for _, pvcName := range coSchedulePVCNames(pod, opts.hotplugPVCs) {
+		pl, err := getShareManagerNodeForPVC(ctx, clientset, dynClient, pod.Namespace, pvcName, opts)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		if node != "" {
-			return node, nil
+		if pl.node != "" {
+			placements = append(placements, pl)
 		}
 	}
+	return placements, nil
+}
+
+// ShareManagerPlacement is the node found for the share-manager of one of a
+// pod's RWX PVCs by FindShareManagerPlacements.
+type ShareManagerPlacement struct {
+	PVC  string `json:"pvc"`
+	PV   string `json:"pv"`
+	Node string `json:"node"`
+
+	// Source is the lookup source that named the node. It is empty for the
+	// VolumeAttachment fallback and for migratable block volumes.
+	Source LookupSource `json:"source,omitempty"`
 
-	return "", nil
+	// Pins is false when the node should only be preferred, never required:
+	// the share-manager is not Ready (with RequireShareManagerReady), the
+	// volume is a migratable block volume, or the node is that of an
+	// attached VolumeAttachment or of a stopped ShareManager, or of the
+	// server pod or share-manager of a scoreOnly RWXDriver.
+	Pins bool `json:"pins"`
+}
+
+// LookupConfig tunes FindShareManagerPlacements like the plugin args of the
+// same names tune the scheduler's lookup.
+type LookupConfig struct {
+	// LonghornNamespace defaults to LonghornNamespace.
+	LonghornNamespace string
+
+	// LookupOrder defaults to DefaultLookupOrder.
+	LookupOrder []LookupSource
+
+	AcceptPendingShareManager bool
+	RequireShareManagerReady  bool
+
+	// RWXDrivers are looked up like the rwxDrivers arg.
+	RWXDrivers []RWXDriver
+}
+
+// lookupOptions returns the lookup options the config selects.
+func (c LookupConfig) lookupOptions() lookupOptions {
+	return lookupOptions{
+		acceptPending: c.AcceptPendingShareManager,
+		requireReady:  c.RequireShareManagerReady,
+		namespace:     c.LonghornNamespace,
+		order:         c.LookupOrder,
+		drivers:       c.RWXDrivers,
+	}
+}
+
+// FindShareManagerPlacements looks up the share-manager nodes of the pod's
+// Longhorn RWX PVCs outside the scheduler, e.g. from an admission webhook,
+// with the same lookup the plugin runs in PreFilter. PVCs without a
+// share-manager are left out. Unlike in the scheduler, other pods mounting a
+// PVC are not followed, PVs and StorageClasses are read through the clientset
+// and the ShareManager CRD version is not discovered.
+func FindShareManagerPlacements(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pod *corev1.Pod, config LookupConfig) ([]ShareManagerPlacement, error) {
+	placements, err := findShareManagerPlacements(ctx, clientset, dynClient, pod, config.lookupOptions())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ShareManagerPlacement, 0, len(placements))
+	for _, pl := range placements {
+		result = append(result, ShareManagerPlacement{PVC: pl.pvc, PV: pl.pv, Node: pl.node, Source: pl.from, Pins: pl.pins()})
+	}
+	return result, nil
 }
 
 // collectPVCNames returns all PVC names referenced by the pod's volumes.
@@ -58,92 +343,509 @@ func collectPVCNames(pod *corev1.Pod) []string {
 	return names
 }
 
+// pvcLookupError is returned when a PVC could not be read for a reason other
+// than it not existing, e.g. RBAC or an API timeout.
+type pvcLookupError struct {
+	namespace, name string
+	err             error
+}
+
+func (e *pvcLookupError) Error() string {
+	return fmt.Sprintf("getting PVC %s/%s: %v", e.namespace, e.name, e.err)
+}
+
+func (e *pvcLookupError) Unwrap() error {
+	return e.err
+}
+
+// errCRDSuspended is the cause of a strict-mode lookup failure while the
+// ShareManager CRD is skipped after CRDFailureThreshold failures.
+var errCRDSuspended = errors.New("ShareManager CRD lookups suspended after repeated failures")
+
+// sourceLookupError is returned in strict mode when a lookup source could not
+// be read, e.g. because of RBAC or an API timeout.
+type sourceLookupError struct {
+	source LookupSource
+	pv     string
+	err    error
+}
+
+func (e *sourceLookupError) Error() string {
+	return fmt.Sprintf("looking up the share-manager of PV %s from source %q: %v", e.pv, e.source, e.err)
+}
+
+func (e *sourceLookupError) Unwrap() error {
+	return e.err
+}
+
+// errorReason returns the API status reason of err for metric labels, or
+// "Unknown".
+func errorReason(err error) string {
+	if reason := apierrors.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+		return string(reason)
+	}
+	return "Unknown"
+}
+
+// errorClass returns the class of a lookup error for the lookupErrors metric:
+// notFound, forbidden, timeout, parse (a malformed Longhorn object) or other.
+func errorClass(err error) string {
+	var parseErr *shareManagerParseError
+	switch {
+	case apierrors.IsNotFound(err):
+		return lookupErrorNotFound
+	case apierrors.IsForbidden(err):
+		return lookupErrorForbidden
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return lookupErrorTimeout
+	case errors.As(err, &parseErr):
+		return lookupErrorParse
+	default:
+		return lookupErrorOther
+	}
+}
+
+// observe records the outcome of a read on a lookup path in namespace: an
+// error is counted in lookupErrors by class, and the forbidden guard sees it.
+func (o lookupOptions) observe(path, namespace string, err error) {
+	if err != nil {
+		lookupErrors.WithLabelValues(path, errorClass(err)).Inc()
+	}
+	o.forbidden.observe(path, namespace, err)
+}
+
 // getShareManagerNodeForPVC resolves the node for the share-manager of a
-// specific PVC. It tries the ShareManager CRD first, then falls back to the
-// share-manager pod.
-func getShareManagerNodeForPVC(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, podNamespace, pvcName string) (string, error) {
+// specific PVC, or for the server pod of its rwxDrivers entry, with the
+// lookup of the entry of the PV's driver. The returned placement has an
+// empty node if none was found. A missing PVC is skipped, and so is
+// one in a namespace outside opts.readableNamespaces or whose reads are
+// skipped after repeated Forbidden errors (unless opts.strict); any other
+// error reading it is returned as a *pvcLookupError.
+func getShareManagerNodeForPVC(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, podNamespace, pvcName string, opts lookupOptions) (shareManagerPlacement, error) {
+	none := shareManagerPlacement{pvc: pvcName}
+
+	if !opts.readableNamespace(podNamespace) {
+		klog.V(5).InfoS("LonghornCoSchedule: namespace not readable, skipping PVC", "pvc", klog.KRef(podNamespace, pvcName))
+		return none, nil
+	}
+	if !opts.forbidden.allow(forbiddenPathPVC, podNamespace) {
+		if opts.strict {
+			return none, &pvcLookupError{namespace: podNamespace, name: pvcName, err: errPathForbidden}
+		}
+		return none, nil
+	}
+
 	// Verify the PVC exists and is RWX.
-	pvc, err := clientset.CoreV1().PersistentVolumeClaims(podNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+	pvc, err := getPVC(ctx, clientset, podNamespace, pvcName, opts)
+	opts.observe(forbiddenPathPVC, podNamespace, err)
+	if apierrors.IsNotFound(err) {
+		return none, nil // PVC not found — skip silently.
+	}
 	if err != nil {
-		return "", nil // PVC not found — skip silently.
+		pvcLookupErrors.WithLabelValues(errorReason(err)).Inc()
+		return none, &pvcLookupError{namespace: podNamespace, name: pvcName, err: err}
+	}
+
+	if pvc.DeletionTimestamp != nil {
+		// The share-manager goes away with the volume; do not pin to it.
+		klog.V(4).InfoS("LonghornCoSchedule: PVC is being deleted, ignoring its share-manager", "pvc", klog.KObj(pvc))
+		return none, nil
 	}
 
-	if !isRWX(pvc) {
-		return "", nil // Not RWX — Longhorn won't create a share-manager.
+	if !IsRWX(pvc) {
+		return none, nil // Not RWX — Longhorn won't create a share-manager.
 	}
 
 	pvName := pvc.Spec.VolumeName
 	if pvName == "" {
-		return "", nil // PVC not yet bound.
+		return none, nil // PVC not yet bound.
 	}
 
-	// --- Primary: query the ShareManager CRD (status.ownerID) ---
-	// The ShareManager CRD is named after the PV (e.g. pvc-<uid>) and lives in
-	// longhorn-system. Longhorn sets status.ownerID as soon as it assigns the
-	// share-manager to a node — well before the pod reaches Running phase.
-	if dynClient != nil {
-		node, err := getShareManagerNodeFromCRD(ctx, dynClient, pvName)
+	// RWX volumes are looked up through the rwxDrivers entry of their
+	// driver, Longhorn's through its share-manager. Those of other drivers
+	// (CephFS, NFS provisioners) without an entry have no server pod; a pod
+	// in longhorn-system that happens to match must not pin the VM.
+	ok, driver := isLonghornVolume(ctx, clientset, pvc, opts)
+	if ok {
+		driver = LonghornDriver
+	}
+	server, found := opts.rwxDriver(driver)
+	if !found {
+		klog.V(5).InfoS("LonghornCoSchedule: RWX PVC not provisioned by Longhorn, skipping",
+			"pvc", klog.KObj(pvc),
+			"pv", pvName,
+			"driver", driver,
+		)
+		return none, nil
+	}
+	pl, err := server.lookup()(ctx, clientset, dynClient, pvc, server, opts)
+	if err == nil && pl.node != "" && server.ScoreOnly {
+		pl.scoreOnly = true
+	}
+	return pl, err
+}
+
+// getShareManagerPlacementForPVC is the lookup of the Longhorn entry. It asks
+// the ShareManager CRD, the share-manager pod, the Longhorn Volume and the
+// share-manager's Service endpoints of a bound RWX PVC as selected and
+// ordered by the lookupOrder arg, then falls back to the PV's
+// VolumeAttachments and to other consumers of the PVC.
+func getShareManagerPlacementForPVC(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pvc *corev1.PersistentVolumeClaim, _ RWXDriver, opts lookupOptions) (shareManagerPlacement, error) {
+	podNamespace, pvcName, pvName := pvc.Namespace, pvc.Name, pvc.Spec.VolumeName
+	none := shareManagerPlacement{pvc: pvcName}
+
+	// Migratable block volumes have no share-manager; prefer the node the
+	// volume is attached to instead.
+	if pv := migratableVolume(ctx, clientset, pvc, opts); pv != nil {
+		node := getLonghornVolumeNode(ctx, dynClient, opts, pv.Spec.CSI.VolumeHandle)
+		klog.V(5).InfoS("LonghornCoSchedule: migratable block volume, preferring its attachment node",
+			"pvc", klog.KObj(pvc),
+			"pv", pvName,
+			"node", node,
+		)
+		result := lookupResultMigratable
+		if node == "" {
+			result = lookupResultNone
+		}
+		lookupResults.WithLabelValues(result).Inc()
+		return shareManagerPlacement{pvc: pvcName, pv: pvName, node: node, migratable: true}, nil
+	}
+
+	// Each source is asked in the configured order until one names a node.
+	var crdErr error
+	for _, source := range opts.lookupOrder() {
+		var pl shareManagerPlacement
+		var err error
+		switch source {
+		case LookupSourceShareManager:
+			pl, err = getShareManagerPlacementFromCRD(ctx, dynClient, pvName, opts)
+			crdErr = pl.crdErr
+		case LookupSourcePod:
+			pl, err = getShareManagerNodeFromPod(ctx, clientset, pvName, opts)
+		case LookupSourceVolume:
+			pl = getVolumePlacement(ctx, dynClient, pvName, opts)
+		case LookupSourceEndpoints:
+			pl = getEndpointsPlacement(ctx, clientset, pvName, opts)
+		}
 		if err != nil {
-			// Log but don't fail — fall through to pod-based lookup.
-			_ = fmt.Errorf("ShareManager CRD lookup failed for %s: %w", pvName, err)
-		} else if node != "" {
-			return node, nil
+			lookupResults.WithLabelValues(lookupResultError).Inc()
+			return none, err
+		}
+		if pl.node != "" {
+			pl.from = source
+			pl, err = crossCheckSources(ctx, clientset, dynClient, pvName, source, pl, opts)
+			if err != nil {
+				lookupResults.WithLabelValues(lookupResultError).Inc()
+				return none, err
+			}
+			lookupResults.WithLabelValues(string(source)).Inc()
+			pl.pvc, pl.pv = pvcName, pvName
+			if source == LookupSourcePod && crdErr != nil {
+				crdPodFallbacks.WithLabelValues(errorClass(crdErr)).Inc()
+				pl.crdErr = crdErr
+			}
+			return pl, nil
 		}
 	}
 
-	// --- Fallback: inspect the share-manager pod directly ---
-	return getShareManagerNodeFromPod(ctx, clientset, pvName)
+	// --- Fallback: the core VolumeAttachments, readable without the
+	// Longhorn CRDs ---
+	if pl := getVolumeAttachmentPlacement(ctx, clientset, pvName, opts); pl.node != "" {
+		lookupResults.WithLabelValues(lookupResultVolumeAttachment).Inc()
+		pl.pvc, pl.pv = pvcName, pvName
+		return pl, nil
+	}
+	if opts.consumers == nil {
+		lookupResults.WithLabelValues(lookupResultNone).Inc()
+		return none, nil
+	}
+
+	// --- Last resort: follow another pod already using the volume ---
+	// The first consumer's share-manager may not be recorded yet.
+	if node, nominated := opts.consumers(podNamespace, pvcName); node != "" {
+		klog.V(4).InfoS("LonghornCoSchedule: no share-manager found, preferring the node of another consumer of the PVC",
+			"pvc", klog.KObj(pvc),
+			"node", node,
+			"nominated", nominated,
+		)
+		lookupResults.WithLabelValues(lookupResultConsumer).Inc()
+		return shareManagerPlacement{pvc: pvcName, pv: pvName, node: node, consumer: true, nominated: nominated}, nil
+	}
+	lookupResults.WithLabelValues(lookupResultNone).Inc()
+	return none, nil
+}
+
+// getShareManagerPlacementFromCRD queries the ShareManager CRD
+// (status.ownerID). The ShareManager is named after the PV (e.g. pvc-<uid>)
+// and lives in the Longhorn namespace. Longhorn sets status.ownerID as soon
+// as it assigns the share-manager to a node — well before the pod reaches
+// Running phase. Failures are counted and logged, but don't fail the lookup;
+// the placement then has an empty node and crdErr set, and the next source is
+// asked. With opts.strict, a failure, or a lookup skipped by the failure
+// threshold or after repeated Forbidden errors, is returned as a
+// *sourceLookupError instead.
+func getShareManagerPlacementFromCRD(ctx context.Context, dynClient dynamic.Interface, pvName string, opts lookupOptions) (shareManagerPlacement, error) {
+	gvr, served := opts.api.resource()
+	if dynClient == nil || !served {
+		return shareManagerPlacement{}, nil
+	}
+	path, namespace := string(LookupSourceShareManager), opts.longhornNamespace()
+	var skipped error
+	switch {
+	case !opts.crd.allow():
+		skipped = errCRDSuspended
+	case !opts.forbidden.allow(path, namespace):
+		skipped = errPathForbidden
+	}
+	if skipped != nil {
+		if opts.strict {
+			return shareManagerPlacement{}, &sourceLookupError{source: LookupSourceShareManager, pv: pvName, err: skipped}
+		}
+		return shareManagerPlacement{}, nil
+	}
+	states := opts.shareManagerStates(ctx, dynClient, pvName)
+	node, running, obj, err := getShareManagerNodeFromCRD(ctx, dynClient, gvr, namespace, pvName, states)
+	opts.api.observe(err)
+	opts.observe(path, namespace, err)
+	var parseErr *shareManagerParseError
+	switch {
+	case errors.As(err, &parseErr):
+		// The API works; the object is malformed. Use the next source.
+		opts.crd.success()
+		return shareManagerPlacement{crdErr: err}, nil
+	case err != nil && !apierrors.IsNotFound(err):
+		opts.crd.failure(pvName, err)
+		if opts.strict {
+			return shareManagerPlacement{}, &sourceLookupError{source: LookupSourceShareManager, pv: pvName, err: err}
+		}
+		return shareManagerPlacement{crdErr: err}, nil
+	case err == nil && node != "":
+		opts.crd.success()
+		return shareManagerPlacement{node: node, notReady: opts.requireReady && !running, updated: lastUpdated(obj)}, nil
+	case err == nil && opts.preferStopped:
+		opts.crd.success()
+		if owner, _, _ := parseShareManagerStatus(namespace, pvName, obj.Object, []string{shareManagerStateStopped}); owner != "" {
+			klog.V(5).InfoS("LonghornCoSchedule: ShareManager stopped, preferring the node that last served it", "pv", pvName, "node", owner)
+			return shareManagerPlacement{node: owner, stopped: true, updated: lastUpdated(obj)}, nil
+		}
+	default:
+		opts.crd.success()
+	}
+	return shareManagerPlacement{}, nil
 }
 
 // getShareManagerNodeFromCRD reads the ShareManager CRD for the given PV name
-// and returns status.ownerID if the share-manager is in a running state.
-func getShareManagerNodeFromCRD(ctx context.Context, dynClient dynamic.Interface, pvName string) (string, error) {
-	obj, err := dynClient.Resource(shareManagerGVR).Namespace(LonghornNamespace).Get(ctx, pvName, metav1.GetOptions{})
+// and returns status.ownerID if the share-manager is in one of the accepted
+// states. running reports that status.state is "running" rather than
+// "starting". The ShareManager read is returned too, unless reading it
+// failed. A ShareManager whose status does not have the expected structure is
+// logged and counted, and reported as a *shareManagerParseError.
+func getShareManagerNodeFromCRD(ctx context.Context, dynClient dynamic.Interface, gvr schema.GroupVersionResource, namespace, pvName string, states []string) (node string, running bool, obj *unstructured.Unstructured, err error) {
+	obj, err = dynClient.Resource(gvr).Namespace(namespace).Get(ctx, pvName, metav1.GetOptions{})
 	if err != nil {
-		return "", err
+		return "", false, nil, err
 	}
 
-	// status.ownerID holds the node name assigned by Longhorn.
-	status, ok := obj.Object["status"].(map[string]interface{})
-	if !ok {
-		return "", nil
+	node, running, err = parseShareManagerStatus(namespace, pvName, obj.Object, states)
+	if err != nil {
+		var parseErr *shareManagerParseError
+		if errors.As(err, &parseErr) {
+			crdParseErrors.WithLabelValues(parseErr.field).Inc()
+		}
+		klog.ErrorS(err, "LonghornCoSchedule: ignoring malformed ShareManager", "namespace", namespace, "pv", pvName, "groupVersion", gvr.GroupVersion().String())
 	}
+	return node, running, obj, err
+}
+
+// shareManagerParseError is returned for a ShareManager whose status field
+// has an unexpected type, e.g. a numeric ownerID or a list-shaped status.
+type shareManagerParseError struct {
+	namespace string
+	name      string
+	field     string
+	err       error
+}
+
+func (e *shareManagerParseError) Error() string {
+	return fmt.Sprintf("parsing status.%s of ShareManager %s/%s: %v", e.field, e.namespace, e.name, e.err)
+}
 
-	ownerID, _ := status["ownerID"].(string)
+func (e *shareManagerParseError) Unwrap() error {
+	return e.err
+}
+
+// parseShareManagerStatus returns status.ownerID of an unstructured
+// ShareManager if its status.state is one of states. A missing or null status
+// or field is not an error: Longhorn has not filled it in yet.
+func parseShareManagerStatus(namespace, name string, obj map[string]interface{}, states []string) (node string, running bool, err error) {
+	// status.ownerID holds the node name assigned by Longhorn.
+	ownerID, err := statusString(obj, "ownerID")
+	if err != nil {
+		return "", false, &shareManagerParseError{namespace: namespace, name: name, field: "ownerID", err: err}
+	}
 	if ownerID == "" {
-		return "", nil
+		return "", false, nil
 	}
 
-	// Only use the ownerID if the share-manager is in a usable state.
-	// Longhorn states: stopped, starting, running, error
-	state, _ := status["state"].(string)
-	switch state {
-	case "running", "starting":
-		return ownerID, nil
-	default:
-		return "", nil
+	// Only use the ownerID if the share-manager is in an accepted state.
+	// Longhorn states: stopping, stopped, starting, running, error
+	state, err := statusString(obj, "state")
+	if err != nil {
+		return "", false, &shareManagerParseError{namespace: namespace, name: name, field: "state", err: err}
+	}
+	if !slices.Contains(states, state) {
+		return "", false, nil
+	}
+	return ownerID, state == "running", nil
+}
+
+// statusString returns the string status field of an unstructured object. A
+// missing or null field is returned as "".
+func statusString(obj map[string]interface{}, field string) (string, error) {
+	return nestedString(obj, "status", field)
+}
+
+// nestedString returns the string field of an unstructured object at the
+// given path. A missing or null field is returned as "".
+func nestedString(obj map[string]interface{}, fields ...string) (string, error) {
+	val, found, err := unstructured.NestedFieldNoCopy(obj, fields...)
+	if err != nil || !found || val == nil {
+		return "", err
 	}
+	s, _, err := unstructured.NestedString(obj, fields...)
+	return s, err
 }
 
 // getShareManagerNodeFromPod looks up the share-manager pod for a PV and
 // returns the node it is running on. Returns empty string if not found or
 // not yet scheduled.
-func getShareManagerNodeFromPod(ctx context.Context, clientset kubernetes.Interface, pvName string) (string, error) {
-	shareManagerName := fmt.Sprintf("%s%s", ShareManagerPrefix, pvName)
-	smPod, err := clientset.CoreV1().Pods(LonghornNamespace).Get(ctx, shareManagerName, metav1.GetOptions{})
+//
+// During failover or a node drain there can briefly be two share-manager pods
+// for the same PV: the old one Terminating and its replacement starting. Pods
+// being deleted are ignored, and of the remaining ones the newest is used.
+//
+// With opts.acceptPending, a Pending pod that is already scheduled to a node
+// is accepted too, and reported as pending. This covers the window during
+// failover where the replacement pod is bound but not yet started.
+//
+// With opts.requireReady, a pod whose Ready condition is not True is reported
+// as notReady.
+//
+// With opts.strict, a failure to list the share-manager pods is returned as a
+// *sourceLookupError.
+func getShareManagerNodeFromPod(ctx context.Context, clientset kubernetes.Interface, pvName string, opts lookupOptions) (shareManagerPlacement, error) {
+	path, namespace := string(LookupSourcePod), opts.longhornNamespace()
+	if !opts.forbidden.allow(path, namespace) {
+		if opts.strict {
+			return shareManagerPlacement{}, &sourceLookupError{source: LookupSourcePod, pv: pvName, err: errPathForbidden}
+		}
+		return shareManagerPlacement{}, nil
+	}
+	smPod, err := newestShareManagerPod(ctx, clientset, pvName, opts)
+	opts.observe(path, namespace, err)
+	if err != nil && opts.strict {
+		return shareManagerPlacement{}, &sourceLookupError{source: LookupSourcePod, pv: pvName, err: err}
+	}
+	if err != nil || smPod == nil {
+		return shareManagerPlacement{}, nil // Pod doesn't exist yet — that's fine.
+	}
+	return podPlacement(smPod, opts), nil
+}
+
+// podPlacement returns the placement of a share-manager or server pod: its
+// node if it is Running, or Pending and scheduled with opts.acceptPending,
+// and an empty placement otherwise.
+func podPlacement(pod *corev1.Pod, opts lookupOptions) shareManagerPlacement {
+	if pod.Spec.NodeName == "" {
+		return shareManagerPlacement{}
+	}
+
+	pl := shareManagerPlacement{node: pod.Spec.NodeName, updated: pod.CreationTimestamp.Time}
+	switch {
+	case pod.Status.Phase == corev1.PodRunning:
+	case opts.acceptPending && pod.Status.Phase == corev1.PodPending:
+		pl.pending = true
+	default:
+		return shareManagerPlacement{}
+	}
+	pl.notReady = opts.requireReady && !isPodReady(pod)
+	return pl
+}
+
+// newestShareManagerPod returns the most recently created share-manager pod
+// for a PV that is not being deleted, or nil if there is none. Of pods
+// created at the same time, the first by name wins. The pods are read from
+// opts.podLister when it is set.
+//
+// Candidates are the pods labelled with ShareManagerLabel for the PV, plus
+// the pod named share-manager-<pvName>. Longhorn shortens both the pod name
+// and the label value when the PV name is too long for them (63 characters
+// for a label value), so for such PVs every share-manager pod is listed and
+// matched by its owner reference to the ShareManager instead.
+func newestShareManagerPod(ctx context.Context, clientset kubernetes.Interface, pvName string, opts lookupOptions) (*corev1.Pod, error) {
+	namespace := opts.longhornNamespace()
+	selector := labels.Set{ShareManagerLabel: pvName}
+	if len(validation.IsValidLabelValue(pvName)) > 0 {
+		selector = labels.Set{ShareManagerComponentLabel: ShareManagerComponentValue}
+	}
+	pods, err := listServerPods(ctx, clientset, namespace, selector.AsSelector(), opts)
 	if err != nil {
-		return "", nil // Pod doesn't exist yet — that's fine.
+		return nil, err
+	}
+
+	var candidates []*corev1.Pod
+	for _, pod := range pods {
+		if isShareManagerPodFor(pod, pvName) {
+			candidates = append(candidates, pod)
+		}
+	}
+	if byName, err := getServerPod(ctx, clientset, namespace, ShareManagerPrefix+pvName, opts); err == nil {
+		if !isShareManagerPodFor(byName, pvName) {
+			candidates = append(candidates, byName)
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	var newest *corev1.Pod
+	for _, pod := range candidates {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if newest == nil || newest.CreationTimestamp.Before(&pod.CreationTimestamp) ||
+			(newest.CreationTimestamp.Equal(&pod.CreationTimestamp) && pod.Name < newest.Name) {
+			newest = pod
+		}
 	}
+	return newest, nil
+}
 
-	if smPod.Status.Phase == corev1.PodRunning && smPod.Spec.NodeName != "" {
-		return smPod.Spec.NodeName, nil
+// isShareManagerPodFor returns true if the pod is labelled as, or owned by,
+// the ShareManager of the given PV.
+func isShareManagerPodFor(pod *corev1.Pod, pvName string) bool {
+	if pod.Labels[ShareManagerLabel] == pvName {
+		return true
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "ShareManager" && ref.Name == pvName {
+			return true
+		}
 	}
+	return false
+}
 
-	return "", nil
+// isPodReady returns true if the pod's Ready condition is True.
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
 }
 
-// isRWX returns true if the PVC has ReadWriteMany access mode.
-func isRWX(pvc *corev1.PersistentVolumeClaim) bool {
+// IsRWX returns true if the PVC has ReadWriteMany access mode.
+func IsRWX(pvc *corev1.PersistentVolumeClaim) bool {
 	for _, mode := range pvc.Spec.AccessModes {
 		if mode == corev1.ReadWriteMany {
 			return true
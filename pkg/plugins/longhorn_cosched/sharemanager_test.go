@@ -0,0 +1,727 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+func TestPendingShareManager(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+	)
+
+	pendingWithNode := makeShareManagerPod(pvName, targetNode)
+	pendingWithNode.Status.Phase = corev1.PodPending
+	pendingWithoutNode := makeShareManagerPod(pvName, "")
+	pendingWithoutNode.Status.Phase = corev1.PodPending
+
+	tests := []struct {
+		name          string
+		smPod         *corev1.Pod
+		acceptPending bool
+		wantNode      string
+		wantPending   bool
+		wantScore     int64
+	}{
+		{
+			name:          "Running — accepted at full score",
+			smPod:         makeShareManagerPod(pvName, targetNode),
+			acceptPending: true,
+			wantNode:      targetNode,
+			wantScore:     framework.MaxNodeScore,
+		},
+		{
+			name:          "Pending with node — accepted at a lower score",
+			smPod:         pendingWithNode,
+			acceptPending: true,
+			wantNode:      targetNode,
+			wantPending:   true,
+			wantScore:     pendingShareManagerScore,
+		},
+		{
+			name:      "Pending with node, flag off — ignored",
+			smPod:     pendingWithNode,
+			wantScore: 0,
+		},
+		{
+			name:          "Pending without node — ignored",
+			smPod:         pendingWithoutNode,
+			acceptPending: true,
+			wantScore:     0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{makePVC(pvcName, vmNamespace, pvName), tt.smPod}
+			clientset := fake.NewSimpleClientset(objects...)
+
+			pl, err := getShareManagerNodeFromPod(context.Background(), clientset, pvName, lookupOptions{acceptPending: tt.acceptPending})
+			if err != nil {
+				t.Fatalf("getShareManagerNodeFromPod() error = %v", err)
+			}
+			if pl.node != tt.wantNode || pl.pending != tt.wantPending {
+				t.Errorf("getShareManagerNodeFromPod() = (%q, %v), want (%q, %v)", pl.node, pl.pending, tt.wantNode, tt.wantPending)
+			}
+
+			plugin := &Plugin{clientset: clientset, args: Args{AcceptPendingShareManager: tt.acceptPending}}
+			score, status := plugin.Score(context.Background(), nil, makeVM("vm", vmNamespace, true, pvcName), targetNode)
+			if !status.IsSuccess() {
+				t.Fatalf("Score() status = %v", status)
+			}
+			if score != tt.wantScore {
+				t.Errorf("Score() = %d, want %d", score, tt.wantScore)
+			}
+		})
+	}
+}
+
+// withReady sets the Ready condition of a share-manager pod.
+func withReady(pod *corev1.Pod, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	return pod
+}
+
+func TestRequireShareManagerReady(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		targetNode  = "node-2"
+		otherNode   = "node-1"
+	)
+
+	tests := []struct {
+		name         string
+		objects      []runtime.Object
+		dynObjects   []runtime.Object
+		requireReady bool
+		wantPinned   bool
+		wantEvent    bool
+	}{
+		{
+			name:       "Running and Ready, flag off — pinned",
+			objects:    []runtime.Object{withReady(makeShareManagerPod(pvName, targetNode), true)},
+			wantPinned: true,
+		},
+		{
+			name:       "Running but not Ready, flag off — pinned",
+			objects:    []runtime.Object{withReady(makeShareManagerPod(pvName, targetNode), false)},
+			wantPinned: true,
+		},
+		{
+			name:         "Running and Ready, flag on — pinned",
+			objects:      []runtime.Object{withReady(makeShareManagerPod(pvName, targetNode), true)},
+			requireReady: true,
+			wantPinned:   true,
+		},
+		{
+			name:         "Running but not Ready, flag on — not pinned",
+			objects:      []runtime.Object{withReady(makeShareManagerPod(pvName, targetNode), false)},
+			requireReady: true,
+			wantEvent:    true,
+		},
+		{
+			name:         "ShareManager running, flag on — pinned",
+			dynObjects:   []runtime.Object{makeShareManagerCR(pvName, targetNode, "running")},
+			requireReady: true,
+			wantPinned:   true,
+		},
+		{
+			name:         "ShareManager starting, flag on — not pinned",
+			dynObjects:   []runtime.Object{makeShareManagerCR(pvName, targetNode, "starting")},
+			requireReady: true,
+			wantEvent:    true,
+		},
+		{
+			name:       "ShareManager starting, flag off — pinned",
+			dynObjects: []runtime.Object{makeShareManagerCR(pvName, targetNode, "starting")},
+			wantPinned: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, true, pvcName)
+			recorder := events.NewFakeRecorder(10)
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:      []*corev1.Node{makeNode(otherNode, "4"), makeNode(targetNode, "4")},
+				objects:    append([]runtime.Object{pod, makePVC(pvcName, vmNamespace, pvName)}, tt.objects...),
+				dynObjects: tt.dynObjects,
+				args:       Args{RequireShareManagerReady: tt.requireReady},
+				recorder:   recorder,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			var rejected bool
+			m.ForEachExplicitNode(func(node string, _ *framework.Status) {
+				rejected = rejected || node == otherNode
+			})
+			if rejected != tt.wantPinned {
+				t.Errorf("%s rejected = %v, want %v", otherNode, rejected, tt.wantPinned)
+			}
+
+			// A share-manager that is not Ready still scores its node.
+			score, status := plugin.Score(context.Background(), state, pod, targetNode)
+			if !status.IsSuccess() {
+				t.Fatalf("Score() status = %v", status)
+			}
+			if score == 0 {
+				t.Errorf("Score(%s) = 0, want > 0", targetNode)
+			}
+
+			var gotEvent bool
+			for len(recorder.Events) > 0 {
+				if strings.Contains(<-recorder.Events, notReadyReason) {
+					gotEvent = true
+				}
+			}
+			if gotEvent != tt.wantEvent {
+				t.Errorf("%s event emitted = %v, want %v", notReadyReason, gotEvent, tt.wantEvent)
+			}
+		})
+	}
+}
+
+// TestShareManagerFailover checks the lookup while an old share-manager pod is
+// Terminating and its replacement is starting on another node.
+func TestShareManagerFailover(t *testing.T) {
+	const (
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		oldNode = "node-1"
+		newNode = "node-2"
+	)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// shareManagerPod returns a share-manager pod for pvName created age
+	// before the old pod was deleted. Replacements carry the same label.
+	shareManagerPod := func(name, node string, age time.Duration, phase corev1.PodPhase, terminating bool) *corev1.Pod {
+		pod := makeShareManagerPod(pvName, node)
+		pod.Name = name
+		pod.CreationTimestamp = metav1.NewTime(created.Add(-age))
+		pod.Status.Phase = phase
+		if terminating {
+			pod.DeletionTimestamp = &metav1.Time{Time: created}
+			pod.Finalizers = []string{"longhorn.io"}
+		}
+		return pod
+	}
+	oldTerminating := shareManagerPod(ShareManagerPrefix+pvName, oldNode, time.Hour, corev1.PodRunning, true)
+	oldRunning := shareManagerPod(ShareManagerPrefix+pvName, oldNode, time.Hour, corev1.PodRunning, false)
+	newPending := shareManagerPod(ShareManagerPrefix+pvName+"-new", newNode, time.Minute, corev1.PodPending, false)
+	newRunning := shareManagerPod(ShareManagerPrefix+pvName+"-new", newNode, time.Minute, corev1.PodRunning, false)
+	sameAgeRunning := shareManagerPod(ShareManagerPrefix+pvName+"-a", newNode, time.Hour, corev1.PodRunning, false)
+
+	tests := []struct {
+		name          string
+		pods          []runtime.Object
+		acceptPending bool
+		wantNode      string
+		wantPending   bool
+	}{
+		{
+			name:          "old Terminating, new Pending — new node wins",
+			pods:          []runtime.Object{oldTerminating, newPending},
+			acceptPending: true,
+			wantNode:      newNode,
+			wantPending:   true,
+		},
+		{
+			name: "old Terminating, new Pending, Pending not accepted — no node",
+			pods: []runtime.Object{oldTerminating, newPending},
+		},
+		{
+			name:     "old Terminating, new Running — new node wins",
+			pods:     []runtime.Object{oldTerminating, newRunning},
+			wantNode: newNode,
+		},
+		{
+			name:     "both Running — newest wins",
+			pods:     []runtime.Object{newRunning, oldRunning},
+			wantNode: newNode,
+		},
+		{
+			name:     "both Running, created at the same time — first by name wins",
+			pods:     []runtime.Object{oldRunning, sameAgeRunning},
+			wantNode: oldNode,
+		},
+		{
+			name: "only a Terminating pod — no node",
+			pods: []runtime.Object{oldTerminating},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, cached := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s (cached=%v)", tt.name, cached), func(t *testing.T) {
+				clientset := fake.NewSimpleClientset(tt.pods...)
+				opts := lookupOptions{acceptPending: tt.acceptPending}
+				if cached {
+					// The lister answers on its own: the clientset is empty.
+					indexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+					for _, pod := range tt.pods {
+						if err := indexer.Add(pod); err != nil {
+							t.Fatal(err)
+						}
+					}
+					clientset = fake.NewSimpleClientset()
+					opts.podLister = corelisters.NewPodLister(indexer)
+				}
+				pl, err := getShareManagerNodeFromPod(context.Background(), clientset, pvName, opts)
+				if err != nil {
+					t.Fatalf("getShareManagerNodeFromPod() error = %v", err)
+				}
+				if pl.node != tt.wantNode || pl.pending != tt.wantPending {
+					t.Errorf("getShareManagerNodeFromPod() = (%q, %v), want (%q, %v)", pl.node, pl.pending, tt.wantNode, tt.wantPending)
+				}
+			})
+		}
+	}
+}
+
+func TestPVCLookupErrors(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	pvcResource := schema.GroupResource{Resource: "persistentvolumeclaims"}
+	forbidden := apierrors.NewForbidden(pvcResource, pvcName, errors.New("RBAC: access denied"))
+	timeout := apierrors.NewTimeoutError("request timed out", 1)
+
+	soft := makeVM("vm", vmNamespace, true, pvcName)
+	soft.Annotations[AnnotationKey] = string(ModeSoft)
+
+	// Metrics only count once registered, which New does in the scheduler.
+	registerMetrics()
+
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		getErr     error
+		policy     PVCLookupErrorPolicy
+		wantError  bool
+		wantReason string
+	}{
+		{name: "NotFound — skipped", pod: makeVM("vm", vmNamespace, true, pvcName), getErr: apierrors.NewNotFound(pvcResource, pvcName)},
+		{name: "Forbidden, hard — error", pod: makeVM("vm", vmNamespace, true, pvcName), getErr: forbidden, wantError: true, wantReason: "Forbidden"},
+		{name: "Timeout, hard — error", pod: makeVM("vm", vmNamespace, true, pvcName), getErr: timeout, wantError: true, wantReason: "Timeout"},
+		{name: "Forbidden, hard, allowAll — schedules freely", pod: makeVM("vm", vmNamespace, true, pvcName), getErr: forbidden, policy: PVCLookupErrorPolicyAllowAll, wantReason: "Forbidden"},
+		{name: "Timeout, soft — schedules freely", pod: soft, getErr: timeout, wantReason: "Timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makeShareManagerPod(pvName, "node-2"))
+			clientset.PrependReactor("get", "persistentvolumeclaims", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, tt.getErr
+			})
+			plugin := &Plugin{clientset: clientset, args: Args{PVCLookupErrorPolicy: tt.policy}}
+
+			var before float64
+			if tt.wantReason != "" {
+				before, _ = testutil.GetCounterMetricValue(pvcLookupErrors.WithLabelValues(tt.wantReason))
+			}
+
+			state := framework.NewCycleState()
+			_, status := plugin.PreFilter(context.Background(), state, tt.pod)
+			if got := status.Code() == framework.Error; got != tt.wantError {
+				t.Fatalf("PreFilter() error = %v, want %v (status: %v)", got, tt.wantError, status)
+			}
+			if tt.wantError {
+				if !strings.Contains(status.Message(), pvcName) {
+					t.Errorf("PreFilter() message %q does not name the PVC", status.Message())
+				}
+			} else {
+				for _, node := range []string{"node-1", "node-2"} {
+					if status := plugin.Filter(context.Background(), state, tt.pod, makeNodeInfo(node)); !status.IsSuccess() {
+						t.Errorf("Filter(%s) = %v, want success", node, status)
+					}
+				}
+			}
+
+			if tt.wantReason != "" {
+				after, _ := testutil.GetCounterMetricValue(pvcLookupErrors.WithLabelValues(tt.wantReason))
+				if after-before != 1 {
+					t.Errorf("%s PVC lookup errors counted = %v, want 1", tt.wantReason, after-before)
+				}
+			}
+		})
+	}
+}
+
+// TestStrictErrors checks that with strictErrors a hard-mode pod whose PVC,
+// ShareManager or share-manager pods cannot be read fails PreFilter, with an
+// event and a metric naming the failed source, while soft-mode pods and the
+// default still schedule.
+func TestStrictErrors(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "persistentvolumeclaims"}, pvcName, errors.New("RBAC: access denied"))
+	timeout := apierrors.NewTimeoutError("request timed out", 1)
+
+	registerMetrics()
+
+	tests := []struct {
+		name       string
+		soft       bool
+		strict     bool
+		policy     PVCLookupErrorPolicy
+		verb       string
+		resource   string
+		err        error
+		wantError  bool
+		wantSource string
+		wantReason string
+	}{
+		{name: "PVC Forbidden, hard, strict", strict: true, verb: "get", resource: "persistentvolumeclaims", err: forbidden, wantError: true, wantSource: "pvc", wantReason: "Forbidden"},
+		{name: "PVC Timeout, hard, strict overrides allowAll", strict: true, policy: PVCLookupErrorPolicyAllowAll, verb: "get", resource: "persistentvolumeclaims", err: timeout, wantError: true, wantSource: "pvc", wantReason: "Timeout"},
+		{name: "PVC Timeout, hard, allowAll", policy: PVCLookupErrorPolicyAllowAll, verb: "get", resource: "persistentvolumeclaims", err: timeout},
+		{name: "PVC Forbidden, soft, strict", soft: true, strict: true, verb: "get", resource: "persistentvolumeclaims", err: forbidden},
+		{name: "CRD Forbidden, hard, strict", strict: true, verb: "get", resource: "sharemanagers", err: forbidden, wantError: true, wantSource: "shareManager", wantReason: "Forbidden"},
+		{name: "CRD Timeout, hard, strict", strict: true, verb: "get", resource: "sharemanagers", err: timeout, wantError: true, wantSource: "shareManager", wantReason: "Timeout"},
+		{name: "CRD Timeout, hard", verb: "get", resource: "sharemanagers", err: timeout},
+		{name: "CRD Forbidden, soft, strict", soft: true, strict: true, verb: "get", resource: "sharemanagers", err: forbidden},
+		{name: "pods Forbidden, hard, strict", strict: true, verb: "list", resource: "pods", err: forbidden, wantError: true, wantSource: "pod", wantReason: "Forbidden"},
+		{name: "pods Timeout, soft, strict", soft: true, strict: true, verb: "list", resource: "pods", err: timeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, true, pvcName)
+			if tt.soft {
+				pod.Annotations[AnnotationKey] = string(ModeSoft)
+			}
+			recorder := events.NewFakeRecorder(10)
+			_, plugin, clientset := newTestFramework(t, testCluster{
+				nodes:    []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects:  []runtime.Object{makePVC(pvcName, vmNamespace, pvName)},
+				args:     Args{StrictErrors: tt.strict, PVCLookupErrorPolicy: tt.policy},
+				recorder: recorder,
+			})
+			inject := func(k8stesting.Action) (bool, runtime.Object, error) { return true, nil, tt.err }
+			if tt.resource == "sharemanagers" {
+				plugin.dynClient.(*dynamicfake.FakeDynamicClient).PrependReactor(tt.verb, tt.resource, inject)
+			} else {
+				clientset.PrependReactor(tt.verb, tt.resource, inject)
+			}
+			// Read the PVCs and share-manager pods through the clientset,
+			// as without an informer cache.
+			plugin.pvcLister = nil
+			plugin.podLister = nil
+
+			var before float64
+			if tt.wantError {
+				before, _ = testutil.GetCounterMetricValue(strictLookupErrors.WithLabelValues(tt.wantSource, tt.wantReason))
+			}
+
+			_, status := plugin.PreFilter(context.Background(), framework.NewCycleState(), pod)
+			if got := status.Code() == framework.Error; got != tt.wantError {
+				t.Fatalf("PreFilter() error = %v, want %v (status: %v)", got, tt.wantError, status)
+			}
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				got = append(got, <-recorder.Events)
+			}
+			if hasEvent(got, lookupFailedReason) != tt.wantError {
+				t.Errorf("%s event emitted = %v, want %v (events: %v)", lookupFailedReason, !tt.wantError, tt.wantError, got)
+			}
+			if tt.wantError {
+				after, _ := testutil.GetCounterMetricValue(strictLookupErrors.WithLabelValues(tt.wantSource, tt.wantReason))
+				if after-before != 1 {
+					t.Errorf("strict lookup errors counted = %v, want 1", after-before)
+				}
+			}
+		})
+	}
+}
+
+// TestPreferStoppedShareManagerNode checks that a stopped ShareManager's last
+// ownerID only earns its node a score with PreferStoppedShareManagerNode,
+// and never filters the other nodes, even in hard mode.
+func TestPreferStoppedShareManagerNode(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		lastNode    = "node-2"
+	)
+
+	tests := []struct {
+		name      string
+		prefer    bool
+		state     string
+		wantScore int64
+	}{
+		{name: "stopped, preferred", prefer: true, state: "stopped", wantScore: framework.MaxNodeScore},
+		{name: "stopped, not preferred", state: "stopped"},
+		{name: "error state, preferred", prefer: true, state: "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", vmNamespace, true, pvcName)
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes:      []*corev1.Node{makeNode("node-1", "4"), makeNode(lastNode, "4")},
+				objects:    []runtime.Object{makePVC(pvcName, vmNamespace, pvName)},
+				dynObjects: []runtime.Object{makeShareManagerCR(pvName, lastNode, tt.state)},
+				args:       Args{PreferStoppedShareManagerNode: tt.prefer},
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != 0 {
+				t.Errorf("%d nodes rejected, want 0", m.Len())
+			}
+			for node, want := range map[string]int64{"node-1": 0, lastNode: tt.wantScore} {
+				score, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+				if score != want {
+					t.Errorf("Score(%s) = %d, want %d", node, score, want)
+				}
+			}
+		})
+	}
+}
+
+// TestLongPVName checks that a share-manager pod whose name and label Longhorn
+// shortened, because the PV name is too long for them, is still found through
+// its owner reference.
+func TestLongPVName(t *testing.T) {
+	const vmNamespace = "default"
+	pvName := "pvc-static-" + strings.Repeat("x", 70)
+
+	shortened := makeShareManagerPod(pvName[:40], "node-2")
+	shortened.Name = ShareManagerPrefix + pvName[:40] + "-7f3a9"
+	shortened.Labels[ShareManagerLabel] = pvName[:40] + "-7f3a9"
+	owned := shortened.DeepCopy()
+	owned.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: shareManagerGVR.GroupVersion().String(),
+		Kind:       "ShareManager",
+		Name:       pvName,
+		UID:        "uid-sm",
+	}}
+
+	tests := []struct {
+		name     string
+		smPod    *corev1.Pod
+		wantNode string
+	}{
+		{name: "owned by the ShareManager — found", smPod: owned, wantNode: "node-2"},
+		{name: "not owned — not found", smPod: shortened},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makePVC("my-rwx-pvc", vmNamespace, pvName), tt.smPod)
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, nil, vmNamespace, "my-rwx-pvc", lookupOptions{})
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode {
+				t.Errorf("getShareManagerNodeForPVC() node = %q, want %q", pl.node, tt.wantNode)
+			}
+		})
+	}
+}
+
+func TestParseShareManagerStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		obj         map[string]interface{}
+		wantNode    string
+		wantRunning bool
+		wantField   string
+	}{
+		{name: "empty object", obj: map[string]interface{}{}},
+		{name: "nil status", obj: map[string]interface{}{"status": nil}},
+		{name: "status not filled in", obj: map[string]interface{}{"status": map[string]interface{}{}}},
+		{name: "list-shaped status", obj: map[string]interface{}{"status": []interface{}{"node-1"}}, wantField: "ownerID"},
+		{name: "string status", obj: map[string]interface{}{"status": "running"}, wantField: "ownerID"},
+		{name: "numeric ownerID", obj: map[string]interface{}{"status": map[string]interface{}{"ownerID": int64(1), "state": "running"}}, wantField: "ownerID"},
+		{name: "float ownerID", obj: map[string]interface{}{"status": map[string]interface{}{"ownerID": 1.5, "state": "running"}}, wantField: "ownerID"},
+		{name: "null ownerID", obj: map[string]interface{}{"status": map[string]interface{}{"ownerID": nil, "state": "running"}}},
+		{name: "numeric state", obj: map[string]interface{}{"status": map[string]interface{}{"ownerID": "node-1", "state": int64(3)}}, wantField: "state"},
+		{name: "missing state", obj: map[string]interface{}{"status": map[string]interface{}{"ownerID": "node-1"}}},
+		{name: "stopped", obj: map[string]interface{}{"status": map[string]interface{}{"ownerID": "node-1", "state": "stopped"}}},
+		{name: "starting", obj: map[string]interface{}{"status": map[string]interface{}{"ownerID": "node-1", "state": "starting"}}, wantNode: "node-1"},
+		{name: "running", obj: map[string]interface{}{"status": map[string]interface{}{"ownerID": "node-1", "state": "running"}}, wantNode: "node-1", wantRunning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, running, err := parseShareManagerStatus(LonghornNamespace, "pv", tt.obj, DefaultShareManagerStates)
+			if node != tt.wantNode || running != tt.wantRunning {
+				t.Errorf("parseShareManagerStatus() = %q, %v, want %q, %v", node, running, tt.wantNode, tt.wantRunning)
+			}
+			var parseErr *shareManagerParseError
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("parseShareManagerStatus() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.As(err, &parseErr) || parseErr.field != tt.wantField {
+				t.Errorf("parseShareManagerStatus() error = %v, want a parse error on status.%s", err, tt.wantField)
+			}
+			if want := "ShareManager " + LonghornNamespace + "/pv"; !strings.Contains(err.Error(), want) {
+				t.Errorf("parseShareManagerStatus() error = %v, want it to name %s", err, want)
+			}
+		})
+	}
+}
+
+// TestMalformedShareManagerFallsBackToPod checks that a ShareManager whose
+// status cannot be parsed is counted and the share-manager pod is used.
+func TestMalformedShareManagerFallsBackToPod(t *testing.T) {
+	registerMetrics()
+	cr := makeShareManagerCR(crdTestPV, "", "")
+	cr.Object["status"] = []interface{}{"node-1"}
+	dynClient := newDynamicClient(cr)
+	clientset := fake.NewSimpleClientset(makePVC(crdTestPVC, "default", crdTestPV), makeShareManagerPod(crdTestPV, "node-2"))
+
+	counter := crdParseErrors.WithLabelValues("ownerID")
+	before, err := testutil.GetCounterMetricValue(counter)
+	if err != nil {
+		t.Fatalf("reading metric: %v", err)
+	}
+
+	pl, err := getShareManagerNodeForPVC(context.Background(), clientset, dynClient, "default", crdTestPVC, lookupOptions{})
+	if err != nil {
+		t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+	}
+	if pl.node != "node-2" {
+		t.Errorf("getShareManagerNodeForPVC() node = %q, want the share-manager pod's node-2", pl.node)
+	}
+	after, err := testutil.GetCounterMetricValue(counter)
+	if err != nil {
+		t.Fatalf("reading metric: %v", err)
+	}
+	if after-before != 1 {
+		t.Errorf("crd_parse_errors_total{field=ownerID} increased by %v, want 1", after-before)
+	}
+}
+
+// FuzzParseShareManagerStatus feeds arbitrary JSON as the ShareManager status
+// and checks that parsing never panics, is deterministic, and only returns a
+// node that is the status.ownerID string.
+func FuzzParseShareManagerStatus(f *testing.F) {
+	for _, seed := range []string{
+		`null`,
+		`[]`,
+		`"running"`,
+		`{"ownerID":1,"state":"running"}`,
+		`{"ownerID":"node-1","state":["running"]}`,
+		`{"ownerID":"node-1","state":"starting"}`,
+		`{"ownerID":"node-1","state":"running"}`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var status interface{}
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Skip()
+		}
+		obj := map[string]interface{}{"status": status}
+
+		node, running, err := parseShareManagerStatus(LonghornNamespace, "pv", obj, DefaultShareManagerStates)
+		node2, running2, err2 := parseShareManagerStatus(LonghornNamespace, "pv", obj, DefaultShareManagerStates)
+		if node != node2 || running != running2 || (err == nil) != (err2 == nil) {
+			t.Fatalf("parseShareManagerStatus() not deterministic: %q %v %v, then %q %v %v", node, running, err, node2, running2, err2)
+		}
+		if err != nil && node != "" {
+			t.Fatalf("parseShareManagerStatus() = %q with error %v, want no node", node, err)
+		}
+		if node != "" {
+			m, _ := status.(map[string]interface{})
+			if ownerID, _ := m["ownerID"].(string); ownerID != node {
+				t.Fatalf("parseShareManagerStatus() node = %q, want status.ownerID %q", node, ownerID)
+			}
+		}
+		if running && node == "" {
+			t.Fatal("parseShareManagerStatus() running without a node")
+		}
+	})
+}
+
+// TestShareManagerNaming guards the convention that a share-manager belongs to
+// the PV, not the PVC: Longhorn names it share-manager-<pv> and labels it
+// longhorn.io/share-manager=<pv>. Both the label and the name find it, for a
+// dynamically provisioned PV (pvc-<uid>) as well as a statically named one.
+func TestShareManagerNaming(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		pvcName     = "my-rwx-pvc"
+		dynamicPV   = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		staticPV    = "nfs-data"
+		targetNode  = "node-2"
+	)
+
+	labelOnly := func(pvName string) *corev1.Pod {
+		p := makeShareManagerPod(pvName, targetNode)
+		p.Name = "sm-7f3a9"
+		return p
+	}
+	nameOnly := func(pvName string) *corev1.Pod {
+		p := makeShareManagerPod(pvName, targetNode)
+		p.Labels = nil
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		pvName   string
+		smPod    *corev1.Pod
+		wantNode string
+	}{
+		{name: "dynamic PV — label and name", pvName: dynamicPV, smPod: makeShareManagerPod(dynamicPV, targetNode), wantNode: targetNode},
+		{name: "dynamic PV — label only", pvName: dynamicPV, smPod: labelOnly(dynamicPV), wantNode: targetNode},
+		{name: "dynamic PV — name only", pvName: dynamicPV, smPod: nameOnly(dynamicPV), wantNode: targetNode},
+		{name: "dynamic PV — share-manager named after the PVC is not its own", pvName: dynamicPV, smPod: makeShareManagerPod(pvcName, targetNode)},
+		{name: "static PV — label and name", pvName: staticPV, smPod: makeShareManagerPod(staticPV, targetNode), wantNode: targetNode},
+		{name: "static PV — label only", pvName: staticPV, smPod: labelOnly(staticPV), wantNode: targetNode},
+		{name: "static PV — name only", pvName: staticPV, smPod: nameOnly(staticPV), wantNode: targetNode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(makePVC(pvcName, vmNamespace, tt.pvName), tt.smPod)
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, nil, vmNamespace, pvcName, lookupOptions{})
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode {
+				t.Errorf("getShareManagerNodeForPVC() node = %q, want %q", pl.node, tt.wantNode)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package longhorn_cosched
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// singleNode returns true if the scheduler's snapshot holds at most one node,
+// so co-scheduling cannot change where the pod lands. Cordoned nodes are
+// counted too: with CordonedNodePolicyPin, a cordoned share-manager node
+// keeps the pod off the others.
+func (p *Plugin) singleNode(pod *corev1.Pod) bool {
+	if p.handle == nil {
+		return false
+	}
+	nodeInfos, err := p.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil || len(nodeInfos) > 1 {
+		return false
+	}
+	klog.V(4).InfoS("LonghornCoSchedule/PreFilter: single node, skipping share-manager lookups", "pod", klog.KObj(pod))
+	return true
+}
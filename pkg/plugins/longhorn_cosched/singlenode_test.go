@@ -0,0 +1,56 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// TestSingleNodeSkipsLookups checks that on a single-node cluster PreFilter
+// skips an opted-in pod without reading anything from the API, and that a
+// second node brings the lookups back.
+func TestSingleNodeSkipsLookups(t *testing.T) {
+	const pvName = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+
+	tests := []struct {
+		name         string
+		nodes        []*corev1.Node
+		wantRejected int
+		wantCalls    bool
+	}{
+		{name: "single node", nodes: []*corev1.Node{makeNode("node-1", "4")}},
+		{name: "two nodes", nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")}, wantRejected: 1, wantCalls: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", true, "shared")
+			fwk, plugin, clientset := newTestFramework(t, testCluster{
+				nodes:      tt.nodes,
+				objects:    []runtime.Object{makePVC("shared", "default", pvName)},
+				dynObjects: []runtime.Object{makeShareManagerCR(pvName, "node-2", "running")},
+			})
+			dynClient := plugin.dynClient.(*dynamicfake.FakeDynamicClient)
+			clientset.ClearActions()
+			dynClient.ClearActions()
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != tt.wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.nodes {
+				if _, status := plugin.Score(context.Background(), state, pod, node.Name); !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node.Name, status)
+				}
+			}
+
+			calls := len(clientset.Actions()) + len(dynClient.Actions())
+			if gotCalls := calls > 0; gotCalls != tt.wantCalls {
+				t.Errorf("%d API calls, want calls: %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/defaultbinder"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/queuesort"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	schedmetrics "k8s.io/kubernetes/pkg/scheduler/metrics"
+)
+
+// NewFramework builds a scheduling framework running the plugin, with the
+// given args, at PreFilter, Filter and Score, for running it outside the
+// kube-scheduler: in the extender and in simulations. PrioritySort and
+// DefaultBinder are only there because every profile needs a queue sort and
+// a bind plugin; neither runs. No pod is ever nominated, as such callers do
+// not see the scheduler's queue. The framework reads the cluster from
+// snapshot; factory must be started once it is built.
+func NewFramework(ctx context.Context, schedulerName string, clientset kubernetes.Interface, dynClient dynamic.Interface, factory informers.SharedInformerFactory, snapshot framework.SharedLister, recorder events.EventRecorder, args runtime.Object) (framework.Framework, error) {
+	// Plugins report their execution durations, which must be registered
+	// before use.
+	schedmetrics.Register()
+
+	registry := frameworkruntime.Registry{
+		queuesort.Name:     queuesort.New,
+		defaultbinder.Name: defaultbinder.New,
+		Name:               NewWithClients(clientset, dynClient),
+	}
+	enabled := config.PluginSet{Enabled: []config.Plugin{{Name: Name}}}
+	profile := &config.KubeSchedulerProfile{
+		SchedulerName: schedulerName,
+		Plugins: &config.Plugins{
+			QueueSort: config.PluginSet{Enabled: []config.Plugin{{Name: queuesort.Name}}},
+			PreFilter: enabled,
+			Filter:    enabled,
+			Score:     config.PluginSet{Enabled: []config.Plugin{{Name: Name, Weight: 1}}},
+			Bind:      config.PluginSet{Enabled: []config.Plugin{{Name: defaultbinder.Name}}},
+		},
+		PluginConfig: []config.PluginConfig{{Name: Name, Args: args}},
+	}
+	fwk, err := frameworkruntime.NewFramework(ctx, registry, profile,
+		frameworkruntime.WithClientSet(clientset),
+		frameworkruntime.WithInformerFactory(factory),
+		frameworkruntime.WithSnapshotSharedLister(snapshot),
+		frameworkruntime.WithPodNominator(nopNominator{}),
+		frameworkruntime.WithEventRecorder(recorder),
+		frameworkruntime.WithWaitingPods(frameworkruntime.NewWaitingPodsMap()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating framework: %w", err)
+	}
+	return fwk, nil
+}
+
+// nopNominator is the pod nominator of a framework built by NewFramework.
+type nopNominator struct{}
+
+func (nopNominator) AddNominatedPod(klog.Logger, *framework.PodInfo, *framework.NominatingInfo) {}
+func (nopNominator) DeleteNominatedPodIfExists(*corev1.Pod)                                     {}
+func (nopNominator) UpdateNominatedPod(klog.Logger, *corev1.Pod, *framework.PodInfo)            {}
+func (nopNominator) NominatedPodsForNode(string) []*framework.PodInfo                           { return nil }
@@ -0,0 +1,229 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+const (
+	// dataLocalityParameter is the Longhorn StorageClass parameter, and the
+	// spec field of a Longhorn Volume, selecting its data locality.
+	dataLocalityParameter = "dataLocality"
+
+	// dataLocalityStrictLocal is the data locality of volumes whose single
+	// replica must be on the node they are attached to. Such a volume can
+	// only ever attach on the node holding its replica.
+	dataLocalityStrictLocal = "strict-local"
+
+	// dataLocalityBestEffort is the data locality of volumes Longhorn tries
+	// to keep a replica of on the node they are attached to, rebuilding one
+	// there if there is none.
+	dataLocalityBestEffort = "best-effort"
+
+	// strictLocalStateKey is the CycleState key under which PreFilter stores
+	// the replica nodes of the pod's strict-local volumes.
+	strictLocalStateKey framework.StateKey = Name + "/strictLocal"
+)
+
+// strictLocalState is the result of the strict-local lookup for one
+// scheduling cycle.
+type strictLocalState struct {
+	// volumes maps each of the pod's strict-local volumes that has a replica
+	// to the nodes holding one, sorted. A strict-local volume has a single
+	// replica, but while it is rebuilt a second one can be on another node.
+	volumes map[string][]string
+
+	// nodes are the nodes holding a replica of every volume in volumes.
+	nodes map[string]bool
+
+	// skipped is set when PreFilter skipped co-scheduling for the pod, so
+	// that Filter only checks the strict-local volumes.
+	skipped bool
+}
+
+// Clone implements framework.StateData. strictLocalState is never modified
+// after PreFilter writes it, so it can be shared between clones.
+func (s *strictLocalState) Clone() framework.StateData {
+	return s
+}
+
+// volumeDataLocality returns the data locality of the Longhorn volume bound
+// to pv. The StorageClass parameter is read first; when it selects
+// strict-local or cannot be read, the Longhorn Volume is read too and its
+// spec.dataLocality wins. Both are read from informers.
+func (p *Plugin) volumeDataLocality(ctx context.Context, opts lookupOptions, pv *corev1.PersistentVolume) string {
+	locality, known := "", false
+	if pv.Spec.StorageClassName != "" {
+		if sc, err := getStorageClass(ctx, p.clientset, pv.Spec.StorageClassName, opts); err == nil {
+			locality, known = sc.Parameters[dataLocalityParameter], true
+		}
+	}
+	if known && locality != dataLocalityStrictLocal {
+		return locality
+	}
+	volumes := p.volumes[opts.longhornNamespace()]
+	if volumes == nil {
+		return locality
+	}
+	obj, err := volumes.Get(longhornVolumeName(pv))
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.V(4).InfoS("LonghornCoSchedule: reading Longhorn Volume failed", "volume", longhornVolumeName(pv), "err", err)
+		}
+		return locality
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		if value, found, _ := unstructured.NestedString(u.Object, "spec", dataLocalityParameter); found {
+			return value
+		}
+	}
+	return locality
+}
+
+// longhornVolumeName returns the name of the Longhorn Volume of the Longhorn
+// CSI PV: its volume handle, or the PV name if it has none.
+func longhornVolumeName(pv *corev1.PersistentVolume) string {
+	if pv.Spec.CSI != nil && pv.Spec.CSI.VolumeHandle != "" {
+		return pv.Spec.CSI.VolumeHandle
+	}
+	return pv.Name
+}
+
+// longhornVolumesWithDataLocality returns the Longhorn volume names of the
+// pod's bound Longhorn PVCs, RWO or RWX, whose data locality is locality.
+// PVCs and PVs that cannot be read are left out.
+func (p *Plugin) longhornVolumesWithDataLocality(ctx context.Context, pod *corev1.Pod, opts lookupOptions, locality string) []string {
+	var volumes []string
+	for _, pvcName := range collectPVCNames(pod) {
+		pvc, err := p.getPVC(ctx, pod.Namespace, pvcName)
+		if err != nil || pvc.Spec.VolumeName == "" {
+			continue
+		}
+		pv, err := getPV(ctx, p.clientset, pvc.Spec.VolumeName, opts)
+		if err != nil || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != LonghornDriver {
+			continue
+		}
+		if p.volumeDataLocality(ctx, opts, pv) == locality {
+			volumes = append(volumes, longhornVolumeName(pv))
+		}
+	}
+	return volumes
+}
+
+// resolveStrictLocal finds the nodes holding the replicas of the pod's bound
+// strict-local Longhorn volumes, whatever the pod's co-scheduling mode, and
+// those holding a replica of each of them. Volumes without a replica yet do
+// not restrict the pod.
+func (p *Plugin) resolveStrictLocal(ctx context.Context, pod *corev1.Pod) *strictLocalState {
+	opts := p.lookupOptions(pod)
+	data := &strictLocalState{}
+	for _, volumeName := range p.longhornVolumesWithDataLocality(ctx, pod, opts, dataLocalityStrictLocal) {
+		nodes := slices.Sorted(maps.Keys(p.replicaScores(opts, volumeName)))
+		if len(nodes) == 0 {
+			continue
+		}
+		if data.volumes == nil {
+			data.volumes = map[string][]string{}
+			data.nodes = map[string]bool{}
+			for _, node := range nodes {
+				data.nodes[node] = true
+			}
+		}
+		data.volumes[volumeName] = nodes
+		for node := range data.nodes {
+			if !slices.Contains(nodes, node) {
+				delete(data.nodes, node)
+			}
+		}
+	}
+	return data
+}
+
+// preFilterStrictLocal stores the replica nodes of the pod's strict-local
+// volumes for Filter, and returns the status PreFilter returns. If the pod
+// has such a volume, a Skip status of the co-scheduling PreFilter is
+// dropped, so that Filter still keeps the pod on the replica's node.
+func (p *Plugin) preFilterStrictLocal(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, status *framework.Status) *framework.Status {
+	data := p.resolveStrictLocal(ctx, pod)
+	data.skipped = status.IsSkip()
+	state.Write(strictLocalStateKey, data)
+	if len(data.volumes) == 0 {
+		return status
+	}
+	klog.V(4).InfoS("LonghornCoSchedule/PreFilter: pod has strict-local volumes",
+		"pod", klog.KObj(pod),
+		"replicaNodes", data.volumes,
+	)
+	return nil
+}
+
+// strictLocalData returns what preFilterStrictLocal stored in the
+// CycleState, or nil if it stored nothing.
+func strictLocalData(state *framework.CycleState) *strictLocalState {
+	if state == nil {
+		return nil
+	}
+	data, err := state.Read(strictLocalStateKey)
+	if err != nil {
+		return nil
+	}
+	s, _ := data.(*strictLocalState)
+	return s
+}
+
+// filterStrictLocal rejects every node but those holding a replica of each
+// of the pod's strict-local volumes, or every node if there are none.
+func filterStrictLocal(data *strictLocalState, pod *corev1.Pod, nodeName string) *framework.Status {
+	if data == nil || len(data.volumes) == 0 {
+		return nil
+	}
+	if len(data.nodes) == 0 {
+		var placements []string
+		for _, volume := range slices.Sorted(maps.Keys(data.volumes)) {
+			placements = append(placements, fmt.Sprintf("%s on %s", volume, quoteNodes(data.volumes[volume])))
+		}
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("the replicas of the pod's strict-local Longhorn volumes are on different nodes: %s", strings.Join(placements, "; ")))
+	}
+	if data.nodes[nodeName] {
+		klog.V(5).InfoS("LonghornCoSchedule/Filter: node holds the replicas of the strict-local volumes", "pod", klog.KObj(pod), "node", nodeName)
+		return nil
+	}
+	for _, volume := range slices.Sorted(maps.Keys(data.volumes)) {
+		nodes := data.volumes[volume]
+		if slices.Contains(nodes, nodeName) {
+			continue
+		}
+		klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (strict-local volume replica elsewhere)",
+			"pod", klog.KObj(pod),
+			"node", nodeName,
+			"volume", volume,
+			"replicaNodes", nodes,
+		)
+		if len(nodes) == 1 {
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+				fmt.Sprintf("strict-local Longhorn volume %s can only attach on node %q, which holds its replica", volume, nodes[0]))
+		}
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("strict-local Longhorn volume %s can only attach on nodes %s, which hold its replicas", volume, quoteNodes(nodes)))
+	}
+	return nil
+}
+
+// quoteNodes joins the quoted node names.
+func quoteNodes(nodes []string) string {
+	quoted := make([]string, len(nodes))
+	for i, node := range nodes {
+		quoted[i] = fmt.Sprintf("%q", node)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,141 @@
+package longhorn_cosched
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// makeClassPV creates a Longhorn PV of the StorageClass.
+func makeClassPV(name, storageClass string) *corev1.PersistentVolume {
+	pv := makeCSIPV(name, LonghornDriver)
+	pv.Spec.StorageClassName = storageClass
+	return pv
+}
+
+// makeDataLocalityClass creates a Longhorn StorageClass with the data
+// locality parameter.
+func makeDataLocalityClass(name, dataLocality string) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: name},
+		Provisioner: LonghornDriver,
+		Parameters:  map[string]string{dataLocalityParameter: dataLocality},
+	}
+}
+
+// TestStrictLocalVolumes checks that pods with a strict-local Longhorn
+// volume are kept on the node holding its replica whatever their
+// co-scheduling mode, and that other nodes are rejected with a reason naming
+// the volume and the replica's node.
+func TestStrictLocalVolumes(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		strictPV    = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+		otherPV     = "pvc-8c1f0b0e-2f5e-4a8b-9d0a-6f6f2a7c9b11"
+		rebuiltPV   = "pvc-0d3c6f5e-7a1b-4c2d-8e9f-a0b1c2d3e4f5"
+	)
+	nodes := []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")}
+	strictClass := makeDataLocalityClass("longhorn-strict", dataLocalityStrictLocal)
+	bestEffortClass := makeDataLocalityClass("longhorn-best-effort", "best-effort")
+	strictVolume := makeLonghornVolumeCR(otherPV, "")
+	strictVolume.Object["spec"] = map[string]interface{}{dataLocalityParameter: dataLocalityStrictLocal}
+	replicas := []runtime.Object{
+		makeReplicaCR(strictPV+"-r-1", strictPV, "node-2", "running", false),
+		makeReplicaCR(otherPV+"-r-1", otherPV, "node-3", "running", false),
+		strictVolume,
+		// A replica of rebuiltPV is being rebuilt on node-3.
+		makeReplicaCR(rebuiltPV+"-r-1", rebuiltPV, "node-2", "running", false),
+		makeReplicaCR(rebuiltPV+"-r-2", rebuiltPV, "node-3", "stopped", false),
+	}
+
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		// rootPV and dataPV are bound to the PVCs rootdisk and, if set,
+		// datadisk.
+		rootPV, dataPV *corev1.PersistentVolume
+		wantFeasible   []string
+		wantReason     string
+	}{
+		{
+			name:         "not opted in — strict-local from the StorageClass",
+			pod:          makeVM("vm", vmNamespace, false, "rootdisk"),
+			rootPV:       makeClassPV(strictPV, strictClass.Name),
+			wantFeasible: []string{"node-2"},
+			wantReason:   `strict-local Longhorn volume ` + strictPV + ` can only attach on node "node-2", which holds its replica`,
+		},
+		{
+			name:         "hard mode — strict-local from the StorageClass",
+			pod:          makeVM("vm", vmNamespace, true, "rootdisk"),
+			rootPV:       makeClassPV(strictPV, strictClass.Name),
+			wantFeasible: []string{"node-2"},
+			wantReason:   `can only attach on node "node-2"`,
+		},
+		{
+			name:         "no StorageClass — strict-local from the Longhorn Volume",
+			pod:          makeVM("vm", vmNamespace, false, "rootdisk"),
+			rootPV:       makeClassPV(otherPV, ""),
+			wantFeasible: []string{"node-3"},
+			wantReason:   `can only attach on node "node-3"`,
+		},
+		{
+			name:         "best-effort volume — not filtered",
+			pod:          makeVM("vm", vmNamespace, false, "rootdisk"),
+			rootPV:       makeClassPV(strictPV, bestEffortClass.Name),
+			wantFeasible: []string{"node-1", "node-2", "node-3"},
+		},
+		{
+			name:         "replica being rebuilt — both replica nodes feasible",
+			pod:          makeVM("vm", vmNamespace, false, "rootdisk"),
+			rootPV:       makeClassPV(rebuiltPV, strictClass.Name),
+			wantFeasible: []string{"node-2", "node-3"},
+			wantReason:   `strict-local Longhorn volume ` + rebuiltPV + ` can only attach on nodes "node-2", "node-3", which hold its replicas`,
+		},
+		{
+			name:         "replica being rebuilt and another volume — the node holding both",
+			pod:          makeVM("vm", vmNamespace, false, "rootdisk", "datadisk"),
+			rootPV:       makeClassPV(rebuiltPV, strictClass.Name),
+			dataPV:       makeClassPV(strictPV, strictClass.Name),
+			wantFeasible: []string{"node-2"},
+		},
+		{
+			name:       "replicas on different nodes — every node rejected",
+			pod:        makeVM("vm", vmNamespace, false, "rootdisk", "datadisk"),
+			rootPV:     makeClassPV(strictPV, strictClass.Name),
+			dataPV:     makeClassPV(otherPV, strictClass.Name),
+			wantReason: "strict-local Longhorn volumes are on different nodes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{tt.pod, strictClass, bestEffortClass, tt.rootPV, makeRWOPVC("rootdisk", vmNamespace, tt.rootPV.Name)}
+			if tt.dataPV != nil {
+				objects = append(objects, tt.dataPV, makeRWOPVC("datadisk", vmNamespace, tt.dataPV.Name))
+			}
+			fwk, _, _ := newTestFramework(t, testCluster{nodes: nodes, objects: objects, dynObjects: replicas})
+
+			_, m := runFilters(t, fwk, tt.pod)
+			if m.Len() != len(nodes)-len(tt.wantFeasible) {
+				t.Errorf("Filter rejected %d nodes, want all but %v", m.Len(), tt.wantFeasible)
+			}
+			for _, node := range nodes {
+				if slices.Contains(tt.wantFeasible, node.Name) {
+					continue
+				}
+				status := m.Get(node.Name)
+				if status.Code() != framework.UnschedulableAndUnresolvable {
+					t.Errorf("status of %s = %v, want UnschedulableAndUnresolvable", node.Name, status.Code())
+				}
+				if !strings.Contains(status.Message(), tt.wantReason) {
+					t.Errorf("status of %s = %q, want it to contain %q", node.Name, status.Message(), tt.wantReason)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+)
+
+// vmiGVR is the GroupVersionResource of KubeVirt's VirtualMachineInstance.
+var vmiGVR = schema.GroupVersionResource{
+	Group:    "kubevirt.io",
+	Version:  "v1",
+	Resource: "virtualmachineinstances",
+}
+
+// vmiAnnotationCacheTTL is how long the co-scheduling annotation read from a
+// VirtualMachineInstance is reused before the VMI is read again.
+const vmiAnnotationCacheTTL = time.Minute
+
+// VMIOwner returns the owner reference of the VirtualMachineInstance that
+// owns the pod, or nil if there is none.
+func VMIOwner(pod *corev1.Pod) *metav1.OwnerReference {
+	for i, ref := range pod.OwnerReferences {
+		if ref.Kind == "VirtualMachineInstance" && strings.HasPrefix(ref.APIVersion, vmiGVR.Group+"/") {
+			return &pod.OwnerReferences[i]
+		}
+	}
+	return nil
+}
+
+// vmiAnnotation is the co-scheduling annotation of a VirtualMachineInstance.
+type vmiAnnotation struct {
+	value string
+	set   bool
+}
+
+// vmiMode returns the mode selected by the co-scheduling annotation of the
+// VirtualMachineInstance that owns the pod. decided is false if the
+// InheritVMIAnnotation arg is off, the pod has no VMI owner, or the VMI does
+// not carry the annotation or cannot be read. Like the pod's own annotation,
+// an opt-out or invalid value decides: the pod is not co-scheduled.
+func (p *Plugin) vmiMode(ctx context.Context, pod *corev1.Pod) (mode Mode, decided bool) {
+	if p.vmis == nil || p.dynClient == nil {
+		return "", false
+	}
+	owner := VMIOwner(pod)
+	if owner == nil {
+		return "", false
+	}
+
+	a, ok := p.vmis.get(owner.UID)
+	if !ok {
+		obj, err := p.getVMI(ctx, pod.Namespace, owner.Name)
+		switch {
+		case apierrors.IsNotFound(err):
+			// Deleted, or never created; nothing to inherit.
+		case err != nil:
+			klog.V(4).InfoS("LonghornCoSchedule: reading VirtualMachineInstance failed", "pod", klog.KObj(pod), "vmi", owner.Name, "err", err)
+			return "", false
+		case obj.GetUID() == owner.UID:
+			a.value, a.set = obj.GetAnnotations()[AnnotationKey]
+		}
+		p.vmis.put(owner.UID, a)
+	}
+	if !a.set {
+		return "", false
+	}
+
+	mode, valid := ParseMode(a.value)
+	if !valid {
+		klog.V(4).InfoS("LonghornCoSchedule: ignoring invalid co-scheduling annotation on VirtualMachineInstance",
+			"pod", klog.KObj(pod),
+			"vmi", owner.Name,
+			"value", a.value,
+		)
+	}
+	return mode, true
+}
+
+// watchVMIs starts an informer on the VirtualMachineInstances of every
+// namespace and returns it. It is not waited for: getVMI reads the API until
+// it has synced.
+func watchVMIs(ctx context.Context, dynClient dynamic.Interface) informers.GenericInformer {
+	if dynClient == nil {
+		return nil
+	}
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynClient, 0)
+	informer := factory.ForResource(vmiGVR)
+	factory.Start(ctx.Done())
+	return informer
+}
+
+// getVMI returns the named VirtualMachineInstance, reading from the VMI
+// informer's cache once it has synced and falling back to a live GET
+// otherwise.
+func (p *Plugin) getVMI(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	if p.vmiInformer == nil || !p.vmiInformer.Informer().HasSynced() {
+		return p.dynClient.Resource(vmiGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	obj, err := p.vmiInformer.Lister().ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	vmi, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T for VirtualMachineInstance %s/%s", obj, namespace, name)
+	}
+	return vmi, nil
+}
@@ -0,0 +1,135 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// makeVMI creates an unstructured VirtualMachineInstance with the given
+// co-scheduling annotation value, if any.
+func makeVMI(name, namespace string, uid types.UID, value *string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(vmiGVR.Group + "/" + vmiGVR.Version)
+	obj.SetKind("VirtualMachineInstance")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetUID(uid)
+	if value != nil {
+		obj.SetAnnotations(map[string]string{AnnotationKey: *value})
+	}
+	return obj
+}
+
+// makeLauncher creates a virt-launcher pod owned by the named VMI.
+func makeLauncher(vmiName, namespace string, vmiUID types.UID, pvcNames ...string) *corev1.Pod {
+	pod := makeVM("virt-launcher-"+vmiName, namespace, false, pvcNames...)
+	pod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: vmiGVR.Group + "/" + vmiGVR.Version,
+		Kind:       "VirtualMachineInstance",
+		Name:       vmiName,
+		UID:        vmiUID,
+	}}
+	return pod
+}
+
+// TestInheritVMIAnnotation checks that a virt-launcher pod without the
+// co-scheduling annotation inherits it from its VirtualMachineInstance when
+// InheritVMIAnnotation is set, and that its own annotations still win.
+func TestInheritVMIAnnotation(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		vmiName     = "my-vm"
+		vmiUID      = types.UID("5d0b1c7e-2f41-4c1a-9a57-0c6f8d3e2b19")
+		pvcName     = "shared"
+		pvName      = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+
+	tests := []struct {
+		name         string
+		inherit      bool
+		vmiValue     *string
+		vmiUID       types.UID
+		podValue     *string
+		pvcValue     *string
+		wantMode     Mode
+		wantRejected int
+	}{
+		{name: "VMI hard, pod silent", inherit: true, vmiValue: ptr("true"), wantMode: ModeHard, wantRejected: 1},
+		{name: "VMI soft, pod silent", inherit: true, vmiValue: ptr("soft"), wantMode: ModeSoft},
+		{name: "flag off", vmiValue: ptr("true")},
+		{name: "pod off wins over VMI", inherit: true, vmiValue: ptr("true"), podValue: ptr(OptOutValue)},
+		{name: "VMI off wins over PVC", inherit: true, vmiValue: ptr(OptOutValue), pvcValue: ptr("true")},
+		{name: "VMI silent, PVC hard", inherit: true, pvcValue: ptr("true"), wantMode: ModeHard, wantRejected: 1},
+		{name: "VMI recreated with another UID", inherit: true, vmiValue: ptr("true"), vmiUID: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeLauncher(vmiName, vmNamespace, vmiUID, pvcName)
+			if tt.podValue != nil {
+				pod.Annotations = map[string]string{AnnotationKey: *tt.podValue}
+			}
+			pvc := makePVC(pvcName, vmNamespace, pvName)
+			if tt.pvcValue != nil {
+				pvc.Annotations = map[string]string{AnnotationKey: *tt.pvcValue}
+			}
+			uid := vmiUID
+			if tt.vmiUID != "" {
+				uid = tt.vmiUID
+			}
+
+			fwk, _, _ := newTestFramework(t, testCluster{
+				nodes:      []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4")},
+				objects:    []runtime.Object{pod, pvc, makeShareManagerPod(pvName, "node-1")},
+				dynObjects: []runtime.Object{makeVMI(vmiName, vmNamespace, uid, tt.vmiValue)},
+				args:       Args{InheritVMIAnnotation: tt.inherit},
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			data, err := state.Read(stateKey)
+			if err != nil {
+				t.Fatalf("reading CycleState: %v", err)
+			}
+			if got := data.(*stateData).mode; got != tt.wantMode {
+				t.Errorf("mode = %q, want %q", got, tt.wantMode)
+			}
+			if m.Len() != tt.wantRejected {
+				t.Errorf("%d nodes rejected, want %d", m.Len(), tt.wantRejected)
+			}
+		})
+	}
+}
+
+// TestVMIAnnotationCached checks that the VMI is read once per TTL, however
+// often its pod is scheduled.
+func TestVMIAnnotationCached(t *testing.T) {
+	const vmiUID = types.UID("5d0b1c7e-2f41-4c1a-9a57-0c6f8d3e2b19")
+	pod := makeLauncher("my-vm", "default", vmiUID)
+	dynClient := newDynamicClient(makeVMI("my-vm", "default", vmiUID, ptr("true")))
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+	p := &Plugin{dynClient: dynClient, vmis: newExpiringCache[types.UID, vmiAnnotation](fakeClock, time.Minute)}
+	ctx := context.Background()
+
+	for range 3 {
+		if mode, decided := p.vmiMode(ctx, pod); mode != ModeHard || !decided {
+			t.Fatalf("vmiMode() = %q, %v, want %q, true", mode, decided, ModeHard)
+		}
+	}
+	if got := len(dynClient.Actions()); got != 1 {
+		t.Errorf("VMI read %d times, want 1", got)
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	p.vmiMode(ctx, pod)
+	if got := len(dynClient.Actions()); got != 2 {
+		t.Errorf("VMI read %d times after the TTL, want 2", got)
+	}
+}
@@ -0,0 +1,249 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/helper"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+)
+
+const (
+	// VMSpreadName is the name of the VMSpread plugin used in the plugin
+	// registry and configurations.
+	VMSpreadName = "VMSpread"
+
+	// SpreadGroupLabel is the pod label naming the spread group of a VM.
+	// Pods of the same group in the same namespace avoid each other's nodes.
+	SpreadGroupLabel = "kubevirt-scheduler/spread-group"
+
+	// vmSpreadStateKey is the CycleState key of the VMSpread plugin.
+	vmSpreadStateKey framework.StateKey = VMSpreadName
+)
+
+// VMSpreadArgs are the args of the VMSpread plugin.
+type VMSpreadArgs struct {
+	// RequireSpread also rejects, in Filter, nodes already running a pod of
+	// the pod's spread group. A hard-mode co-scheduled pod is still allowed
+	// on its share-manager node. By default nodes are only scored.
+	RequireSpread bool `json:"requireSpread,omitempty"`
+}
+
+// decodeVMSpreadArgs decodes the VMSpread args passed in by the scheduler
+// framework. A nil object yields the zero value.
+func decodeVMSpreadArgs(obj runtime.Object) (VMSpreadArgs, error) {
+	var args VMSpreadArgs
+	if err := frameworkruntime.DecodeInto(obj, &args); err != nil {
+		return VMSpreadArgs{}, fmt.Errorf("failed to decode %s args: %w", VMSpreadName, err)
+	}
+	return args, nil
+}
+
+// VMSpread implements the PreFilter, Filter and Score extension points to
+// keep the pods of a spread group, e.g. the etcd VMs of a guest cluster, on
+// different nodes. The group's pods are found in the scheduler's snapshot,
+// so it costs no API requests.
+//
+// It composes with LonghornCoSchedule: a hard-mode pod pinned to its
+// share-manager node is never rejected there, while in soft mode, where
+// the share-manager node is only preferred, spreading wins.
+type VMSpread struct {
+	args   VMSpreadArgs
+	handle framework.Handle
+}
+
+var _ framework.PreFilterPlugin = &VMSpread{}
+var _ framework.FilterPlugin = &VMSpread{}
+var _ framework.ScorePlugin = &VMSpread{}
+
+// Name returns the name of the plugin.
+func (s *VMSpread) Name() string {
+	return VMSpreadName
+}
+
+// NewVMSpread creates a new instance of the VMSpread plugin.
+func NewVMSpread(_ context.Context, obj runtime.Object, h framework.Handle) (framework.Plugin, error) {
+	args, err := decodeVMSpreadArgs(obj)
+	if err != nil {
+		return nil, err
+	}
+	return newVMSpread(h, args), nil
+}
+
+// newVMSpread wires a VMSpread around the framework handle. It is separate
+// from NewVMSpread so tests can pass args directly.
+func newVMSpread(h framework.Handle, args VMSpreadArgs) *VMSpread {
+	return &VMSpread{args: args, handle: h}
+}
+
+// vmSpreadState is what PreFilter found out about the pod's spread group.
+type vmSpreadState struct {
+	// group is the pod's spread group.
+	group string
+
+	// nodes counts, per node, the other pods of the group on it.
+	nodes map[string]int
+}
+
+// Clone implements framework.StateData. vmSpreadState is never modified
+// after PreFilter writes it, so it can be shared between clones.
+func (s *vmSpreadState) Clone() framework.StateData {
+	return s
+}
+
+// resolve counts the pods of the pod's spread group on each node of the
+// snapshot. Pods of other namespaces, finished pods and the pod itself do not
+// count.
+func (s *VMSpread) resolve(pod *corev1.Pod) (*vmSpreadState, error) {
+	data := &vmSpreadState{group: pod.Labels[SpreadGroupLabel], nodes: map[string]int{}}
+	if data.group == "" {
+		return data, nil
+	}
+	nodeInfos, err := s.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return nil, err
+	}
+	for _, ni := range nodeInfos {
+		node := ni.Node()
+		if node == nil {
+			continue
+		}
+		for _, pi := range ni.Pods {
+			if isSpreadGroupMember(pi.Pod, pod, data.group) {
+				data.nodes[node.Name]++
+			}
+		}
+	}
+	return data, nil
+}
+
+// isSpreadGroupMember returns true if other is another unfinished pod of the
+// group in the pod's namespace.
+func isSpreadGroupMember(other, pod *corev1.Pod, group string) bool {
+	if other.UID == pod.UID || other.Namespace != pod.Namespace || other.Labels[SpreadGroupLabel] != group {
+		return false
+	}
+	return other.Status.Phase != corev1.PodSucceeded && other.Status.Phase != corev1.PodFailed
+}
+
+// cycleData returns what PreFilter stored in the CycleState, or resolves it
+// if the plugin was not run at PreFilter.
+func (s *VMSpread) cycleData(state *framework.CycleState, pod *corev1.Pod) (*vmSpreadState, error) {
+	if state != nil {
+		if data, err := state.Read(vmSpreadStateKey); err == nil {
+			if d, ok := data.(*vmSpreadState); ok {
+				return d, nil
+			}
+		}
+	}
+	return s.resolve(pod)
+}
+
+// PreFilter implements the PreFilterPlugin interface. It counts the pods of
+// the pod's spread group once per scheduling cycle and returns Skip,
+// bypassing Filter, unless RequireSpread is set and the pod has a group.
+func (s *VMSpread) PreFilter(_ context.Context, state *framework.CycleState, pod *corev1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	data, err := s.resolve(pod)
+	if err != nil {
+		return nil, framework.AsStatus(fmt.Errorf("listing nodes: %w", err))
+	}
+	state.Write(vmSpreadStateKey, data)
+	if data.group == "" {
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	klog.V(4).InfoS("VMSpread/PreFilter: counted spread group pods",
+		"pod", klog.KObj(pod),
+		"group", data.group,
+		"nodes", data.nodes,
+	)
+	if !s.args.RequireSpread {
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	return nil, nil
+}
+
+// PreFilterExtensions returns nil: the group's pods are counted once per
+// cycle, so preemption victims are not taken into account.
+func (s *VMSpread) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter implements the FilterPlugin interface. With RequireSpread, it
+// rejects nodes running another pod of the pod's spread group, unless the
+// pod is pinned to the node as its share-manager node by LonghornCoSchedule
+// in hard mode.
+func (s *VMSpread) Filter(_ context.Context, state *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	data, err := s.cycleData(state, pod)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	count := data.nodes[node.Name]
+	if count == 0 {
+		return nil
+	}
+	if pinnedNode(state) == node.Name {
+		klog.V(4).InfoS("VMSpread/Filter: node runs spread group pods, allowed as the hard-mode share-manager node",
+			"pod", klog.KObj(pod),
+			"node", node.Name,
+			"group", data.group,
+		)
+		return nil
+	}
+	klog.V(4).InfoS("VMSpread/Filter: node rejected (runs spread group pods)",
+		"pod", klog.KObj(pod),
+		"node", node.Name,
+		"group", data.group,
+		"count", count,
+	)
+	return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+		fmt.Sprintf("node %q already runs %d pod(s) of spread group %q", node.Name, count, data.group))
+}
+
+// pinnedNode returns the share-manager node LonghornCoSchedule pins the pod
+// to in this cycle, or "" if it does not pin it, e.g. because the pod is in
+// soft mode, its share-managers do not pin it, a fallback relaxed the pin or
+// LonghornCoSchedule is not enabled.
+func pinnedNode(state *framework.CycleState) string {
+	if state == nil {
+		return ""
+	}
+	data, err := state.Read(stateKey)
+	if err != nil {
+		return ""
+	}
+	if s, ok := data.(*stateData); ok && s.decision.Outcome == decisionPinned && len(s.pins) > 0 {
+		return s.decision.ShareManagerNode
+	}
+	return ""
+}
+
+// Score implements the ScorePlugin interface. The raw score is the number of
+// other pods of the pod's spread group on the node; NormalizeScore reverses
+// it, so nodes without one score MaxNodeScore.
+func (s *VMSpread) Score(_ context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) (int64, *framework.Status) {
+	data, err := s.cycleData(state, pod)
+	if err != nil {
+		return 0, framework.AsStatus(err)
+	}
+	return int64(data.nodes[nodeName]), nil
+}
+
+// ScoreExtensions returns the plugin itself, which implements NormalizeScore.
+func (s *VMSpread) ScoreExtensions() framework.ScoreExtensions {
+	return s
+}
+
+// NormalizeScore implements the ScoreExtensions interface. It maps the node
+// running the most pods of the group to 0 and nodes running none to
+// MaxNodeScore. If no node runs one, every node scores MaxNodeScore.
+func (s *VMSpread) NormalizeScore(_ context.Context, _ *framework.CycleState, _ *corev1.Pod, scores framework.NodeScoreList) *framework.Status {
+	return helper.DefaultNormalizeScore(framework.MaxNodeScore, true, scores)
+}
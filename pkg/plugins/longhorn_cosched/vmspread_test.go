@@ -0,0 +1,142 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/defaultbinder"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/queuesort"
+)
+
+// makeSpreadMember creates a running virt-launcher pod of the spread group
+// on the given node.
+func makeSpreadMember(name, namespace, group, nodeName string) *corev1.Pod {
+	pod := makeRunningPod(name, namespace, nodeName)
+	pod.Labels = map[string]string{SpreadGroupLabel: group}
+	return pod
+}
+
+// TestVMSpread checks that the third pod of a spread group avoids the nodes
+// of the other two, that it is only filtered with requireSpread, and that a
+// hard-mode co-scheduled pod may still go to its share-manager node while a
+// soft-mode one, or a hard-mode one its share-manager does not pin, may not.
+func TestVMSpread(t *testing.T) {
+	const (
+		vmNamespace = "default"
+		group       = "etcd"
+		pvcName     = "shared"
+	)
+	nodes := []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")}
+	members := []*corev1.Pod{
+		makeSpreadMember("etcd-0", vmNamespace, group, "node-1"),
+		makeSpreadMember("etcd-1", vmNamespace, group, "node-2"),
+		makeSpreadMember("other-0", vmNamespace, "other", "node-3"),
+		makeSpreadMember("etcd-0", "tenant", group, "node-3"),
+	}
+
+	tests := []struct {
+		name         string
+		coSchedule   string
+		requireReady bool
+		args         VMSpreadArgs
+		wantRejected []string
+		wantScores   map[string]int64
+	}{
+		{
+			name:       "score only",
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 100},
+		},
+		{
+			name:         "requireSpread",
+			args:         VMSpreadArgs{RequireSpread: true},
+			wantRejected: []string{"node-1", "node-2"},
+			wantScores:   map[string]int64{"node-3": 100},
+		},
+		{
+			name:         "requireSpread, soft mode — spreading wins over the share-manager node",
+			coSchedule:   string(ModeSoft),
+			args:         VMSpreadArgs{RequireSpread: true},
+			wantRejected: []string{"node-1", "node-2"},
+			wantScores:   map[string]int64{"node-3": 100},
+		},
+		{
+			name:         "requireSpread, hard mode — the share-manager node is kept",
+			coSchedule:   "true",
+			args:         VMSpreadArgs{RequireSpread: true},
+			wantRejected: []string{"node-2", "node-3"},
+			// The only feasible node runs the group's most pods.
+			wantScores: map[string]int64{"node-1": 0},
+		},
+		{
+			name:         "requireSpread, hard mode, share-manager not Ready — spreading wins",
+			coSchedule:   "true",
+			requireReady: true,
+			args:         VMSpreadArgs{RequireSpread: true},
+			wantRejected: []string{"node-1", "node-2"},
+			wantScores:   map[string]int64{"node-3": 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("etcd-2", vmNamespace, false, pvcName)
+			pod.UID = types.UID("uid-etcd-2")
+			pod.Labels = map[string]string{SpreadGroupLabel: group}
+			if tt.coSchedule != "" {
+				pod.Annotations = map[string]string{AnnotationKey: tt.coSchedule}
+			}
+
+			fwk, _, _ := newTestFrameworkWithPlugins(t, testCluster{
+				nodes: nodes,
+				pods:  members,
+				objects: []runtime.Object{
+					pod,
+					makePVC(pvcName, vmNamespace, fixtureVolume),
+					makeShareManagerPod(fixtureVolume, "node-1"),
+				},
+				args:       Args{RequireShareManagerReady: tt.requireReady},
+				spreadArgs: tt.args,
+			}, &config.Plugins{
+				QueueSort: config.PluginSet{Enabled: []config.Plugin{{Name: queuesort.Name}}},
+				PreFilter: config.PluginSet{Enabled: []config.Plugin{{Name: Name}, {Name: VMSpreadName}}},
+				Filter:    config.PluginSet{Enabled: []config.Plugin{{Name: Name}, {Name: VMSpreadName}}},
+				Score:     config.PluginSet{Enabled: []config.Plugin{{Name: VMSpreadName, Weight: 1}}},
+				Bind:      config.PluginSet{Enabled: []config.Plugin{{Name: defaultbinder.Name}}},
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				if m.Get(node).IsSuccess() {
+					t.Errorf("node %s not rejected", node)
+				}
+			}
+
+			var feasible []*framework.NodeInfo
+			nodeInfos, err := fwk.SnapshotSharedLister().NodeInfos().List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ni := range nodeInfos {
+				if _, ok := tt.wantScores[ni.Node().Name]; ok {
+					feasible = append(feasible, ni)
+				}
+			}
+			scores, status := fwk.RunScorePlugins(context.Background(), state, pod, feasible)
+			if !status.IsSuccess() {
+				t.Fatalf("RunScorePlugins() = %v", status)
+			}
+			for _, s := range scores {
+				if s.TotalScore != tt.wantScores[s.Name] {
+					t.Errorf("score of %s = %d, want %d", s.Name, s.TotalScore, tt.wantScores[s.Name])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package longhorn_cosched
+
+import (
+	"context"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// listVolumeAttachments returns all VolumeAttachments, reading from the
+// informer cache when opts carries a lister and with a live LIST otherwise.
+func listVolumeAttachments(ctx context.Context, clientset kubernetes.Interface, opts lookupOptions) ([]*storagev1.VolumeAttachment, error) {
+	if opts.vaLister != nil {
+		return opts.vaLister.List(labels.Everything())
+	}
+	list, err := clientset.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	attachments := make([]*storagev1.VolumeAttachment, 0, len(list.Items))
+	for i := range list.Items {
+		attachments = append(attachments, &list.Items[i])
+	}
+	return attachments, nil
+}
+
+// getVolumeAttachmentPlacement returns the node of an attached Longhorn
+// VolumeAttachment (spec.source.persistentVolumeName) of the PV. It needs
+// neither the Longhorn CRDs nor the share-manager's name, so it still answers
+// when those lookups cannot. The CSI driver attaches an RWX volume on every
+// node that mounts it, so the node is that of a consumer rather than of the
+// share-manager; if there are several, the oldest attachment wins. Failures
+// are logged and yield an empty node.
+func getVolumeAttachmentPlacement(ctx context.Context, clientset kubernetes.Interface, pvName string, opts lookupOptions) shareManagerPlacement {
+	attachments, err := listVolumeAttachments(ctx, clientset, opts)
+	if err != nil {
+		klog.V(4).InfoS("LonghornCoSchedule: listing VolumeAttachments failed", "pv", pvName, "err", err)
+		return shareManagerPlacement{}
+	}
+
+	var oldest *storagev1.VolumeAttachment
+	for _, va := range attachments {
+		source := va.Spec.Source.PersistentVolumeName
+		if source == nil || *source != pvName || va.Spec.Attacher != LonghornDriver ||
+			!va.Status.Attached || va.DeletionTimestamp != nil || va.Spec.NodeName == "" {
+			continue
+		}
+		if oldest == nil || va.CreationTimestamp.Before(&oldest.CreationTimestamp) ||
+			(va.CreationTimestamp.Equal(&oldest.CreationTimestamp) && va.Name < oldest.Name) {
+			oldest = va
+		}
+	}
+	if oldest == nil {
+		return shareManagerPlacement{}
+	}
+	klog.V(5).InfoS("LonghornCoSchedule: volume attached according to its VolumeAttachment",
+		"pv", pvName,
+		"volumeAttachment", oldest.Name,
+		"node", oldest.Spec.NodeName,
+	)
+	return shareManagerPlacement{node: oldest.Spec.NodeName, attachment: true}
+}
@@ -0,0 +1,100 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// makeVolumeAttachment creates a VolumeAttachment of the PV to the node.
+func makeVolumeAttachment(name, attacher, pvName, nodeName string, attached bool, created time.Time) *storagev1.VolumeAttachment {
+	return &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: attacher,
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+			NodeName: nodeName,
+		},
+		Status: storagev1.VolumeAttachmentStatus{Attached: attached},
+	}
+}
+
+// TestVolumeAttachmentFallback checks that an attached Longhorn
+// VolumeAttachment of the PV names the node only when the other sources
+// yield nothing, and that such a node does not pin the pod.
+func TestVolumeAttachmentFallback(t *testing.T) {
+	const (
+		pvcName = "shared"
+		pvName  = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+	)
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		attachments []runtime.Object
+		smNode      string
+		wantNode    string
+	}{
+		{
+			name:        "attached",
+			attachments: []runtime.Object{makeVolumeAttachment("csi-1", LonghornDriver, pvName, "node-2", true, now)},
+			wantNode:    "node-2",
+		},
+		{
+			name:        "not attached yet",
+			attachments: []runtime.Object{makeVolumeAttachment("csi-1", LonghornDriver, pvName, "node-2", false, now)},
+		},
+		{
+			name:        "another PV",
+			attachments: []runtime.Object{makeVolumeAttachment("csi-1", LonghornDriver, "pvc-other", "node-2", true, now)},
+		},
+		{
+			name:        "another driver",
+			attachments: []runtime.Object{makeVolumeAttachment("csi-1", "rbd.csi.ceph.com", pvName, "node-2", true, now)},
+		},
+		{
+			name: "oldest attachment wins",
+			attachments: []runtime.Object{
+				makeVolumeAttachment("csi-1", LonghornDriver, pvName, "node-3", true, now),
+				makeVolumeAttachment("csi-2", LonghornDriver, pvName, "node-2", true, now.Add(-time.Minute)),
+			},
+			wantNode: "node-2",
+		},
+		{
+			name:        "share-manager found first",
+			attachments: []runtime.Object{makeVolumeAttachment("csi-1", LonghornDriver, pvName, "node-2", true, now)},
+			smNode:      "node-1",
+			wantNode:    "node-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append([]runtime.Object{makePVC(pvcName, "default", pvName)}, tt.attachments...)
+			clientset := fake.NewSimpleClientset(objects...)
+			serveShareManagers(clientset, shareManagerGVR.Version)
+			var dynObjects []runtime.Object
+			if tt.smNode != "" {
+				dynObjects = append(dynObjects, makeShareManagerCR(pvName, tt.smNode, "running"))
+			}
+			opts := lookupOptions{api: newShareManagerAPI(clientset.Discovery(), clocktesting.NewFakePassiveClock(now))}
+
+			pl, err := getShareManagerNodeForPVC(context.Background(), clientset, newDynamicClient(dynObjects...), "default", pvcName, opts)
+			if err != nil {
+				t.Fatalf("getShareManagerNodeForPVC() error = %v", err)
+			}
+			if pl.node != tt.wantNode {
+				t.Errorf("getShareManagerNodeForPVC() node = %q, want %q", pl.node, tt.wantNode)
+			}
+			if wantPins := tt.smNode != ""; pl.node != "" && pl.pins() != wantPins {
+				t.Errorf("pins() = %v, want %v", pl.pins(), wantPins)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// podVolumeNodeTags returns the node tags selected (spec.nodeSelector) by the
+// Longhorn Volumes of the pod's co-scheduled RWX PVCs, sorted and without
+// duplicates. PVCs that are not bound or cannot be read, and Volumes that
+// cannot be read, select none.
+func (p *Plugin) podVolumeNodeTags(ctx context.Context, pod *corev1.Pod) []string {
+	opts := p.lookupOptions(pod)
+	var tags []string
+	for _, pvcName := range coSchedulePVCNames(pod, p.hotplugPVCNames(ctx, pod)) {
+		pvc, err := p.getPVC(ctx, pod.Namespace, pvcName)
+		if err != nil || !IsRWX(pvc) || pvc.Spec.VolumeName == "" {
+			continue
+		}
+		volumeName := pvc.Spec.VolumeName
+		if pv, err := getPV(ctx, p.clientset, volumeName, opts); err == nil && pv.Spec.CSI != nil && pv.Spec.CSI.VolumeHandle != "" {
+			volumeName = pv.Spec.CSI.VolumeHandle
+		}
+		if obj := getLonghornVolume(ctx, p.dynClient, opts, volumeName); obj != nil {
+			tags = append(tags, stringList(obj, "spec", "nodeSelector")...)
+		}
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// missingNodeTags returns the tags the node's Longhorn Node (spec.tags) lacks,
+// or nil if it has them all, the node has no Longhorn Node, or Longhorn Nodes
+// are not watched.
+func (p *Plugin) missingNodeTags(nodeName string, tags []string) []string {
+	if len(tags) == 0 || p.longhornNodes == nil {
+		return nil
+	}
+	obj, err := p.longhornNodes.Get(nodeName)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.V(4).InfoS("LonghornCoSchedule: reading Longhorn Node failed", "node", nodeName, "err", err)
+		}
+		return nil
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+	nodeTags := stringList(u, "spec", "tags")
+	var missing []string
+	for _, tag := range tags {
+		if !slices.Contains(nodeTags, tag) {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
+
+// stringList returns the string list at the given path of the object. Items
+// that are not strings are skipped; a missing or malformed field is empty.
+func stringList(obj *unstructured.Unstructured, fields ...string) []string {
+	raw, found, err := unstructured.NestedSlice(obj.Object, fields...)
+	if !found || err != nil {
+		return nil
+	}
+	var list []string
+	for _, item := range raw {
+		if s, ok := item.(string); ok && s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// filterVolumeNodeTags rejects the node, under VolumeNodeTagPolicyFilter, if
+// its Longhorn Node lacks a tag the pod's Longhorn Volumes select.
+func (p *Plugin) filterVolumeNodeTags(pod *corev1.Pod, data *stateData, nodeName string) *framework.Status {
+	if p.args.volumeNodeTagPolicy() != VolumeNodeTagPolicyFilter {
+		return nil
+	}
+	missing := p.missingNodeTags(nodeName, data.volumeTags)
+	if len(missing) == 0 {
+		return nil
+	}
+	klog.V(4).InfoS("LonghornCoSchedule/Filter: node rejected (Longhorn Node lacks volume node tags)",
+		"pod", klog.KObj(pod),
+		"node", nodeName,
+		"missingTags", missing,
+	)
+	return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+		fmt.Sprintf("Longhorn Node %q lacks the node tags %s its volumes select", nodeName, strings.Join(missing, ", ")))
+}
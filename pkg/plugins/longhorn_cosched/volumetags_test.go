@@ -0,0 +1,186 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// TestVolumeNodeTagPolicy checks that nodes whose Longhorn Node lacks a tag
+// the pod's Longhorn Volume selects score 0, and are rejected in hard mode
+// under the filter policy. The Volume selects "ssd": node-1's Longhorn Node
+// has it, node-2's is tagged "hdd" only and node-3's has no tags.
+func TestVolumeNodeTagPolicy(t *testing.T) {
+	const pvcName = "shared"
+
+	tests := []struct {
+		name         string
+		policy       VolumeNodeTagPolicy
+		value        string
+		smNode       string
+		wantScores   map[string]int64
+		wantRejected []string
+	}{
+		{
+			name:       "ignore",
+			value:      "soft",
+			smNode:     "node-2",
+			wantScores: map[string]int64{"node-1": 0, "node-2": framework.MaxNodeScore, "node-3": 0},
+		},
+		{
+			name:       "score, share-manager on a tagged node",
+			policy:     VolumeNodeTagPolicyScore,
+			value:      "soft",
+			smNode:     "node-1",
+			wantScores: map[string]int64{"node-1": framework.MaxNodeScore, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:       "score, share-manager on an untagged node",
+			policy:     VolumeNodeTagPolicyScore,
+			value:      "soft",
+			smNode:     "node-2",
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:       "filter, soft",
+			policy:     VolumeNodeTagPolicyFilter,
+			value:      "soft",
+			smNode:     "node-2",
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0},
+		},
+		{
+			name:         "ignore, hard",
+			value:        "true",
+			smNode:       "node-2",
+			wantRejected: []string{"node-1", "node-3"},
+		},
+		{
+			name:         "filter, hard, share-manager on a tagged node",
+			policy:       VolumeNodeTagPolicyFilter,
+			value:        "true",
+			smNode:       "node-1",
+			wantRejected: []string{"node-2", "node-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", false, pvcName)
+			pod.Annotations = map[string]string{AnnotationKey: tt.value}
+
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+				objects: []runtime.Object{
+					pod,
+					makePVC(pvcName, "default", fixtureVolume),
+					makeShareManagerPod(fixtureVolume, tt.smNode),
+				},
+				dynObjects: []runtime.Object{
+					loadFixture(t, "volume-tagged.json"),
+					loadFixture(t, "longhorn-node-ssd.json"),
+					loadFixture(t, "longhorn-node-hdd.json"),
+					makeLonghornNode("node-3", true, "True"),
+				},
+				args: Args{VolumeNodeTagPolicy: tt.policy},
+			})
+			if plugin.longhornNodes != nil {
+				ctx := context.Background()
+				if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+					nodes, err := plugin.longhornNodes.List(labels.Everything())
+					return len(nodes) == 3, err
+				}); err != nil {
+					t.Fatalf("Longhorn Nodes not listed: %v", err)
+				}
+			}
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != len(tt.wantRejected) {
+				t.Errorf("%d nodes rejected, want %v", m.Len(), tt.wantRejected)
+			}
+			for _, node := range tt.wantRejected {
+				if m.Get(node).IsSuccess() {
+					t.Errorf("node %s not rejected", node)
+				}
+			}
+			for node, want := range tt.wantScores {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestVolumeNodeTagsUntaggedShareManagerNode checks that, under the filter
+// policy, a hard-mode pod whose share-manager runs on a node lacking the
+// Volume's tags is not pinned to it, which would reject every node: the hard
+// filter is relaxed with an event, and only the tagged node passes.
+func TestVolumeNodeTagsUntaggedShareManagerNode(t *testing.T) {
+	pod := makeVM("vm", "default", true, "shared")
+	recorder := events.NewFakeRecorder(10)
+	fwk, plugin, _ := newTestFramework(t, testCluster{
+		nodes: []*corev1.Node{makeNode("node-1", "4"), makeNode("node-2", "4"), makeNode("node-3", "4")},
+		objects: []runtime.Object{
+			pod,
+			makePVC("shared", "default", fixtureVolume),
+			makeShareManagerPod(fixtureVolume, "node-2"),
+		},
+		dynObjects: []runtime.Object{
+			loadFixture(t, "volume-tagged.json"),
+			loadFixture(t, "longhorn-node-ssd.json"),
+			loadFixture(t, "longhorn-node-hdd.json"),
+			makeLonghornNode("node-3", true, "True"),
+		},
+		args:     Args{VolumeNodeTagPolicy: VolumeNodeTagPolicyFilter},
+		recorder: recorder,
+	})
+	ctx := context.Background()
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		nodes, err := plugin.longhornNodes.List(labels.Everything())
+		return len(nodes) == 3, err
+	}); err != nil {
+		t.Fatalf("Longhorn Nodes not listed: %v", err)
+	}
+
+	state, m := runFilters(t, fwk, pod)
+	var rejected []string
+	m.ForEachExplicitNode(func(node string, _ *framework.Status) { rejected = append(rejected, node) })
+	slices.Sort(rejected)
+	if !slices.Equal(rejected, []string{"node-2", "node-3"}) {
+		t.Errorf("rejected nodes = %v, want [node-2 node-3]", rejected)
+	}
+	for _, node := range []string{"node-2", "node-3"} {
+		if status := m.Get(node); !strings.Contains(status.Message(), "lacks the node tags ssd") {
+			t.Errorf("status of %s = %v, want it to name the missing tag", node, status)
+		}
+	}
+
+	data, err := plugin.cycleData(ctx, state, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.shareManagerNode != "" || data.fallbackNode != "node-2" || data.fallbackReason != missingNodeTagsReason {
+		t.Errorf("shareManagerNode = %q, fallbackNode = %q, fallbackReason = %q; want the pin to node-2 relaxed with %s",
+			data.shareManagerNode, data.fallbackNode, data.fallbackReason, missingNodeTagsReason)
+	}
+	var emitted []string
+	for len(recorder.Events) > 0 {
+		emitted = append(emitted, <-recorder.Events)
+	}
+	if !hasEvent(emitted, missingNodeTagsReason) {
+		t.Errorf("events = %v, want one with reason %s", emitted, missingNodeTagsReason)
+	}
+}
@@ -0,0 +1,26 @@
+package longhorn_cosched
+
+import "k8s.io/kubernetes/pkg/scheduler/framework"
+
+// zoneScore returns the ZoneScore share a node gets from the pod's
+// share-managers running on other nodes of its zone
+// (topology.kubernetes.io/zone): each contributes ZoneScore, scaled like its
+// placementScore, and the sum is divided by the number of share-managers, as
+// in Score. Nodes without a zone label, and share-managers on such nodes,
+// contribute nothing. Zones are read from the scheduler's snapshot.
+func (p *Plugin) zoneScore(data *stateData, nodeName string) int64 {
+	if p.args.ZoneScore == 0 || len(data.placements) == 0 {
+		return 0
+	}
+	zone := p.nodeZone(nodeName)
+	if zone == "" {
+		return 0
+	}
+	var sum int64
+	for _, pl := range data.placements {
+		if pl.node != nodeName && p.nodeZone(pl.node) == zone {
+			sum += p.args.ZoneScore * placementScore(pl) / framework.MaxNodeScore
+		}
+	}
+	return sum / int64(len(data.placements))
+}
@@ -0,0 +1,102 @@
+package longhorn_cosched
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// makeZoneNode creates a node in the zone, or without a zone label if it is
+// empty.
+func makeZoneNode(name, zone string) *corev1.Node {
+	node := makeNode(name, "4")
+	if zone != "" {
+		node.Labels = map[string]string{corev1.LabelTopologyZone: zone}
+	}
+	return node
+}
+
+// TestZoneScore checks that, with ZoneScore, the share-manager node scores
+// 100, the other nodes of its zone the zone score and every other node 0, in
+// soft mode and when the hard filter is relaxed, and that a share-manager
+// node without a zone label lends no node a zone score.
+func TestZoneScore(t *testing.T) {
+	const pvcName = "shared"
+
+	tests := []struct {
+		name       string
+		value      string
+		smNode     string
+		cordoned   bool
+		args       Args
+		wantScores map[string]int64
+	}{
+		{
+			name:       "zone score unset",
+			value:      "soft",
+			smNode:     "node-1",
+			wantScores: map[string]int64{"node-1": 100, "node-2": 0, "node-3": 0, "node-4": 0},
+		},
+		{
+			name:       "soft — share-manager node, same zone, other zone",
+			value:      "soft",
+			smNode:     "node-1",
+			args:       Args{ZoneScore: 40},
+			wantScores: map[string]int64{"node-1": 100, "node-2": 40, "node-3": 0, "node-4": 0},
+		},
+		{
+			name:       "hard, relaxed for a cordoned share-manager node",
+			value:      "true",
+			smNode:     "node-1",
+			cordoned:   true,
+			args:       Args{ZoneScore: 40, CordonedNodePolicy: CordonedNodePolicySoft},
+			wantScores: map[string]int64{"node-1": 100, "node-2": 40, "node-3": 0, "node-4": 0},
+		},
+		{
+			name:       "share-manager node without a zone label",
+			value:      "soft",
+			smNode:     "node-4",
+			args:       Args{ZoneScore: 40},
+			wantScores: map[string]int64{"node-1": 0, "node-2": 0, "node-3": 0, "node-4": 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := makeVM("vm", "default", false, pvcName)
+			pod.Annotations = map[string]string{AnnotationKey: tt.value}
+			nodes := []*corev1.Node{
+				makeZoneNode("node-1", "zone-a"),
+				makeZoneNode("node-2", "zone-a"),
+				makeZoneNode("node-3", "zone-b"),
+				makeZoneNode("node-4", ""),
+			}
+			nodes[0].Spec.Unschedulable = tt.cordoned
+
+			fwk, plugin, _ := newTestFramework(t, testCluster{
+				nodes: nodes,
+				objects: []runtime.Object{
+					pod,
+					makePVC(pvcName, "default", fixtureVolume),
+					makeShareManagerPod(fixtureVolume, tt.smNode),
+				},
+				args: tt.args,
+			})
+
+			state, m := runFilters(t, fwk, pod)
+			if m.Len() != 0 {
+				t.Errorf("%d nodes rejected, want none", m.Len())
+			}
+			for node, want := range tt.wantScores {
+				got, status := plugin.Score(context.Background(), state, pod, node)
+				if !status.IsSuccess() {
+					t.Fatalf("Score(%s) = %v", node, status)
+				}
+				if got != want {
+					t.Errorf("Score(%s) = %d, want %d", node, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Package preflight checks that a cluster is ready for the kubevirt-scheduler:
+// that the ShareManager CRD is served, that the scheduler may read what the
+// LonghornCoSchedule plugin reads, that Longhorn runs in the namespace the
+// plugin looks in, and that the plugin args are valid.
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// LonghornManagerSelector selects Longhorn's longhorn-manager pods.
+const LonghornManagerSelector = "app=longhorn-manager"
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusFail Status = "FAIL"
+	StatusSkip Status = "SKIP"
+)
+
+// Result is the outcome of one check.
+type Result struct {
+	Check   string
+	Status  Status
+	Message string
+}
+
+// Config is what the checks are run against.
+type Config struct {
+	// LonghornNamespace defaults to longhorn_cosched.LonghornNamespace.
+	LonghornNamespace string
+	// Args are the plugin args of each scheduler profile setting them, by
+	// profile name. If nil, the args check is skipped.
+	Args map[string]runtime.Object
+}
+
+// access is a permission the scheduler needs, checked with a
+// SelfSubjectAccessReview per verb. An empty namespace is cluster-wide.
+type access struct {
+	group, resource string
+	namespace       string
+	verbs           []string
+}
+
+// requiredAccess returns the permissions the plugin cannot work without.
+// Permissions only some plugin args need are left out.
+func requiredAccess(longhornNamespace string) []access {
+	return []access{
+		{resource: "persistentvolumeclaims", verbs: []string{"get", "list", "watch"}},
+		{resource: "persistentvolumes", verbs: []string{"get", "list", "watch"}},
+		{group: "storage.k8s.io", resource: "storageclasses", verbs: []string{"get", "list", "watch"}},
+		{resource: "pods", namespace: longhornNamespace, verbs: []string{"get", "list", "watch"}},
+		{group: "longhorn.io", resource: "sharemanagers", namespace: longhornNamespace, verbs: []string{"get"}},
+	}
+}
+
+// Run runs every check, in a fixed order. Access is checked for the user the
+// clientset authenticates as, so run it as the scheduler's ServiceAccount,
+// e.g. by impersonating it.
+func Run(ctx context.Context, clientset kubernetes.Interface, config Config) []Result {
+	namespace := config.LonghornNamespace
+	if namespace == "" {
+		namespace = longhorn_cosched.LonghornNamespace
+	}
+	results := []Result{checkShareManagerCRD(clientset)}
+	for _, a := range requiredAccess(namespace) {
+		results = append(results, checkAccess(ctx, clientset, a))
+	}
+	results = append(results, checkLonghornManager(ctx, clientset, namespace))
+	return append(results, checkArgs(config.Args)...)
+}
+
+// Failed returns true if any check failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteReport writes one line per check, and a summary line.
+func WriteReport(w io.Writer, results []Result) error {
+	failed := 0
+	for _, r := range results {
+		if r.Status == StatusFail {
+			failed++
+		}
+		if _, err := fmt.Fprintf(w, "[%s] %s: %s\n", r.Status, r.Check, r.Message); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d checks, %d failed\n", len(results), failed)
+	return err
+}
+
+// checkShareManagerCRD checks that a ShareManager CRD version the plugin can
+// read is served.
+func checkShareManagerCRD(clientset kubernetes.Interface) Result {
+	r := Result{Check: "ShareManager CRD"}
+	version, err := longhorn_cosched.ServedShareManagerVersion(clientset.Discovery())
+	switch {
+	case err != nil:
+		r.Status, r.Message = StatusFail, fmt.Sprintf("discovery failed: %v", err)
+	case version == "":
+		r.Status, r.Message = StatusFail, "sharemanagers.longhorn.io is not served in any version the plugin reads; is Longhorn installed?"
+	default:
+		r.Status, r.Message = StatusPass, fmt.Sprintf("served as longhorn.io/%s", version)
+	}
+	return r
+}
+
+// checkAccess checks that every verb of a is allowed.
+func checkAccess(ctx context.Context, clientset kubernetes.Interface, a access) Result {
+	resource := a.resource
+	if a.group != "" {
+		resource += "." + a.group
+	}
+	r := Result{Check: "access to " + resource}
+	if a.namespace != "" {
+		r.Check += " in " + a.namespace
+	}
+
+	var denied []string
+	for _, verb := range a.verbs {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: a.namespace,
+					Verb:      verb,
+					Group:     a.group,
+					Resource:  a.resource,
+				},
+			},
+		}
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			r.Status, r.Message = StatusFail, fmt.Sprintf("SelfSubjectAccessReview for %s failed: %v", verb, err)
+			return r
+		}
+		if !review.Status.Allowed {
+			denied = append(denied, verb)
+		}
+	}
+	if len(denied) > 0 {
+		r.Status, r.Message = StatusFail, fmt.Sprintf("%s denied; add them to the scheduler's ClusterRole (manifests/rbac.yaml)", strings.Join(denied, ", "))
+		return r
+	}
+	r.Status, r.Message = StatusPass, strings.Join(a.verbs, ", ")+" allowed"
+	return r
+}
+
+// checkLonghornManager checks that a longhorn-manager pod is ready in the
+// Longhorn namespace, i.e. that Longhorn runs where the plugin looks for
+// share-managers.
+func checkLonghornManager(ctx context.Context, clientset kubernetes.Interface, namespace string) Result {
+	r := Result{Check: "longhorn-manager in " + namespace}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: LonghornManagerSelector})
+	if err != nil {
+		r.Status, r.Message = StatusFail, fmt.Sprintf("listing pods failed: %v", err)
+		return r
+	}
+	if len(pods.Items) == 0 {
+		r.Status, r.Message = StatusFail, fmt.Sprintf("no %s pods; is Longhorn installed in namespace %q?", LonghornManagerSelector, namespace)
+		return r
+	}
+	ready := 0
+	for _, pod := range pods.Items {
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	if ready == 0 {
+		r.Status, r.Message = StatusFail, fmt.Sprintf("none of %d pods is ready", len(pods.Items))
+		return r
+	}
+	r.Status, r.Message = StatusPass, fmt.Sprintf("%d of %d pods ready", ready, len(pods.Items))
+	return r
+}
+
+// checkArgs validates the plugin args of each profile.
+func checkArgs(args map[string]runtime.Object) []Result {
+	if args == nil {
+		return []Result{{Check: "plugin args", Status: StatusSkip, Message: "no scheduler configuration given"}}
+	}
+	if len(args) == 0 {
+		return []Result{{Check: "plugin args", Status: StatusPass, Message: "no profile sets " + longhorn_cosched.Name + " args; the defaults are used"}}
+	}
+	profiles := make([]string, 0, len(args))
+	for profile := range args {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	results := make([]Result, 0, len(profiles))
+	for _, profile := range profiles {
+		r := Result{Check: fmt.Sprintf("plugin args of profile %q", profile), Status: StatusPass, Message: "valid"}
+		if err := longhorn_cosched.ValidateArgs(args[profile]); err != nil {
+			r.Status, r.Message = StatusFail, err.Error()
+		}
+		results = append(results, r)
+	}
+	return results
+}
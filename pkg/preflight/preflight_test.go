@@ -0,0 +1,230 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// makeLonghornManager returns a longhorn-manager pod in the namespace.
+func makeLonghornManager(namespace string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "longhorn-manager-abcde", Namespace: namespace, Labels: map[string]string{"app": "longhorn-manager"}},
+		Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+	}
+}
+
+// cluster is a fake cluster the checks run against.
+type cluster struct {
+	// versions are the ShareManager CRD versions served.
+	versions []string
+	// discoveryErr fails discovery.
+	discoveryErr error
+	// denied are the "verb resource" pairs SelfSubjectAccessReviews deny.
+	denied []string
+	// ssarErr fails SelfSubjectAccessReviews.
+	ssarErr error
+	pods    []runtime.Object
+}
+
+func (c cluster) clientset() *fake.Clientset {
+	clientset := fake.NewSimpleClientset(c.pods...)
+	for _, version := range c.versions {
+		clientset.Resources = append(clientset.Resources, &metav1.APIResourceList{
+			GroupVersion: "longhorn.io/" + version,
+			APIResources: []metav1.APIResource{{Name: "sharemanagers", Namespaced: true, Kind: "ShareManager"}},
+		})
+	}
+	if c.discoveryErr != nil {
+		clientset.PrependReactor("get", "resource", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, c.discoveryErr
+		})
+	}
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if c.ssarErr != nil {
+			return true, nil, c.ssarErr
+		}
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = true
+		for _, denied := range c.denied {
+			if denied == attrs.Verb+" "+attrs.Resource {
+				review.Status.Allowed = false
+			}
+		}
+		return true, review, nil
+	})
+	return clientset
+}
+
+// healthy is a cluster passing every check.
+var healthy = cluster{
+	versions: []string{"v1beta2"},
+	pods:     []runtime.Object{makeLonghornManager(longhorn_cosched.LonghornNamespace, true)},
+}
+
+// argsOf returns plugin args as the scheduler decodes them from its
+// configuration file.
+func argsOf(raw string) runtime.Object {
+	return &runtime.Unknown{Raw: []byte(raw), ContentType: runtime.ContentTypeJSON}
+}
+
+// TestRun runs the checks against a healthy cluster and against one failure
+// mode at a time, and checks which checks fail.
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster cluster
+		config  Config
+		// wantFailed are the checks that must fail, by name prefix.
+		wantFailed []string
+		// wantMessage is part of the message of the first failed check.
+		wantMessage string
+	}{
+		{name: "healthy", cluster: healthy},
+		{
+			name:    "healthy with valid args",
+			cluster: healthy,
+			config:  Config{Args: map[string]runtime.Object{"kubevirt-scheduler": argsOf(`{"conflictPolicy":"first"}`), "defaults": nil}},
+		},
+		{
+			name:    "v1beta1 served",
+			cluster: cluster{versions: []string{"v1beta1"}, pods: healthy.pods},
+		},
+		{
+			name:        "CRD not served",
+			cluster:     cluster{pods: healthy.pods},
+			wantFailed:  []string{"ShareManager CRD"},
+			wantMessage: "is Longhorn installed?",
+		},
+		{
+			name:        "discovery fails",
+			cluster:     cluster{versions: []string{"v1beta2"}, discoveryErr: errors.New("connection refused"), pods: healthy.pods},
+			wantFailed:  []string{"ShareManager CRD"},
+			wantMessage: "connection refused",
+		},
+		{
+			name:        "sharemanagers denied",
+			cluster:     cluster{versions: []string{"v1beta2"}, denied: []string{"get sharemanagers"}, pods: healthy.pods},
+			wantFailed:  []string{"access to sharemanagers.longhorn.io in longhorn-system"},
+			wantMessage: "get denied",
+		},
+		{
+			name:        "PVC list and watch denied",
+			cluster:     cluster{versions: []string{"v1beta2"}, denied: []string{"list persistentvolumeclaims", "watch persistentvolumeclaims"}, pods: healthy.pods},
+			wantFailed:  []string{"access to persistentvolumeclaims"},
+			wantMessage: "list, watch denied",
+		},
+		{
+			name:        "Longhorn pods denied",
+			cluster:     cluster{versions: []string{"v1beta2"}, denied: []string{"list pods"}, pods: healthy.pods},
+			wantFailed:  []string{"access to pods in longhorn-system"},
+			wantMessage: "list denied",
+		},
+		{
+			name:    "access reviews fail",
+			cluster: cluster{versions: []string{"v1beta2"}, ssarErr: errors.New("forbidden"), pods: healthy.pods},
+			wantFailed: []string{
+				"access to persistentvolumeclaims",
+				"access to persistentvolumes",
+				"access to storageclasses.storage.k8s.io",
+				"access to pods in longhorn-system",
+				"access to sharemanagers.longhorn.io in longhorn-system",
+			},
+			wantMessage: "SelfSubjectAccessReview for get failed",
+		},
+		{
+			name:        "Longhorn in another namespace",
+			cluster:     cluster{versions: []string{"v1beta2"}, pods: []runtime.Object{makeLonghornManager("storage", true)}},
+			wantFailed:  []string{"longhorn-manager in longhorn-system"},
+			wantMessage: `is Longhorn installed in namespace "longhorn-system"?`,
+		},
+		{
+			name:    "renamed Longhorn namespace configured",
+			cluster: cluster{versions: []string{"v1beta2"}, pods: []runtime.Object{makeLonghornManager("storage", true)}},
+			config:  Config{LonghornNamespace: "storage"},
+		},
+		{
+			name:        "longhorn-manager not ready",
+			cluster:     cluster{versions: []string{"v1beta2"}, pods: []runtime.Object{makeLonghornManager(longhorn_cosched.LonghornNamespace, false)}},
+			wantFailed:  []string{"longhorn-manager in longhorn-system"},
+			wantMessage: "none of 1 pods is ready",
+		},
+		{
+			name:        "invalid arg value",
+			cluster:     healthy,
+			config:      Config{Args: map[string]runtime.Object{"kubevirt-scheduler": argsOf(`{"conflictPolicy":"bogus"}`)}},
+			wantFailed:  []string{`plugin args of profile "kubevirt-scheduler"`},
+			wantMessage: `unknown conflictPolicy "bogus"`,
+		},
+		{
+			name:        "misspelled arg",
+			cluster:     healthy,
+			config:      Config{Args: map[string]runtime.Object{"kubevirt-scheduler": argsOf(`{"relocateShareManagr":true}`)}},
+			wantFailed:  []string{`plugin args of profile "kubevirt-scheduler"`},
+			wantMessage: `unknown field "relocateShareManagr"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := Run(context.Background(), tt.cluster.clientset(), tt.config)
+
+			var failed []Result
+			for _, r := range results {
+				if r.Status == StatusFail {
+					failed = append(failed, r)
+				}
+			}
+			if len(failed) != len(tt.wantFailed) {
+				t.Fatalf("failed checks = %+v, want %v", failed, tt.wantFailed)
+			}
+			for i, want := range tt.wantFailed {
+				if !strings.HasPrefix(failed[i].Check, want) {
+					t.Errorf("failed check %d = %q, want %q", i, failed[i].Check, want)
+				}
+			}
+			if len(failed) > 0 && !strings.Contains(failed[0].Message, tt.wantMessage) {
+				t.Errorf("message = %q, want it to contain %q", failed[0].Message, tt.wantMessage)
+			}
+			if Failed(results) != (len(tt.wantFailed) > 0) {
+				t.Errorf("Failed() = %v, want %v", Failed(results), len(tt.wantFailed) > 0)
+			}
+		})
+	}
+}
+
+// TestWriteReport checks the report of a run without scheduler
+// configuration.
+func TestWriteReport(t *testing.T) {
+	results := Run(context.Background(), healthy.clientset(), Config{})
+	var out bytes.Buffer
+	if err := WriteReport(&out, results); err != nil {
+		t.Fatalf("WriteReport() error = %v", err)
+	}
+	for _, want := range []string{
+		"[PASS] ShareManager CRD: served as longhorn.io/v1beta2",
+		"[PASS] access to sharemanagers.longhorn.io in longhorn-system: get allowed",
+		"[PASS] longhorn-manager in longhorn-system: 1 of 1 pods ready",
+		"[SKIP] plugin args: no scheduler configuration given",
+		"8 checks, 0 failed",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
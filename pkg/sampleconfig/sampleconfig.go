@@ -0,0 +1,190 @@
+// Package sampleconfig writes KubeSchedulerConfigurations for the
+// kubevirt-scheduler, and checks them with the scheduler's own decoder
+// before handing them out.
+package sampleconfig
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config/scheme"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// DefaultSchedulerName is the scheduler name of the bundled profile.
+const DefaultSchedulerName = "kubevirt-scheduler"
+
+// Mode selects the profiles of a configuration.
+type Mode string
+
+const (
+	// ModeHard is a single profile with the default plugin args: pods are
+	// co-scheduled in the mode their annotations ask for.
+	ModeHard Mode = "hard"
+	// ModeSoft is a single profile co-scheduling every opted-in pod whose
+	// priority is not negative in soft mode, so storage placement never
+	// keeps one pending.
+	ModeSoft Mode = "soft"
+	// ModeMultiProfile is a hard profile and a soft one, named after the
+	// scheduler name with "-soft" appended, for pods to choose from with
+	// their schedulerName.
+	ModeMultiProfile Mode = "multi-profile"
+)
+
+// Modes are the modes, in the order they are documented.
+var Modes = []Mode{ModeHard, ModeSoft, ModeMultiProfile}
+
+// Config is what Generate writes.
+type Config struct {
+	Mode Mode
+	// SchedulerName defaults to DefaultSchedulerName. It also names the
+	// leader election lease.
+	SchedulerName string
+	// Args are plugin args, as a YAML or JSON object, merged over the args
+	// of the mode in every profile: a field set here replaces the mode's.
+	Args []byte
+}
+
+// profile is a profile of the template.
+type profile struct {
+	Name string
+	Args map[string]interface{}
+}
+
+// softArgs are the args of a soft profile: a threshold of 0, the priority of
+// pods without a PriorityClass.
+func softArgs() map[string]interface{} {
+	return map[string]interface{}{"softModePriorityThreshold": 0}
+}
+
+var configTemplate = template.Must(template.New("config").Funcs(template.FuncMap{"args": renderArgs}).Parse(
+	`# KubeSchedulerConfiguration for the kubevirt-scheduler ({{.Mode}}), as written
+# by "kubevirt-scheduler print-config". The LonghornCoSchedule plugin is enabled
+# through multiPoint alongside all default plugins; PostFilter lists it before
+# DefaultPreemption so hard-mode VMs preempt on their share-manager node.
+apiVersion: kubescheduler.config.k8s.io/v1
+kind: KubeSchedulerConfiguration
+leaderElection:
+  leaderElect: true
+  resourceName: {{.SchedulerName}}
+  resourceNamespace: kube-system
+profiles:
+{{- range .Profiles}}
+  - schedulerName: {{.Name}}
+    plugins:
+      multiPoint:
+        enabled:
+          - name: LonghornCoSchedule
+      postFilter:
+        disabled:
+          - name: "*"
+        enabled:
+          - name: LonghornCoSchedule
+          - name: DefaultPreemption
+    pluginConfig:
+      - name: LonghornCoSchedule
+        args:{{args .Args}}
+{{- end}}
+`))
+
+// renderArgs renders plugin args as the value of the args key of the
+// template.
+func renderArgs(args map[string]interface{}) (string, error) {
+	if len(args) == 0 {
+		return " {}", nil
+	}
+	data, err := yaml.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		b.WriteString("\n          " + line)
+	}
+	return b.String(), nil
+}
+
+// Generate returns the configuration as YAML. It fails if the scheduler
+// would not accept it, e.g. because the args have an unknown field.
+func Generate(c Config) ([]byte, error) {
+	name := c.SchedulerName
+	if name == "" {
+		name = DefaultSchedulerName
+	}
+	var userArgs map[string]interface{}
+	if len(bytes.TrimSpace(c.Args)) > 0 {
+		if err := yaml.Unmarshal(c.Args, &userArgs); err != nil {
+			return nil, fmt.Errorf("parsing args: %w", err)
+		}
+	}
+	merge := func(args map[string]interface{}) map[string]interface{} {
+		for key, value := range userArgs {
+			args[key] = value
+		}
+		return args
+	}
+
+	var profiles []profile
+	switch c.Mode {
+	case ModeHard:
+		profiles = []profile{{Name: name, Args: merge(map[string]interface{}{})}}
+	case ModeSoft:
+		profiles = []profile{{Name: name, Args: merge(softArgs())}}
+	case ModeMultiProfile:
+		profiles = []profile{
+			{Name: name, Args: merge(map[string]interface{}{})},
+			{Name: name + "-soft", Args: merge(softArgs())},
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %q, want one of %v", c.Mode, Modes)
+	}
+
+	var out bytes.Buffer
+	err := configTemplate.Execute(&out, struct {
+		Mode          Mode
+		SchedulerName string
+		Profiles      []profile
+	}{c.Mode, name, profiles})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Decode(out.Bytes()); err != nil {
+		return nil, fmt.Errorf("generated configuration does not decode: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// Decode decodes a KubeSchedulerConfiguration as the scheduler does,
+// defaults included, and validates it and the LonghornCoSchedule args of
+// each profile, strictly: unknown fields, which the scheduler would ignore,
+// are errors.
+func Decode(data []byte) (*config.KubeSchedulerConfiguration, error) {
+	obj, gvk, err := scheme.Codecs.UniversalDecoder().Decode(data, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	cfg, ok := obj.(*config.KubeSchedulerConfiguration)
+	if !ok {
+		return nil, fmt.Errorf("decoded %s, not a KubeSchedulerConfiguration", gvk)
+	}
+	if err := validation.ValidateKubeSchedulerConfiguration(cfg); err != nil {
+		return nil, err
+	}
+	for _, p := range cfg.Profiles {
+		for _, pc := range p.PluginConfig {
+			if pc.Name != longhorn_cosched.Name {
+				continue
+			}
+			if err := longhorn_cosched.ValidateArgs(pc.Args); err != nil {
+				return nil, fmt.Errorf("profile %q: %w", p.SchedulerName, err)
+			}
+		}
+	}
+	return cfg, nil
+}
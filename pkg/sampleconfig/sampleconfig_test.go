@@ -0,0 +1,160 @@
+package sampleconfig
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/backend/cache"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	schedmetrics "k8s.io/kubernetes/pkg/scheduler/metrics"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// extensionPoints returns the extension points the plugin runs at in the
+// profile, by building the profile's framework as the scheduler does.
+func extensionPoints(t *testing.T, profile *config.KubeSchedulerProfile) map[string]int {
+	t.Helper()
+	schedmetrics.Register()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	clientset := fake.NewSimpleClientset()
+	registry := plugins.NewInTreeRegistry()
+	if err := registry.Register(longhorn_cosched.Name, longhorn_cosched.NewWithClients(clientset, dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))); err != nil {
+		t.Fatal(err)
+	}
+	fwk, err := frameworkruntime.NewFramework(ctx, registry, profile,
+		frameworkruntime.WithClientSet(clientset),
+		frameworkruntime.WithInformerFactory(informers.NewSharedInformerFactory(clientset, 0)),
+		frameworkruntime.WithSnapshotSharedLister(cache.NewEmptySnapshot()),
+		frameworkruntime.WithWaitingPods(frameworkruntime.NewWaitingPodsMap()),
+	)
+	if err != nil {
+		t.Fatalf("building the framework of profile %q: %v", profile.SchedulerName, err)
+	}
+	// points maps each extension point the plugin runs at to its position.
+	listed := fwk.ListPlugins()
+	points := map[string]int{}
+	for point, set := range map[string]config.PluginSet{
+		"PreEnqueue": listed.PreEnqueue,
+		"PreFilter":  listed.PreFilter,
+		"Filter":     listed.Filter,
+		"PostFilter": listed.PostFilter,
+		"Score":      listed.Score,
+		"PostBind":   listed.PostBind,
+	} {
+		for i, p := range set.Enabled {
+			if p.Name == longhorn_cosched.Name {
+				points[point] = i
+			}
+		}
+	}
+	return points
+}
+
+// TestGenerate decodes the configuration of each mode with the scheduler's
+// decoder and checks its profiles, their args and where the plugin runs.
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		mode Mode
+		// wantProfiles are the scheduler names of the profiles.
+		wantProfiles []string
+		// wantSoft are the profiles co-scheduling in soft mode.
+		wantSoft []bool
+	}{
+		{mode: ModeHard, wantProfiles: []string{"kubevirt-scheduler"}, wantSoft: []bool{false}},
+		{mode: ModeSoft, wantProfiles: []string{"kubevirt-scheduler"}, wantSoft: []bool{true}},
+		{mode: ModeMultiProfile, wantProfiles: []string{"kubevirt-scheduler", "kubevirt-scheduler-soft"}, wantSoft: []bool{false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			data, err := Generate(Config{Mode: tt.mode})
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+			cfg, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode() error = %v\n%s", err, data)
+			}
+			if !cfg.LeaderElection.LeaderElect || cfg.LeaderElection.ResourceName != "kubevirt-scheduler" {
+				t.Errorf("leader election = %+v, want the kubevirt-scheduler lease", cfg.LeaderElection)
+			}
+			if len(cfg.Profiles) != len(tt.wantProfiles) {
+				t.Fatalf("profiles = %d, want %v", len(cfg.Profiles), tt.wantProfiles)
+			}
+			for i := range cfg.Profiles {
+				profile := &cfg.Profiles[i]
+				if profile.SchedulerName != tt.wantProfiles[i] {
+					t.Errorf("profile %d = %q, want %q", i, profile.SchedulerName, tt.wantProfiles[i])
+				}
+				var args longhorn_cosched.Args
+				for _, pc := range profile.PluginConfig {
+					if pc.Name == longhorn_cosched.Name {
+						if err := frameworkruntime.DecodeInto(pc.Args, &args); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				if soft := args.SoftModePriorityThreshold != nil && *args.SoftModePriorityThreshold == 0; soft != tt.wantSoft[i] {
+					t.Errorf("profile %q soft = %v, want %v", profile.SchedulerName, soft, tt.wantSoft[i])
+				}
+
+				points := extensionPoints(t, profile)
+				for _, point := range []string{"PreEnqueue", "PreFilter", "Filter", "PostFilter", "Score", "PostBind"} {
+					if _, ok := points[point]; !ok {
+						t.Errorf("profile %q: plugin not at %s, only at %v", profile.SchedulerName, point, points)
+					}
+				}
+				if points["PostFilter"] != 0 {
+					t.Errorf("profile %q: plugin is PostFilter plugin %d, want it first, before DefaultPreemption", profile.SchedulerName, points["PostFilter"])
+				}
+			}
+		})
+	}
+}
+
+// TestGenerateArgs checks that user args are merged over the mode's, and
+// that args the scheduler would not accept fail the self-test.
+func TestGenerateArgs(t *testing.T) {
+	data, err := Generate(Config{Mode: ModeMultiProfile, SchedulerName: "vms", Args: []byte(`{"conflictPolicy": "first", "softModePriorityThreshold": 1000}`)})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	cfg, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if cfg.Profiles[0].SchedulerName != "vms" || cfg.Profiles[1].SchedulerName != "vms-soft" || cfg.LeaderElection.ResourceName != "vms" {
+		t.Errorf("profiles %q, %q and lease %q, want them named after the scheduler name", cfg.Profiles[0].SchedulerName, cfg.Profiles[1].SchedulerName, cfg.LeaderElection.ResourceName)
+	}
+	for _, profile := range cfg.Profiles {
+		var args longhorn_cosched.Args
+		if err := frameworkruntime.DecodeInto(profile.PluginConfig[0].Args, &args); err != nil {
+			t.Fatal(err)
+		}
+		if args.ConflictPolicy != "first" || args.SoftModePriorityThreshold == nil || *args.SoftModePriorityThreshold != 1000 {
+			t.Errorf("profile %q args = %+v, want the given ones", profile.SchedulerName, args)
+		}
+	}
+
+	for _, tt := range []struct {
+		config  Config
+		wantErr string
+	}{
+		{config: Config{Mode: ModeHard, Args: []byte("relocateShareManagr: true")}, wantErr: `unknown field "relocateShareManagr"`},
+		{config: Config{Mode: ModeSoft, Args: []byte("conflictPolicy: bogus")}, wantErr: `unknown conflictPolicy "bogus"`},
+		{config: Config{Mode: ModeHard, Args: []byte("- not an object")}, wantErr: "parsing args"},
+		{config: Config{Mode: "strict"}, wantErr: `unknown mode "strict"`},
+	} {
+		if _, err := Generate(tt.config); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Generate(%+v) error = %v, want it to contain %q", tt.config, err, tt.wantErr)
+		}
+	}
+}
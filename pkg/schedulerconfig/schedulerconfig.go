@@ -0,0 +1,30 @@
+// Package schedulerconfig reads the LonghornCoSchedule args out of a
+// KubeSchedulerConfiguration file, for the commands that decide like the
+// plugin outside the scheduler.
+package schedulerconfig
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app/options"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// PluginArgs returns the LonghornCoSchedule args of each profile of the
+// KubeSchedulerConfiguration file that sets them, by scheduler name.
+func PluginArgs(configFile string) (map[string]runtime.Object, error) {
+	schedulerConfig, err := options.LoadConfigFromFile(klog.Background(), configFile)
+	if err != nil {
+		return nil, err
+	}
+	args := map[string]runtime.Object{}
+	for _, profile := range schedulerConfig.Profiles {
+		for _, pc := range profile.PluginConfig {
+			if pc.Name == longhorn_cosched.Name {
+				args[profile.SchedulerName] = pc.Args
+			}
+		}
+	}
+	return args, nil
+}
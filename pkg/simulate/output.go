@@ -0,0 +1,95 @@
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// WriteTable writes the result: the pod's decision, the share-managers found,
+// a table of the nodes with their verdicts and scores, and the events.
+func WriteTable(w io.Writer, result *Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Pod:\t%s/%s\n", result.Pod.Namespace, result.Pod.Name)
+	if result.HasDecision {
+		d := result.Decision
+		mode := string(d.Mode)
+		if mode == "" {
+			mode = "<not co-scheduled>"
+		}
+		fmt.Fprintf(tw, "Mode:\t%s\n", mode)
+		fmt.Fprintf(tw, "Outcome:\t%s\n", d.Outcome)
+		if d.ShareManagerNode != "" {
+			fmt.Fprintf(tw, "Share-manager node:\t%s\n", d.ShareManagerNode)
+		}
+		if d.PinnedBy != "" {
+			fmt.Fprintf(tw, "Because:\t%s\n", d.PinnedBy)
+		}
+		if d.Fallback != "" {
+			fmt.Fprintf(tw, "Fallback:\t%s\n", d.Fallback)
+		}
+	}
+	if result.PreFilter != "" {
+		fmt.Fprintf(tw, "PreFilter:\t%s\n", result.PreFilter)
+	}
+	if len(result.Decision.Placements) > 0 {
+		fmt.Fprintln(tw, "\nPVC\tPV\tSHARE-MANAGER NODE\tSOURCE\tPINS")
+		for _, pl := range result.Decision.Placements {
+			source := string(pl.Source)
+			if source == "" {
+				source = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\n", orDash(pl.PVC), orDash(pl.PV), pl.Node, source, pl.Pins)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nNODE\tFILTER\tSCORE\tREASON")
+	for _, n := range result.Nodes {
+		score := "-"
+		if n.Verdict == VerdictPass {
+			score = strconv.FormatInt(n.Score, 10)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", n.Node, n.Verdict, score, orDash(n.Reason))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(result.Events) > 0 {
+		if _, err := fmt.Fprintln(w, "\nEvents:"); err != nil {
+			return err
+		}
+		for _, event := range result.Events {
+			if _, err := fmt.Fprintf(w, "  %s\n", event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the results as indented JSON, a list of objects with the
+// pod's namespace and name, the decision, the nodes and the events.
+func WriteJSON(w io.Writer, results []*Result) error {
+	type podResult struct {
+		Namespace string `json:"namespace"`
+		Pod       string `json:"pod"`
+		*Result
+	}
+	out := make([]podResult, 0, len(results))
+	for _, result := range results {
+		out = append(out, podResult{Namespace: result.Pod.Namespace, Pod: result.Pod.Name, Result: result})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
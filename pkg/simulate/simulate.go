@@ -0,0 +1,198 @@
+// Package simulate runs a scheduling cycle of the LonghornCoSchedule plugin
+// for a pod against a cluster's current state, without binding it, to show
+// per node what the plugin's Filter and Score make of it and why.
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/backend/cache"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// SchedulerName is the name of the profile the simulation runs, as reported
+// in the events it collects.
+const SchedulerName = "kubevirt-scheduler-simulate"
+
+// maxEvents bounds the events collected from a simulated cycle.
+const maxEvents = 100
+
+// Verdict is what Filter made of a node.
+type Verdict string
+
+const (
+	VerdictPass         Verdict = "pass"
+	VerdictFail         Verdict = "fail"
+	VerdictUnresolvable Verdict = "unresolvable"
+)
+
+// NodeResult is the plugin's verdict and score for one node.
+type NodeResult struct {
+	Node    string  `json:"node"`
+	Verdict Verdict `json:"verdict"`
+	// Reason is the Filter status message of a node that did not pass.
+	Reason string `json:"reason,omitempty"`
+	// Score is the plugin's normalized score, 0-framework.MaxNodeScore, of a
+	// node that passed.
+	Score int64 `json:"score"`
+}
+
+// Result is a simulated scheduling cycle of a pod.
+type Result struct {
+	Pod *corev1.Pod `json:"-"`
+	// PreFilter is the PreFilter status message if it did not succeed; no
+	// node then passes.
+	PreFilter string `json:"preFilter,omitempty"`
+	// Decision is the decision PreFilter stored, if it ran.
+	Decision    longhorn_cosched.Decision `json:"decision"`
+	HasDecision bool                      `json:"-"`
+	// Nodes are sorted by name.
+	Nodes []NodeResult `json:"nodes"`
+	// Events are the events the plugin would have emitted, as
+	// "<type> <reason> <message>".
+	Events []string `json:"events,omitempty"`
+}
+
+// Run runs PreFilter, Filter on every node and Score on the nodes that pass
+// for the pod, with the plugin args, against the nodes and the assigned pods
+// of the cluster. Events are collected instead of emitted. The pod is
+// simulated as if it were not assigned: an existing pod is left out of the
+// snapshot and its node name is ignored.
+func Run(ctx context.Context, clientset kubernetes.Interface, dynClient dynamic.Interface, pod *corev1.Pod, args runtime.Object) (*Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pod = pod.DeepCopy()
+	pod.Spec.NodeName = ""
+
+	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing nodes: %w", err)
+	}
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+	nodes := make([]*corev1.Node, 0, len(nodeList.Items))
+	for i := range nodeList.Items {
+		nodes = append(nodes, &nodeList.Items[i])
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	var assigned []*corev1.Pod
+	for i := range podList.Items {
+		p := &podList.Items[i]
+		if p.Spec.NodeName == "" || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if p.Namespace == pod.Namespace && p.Name == pod.Name {
+			continue
+		}
+		assigned = append(assigned, p)
+	}
+	snapshot := cache.NewSnapshot(assigned, nodes)
+
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	recorder := events.NewFakeRecorder(maxEvents)
+	fwk, err := longhorn_cosched.NewFramework(ctx, SchedulerName, clientset, dynClient, factory, snapshot, recorder, args)
+	if err != nil {
+		return nil, err
+	}
+	factory.Start(ctx.Done())
+	for informer, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("syncing the %v cache failed", informer)
+		}
+	}
+
+	result := &Result{Pod: pod}
+	state := framework.NewCycleState()
+	preRes, status, _ := fwk.RunPreFilterPlugins(ctx, state, pod)
+	if status.Code() == framework.Error {
+		return nil, fmt.Errorf("running PreFilter: %w", status.AsError())
+	}
+	result.Decision, result.HasDecision = longhorn_cosched.CycleDecision(state)
+
+	var feasible []*framework.NodeInfo
+	for _, node := range nodes {
+		nodeResult := NodeResult{Node: node.Name}
+		nodeStatus := status
+		if nodeStatus.IsSuccess() {
+			if preRes != nil && !preRes.AllNodes() && !preRes.NodeNames.Has(node.Name) {
+				nodeStatus = framework.NewStatus(framework.UnschedulableAndUnresolvable, "node excluded by PreFilter")
+			} else {
+				nodeInfo, err := snapshot.NodeInfos().Get(node.Name)
+				if err != nil {
+					return nil, err
+				}
+				if nodeStatus = fwk.RunFilterPlugins(ctx, state, pod, nodeInfo); nodeStatus.IsSuccess() {
+					feasible = append(feasible, nodeInfo)
+				}
+			}
+		}
+		switch nodeStatus.Code() {
+		case framework.Success:
+			nodeResult.Verdict = VerdictPass
+		case framework.UnschedulableAndUnresolvable:
+			nodeResult.Verdict, nodeResult.Reason = VerdictUnresolvable, nodeStatus.Message()
+		case framework.Unschedulable:
+			nodeResult.Verdict, nodeResult.Reason = VerdictFail, nodeStatus.Message()
+		default:
+			return nil, fmt.Errorf("running Filter on node %s: %w", node.Name, nodeStatus.AsError())
+		}
+		result.Nodes = append(result.Nodes, nodeResult)
+	}
+	if !status.IsSuccess() {
+		result.PreFilter = status.Message()
+	}
+
+	if len(feasible) > 0 {
+		scores, status := fwk.RunScorePlugins(ctx, state, pod, feasible)
+		if !status.IsSuccess() {
+			return nil, fmt.Errorf("running Score: %w", status.AsError())
+		}
+		byNode := make(map[string]int64, len(scores))
+		for _, score := range scores {
+			byNode[score.Name] = score.TotalScore
+		}
+		for i := range result.Nodes {
+			result.Nodes[i].Score = byNode[result.Nodes[i].Node]
+		}
+	}
+
+	for {
+		select {
+		case event := <-recorder.Events:
+			result.Events = append(result.Events, event)
+		default:
+			return result, nil
+		}
+	}
+}
+
+// ReadOnly wraps a client transport to refuse requests that could change the
+// cluster, so that nothing but reads reaches it from a simulation's clients.
+func ReadOnly(rt http.RoundTripper) http.RoundTripper {
+	return readOnlyTransport{rt}
+}
+
+type readOnlyTransport struct {
+	http.RoundTripper
+}
+
+func (t readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("simulation is read-only, refusing %s %s", req.Method, req.URL.Path)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,167 @@
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	testNamespace = "vms"
+	testPVName    = "pvc-54191094-16e2-41ae-8f06-3abf5d1fbae1"
+)
+
+// makeVM returns a virt-launcher pod with the co-schedule annotation set to
+// mode unless empty, mounting the RWX PVC "shared".
+func makeVM(mode string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "virt-launcher-vm", Namespace: testNamespace, UID: "vm-uid", Annotations: map[string]string{}},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         "disk",
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "shared"}},
+		}}},
+	}
+	if mode != "" {
+		pod.Annotations[longhorn_cosched.AnnotationKey] = mode
+	}
+	return pod
+}
+
+// newTestClients returns fake clients holding three nodes, the PVC "shared"
+// and its share-manager on node-2, running, and the objects.
+func newTestClients(objects ...runtime.Object) (*fake.Clientset, *dynamicfake.FakeDynamicClient) {
+	for _, name := range []string{"node-1", "node-2", "node-3"} {
+		objects = append(objects, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	objects = append(objects, &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: testNamespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			VolumeName:  testPVName,
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	})
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "longhorn.io/v1beta2",
+		APIResources: []metav1.APIResource{{Name: "sharemanagers", Namespaced: true, Kind: "ShareManager"}},
+	}}
+	shareManager := &unstructured.Unstructured{}
+	shareManager.SetAPIVersion("longhorn.io/v1beta2")
+	shareManager.SetKind("ShareManager")
+	shareManager.SetNamespace(longhorn_cosched.LonghornNamespace)
+	shareManager.SetName(testPVName)
+	shareManager.Object["status"] = map[string]interface{}{"ownerID": "node-2", "state": "running"}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "sharemanagers"}: "ShareManagerList",
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "replicas"}:      "ReplicaList",
+		{Group: "longhorn.io", Version: "v1beta2", Resource: "volumes"}:       "VolumeList",
+	}, shareManager)
+	return clientset, dynClient
+}
+
+// TestRun simulates pods in each mode and checks the rendered output.
+func TestRun(t *testing.T) {
+	bound := makeVM("true")
+	bound.Spec.NodeName = "node-1"
+	bound.Status.Phase = corev1.PodRunning
+
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		objects []runtime.Object
+		// want are lines, or parts of lines, of the output, with runs of
+		// spaces collapsed to one.
+		want []string
+	}{
+		{
+			name: "hard mode",
+			pod:  makeVM("true"),
+			want: []string{
+				"Mode: hard",
+				"Outcome: pinned",
+				"Share-manager node: node-2",
+				"Because: Longhorn share-manager for PVC vms/shared (pv " + testPVName + ") is running (source: shareManager)",
+				"shared " + testPVName + " node-2 shareManager true",
+				`node-1 fail - node "node-1" rejected: VM must run on "node-2"`,
+				"node-2 pass 100 -",
+				`node-3 fail - node "node-3" rejected`,
+				"Normal CoScheduledWithShareManager",
+			},
+		},
+		{
+			name: "soft mode",
+			pod:  makeVM("soft"),
+			want: []string{
+				"Outcome: preferred",
+				"node-1 pass 0 -",
+				"node-2 pass 100 -",
+				"node-3 pass 0 -",
+			},
+		},
+		{
+			name: "not opted in",
+			pod:  makeVM(""),
+			want: []string{
+				"Mode: <not co-scheduled>",
+				"node-1 pass 0 -",
+				"node-2 pass 0 -",
+			},
+		},
+		{
+			name:    "existing pod bound elsewhere",
+			pod:     bound,
+			objects: []runtime.Object{bound},
+			want: []string{
+				"Outcome: pinned",
+				`node-1 fail - node "node-1" rejected: VM must run on "node-2"`,
+				"node-2 pass 100 -",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, dynClient := newTestClients(tt.objects...)
+			result, err := Run(context.Background(), clientset, dynClient, tt.pod, nil)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			var out bytes.Buffer
+			if err := WriteTable(&out, result); err != nil {
+				t.Fatalf("WriteTable() error = %v", err)
+			}
+			rendered := strings.Join(strings.Fields(strings.ReplaceAll(out.String(), "\n", " \n ")), " ")
+			for _, want := range tt.want {
+				if !strings.Contains(rendered, want) {
+					t.Errorf("output does not contain %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+// TestRunReadOnly checks that a simulation writes nothing to the cluster.
+func TestRunReadOnly(t *testing.T) {
+	clientset, dynClient := newTestClients()
+	if _, err := Run(context.Background(), clientset, dynClient, makeVM("true"), nil); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for _, action := range append(clientset.Actions(), dynClient.Actions()...) {
+		switch action.GetVerb() {
+		case "get", "list", "watch":
+		default:
+			t.Errorf("simulation sent %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// preferredAffinityWeight is the weight of each preferred node affinity term
+// injected, the highest the API accepts.
+const preferredAffinityWeight = 100
+
+// affinityPatch returns the patch restricting the pod, created in namespace,
+// to the node of its share-managers through node affinity, or nil if the pod
+// is left alone: it is exempt, its co-schedule annotation opts out or asks
+// for observe mode, it is already assigned a node, or none of its Longhorn
+// RWX PVCs has a share-manager.
+//
+// Required affinity is only injected for share-managers that would pin the
+// pod in the scheduler's hard mode, and only if they all run on one node;
+// otherwise the nodes are preferred, or the pod is left alone when they are
+// on different nodes. A co-schedule annotation of "soft" selects preferred
+// affinity and one of hard mode required affinity, whatever the Mode.
+func (i *Injector) affinityPatch(ctx context.Context, namespace string, pod *corev1.Pod) ([]patchOperation, string, error) {
+	if value, ok := pod.Annotations[longhorn_cosched.ExemptAnnotationKey]; ok {
+		return nil, fmt.Sprintf("has %s=%q", longhorn_cosched.ExemptAnnotationKey, value), nil
+	}
+	required := i.config.Mode == ModeRequiredAffinity
+	if value, ok := pod.Annotations[longhorn_cosched.AnnotationKey]; ok {
+		switch mode, _ := longhorn_cosched.ParseMode(value); mode {
+		case longhorn_cosched.ModeHard:
+			required = true
+		case longhorn_cosched.ModeSoft:
+			required = false
+		default:
+			return nil, fmt.Sprintf("has %s=%q", longhorn_cosched.AnnotationKey, value), nil
+		}
+	}
+	if pod.Spec.NodeName != "" {
+		return nil, "already assigned to node " + pod.Spec.NodeName, nil
+	}
+
+	// The pod's namespace is not set yet when its name is generated.
+	if pod.Namespace == "" {
+		pod.Namespace = namespace
+	}
+	placements, err := longhorn_cosched.FindShareManagerPlacements(ctx, i.clientset, i.dynClient, pod, i.config.Lookup)
+	if err != nil {
+		return nil, "", err
+	}
+	var pinning, preferred []string
+	for _, pl := range placements {
+		if pl.Pins && !slices.Contains(pinning, pl.Node) {
+			pinning = append(pinning, pl.Node)
+		}
+		if !slices.Contains(preferred, pl.Node) {
+			preferred = append(preferred, pl.Node)
+		}
+	}
+	if len(preferred) == 0 {
+		return nil, "no share-manager found", nil
+	}
+
+	if required && len(pinning) > 0 {
+		if len(pinning) > 1 {
+			return nil, fmt.Sprintf("share-managers on different nodes %v", pinning), nil
+		}
+		affinity := withRequiredNode(pod.Spec.Affinity, pinning[0])
+		return []patchOperation{{Op: "add", Path: "/spec/affinity", Value: affinity}}, "requires share-manager node " + pinning[0], nil
+	}
+	affinity := withPreferredNodes(pod.Spec.Affinity, preferred)
+	return []patchOperation{{Op: "add", Path: "/spec/affinity", Value: affinity}}, fmt.Sprintf("prefers share-manager nodes %v", preferred), nil
+}
+
+// nodeNameTerm returns a node selector term matching the node by name, the
+// way DaemonSets pin their pods.
+func nodeNameTerm(node string) corev1.NodeSelectorTerm {
+	return corev1.NodeSelectorTerm{
+		MatchFields: []corev1.NodeSelectorRequirement{{
+			Key:      metav1.ObjectNameField,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{node},
+		}},
+	}
+}
+
+// withRequiredNode returns a copy of affinity that also requires the node.
+// Node selector terms are ORed, so the requirement is added to each existing
+// term rather than as a term of its own.
+func withRequiredNode(affinity *corev1.Affinity, node string) *corev1.Affinity {
+	affinity = nodeAffinityCopy(affinity)
+	na := affinity.NodeAffinity
+	if na.RequiredDuringSchedulingIgnoredDuringExecution == nil || len(na.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
+		na.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{nodeNameTerm(node)},
+		}
+		return affinity
+	}
+	requirement := nodeNameTerm(node).MatchFields[0]
+	terms := na.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for t := range terms {
+		terms[t].MatchFields = append(terms[t].MatchFields, requirement)
+	}
+	return affinity
+}
+
+// withPreferredNodes returns a copy of affinity that also prefers each of
+// the nodes.
+func withPreferredNodes(affinity *corev1.Affinity, nodes []string) *corev1.Affinity {
+	affinity = nodeAffinityCopy(affinity)
+	na := affinity.NodeAffinity
+	for _, node := range nodes {
+		na.PreferredDuringSchedulingIgnoredDuringExecution = append(na.PreferredDuringSchedulingIgnoredDuringExecution,
+			corev1.PreferredSchedulingTerm{Weight: preferredAffinityWeight, Preference: nodeNameTerm(node)})
+	}
+	return affinity
+}
+
+// nodeAffinityCopy returns a deep copy of affinity, or a new one, with a
+// non-nil NodeAffinity.
+func nodeAffinityCopy(affinity *corev1.Affinity) *corev1.Affinity {
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	} else {
+		affinity = affinity.DeepCopy()
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	return affinity
+}
@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// makeShareManagerPod creates a Running share-manager pod for the PV on the
+// node.
+func makeShareManagerPod(pvName, node string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      longhorn_cosched.ShareManagerPrefix + pvName,
+			Namespace: longhorn_cosched.LonghornNamespace,
+			Labels: map[string]string{
+				longhorn_cosched.ShareManagerLabel:          pvName,
+				longhorn_cosched.ShareManagerComponentLabel: longhorn_cosched.ShareManagerComponentValue,
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func requiredAffinity(node string) *corev1.Affinity {
+	return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{nodeNameTerm(node)},
+		},
+	}}
+}
+
+func preferredAffinity(nodes ...string) *corev1.Affinity {
+	affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}
+	for _, node := range nodes {
+		affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+			corev1.PreferredSchedulingTerm{Weight: preferredAffinityWeight, Preference: nodeNameTerm(node)})
+	}
+	return affinity
+}
+
+// TestAffinityModes checks the node affinity injected in the affinity modes,
+// and that pods are left unchanged when no share-manager pins them to one
+// node.
+func TestAffinityModes(t *testing.T) {
+	longhornPVC := func(name, pvName string) []runtime.Object {
+		return []runtime.Object{makePVC(name, corev1.ReadWriteMany, pvName, "longhorn"), makePV(pvName, longhorn_cosched.LonghornDriver)}
+	}
+	shared := longhornPVC("shared", "pvc-shared")
+	root := longhornPVC("my-vm-root", "pvc-root")
+
+	tests := []struct {
+		name         string
+		fixture      string
+		objects      []runtime.Object
+		config       Config
+		wantAffinity *corev1.Affinity
+	}{
+		{
+			name:         "required",
+			fixture:      "launcher.json",
+			objects:      append(shared, makeShareManagerPod("pvc-shared", "node-1", true)),
+			config:       Config{Mode: ModeRequiredAffinity},
+			wantAffinity: requiredAffinity("node-1"),
+		},
+		{
+			name:         "preferred",
+			fixture:      "launcher.json",
+			objects:      append(shared, makeShareManagerPod("pvc-shared", "node-1", true)),
+			config:       Config{Mode: ModePreferredAffinity},
+			wantAffinity: preferredAffinity("node-1"),
+		},
+		{
+			name:         "soft annotation selects preferred",
+			fixture:      "launcher-annotated.json",
+			objects:      append(shared, makeShareManagerPod("pvc-shared", "node-1", true)),
+			config:       Config{Mode: ModeRequiredAffinity},
+			wantAffinity: preferredAffinity("node-1"),
+		},
+		{
+			name:         "share-manager not Ready is only preferred",
+			fixture:      "launcher.json",
+			objects:      append(shared, makeShareManagerPod("pvc-shared", "node-1", false)),
+			config:       Config{Mode: ModeRequiredAffinity, Lookup: longhorn_cosched.LookupConfig{RequireShareManagerReady: true}},
+			wantAffinity: preferredAffinity("node-1"),
+		},
+		{
+			name:    "share-managers on different nodes",
+			fixture: "launcher.json",
+			objects: append(append(shared, root...), makeShareManagerPod("pvc-shared", "node-1", true), makeShareManagerPod("pvc-root", "node-2", true)),
+			config:  Config{Mode: ModeRequiredAffinity},
+		},
+		{
+			name:    "no share-manager",
+			fixture: "launcher.json",
+			objects: shared,
+			config:  Config{Mode: ModeRequiredAffinity},
+		},
+		{
+			name:    "not a virt-launcher pod",
+			fixture: "other-pod.json",
+			objects: append(shared, makeShareManagerPod("pvc-shared", "node-1", true)),
+			config:  Config{Mode: ModeRequiredAffinity},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dynClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+			injector, err := NewInjector(fake.NewSimpleClientset(tt.objects...), dynClient, tt.config)
+			if err != nil {
+				t.Fatalf("NewInjector() error = %v", err)
+			}
+			resp := postReview(t, injector.Handler(nil), "/mutate", tt.fixture)
+			if !resp.Allowed {
+				t.Errorf("pod not admitted: %v", resp.Result)
+			}
+
+			if tt.wantAffinity == nil {
+				if resp.Patch != nil {
+					t.Errorf("patch = %s, want none", resp.Patch)
+				}
+				return
+			}
+			var patch []struct {
+				Op    string           `json:"op"`
+				Path  string           `json:"path"`
+				Value *corev1.Affinity `json:"value"`
+			}
+			if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+				t.Fatalf("decoding patch %s: %v", resp.Patch, err)
+			}
+			if len(patch) != 1 || patch[0].Op != "add" || patch[0].Path != "/spec/affinity" {
+				t.Fatalf("patch = %s, want a single add of /spec/affinity", resp.Patch)
+			}
+			if !equality.Semantic.DeepEqual(patch[0].Value, tt.wantAffinity) {
+				t.Errorf("affinity = %s", resp.Patch)
+			}
+		})
+	}
+}
+
+// TestWithRequiredNode checks that the node requirement is added to each of
+// the pod's own node selector terms, which are ORed, and that the pod's
+// affinity is not modified in place.
+func TestWithRequiredNode(t *testing.T) {
+	zone := corev1.NodeSelectorRequirement{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}}
+	gpu := corev1.NodeSelectorRequirement{Key: "gpu", Operator: corev1.NodeSelectorOpExists}
+	own := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{zone}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{gpu}},
+			},
+		},
+	}}
+	original := own.DeepCopy()
+
+	got := withRequiredNode(own, "node-1")
+	node := nodeNameTerm("node-1").MatchFields
+	want := []corev1.NodeSelectorTerm{
+		{MatchExpressions: []corev1.NodeSelectorRequirement{zone}, MatchFields: node},
+		{MatchExpressions: []corev1.NodeSelectorRequirement{gpu}, MatchFields: node},
+	}
+	if terms := got.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms; !equality.Semantic.DeepEqual(terms, want) {
+		t.Errorf("node selector terms = %+v, want %+v", terms, want)
+	}
+	if !equality.Semantic.DeepEqual(own, original) {
+		t.Errorf("withRequiredNode() modified the pod's affinity")
+	}
+}
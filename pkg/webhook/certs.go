@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// CertReloader serves the certificate and key mounted from a Secret, and
+// reloads them when the kubelet updates the mount, so a rotated certificate
+// (e.g. by cert-manager) is picked up without a restart.
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader returns a CertReloader for the PEM files at certFile and
+// keyFile. It fails if they cannot be loaded.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.load()
+}
+
+// Ready returns an error if the certificate cannot be loaded.
+func (r *CertReloader) Ready() error {
+	_, err := r.load()
+	return err
+}
+
+// load returns the certificate, reading the files again if the certificate
+// file changed since they were last read. If reading them fails, the
+// certificate last loaded is kept.
+func (r *CertReloader) load() (*tls.Certificate, error) {
+	info, err := os.Stat(r.certFile)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("reading serving certificate: %w", err)
+	}
+	if r.cert != nil && info.ModTime().Equal(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			klog.ErrorS(err, "Webhook: error reloading serving certificate, keeping the previous one", "certFile", r.certFile)
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("loading serving certificate: %w", err)
+	}
+	r.cert, r.modTime = &cert, info.ModTime()
+	return r.cert, nil
+}
@@ -0,0 +1,297 @@
+// Package webhook implements a mutating admission webhook that opts
+// virt-launcher pods mounting Longhorn RWX volumes in to co-scheduling with
+// their share-managers, so VM authors do not have to annotate every VM
+// template. In its affinity modes it pins the pods to the share-manager node
+// through node affinity instead, for clusters without the custom scheduler.
+// Its validating webhook catches invalid co-scheduling annotation values on
+// pods and VirtualMachines.
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+const (
+	// DefaultPodSelector selects the virt-launcher pods KubeVirt creates for
+	// VirtualMachineInstances.
+	DefaultPodSelector = "kubevirt.io=virt-launcher"
+
+	// DefaultSchedulerName is the name the scheduler's profile is deployed
+	// under.
+	DefaultSchedulerName = "kubevirt-scheduler"
+
+	// defaultSchedulerName is the scheduler name the API server sets on pods
+	// that do not name one.
+	defaultSchedulerName = corev1.DefaultSchedulerName
+)
+
+// Mode selects how an Injector opts pods in.
+type Mode string
+
+const (
+	// ModeAnnotate sets the co-schedule annotation and the scheduler name,
+	// leaving the co-location to the scheduler's LonghornCoSchedule plugin.
+	ModeAnnotate Mode = "annotate"
+
+	// ModeRequiredAffinity looks up the share-manager node at admission and
+	// injects required node affinity for it, so the default scheduler
+	// enforces the co-location. For clusters that cannot run a second
+	// scheduler.
+	ModeRequiredAffinity Mode = "required-affinity"
+
+	// ModePreferredAffinity is ModeRequiredAffinity with preferred node
+	// affinity.
+	ModePreferredAffinity Mode = "preferred-affinity"
+)
+
+// Config configures an Injector.
+type Config struct {
+	// Mode defaults to ModeAnnotate.
+	Mode Mode
+
+	// PodSelector selects the pods the Injector mutates. Defaults to
+	// DefaultPodSelector.
+	PodSelector labels.Selector
+
+	// SchedulerName is set on mutated pods that do not name a scheduler of
+	// their own. Defaults to DefaultSchedulerName.
+	SchedulerName string
+
+	// Value is the co-scheduling annotation value injected. Defaults to
+	// longhorn_cosched.AnnotationValue (hard mode). It must opt pods in.
+	Value string
+
+	// PluginArgs are the scheduler's LonghornCoSchedule args, as found under
+	// pluginConfig in its KubeSchedulerConfiguration, or nil for the
+	// defaults. They decide whether pods inherit the annotation of their
+	// VirtualMachineInstance.
+	PluginArgs runtime.Object
+
+	// Lookup tunes the share-manager lookup of the affinity modes.
+	Lookup longhorn_cosched.LookupConfig
+
+	// StorageGate adds the longhorn_cosched.StorageReadyGate scheduling gate
+	// to the pods opted in, for the storage-gate controller to remove once
+	// their storage is ready. Only in ModeAnnotate.
+	StorageGate bool
+
+	// InvalidAnnotations selects what /validate does with an object whose
+	// plugin annotations have invalid values. Defaults to
+	// InvalidAnnotationsReject.
+	InvalidAnnotations InvalidAnnotationPolicy
+}
+
+// Injector decides which pods to opt in to co-scheduling and how.
+type Injector struct {
+	clientset kubernetes.Interface
+	dynClient dynamic.Interface
+	modes     *longhorn_cosched.ModeResolver
+	config    Config
+}
+
+// NewInjector returns an Injector reading PVCs, PVs, StorageClasses and
+// Namespaces through clientset. The affinity modes also read the Longhorn
+// CRDs through dynClient, which may be nil in ModeAnnotate;
+// VirtualMachineInstances are read through it if the plugin args inherit
+// their annotation.
+func NewInjector(clientset kubernetes.Interface, dynClient dynamic.Interface, config Config) (*Injector, error) {
+	switch config.Mode {
+	case "":
+		config.Mode = ModeAnnotate
+	case ModeAnnotate:
+	case ModeRequiredAffinity, ModePreferredAffinity:
+		if dynClient == nil {
+			return nil, fmt.Errorf("mode %q needs a dynamic client", config.Mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %q, must be one of %q, %q or %q", config.Mode, ModeAnnotate, ModeRequiredAffinity, ModePreferredAffinity)
+	}
+	switch config.InvalidAnnotations {
+	case "":
+		config.InvalidAnnotations = InvalidAnnotationsReject
+	case InvalidAnnotationsReject, InvalidAnnotationsWarn:
+	default:
+		return nil, fmt.Errorf("unknown invalid annotation policy %q, must be %q or %q", config.InvalidAnnotations, InvalidAnnotationsReject, InvalidAnnotationsWarn)
+	}
+	if config.PodSelector == nil {
+		selector, err := labels.Parse(DefaultPodSelector)
+		if err != nil {
+			return nil, err
+		}
+		config.PodSelector = selector
+	}
+	if config.SchedulerName == "" {
+		config.SchedulerName = DefaultSchedulerName
+	}
+	if config.Value == "" {
+		config.Value = longhorn_cosched.AnnotationValue
+	}
+	if mode, valid := longhorn_cosched.ParseMode(config.Value); !valid || mode == "" {
+		return nil, fmt.Errorf("co-scheduling annotation value %q does not opt pods in, must be %q, %q, %q or %q", config.Value, longhorn_cosched.AnnotationValue, longhorn_cosched.ModeHard, longhorn_cosched.ModeSoft, longhorn_cosched.ModeObserve)
+	}
+	modes, err := longhorn_cosched.NewModeResolver(clientset, dynClient, config.PluginArgs)
+	if err != nil {
+		return nil, fmt.Errorf("plugin args: %w", err)
+	}
+	return &Injector{clientset: clientset, dynClient: dynClient, modes: modes, config: config}, nil
+}
+
+// patchOperation is an RFC 6902 JSON Patch operation.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// mutate returns the patch opting the pod, created in namespace, in to
+// co-scheduling, or nil if the pod is left alone: it is not selected, it
+// already carries a co-scheduling or exempt annotation, its
+// VirtualMachineInstance or Namespace decides for it, or it mounts no RWX
+// Longhorn PVC. The scheduler name is only set if the pod names none, so a
+// pod bound for another scheduler keeps it. With StorageGate, pods opted in
+// here, by their own annotation or by their VMI or Namespace also get the
+// storage-ready scheduling gate. In the affinity modes the patch comes from
+// affinityPatch instead. The reason is returned for logging.
+func (i *Injector) mutate(ctx context.Context, namespace string, pod *corev1.Pod) ([]patchOperation, string, error) {
+	if !i.config.PodSelector.Matches(labels.Set(pod.Labels)) {
+		return nil, "not selected", nil
+	}
+	if i.config.Mode != ModeAnnotate {
+		return i.affinityPatch(ctx, namespace, pod)
+	}
+	if value, ok := pod.Annotations[longhorn_cosched.ExemptAnnotationKey]; ok {
+		return nil, fmt.Sprintf("has %s=%q", longhorn_cosched.ExemptAnnotationKey, value), nil
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = namespace
+	}
+	value, decided := pod.Annotations[longhorn_cosched.AnnotationKey]
+	decidedBy := fmt.Sprintf("has %s=%q", longhorn_cosched.AnnotationKey, value)
+	if !decided {
+		if source := i.modes.Inherited(ctx, pod); source != "" {
+			decided, decidedBy = true, "co-scheduling decided by "+source
+			value = string(i.modes.Mode(ctx, pod))
+		}
+	}
+	if decided && (!i.config.StorageGate || !gates(value)) {
+		return nil, decidedBy, nil
+	}
+	pvc, err := i.longhornRWXPVC(ctx, namespace, pod)
+	if err != nil {
+		return nil, "", err
+	}
+	if pvc == "" {
+		return nil, "no Longhorn RWX PVC", nil
+	}
+
+	var patch []patchOperation
+	reason := "mounts Longhorn RWX PVC " + pvc
+	if !decided {
+		if pod.Annotations == nil {
+			patch = append(patch, patchOperation{Op: "add", Path: "/metadata/annotations", Value: map[string]string{}})
+		}
+		patch = append(patch, patchOperation{Op: "add", Path: "/metadata/annotations/" + escapeJSONPointer(longhorn_cosched.AnnotationKey), Value: i.config.Value})
+		if pod.Spec.SchedulerName == "" || pod.Spec.SchedulerName == defaultSchedulerName {
+			patch = append(patch, patchOperation{Op: "add", Path: "/spec/schedulerName", Value: i.config.SchedulerName})
+		}
+		value = i.config.Value
+	}
+	if i.config.StorageGate && gates(value) && !hasGate(pod, longhorn_cosched.StorageReadyGate) {
+		gate := corev1.PodSchedulingGate{Name: longhorn_cosched.StorageReadyGate}
+		if len(pod.Spec.SchedulingGates) == 0 {
+			patch = append(patch, patchOperation{Op: "add", Path: "/spec/schedulingGates", Value: []corev1.PodSchedulingGate{gate}})
+		} else {
+			patch = append(patch, patchOperation{Op: "add", Path: "/spec/schedulingGates/-", Value: gate})
+		}
+		reason += ", gated until its storage is ready"
+	}
+	return patch, reason, nil
+}
+
+// gates returns true if a pod with the co-scheduling annotation value is
+// gated until its storage is ready: it opts in, and not in observe mode,
+// which must not change when the pod is scheduled.
+func gates(value string) bool {
+	mode, _ := longhorn_cosched.ParseMode(value)
+	return mode != "" && mode != longhorn_cosched.ModeObserve
+}
+
+// hasGate returns true if the pod carries the scheduling gate.
+func hasGate(pod *corev1.Pod, name string) bool {
+	for _, gate := range pod.Spec.SchedulingGates {
+		if gate.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// longhornRWXPVC returns the name of the first PVC the pod mounts that is
+// ReadWriteMany and provisioned by Longhorn, or "" if there is none. A PVC
+// that does not exist yet, or whose PV and StorageClass cannot be read, does
+// not count.
+func (i *Injector) longhornRWXPVC(ctx context.Context, namespace string, pod *corev1.Pod) (string, error) {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		name := volume.PersistentVolumeClaim.ClaimName
+		pvc, err := i.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("getting PVC %s/%s: %w", namespace, name, err)
+		}
+		if !longhorn_cosched.IsRWX(pvc) {
+			continue
+		}
+		if driver := i.volumeDriver(ctx, pvc); driver == longhorn_cosched.LonghornDriver {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// volumeDriver returns the CSI driver of the PV bound to pvc or, if the PVC
+// is not bound, the provisioner of its StorageClass. It returns "" if
+// neither can be read.
+func (i *Injector) volumeDriver(ctx context.Context, pvc *corev1.PersistentVolumeClaim) string {
+	if pvc.Spec.VolumeName != "" {
+		pv, err := i.clientset.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+		if err == nil {
+			if pv.Spec.CSI == nil {
+				return ""
+			}
+			return pv.Spec.CSI.Driver
+		}
+		klog.V(4).InfoS("Webhook: error reading PV, falling back to the StorageClass", "pvc", klog.KObj(pvc), "pv", pvc.Spec.VolumeName, "err", err)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return ""
+	}
+	sc, err := i.clientset.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).InfoS("Webhook: error reading StorageClass", "pvc", klog.KObj(pvc), "storageClass", *pvc.Spec.StorageClassName, "err", err)
+		return ""
+	}
+	return sc.Provisioner
+}
+
+// escapeJSONPointer escapes a map key for use in a JSON Pointer (RFC 6901).
+func escapeJSONPointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// InvalidAnnotationPolicy selects what /validate does with an object whose
+// plugin annotations have invalid values.
+type InvalidAnnotationPolicy string
+
+const (
+	// InvalidAnnotationsReject denies the object.
+	InvalidAnnotationsReject InvalidAnnotationPolicy = "reject"
+
+	// InvalidAnnotationsWarn admits the object with a warning, shown by
+	// kubectl.
+	InvalidAnnotationsWarn InvalidAnnotationPolicy = "warn"
+)
+
+// virtualMachineKind is the kind of KubeVirt VirtualMachines.
+const virtualMachineKind = "VirtualMachine"
+
+// validateReview returns the response to a validating admission request for
+// a pod or a VirtualMachine. An invalid plugin annotation value is rejected
+// or warned about, per the InvalidAnnotations policy, with the accepted
+// values. Everything else only ever gets warnings: an opt-in without RWX PVC,
+// or, on a VirtualMachine, an annotation on its own metadata that KubeVirt
+// does not copy to its virt-launcher pods.
+func (i *Injector) validateReview(r *http.Request, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return allowed
+	}
+
+	var problems, warnings []string
+	switch req.Kind.Kind {
+	case "Pod":
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+			klog.ErrorS(err, "Webhook: error decoding pod, admitting it", "uid", req.UID)
+			return allowed
+		}
+		problems = invalidAnnotations("", pod.Annotations)
+		if len(problems) == 0 {
+			warnings = i.rwxWarnings(r.Context(), req.Namespace, pod.Annotations, podClaims(pod), nil)
+		}
+	case virtualMachineKind:
+		vm := &unstructured.Unstructured{}
+		if err := vm.UnmarshalJSON(req.Object.Raw); err != nil {
+			klog.ErrorS(err, "Webhook: error decoding VirtualMachine, admitting it", "uid", req.UID)
+			return allowed
+		}
+		templateAnnotations, _, _ := unstructured.NestedStringMap(vm.Object, "spec", "template", "metadata", "annotations")
+		problems = append(invalidAnnotations("metadata.annotations: ", vm.GetAnnotations()),
+			invalidAnnotations("spec.template.metadata.annotations: ", templateAnnotations)...)
+		if len(problems) == 0 {
+			claims, templated := vmClaims(vm)
+			warnings = i.rwxWarnings(r.Context(), req.Namespace, templateAnnotations, claims, templated)
+			if _, ok := vm.GetAnnotations()[longhorn_cosched.AnnotationKey]; ok {
+				if _, ok := templateAnnotations[longhorn_cosched.AnnotationKey]; !ok {
+					warnings = append(warnings, fmt.Sprintf("annotation %s on the VirtualMachine's metadata is not copied to its virt-launcher pods by KubeVirt; set it in spec.template.metadata.annotations, or deploy the annotation propagation controller", longhorn_cosched.AnnotationKey))
+				}
+			}
+		}
+	default:
+		return allowed
+	}
+
+	objectKey := klog.KRef(req.Namespace, req.Name)
+	if len(problems) > 0 && i.config.InvalidAnnotations == InvalidAnnotationsReject {
+		klog.V(2).InfoS("Webhook: rejecting object with invalid annotation", "kind", req.Kind.Kind, "object", objectKey, "problem", problems[0])
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+				Message: problems[0],
+			},
+		}
+	}
+	allowed.Warnings = append(problems, warnings...)
+	if len(allowed.Warnings) > 0 {
+		klog.V(4).InfoS("Webhook: admitting object with warnings", "kind", req.Kind.Kind, "object", objectKey, "warnings", allowed.Warnings)
+	}
+	return allowed
+}
+
+// invalidAnnotations returns a message for the first invalid plugin
+// annotation, prefixed with where it was found, or nil if there is none.
+func invalidAnnotations(where string, annotations map[string]string) []string {
+	key, value, accepted := longhorn_cosched.InvalidAnnotation(annotations)
+	if key == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("%sannotation %s has invalid value %q, must be %s", where, key, value, accepted)}
+}
+
+// rwxWarnings returns a warning if the annotations opt in to co-scheduling
+// but none of the claims is an RWX PVC. Claims that do not exist yet, and
+// those in templated (a VirtualMachine's dataVolumeTemplates), may still turn
+// out RWX and suppress the warning, as do PVCs that cannot be read.
+func (i *Injector) rwxWarnings(ctx context.Context, namespace string, annotations map[string]string, claims []string, templated map[string]bool) []string {
+	value, ok := annotations[longhorn_cosched.AnnotationKey]
+	if !ok {
+		return nil
+	}
+	if mode, _ := longhorn_cosched.ParseMode(value); mode == "" {
+		return nil
+	}
+	for _, name := range claims {
+		if templated[name] {
+			return nil
+		}
+		pvc, err := i.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				klog.V(4).InfoS("Webhook: error reading PVC", "pvc", klog.KRef(namespace, name), "err", err)
+			}
+			return nil
+		}
+		if longhorn_cosched.IsRWX(pvc) {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("annotation %s=%q opts in to co-scheduling, but no volume is a ReadWriteMany PVC, so there is no share-manager to co-locate with", longhorn_cosched.AnnotationKey, value)}
+}
+
+// podClaims returns the PVCs the pod's volumes name.
+func podClaims(pod *corev1.Pod) []string {
+	var claims []string
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return claims
+}
+
+// vmClaims returns the PVCs the VirtualMachine's template volumes name,
+// directly or through a DataVolume of the same name, and the names of its
+// dataVolumeTemplates.
+func vmClaims(vm *unstructured.Unstructured) (claims []string, templated map[string]bool) {
+	volumes, _, _ := unstructured.NestedSlice(vm.Object, "spec", "template", "spec", "volumes")
+	for _, volume := range volumes {
+		volume, ok := volume.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, _, _ := unstructured.NestedString(volume, "persistentVolumeClaim", "claimName"); name != "" {
+			claims = append(claims, name)
+		}
+		if name, _, _ := unstructured.NestedString(volume, "dataVolume", "name"); name != "" {
+			claims = append(claims, name)
+		}
+	}
+	templates, _, _ := unstructured.NestedSlice(vm.Object, "spec", "dataVolumeTemplates")
+	templated = map[string]bool{}
+	for _, template := range templates {
+		template, ok := template.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, _, _ := unstructured.NestedString(template, "metadata", "name"); name != "" {
+			templated[name] = true
+		}
+	}
+	return claims, templated
+}
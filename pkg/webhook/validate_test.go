@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestValidate checks which AdmissionReview fixtures /validate accepts,
+// rejects or warns about.
+func TestValidate(t *testing.T) {
+	rwx := makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn")
+	rwo := makePVC("shared", corev1.ReadWriteOnce, "pvc-shared", "longhorn")
+
+	tests := []struct {
+		name         string
+		fixture      string
+		objects      []runtime.Object
+		policy       InvalidAnnotationPolicy
+		wantRejected string
+		wantWarnings []string
+	}{
+		{
+			name:    "valid pod",
+			fixture: "launcher-annotated.json",
+			objects: []runtime.Object{rwx},
+		},
+		{
+			name:    "valid VirtualMachine",
+			fixture: "vm.json",
+			objects: []runtime.Object{rwx},
+		},
+		{
+			name:    "pod without annotations",
+			fixture: "other-pod.json",
+			objects: []runtime.Object{rwo},
+		},
+		{
+			name:         "pod typo rejected",
+			fixture:      "launcher-typo.json",
+			objects:      []runtime.Object{rwx},
+			wantRejected: `annotation scheduler.kubevirt-scheduler.io/co-schedule has invalid value "ture", must be "true", "hard", "soft", "observe", "false" or "off"`,
+		},
+		{
+			name:         "pod typo warned about",
+			fixture:      "launcher-typo.json",
+			objects:      []runtime.Object{rwx},
+			policy:       InvalidAnnotationsWarn,
+			wantWarnings: []string{`has invalid value "ture"`},
+		},
+		{
+			name:         "VirtualMachine template typo rejected",
+			fixture:      "vm-typo.json",
+			objects:      []runtime.Object{rwx},
+			wantRejected: `spec.template.metadata.annotations: annotation scheduler.kubevirt-scheduler.io/co-schedule has invalid value "sfot"`,
+		},
+		{
+			name:         "pod without RWX PVC",
+			fixture:      "launcher-annotated.json",
+			objects:      []runtime.Object{rwo},
+			wantWarnings: []string{"no volume is a ReadWriteMany PVC"},
+		},
+		{
+			name:         "VirtualMachine without RWX PVC",
+			fixture:      "vm.json",
+			objects:      []runtime.Object{rwo},
+			wantWarnings: []string{"no volume is a ReadWriteMany PVC"},
+		},
+		{
+			name:    "PVC not created yet",
+			fixture: "vm.json",
+		},
+		{
+			name:         "annotation on VirtualMachine metadata",
+			fixture:      "vm-datavolume.json",
+			wantWarnings: []string{"is not copied to its virt-launcher pods"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector, err := NewInjector(fake.NewSimpleClientset(tt.objects...), nil, Config{InvalidAnnotations: tt.policy})
+			if err != nil {
+				t.Fatalf("NewInjector() error = %v", err)
+			}
+			resp := postReview(t, injector.Handler(nil), "/validate", tt.fixture)
+			if resp.Patch != nil {
+				t.Errorf("patch = %s, want none", resp.Patch)
+			}
+
+			if tt.wantRejected != "" {
+				if resp.Allowed || resp.Result == nil || !strings.Contains(resp.Result.Message, tt.wantRejected) {
+					t.Errorf("response = %+v, want rejected with %q", resp, tt.wantRejected)
+				}
+				return
+			}
+			if !resp.Allowed {
+				t.Fatalf("object rejected: %v", resp.Result)
+			}
+			if len(resp.Warnings) != len(tt.wantWarnings) {
+				t.Fatalf("warnings = %q, want %d", resp.Warnings, len(tt.wantWarnings))
+			}
+			for i, want := range tt.wantWarnings {
+				if !strings.Contains(resp.Warnings[i], want) {
+					t.Errorf("warning %q does not contain %q", resp.Warnings[i], want)
+				}
+			}
+		})
+	}
+}
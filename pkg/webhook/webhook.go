@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// maxRequestBytes bounds the AdmissionReview bodies read. The API server
+// sends at most 3 MiB objects; pods are far smaller.
+const maxRequestBytes = 3 << 20
+
+// Handler returns the webhook's HTTP handler: AdmissionReviews are served on
+// /mutate and /validate, and /healthz and /readyz report whether the server
+// is up. /readyz fails while ready returns an error, e.g. until a serving
+// certificate has been loaded; ready may be nil.
+func (i *Injector) Handler(ready func() error) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate", serveReview(i.mutateReview))
+	mux.HandleFunc("/validate", serveReview(i.validateReview))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if ready != nil {
+			if err := ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprint(w, "ok")
+	})
+	return mux
+}
+
+// serveReview returns a handler answering AdmissionReviews with answer.
+func serveReview(answer func(*http.Request, *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("reading request: %v", err), http.StatusBadRequest)
+			return
+		}
+		review := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, "request body is not an AdmissionReview", http.StatusBadRequest)
+			return
+		}
+
+		review.Response = answer(r, review.Request)
+		review.Response.UID = review.Request.UID
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			klog.ErrorS(err, "Webhook: error writing AdmissionReview response")
+		}
+	}
+}
+
+// mutateReview returns the response to a mutating admission request. The pod
+// is always admitted: when it cannot be read or its PVCs cannot be looked up
+// it is admitted unchanged, so the webhook never blocks VM creation.
+func (i *Injector) mutateReview(r *http.Request, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+	if req.Kind.Kind != "Pod" || req.Operation != admissionv1.Create {
+		return allowed
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		klog.ErrorS(err, "Webhook: error decoding pod, admitting it unchanged", "uid", req.UID)
+		allowed.Result = &metav1.Status{Message: fmt.Sprintf("decoding pod: %v", err)}
+		return allowed
+	}
+	podKey := klog.KRef(req.Namespace, pod.Name)
+	if pod.Name == "" {
+		podKey = klog.KRef(req.Namespace, pod.GenerateName)
+	}
+
+	patch, reason, err := i.mutate(r.Context(), req.Namespace, pod)
+	if err != nil {
+		klog.ErrorS(err, "Webhook: error looking up the pod's volumes, admitting pod unchanged", "pod", podKey)
+		return allowed
+	}
+	if patch == nil {
+		klog.V(5).InfoS("Webhook: pod left unchanged", "pod", podKey, "reason", reason)
+		return allowed
+	}
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		klog.ErrorS(err, "Webhook: error encoding patch, admitting pod unchanged", "pod", podKey)
+		return allowed
+	}
+	klog.V(2).InfoS("Webhook: opting pod in to co-scheduling", "pod", podKey, "reason", reason)
+	patchType := admissionv1.PatchTypeJSONPatch
+	allowed.Patch = raw
+	allowed.PatchType = &patchType
+	return allowed
+}
@@ -0,0 +1,250 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+func makePVC(name string, mode corev1.PersistentVolumeAccessMode, volumeName, storageClass string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vms"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{mode},
+			VolumeName:       volumeName,
+			StorageClassName: &storageClass,
+		},
+	}
+}
+
+func makePV(name, driver string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: name},
+			},
+		},
+	}
+}
+
+func makeStorageClass(name, provisioner string) *storagev1.StorageClass {
+	return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: name}, Provisioner: provisioner}
+}
+
+func makeNamespace(name, value string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{longhorn_cosched.AnnotationKey: value}}}
+}
+
+// postReview sends the AdmissionReview fixture to the handler's path and
+// returns the response.
+func postReview(t *testing.T, handler http.Handler, path, fixture string) *admissionv1.AdmissionResponse {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST %s = %d %s", path, rec.Code, rec.Body)
+	}
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), review); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if review.Response == nil {
+		t.Fatalf("AdmissionReview has no response")
+	}
+	return review.Response
+}
+
+// TestMutate checks which AdmissionReview fixtures get the co-schedule
+// annotation, the scheduler name and the storage gate, and that every pod is
+// admitted.
+func TestMutate(t *testing.T) {
+	const annotationPath = "/metadata/annotations/scheduler.kubevirt-scheduler.io~1co-schedule"
+	rootDisk := makePVC("my-vm-root", corev1.ReadWriteOnce, "pvc-root", "longhorn")
+	rootPV := makePV("pvc-root", longhorn_cosched.LonghornDriver)
+
+	tests := []struct {
+		name      string
+		fixture   string
+		objects   []runtime.Object
+		config    Config
+		wantPatch []patchOperation
+	}{
+		{
+			name:    "bound Longhorn RWX PVC",
+			fixture: "launcher.json",
+			objects: []runtime.Object{rootDisk, rootPV, makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+			wantPatch: []patchOperation{
+				{Op: "add", Path: annotationPath, Value: "true"},
+				{Op: "add", Path: "/spec/schedulerName", Value: "kubevirt-scheduler"},
+			},
+		},
+		{
+			name:    "unbound PVC of a Longhorn StorageClass, configured value",
+			fixture: "launcher.json",
+			objects: []runtime.Object{makePVC("shared", corev1.ReadWriteMany, "", "longhorn-rwx"), makeStorageClass("longhorn-rwx", longhorn_cosched.LonghornDriver)},
+			config:  Config{Value: "soft", SchedulerName: "vm-scheduler"},
+			wantPatch: []patchOperation{
+				{Op: "add", Path: annotationPath, Value: "soft"},
+				{Op: "add", Path: "/spec/schedulerName", Value: "vm-scheduler"},
+			},
+		},
+		{
+			name:    "RWO Longhorn PVCs only",
+			fixture: "launcher.json",
+			objects: []runtime.Object{rootDisk, rootPV, makePVC("shared", corev1.ReadWriteOnce, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+		},
+		{
+			name:    "RWX PVC of another driver",
+			fixture: "launcher.json",
+			objects: []runtime.Object{makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "cephfs"), makePV("pvc-shared", "cephfs.csi.ceph.com")},
+		},
+		{
+			name:    "PVC not created yet",
+			fixture: "launcher.json",
+		},
+		{
+			name:    "explicit annotation",
+			fixture: "launcher-annotated.json",
+			objects: []runtime.Object{makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+		},
+		{
+			name:    "no annotations, own scheduler",
+			fixture: "launcher-no-annotations.json",
+			objects: []runtime.Object{makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+			wantPatch: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{}},
+				{Op: "add", Path: annotationPath, Value: "true"},
+			},
+		},
+		{
+			name:    "storage gate",
+			fixture: "launcher.json",
+			objects: []runtime.Object{makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+			config:  Config{StorageGate: true},
+			wantPatch: []patchOperation{
+				{Op: "add", Path: annotationPath, Value: "true"},
+				{Op: "add", Path: "/spec/schedulerName", Value: "kubevirt-scheduler"},
+				{Op: "add", Path: "/spec/schedulingGates", Value: []corev1.PodSchedulingGate{{Name: longhorn_cosched.StorageReadyGate}}},
+			},
+		},
+		{
+			name:    "storage gate, explicit annotation",
+			fixture: "launcher-annotated.json",
+			objects: []runtime.Object{makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+			config:  Config{StorageGate: true},
+			wantPatch: []patchOperation{
+				{Op: "add", Path: "/spec/schedulingGates", Value: []corev1.PodSchedulingGate{{Name: longhorn_cosched.StorageReadyGate}}},
+			},
+		},
+		{
+			name:    "namespace opts out",
+			fixture: "launcher.json",
+			objects: []runtime.Object{makeNamespace("vms", "off"), makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+		},
+		{
+			name:    "namespace selects soft mode",
+			fixture: "launcher.json",
+			objects: []runtime.Object{makeNamespace("vms", "soft"), makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+		},
+		{
+			name:    "invalid namespace annotation",
+			fixture: "launcher.json",
+			objects: []runtime.Object{makeNamespace("vms", "yes"), makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+			wantPatch: []patchOperation{
+				{Op: "add", Path: annotationPath, Value: "true"},
+				{Op: "add", Path: "/spec/schedulerName", Value: "kubevirt-scheduler"},
+			},
+		},
+		{
+			name:    "storage gate, namespace selects hard mode",
+			fixture: "launcher.json",
+			objects: []runtime.Object{makeNamespace("vms", "require"), makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+			config:  Config{StorageGate: true},
+			wantPatch: []patchOperation{
+				{Op: "add", Path: "/spec/schedulingGates", Value: []corev1.PodSchedulingGate{{Name: longhorn_cosched.StorageReadyGate}}},
+			},
+		},
+		{
+			name:    "not a virt-launcher pod",
+			fixture: "other-pod.json",
+			objects: []runtime.Object{makePVC("shared", corev1.ReadWriteMany, "pvc-shared", "longhorn"), makePV("pvc-shared", longhorn_cosched.LonghornDriver)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector, err := NewInjector(fake.NewSimpleClientset(tt.objects...), nil, tt.config)
+			if err != nil {
+				t.Fatalf("NewInjector() error = %v", err)
+			}
+			resp := postReview(t, injector.Handler(nil), "/mutate", tt.fixture)
+			if !resp.Allowed {
+				t.Errorf("pod not admitted: %v", resp.Result)
+			}
+			if resp.UID == "" {
+				t.Errorf("response has no UID")
+			}
+
+			if tt.wantPatch == nil {
+				if resp.Patch != nil {
+					t.Errorf("patch = %s, want none", resp.Patch)
+				}
+				return
+			}
+			if resp.PatchType == nil || *resp.PatchType != admissionv1.PatchTypeJSONPatch {
+				t.Errorf("patch type = %v, want %s", resp.PatchType, admissionv1.PatchTypeJSONPatch)
+			}
+			var got []patchOperation
+			if err := json.Unmarshal(resp.Patch, &got); err != nil {
+				t.Fatalf("decoding patch %s: %v", resp.Patch, err)
+			}
+			want, _ := json.Marshal(tt.wantPatch)
+			if gotJSON, _ := json.Marshal(got); !bytes.Equal(gotJSON, want) {
+				t.Errorf("patch = %s, want %s", gotJSON, want)
+			}
+		})
+	}
+}
+
+// TestNewInjectorValue checks that only injected values opting pods in are
+// accepted.
+func TestNewInjectorValue(t *testing.T) {
+	for value, wantErr := range map[string]bool{"": false, "true": false, "soft": false, "observe": false, "off": true, "false": true, "yes": true} {
+		_, err := NewInjector(fake.NewSimpleClientset(), nil, Config{Value: value})
+		if (err != nil) != wantErr {
+			t.Errorf("NewInjector(Value: %q) error = %v, wantErr %v", value, err, wantErr)
+		}
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	injector, err := NewInjector(fake.NewSimpleClientset(), nil, Config{})
+	if err != nil {
+		t.Fatalf("NewInjector() error = %v", err)
+	}
+	notReady := injector.Handler(func() error { return os.ErrNotExist })
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable} {
+		rec := httptest.NewRecorder()
+		notReady.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
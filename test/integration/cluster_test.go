@@ -0,0 +1,347 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/profile"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/sampleconfig"
+)
+
+const (
+	// schedulerUser is the ServiceAccount manifests/rbac.yaml binds the
+	// scheduler's ClusterRole to; the scheduler runs as it.
+	schedulerUser = "system:serviceaccount:kube-system:kubevirt-scheduler"
+
+	storageClassName = "longhorn"
+	longhornDriver   = "driver.longhorn.io"
+)
+
+var shareManagerResource = schema.GroupVersionResource{Group: "longhorn.io", Version: "v1beta2", Resource: "sharemanagers"}
+
+// createStorageClass creates Longhorn's StorageClass.
+func createStorageClass(ctx context.Context) error {
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: storageClassName}, Provisioner: longhornDriver}
+	_, err := admin.StorageV1().StorageClasses().Create(ctx, sc, metav1.CreateOptions{})
+	return err
+}
+
+// applyManifest creates the objects of a multi-document YAML file.
+func applyManifest(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := client.New(adminConfig, client.Options{})
+	if err != nil {
+		return err
+	}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decoding %s: %w", path, err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if err := c.Create(ctx, obj); err != nil {
+			return fmt.Errorf("creating %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+}
+
+// startScheduler runs the scheduler with the profiles of
+// manifests/scheduler-config.yaml and the plugin registered as the
+// scheduler command registers it, as the scheduler's ServiceAccount, until
+// the test ends.
+func startScheduler(t *testing.T) {
+	t.Helper()
+	startSchedulerWithPlugin(t, longhorn_cosched.New)
+}
+
+// startSchedulerWithPlugin is startScheduler with the plugin created by the
+// factory.
+func startSchedulerWithPlugin(t *testing.T, factory frameworkruntime.PluginFactory) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "manifests", "scheduler-config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var configMap corev1.ConfigMap
+	if err := yaml.Unmarshal(data, &configMap); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := sampleconfig.Decode([]byte(configMap.Data["scheduler-config.yaml"]))
+	if err != nil {
+		t.Fatalf("decoding the bundled configuration: %v", err)
+	}
+
+	restConfig := rest.CopyConfig(adminConfig)
+	restConfig.Impersonate = rest.ImpersonationConfig{UserName: schedulerUser}
+	// The scheduler command limits its clients to the configured QPS and
+	// burst, defaulted to 50 and 100.
+	restConfig.QPS, restConfig.Burst = cfg.ClientConnection.QPS, int(cfg.ClientConnection.Burst)
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	broadcaster := events.NewBroadcaster(&events.EventSinkImpl{Interface: clientset.EventsV1()})
+	broadcaster.StartRecordingToSink(ctx.Done())
+	t.Cleanup(broadcaster.Shutdown)
+
+	informerFactory := scheduler.NewInformerFactory(clientset, 0)
+	sched, err := scheduler.New(ctx, clientset, informerFactory, nil, profile.NewRecorderFactory(broadcaster),
+		scheduler.WithKubeConfig(restConfig),
+		scheduler.WithProfiles(cfg.Profiles...),
+		scheduler.WithFrameworkOutOfTreeRegistry(frameworkruntime.Registry{longhorn_cosched.Name: factory}),
+	)
+	if err != nil {
+		t.Fatalf("creating the scheduler: %v", err)
+	}
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+	if err := sched.WaitForHandlersSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	go sched.Run(ctx)
+}
+
+// createNamespace creates a namespace for the test, deleted when it ends.
+func createNamespace(t *testing.T) string {
+	t.Helper()
+	name := strings.ToLower(strings.NewReplacer("/", "-", "_", "-").Replace(t.Name()))
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if _, err := admin.CoreV1().Namespaces().Create(context.Background(), namespace, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = admin.CoreV1().Namespaces().Delete(context.Background(), name, metav1.DeleteOptions{})
+	})
+	return name
+}
+
+// createVolume creates the RWX PVC "shared" in the namespace, bound to a
+// Longhorn PV, and returns the PV's name.
+func createVolume(t *testing.T, namespace string) string {
+	t.Helper()
+	ctx := context.Background()
+	pvName := "pvc-" + namespace
+	size := corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}
+	rwx := []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+	className := storageClassName
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      size,
+			AccessModes:                   rwx,
+			StorageClassName:              storageClassName,
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: longhornDriver, VolumeHandle: pvName},
+			},
+			ClaimRef: &corev1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: namespace, Name: "shared"},
+		},
+	}
+	pv, err := admin.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = admin.CoreV1().PersistentVolumes().Delete(context.Background(), pvName, metav1.DeleteOptions{})
+	})
+	pv.Status.Phase = corev1.VolumeBound
+	if _, err := admin.CoreV1().PersistentVolumes().UpdateStatus(ctx, pv, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		// The annotation is how the PV controller marks a claim it bound;
+		// VolumeBinding treats claims without it as unbound.
+		ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: namespace, Annotations: map[string]string{"pv.kubernetes.io/bind-completed": "yes"}},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      rwx,
+			StorageClassName: &className,
+			VolumeName:       pvName,
+			Resources:        corev1.VolumeResourceRequirements{Requests: size},
+		},
+	}
+	pvc, err = admin.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pvc.Status = corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound, AccessModes: rwx, Capacity: size}
+	if _, err := admin.CoreV1().PersistentVolumeClaims(namespace).UpdateStatus(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return pvName
+}
+
+// createShareManager creates the ShareManager of the PV, with the status
+// Longhorn gives it once it assigns it to a node; an empty owner leaves the
+// status unset.
+func createShareManager(t *testing.T, pvName, owner, state string) {
+	t.Helper()
+	ctx := context.Background()
+	dynClient, err := dynamic.NewForConfig(adminConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := &unstructured.Unstructured{}
+	sm.SetAPIVersion(shareManagerResource.GroupVersion().String())
+	sm.SetKind("ShareManager")
+	sm.SetNamespace(longhorn_cosched.LonghornNamespace)
+	sm.SetName(pvName)
+	sm.Object["spec"] = map[string]interface{}{"image": "longhornio/longhorn-share-manager"}
+	shareManagers := dynClient.Resource(shareManagerResource).Namespace(longhorn_cosched.LonghornNamespace)
+	sm, err = shareManagers.Create(ctx, sm, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = shareManagers.Delete(context.Background(), pvName, metav1.DeleteOptions{})
+	})
+	if owner == "" {
+		return
+	}
+	sm.Object["status"] = map[string]interface{}{"ownerID": owner, "state": state}
+	if _, err := shareManagers.UpdateStatus(ctx, sm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createShareManagerPod creates the running share-manager pod of the PV on
+// the node, as Longhorn names and labels it.
+func createShareManagerPod(t *testing.T, pvName, node string) {
+	t.Helper()
+	ctx := context.Background()
+	pods := admin.CoreV1().Pods(longhorn_cosched.LonghornNamespace)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   longhorn_cosched.ShareManagerPrefix + pvName,
+			Labels: map[string]string{"longhorn.io/share-manager": pvName},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   node,
+			Containers: []corev1.Container{{Name: "share-manager", Image: "longhornio/longhorn-share-manager"}},
+		},
+	}
+	pod, err := pods.Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = pods.Delete(context.Background(), pod.Name, metav1.DeleteOptions{GracePeriodSeconds: new(int64)})
+	})
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	if _, err := pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createVM creates a virt-launcher pod for the kubevirt-scheduler mounting
+// the PVC "shared", co-scheduled in the mode.
+func createVM(t *testing.T, namespace, name string, mode longhorn_cosched.Mode) *corev1.Pod {
+	t.Helper()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{"kubevirt.io": "virt-launcher"},
+			Annotations: map[string]string{longhorn_cosched.AnnotationKey: string(mode)},
+		},
+		Spec: corev1.PodSpec{
+			SchedulerName: sampleconfig.DefaultSchedulerName,
+			Containers: []corev1.Container{{
+				Name:      "compute",
+				Image:     "quay.io/kubevirt/virt-launcher",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name:         "disk",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "shared"}},
+			}},
+		},
+	}
+	pod, err := admin.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pod
+}
+
+// waitForBinding waits for the pod to be bound and returns its node.
+func waitForBinding(t *testing.T, pod *corev1.Pod) string {
+	t.Helper()
+	current := pod
+	err := wait.PollUntilContextTimeout(context.Background(), 100*time.Millisecond, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		got, err := admin.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		current = got
+		return current.Spec.NodeName != "", nil
+	})
+	if err != nil {
+		t.Fatalf("pod %s/%s not bound: %v; conditions: %+v", pod.Namespace, pod.Name, err, current.Status.Conditions)
+	}
+	return current.Spec.NodeName
+}
+
+// waitForEvent waits for an event with the reason on the pod and returns its
+// note.
+func waitForEvent(t *testing.T, pod *corev1.Pod, reason string) string {
+	t.Helper()
+	var note string
+	err := wait.PollUntilContextTimeout(context.Background(), 100*time.Millisecond, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		list, err := admin.EventsV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, event := range list.Items {
+			if event.Regarding.Name == pod.Name && event.Reason == reason {
+				note = event.Note
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("no %s event on pod %s/%s: %v", reason, pod.Namespace, pod.Name, err)
+	}
+	return note
+}
@@ -0,0 +1,103 @@
+//go:build integration
+
+// Package integration runs the kubevirt-scheduler, with the
+// LonghornCoSchedule plugin and the bundled profile and RBAC, against a real
+// API server started by envtest. Run it with
+//
+//	KUBEBUILDER_ASSETS=$(setup-envtest use -p path 1.32.x) go test -tags=integration ./test/integration/
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// nodes are the nodes of the test cluster. There is no kubelet: they are
+// Ready because the tests say so.
+var nodes = []string{"node-1", "node-2", "node-3"}
+
+var (
+	// adminConfig is the config of a cluster admin, setting up the tests.
+	adminConfig *rest.Config
+	admin       kubernetes.Interface
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("testdata", "crds")},
+		ErrorIfCRDPathMissing: true,
+	}
+	var err error
+	if adminConfig, err = env.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "starting envtest (is KUBEBUILDER_ASSETS set?): %v\n", err)
+		return 1
+	}
+	defer env.Stop()
+	if err := setUpCluster(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "setting up the cluster: %v\n", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// setUpCluster creates the nodes, the Longhorn namespace and StorageClass,
+// and the scheduler's RBAC from manifests/rbac.yaml.
+func setUpCluster(ctx context.Context) error {
+	var err error
+	if admin, err = kubernetes.NewForConfig(adminConfig); err != nil {
+		return err
+	}
+	for _, name := range nodes {
+		if err := createNode(ctx, name, nil); err != nil {
+			return err
+		}
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: longhorn_cosched.LonghornNamespace}}
+	if _, err := admin.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	if err := createStorageClass(ctx); err != nil {
+		return err
+	}
+	return applyManifest(ctx, filepath.Join("..", "..", "manifests", "rbac.yaml"))
+}
+
+// createNode creates a Ready node with the labels and room for 110 pods.
+func createNode(ctx context.Context, name string, labels map[string]string) error {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	node, err := admin.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	capacity := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("8"),
+		corev1.ResourceMemory: resource.MustParse("16Gi"),
+		corev1.ResourcePods:   resource.MustParse("110"),
+	}
+	node.Status.Capacity, node.Status.Allocatable = capacity, capacity
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	if node, err = admin.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	// Admission taints new nodes not-ready until the node lifecycle
+	// controller, which does not run here, sees them Ready.
+	node.Spec.Taints = nil
+	_, err = admin.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,536 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/sampleconfig"
+)
+
+// TestScale is skipped unless -scale is given. To compare a change, e.g. to
+// how the plugin caches, with the code before it, write the results of a run
+// before the change with -scale.out and pass them to a run after it with
+// -scale.baseline:
+//
+//	go test -tags=integration ./test/integration/ -run TestScale -timeout 30m -scale -scale.out before.json
+//	go test -tags=integration ./test/integration/ -run TestScale -timeout 30m -scale -scale.baseline before.json
+var (
+	scale              = flag.Bool("scale", false, "Run TestScale.")
+	scaleNodes         = flag.Int("scale.nodes", 1000, "Nodes TestScale creates.")
+	scaleShareManagers = flag.Int("scale.sharemanagers", 300, "Volumes TestScale creates, each with a running ShareManager on one of the nodes.")
+	scalePods          = flag.Int("scale.pods", 500, "Hard-mode VMs TestScale creates at once, round-robin over the volumes.")
+	scaleTimeout       = flag.Duration("scale.timeout", 10*time.Minute, "How long TestScale waits for the VMs to be bound.")
+	scaleOut           = flag.String("scale.out", "", "File TestScale writes its results to, as JSON.")
+	scaleBaseline      = flag.String("scale.baseline", "", "Results of an earlier TestScale run, written with -scale.out; the run fails if it is worse by more than -scale.tolerance.")
+	scaleTolerance     = flag.Float64("scale.tolerance", 0.25, "Fraction by which the throughput, a latency percentile or the requests per pod may be worse than in -scale.baseline.")
+	scaleLatencySlack  = flag.Duration("scale.latency-slack", 5*time.Millisecond, "Latency by which a p99 may exceed -scale.baseline on top of -scale.tolerance, so that extension points taking microseconds do not fail on noise.")
+	scaleMinThroughput = flag.Float64("scale.min-throughput", 10, "VMs bound per second below which TestScale fails.")
+	scaleMaxP99        = flag.Duration("scale.max-p99", 250*time.Millisecond, "99th percentile latency of any of the plugin's extension points, or of its share-manager lookup, above which TestScale fails.")
+	scaleMaxRequests   = flag.Float64("scale.max-requests-per-pod", 2.5, "API requests of the plugin per VM above which TestScale fails.")
+)
+
+const (
+	scaleNamespace = "scale"
+
+	extensionPointDurationMetric = "scheduler_plugin_longhorn_cosched_extension_point_duration_seconds"
+	lookupDurationMetric         = "scheduler_plugin_longhorn_cosched_lookup_duration_seconds"
+	// lookupLatency is the key of the share-manager lookup in
+	// scaleResult.Latency.
+	lookupLatency = "lookup"
+)
+
+// extensionPoints are the extension points the VMs of TestScale run through.
+var extensionPoints = []string{"PreEnqueue", "PreFilter", "Filter", "PostFilter", "Score", "PostBind"}
+
+// scaleResult is what a TestScale run measured.
+type scaleResult struct {
+	Nodes         int `json:"nodes"`
+	ShareManagers int `json:"shareManagers"`
+	Pods          int `json:"pods"`
+	// Seconds is the time from creating the first VM to the last one being
+	// bound.
+	Seconds float64 `json:"seconds"`
+	// Throughput is VMs bound per second.
+	Throughput float64 `json:"throughput"`
+	// Latency are the percentiles of the plugin's extension points that ran,
+	// by extension point, and of its share-manager lookup, as "lookup". A
+	// percentile beyond a histogram's largest bucket is that bucket's bound.
+	Latency map[string]percentiles `json:"latency"`
+	// Requests are the API requests the plugin's own clients sent, by
+	// "<method> <resource>". The reads of the plugin's listers are served by
+	// the scheduler's informers and are not included.
+	Requests       map[string]int `json:"requests"`
+	RequestsPerPod float64        `json:"requestsPerPod"`
+	QPS            float64        `json:"qps"`
+}
+
+// percentiles of a latency, in seconds.
+type percentiles struct {
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Count uint64  `json:"count"`
+}
+
+// TestScale creates -scale.nodes nodes and -scale.sharemanagers volumes with
+// their ShareManagers spread over them, starts the scheduler, and creates a
+// burst of -scale.pods hard-mode VMs. It checks that every VM is bound to its
+// share-manager node, and measures the throughput, the latency of the
+// plugin's extension points and the API requests the plugin sends. It fails
+// if a measurement crosses its threshold or, with -scale.baseline, is worse
+// than the baseline by more than -scale.tolerance.
+func TestScale(t *testing.T) {
+	if !*scale {
+		t.Skip("the scale test runs with -scale")
+	}
+	ctx := context.Background()
+	pvNames, owners := createScaleCluster(t)
+
+	requests := &requestCounter{counts: map[string]int{}}
+	startSchedulerWithPlugin(t, countingPlugin(requests))
+	before, err := legacyregistry.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests.reset()
+
+	start := time.Now()
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(32)
+	for i := 0; i < *scalePods; i++ {
+		g.Go(func() error {
+			return createScaleVM(gctx, fmt.Sprintf("virt-launcher-%04d", i), pvNames[i%len(pvNames)])
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatalf("creating the VMs: %v", err)
+	}
+	var bound []corev1.Pod
+	err = wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, *scaleTimeout, true, func(ctx context.Context) (bool, error) {
+		list, err := admin.CoreV1().Pods(scaleNamespace).List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName!="})
+		if err != nil {
+			return false, err
+		}
+		bound = list.Items
+		return len(bound) == *scalePods, nil
+	})
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("%d of %d VMs bound after %v: %v", len(bound), *scalePods, elapsed, err)
+	}
+	for _, pod := range bound {
+		pvName := pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName
+		if want := owners[pvName]; pod.Spec.NodeName != want {
+			t.Errorf("VM %s bound to %s, want %s", pod.Name, pod.Spec.NodeName, want)
+		}
+	}
+
+	after, err := legacyregistry.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := scaleResult{
+		Nodes:         *scaleNodes,
+		ShareManagers: len(pvNames),
+		Pods:          *scalePods,
+		Seconds:       elapsed.Seconds(),
+		Throughput:    float64(*scalePods) / elapsed.Seconds(),
+		Latency:       map[string]percentiles{},
+		Requests:      requests.snapshot(),
+	}
+	for _, point := range extensionPoints {
+		if p, ok := latency(before, after, extensionPointDurationMetric, map[string]string{"extension_point": point}); ok {
+			result.Latency[point] = p
+		}
+	}
+	if p, ok := latency(before, after, lookupDurationMetric, nil); ok {
+		result.Latency[lookupLatency] = p
+	}
+	total := 0
+	for _, n := range result.Requests {
+		total += n
+	}
+	result.RequestsPerPod = float64(total) / float64(*scalePods)
+	result.QPS = float64(total) / elapsed.Seconds()
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("results:\n%s", data)
+	if *scaleOut != "" {
+		if err := os.WriteFile(*scaleOut, append(data, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkThresholds(t, result)
+	if *scaleBaseline != "" {
+		checkBaseline(t, result, *scaleBaseline)
+	}
+}
+
+// createScaleCluster creates the nodes, the namespace, and the volumes with
+// their ShareManagers, each running on the next of every
+// nodes/sharemanagers nodes. It returns the PV names and their ShareManagers'
+// nodes, and deletes all of it when the test ends.
+func createScaleCluster(t *testing.T) ([]string, map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	dynClient, err := dynamic.NewForConfig(adminConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shareManagers := dynClient.Resource(shareManagerResource).Namespace(longhorn_cosched.LonghornNamespace)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		all := metav1.ListOptions{LabelSelector: "scale"}
+		_ = admin.CoreV1().Pods(scaleNamespace).DeleteCollection(ctx, metav1.DeleteOptions{GracePeriodSeconds: new(int64)}, metav1.ListOptions{})
+		_ = admin.CoreV1().Namespaces().Delete(ctx, scaleNamespace, metav1.DeleteOptions{})
+		_ = admin.CoreV1().PersistentVolumes().DeleteCollection(ctx, metav1.DeleteOptions{}, all)
+		_ = shareManagers.DeleteCollection(ctx, metav1.DeleteOptions{}, all)
+		_ = admin.CoreV1().Nodes().DeleteCollection(ctx, metav1.DeleteOptions{}, all)
+	})
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(32)
+	nodeNames := make([]string, *scaleNodes)
+	for i := range nodeNames {
+		nodeNames[i] = fmt.Sprintf("scale-node-%04d", i)
+		g.Go(func() error { return createNode(gctx, nodeNames[i], map[string]string{"scale": "true"}) })
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatalf("creating the nodes: %v", err)
+	}
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: scaleNamespace}}
+	if _, err := admin.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	pvNames := make([]string, *scaleShareManagers)
+	owners := make(map[string]string, len(pvNames))
+	var mu sync.Mutex
+	g, gctx = errgroup.WithContext(ctx)
+	g.SetLimit(32)
+	for i := range pvNames {
+		pvNames[i] = fmt.Sprintf("pvc-scale-%04d", i)
+		owner := nodeNames[i*len(nodeNames)/len(pvNames)]
+		g.Go(func() error {
+			if err := createScaleVolume(gctx, shareManagers, pvNames[i], owner); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			owners[pvNames[i]] = owner
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatalf("creating the volumes: %v", err)
+	}
+	return pvNames, owners
+}
+
+// createScaleVolume creates a bound RWX PVC in the scale namespace, named
+// like its Longhorn PV, and the PV's ShareManager running on the node.
+func createScaleVolume(ctx context.Context, shareManagers dynamic.ResourceInterface, pvName, node string) error {
+	size := corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}
+	rwx := []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+	className := storageClassName
+	labels := map[string]string{"scale": "true"}
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName, Labels: labels},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      size,
+			AccessModes:                   rwx,
+			StorageClassName:              storageClassName,
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: longhornDriver, VolumeHandle: pvName},
+			},
+			ClaimRef: &corev1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: scaleNamespace, Name: pvName},
+		},
+	}
+	pv, err := admin.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	pv.Status.Phase = corev1.VolumeBound
+	if _, err := admin.CoreV1().PersistentVolumes().UpdateStatus(ctx, pv, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName, Namespace: scaleNamespace, Annotations: map[string]string{"pv.kubernetes.io/bind-completed": "yes"}},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      rwx,
+			StorageClassName: &className,
+			VolumeName:       pvName,
+			Resources:        corev1.VolumeResourceRequirements{Requests: size},
+		},
+	}
+	if pvc, err = admin.CoreV1().PersistentVolumeClaims(scaleNamespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	pvc.Status = corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound, AccessModes: rwx, Capacity: size}
+	if _, err := admin.CoreV1().PersistentVolumeClaims(scaleNamespace).UpdateStatus(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+
+	sm := &unstructured.Unstructured{}
+	sm.SetAPIVersion(shareManagerResource.GroupVersion().String())
+	sm.SetKind("ShareManager")
+	sm.SetNamespace(longhorn_cosched.LonghornNamespace)
+	sm.SetName(pvName)
+	sm.SetLabels(labels)
+	sm.Object["spec"] = map[string]interface{}{"image": "longhornio/longhorn-share-manager"}
+	if sm, err = shareManagers.Create(ctx, sm, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	sm.Object["status"] = map[string]interface{}{"ownerID": node, "state": "running"}
+	_, err = shareManagers.UpdateStatus(ctx, sm, metav1.UpdateOptions{})
+	return err
+}
+
+// createScaleVM creates a hard-mode VM in the scale namespace mounting the
+// PVC.
+func createScaleVM(ctx context.Context, name, pvcName string) error {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   scaleNamespace,
+			Labels:      map[string]string{"kubevirt.io": "virt-launcher"},
+			Annotations: map[string]string{longhorn_cosched.AnnotationKey: string(longhorn_cosched.ModeHard)},
+		},
+		Spec: corev1.PodSpec{
+			SchedulerName: sampleconfig.DefaultSchedulerName,
+			Containers: []corev1.Container{{
+				Name:      "compute",
+				Image:     "quay.io/kubevirt/virt-launcher",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name:         "disk",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName}},
+			}},
+		},
+	}
+	_, err := admin.CoreV1().Pods(scaleNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	return err
+}
+
+// countingPlugin returns a factory of the plugin whose clients count the
+// requests they send in counter.
+func countingPlugin(counter *requestCounter) frameworkruntime.PluginFactory {
+	return func(ctx context.Context, obj runtime.Object, h framework.Handle) (framework.Plugin, error) {
+		config := rest.CopyConfig(h.KubeConfig())
+		config.Wrap(counter.wrap)
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		dynClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return longhorn_cosched.NewWithClients(clientset, dynClient)(ctx, obj, h)
+	}
+}
+
+// requestCounter counts API requests by method and resource.
+type requestCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *requestCounter) wrap(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		c.mu.Lock()
+		c.counts[req.Method+" "+requestResource(req.URL.Path)]++
+		c.mu.Unlock()
+		return rt.RoundTrip(req)
+	})
+}
+
+func (c *requestCounter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = map[string]int{}
+}
+
+func (c *requestCounter) snapshot() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int, len(c.counts))
+	for k, v := range c.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// requestResource returns the resource an API path names, e.g. "pods" for
+// /api/v1/namespaces/ns/pods/name, or "discovery" for a discovery path.
+func requestResource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return "discovery"
+	}
+	if parts[0] == "namespaces" && len(parts) >= 3 {
+		return parts[2]
+	}
+	return parts[0]
+}
+
+// latency returns the percentiles of the observations of the histogram
+// between two gatherings, summed over the series whose labels match, or
+// false if there are none.
+func latency(before, after []*dto.MetricFamily, name string, labels map[string]string) (percentiles, bool) {
+	delta := histogramSum(after, name, labels)
+	if delta == nil {
+		return percentiles{}, false
+	}
+	if prior := histogramSum(before, name, labels); prior != nil {
+		delta.SampleCount = ptr(delta.GetSampleCount() - prior.GetSampleCount())
+		for i, b := range delta.Bucket {
+			b.CumulativeCount = ptr(b.GetCumulativeCount() - prior.Bucket[i].GetCumulativeCount())
+		}
+	}
+	if delta.GetSampleCount() == 0 {
+		return percentiles{}, false
+	}
+	h := &testutil.Histogram{Histogram: delta}
+	return percentiles{P50: h.Quantile(0.5), P90: h.Quantile(0.9), P99: h.Quantile(0.99), Count: delta.GetSampleCount()}, true
+}
+
+// histogramSum returns the sum of the series of the histogram whose labels
+// match, or nil if there are none.
+func histogramSum(families []*dto.MetricFamily, name string, labels map[string]string) *dto.Histogram {
+	var sum *dto.Histogram
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			h := metric.GetHistogram()
+			if h == nil || !testutil.LabelsMatch(metric, labels) {
+				continue
+			}
+			if sum == nil {
+				sum = &dto.Histogram{SampleCount: ptr(uint64(0))}
+				for _, b := range h.Bucket {
+					sum.Bucket = append(sum.Bucket, &dto.Bucket{UpperBound: b.UpperBound, CumulativeCount: ptr(uint64(0))})
+				}
+			}
+			sum.SampleCount = ptr(sum.GetSampleCount() + h.GetSampleCount())
+			for i, b := range h.Bucket {
+				sum.Bucket[i].CumulativeCount = ptr(sum.Bucket[i].GetCumulativeCount() + b.GetCumulativeCount())
+			}
+		}
+	}
+	return sum
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// checkThresholds fails the test for each measurement crossing its
+// threshold.
+func checkThresholds(t *testing.T, result scaleResult) {
+	t.Helper()
+	if result.Throughput < *scaleMinThroughput {
+		t.Errorf("throughput %.1f VMs/s, want at least %.1f (-scale.min-throughput)", result.Throughput, *scaleMinThroughput)
+	}
+	for _, name := range sortedKeys(result.Latency) {
+		if p99 := result.Latency[name].P99; p99 > scaleMaxP99.Seconds() {
+			t.Errorf("%s p99 latency %v, want at most %v (-scale.max-p99)", name, seconds(p99), *scaleMaxP99)
+		}
+	}
+	if result.RequestsPerPod > *scaleMaxRequests {
+		t.Errorf("plugin sent %.2f API requests per VM (%v), want at most %.2f (-scale.max-requests-per-pod)", result.RequestsPerPod, result.Requests, *scaleMaxRequests)
+	}
+}
+
+// checkBaseline fails the test for each measurement worse than in the
+// baseline results file by more than the tolerance.
+func checkBaseline(t *testing.T, result scaleResult, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var baseline scaleResult
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		t.Fatalf("reading the baseline %s: %v", path, err)
+	}
+	if baseline.Nodes != result.Nodes || baseline.ShareManagers != result.ShareManagers || baseline.Pods != result.Pods {
+		t.Fatalf("baseline ran %d nodes, %d share-managers and %d VMs, this run %d, %d and %d; rerun with the same -scale.* flags",
+			baseline.Nodes, baseline.ShareManagers, baseline.Pods, result.Nodes, result.ShareManagers, result.Pods)
+	}
+	tolerance := *scaleTolerance
+	if min := baseline.Throughput * (1 - tolerance); result.Throughput < min {
+		t.Errorf("throughput %.1f VMs/s regressed from %.1f in the baseline", result.Throughput, baseline.Throughput)
+	}
+	for _, name := range sortedKeys(result.Latency) {
+		was, ok := baseline.Latency[name]
+		if !ok {
+			continue
+		}
+		if p99 := result.Latency[name].P99; p99 > was.P99*(1+tolerance)+scaleLatencySlack.Seconds() {
+			t.Errorf("%s p99 latency %v regressed from %v in the baseline", name, seconds(p99), seconds(was.P99))
+		}
+	}
+	if result.RequestsPerPod > baseline.RequestsPerPod*(1+tolerance) {
+		t.Errorf("plugin API requests per VM %.2f regressed from %.2f in the baseline", result.RequestsPerPod, baseline.RequestsPerPod)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// seconds returns a latency in seconds as a duration, rounded to a
+// microsecond.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s*1e6)) * time.Microsecond
+}
@@ -0,0 +1,51 @@
+//go:build integration
+
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/michaeltrip/kubevirt-scheduler/pkg/plugins/longhorn_cosched"
+)
+
+// TestShareManagerCRD checks that VMs are bound to the node the ShareManager
+// CRD names.
+func TestShareManagerCRD(t *testing.T) {
+	startScheduler(t)
+	namespace := createNamespace(t)
+	pvName := createVolume(t, namespace)
+	createShareManager(t, pvName, "node-2", "running")
+
+	for _, mode := range []longhorn_cosched.Mode{longhorn_cosched.ModeHard, longhorn_cosched.ModeSoft} {
+		t.Run(string(mode), func(t *testing.T) {
+			pod := createVM(t, namespace, "virt-launcher-"+string(mode), mode)
+			if node := waitForBinding(t, pod); node != "node-2" {
+				t.Errorf("pod bound to %s, want node-2", node)
+			}
+		})
+	}
+	pod := createVM(t, namespace, "virt-launcher-events", longhorn_cosched.ModeHard)
+	waitForBinding(t, pod)
+	if note := waitForEvent(t, pod, "CoScheduledWithShareManager"); !strings.Contains(note, "(source: shareManager)") {
+		t.Errorf("event note = %q, want the share-manager found through the CRD", note)
+	}
+}
+
+// TestShareManagerPod checks that VMs are bound to the node of the
+// share-manager pod while the ShareManager has no owner yet.
+func TestShareManagerPod(t *testing.T) {
+	startScheduler(t)
+	namespace := createNamespace(t)
+	pvName := createVolume(t, namespace)
+	createShareManager(t, pvName, "", "")
+	createShareManagerPod(t, pvName, "node-3")
+
+	pod := createVM(t, namespace, "virt-launcher-vm", longhorn_cosched.ModeHard)
+	if node := waitForBinding(t, pod); node != "node-3" {
+		t.Errorf("pod bound to %s, want node-3", node)
+	}
+	if note := waitForEvent(t, pod, "CoScheduledWithShareManager"); !strings.Contains(note, "(source: pod)") {
+		t.Errorf("event note = %q, want the share-manager found through its pod", note)
+	}
+}